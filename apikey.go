@@ -0,0 +1,24 @@
+package tork
+
+import "time"
+
+type APIKey struct {
+	ID   string `json:"id,omitempty"`
+	Name string `json:"name,omitempty"`
+	// Key is the plaintext key. It is only
+	// populated when the key is created.
+	Key       string     `json:"key,omitempty"`
+	KeyHash   string     `json:"-"`
+	CreatedBy string     `json:"createdBy,omitempty"` // the ID of the user the key acts on behalf of
+	CreatedAt *time.Time `json:"createdAt,omitempty"`
+}
+
+func (k *APIKey) Clone() *APIKey {
+	return &APIKey{
+		ID:        k.ID,
+		Name:      k.Name,
+		KeyHash:   k.KeyHash,
+		CreatedBy: k.CreatedBy,
+		CreatedAt: k.CreatedAt,
+	}
+}
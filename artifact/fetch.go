@@ -0,0 +1,134 @@
+package artifact
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/runabol/tork/internal/wildcard"
+)
+
+// the default maximum size of a download
+const DEFAULT_FETCH_MAXSIZE int64 = 1 << 30
+
+// Fetcher is implemented by stores that can retrieve
+// the artifacts they store (e.g. s3:// URLs).
+type Fetcher interface {
+	Fetch(ctx context.Context, url string) (io.ReadCloser, error)
+}
+
+// Fetch opens an artifact of the store.
+func Fetch(ctx context.Context, s Store, rawURL string) (io.ReadCloser, error) {
+	if f, ok := s.(Fetcher); ok {
+		return f.Fetch(ctx, rawURL)
+	}
+	return nil, errors.Errorf("unsupported artifact url: %s", rawURL)
+}
+
+// FetchPolicy restricts the URLs that tasks may download
+// their inputs from and the size of the downloads.
+type FetchPolicy struct {
+	// Hosts are the hosts (e.g. "*.example.com" or
+	// "files.example.com:8080") that http(s) URLs may be
+	// fetched from. If empty, only the artifacts of the
+	// store can be fetched.
+	Hosts []string
+	// MaxSize is the maximum number of bytes read from
+	// an artifact. Unlimited if 0.
+	MaxSize int64
+}
+
+// Fetch opens the artifact at the given URL. http(s) URLs are
+// fetched from the allowed hosts, other URLs from the store.
+func (p FetchPolicy) Fetch(ctx context.Context, s Store, rawURL string) (io.ReadCloser, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid url: %s", rawURL)
+	}
+	var r io.ReadCloser
+	switch u.Scheme {
+	case "http", "https":
+		if !p.allowed(u) {
+			return nil, errors.Errorf("host %s is not allowed", u.Host)
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+		if err != nil {
+			return nil, err
+		}
+		client := &http.Client{
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				if !p.allowed(req.URL) {
+					return errors.Errorf("host %s is not allowed", req.URL.Host)
+				}
+				return nil
+			},
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error fetching %s", rawURL)
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, errors.Errorf("error fetching %s: %d", rawURL, resp.StatusCode)
+		}
+		if p.MaxSize > 0 && resp.ContentLength > p.MaxSize {
+			resp.Body.Close()
+			return nil, errors.Errorf("%s exceeds the maximum size of %d bytes", rawURL, p.MaxSize)
+		}
+		r = resp.Body
+	default:
+		r, err = Fetch(ctx, s, rawURL)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if p.MaxSize > 0 {
+		return &limitedReader{rc: r, url: rawURL, remaining: p.MaxSize, max: p.MaxSize}, nil
+	}
+	return r, nil
+}
+
+func (p FetchPolicy) allowed(u *url.URL) bool {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return false
+	}
+	for _, h := range p.Hosts {
+		if wildcard.Match(strings.ToLower(h), strings.ToLower(u.Host)) {
+			return true
+		}
+	}
+	return false
+}
+
+// limitedReader fails the read of an
+// artifact exceeding the maximum size.
+type limitedReader struct {
+	rc        io.ReadCloser
+	url       string
+	remaining int64
+	max       int64
+}
+
+func (l *limitedReader) Read(b []byte) (int, error) {
+	if l.remaining < 0 {
+		return 0, errors.Errorf("%s exceeds the maximum size of %d bytes", l.url, l.max)
+	}
+	// read one more byte than allowed to
+	// tell whether the limit is exceeded
+	if int64(len(b)) > l.remaining+1 {
+		b = b[:l.remaining+1]
+	}
+	n, err := l.rc.Read(b)
+	l.remaining -= int64(n)
+	if l.remaining < 0 {
+		return n + int(l.remaining), errors.Errorf("%s exceeds the maximum size of %d bytes", l.url, l.max)
+	}
+	return n, err
+}
+
+func (l *limitedReader) Close() error {
+	return l.rc.Close()
+}
@@ -0,0 +1,94 @@
+package artifact_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/runabol/tork/artifact"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFetchHTTP(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/data.csv":
+			_, _ = w.Write([]byte("a,b,c"))
+		case "/redirect":
+			http.Redirect(w, r, "http://example.com/data.csv", http.StatusFound)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+	p := artifact.FetchPolicy{Hosts: []string{strings.TrimPrefix(srv.URL, "http://")}}
+
+	r, err := p.Fetch(context.Background(), nil, srv.URL+"/data.csv")
+	assert.NoError(t, err)
+	defer r.Close()
+	b, err := io.ReadAll(r)
+	assert.NoError(t, err)
+	assert.Equal(t, "a,b,c", string(b))
+
+	_, err = p.Fetch(context.Background(), nil, srv.URL+"/other.csv")
+	assert.Error(t, err)
+
+	// redirects to other hosts are not followed
+	_, err = p.Fetch(context.Background(), nil, srv.URL+"/redirect")
+	assert.ErrorContains(t, err, "host example.com is not allowed")
+
+	// hosts must be allowed
+	_, err = artifact.FetchPolicy{}.Fetch(context.Background(), nil, srv.URL+"/data.csv")
+	assert.ErrorContains(t, err, "is not allowed")
+}
+
+func TestFetchMaxSize(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// stream the response to omit its length
+		w.(http.Flusher).Flush()
+		_, _ = w.Write([]byte("0123456789"))
+	}))
+	defer srv.Close()
+	host := strings.TrimPrefix(srv.URL, "http://")
+
+	r, err := artifact.FetchPolicy{Hosts: []string{host}, MaxSize: 5}.Fetch(context.Background(), nil, srv.URL)
+	assert.NoError(t, err)
+	defer r.Close()
+	_, err = io.ReadAll(r)
+	assert.ErrorContains(t, err, "exceeds the maximum size of 5 bytes")
+
+	r, err = artifact.FetchPolicy{Hosts: []string{host}, MaxSize: 10}.Fetch(context.Background(), nil, srv.URL)
+	assert.NoError(t, err)
+	defer r.Close()
+	b, err := io.ReadAll(r)
+	assert.NoError(t, err)
+	assert.Equal(t, "0123456789", string(b))
+}
+
+func TestFetchFile(t *testing.T) {
+	s, err := artifact.NewFileStore(t.TempDir())
+	assert.NoError(t, err)
+	u, err := s.Put(context.Background(), "job-1/task-1/out.txt", strings.NewReader("hello"))
+	assert.NoError(t, err)
+
+	r, err := artifact.FetchPolicy{}.Fetch(context.Background(), s, u)
+	assert.NoError(t, err)
+	defer r.Close()
+	b, err := io.ReadAll(r)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", string(b))
+
+	// only the files of the store can be fetched
+	_, err = artifact.FetchPolicy{}.Fetch(context.Background(), s, "file:///etc/passwd")
+	assert.Error(t, err)
+	_, err = artifact.FetchPolicy{}.Fetch(context.Background(), nil, "file:///etc/passwd")
+	assert.Error(t, err)
+}
+
+func TestFetchUnsupported(t *testing.T) {
+	_, err := artifact.Fetch(context.Background(), nil, "s3://bucket/key")
+	assert.Error(t, err)
+}
@@ -0,0 +1,65 @@
+package artifact
+
+import (
+	"context"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// FileStore stores artifacts in a directory, typically
+// a filesystem shared by the worker nodes.
+type FileStore struct {
+	dir string
+}
+
+func NewFileStore(dir string) (*FileStore, error) {
+	if dir == "" {
+		return nil, errors.New("must provide an artifacts directory")
+	}
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, errors.Wrapf(err, "error creating artifacts directory %s", dir)
+	}
+	return &FileStore{dir: dir}, nil
+}
+
+func (s *FileStore) Put(ctx context.Context, key string, r io.Reader) (string, error) {
+	p := filepath.Join(s.dir, filepath.FromSlash(key))
+	if !strings.HasPrefix(p, s.dir+string(filepath.Separator)) {
+		return "", errors.Errorf("invalid artifact key: %s", key)
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return "", errors.Wrapf(err, "error creating artifact directory")
+	}
+	f, err := os.Create(p)
+	if err != nil {
+		return "", errors.Wrapf(err, "error creating artifact %s", key)
+	}
+	defer f.Close()
+	if _, err := io.Copy(f, r); err != nil {
+		return "", errors.Wrapf(err, "error writing artifact %s", key)
+	}
+	u := url.URL{Scheme: "file", Path: filepath.ToSlash(p)}
+	return u.String(), nil
+}
+
+// Fetch opens an artifact stored in the store's directory.
+func (s *FileStore) Fetch(ctx context.Context, rawURL string) (io.ReadCloser, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid url: %s", rawURL)
+	}
+	p := filepath.Clean(filepath.FromSlash(u.Path))
+	if u.Scheme != "file" || u.Host != "" || !strings.HasPrefix(p, s.dir+string(filepath.Separator)) {
+		return nil, errors.Errorf("artifact %s is not in %s", rawURL, s.dir)
+	}
+	return os.Open(p)
+}
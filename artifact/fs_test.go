@@ -0,0 +1,44 @@
+package artifact_test
+
+import (
+	"context"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/runabol/tork"
+	"github.com/runabol/tork/artifact"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileStorePut(t *testing.T) {
+	s, err := artifact.NewFileStore(t.TempDir())
+	assert.NoError(t, err)
+
+	key := artifact.Key(&tork.Task{ID: "task-1", JobID: "job-1"}, "out.txt")
+	assert.Equal(t, "job-1/task-1/out.txt", key)
+
+	u, err := s.Put(context.Background(), key, strings.NewReader("hello world"))
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(u, "file://"))
+	assert.True(t, strings.HasSuffix(u, "/job-1/task-1/out.txt"))
+
+	pu, err := url.Parse(u)
+	assert.NoError(t, err)
+	b, err := os.ReadFile(pu.Path)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello world", string(b))
+}
+
+func TestFileStorePutInvalidKey(t *testing.T) {
+	s, err := artifact.NewFileStore(t.TempDir())
+	assert.NoError(t, err)
+	_, err = s.Put(context.Background(), "../../etc/passwd", strings.NewReader("bad"))
+	assert.Error(t, err)
+}
+
+func TestNewFileStoreNoDir(t *testing.T) {
+	_, err := artifact.NewFileStore("")
+	assert.Error(t, err)
+}
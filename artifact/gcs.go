@@ -0,0 +1,126 @@
+package artifact
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/runabol/tork/internal/gcp"
+)
+
+const (
+	GCS_DEFAULT_ENDPOINT = "https://storage.googleapis.com"
+)
+
+// GCSStore stores artifacts in a Google Cloud Storage
+// bucket, using the JSON API.
+type GCSStore struct {
+	bucket      string
+	endpoint    string
+	tokenSource func(ctx context.Context) (string, error)
+	client      *http.Client
+}
+
+type GCSOption = func(s *GCSStore)
+
+// WithGCSEndpoint sets the URL of the Cloud Storage API
+// (e.g. of an emulator). Default: https://storage.googleapis.com
+func WithGCSEndpoint(endpoint string) GCSOption {
+	return func(s *GCSStore) {
+		s.endpoint = strings.TrimSuffix(endpoint, "/")
+	}
+}
+
+// WithGCSTokenSource sets the source of the access tokens used
+// to authenticate with Cloud Storage. A nil source sends the
+// requests unauthenticated. Default: the token of the instance's
+// service account, fetched from the metadata server.
+func WithGCSTokenSource(ts func(ctx context.Context) (string, error)) GCSOption {
+	return func(s *GCSStore) {
+		s.tokenSource = ts
+	}
+}
+
+func NewGCSStore(bucket string, opts ...GCSOption) (*GCSStore, error) {
+	if bucket == "" {
+		return nil, errors.New("must provide a GCS bucket")
+	}
+	client := &http.Client{Timeout: time.Hour}
+	s := &GCSStore{
+		bucket:      bucket,
+		endpoint:    GCS_DEFAULT_ENDPOINT,
+		tokenSource: gcp.MetadataTokenSource(client),
+		client:      client,
+	}
+	for _, o := range opts {
+		o(s)
+	}
+	return s, nil
+}
+
+func (s *GCSStore) Put(ctx context.Context, key string, r io.Reader) (string, error) {
+	if err := validateKey(key); err != nil {
+		return "", err
+	}
+	u := fmt.Sprintf("%s/upload/storage/v1/b/%s/o?uploadType=media&name=%s",
+		s.endpoint, url.PathEscape(s.bucket), url.QueryEscape(key))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, r)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	resp, err := s.do(req)
+	if err != nil {
+		return "", errors.Wrapf(err, "error uploading artifact %s", key)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", errors.Errorf("error uploading artifact %s: %s", key, resp.Status)
+	}
+	return (&url.URL{Scheme: "gs", Host: s.bucket, Path: "/" + key}).String(), nil
+}
+
+// Fetch opens an artifact stored in the store's bucket.
+func (s *GCSStore) Fetch(ctx context.Context, rawURL string) (io.ReadCloser, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid url: %s", rawURL)
+	}
+	if u.Scheme != "gs" || u.Host != s.bucket {
+		return nil, errors.Errorf("artifact %s is not in bucket %s", rawURL, s.bucket)
+	}
+	key := strings.TrimPrefix(u.Path, "/")
+	if err := validateKey(key); err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s/storage/v1/b/%s/o/%s?alt=media",
+		s.endpoint, url.PathEscape(s.bucket), url.PathEscape(key)), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.do(req)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error fetching %s", rawURL)
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, errors.Errorf("error fetching %s: %s", rawURL, resp.Status)
+	}
+	return resp.Body, nil
+}
+
+func (s *GCSStore) do(req *http.Request) (*http.Response, error) {
+	if s.tokenSource != nil {
+		token, err := s.tokenSource(req.Context())
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return s.client.Do(req)
+}
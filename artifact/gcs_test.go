@@ -0,0 +1,63 @@
+package artifact_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/runabol/tork/artifact"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGCSPutFetch(t *testing.T) {
+	objects := map[string]string{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer some-token", r.Header.Get("Authorization"))
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/upload/storage/v1/b/some-bucket/o":
+			assert.Equal(t, "media", r.URL.Query().Get("uploadType"))
+			b, err := io.ReadAll(r.Body)
+			assert.NoError(t, err)
+			objects[r.URL.Query().Get("name")] = string(b)
+			_, _ = w.Write([]byte(`{}`))
+		case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/storage/v1/b/some-bucket/o/"):
+			assert.Equal(t, "media", r.URL.Query().Get("alt"))
+			o, ok := objects[strings.TrimPrefix(r.URL.Path, "/storage/v1/b/some-bucket/o/")]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			_, _ = w.Write([]byte(o))
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer srv.Close()
+
+	s, err := artifact.NewGCSStore("some-bucket",
+		artifact.WithGCSEndpoint(srv.URL),
+		artifact.WithGCSTokenSource(func(ctx context.Context) (string, error) {
+			return "some-token", nil
+		}))
+	assert.NoError(t, err)
+	ctx := context.Background()
+	u, err := s.Put(ctx, "job-1/task-1/out.txt", strings.NewReader("hello world"))
+	assert.NoError(t, err)
+	assert.Equal(t, "gs://some-bucket/job-1/task-1/out.txt", u)
+	assert.Equal(t, "hello world", objects["job-1/task-1/out.txt"])
+
+	r, err := artifact.Fetch(ctx, s, u)
+	assert.NoError(t, err)
+	defer r.Close()
+	b, err := io.ReadAll(r)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello world", string(b))
+
+	_, err = s.Fetch(ctx, "gs://some-bucket/job-1/task-1/other.txt")
+	assert.Error(t, err)
+	_, err = s.Fetch(ctx, "gs://other-bucket/job-1/task-1/out.txt")
+	assert.Error(t, err)
+}
@@ -0,0 +1,193 @@
+package artifact
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/runabol/tork/internal/aws"
+)
+
+const (
+	S3_DEFAULT_REGION = "us-east-1"
+)
+
+// S3Store stores artifacts in an S3 bucket (or a service
+// compatible with the S3 API, such as MinIO). Requests are
+// signed with AWS Signature Version 4. Artifacts are uploaded
+// with a single PUT, which limits them to 5GB.
+type S3Store struct {
+	bucket       string
+	region       string
+	endpoint     string
+	pathStyle    bool
+	accessKey    string
+	secretKey    string
+	sessionToken string
+	client       *http.Client
+}
+
+type S3Option = func(s *S3Store)
+
+// WithS3Region sets the region of the bucket.
+// Default: $AWS_REGION or us-east-1
+func WithS3Region(region string) S3Option {
+	return func(s *S3Store) {
+		s.region = region
+	}
+}
+
+// WithS3Endpoint sets the URL of an S3 compatible service
+// (e.g. http://localhost:9000). Buckets are addressed
+// path-style on custom endpoints.
+func WithS3Endpoint(endpoint string) S3Option {
+	return func(s *S3Store) {
+		s.endpoint = strings.TrimSuffix(endpoint, "/")
+		s.pathStyle = true
+	}
+}
+
+// WithS3Credentials sets the access keys used to sign the
+// requests. Default: $AWS_ACCESS_KEY_ID, $AWS_SECRET_ACCESS_KEY
+// and $AWS_SESSION_TOKEN
+func WithS3Credentials(accessKey, secretKey, sessionToken string) S3Option {
+	return func(s *S3Store) {
+		s.accessKey = accessKey
+		s.secretKey = secretKey
+		s.sessionToken = sessionToken
+	}
+}
+
+func NewS3Store(bucket string, opts ...S3Option) (*S3Store, error) {
+	if bucket == "" {
+		return nil, errors.New("must provide an S3 bucket")
+	}
+	s := &S3Store{
+		bucket:       bucket,
+		region:       os.Getenv("AWS_REGION"),
+		accessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+		secretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		sessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+		client:       &http.Client{Timeout: time.Hour},
+	}
+	for _, o := range opts {
+		o(s)
+	}
+	if s.region == "" {
+		s.region = S3_DEFAULT_REGION
+	}
+	if s.accessKey == "" || s.secretKey == "" {
+		return nil, errors.New("must provide the S3 access key and secret key")
+	}
+	if s.endpoint == "" {
+		s.endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", s.region)
+	}
+	return s, nil
+}
+
+func (s *S3Store) Put(ctx context.Context, key string, r io.Reader) (string, error) {
+	if err := validateKey(key); err != nil {
+		return "", err
+	}
+	// S3 requires the length of the object up front, and
+	// its hash is part of the signature, so the artifact
+	// is spooled to a temporary file first.
+	f, err := os.CreateTemp("", "tork-artifact-*")
+	if err != nil {
+		return "", errors.Wrapf(err, "error creating temporary file")
+	}
+	defer func() {
+		f.Close()
+		os.Remove(f.Name())
+	}()
+	h := sha256.New()
+	size, err := io.Copy(io.MultiWriter(f, h), r)
+	if err != nil {
+		return "", errors.Wrapf(err, "error reading artifact %s", key)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, s.objectURL(key), f)
+	if err != nil {
+		return "", err
+	}
+	req.ContentLength = size
+	req.Header.Set("Content-Type", "application/octet-stream")
+	s.sign(req, hex.EncodeToString(h.Sum(nil)), time.Now().UTC())
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return "", errors.Wrapf(err, "error uploading artifact %s", key)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", errors.Errorf("error uploading artifact %s: %s", key, s3Error(resp))
+	}
+	return (&url.URL{Scheme: "s3", Host: s.bucket, Path: "/" + key}).String(), nil
+}
+
+// Fetch opens an artifact stored in the store's bucket.
+func (s *S3Store) Fetch(ctx context.Context, rawURL string) (io.ReadCloser, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid url: %s", rawURL)
+	}
+	if u.Scheme != "s3" || u.Host != s.bucket {
+		return nil, errors.Errorf("artifact %s is not in bucket %s", rawURL, s.bucket)
+	}
+	key := strings.TrimPrefix(u.Path, "/")
+	if err := validateKey(key); err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.objectURL(key), nil)
+	if err != nil {
+		return nil, err
+	}
+	s.sign(req, aws.EmptyPayloadHash, time.Now().UTC())
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error fetching %s", rawURL)
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		return nil, errors.Errorf("error fetching %s: %s", rawURL, s3Error(resp))
+	}
+	return resp.Body, nil
+}
+
+func (s *S3Store) objectURL(key string) string {
+	if s.pathStyle {
+		return fmt.Sprintf("%s/%s/%s", s.endpoint, s.bucket, aws.URIEncode(key, false))
+	}
+	u, _ := url.Parse(s.endpoint)
+	return fmt.Sprintf("%s://%s.%s/%s", u.Scheme, s.bucket, u.Host, aws.URIEncode(key, false))
+}
+
+// sign signs the request with AWS Signature Version 4. The host
+// and the headers set on the request so far are signed.
+func (s *S3Store) sign(req *http.Request, payloadHash string, now time.Time) {
+	aws.Sign(req, aws.Credentials{
+		AccessKey:    s.accessKey,
+		SecretKey:    s.secretKey,
+		SessionToken: s.sessionToken,
+	}, s.region, "s3", payloadHash, now)
+}
+
+// s3Error extracts the error code of an S3 error response.
+func s3Error(resp *http.Response) string {
+	b, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if i := strings.Index(string(b), "<Code>"); i >= 0 {
+		if j := strings.Index(string(b[i:]), "</Code>"); j >= 0 {
+			return fmt.Sprintf("%s (%s)", resp.Status, string(b[i+len("<Code>"):i+j]))
+		}
+	}
+	return resp.Status
+}
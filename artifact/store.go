@@ -0,0 +1,43 @@
+package artifact
+
+import (
+	"context"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/runabol/tork"
+)
+
+// Store persists the artifacts produced by tasks
+// (on a shared filesystem, S3 or GCS).
+type Store interface {
+	// Put stores the contents of r under the given key and
+	// returns the URL the artifact can be retrieved from.
+	Put(ctx context.Context, key string, r io.Reader) (string, error)
+}
+
+// Key returns the key the named artifact of the task is
+// stored under, so that artifacts of different tasks
+// never collide.
+func Key(t *tork.Task, name string) string {
+	return path.Join(t.JobID, t.ID, name)
+}
+
+// ResultKey returns the key the full result of the task is
+// spilled to. Results are stored apart from the artifacts of
+// the tasks so that they never collide.
+func ResultKey(t *tork.Task) string {
+	return path.Join("results", t.JobID, t.ID)
+}
+
+// validateKey rejects the keys which could
+// escape the prefix they are stored under.
+func validateKey(key string) error {
+	if key == "" || path.IsAbs(key) || path.Clean(key) != key ||
+		key == ".." || strings.HasPrefix(key, "../") {
+		return errors.Errorf("invalid artifact key: %s", key)
+	}
+	return nil
+}
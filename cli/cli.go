@@ -33,7 +33,10 @@ func (c *CLI) Run() error {
 }
 
 func (c *CLI) before(ctx *ucli.Context) error {
-	displayBanner()
+	// client and config commands keep their output machine-readable
+	if !isClientCmd(ctx.Args().First()) {
+		displayBanner()
+	}
 
 	if err := logging.SetupLogging(); err != nil {
 		return err
@@ -41,10 +44,19 @@ func (c *CLI) before(ctx *ucli.Context) error {
 	return nil
 }
 
+func isClientCmd(name string) bool {
+	return name == "job" || name == "logs" || name == "config" || name == "dead-letter" || name == "key"
+}
+
 func (c *CLI) commands() []*ucli.Command {
 	return []*ucli.Command{
 		c.runCmd(),
 		c.migrationCmd(),
 		c.healthCmd(),
+		c.jobCmd(),
+		c.logsCmd(),
+		c.deadLetterCmd(),
+		c.keyCmd(),
+		c.configCmd(),
 	}
 }
@@ -0,0 +1,202 @@
+package cli
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/runabol/tork/conf"
+	ucli "github.com/urfave/cli/v2"
+)
+
+// client is a minimal client of the coordinator's REST API.
+type client struct {
+	endpoint string
+	token    string
+	username string
+	password string
+	http     *http.Client
+}
+
+// apiError is an error response of the API.
+type apiError struct {
+	method  string
+	path    string
+	status  int
+	message string
+}
+
+func (e *apiError) Error() string {
+	return fmt.Sprintf("%s %s failed (%d): %s", e.method, e.path, e.status, e.message)
+}
+
+// isNotFound reports whether the API responded
+// that the requested resource doesn't exist.
+func isNotFound(err error) bool {
+	var aerr *apiError
+	return errors.As(err, &aerr) && aerr.status == http.StatusNotFound
+}
+
+// sseEvent is an event received from a
+// text/event-stream response.
+type sseEvent struct {
+	name string
+	data []byte
+}
+
+func clientFlags() []ucli.Flag {
+	return []ucli.Flag{
+		&ucli.StringFlag{
+			Name:  "endpoint",
+			Usage: "the coordinator's API endpoint",
+			Value: conf.StringDefault("client.endpoint", conf.StringDefault("endpoint", "http://localhost:8000")),
+		},
+		&ucli.StringFlag{
+			Name:  "token",
+			Usage: "the API key or JWT used to authenticate",
+			Value: conf.String("client.token"),
+		},
+		&ucli.StringFlag{
+			Name:  "username",
+			Usage: "the username used for basic authentication",
+			Value: conf.String("client.username"),
+		},
+		&ucli.StringFlag{
+			Name:  "password",
+			Usage: "the password used for basic authentication",
+			Value: conf.String("client.password"),
+		},
+		&ucli.StringFlag{
+			Name:    "output",
+			Aliases: []string{"o"},
+			Usage:   "the output format: table or json",
+			Value:   "table",
+		},
+	}
+}
+
+func newClient(ctx *ucli.Context) *client {
+	return &client{
+		endpoint: strings.TrimSuffix(ctx.String("endpoint"), "/"),
+		token:    ctx.String("token"),
+		username: ctx.String("username"),
+		password: ctx.String("password"),
+		http:     &http.Client{},
+	}
+}
+
+func (c *client) request(ctx context.Context, method, path string, body io.Reader, headers map[string]string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.endpoint+path, body)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error building request")
+	}
+	for k, v := range headers {
+		if v != "" {
+			req.Header.Set(k, v)
+		}
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	} else if c.username != "" {
+		req.SetBasicAuth(c.username, c.password)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error calling %s", c.endpoint)
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		msg := struct {
+			Message string `json:"message"`
+		}{}
+		raw, _ := io.ReadAll(resp.Body)
+		if err := json.Unmarshal(raw, &msg); err != nil || msg.Message == "" {
+			msg.Message = strings.TrimSpace(string(raw))
+		}
+		return nil, &apiError{method: method, path: path, status: resp.StatusCode, message: msg.Message}
+	}
+	return resp, nil
+}
+
+// do calls the API and unmarshals the JSON response into v.
+func (c *client) do(ctx context.Context, method, path string, body io.Reader, headers map[string]string, v any) error {
+	resp, err := c.request(ctx, method, path, body, headers)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if v == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return errors.Wrapf(err, "error unmarshalling response")
+	}
+	return nil
+}
+
+// stream calls an API endpoint producing server-sent
+// events and hands each event to the handler.
+func (c *client) stream(ctx context.Context, path string, handler func(ev sseEvent) error) error {
+	resp, err := c.request(ctx, http.MethodGet, path, nil, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	ev := sseEvent{}
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "":
+			if ev.name != "" || len(ev.data) > 0 {
+				if err := handler(ev); err != nil {
+					return err
+				}
+			}
+			ev = sseEvent{}
+		case strings.HasPrefix(line, "event:"):
+			ev.name = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+		case strings.HasPrefix(line, "data:"):
+			if len(ev.data) > 0 {
+				ev.data = append(ev.data, '\n')
+			}
+			ev.data = append(ev.data, strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " ")...)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return errors.Wrapf(err, "error reading events")
+	}
+	return nil
+}
+
+func printJSON(w io.Writer, v any) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		return errors.Wrapf(err, "error marshalling output")
+	}
+	return nil
+}
+
+func validateOutput(ctx *ucli.Context) error {
+	switch ctx.String("output") {
+	case "table", "json":
+		return nil
+	default:
+		return errors.Errorf("invalid output format: %s. expecting table or json", ctx.String("output"))
+	}
+}
+
+func isJSON(ctx *ucli.Context) bool {
+	return ctx.String("output") == "json"
+}
+
+func printf(w io.Writer, format string, args ...any) {
+	_, _ = fmt.Fprintf(w, format, args...)
+}
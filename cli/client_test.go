@@ -0,0 +1,76 @@
+package cli
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClientDo(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/ok":
+			assert.Equal(t, "Bearer some-token", r.Header.Get("Authorization"))
+			_, _ = w.Write([]byte(`{"status":"UP"}`))
+		case "/missing":
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"message":"job not found"}`))
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+			_, _ = w.Write([]byte("boom"))
+		}
+	}))
+	defer srv.Close()
+
+	c := &client{endpoint: srv.URL, token: "some-token", http: &http.Client{}}
+	v := struct {
+		Status string `json:"status"`
+	}{}
+	assert.NoError(t, c.do(context.Background(), http.MethodGet, "/ok", nil, nil, &v))
+	assert.Equal(t, "UP", v.Status)
+
+	err := c.do(context.Background(), http.MethodGet, "/missing", nil, nil, nil)
+	assert.EqualError(t, err, "GET /missing failed (404): job not found")
+	assert.True(t, isNotFound(err))
+
+	err = c.do(context.Background(), http.MethodGet, "/broken", nil, nil, nil)
+	assert.EqualError(t, err, "GET /broken failed (500): boom")
+	assert.False(t, isNotFound(err))
+}
+
+func TestClientBasicAuth(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		username, password, ok := r.BasicAuth()
+		assert.True(t, ok)
+		assert.Equal(t, "someuser", username)
+		assert.Equal(t, "secret", password)
+		_, _ = w.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+
+	c := &client{endpoint: srv.URL, username: "someuser", password: "secret", http: &http.Client{}}
+	assert.NoError(t, c.do(context.Background(), http.MethodGet, "/", nil, nil, nil))
+}
+
+func TestClientStream(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = w.Write([]byte("event: job\ndata: {\"id\":\"1234\"}\n\n: keep-alive\n\nevent: log\ndata: line 1\ndata: line 2\n\n"))
+	}))
+	defer srv.Close()
+
+	c := &client{endpoint: srv.URL, http: &http.Client{}}
+	events := make([]sseEvent, 0)
+	err := c.stream(context.Background(), "/jobs/1234/events", func(ev sseEvent) error {
+		events = append(events, ev)
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []sseEvent{
+		{name: "job", data: []byte(`{"id":"1234"}`)},
+		{name: "log", data: []byte("line 1\nline 2")},
+	}, events)
+}
@@ -0,0 +1,55 @@
+package cli
+
+import (
+	"github.com/pkg/errors"
+	"github.com/runabol/tork/conf"
+	"github.com/runabol/tork/engine"
+	ucli "github.com/urfave/cli/v2"
+)
+
+func (c *CLI) configCmd() *ucli.Command {
+	return &ucli.Command{
+		Name:  "config",
+		Usage: "Inspect Tork's config",
+		Subcommands: []*ucli.Command{
+			{
+				Name:      "validate",
+				Usage:     "Validate the config",
+				UsageText: "tork config validate [options] [config.toml|config.yaml]",
+				Description: "Checks the config file, merged with any TORK_ env vars, and prints\n" +
+					"every problem found. Without a file it checks the config Tork would\n" +
+					"load on startup.",
+				Flags: []ucli.Flag{
+					&ucli.StringFlag{
+						Name:  "mode",
+						Usage: "only check the sections used by this mode: standalone, coordinator or worker",
+					},
+				},
+				Action: validateConfig,
+			},
+		},
+	}
+}
+
+func validateConfig(ctx *ucli.Context) error {
+	mode := engine.Mode(ctx.String("mode"))
+	switch mode {
+	case "", engine.ModeStandalone, engine.ModeCoordinator, engine.ModeWorker:
+	default:
+		return errors.Errorf("invalid mode: %s. expecting standalone, coordinator or worker", mode)
+	}
+	if f := ctx.Args().First(); f != "" {
+		if err := conf.LoadConfigFile(f); err != nil {
+			return err
+		}
+	}
+	if err := engine.ValidateConfig(mode); err != nil {
+		return err
+	}
+	if conf.Source() == "" {
+		printf(ctx.App.Writer, "no config file found, the defaults and env vars are valid\n")
+	} else {
+		printf(ctx.App.Writer, "%s is valid\n", conf.Source())
+	}
+	return nil
+}
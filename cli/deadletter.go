@@ -0,0 +1,151 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/runabol/tork"
+	ucli "github.com/urfave/cli/v2"
+)
+
+func (c *CLI) deadLetterCmd() *ucli.Command {
+	return &ucli.Command{
+		Name:  "dead-letter",
+		Usage: "Inspect and requeue task messages that exhausted their delivery attempts",
+		Subcommands: []*ucli.Command{
+			{
+				Name:      "ls",
+				Usage:     "List the dead-lettered task messages",
+				UsageText: "tork dead-letter ls [options]",
+				Flags: append(clientFlags(),
+					&ucli.IntFlag{
+						Name:  "page",
+						Usage: "the page number",
+						Value: 1,
+					},
+				),
+				Action: listDeadLetters,
+			},
+			{
+				Name:      "show",
+				Usage:     "Show a dead-lettered task message",
+				UsageText: "tork dead-letter show [options] id",
+				Flags:     clientFlags(),
+				Action:    showDeadLetter,
+			},
+			{
+				Name:      "requeue",
+				Usage:     "Publish a dead-lettered task message back to its queue",
+				UsageText: "tork dead-letter requeue [options] id",
+				Flags:     clientFlags(),
+				Action:    requeueDeadLetter,
+			},
+			{
+				Name:      "rm",
+				Usage:     "Discard a dead-lettered task message",
+				UsageText: "tork dead-letter rm [options] id",
+				Flags:     clientFlags(),
+				Action:    deleteDeadLetter,
+			},
+		},
+	}
+}
+
+func listDeadLetters(ctx *ucli.Context) error {
+	if err := validateOutput(ctx); err != nil {
+		return err
+	}
+	page := struct {
+		Items      []*tork.DeadLetter `json:"items"`
+		Number     int                `json:"number"`
+		TotalPages int                `json:"totalPages"`
+	}{}
+	path := fmt.Sprintf("/dead-letters?page=%d", ctx.Int("page"))
+	if err := newClient(ctx).do(ctx.Context, http.MethodGet, path, nil, nil, &page); err != nil {
+		return err
+	}
+	if isJSON(ctx) {
+		return printJSON(ctx.App.Writer, page.Items)
+	}
+	if err := writeDeadLettersTable(ctx.App.Writer, page.Items); err != nil {
+		return err
+	}
+	if page.TotalPages > 1 {
+		printf(ctx.App.Writer, "page %d of %d\n", page.Number, page.TotalPages)
+	}
+	return nil
+}
+
+func showDeadLetter(ctx *ucli.Context) error {
+	if err := validateOutput(ctx); err != nil {
+		return err
+	}
+	id := ctx.Args().First()
+	if id == "" {
+		return errors.New("missing required argument: dead letter id")
+	}
+	dl := &tork.DeadLetter{}
+	if err := newClient(ctx).do(ctx.Context, http.MethodGet, fmt.Sprintf("/dead-letters/%s", id), nil, nil, dl); err != nil {
+		return err
+	}
+	if isJSON(ctx) {
+		return printJSON(ctx.App.Writer, dl)
+	}
+	return writeDeadLettersTable(ctx.App.Writer, []*tork.DeadLetter{dl})
+}
+
+func requeueDeadLetter(ctx *ucli.Context) error {
+	id := ctx.Args().First()
+	if id == "" {
+		return errors.New("missing required argument: dead letter id")
+	}
+	if err := newClient(ctx).do(ctx.Context, http.MethodPut, fmt.Sprintf("/dead-letters/%s/requeue", id), nil, nil, nil); err != nil {
+		return err
+	}
+	printf(ctx.App.Writer, "dead letter %s requeued\n", id)
+	return nil
+}
+
+func deleteDeadLetter(ctx *ucli.Context) error {
+	id := ctx.Args().First()
+	if id == "" {
+		return errors.New("missing required argument: dead letter id")
+	}
+	if err := newClient(ctx).do(ctx.Context, http.MethodDelete, fmt.Sprintf("/dead-letters/%s", id), nil, nil, nil); err != nil {
+		return err
+	}
+	printf(ctx.App.Writer, "dead letter %s deleted\n", id)
+	return nil
+}
+
+func writeDeadLettersTable(out io.Writer, dls []*tork.DeadLetter) error {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	printf(w, "ID\tQUEUE\tTASK\tATTEMPTS\tCREATED\tERROR\n")
+	for _, dl := range dls {
+		var taskID string
+		if dl.Task != nil {
+			taskID = dl.Task.ID
+		}
+		var created string
+		if dl.CreatedAt != nil {
+			created = dl.CreatedAt.Local().Format(time.DateTime)
+		}
+		printf(w, "%s\t%s\t%s\t%d\t%s\t%s\n",
+			dl.ID, dl.Queue, taskID, dl.Attempts, created, firstLine(dl.Error))
+	}
+	return w.Flush()
+}
+
+// firstLine keeps multi-line
+// errors from breaking the table.
+func firstLine(s string) string {
+	if i := strings.IndexByte(s, '\n'); i >= 0 {
+		return s[:i]
+	}
+	return s
+}
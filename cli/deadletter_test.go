@@ -0,0 +1,67 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/runabol/tork"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeadLetterLs(t *testing.T) {
+	now := time.Now().UTC()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/dead-letters", r.URL.Path)
+		assert.Equal(t, "2", r.URL.Query().Get("page"))
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"items": []*tork.DeadLetter{{
+				ID:        "1234",
+				Queue:     "default",
+				Task:      &tork.Task{ID: "5678"},
+				Error:     "bad things\nhappened",
+				Attempts:  3,
+				CreatedAt: &now,
+			}},
+			"number":     2,
+			"totalPages": 2,
+		})
+	}))
+	defer srv.Close()
+
+	c := New()
+	out := &bytes.Buffer{}
+	c.app.Writer = out
+	err := c.app.Run([]string{"tork", "dead-letter", "ls", "--endpoint", srv.URL, "--page", "2"})
+	assert.NoError(t, err)
+	assert.Contains(t, out.String(), "1234")
+	assert.Contains(t, out.String(), "5678")
+	assert.Contains(t, out.String(), "bad things")
+	assert.NotContains(t, out.String(), "happened")
+	assert.Contains(t, out.String(), "page 2 of 2")
+}
+
+func TestDeadLetterRequeue(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/dead-letters/1234/requeue" || r.Method != http.MethodPut {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"message":"Not Found"}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"status":"OK"}`))
+	}))
+	defer srv.Close()
+
+	c := New()
+	out := &bytes.Buffer{}
+	c.app.Writer = out
+	err := c.app.Run([]string{"tork", "dead-letter", "requeue", "--endpoint", srv.URL, "1234"})
+	assert.NoError(t, err)
+	assert.Contains(t, out.String(), "dead letter 1234 requeued")
+
+	err = c.app.Run([]string{"tork", "dead-letter", "requeue", "--endpoint", srv.URL, "4321"})
+	assert.ErrorContains(t, err, "(404): Not Found")
+}
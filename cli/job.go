@@ -0,0 +1,268 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/runabol/tork"
+	ucli "github.com/urfave/cli/v2"
+)
+
+func (c *CLI) jobCmd() *ucli.Command {
+	return &ucli.Command{
+		Name:  "job",
+		Usage: "Submit, watch and cancel jobs",
+		Subcommands: []*ucli.Command{
+			{
+				Name:      "submit",
+				Usage:     "Submit a job",
+				UsageText: "tork job submit [options] job.yaml",
+				Flags: append(clientFlags(),
+					&ucli.BoolFlag{
+						Name:  "watch",
+						Usage: "watch the job until it finishes",
+					},
+					&ucli.StringFlag{
+						Name:  "idempotency-key",
+						Usage: "a key making retried submissions return the original job",
+					},
+				),
+				Action: submitJob,
+			},
+			{
+				Name:      "watch",
+				Usage:     "Watch a job's progress and logs until it finishes",
+				UsageText: "tork job watch [options] job-id",
+				Flags:     clientFlags(),
+				Action:    watchJob,
+			},
+			{
+				Name:      "cancel",
+				Usage:     "Cancel a running job",
+				UsageText: "tork job cancel [options] job-id",
+				Flags:     clientFlags(),
+				Action:    cancelJob,
+			},
+			{
+				Name:      "search",
+				Usage:     "Search jobs by the names, errors and results of their tasks",
+				UsageText: "tork job search [options] words...",
+				Description: "Lists the most recent jobs matching all the words, e.g.\n" +
+					"tork job search --state failed --since 24h oom",
+				Flags: append(clientFlags(),
+					&ucli.StringFlag{
+						Name:  "state",
+						Usage: "only jobs in these comma-separated states",
+					},
+					&ucli.DurationFlag{
+						Name:  "since",
+						Usage: "only jobs created within this duration, e.g. 12h",
+					},
+					&ucli.BoolFlag{
+						Name:  "logs",
+						Usage: "also search the logs of the tasks",
+					},
+					&ucli.IntFlag{
+						Name:  "limit",
+						Usage: "the maximum number of jobs to list",
+						Value: 20,
+					},
+				),
+				Action: searchJobs,
+			},
+		},
+	}
+}
+
+func submitJob(ctx *ucli.Context) error {
+	if err := validateOutput(ctx); err != nil {
+		return err
+	}
+	fname := ctx.Args().First()
+	if fname == "" {
+		return errors.New("missing required argument: job file")
+	}
+	body, err := os.ReadFile(fname)
+	if err != nil {
+		return errors.Wrapf(err, "error reading %s", fname)
+	}
+	contentType := "text/yaml"
+	if strings.EqualFold(filepath.Ext(fname), ".json") {
+		contentType = "application/json"
+	}
+	c := newClient(ctx)
+	js := &tork.JobSummary{}
+	if err := c.do(ctx.Context, http.MethodPost, "/jobs", bytes.NewReader(body), map[string]string{
+		"Content-Type":    contentType,
+		"Idempotency-Key": ctx.String("idempotency-key"),
+	}, js); err != nil {
+		return err
+	}
+	if !ctx.Bool("watch") {
+		return printJobs(ctx, js)
+	}
+	if !isJSON(ctx) {
+		printf(ctx.App.Writer, "job %s submitted\n", js.ID)
+	}
+	return watch(ctx, c, js.ID)
+}
+
+func watchJob(ctx *ucli.Context) error {
+	if err := validateOutput(ctx); err != nil {
+		return err
+	}
+	id := ctx.Args().First()
+	if id == "" {
+		return errors.New("missing required argument: job id")
+	}
+	return watch(ctx, newClient(ctx), id)
+}
+
+// watch prints the job's state changes and logs as they are
+// streamed by the API, and fails unless the job completes.
+func watch(ctx *ucli.Context, c *client, id string) error {
+	w := ctx.App.Writer
+	var last *tork.JobSummary
+	err := c.stream(ctx.Context, fmt.Sprintf("/jobs/%s/events", id), func(ev sseEvent) error {
+		if isJSON(ctx) {
+			return json.NewEncoder(w).Encode(map[string]any{
+				"event": ev.name,
+				"data":  json.RawMessage(ev.data),
+			})
+		}
+		switch ev.name {
+		case "log":
+			p := tork.TaskLogPart{}
+			if err := json.Unmarshal(ev.data, &p); err != nil {
+				return errors.Wrapf(err, "error unmarshalling log part")
+			}
+			printf(w, "%s", p.Contents)
+			if !strings.HasSuffix(p.Contents, "\n") {
+				printf(w, "\n")
+			}
+		case "job":
+			js := &tork.JobSummary{}
+			if err := json.Unmarshal(ev.data, js); err != nil {
+				return errors.Wrapf(err, "error unmarshalling job")
+			}
+			printf(w, "[%s] job %s %s (%s, %.0f%%)\n",
+				time.Now().Format(time.TimeOnly), js.ID, js.State, taskProgress(js), js.Progress)
+			last = js
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if last == nil {
+		return nil
+	}
+	switch last.State {
+	case tork.JobStateFailed:
+		return errors.Errorf("job %s failed: %s", last.ID, last.Error)
+	case tork.JobStateCancelled:
+		return errors.Errorf("job %s was cancelled", last.ID)
+	}
+	return nil
+}
+
+func cancelJob(ctx *ucli.Context) error {
+	if err := validateOutput(ctx); err != nil {
+		return err
+	}
+	id := ctx.Args().First()
+	if id == "" {
+		return errors.New("missing required argument: job id")
+	}
+	c := newClient(ctx)
+	if err := c.do(ctx.Context, http.MethodPut, fmt.Sprintf("/jobs/%s/cancel", id), nil, nil, nil); err != nil {
+		return err
+	}
+	js := &tork.JobSummary{}
+	if err := c.do(ctx.Context, http.MethodGet, fmt.Sprintf("/jobs/%s", id), nil, nil, js); err != nil {
+		return err
+	}
+	return printJobs(ctx, js)
+}
+
+func searchJobs(ctx *ucli.Context) error {
+	if err := validateOutput(ctx); err != nil {
+		return err
+	}
+	words := strings.Join(ctx.Args().Slice(), " ")
+	if strings.TrimSpace(words) == "" {
+		return errors.New("missing required argument: search words")
+	}
+	params := url.Values{}
+	params.Set("search", words)
+	if state := ctx.String("state"); state != "" {
+		params.Set("state", state)
+	}
+	if since := ctx.Duration("since"); since > 0 {
+		params.Set("createdAfter", time.Now().Add(-since).UTC().Format(time.RFC3339))
+	}
+	if ctx.Bool("logs") {
+		params.Set("searchLogs", "true")
+	}
+	// the API caps the size of a page,
+	// so follow the cursors up to the limit
+	c := newClient(ctx)
+	limit := ctx.Int("limit")
+	jobs := make([]*tork.JobSummary, 0)
+	for len(jobs) < limit {
+		page := struct {
+			Items      []*tork.JobSummary `json:"items"`
+			NextCursor string             `json:"nextCursor"`
+		}{}
+		params.Set("size", strconv.Itoa(limit-len(jobs)))
+		if err := c.do(ctx.Context, http.MethodGet, "/jobs?"+params.Encode(), nil, nil, &page); err != nil {
+			return err
+		}
+		jobs = append(jobs, page.Items...)
+		if page.NextCursor == "" {
+			break
+		}
+		params.Set("cursor", page.NextCursor)
+	}
+	if isJSON(ctx) {
+		return printJSON(ctx.App.Writer, jobs)
+	}
+	return writeJobsTable(ctx.App.Writer, jobs)
+}
+
+func printJobs(ctx *ucli.Context, jobs ...*tork.JobSummary) error {
+	if isJSON(ctx) {
+		if len(jobs) == 1 {
+			return printJSON(ctx.App.Writer, jobs[0])
+		}
+		return printJSON(ctx.App.Writer, jobs)
+	}
+	return writeJobsTable(ctx.App.Writer, jobs)
+}
+
+func writeJobsTable(out io.Writer, jobs []*tork.JobSummary) error {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	printf(w, "ID\tNAME\tSTATE\tPROGRESS\tCREATED\n")
+	for _, js := range jobs {
+		printf(w, "%s\t%s\t%s\t%s\t%s\n",
+			js.ID, js.Name, js.State, taskProgress(js), js.CreatedAt.Local().Format(time.DateTime))
+	}
+	return w.Flush()
+}
+
+// taskProgress returns the position of the job's
+// current task out of the job's number of tasks.
+func taskProgress(js *tork.JobSummary) string {
+	return fmt.Sprintf("%d/%d", min(js.Position, js.TaskCount), js.TaskCount)
+}
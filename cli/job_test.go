@@ -0,0 +1,142 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/runabol/tork"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJobSubmit(t *testing.T) {
+	now := time.Now().UTC()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "/jobs", r.URL.Path)
+		assert.Equal(t, "text/yaml", r.Header.Get("Content-Type"))
+		assert.Equal(t, "abc", r.Header.Get("Idempotency-Key"))
+		body, err := io.ReadAll(r.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, "name: my job\n", string(body))
+		_ = json.NewEncoder(w).Encode(tork.JobSummary{
+			ID:        "1234",
+			Name:      "my job",
+			State:     tork.JobStatePending,
+			CreatedAt: now,
+		})
+	}))
+	defer srv.Close()
+
+	fname := filepath.Join(t.TempDir(), "job.yaml")
+	assert.NoError(t, os.WriteFile(fname, []byte("name: my job\n"), 0644))
+
+	c := New()
+	out := &bytes.Buffer{}
+	c.app.Writer = out
+	err := c.app.Run([]string{"tork", "job", "submit", "--endpoint", srv.URL, "--idempotency-key", "abc", fname})
+	assert.NoError(t, err)
+	assert.Contains(t, out.String(), "1234")
+	assert.Contains(t, out.String(), "my job")
+	assert.Contains(t, out.String(), "PENDING")
+
+	out.Reset()
+	err = c.app.Run([]string{"tork", "job", "submit", "--endpoint", srv.URL, "--idempotency-key", "abc", "-o", "json", fname})
+	assert.NoError(t, err)
+	js := tork.JobSummary{}
+	assert.NoError(t, json.Unmarshal(out.Bytes(), &js))
+	assert.Equal(t, "1234", js.ID)
+
+	err = c.app.Run([]string{"tork", "job", "submit", "--endpoint", srv.URL, "-o", "xml", fname})
+	assert.ErrorContains(t, err, "invalid output format")
+}
+
+func TestJobWatch(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/jobs/1234/events", r.URL.Path)
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = w.Write([]byte("event: job\ndata: {\"id\":\"1234\",\"state\":\"RUNNING\",\"position\":1,\"taskCount\":2}\n\n" +
+			"event: log\ndata: {\"taskId\":\"t1\",\"contents\":\"hello\"}\n\n" +
+			"event: job\ndata: {\"id\":\"1234\",\"state\":\"FAILED\",\"error\":\"bad things happened\"}\n\n"))
+	}))
+	defer srv.Close()
+
+	c := New()
+	out := &bytes.Buffer{}
+	c.app.Writer = out
+	err := c.app.Run([]string{"tork", "job", "watch", "--endpoint", srv.URL, "1234"})
+	assert.EqualError(t, err, "job 1234 failed: bad things happened")
+	assert.Contains(t, out.String(), "job 1234 RUNNING (1/2")
+	assert.Contains(t, out.String(), "hello\n")
+}
+
+func TestJobCancel(t *testing.T) {
+	cancelled := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPut && r.URL.Path == "/jobs/1234/cancel":
+			cancelled = true
+			_, _ = w.Write([]byte(`{"status":"OK"}`))
+		case r.Method == http.MethodGet && r.URL.Path == "/jobs/1234":
+			_ = json.NewEncoder(w).Encode(tork.JobSummary{ID: "1234", State: tork.JobStateCancelled})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"message":"job not found"}`))
+		}
+	}))
+	defer srv.Close()
+
+	c := New()
+	out := &bytes.Buffer{}
+	c.app.Writer = out
+	err := c.app.Run([]string{"tork", "job", "cancel", "--endpoint", srv.URL, "1234"})
+	assert.NoError(t, err)
+	assert.True(t, cancelled)
+	assert.Contains(t, out.String(), "CANCELLED")
+
+	err = c.app.Run([]string{"tork", "job", "cancel", "--endpoint", srv.URL, "4321"})
+	assert.ErrorContains(t, err, "(404): job not found")
+}
+
+func TestJobSearch(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/jobs", r.URL.Path)
+		assert.Equal(t, "oom", r.URL.Query().Get("search"))
+		assert.Equal(t, "FAILED", r.URL.Query().Get("state"))
+		assert.Equal(t, "true", r.URL.Query().Get("searchLogs"))
+		// the cursors are followed up to the limit
+		if r.URL.Query().Get("cursor") == "" {
+			assert.Equal(t, "3", r.URL.Query().Get("size"))
+			_ = json.NewEncoder(w).Encode(map[string]any{
+				"items":      []*tork.JobSummary{{ID: "j1"}, {ID: "j2"}},
+				"nextCursor": "next",
+			})
+			return
+		}
+		assert.Equal(t, "next", r.URL.Query().Get("cursor"))
+		assert.Equal(t, "1", r.URL.Query().Get("size"))
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"items": []*tork.JobSummary{{ID: "j3"}},
+		})
+	}))
+	defer srv.Close()
+
+	c := New()
+	out := &bytes.Buffer{}
+	c.app.Writer = out
+	err := c.app.Run([]string{"tork", "job", "search", "--endpoint", srv.URL, "--state", "FAILED", "--logs", "--limit", "3", "-o", "json", "oom"})
+	assert.NoError(t, err)
+	jobs := make([]*tork.JobSummary, 0)
+	assert.NoError(t, json.Unmarshal(out.Bytes(), &jobs))
+	assert.Len(t, jobs, 3)
+	assert.Equal(t, "j3", jobs[2].ID)
+
+	err = c.app.Run([]string{"tork", "job", "search", "--endpoint", srv.URL})
+	assert.ErrorContains(t, err, "missing required argument")
+}
@@ -0,0 +1,114 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/runabol/tork"
+	ucli "github.com/urfave/cli/v2"
+)
+
+func (c *CLI) keyCmd() *ucli.Command {
+	return &ucli.Command{
+		Name:  "key",
+		Usage: "Mint, list and revoke API keys",
+		Subcommands: []*ucli.Command{
+			{
+				Name:      "create",
+				Usage:     "Mint an API key acting on behalf of the calling user",
+				UsageText: "tork key create [options] name",
+				Description: "The key is only printed once. Pass it to the other\n" +
+					"commands with --token or set it as client.token.",
+				Flags:  clientFlags(),
+				Action: createKey,
+			},
+			{
+				Name:      "ls",
+				Usage:     "List the API keys",
+				UsageText: "tork key ls [options]",
+				Flags:     clientFlags(),
+				Action:    listKeys,
+			},
+			{
+				Name:      "revoke",
+				Usage:     "Revoke an API key",
+				UsageText: "tork key revoke [options] key-id",
+				Flags:     clientFlags(),
+				Action:    revokeKey,
+			},
+		},
+	}
+}
+
+func createKey(ctx *ucli.Context) error {
+	if err := validateOutput(ctx); err != nil {
+		return err
+	}
+	name := ctx.Args().First()
+	if name == "" {
+		return errors.New("missing required argument: key name")
+	}
+	body, err := json.Marshal(tork.APIKey{Name: name})
+	if err != nil {
+		return errors.Wrapf(err, "error marshalling the key")
+	}
+	k := &tork.APIKey{}
+	if err := newClient(ctx).do(ctx.Context, http.MethodPost, "/keys", bytes.NewReader(body), map[string]string{
+		"Content-Type": "application/json",
+	}, k); err != nil {
+		return err
+	}
+	if isJSON(ctx) {
+		return printJSON(ctx.App.Writer, k)
+	}
+	if err := writeKeysTable(ctx.App.Writer, []*tork.APIKey{k}); err != nil {
+		return err
+	}
+	printf(ctx.App.Writer, "\n%s\n\nstore the key now, it can't be displayed again\n", k.Key)
+	return nil
+}
+
+func listKeys(ctx *ucli.Context) error {
+	if err := validateOutput(ctx); err != nil {
+		return err
+	}
+	keys := make([]*tork.APIKey, 0)
+	if err := newClient(ctx).do(ctx.Context, http.MethodGet, "/keys", nil, nil, &keys); err != nil {
+		return err
+	}
+	if isJSON(ctx) {
+		return printJSON(ctx.App.Writer, keys)
+	}
+	return writeKeysTable(ctx.App.Writer, keys)
+}
+
+func revokeKey(ctx *ucli.Context) error {
+	id := ctx.Args().First()
+	if id == "" {
+		return errors.New("missing required argument: key id")
+	}
+	if err := newClient(ctx).do(ctx.Context, http.MethodDelete, fmt.Sprintf("/keys/%s", id), nil, nil, nil); err != nil {
+		return err
+	}
+	printf(ctx.App.Writer, "key %s revoked\n", id)
+	return nil
+}
+
+func writeKeysTable(out io.Writer, keys []*tork.APIKey) error {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	printf(w, "ID\tNAME\tCREATED BY\tCREATED\n")
+	for _, k := range keys {
+		var created string
+		if k.CreatedAt != nil {
+			created = k.CreatedAt.Local().Format(time.DateTime)
+		}
+		printf(w, "%s\t%s\t%s\t%s\n", k.ID, k.Name, k.CreatedBy, created)
+	}
+	return w.Flush()
+}
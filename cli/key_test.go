@@ -0,0 +1,64 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/runabol/tork"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeyCreate(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "/keys", r.URL.Path)
+		assert.Equal(t, "Bearer admin-key", r.Header.Get("Authorization"))
+		body, err := io.ReadAll(r.Body)
+		assert.NoError(t, err)
+		assert.JSONEq(t, `{"name":"ci"}`, string(body))
+		_ = json.NewEncoder(w).Encode(tork.APIKey{
+			ID:   "1234",
+			Name: "ci",
+			Key:  "plaintext-key",
+		})
+	}))
+	defer srv.Close()
+
+	c := New()
+	out := &bytes.Buffer{}
+	c.app.Writer = out
+	err := c.app.Run([]string{"tork", "key", "create", "--endpoint", srv.URL, "--token", "admin-key", "ci"})
+	assert.NoError(t, err)
+	assert.Contains(t, out.String(), "1234")
+	assert.Contains(t, out.String(), "plaintext-key")
+
+	err = c.app.Run([]string{"tork", "key", "create", "--endpoint", srv.URL})
+	assert.ErrorContains(t, err, "missing required argument")
+}
+
+func TestKeyRevoke(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodDelete, r.Method)
+		if r.URL.Path != "/keys/1234" {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"message":"Not Found"}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"status":"OK"}`))
+	}))
+	defer srv.Close()
+
+	c := New()
+	out := &bytes.Buffer{}
+	c.app.Writer = out
+	err := c.app.Run([]string{"tork", "key", "revoke", "--endpoint", srv.URL, "1234"})
+	assert.NoError(t, err)
+	assert.Contains(t, out.String(), "key 1234 revoked")
+
+	err = c.app.Run([]string{"tork", "key", "revoke", "--endpoint", srv.URL, "4321"})
+	assert.ErrorContains(t, err, "(404): Not Found")
+}
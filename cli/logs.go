@@ -0,0 +1,251 @@
+package cli
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/pkg/errors"
+	"github.com/runabol/tork"
+	ucli "github.com/urfave/cli/v2"
+	"golang.org/x/net/websocket"
+)
+
+// the colors the task prefixes of a job's logs cycle through
+var prefixColors = []color.Attribute{
+	color.FgCyan,
+	color.FgYellow,
+	color.FgGreen,
+	color.FgMagenta,
+	color.FgBlue,
+	color.FgRed,
+}
+
+// logPage mirrors the API's paginated log parts response.
+type logPage struct {
+	Items      []*tork.TaskLogPart `json:"items"`
+	TotalPages int                 `json:"totalPages"`
+}
+
+func (c *CLI) logsCmd() *ucli.Command {
+	return &ucli.Command{
+		Name:      "logs",
+		Usage:     "Print the logs of a task or a job",
+		UsageText: "tork logs [options] task-id|job-id",
+		Flags: append(clientFlags(),
+			&ucli.BoolFlag{
+				Name:    "follow",
+				Aliases: []string{"f"},
+				Usage:   "keep printing the logs as they arrive until the task or job finishes",
+			},
+		),
+		Action: logs,
+	}
+}
+
+func logs(ctx *ucli.Context) error {
+	id := ctx.Args().First()
+	if id == "" {
+		return errors.New("missing required argument: task or job id")
+	}
+	c := newClient(ctx)
+	p := &logPrinter{
+		ctx:    ctx.Context,
+		c:      c,
+		w:      ctx.App.Writer,
+		names:  make(map[string]string),
+		colors: make(map[string]*color.Color),
+	}
+	t := &tork.Task{}
+	err := c.do(ctx.Context, http.MethodGet, "/tasks/"+url.PathEscape(id), nil, nil, t)
+	if err == nil {
+		if ctx.Bool("follow") {
+			return p.followTask(id)
+		}
+		return p.printLog(fmt.Sprintf("/tasks/%s/log", url.PathEscape(id)), nil)
+	}
+	// e.g. an authentication or network error
+	if !isNotFound(err) {
+		return err
+	}
+	j := &tork.Job{}
+	if err := c.do(ctx.Context, http.MethodGet, "/jobs/"+url.PathEscape(id), nil, nil, j); err != nil {
+		if isNotFound(err) {
+			return errors.Errorf("no task or job found with id %s", id)
+		}
+		return err
+	}
+	for _, t := range j.Execution {
+		p.names[t.ID] = t.Name
+	}
+	if ctx.Bool("follow") {
+		return p.followJob(id)
+	}
+	return p.printLog(fmt.Sprintf("/jobs/%s/log", url.PathEscape(id)), p.prefix)
+}
+
+type logPrinter struct {
+	ctx    context.Context
+	c      *client
+	w      io.Writer
+	names  map[string]string
+	colors map[string]*color.Color
+}
+
+// printLog prints all the log parts of a task or a
+// job, which the API returns newest first.
+func (p *logPrinter) printLog(path string, prefix func(taskID string) string) error {
+	parts := make([]*tork.TaskLogPart, 0)
+	for page := 1; ; page++ {
+		l := logPage{}
+		if err := p.c.do(p.ctx, http.MethodGet, fmt.Sprintf("%s?page=%d&size=100", path, page), nil, nil, &l); err != nil {
+			return err
+		}
+		parts = append(parts, l.Items...)
+		if page >= l.TotalPages {
+			break
+		}
+	}
+	for i := len(parts) - 1; i >= 0; i-- {
+		p.print(parts[i], prefix)
+	}
+	return nil
+}
+
+// followTask tails the task's log through the API's websocket.
+func (p *logPrinter) followTask(id string) error {
+	u, err := url.Parse(fmt.Sprintf("%s/tasks/%s/log/ws", p.c.endpoint, url.PathEscape(id)))
+	if err != nil {
+		return errors.Wrapf(err, "invalid endpoint: %s", p.c.endpoint)
+	}
+	origin := *u
+	switch u.Scheme {
+	case "https":
+		u.Scheme = "wss"
+	default:
+		u.Scheme = "ws"
+	}
+	cfg, err := websocket.NewConfig(u.String(), origin.String())
+	if err != nil {
+		return errors.Wrapf(err, "error building websocket config")
+	}
+	if p.c.token != "" {
+		cfg.Header.Set("Authorization", "Bearer "+p.c.token)
+	} else if p.c.username != "" {
+		creds := base64.StdEncoding.EncodeToString([]byte(p.c.username + ":" + p.c.password))
+		cfg.Header.Set("Authorization", "Basic "+creds)
+	}
+	ws, err := cfg.DialContext(p.ctx)
+	if err != nil {
+		return errors.Wrapf(err, "error connecting to %s", u.String())
+	}
+	defer ws.Close()
+	for {
+		part := &tork.TaskLogPart{}
+		if err := websocket.JSON.Receive(ws, part); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return errors.Wrapf(err, "error reading log")
+		}
+		p.print(part, nil)
+	}
+}
+
+// how often the log of a followed job is polled
+var followInterval = time.Second
+
+// followJob prints the logs of all the job's tasks, prefixed
+// with the name of their task, until the job finishes. The log
+// is polled through the paginated API, so that no part is lost
+// when the printer falls behind or reconnects.
+func (p *logPrinter) followJob(id string) error {
+	seen := make(map[string]bool)
+	for {
+		j := &tork.JobSummary{}
+		if err := p.c.do(p.ctx, http.MethodGet, "/jobs/"+url.PathEscape(id), nil, nil, j); err != nil {
+			return err
+		}
+		if err := p.printNew(fmt.Sprintf("/jobs/%s/log", url.PathEscape(id)), seen, p.prefix); err != nil {
+			return err
+		}
+		if !j.State.IsActive() {
+			return nil
+		}
+		select {
+		case <-p.ctx.Done():
+			return p.ctx.Err()
+		case <-time.After(followInterval):
+		}
+	}
+}
+
+// printNew prints the log parts which were not seen yet. Since the
+// API returns the newest parts first, the pages are fetched until
+// one holds a part that was already seen.
+func (p *logPrinter) printNew(path string, seen map[string]bool, prefix func(taskID string) string) error {
+	parts := make([]*tork.TaskLogPart, 0)
+	for page := 1; ; page++ {
+		l := logPage{}
+		if err := p.c.do(p.ctx, http.MethodGet, fmt.Sprintf("%s?page=%d&size=100", path, page), nil, nil, &l); err != nil {
+			return err
+		}
+		caughtUp := false
+		for _, part := range l.Items {
+			key := fmt.Sprintf("%s/%d", part.TaskID, part.Number)
+			if seen[key] {
+				caughtUp = true
+				continue
+			}
+			seen[key] = true
+			parts = append(parts, part)
+		}
+		if caughtUp || page >= l.TotalPages {
+			break
+		}
+	}
+	for i := len(parts) - 1; i >= 0; i-- {
+		p.print(parts[i], prefix)
+	}
+	return nil
+}
+
+func (p *logPrinter) print(part *tork.TaskLogPart, prefix func(taskID string) string) {
+	contents := strings.TrimSuffix(part.Contents, "\n")
+	if prefix == nil {
+		printf(p.w, "%s\n", contents)
+		return
+	}
+	pre := prefix(part.TaskID)
+	for _, line := range strings.Split(contents, "\n") {
+		printf(p.w, "%s %s\n", pre, line)
+	}
+}
+
+// prefix returns the colored name of the task, looking it
+// up for tasks which were not in the job's execution yet.
+func (p *logPrinter) prefix(taskID string) string {
+	name, ok := p.names[taskID]
+	if !ok {
+		t := &tork.Task{}
+		if err := p.c.do(p.ctx, http.MethodGet, "/tasks/"+url.PathEscape(taskID), nil, nil, t); err == nil {
+			name = t.Name
+		}
+		p.names[taskID] = name
+	}
+	if name == "" {
+		name = taskID
+	}
+	c, ok := p.colors[taskID]
+	if !ok {
+		c = color.New(prefixColors[len(p.colors)%len(prefixColors)])
+		p.colors[taskID] = c
+	}
+	return c.Sprintf("%s |", name)
+}
@@ -0,0 +1,127 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/runabol/tork"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLogsTask(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/tasks/1234":
+			_ = json.NewEncoder(w).Encode(tork.Task{ID: "1234"})
+		case "/tasks/1234/log":
+			// newest first
+			_ = json.NewEncoder(w).Encode(logPage{
+				Items: []*tork.TaskLogPart{
+					{Number: 2, TaskID: "1234", Contents: "world\n"},
+					{Number: 1, TaskID: "1234", Contents: "hello\n"},
+				},
+				TotalPages: 1,
+			})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	c := New()
+	out := &bytes.Buffer{}
+	c.app.Writer = out
+	err := c.app.Run([]string{"tork", "logs", "--endpoint", srv.URL, "1234"})
+	assert.NoError(t, err)
+	assert.Equal(t, "hello\nworld\n", out.String())
+}
+
+func TestLogsJob(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/jobs/1234":
+			_ = json.NewEncoder(w).Encode(tork.Job{
+				ID:        "1234",
+				Execution: []*tork.Task{{ID: "t1", Name: "build"}},
+			})
+		case "/jobs/1234/log":
+			_ = json.NewEncoder(w).Encode(logPage{
+				Items:      []*tork.TaskLogPart{{Number: 1, TaskID: "t1", Contents: "compiling\nlinking\n"}},
+				TotalPages: 1,
+			})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"message":"not found"}`))
+		}
+	}))
+	defer srv.Close()
+
+	c := New()
+	out := &bytes.Buffer{}
+	c.app.Writer = out
+	err := c.app.Run([]string{"tork", "logs", "--endpoint", srv.URL, "1234"})
+	assert.NoError(t, err)
+	assert.Contains(t, out.String(), "build | compiling\n")
+	assert.Contains(t, out.String(), "build | linking\n")
+
+	err = c.app.Run([]string{"tork", "logs", "--endpoint", srv.URL, "4321"})
+	assert.EqualError(t, err, "no task or job found with id 4321")
+}
+
+func TestLogsUnauthorized(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+		_, _ = w.Write([]byte(`{"message":"invalid key"}`))
+	}))
+	defer srv.Close()
+
+	c := New()
+	c.app.Writer = &bytes.Buffer{}
+	err := c.app.Run([]string{"tork", "logs", "--endpoint", srv.URL, "--token", "bad", "1234"})
+	assert.EqualError(t, err, "GET /tasks/1234 failed (401): invalid key")
+}
+
+func TestLogsFollowJob(t *testing.T) {
+	defer func(d time.Duration) { followInterval = d }(followInterval)
+	followInterval = time.Millisecond * 10
+	// the job's log grows between the polls
+	var polls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/jobs/1234":
+			state := tork.JobStateRunning
+			if polls.Load() >= 2 {
+				state = tork.JobStateCompleted
+			}
+			_ = json.NewEncoder(w).Encode(tork.Job{
+				ID:        "1234",
+				State:     state,
+				Execution: []*tork.Task{{ID: "t1", Name: "build"}},
+			})
+		case "/jobs/1234/log":
+			items := []*tork.TaskLogPart{{Number: 1, TaskID: "t1", Contents: "one\n"}}
+			if polls.Add(1) > 1 {
+				items = append([]*tork.TaskLogPart{
+					{Number: 3, TaskID: "t1", Contents: "three\n"},
+					{Number: 2, TaskID: "t1", Contents: "two\n"},
+				}, items...)
+			}
+			_ = json.NewEncoder(w).Encode(logPage{Items: items, TotalPages: 1})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	c := New()
+	out := &bytes.Buffer{}
+	c.app.Writer = out
+	err := c.app.Run([]string{"tork", "logs", "--endpoint", srv.URL, "--follow", "1234"})
+	assert.NoError(t, err)
+	assert.Equal(t, "build | one\nbuild | two\nbuild | three\n", out.String())
+}
@@ -6,14 +6,13 @@ import (
 	"github.com/runabol/tork/conf"
 	"github.com/runabol/tork/datastore"
 	"github.com/runabol/tork/datastore/postgres"
-	schema "github.com/runabol/tork/db/postgres"
 	ucli "github.com/urfave/cli/v2"
 )
 
 func (c *CLI) migrationCmd() *ucli.Command {
 	return &ucli.Command{
 		Name:   "migration",
-		Usage:  "Run the db migration script",
+		Usage:  "Apply the pending db migrations",
 		Action: migration,
 	}
 }
@@ -30,8 +29,8 @@ func migration(ctx *ucli.Context) error {
 		if err != nil {
 			return err
 		}
-		if err := pg.ExecScript(schema.SCHEMA); err != nil {
-			return errors.Wrapf(err, "error when trying to create db schema")
+		if err := pg.Migrate(ctx.Context); err != nil {
+			return errors.Wrapf(err, "error when trying to migrate the db schema")
 		}
 	default:
 		return errors.Errorf("can't perform db migration on: %s", dstype)
@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"os"
 
+	"github.com/pkg/errors"
+	"github.com/runabol/tork/conf"
 	"github.com/runabol/tork/engine"
 	ucli "github.com/urfave/cli/v2"
 )
@@ -12,8 +14,23 @@ func (c *CLI) runCmd() *ucli.Command {
 	return &ucli.Command{
 		Name:      "run",
 		Usage:     "Run Tork",
-		UsageText: "tork run mode (standalone|coordinator|worker)",
-		Action:    c.run,
+		UsageText: "tork run [--datastore inmemory|postgres|bolt] [--db file] mode (standalone|coordinator|worker)",
+		Description: "standalone runs the coordinator, its API and a worker in a single process.\n" +
+			"Unless configured otherwise it uses the in-memory broker and datastore,\n" +
+			"which makes it the quickest way to try Tork locally. To keep the jobs\n" +
+			"across restarts, run it with --datastore bolt, which stores them in an\n" +
+			"embedded database file.",
+		Flags: []ucli.Flag{
+			&ucli.StringFlag{
+				Name:  "datastore",
+				Usage: "the datastore to use instead of the configured datastore.type",
+			},
+			&ucli.StringFlag{
+				Name:  "db",
+				Usage: "the database file of the bolt datastore (default: tork.db)",
+			},
+		},
+		Action: c.run,
 	}
 }
 func (c *CLI) run(ctx *ucli.Context) error {
@@ -25,6 +42,21 @@ func (c *CLI) run(ctx *ucli.Context) error {
 		fmt.Println("missing required argument: mode")
 		os.Exit(1)
 	}
+	switch engine.Mode(mode) {
+	case engine.ModeStandalone, engine.ModeCoordinator, engine.ModeWorker:
+	default:
+		return errors.Errorf("invalid mode: %s. expecting standalone, coordinator or worker", mode)
+	}
+	if ctx.IsSet("datastore") {
+		if err := conf.Set("datastore.type", ctx.String("datastore")); err != nil {
+			return err
+		}
+	}
+	if ctx.IsSet("db") {
+		if err := conf.Set("datastore.bolt.path", ctx.String("db")); err != nil {
+			return err
+		}
+	}
 	engine.SetMode(engine.Mode(mode))
 	if err := engine.Run(); err != nil {
 		return err
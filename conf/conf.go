@@ -3,6 +3,7 @@ package conf
 import (
 	"fmt"
 	"os"
+	"path/filepath"
 	"strings"
 	"time"
 
@@ -18,11 +19,18 @@ import (
 var konf = koanf.New(".")
 var logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
 
+// the file the config was loaded from, if any
+var source string
+
 var defaultConfigPaths = []string{
 	"config.local.toml",
+	"config.local.yaml",
 	"config.toml",
+	"config.yaml",
 	"~/tork/config.toml",
+	"~/tork/config.yaml",
 	"/etc/tork/config.toml",
+	"/etc/tork/config.yaml",
 }
 
 func LoadConfig() error {
@@ -36,7 +44,7 @@ func LoadConfig() error {
 	// load configs from file paths
 	var loaded bool
 	for _, f := range paths {
-		err := konf.Load(file.Provider(f), toml.Parser())
+		err := konf.Load(file.Provider(f), parserFor(f))
 		if errors.Is(err, os.ErrNotExist) {
 			continue
 		}
@@ -44,12 +52,46 @@ func LoadConfig() error {
 			return errors.Wrapf(err, "error loading config from %s", f)
 		}
 		logger.Info().Msgf("Config loaded from %s", f)
+		source = f
 		loaded = true
 		break
 	}
 	if !loaded && userConfig != "" {
 		return errors.Errorf(fmt.Sprintf("could not find config file in: %s", userConfig))
 	}
+	return loadEnv()
+}
+
+// LoadConfigFile discards the loaded config and
+// loads it again from the given file and env vars.
+func LoadConfigFile(f string) error {
+	konf = koanf.New(".")
+	source = ""
+	if err := konf.Load(file.Provider(f), parserFor(f)); err != nil {
+		return errors.Wrapf(err, "error loading config from %s", f)
+	}
+	source = f
+	return loadEnv()
+}
+
+// Source returns the file the config was
+// loaded from or an empty string if none.
+func Source() string {
+	return source
+}
+
+// parserFor picks the parser by the file's extension,
+// falling back to TOML.
+func parserFor(f string) koanf.Parser {
+	switch strings.ToLower(filepath.Ext(f)) {
+	case ".yaml", ".yml":
+		return yamlParser{}
+	default:
+		return toml.Parser()
+	}
+}
+
+func loadEnv() error {
 	// load configs from env vars
 	if err := konf.Load(env.Provider("TORK_", ".", func(s string) string {
 		return strings.Replace(strings.ToLower(
@@ -60,6 +102,21 @@ func LoadConfig() error {
 	return nil
 }
 
+// Set overrides the value of the key, e.g.
+// with the value of a command line flag.
+func Set(key string, value any) error {
+	if err := konf.Set(key, value); err != nil {
+		return errors.Wrapf(err, "error setting %s", key)
+	}
+	return nil
+}
+
+// Get returns the raw value of the key
+// or nil if it isn't set.
+func Get(key string) any {
+	return konf.Get(key)
+}
+
 func IntMap(key string) map[string]int {
 	return konf.IntMap(key)
 }
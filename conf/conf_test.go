@@ -104,6 +104,24 @@ func TestStringDefault(t *testing.T) {
 	assert.Equal(t, "v2", conf.StringDefault("main.key2", "v2"))
 }
 
+func TestSet(t *testing.T) {
+	konf := `
+	[main]
+	key1 = "value1"
+	`
+	err := os.WriteFile("config.toml", []byte(konf), os.ModePerm)
+	assert.NoError(t, err)
+	defer func() {
+		assert.NoError(t, os.Remove("config.toml"))
+	}()
+	err = conf.LoadConfig()
+	assert.NoError(t, err)
+	assert.NoError(t, conf.Set("main.key1", "value2"))
+	assert.NoError(t, conf.Set("main.key3", "value3"))
+	assert.Equal(t, "value2", conf.String("main.key1"))
+	assert.Equal(t, "value3", conf.String("main.key3"))
+}
+
 func TestIntMap(t *testing.T) {
 	konf := `
 	[main]
@@ -291,3 +309,50 @@ func TestUnmarshal(t *testing.T) {
 	assert.Equal(t, []string{"a", "b"}, c.SArr1)
 	assert.Equal(t, []string{"default1", "default2"}, c.SArr2)
 }
+
+func TestLoadConfigYAML(t *testing.T) {
+	konf := `
+main:
+  key1: value1
+  some:
+    duration: 5m
+`
+	err := os.WriteFile("config_test.yaml", []byte(konf), os.ModePerm)
+	assert.NoError(t, err)
+	defer func() {
+		assert.NoError(t, os.Remove("config_test.yaml"))
+	}()
+	os.Setenv("TORK_CONFIG", "config_test.yaml")
+	defer func() {
+		os.Unsetenv("TORK_CONFIG")
+	}()
+	assert.NoError(t, os.Setenv("TORK_MAIN_KEY2", "value2"))
+	defer func() {
+		assert.NoError(t, os.Unsetenv("TORK_MAIN_KEY2"))
+	}()
+	err = conf.LoadConfig()
+	assert.NoError(t, err)
+	assert.Equal(t, "config_test.yaml", conf.Source())
+	assert.Equal(t, "value1", conf.String("main.key1"))
+	assert.Equal(t, "value2", conf.String("main.key2"))
+	assert.Equal(t, time.Minute*5, conf.DurationDefault("main.some.duration", time.Minute))
+}
+
+func TestLoadConfigFile(t *testing.T) {
+	err := os.WriteFile("config_file1.toml", []byte("[main]\nkey1 = \"value1\""), os.ModePerm)
+	assert.NoError(t, err)
+	defer func() {
+		assert.NoError(t, os.Remove("config_file1.toml"))
+	}()
+	err = os.WriteFile("config_file2.yaml", []byte("main:\n  key2: value2"), os.ModePerm)
+	assert.NoError(t, err)
+	defer func() {
+		assert.NoError(t, os.Remove("config_file2.yaml"))
+	}()
+	assert.NoError(t, conf.LoadConfigFile("config_file1.toml"))
+	assert.Equal(t, "value1", conf.String("main.key1"))
+	assert.NoError(t, conf.LoadConfigFile("config_file2.yaml"))
+	assert.Equal(t, "", conf.String("main.key1"))
+	assert.Equal(t, "value2", conf.String("main.key2"))
+	assert.Error(t, conf.LoadConfigFile("no.such.yaml"))
+}
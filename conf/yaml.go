@@ -0,0 +1,23 @@
+package conf
+
+import (
+	"gopkg.in/yaml.v3"
+)
+
+// yamlParser parses YAML config files.
+type yamlParser struct{}
+
+func (p yamlParser) Unmarshal(b []byte) (map[string]interface{}, error) {
+	var out map[string]interface{}
+	if err := yaml.Unmarshal(b, &out); err != nil {
+		return nil, err
+	}
+	if out == nil {
+		out = make(map[string]interface{})
+	}
+	return out, nil
+}
+
+func (p yamlParser) Marshal(o map[string]interface{}) ([]byte, error) {
+	return yaml.Marshal(o)
+}
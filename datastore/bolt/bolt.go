@@ -0,0 +1,490 @@
+package bolt
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/runabol/tork"
+	"github.com/runabol/tork/datastore"
+	"github.com/runabol/tork/datastore/inmemory"
+	"github.com/runabol/tork/internal/cache"
+	bbolt "go.etcd.io/bbolt"
+)
+
+const (
+	DefaultPath        = "tork.db"
+	DefaultOpenTimeout = time.Second * 5
+)
+
+var (
+	bucketJobs          = []byte("jobs")
+	bucketTasks         = []byte("tasks")
+	bucketLogParts      = []byte("tasks_log_parts")
+	bucketUsers         = []byte("users")
+	bucketRoles         = []byte("roles")
+	bucketUserRoles     = []byte("users_roles")
+	bucketAPIKeys       = []byte("api_keys")
+	bucketSecrets       = []byte("secrets")
+	bucketDeadLetters   = []byte("dead_letters")
+	bucketScheduledJobs = []byte("scheduled_jobs")
+)
+
+// BoltDatastore is a datastore for single-node deployments which
+// keeps its data in a BoltDB file. Reads are served by an in-memory
+// datastore which is loaded from the file on startup, while every
+// change is written through to the file.
+// Nodes and leases are short-lived and are not persisted.
+type BoltDatastore struct {
+	*inmemory.InMemoryDatastore
+	db           *bbolt.DB
+	mu           sync.Mutex
+	keyRetention time.Duration
+	openTimeout  time.Duration
+}
+
+type Option = func(ds *BoltDatastore)
+
+// WithIdempotencyKeyRetention sets how long the
+// idempotency key of a job is honored.
+func WithIdempotencyKeyRetention(d time.Duration) Option {
+	return func(ds *BoltDatastore) {
+		ds.keyRetention = d
+	}
+}
+
+// WithOpenTimeout sets how long to wait for the lock on the
+// database file, which is held by one process at a time.
+func WithOpenTimeout(d time.Duration) Option {
+	return func(ds *BoltDatastore) {
+		ds.openTimeout = d
+	}
+}
+
+type jobRecord struct {
+	*tork.Job
+	IdempotencyHash string `json:"idempotencyHash,omitempty"`
+}
+
+type userRecord struct {
+	*tork.User
+	PasswordHash string `json:"passwordHash,omitempty"`
+}
+
+type apiKeyRecord struct {
+	*tork.APIKey
+	KeyHash string `json:"keyHash,omitempty"`
+}
+
+func NewBoltDatastore(path string, opts ...Option) (*BoltDatastore, error) {
+	ds := &BoltDatastore{
+		keyRetention: datastore.DefaultIdempotencyKeyRetention,
+		openTimeout:  DefaultOpenTimeout,
+	}
+	for _, opt := range opts {
+		opt(ds)
+	}
+	db, err := bbolt.Open(path, 0600, &bbolt.Options{Timeout: ds.openTimeout})
+	if err != nil {
+		return nil, errors.Wrapf(err, "error opening %s", path)
+	}
+	ds.db = db
+	if err := ds.load(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return ds, nil
+}
+
+func (ds *BoltDatastore) load() error {
+	if err := ds.db.Update(func(tx *bbolt.Tx) error {
+		for _, b := range [][]byte{bucketJobs, bucketTasks, bucketLogParts, bucketUsers, bucketRoles,
+			bucketUserRoles, bucketAPIKeys, bucketSecrets, bucketDeadLetters, bucketScheduledJobs} {
+			if _, err := tx.CreateBucketIfNotExists(b); err != nil {
+				return errors.Wrapf(err, "error creating bucket %s", b)
+			}
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	roles := make([]*tork.Role, 0)
+	if err := ds.each(bucketRoles, func(v []byte) error {
+		r := &tork.Role{}
+		if err := json.Unmarshal(v, r); err != nil {
+			return err
+		}
+		roles = append(roles, r)
+		return nil
+	}); err != nil {
+		return err
+	}
+	opts := []inmemory.Option{
+		inmemory.WithJobExpiration(cache.NoExpiration),
+		inmemory.WithIdempotencyKeyRetention(ds.keyRetention),
+	}
+	if len(roles) > 0 {
+		opts = append(opts, inmemory.WithRoles(roles...))
+	}
+	ds.InMemoryDatastore = inmemory.NewInMemoryDatastore(opts...)
+	ctx := context.Background()
+	if len(roles) == 0 {
+		// persist the roles the datastore is seeded with
+		seeded, err := ds.InMemoryDatastore.GetRoles(ctx)
+		if err != nil {
+			return err
+		}
+		for _, r := range seeded {
+			if err := ds.put(bucketRoles, r.ID, r); err != nil {
+				return err
+			}
+		}
+	}
+	if err := ds.each(bucketUsers, func(v []byte) error {
+		r := &userRecord{User: &tork.User{}}
+		if err := json.Unmarshal(v, r); err != nil {
+			return err
+		}
+		r.User.PasswordHash = r.PasswordHash
+		return ds.InMemoryDatastore.CreateUser(ctx, r.User)
+	}); err != nil {
+		return err
+	}
+	if err := ds.eachKey(bucketUserRoles, func(userID string, v []byte) error {
+		roleIDs := make([]string, 0)
+		if err := json.Unmarshal(v, &roleIDs); err != nil {
+			return err
+		}
+		for _, roleID := range roleIDs {
+			if err := ds.InMemoryDatastore.AssignRole(ctx, userID, roleID); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	if err := ds.each(bucketJobs, func(v []byte) error {
+		r := &jobRecord{Job: &tork.Job{}}
+		if err := json.Unmarshal(v, r); err != nil {
+			return err
+		}
+		r.Job.IdempotencyHash = r.IdempotencyHash
+		return ds.InMemoryDatastore.CreateJob(ctx, r.Job)
+	}); err != nil {
+		return err
+	}
+	if err := ds.each(bucketTasks, func(v []byte) error {
+		t := &tork.Task{}
+		if err := json.Unmarshal(v, t); err != nil {
+			return err
+		}
+		return ds.InMemoryDatastore.CreateTask(ctx, t)
+	}); err != nil {
+		return err
+	}
+	if err := ds.each(bucketLogParts, func(v []byte) error {
+		p := &tork.TaskLogPart{}
+		if err := json.Unmarshal(v, p); err != nil {
+			return err
+		}
+		return ds.InMemoryDatastore.CreateTaskLogPart(ctx, p)
+	}); err != nil {
+		return err
+	}
+	if err := ds.each(bucketAPIKeys, func(v []byte) error {
+		r := &apiKeyRecord{APIKey: &tork.APIKey{}}
+		if err := json.Unmarshal(v, r); err != nil {
+			return err
+		}
+		r.APIKey.KeyHash = r.KeyHash
+		return ds.InMemoryDatastore.CreateAPIKey(ctx, r.APIKey)
+	}); err != nil {
+		return err
+	}
+	if err := ds.each(bucketSecrets, func(v []byte) error {
+		s := &tork.Secret{}
+		if err := json.Unmarshal(v, s); err != nil {
+			return err
+		}
+		return ds.InMemoryDatastore.SetSecret(ctx, s)
+	}); err != nil {
+		return err
+	}
+	if err := ds.each(bucketDeadLetters, func(v []byte) error {
+		dl := &tork.DeadLetter{}
+		if err := json.Unmarshal(v, dl); err != nil {
+			return err
+		}
+		return ds.InMemoryDatastore.CreateDeadLetter(ctx, dl)
+	}); err != nil {
+		return err
+	}
+	return ds.each(bucketScheduledJobs, func(v []byte) error {
+		sj := &tork.ScheduledJob{}
+		if err := json.Unmarshal(v, sj); err != nil {
+			return err
+		}
+		return ds.InMemoryDatastore.CreateScheduledJob(ctx, sj)
+	})
+}
+
+func (ds *BoltDatastore) each(bucket []byte, fn func(v []byte) error) error {
+	return ds.eachKey(bucket, func(_ string, v []byte) error {
+		return fn(v)
+	})
+}
+
+func (ds *BoltDatastore) eachKey(bucket []byte, fn func(k string, v []byte) error) error {
+	return ds.db.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket(bucket).ForEach(func(k, v []byte) error {
+			if err := fn(string(k), v); err != nil {
+				return errors.Wrapf(err, "error loading %s %s", bucket, k)
+			}
+			return nil
+		})
+	})
+}
+
+func (ds *BoltDatastore) put(bucket []byte, key string, v any) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return errors.Wrapf(err, "error marshalling %s %s", bucket, key)
+	}
+	if err := ds.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(bucket).Put([]byte(key), b)
+	}); err != nil {
+		return errors.Wrapf(err, "error writing %s %s", bucket, key)
+	}
+	return nil
+}
+
+func (ds *BoltDatastore) delete(bucket []byte, key string) error {
+	if err := ds.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(bucket).Delete([]byte(key))
+	}); err != nil {
+		return errors.Wrapf(err, "error deleting %s %s", bucket, key)
+	}
+	return nil
+}
+
+func (ds *BoltDatastore) putJob(j *tork.Job) error {
+	j = j.Clone()
+	// the execution is stored with the tasks
+	j.Execution = nil
+	return ds.put(bucketJobs, j.ID, jobRecord{Job: j, IdempotencyHash: j.IdempotencyHash})
+}
+
+func (ds *BoltDatastore) CreateTask(ctx context.Context, t *tork.Task) error {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	if err := ds.InMemoryDatastore.CreateTask(ctx, t); err != nil {
+		return err
+	}
+	return ds.put(bucketTasks, t.ID, t)
+}
+
+func (ds *BoltDatastore) UpdateTask(ctx context.Context, id string, modify func(u *tork.Task) error) error {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	var updated *tork.Task
+	if err := ds.InMemoryDatastore.UpdateTask(ctx, id, func(u *tork.Task) error {
+		if err := modify(u); err != nil {
+			return err
+		}
+		updated = u.Clone()
+		return nil
+	}); err != nil {
+		return err
+	}
+	return ds.put(bucketTasks, id, updated)
+}
+
+func (ds *BoltDatastore) CreateTaskLogPart(ctx context.Context, p *tork.TaskLogPart) error {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	if err := ds.InMemoryDatastore.CreateTaskLogPart(ctx, p); err != nil {
+		return err
+	}
+	// keyed so that the parts of a task are loaded in order
+	return ds.put(bucketLogParts, fmt.Sprintf("%s/%010d", p.TaskID, p.Number), p)
+}
+
+func (ds *BoltDatastore) CreateJob(ctx context.Context, j *tork.Job) error {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	if err := ds.InMemoryDatastore.CreateJob(ctx, j); err != nil {
+		return err
+	}
+	return ds.putJob(j)
+}
+
+func (ds *BoltDatastore) UpdateJob(ctx context.Context, id string, modify func(u *tork.Job) error) error {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	var updated *tork.Job
+	if err := ds.InMemoryDatastore.UpdateJob(ctx, id, func(u *tork.Job) error {
+		if err := modify(u); err != nil {
+			return err
+		}
+		updated = u.Clone()
+		return nil
+	}); err != nil {
+		return err
+	}
+	return ds.putJob(updated)
+}
+
+func (ds *BoltDatastore) CreateUser(ctx context.Context, u *tork.User) error {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	if err := ds.InMemoryDatastore.CreateUser(ctx, u); err != nil {
+		return err
+	}
+	return ds.put(bucketUsers, u.ID, userRecord{User: u.Clone(), PasswordHash: u.PasswordHash})
+}
+
+func (ds *BoltDatastore) CreateRole(ctx context.Context, r *tork.Role) error {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	if err := ds.InMemoryDatastore.CreateRole(ctx, r); err != nil {
+		return err
+	}
+	return ds.put(bucketRoles, r.ID, r)
+}
+
+func (ds *BoltDatastore) AssignRole(ctx context.Context, userID, roleID string) error {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	if err := ds.InMemoryDatastore.AssignRole(ctx, userID, roleID); err != nil {
+		return err
+	}
+	return ds.putUserRoles(ctx, userID)
+}
+
+func (ds *BoltDatastore) UnassignRole(ctx context.Context, userID, roleID string) error {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	if err := ds.InMemoryDatastore.UnassignRole(ctx, userID, roleID); err != nil {
+		return err
+	}
+	return ds.putUserRoles(ctx, userID)
+}
+
+func (ds *BoltDatastore) putUserRoles(ctx context.Context, userID string) error {
+	roles, err := ds.InMemoryDatastore.GetUserRoles(ctx, userID)
+	if err != nil {
+		return err
+	}
+	roleIDs := make([]string, len(roles))
+	for i, r := range roles {
+		roleIDs[i] = r.ID
+	}
+	return ds.put(bucketUserRoles, userID, roleIDs)
+}
+
+func (ds *BoltDatastore) CreateAPIKey(ctx context.Context, k *tork.APIKey) error {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	if err := ds.InMemoryDatastore.CreateAPIKey(ctx, k); err != nil {
+		return err
+	}
+	return ds.put(bucketAPIKeys, k.ID, apiKeyRecord{APIKey: k, KeyHash: k.KeyHash})
+}
+
+func (ds *BoltDatastore) DeleteAPIKey(ctx context.Context, id string) error {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	if err := ds.InMemoryDatastore.DeleteAPIKey(ctx, id); err != nil {
+		return err
+	}
+	return ds.delete(bucketAPIKeys, id)
+}
+
+func (ds *BoltDatastore) CreateScheduledJob(ctx context.Context, sj *tork.ScheduledJob) error {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	if err := ds.InMemoryDatastore.CreateScheduledJob(ctx, sj); err != nil {
+		return err
+	}
+	return ds.put(bucketScheduledJobs, sj.ID, sj)
+}
+
+func (ds *BoltDatastore) UpdateScheduledJob(ctx context.Context, id string, modify func(u *tork.ScheduledJob) error) error {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	var updated *tork.ScheduledJob
+	if err := ds.InMemoryDatastore.UpdateScheduledJob(ctx, id, func(u *tork.ScheduledJob) error {
+		if err := modify(u); err != nil {
+			return err
+		}
+		updated = u.Clone()
+		return nil
+	}); err != nil {
+		return err
+	}
+	return ds.put(bucketScheduledJobs, id, updated)
+}
+
+func (ds *BoltDatastore) DeleteScheduledJob(ctx context.Context, id string) error {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	if err := ds.InMemoryDatastore.DeleteScheduledJob(ctx, id); err != nil {
+		return err
+	}
+	return ds.delete(bucketScheduledJobs, id)
+}
+
+func (ds *BoltDatastore) SetSecret(ctx context.Context, s *tork.Secret) error {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	if err := ds.InMemoryDatastore.SetSecret(ctx, s); err != nil {
+		return err
+	}
+	return ds.put(bucketSecrets, s.Name, s)
+}
+
+func (ds *BoltDatastore) DeleteSecret(ctx context.Context, name string) error {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	if err := ds.InMemoryDatastore.DeleteSecret(ctx, name); err != nil {
+		return err
+	}
+	return ds.delete(bucketSecrets, name)
+}
+
+func (ds *BoltDatastore) CreateDeadLetter(ctx context.Context, dl *tork.DeadLetter) error {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	if err := ds.InMemoryDatastore.CreateDeadLetter(ctx, dl); err != nil {
+		return err
+	}
+	return ds.put(bucketDeadLetters, dl.ID, dl)
+}
+
+func (ds *BoltDatastore) DeleteDeadLetter(ctx context.Context, id string) error {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	if err := ds.InMemoryDatastore.DeleteDeadLetter(ctx, id); err != nil {
+		return err
+	}
+	return ds.delete(bucketDeadLetters, id)
+}
+
+func (ds *BoltDatastore) WithTx(ctx context.Context, f func(tx datastore.Datastore) error) error {
+	return f(ds)
+}
+
+func (ds *BoltDatastore) HealthCheck(ctx context.Context) error {
+	return ds.db.View(func(tx *bbolt.Tx) error {
+		return nil
+	})
+}
+
+// Close releases the database file.
+func (ds *BoltDatastore) Close() error {
+	return ds.db.Close()
+}
@@ -0,0 +1,215 @@
+package bolt_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/runabol/tork"
+	"github.com/runabol/tork/datastore"
+	"github.com/runabol/tork/datastore/bolt"
+	"github.com/runabol/tork/internal/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestBoltDatastore(t *testing.T, path string) *bolt.BoltDatastore {
+	ds, err := bolt.NewBoltDatastore(path)
+	assert.NoError(t, err)
+	return ds
+}
+
+func TestBoltJobSurvivesRestart(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "tork.db")
+	ds := newTestBoltDatastore(t, path)
+	now := time.Now().UTC()
+	j1 := &tork.Job{
+		ID:              uuid.NewUUID(),
+		Name:            "test job",
+		State:           tork.JobStateRunning,
+		CreatedAt:       now,
+		IdempotencyKey:  "some-key",
+		IdempotencyHash: "1234",
+	}
+	assert.NoError(t, ds.CreateJob(ctx, j1))
+	t1 := &tork.Task{
+		ID:        uuid.NewUUID(),
+		JobID:     j1.ID,
+		State:     tork.TaskStateRunning,
+		CreatedAt: &now,
+	}
+	assert.NoError(t, ds.CreateTask(ctx, t1))
+	assert.NoError(t, ds.CreateTaskLogPart(ctx, &tork.TaskLogPart{TaskID: t1.ID, Number: 1, Contents: "line 1"}))
+	assert.NoError(t, ds.CreateTaskLogPart(ctx, &tork.TaskLogPart{TaskID: t1.ID, Number: 2, Contents: "line 2"}))
+	assert.NoError(t, ds.UpdateTask(ctx, t1.ID, func(u *tork.Task) error {
+		u.State = tork.TaskStateCompleted
+		return nil
+	}))
+	assert.NoError(t, ds.UpdateJob(ctx, j1.ID, func(u *tork.Job) error {
+		u.State = tork.JobStateCompleted
+		return nil
+	}))
+	assert.NoError(t, ds.Close())
+
+	ds = newTestBoltDatastore(t, path)
+	j2, err := ds.GetJobByID(ctx, j1.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, "test job", j2.Name)
+	assert.Equal(t, tork.JobStateCompleted, j2.State)
+	assert.Len(t, j2.Execution, 1)
+	assert.Equal(t, tork.TaskStateCompleted, j2.Execution[0].State)
+	j3, err := ds.GetJobByIdempotencyKey(ctx, tork.USER_GUEST, "some-key")
+	assert.NoError(t, err)
+	assert.Equal(t, "1234", j3.IdempotencyHash)
+	parts, err := ds.GetTaskLogParts(ctx, t1.ID, 1, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, parts.TotalItems)
+	assert.Equal(t, "line 2", parts.Items[0].Contents)
+	page, err := ds.ListJobs(ctx, "", datastore.JobQuery{Page: 1, Size: 10})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, page.TotalItems)
+	assert.NoError(t, ds.Close())
+}
+
+func TestBoltUsersSurviveRestart(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "tork.db")
+	ds := newTestBoltDatastore(t, path)
+	roles, err := ds.GetRoles(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, roles, 4)
+	u1 := &tork.User{
+		ID:           uuid.NewUUID(),
+		Username:     "someuser",
+		Name:         "Some User",
+		PasswordHash: "some-hash",
+	}
+	assert.NoError(t, ds.CreateUser(ctx, u1))
+	admin, err := ds.GetRole(ctx, tork.ROLE_ADMIN)
+	assert.NoError(t, err)
+	assert.NoError(t, ds.AssignRole(ctx, u1.ID, admin.ID))
+	k1 := &tork.APIKey{Name: "some key", KeyHash: "some-key-hash", CreatedBy: u1.ID}
+	assert.NoError(t, ds.CreateAPIKey(ctx, k1))
+	k2 := &tork.APIKey{Name: "other key", KeyHash: "other-key-hash", CreatedBy: u1.ID}
+	assert.NoError(t, ds.CreateAPIKey(ctx, k2))
+	assert.NoError(t, ds.DeleteAPIKey(ctx, k2.ID))
+	assert.NoError(t, ds.Close())
+
+	ds = newTestBoltDatastore(t, path)
+	// the roles keep their ids
+	roles2, err := ds.GetRoles(ctx)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, roles, roles2)
+	u2, err := ds.GetUser(ctx, "someuser")
+	assert.NoError(t, err)
+	assert.Equal(t, u1.ID, u2.ID)
+	assert.Equal(t, "some-hash", u2.PasswordHash)
+	uroles, err := ds.GetUserRoles(ctx, u1.ID)
+	assert.NoError(t, err)
+	assert.Len(t, uroles, 1)
+	assert.Equal(t, tork.ROLE_ADMIN, uroles[0].Slug)
+	k3, err := ds.GetAPIKey(ctx, "some-key-hash")
+	assert.NoError(t, err)
+	assert.Equal(t, k1.ID, k3.ID)
+	keys, err := ds.GetAPIKeys(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, keys, 1)
+	assert.NoError(t, ds.Close())
+}
+
+func TestBoltSecretsSurviveRestart(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "tork.db")
+	ds := newTestBoltDatastore(t, path)
+	assert.NoError(t, ds.SetSecret(ctx, &tork.Secret{Name: "SOME_SECRET", Value: "some value"}))
+	assert.NoError(t, ds.SetSecret(ctx, &tork.Secret{Name: "OTHER_SECRET", Value: "other value"}))
+	assert.NoError(t, ds.DeleteSecret(ctx, "OTHER_SECRET"))
+	assert.NoError(t, ds.Close())
+
+	ds = newTestBoltDatastore(t, path)
+	secrets, err := ds.GetSecrets(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, secrets, 1)
+	assert.Equal(t, "some value", secrets[0].Value)
+	assert.NoError(t, ds.Close())
+}
+
+func TestBoltDeadLettersSurviveRestart(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "tork.db")
+	ds := newTestBoltDatastore(t, path)
+	dl1 := &tork.DeadLetter{ID: uuid.NewUUID(), Queue: "default", Task: &tork.Task{ID: uuid.NewUUID()}, Error: "something bad happened"}
+	assert.NoError(t, ds.CreateDeadLetter(ctx, dl1))
+	dl2 := &tork.DeadLetter{ID: uuid.NewUUID(), Queue: "default", Task: &tork.Task{ID: uuid.NewUUID()}}
+	assert.NoError(t, ds.CreateDeadLetter(ctx, dl2))
+	assert.NoError(t, ds.DeleteDeadLetter(ctx, dl2.ID))
+	assert.NoError(t, ds.Close())
+
+	ds = newTestBoltDatastore(t, path)
+	dl3, err := ds.GetDeadLetterByID(ctx, dl1.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, dl1.Task.ID, dl3.Task.ID)
+	assert.Equal(t, "something bad happened", dl3.Error)
+	_, err = ds.GetDeadLetterByID(ctx, dl2.ID)
+	assert.ErrorIs(t, err, datastore.ErrDeadLetterNotFound)
+	assert.NoError(t, ds.Close())
+}
+
+func TestBoltScheduledJobsSurviveRestart(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "tork.db")
+	ds := newTestBoltDatastore(t, path)
+	sj1 := &tork.ScheduledJob{ID: uuid.NewUUID(), Cron: "* * * * *", State: tork.ScheduledJobStateActive}
+	assert.NoError(t, ds.CreateScheduledJob(ctx, sj1))
+	assert.NoError(t, ds.UpdateScheduledJob(ctx, sj1.ID, func(u *tork.ScheduledJob) error {
+		u.State = tork.ScheduledJobStatePaused
+		return nil
+	}))
+	sj2 := &tork.ScheduledJob{ID: uuid.NewUUID(), Cron: "* * * * *"}
+	assert.NoError(t, ds.CreateScheduledJob(ctx, sj2))
+	assert.NoError(t, ds.DeleteScheduledJob(ctx, sj2.ID))
+	assert.NoError(t, ds.Close())
+
+	ds = newTestBoltDatastore(t, path)
+	sjs, err := ds.GetScheduledJobs(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, sjs, 1)
+	assert.Equal(t, sj1.ID, sjs[0].ID)
+	assert.Equal(t, tork.ScheduledJobStatePaused, sjs[0].State)
+	assert.NoError(t, ds.Close())
+}
+
+func TestBoltWithTx(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "tork.db")
+	ds := newTestBoltDatastore(t, path)
+	j1 := &tork.Job{ID: uuid.NewUUID()}
+	err := ds.WithTx(ctx, func(tx datastore.Datastore) error {
+		return tx.CreateJob(ctx, j1)
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, ds.Close())
+
+	ds = newTestBoltDatastore(t, path)
+	_, err = ds.GetJobByID(ctx, j1.ID)
+	assert.NoError(t, err)
+	assert.NoError(t, ds.Close())
+}
+
+func TestBoltLocked(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tork.db")
+	ds := newTestBoltDatastore(t, path)
+	// the file is held by one datastore at a time
+	_, err := bolt.NewBoltDatastore(path, bolt.WithOpenTimeout(time.Millisecond*100))
+	assert.Error(t, err)
+	assert.NoError(t, ds.Close())
+}
+
+func TestBoltHealthCheck(t *testing.T) {
+	ctx := context.Background()
+	ds := newTestBoltDatastore(t, filepath.Join(t.TempDir(), "tork.db"))
+	assert.NoError(t, ds.HealthCheck(ctx))
+	assert.NoError(t, ds.Close())
+	assert.Error(t, ds.HealthCheck(ctx))
+}
@@ -2,6 +2,7 @@ package datastore
 
 import (
 	"context"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/runabol/tork"
@@ -10,24 +11,38 @@ import (
 type Provider func() (Datastore, error)
 
 var (
-	ErrTaskNotFound    = errors.New("task not found")
-	ErrNodeNotFound    = errors.New("node not found")
-	ErrJobNotFound     = errors.New("job not found")
-	ErrUserNotFound    = errors.New("user not found")
-	ErrRoleNotFound    = errors.New("role not found")
-	ErrContextNotFound = errors.New("context not found")
+	ErrTaskNotFound         = errors.New("task not found")
+	ErrNodeNotFound         = errors.New("node not found")
+	ErrJobNotFound          = errors.New("job not found")
+	ErrUserNotFound         = errors.New("user not found")
+	ErrRoleNotFound         = errors.New("role not found")
+	ErrContextNotFound      = errors.New("context not found")
+	ErrAPIKeyNotFound       = errors.New("api key not found")
+	ErrInvalidCursor        = errors.New("invalid cursor")
+	ErrScheduledJobNotFound = errors.New("scheduled job not found")
+	ErrSecretNotFound       = errors.New("secret not found")
+	ErrDeadLetterNotFound   = errors.New("dead letter not found")
 )
 
 const (
 	DATASTORE_INMEMORY = "inmemory"
 	DATASTORE_POSTGRES = "postgres"
+	DATASTORE_BOLT     = "bolt"
 )
 
+// DefaultIdempotencyKeyRetention is how long the idempotency
+// key of a job is honored after the job was submitted. After
+// that the key can be used to submit a new job.
+var DefaultIdempotencyKeyRetention = time.Hour * 24
+
 type Datastore interface {
 	CreateTask(ctx context.Context, t *tork.Task) error
 	UpdateTask(ctx context.Context, id string, modify func(u *tork.Task) error) error
 	GetTaskByID(ctx context.Context, id string) (*tork.Task, error)
 	GetActiveTasks(ctx context.Context, jobID string) ([]*tork.Task, error)
+	GetNextTask(ctx context.Context, parentTaskID string) (*tork.Task, error)
+	GetStalledTasks(ctx context.Context, heartbeatBefore time.Time) ([]*tork.Task, error)
+	GetDueRetries(ctx context.Context, before time.Time) ([]*tork.Task, error)
 	CreateTaskLogPart(ctx context.Context, p *tork.TaskLogPart) error
 	GetTaskLogParts(ctx context.Context, taskID string, page, size int) (*Page[*tork.TaskLogPart], error)
 
@@ -39,8 +54,11 @@ type Datastore interface {
 	CreateJob(ctx context.Context, j *tork.Job) error
 	UpdateJob(ctx context.Context, id string, modify func(u *tork.Job) error) error
 	GetJobByID(ctx context.Context, id string) (*tork.Job, error)
+	GetJobByIdempotencyKey(ctx context.Context, userID, key string) (*tork.Job, error)
+	GetTimedOutJobs(ctx context.Context, before time.Time) ([]*tork.Job, error)
 	GetJobLogParts(ctx context.Context, jobID string, page, size int) (*Page[*tork.TaskLogPart], error)
 	GetJobs(ctx context.Context, currentUser, q string, page, size int) (*Page[*tork.JobSummary], error)
+	ListJobs(ctx context.Context, currentUser string, q JobQuery) (*Page[*tork.JobSummary], error)
 
 	CreateUser(ctx context.Context, u *tork.User) error
 	GetUser(ctx context.Context, username string) (*tork.User, error)
@@ -52,8 +70,34 @@ type Datastore interface {
 	AssignRole(ctx context.Context, userID, roleID string) error
 	UnassignRole(ctx context.Context, userID, roleID string) error
 
+	CreateAPIKey(ctx context.Context, k *tork.APIKey) error
+	GetAPIKey(ctx context.Context, keyHash string) (*tork.APIKey, error)
+	GetAPIKeys(ctx context.Context) ([]*tork.APIKey, error)
+	DeleteAPIKey(ctx context.Context, id string) error
+
+	CreateScheduledJob(ctx context.Context, sj *tork.ScheduledJob) error
+	UpdateScheduledJob(ctx context.Context, id string, modify func(u *tork.ScheduledJob) error) error
+	GetScheduledJobByID(ctx context.Context, id string) (*tork.ScheduledJob, error)
+	GetScheduledJobs(ctx context.Context) ([]*tork.ScheduledJob, error)
+	DeleteScheduledJob(ctx context.Context, id string) error
+
+	SetSecret(ctx context.Context, s *tork.Secret) error
+	GetSecret(ctx context.Context, name string) (*tork.Secret, error)
+	GetSecrets(ctx context.Context) ([]*tork.Secret, error)
+	DeleteSecret(ctx context.Context, name string) error
+
+	CreateDeadLetter(ctx context.Context, dl *tork.DeadLetter) error
+	GetDeadLetterByID(ctx context.Context, id string) (*tork.DeadLetter, error)
+	GetDeadLetters(ctx context.Context, page, size int) (*Page[*tork.DeadLetter], error)
+	DeleteDeadLetter(ctx context.Context, id string) error
+
 	GetMetrics(ctx context.Context) (*tork.Metrics, error)
 
+	// AcquireLease acquires or renews the named lease on behalf of
+	// the holder, returning false while another holder owns it.
+	AcquireLease(ctx context.Context, name, holder string, ttl time.Duration) (bool, error)
+	ReleaseLease(ctx context.Context, name, holder string) error
+
 	WithTx(ctx context.Context, f func(tx Datastore) error) error
 
 	HealthCheck(ctx context.Context) error
@@ -65,4 +109,7 @@ type Page[T any] struct {
 	Size       int `json:"size"`
 	TotalPages int `json:"totalPages"`
 	TotalItems int `json:"totalItems"`
+	// NextCursor, when set, fetches the items
+	// following this page in the same order.
+	NextCursor string `json:"nextCursor,omitempty"`
 }
@@ -18,9 +18,9 @@ import (
 )
 
 const (
-	defaultNodeExpiration  = time.Minute * 10
-	defaultCleanupInterval = time.Minute * 10
-	defaultJobExpiration   = time.Hour
+	DefaultNodeExpiration  = time.Minute * 10
+	DefaultCleanupInterval = time.Minute * 10
+	DefaultJobExpiration   = time.Hour
 )
 
 var guestUser = &tork.User{
@@ -38,11 +38,20 @@ type InMemoryDatastore struct {
 	usersByUsername *cache.Cache[*tork.User]
 	roles           *cache.Cache[*tork.Role]
 	userRoles       *cache.Cache[[]*tork.UserRole]
+	apiKeys         *cache.Cache[*tork.APIKey]
+	secrets         *cache.Cache[*tork.Secret]
+	deadLetters     *cache.Cache[*tork.DeadLetter]
+	scheduledJobs   *cache.Cache[*tork.ScheduledJob]
 	logs            *cache.Cache[[]*tork.TaskLogPart]
 	logsMu          sync.RWMutex
+	leases          map[string]lease
+	leasesMu        sync.Mutex
+	jobsMu          sync.Mutex
 	nodeExpiration  *time.Duration
 	jobExpiration   *time.Duration
 	cleanupInterval *time.Duration
+	keyRetention    time.Duration
+	seedRoles       []*tork.Role
 }
 
 type Option = func(ds *InMemoryDatastore)
@@ -58,23 +67,42 @@ func WithJobExpiration(exp time.Duration) Option {
 		ds.jobExpiration = &exp
 	}
 }
+
+// WithIdempotencyKeyRetention sets how long the
+// idempotency key of a job is honored.
+func WithIdempotencyKeyRetention(d time.Duration) Option {
+	return func(ds *InMemoryDatastore) {
+		ds.keyRetention = d
+	}
+}
+
 func WithCleanupInterval(ci time.Duration) Option {
 	return func(ds *InMemoryDatastore) {
 		ds.cleanupInterval = &ci
 	}
 }
 
+// WithRoles seeds the datastore with the given
+// roles instead of the default ones.
+func WithRoles(roles ...*tork.Role) Option {
+	return func(ds *InMemoryDatastore) {
+		ds.seedRoles = roles
+	}
+}
+
 func NewInMemoryDatastore(opts ...Option) *InMemoryDatastore {
-	ds := &InMemoryDatastore{}
+	ds := &InMemoryDatastore{
+		keyRetention: datastore.DefaultIdempotencyKeyRetention,
+	}
 	for _, opt := range opts {
 		opt(ds)
 	}
-	ci := defaultCleanupInterval
+	ci := DefaultCleanupInterval
 	if ds.cleanupInterval != nil {
 		ci = *ds.cleanupInterval
 	}
 	ds.tasks = cache.New[*tork.Task](cache.NoExpiration, ci)
-	nodeExp := defaultNodeExpiration
+	nodeExp := DefaultNodeExpiration
 	if ds.nodeExpiration != nil {
 		nodeExp = *ds.nodeExpiration
 	}
@@ -85,10 +113,35 @@ func NewInMemoryDatastore(opts ...Option) *InMemoryDatastore {
 	ds.usersByUsername = cache.New[*tork.User](cache.NoExpiration, ci)
 	ds.roles = cache.New[*tork.Role](cache.NoExpiration, ci)
 	ds.userRoles = cache.New[[]*tork.UserRole](cache.NoExpiration, ci)
+	ds.apiKeys = cache.New[*tork.APIKey](cache.NoExpiration, ci)
+	ds.secrets = cache.New[*tork.Secret](cache.NoExpiration, ci)
+	ds.deadLetters = cache.New[*tork.DeadLetter](cache.NoExpiration, ci)
+	ds.scheduledJobs = cache.New[*tork.ScheduledJob](cache.NoExpiration, ci)
+	ds.leases = make(map[string]lease)
 	ds.jobs.OnEvicted(ds.onJobEviction)
+	if ds.seedRoles == nil {
+		// the same roles the postgres
+		// schema comes seeded with
+		ds.seedRoles = []*tork.Role{
+			{Slug: tork.ROLE_PUBLIC, Name: "Public"},
+			{Slug: tork.ROLE_SUBMITTER, Name: "Submitter"},
+			{Slug: tork.ROLE_OPERATOR, Name: "Operator"},
+			{Slug: tork.ROLE_ADMIN, Name: "Admin"},
+		}
+	}
+	for _, r := range ds.seedRoles {
+		if err := ds.CreateRole(context.Background(), r); err != nil {
+			panic(err)
+		}
+	}
 	return ds
 }
 
+type lease struct {
+	holder    string
+	expiresAt time.Time
+}
+
 func (ds *InMemoryDatastore) CreateTask(ctx context.Context, t *tork.Task) error {
 	if t.ID == "" {
 		return errors.New("must provide ID")
@@ -133,13 +186,17 @@ func (ds *InMemoryDatastore) UpdateNode(ctx context.Context, id string, modify f
 	if !ok {
 		return datastore.ErrNodeNotFound
 	}
-	return ds.nodes.Modify(id, func(n *tork.Node) (*tork.Node, error) {
+	if err := ds.nodes.Modify(id, func(n *tork.Node) (*tork.Node, error) {
 		update := n.Clone()
 		if err := modify(update); err != nil {
 			return nil, errors.Wrapf(err, "error modifying node %s", id)
 		}
 		return update, nil
-	})
+	}); err != nil {
+		return err
+	}
+	// keep the nodes we're still hearing from
+	return ds.nodes.SetExpiration(id, cache.DefaultExpiration)
 }
 
 func (ds *InMemoryDatastore) GetNodeByID(ctx context.Context, id string) (*tork.Node, error) {
@@ -173,10 +230,36 @@ func (ds *InMemoryDatastore) CreateJob(ctx context.Context, j *tork.Job) error {
 	if j.CreatedBy == nil {
 		j.CreatedBy = guestUser
 	}
+	ds.jobsMu.Lock()
+	defer ds.jobsMu.Unlock()
+	if j.IdempotencyKey != "" {
+		if _, err := ds.GetJobByIdempotencyKey(ctx, j.CreatedBy.ID, j.IdempotencyKey); err == nil {
+			return errors.Errorf("job with idempotency key %s already exists", j.IdempotencyKey)
+		}
+	}
 	ds.jobs.Set(j.ID, j.Clone())
 	return nil
 }
 
+func (ds *InMemoryDatastore) GetJobByIdempotencyKey(ctx context.Context, userID, key string) (*tork.Job, error) {
+	cutoff := time.Now().UTC().Add(-ds.keyRetention)
+	var id string
+	ds.jobs.Iterate(func(_ string, j *tork.Job) {
+		if j.IdempotencyKey != key || j.CreatedBy == nil || j.CreatedBy.ID != userID {
+			return
+		}
+		if !j.CreatedAt.IsZero() && j.CreatedAt.Before(cutoff) {
+			// the key expired
+			return
+		}
+		id = j.ID
+	})
+	if id == "" {
+		return nil, datastore.ErrJobNotFound
+	}
+	return ds.GetJobByID(ctx, id)
+}
+
 func (ds *InMemoryDatastore) UpdateJob(ctx context.Context, id string, modify func(u *tork.Job) error) error {
 	_, ok := ds.jobs.Get(id)
 	if !ok {
@@ -200,14 +283,17 @@ func (ds *InMemoryDatastore) UpdateJob(ctx context.Context, id string, modify fu
 		return datastore.ErrJobNotFound
 	}
 
-	if j.State == tork.JobStateCompleted || j.State == tork.JobStateFailed {
-		exp := defaultJobExpiration
+	// finished jobs expire, unless they are restarted
+	exp := cache.NoExpiration
+	switch j.State {
+	case tork.JobStateCompleted, tork.JobStateFailed, tork.JobStateCancelled:
+		exp = DefaultJobExpiration
 		if ds.jobExpiration != nil {
 			exp = *ds.jobExpiration
 		}
-		if err := ds.jobs.SetExpiration(j.ID, exp); err != nil {
-			return errors.Wrap(err, "error modifying job expiration")
-		}
+	}
+	if err := ds.jobs.SetExpiration(j.ID, exp); err != nil {
+		return errors.Wrap(err, "error modifying job expiration")
 	}
 
 	return nil
@@ -260,7 +346,46 @@ func (ds *InMemoryDatastore) GetActiveTasks(ctx context.Context, jobID string) (
 	return result, nil
 }
 
+func (ds *InMemoryDatastore) GetNextTask(ctx context.Context, parentTaskID string) (*tork.Task, error) {
+	// pick the first task in order of position
+	// and creation, as the postgres datastore does
+	var next *tork.Task
+	ds.tasks.Iterate(func(_ string, t *tork.Task) {
+		if t.ParentID != parentTaskID || t.State != tork.TaskStateCreated {
+			return
+		}
+		if next == nil || t.Position < next.Position ||
+			(t.Position == next.Position && createdBefore(t, next)) {
+			next = t
+		}
+	})
+	if next == nil {
+		return nil, datastore.ErrTaskNotFound
+	}
+	return next.Clone(), nil
+}
+
+// namespaceOf returns the namespace of the job, which is
+// the default one for jobs submitted before namespaces.
+func namespaceOf(j *tork.Job) string {
+	if j.Namespace == "" {
+		return tork.NAMESPACE_DEFAULT
+	}
+	return j.Namespace
+}
+
+func createdBefore(a, b *tork.Task) bool {
+	if a.CreatedAt == nil || b.CreatedAt == nil {
+		return b.CreatedAt != nil
+	}
+	return a.CreatedAt.Before(*b.CreatedAt)
+}
+
 func (ds *InMemoryDatastore) GetJobs(ctx context.Context, currentUser, q string, page, size int) (*datastore.Page[*tork.JobSummary], error) {
+	return ds.ListJobs(ctx, currentUser, datastore.JobQuery{Q: q, Page: page, Size: size, Desc: true})
+}
+
+func (ds *InMemoryDatastore) ListJobs(ctx context.Context, currentUser string, q datastore.JobQuery) (*datastore.Page[*tork.JobSummary], error) {
 	parseQuery := func(query string) (string, []string) {
 		terms := []string{}
 		tags := []string{}
@@ -269,7 +394,7 @@ func (ds *InMemoryDatastore) GetJobs(ctx context.Context, currentUser, q string,
 			if strings.HasPrefix(part, "tag:") {
 				tags = append(tags, strings.TrimPrefix(part, "tag:"))
 			} else if strings.HasPrefix(part, "tags:") {
-				tags = append(tags, strings.Split(strings.TrimPrefix(part, "tag:"), ",")...)
+				tags = append(tags, strings.Split(strings.TrimPrefix(part, "tags:"), ",")...)
 			} else {
 				terms = append(terms, part)
 			}
@@ -292,8 +417,60 @@ func (ds *InMemoryDatastore) GetJobs(ctx context.Context, currentUser, q string,
 		urs = ur
 	}
 
-	searchTerm, tags := parseQuery(q)
-	offset := (page - 1) * size
+	var cursor *datastore.JobCursor
+	if q.Cursor != "" {
+		c, err := datastore.ParseJobCursor(q)
+		if err != nil {
+			return nil, err
+		}
+		cursor = c
+	}
+
+	searchTerm, tags := parseQuery(q.Q)
+	// the full-text search matches when all of its words are found
+	// in the job or in one of its tasks, e.g. in their errors
+	search := strings.Fields(strings.ToLower(q.Search))
+	var tasks map[string][]*tork.Task
+	if len(search) > 0 {
+		tasks = make(map[string][]*tork.Task)
+		ds.tasks.Iterate(func(_ string, t *tork.Task) {
+			tasks[t.JobID] = append(tasks[t.JobID], t)
+		})
+	}
+	containsAll := func(texts ...string) bool {
+		text := strings.ToLower(strings.Join(texts, " "))
+		for _, w := range search {
+			if !strings.Contains(text, w) {
+				return false
+			}
+		}
+		return true
+	}
+	searchMatches := func(j *tork.Job) bool {
+		if containsAll(j.Name, j.Description, string(j.State), j.Error, j.Result) {
+			return true
+		}
+		for _, t := range tasks[j.ID] {
+			if containsAll(t.Name, t.Error, t.Result) {
+				return true
+			}
+			if !q.SearchLogs {
+				continue
+			}
+			parts, _ := ds.logs.Get(t.ID)
+			for _, p := range parts {
+				if containsAll(p.Contents) {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	page := q.Page
+	if page < 1 || cursor != nil {
+		page = 1
+	}
+	offset := (page - 1) * q.Size
 	filtered := make([]*tork.Job, 0)
 	hasPermission := func(user *tork.User, uroles []*tork.Role, job *tork.Job) bool {
 		if len(job.Permissions) == 0 {
@@ -313,33 +490,87 @@ func (ds *InMemoryDatastore) GetJobs(ctx context.Context, currentUser, q string,
 		}
 		return false
 	}
+	matches := func(j *tork.Job) bool {
+		if searchTerm != "" &&
+			!strings.Contains(strings.ToLower(j.Name), strings.ToLower(searchTerm)) &&
+			!strings.Contains(strings.ToLower(string(j.State)), strings.ToLower(searchTerm)) {
+			return false
+		}
+		if len(tags) > 0 && !slices.Intersect(j.Tags, tags) {
+			return false
+		}
+		if len(search) > 0 && !searchMatches(j) {
+			return false
+		}
+		if q.Name != "" && !strings.Contains(strings.ToLower(j.Name), strings.ToLower(q.Name)) {
+			return false
+		}
+		if len(q.Namespaces) > 0 && !slices.Intersect(q.Namespaces, []string{namespaceOf(j)}) {
+			return false
+		}
+		if len(q.States) > 0 && !slices.Intersect(q.States, []tork.JobState{j.State}) {
+			return false
+		}
+		if q.CreatedAfter != nil && j.CreatedAt.Before(*q.CreatedAfter) {
+			return false
+		}
+		if q.CreatedBefore != nil && !j.CreatedAt.Before(*q.CreatedBefore) {
+			return false
+		}
+		for _, l := range q.Labels {
+			if !l.Matches(j.Tags) {
+				return false
+			}
+		}
+		return true
+	}
+	// compare orders two jobs by the sort
+	// key, breaking ties with their IDs
+	compare := func(a, b *tork.Job) int {
+		var c int
+		if q.Sort == datastore.JOBS_SORT_NAME {
+			c = strings.Compare(a.Name, b.Name)
+		} else {
+			c = a.CreatedAt.Compare(b.CreatedAt)
+		}
+		if c == 0 {
+			c = strings.Compare(a.ID, b.ID)
+		}
+		if q.Desc {
+			return -c
+		}
+		return c
+	}
 	ds.jobs.Iterate(func(_ string, j *tork.Job) {
 		if currentUser != "" && !hasPermission(user, urs, j) {
 			return
 		}
-		if searchTerm != "" {
-			if strings.Contains(strings.ToLower(j.Name), strings.ToLower(searchTerm)) ||
-				strings.Contains(strings.ToLower(string(j.State)), strings.ToLower(searchTerm)) {
-				filtered = append(filtered, j)
-			}
-		} else if len(tags) > 0 {
-			if slices.Intersect(j.Tags, tags) {
-				filtered = append(filtered, j)
-			}
-		} else {
+		if matches(j) {
 			filtered = append(filtered, j)
 		}
 	})
 	sort.Slice(filtered, func(i, j int) bool {
-		return filtered[i].CreatedAt.After(filtered[j].CreatedAt)
+		return compare(filtered[i], filtered[j]) < 0
 	})
+	total := len(filtered)
+	if cursor != nil {
+		last := &tork.Job{ID: cursor.ID, Name: cursor.Value, CreatedAt: cursor.CreatedAt()}
+		i := sort.Search(len(filtered), func(i int) bool {
+			return compare(filtered[i], last) > 0
+		})
+		filtered = filtered[i:]
+	}
 	result := make([]*tork.JobSummary, 0)
-	for i := offset; i < (offset+size) && i < len(filtered); i++ {
+	var next string
+	for i := offset; i < (offset+q.Size) && i < len(filtered); i++ {
 		j := filtered[i]
 		result = append(result, tork.NewJobSummary(j))
+		if i == offset+q.Size-1 && i < len(filtered)-1 {
+			next = datastore.NewJobCursor(q, j)
+		}
 	}
-	totalPages := len(filtered) / size
-	if len(filtered)%size != 0 {
+	totalPages := total / q.Size
+	if total%q.Size != 0 {
 		totalPages = totalPages + 1
 	}
 	return &datastore.Page[*tork.JobSummary]{
@@ -347,10 +578,50 @@ func (ds *InMemoryDatastore) GetJobs(ctx context.Context, currentUser, q string,
 		Number:     page,
 		Size:       len(result),
 		TotalPages: totalPages,
-		TotalItems: len(filtered),
+		TotalItems: total,
+		NextCursor: next,
 	}, nil
 }
 
+func (ds *InMemoryDatastore) GetTimedOutJobs(ctx context.Context, before time.Time) ([]*tork.Job, error) {
+	result := make([]*tork.Job, 0)
+	ds.jobs.Iterate(func(_ string, j *tork.Job) {
+		if j.State != tork.JobStateScheduled && j.State != tork.JobStateRunning {
+			return
+		}
+		if j.TimeoutAt != nil && j.TimeoutAt.Before(before) {
+			result = append(result, j.Clone())
+		}
+	})
+	return result, nil
+}
+
+func (ds *InMemoryDatastore) GetStalledTasks(ctx context.Context, heartbeatBefore time.Time) ([]*tork.Task, error) {
+	result := make([]*tork.Task, 0)
+	ds.tasks.Iterate(func(_ string, t *tork.Task) {
+		if t.State != tork.TaskStateRunning || t.NodeID == "" {
+			return
+		}
+		n, ok := ds.nodes.Get(t.NodeID)
+		if ok && n.LastHeartbeatAt.Before(heartbeatBefore) {
+			result = append(result, t.Clone())
+		} else if !ok && t.StartedAt != nil && t.StartedAt.Before(heartbeatBefore) {
+			result = append(result, t.Clone())
+		}
+	})
+	return result, nil
+}
+
+func (ds *InMemoryDatastore) GetDueRetries(ctx context.Context, before time.Time) ([]*tork.Task, error) {
+	result := make([]*tork.Task, 0)
+	ds.tasks.Iterate(func(_ string, t *tork.Task) {
+		if t.State == tork.TaskStatePending && t.RetryAt != nil && !t.RetryAt.After(before) {
+			result = append(result, t.Clone())
+		}
+	})
+	return result, nil
+}
+
 func (ds *InMemoryDatastore) CreateTaskLogPart(ctx context.Context, p *tork.TaskLogPart) error {
 	if p.TaskID == "" {
 		return errors.Errorf("must provide task id")
@@ -358,8 +629,10 @@ func (ds *InMemoryDatastore) CreateTaskLogPart(ctx context.Context, p *tork.Task
 	if p.Number < 1 {
 		return errors.Errorf("part number must be > 0")
 	}
-	now := time.Now().UTC()
-	p.CreatedAt = &now
+	if p.CreatedAt == nil {
+		now := time.Now().UTC()
+		p.CreatedAt = &now
+	}
 	ds.logsMu.Lock()
 	defer ds.logsMu.Unlock()
 	logs, ok := ds.logs.Get(p.TaskID)
@@ -470,16 +743,27 @@ func (ds *InMemoryDatastore) GetMetrics(ctx context.Context) (*tork.Metrics, err
 		}
 	})
 
+	// only the workers report memory and disk usage
+	var reporting int
 	ds.nodes.Iterate(func(_ string, n *tork.Node) {
 		if n.LastHeartbeatAt.After(time.Now().UTC().Add(-(time.Minute * 5))) {
 			s.Nodes.Running = s.Nodes.Running + 1
 			s.Nodes.CPUPercent = s.Nodes.CPUPercent + n.CPUPercent
+			if n.MemoryPercent > 0 || n.DiskPercent > 0 {
+				reporting = reporting + 1
+				s.Nodes.MemoryPercent = s.Nodes.MemoryPercent + n.MemoryPercent
+				s.Nodes.DiskPercent = s.Nodes.DiskPercent + n.DiskPercent
+			}
 		}
 	})
 	// calculate average
 	if s.Nodes.Running > 0 {
 		s.Nodes.CPUPercent = s.Nodes.CPUPercent / float64(s.Nodes.Running)
 	}
+	if reporting > 0 {
+		s.Nodes.MemoryPercent = s.Nodes.MemoryPercent / float64(reporting)
+		s.Nodes.DiskPercent = s.Nodes.DiskPercent / float64(reporting)
+	}
 
 	return s, nil
 }
@@ -524,10 +808,229 @@ func (ds *InMemoryDatastore) CreateUser(ctx context.Context, u *tork.User) error
 	return nil
 }
 
-func (ds *InMemoryDatastore) CreateRole(ctx context.Context, r *tork.Role) error {
-	r.ID = uuid.NewUUID()
+func (ds *InMemoryDatastore) CreateAPIKey(ctx context.Context, k *tork.APIKey) error {
+	if k.KeyHash == "" {
+		return errors.New("must provide key hash")
+	}
+	if k.ID == "" {
+		k.ID = uuid.NewUUID()
+	}
+	if k.CreatedAt == nil {
+		now := time.Now().UTC()
+		k.CreatedAt = &now
+	}
+	ds.apiKeys.Set(k.ID, k.Clone())
+	return nil
+}
+
+func (ds *InMemoryDatastore) GetAPIKey(ctx context.Context, keyHash string) (*tork.APIKey, error) {
+	var k *tork.APIKey
+	ds.apiKeys.Iterate(func(_ string, v *tork.APIKey) {
+		if v.KeyHash == keyHash {
+			k = v
+		}
+	})
+	if k == nil {
+		return nil, datastore.ErrAPIKeyNotFound
+	}
+	return k.Clone(), nil
+}
+
+func (ds *InMemoryDatastore) GetAPIKeys(ctx context.Context) ([]*tork.APIKey, error) {
+	keys := make([]*tork.APIKey, 0)
+	ds.apiKeys.Iterate(func(_ string, v *tork.APIKey) {
+		keys = append(keys, v.Clone())
+	})
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].CreatedAt.Before(*keys[j].CreatedAt)
+	})
+	return keys, nil
+}
+
+func (ds *InMemoryDatastore) DeleteAPIKey(ctx context.Context, id string) error {
+	if _, ok := ds.apiKeys.Get(id); !ok {
+		return datastore.ErrAPIKeyNotFound
+	}
+	ds.apiKeys.Delete(id)
+	return nil
+}
+
+func (ds *InMemoryDatastore) CreateDeadLetter(ctx context.Context, dl *tork.DeadLetter) error {
+	if dl.ID == "" {
+		return errors.New("must provide ID")
+	}
+	if dl.CreatedAt == nil {
+		now := time.Now().UTC()
+		dl.CreatedAt = &now
+	}
+	ds.deadLetters.Set(dl.ID, dl.Clone())
+	return nil
+}
+
+func (ds *InMemoryDatastore) GetDeadLetterByID(ctx context.Context, id string) (*tork.DeadLetter, error) {
+	dl, ok := ds.deadLetters.Get(id)
+	if !ok {
+		return nil, datastore.ErrDeadLetterNotFound
+	}
+	return dl.Clone(), nil
+}
+
+func (ds *InMemoryDatastore) GetDeadLetters(ctx context.Context, page, size int) (*datastore.Page[*tork.DeadLetter], error) {
+	dls := make([]*tork.DeadLetter, 0)
+	ds.deadLetters.Iterate(func(_ string, v *tork.DeadLetter) {
+		dls = append(dls, v)
+	})
+	sort.Slice(dls, func(i, j int) bool {
+		return dls[i].CreatedAt.After(*dls[j].CreatedAt)
+	})
+	offset := (page - 1) * size
+	result := make([]*tork.DeadLetter, 0)
+	for i := offset; i < (offset+size) && i < len(dls); i++ {
+		result = append(result, dls[i].Clone())
+	}
+	totalPages := len(dls) / size
+	if len(dls)%size != 0 {
+		totalPages = totalPages + 1
+	}
+	return &datastore.Page[*tork.DeadLetter]{
+		Items:      result,
+		Number:     page,
+		Size:       len(result),
+		TotalPages: totalPages,
+		TotalItems: len(dls),
+	}, nil
+}
+
+func (ds *InMemoryDatastore) DeleteDeadLetter(ctx context.Context, id string) error {
+	if _, ok := ds.deadLetters.Get(id); !ok {
+		return datastore.ErrDeadLetterNotFound
+	}
+	ds.deadLetters.Delete(id)
+	return nil
+}
+
+func (ds *InMemoryDatastore) SetSecret(ctx context.Context, s *tork.Secret) error {
+	if s.Name == "" {
+		return errors.New("must provide secret name")
+	}
 	now := time.Now().UTC()
-	r.CreatedAt = &now
+	s.UpdatedAt = &now
+	if existing, ok := ds.secrets.Get(s.Name); ok {
+		s.CreatedAt = existing.CreatedAt
+		s.CreatedBy = existing.CreatedBy
+	} else {
+		s.CreatedAt = &now
+	}
+	ds.secrets.Set(s.Name, s.Clone())
+	return nil
+}
+
+func (ds *InMemoryDatastore) GetSecret(ctx context.Context, name string) (*tork.Secret, error) {
+	s, ok := ds.secrets.Get(name)
+	if !ok {
+		return nil, datastore.ErrSecretNotFound
+	}
+	return s.Clone(), nil
+}
+
+func (ds *InMemoryDatastore) GetSecrets(ctx context.Context) ([]*tork.Secret, error) {
+	secrets := make([]*tork.Secret, 0)
+	ds.secrets.Iterate(func(_ string, v *tork.Secret) {
+		secrets = append(secrets, v.Clone())
+	})
+	sort.Slice(secrets, func(i, j int) bool {
+		return secrets[i].Name < secrets[j].Name
+	})
+	return secrets, nil
+}
+
+func (ds *InMemoryDatastore) DeleteSecret(ctx context.Context, name string) error {
+	if _, ok := ds.secrets.Get(name); !ok {
+		return datastore.ErrSecretNotFound
+	}
+	ds.secrets.Delete(name)
+	return nil
+}
+
+func (ds *InMemoryDatastore) AcquireLease(ctx context.Context, name, holder string, ttl time.Duration) (bool, error) {
+	ds.leasesMu.Lock()
+	defer ds.leasesMu.Unlock()
+	now := time.Now().UTC()
+	if l, ok := ds.leases[name]; ok && l.holder != holder && l.expiresAt.After(now) {
+		return false, nil
+	}
+	ds.leases[name] = lease{holder: holder, expiresAt: now.Add(ttl)}
+	return true, nil
+}
+
+func (ds *InMemoryDatastore) ReleaseLease(ctx context.Context, name, holder string) error {
+	ds.leasesMu.Lock()
+	defer ds.leasesMu.Unlock()
+	if l, ok := ds.leases[name]; ok && l.holder == holder {
+		delete(ds.leases, name)
+	}
+	return nil
+}
+
+func (ds *InMemoryDatastore) CreateScheduledJob(ctx context.Context, sj *tork.ScheduledJob) error {
+	if sj.ID == "" {
+		return errors.Errorf("scheduled job id must not be empty")
+	}
+	if sj.CreatedBy == nil {
+		sj.CreatedBy = guestUser
+	}
+	ds.scheduledJobs.Set(sj.ID, sj.Clone())
+	return nil
+}
+
+func (ds *InMemoryDatastore) UpdateScheduledJob(ctx context.Context, id string, modify func(u *tork.ScheduledJob) error) error {
+	if _, ok := ds.scheduledJobs.Get(id); !ok {
+		return datastore.ErrScheduledJobNotFound
+	}
+	return ds.scheduledJobs.Modify(id, func(sj *tork.ScheduledJob) (*tork.ScheduledJob, error) {
+		update := sj.Clone()
+		if err := modify(update); err != nil {
+			return nil, errors.Wrapf(err, "error modifying scheduled job %s", id)
+		}
+		return update, nil
+	})
+}
+
+func (ds *InMemoryDatastore) GetScheduledJobByID(ctx context.Context, id string) (*tork.ScheduledJob, error) {
+	sj, ok := ds.scheduledJobs.Get(id)
+	if !ok {
+		return nil, datastore.ErrScheduledJobNotFound
+	}
+	return sj.Clone(), nil
+}
+
+func (ds *InMemoryDatastore) GetScheduledJobs(ctx context.Context) ([]*tork.ScheduledJob, error) {
+	result := make([]*tork.ScheduledJob, 0)
+	ds.scheduledJobs.Iterate(func(_ string, sj *tork.ScheduledJob) {
+		result = append(result, sj.Clone())
+	})
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].CreatedAt.Before(result[j].CreatedAt)
+	})
+	return result, nil
+}
+
+func (ds *InMemoryDatastore) DeleteScheduledJob(ctx context.Context, id string) error {
+	if _, ok := ds.scheduledJobs.Get(id); !ok {
+		return datastore.ErrScheduledJobNotFound
+	}
+	ds.scheduledJobs.Delete(id)
+	return nil
+}
+
+func (ds *InMemoryDatastore) CreateRole(ctx context.Context, r *tork.Role) error {
+	if r.ID == "" {
+		r.ID = uuid.NewUUID()
+	}
+	if r.CreatedAt == nil {
+		now := time.Now().UTC()
+		r.CreatedAt = &now
+	}
 	ds.roles.Set(r.ID, r)
 	return nil
 }
@@ -11,6 +11,7 @@ import (
 	"github.com/runabol/tork"
 	"github.com/runabol/tork/datastore"
 	"github.com/runabol/tork/datastore/inmemory"
+	"github.com/runabol/tork/internal/hash"
 
 	"github.com/runabol/tork/internal/uuid"
 	"github.com/stretchr/testify/assert"
@@ -94,6 +95,154 @@ func TestInMemoryGetActiveTasks(t *testing.T) {
 	assert.Equal(t, 3, len(at))
 }
 
+func TestInMemoryGetNextTask(t *testing.T) {
+	ctx := context.Background()
+	ds := inmemory.NewInMemoryDatastore()
+	pid := uuid.NewUUID()
+
+	_, err := ds.GetNextTask(ctx, pid)
+	assert.ErrorIs(t, err, datastore.ErrTaskNotFound)
+
+	t1 := &tork.Task{
+		ID:       uuid.NewUUID(),
+		State:    tork.TaskStateRunning,
+		ParentID: pid,
+	}
+	err = ds.CreateTask(ctx, t1)
+	assert.NoError(t, err)
+
+	t2 := &tork.Task{
+		ID:       uuid.NewUUID(),
+		State:    tork.TaskStateCreated,
+		ParentID: pid,
+	}
+	err = ds.CreateTask(ctx, t2)
+	assert.NoError(t, err)
+
+	next, err := ds.GetNextTask(ctx, pid)
+	assert.NoError(t, err)
+	assert.Equal(t, t2.ID, next.ID)
+}
+
+func TestInMemoryGetNextTaskOrder(t *testing.T) {
+	ctx := context.Background()
+	ds := inmemory.NewInMemoryDatastore()
+	pid := uuid.NewUUID()
+	now := time.Now().UTC()
+	later := now.Add(time.Second)
+
+	tasks := []*tork.Task{
+		{ID: uuid.NewUUID(), State: tork.TaskStateCreated, ParentID: pid, Position: 3, CreatedAt: &now},
+		{ID: uuid.NewUUID(), State: tork.TaskStateCreated, ParentID: pid, Position: 2, CreatedAt: &later},
+		{ID: uuid.NewUUID(), State: tork.TaskStateCreated, ParentID: pid, Position: 2, CreatedAt: &now},
+		{ID: uuid.NewUUID(), State: tork.TaskStateCompleted, ParentID: pid, Position: 1, CreatedAt: &now},
+	}
+	for _, tk := range tasks {
+		assert.NoError(t, ds.CreateTask(ctx, tk))
+	}
+
+	for i := 0; i < 10; i++ {
+		next, err := ds.GetNextTask(ctx, pid)
+		assert.NoError(t, err)
+		assert.Equal(t, tasks[2].ID, next.ID)
+	}
+}
+
+func TestInMemoryGetStalledTasks(t *testing.T) {
+	ctx := context.Background()
+	ds := inmemory.NewInMemoryDatastore()
+	now := time.Now().UTC()
+	startedAt := now.Add(-time.Minute * 10)
+
+	alive := &tork.Node{ID: uuid.NewUUID(), LastHeartbeatAt: now}
+	dead := &tork.Node{ID: uuid.NewUUID(), LastHeartbeatAt: now.Add(-time.Minute * 6)}
+	assert.NoError(t, ds.CreateNode(ctx, alive))
+	assert.NoError(t, ds.CreateNode(ctx, dead))
+
+	t1 := &tork.Task{ID: uuid.NewUUID(), State: tork.TaskStateRunning, NodeID: alive.ID, StartedAt: &startedAt}
+	t2 := &tork.Task{ID: uuid.NewUUID(), State: tork.TaskStateRunning, NodeID: dead.ID, StartedAt: &startedAt}
+	t3 := &tork.Task{ID: uuid.NewUUID(), State: tork.TaskStateCompleted, NodeID: dead.ID, StartedAt: &startedAt}
+	t4 := &tork.Task{ID: uuid.NewUUID(), State: tork.TaskStateRunning, NodeID: uuid.NewUUID(), StartedAt: &startedAt}
+	t5 := &tork.Task{ID: uuid.NewUUID(), State: tork.TaskStateRunning, StartedAt: &startedAt}
+	for _, ta := range []*tork.Task{t1, t2, t3, t4, t5} {
+		assert.NoError(t, ds.CreateTask(ctx, ta))
+	}
+
+	stalled, err := ds.GetStalledTasks(ctx, now.Add(-time.Minute*5))
+	assert.NoError(t, err)
+	ids := make([]string, len(stalled))
+	for i, st := range stalled {
+		ids[i] = st.ID
+	}
+	assert.ElementsMatch(t, []string{t2.ID, t4.ID}, ids)
+}
+
+func TestInMemoryGetJobByIdempotencyKey(t *testing.T) {
+	ctx := context.Background()
+	ds := inmemory.NewInMemoryDatastore()
+	j1 := &tork.Job{ID: uuid.NewUUID(), IdempotencyKey: "some-key"}
+	assert.NoError(t, ds.CreateJob(ctx, j1))
+
+	j2, err := ds.GetJobByIdempotencyKey(ctx, j1.CreatedBy.ID, "some-key")
+	assert.NoError(t, err)
+	assert.Equal(t, j1.ID, j2.ID)
+
+	_, err = ds.GetJobByIdempotencyKey(ctx, j1.CreatedBy.ID, "other-key")
+	assert.ErrorIs(t, err, datastore.ErrJobNotFound)
+
+	// keys are scoped to the user
+	_, err = ds.GetJobByIdempotencyKey(ctx, "other-user", "some-key")
+	assert.ErrorIs(t, err, datastore.ErrJobNotFound)
+	u := &tork.User{ID: uuid.NewUUID(), Username: "someuser"}
+	assert.NoError(t, ds.CreateJob(ctx, &tork.Job{ID: uuid.NewUUID(), IdempotencyKey: "some-key", CreatedBy: u}))
+
+	// keys are unique
+	err = ds.CreateJob(ctx, &tork.Job{ID: uuid.NewUUID(), IdempotencyKey: "some-key"})
+	assert.Error(t, err)
+}
+
+func TestInMemoryGetJobByIdempotencyKeyExpired(t *testing.T) {
+	ctx := context.Background()
+	ds := inmemory.NewInMemoryDatastore(inmemory.WithIdempotencyKeyRetention(time.Hour))
+	j1 := &tork.Job{ID: uuid.NewUUID(), IdempotencyKey: "some-key", CreatedAt: time.Now().UTC().Add(-time.Hour * 2)}
+	assert.NoError(t, ds.CreateJob(ctx, j1))
+
+	_, err := ds.GetJobByIdempotencyKey(ctx, j1.CreatedBy.ID, "some-key")
+	assert.ErrorIs(t, err, datastore.ErrJobNotFound)
+
+	// the expired key can be reused
+	j2 := &tork.Job{ID: uuid.NewUUID(), IdempotencyKey: "some-key", CreatedAt: time.Now().UTC()}
+	assert.NoError(t, ds.CreateJob(ctx, j2))
+	j3, err := ds.GetJobByIdempotencyKey(ctx, j2.CreatedBy.ID, "some-key")
+	assert.NoError(t, err)
+	assert.Equal(t, j2.ID, j3.ID)
+}
+
+func TestInMemoryGetTimedOutJobs(t *testing.T) {
+	ctx := context.Background()
+	ds := inmemory.NewInMemoryDatastore()
+	now := time.Now().UTC()
+	past := now.Add(-time.Minute)
+	future := now.Add(time.Minute)
+
+	j1 := &tork.Job{ID: uuid.NewUUID(), State: tork.JobStateRunning, TimeoutAt: &past}
+	j2 := &tork.Job{ID: uuid.NewUUID(), State: tork.JobStateScheduled, TimeoutAt: &past}
+	j3 := &tork.Job{ID: uuid.NewUUID(), State: tork.JobStateRunning, TimeoutAt: &future}
+	j4 := &tork.Job{ID: uuid.NewUUID(), State: tork.JobStateCompleted, TimeoutAt: &past}
+	j5 := &tork.Job{ID: uuid.NewUUID(), State: tork.JobStateRunning}
+	for _, j := range []*tork.Job{j1, j2, j3, j4, j5} {
+		assert.NoError(t, ds.CreateJob(ctx, j))
+	}
+
+	jobs, err := ds.GetTimedOutJobs(ctx, now)
+	assert.NoError(t, err)
+	ids := make([]string, len(jobs))
+	for i, j := range jobs {
+		ids[i] = j.ID
+	}
+	assert.ElementsMatch(t, []string{j1.ID, j2.ID}, ids)
+}
+
 func TestInMemoryUpdateTask(t *testing.T) {
 	ctx := context.Background()
 	ds := inmemory.NewInMemoryDatastore()
@@ -382,6 +531,49 @@ func TestInMemoryExpiredJob(t *testing.T) {
 	assert.ErrorIs(t, err, datastore.ErrTaskNotFound)
 }
 
+func TestInMemoryExpiredCancelledJob(t *testing.T) {
+	ctx := context.Background()
+	ds := inmemory.NewInMemoryDatastore(
+		inmemory.WithCleanupInterval(time.Millisecond*20),
+		inmemory.WithJobExpiration(time.Millisecond*100),
+	)
+	j := &tork.Job{
+		ID:    uuid.NewUUID(),
+		State: tork.JobStateRunning,
+	}
+	err := ds.CreateJob(ctx, j)
+	assert.NoError(t, err)
+
+	err = ds.UpdateJob(ctx, j.ID, func(u *tork.Job) error {
+		u.State = tork.JobStateCancelled
+		return nil
+	})
+	assert.NoError(t, err)
+
+	// restarting the job keeps it around
+	err = ds.UpdateJob(ctx, j.ID, func(u *tork.Job) error {
+		u.State = tork.JobStateRestart
+		return nil
+	})
+	assert.NoError(t, err)
+
+	time.Sleep(time.Millisecond * 300)
+
+	_, err = ds.GetJobByID(ctx, j.ID)
+	assert.NoError(t, err)
+
+	err = ds.UpdateJob(ctx, j.ID, func(u *tork.Job) error {
+		u.State = tork.JobStateCancelled
+		return nil
+	})
+	assert.NoError(t, err)
+
+	time.Sleep(time.Millisecond * 300)
+
+	_, err = ds.GetJobByID(ctx, j.ID)
+	assert.ErrorIs(t, err, datastore.ErrJobNotFound)
+}
+
 func TestInMemoryCreateAndGetTaskLogs(t *testing.T) {
 	ctx := context.Background()
 	ds := inmemory.NewInMemoryDatastore()
@@ -668,8 +860,13 @@ func TestInMemoryCreateRole(t *testing.T) {
 
 	roles, err := ds.GetRoles(ctx)
 	assert.NoError(t, err)
-	assert.Len(t, roles, 1)
-	assert.Equal(t, "Test Role", roles[0].Name)
+	assert.Len(t, roles, 5)
+	assert.Equal(t, "Test Role", roles[4].Name)
+
+	for _, slug := range []string{tork.ROLE_PUBLIC, tork.ROLE_SUBMITTER, tork.ROLE_OPERATOR, tork.ROLE_ADMIN} {
+		_, err := ds.GetRole(ctx, slug)
+		assert.NoError(t, err)
+	}
 
 	u := &tork.User{
 		ID:        uuid.NewUUID(),
@@ -695,3 +892,366 @@ func TestInMemoryCreateRole(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Len(t, uroles, 0)
 }
+
+func TestInMemoryAPIKeys(t *testing.T) {
+	ctx := context.Background()
+	ds := inmemory.NewInMemoryDatastore()
+	k := &tork.APIKey{
+		Name:    "test key",
+		KeyHash: hash.Key("secret"),
+	}
+	err := ds.CreateAPIKey(ctx, k)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, k.ID)
+
+	k2, err := ds.GetAPIKey(ctx, hash.Key("secret"))
+	assert.NoError(t, err)
+	assert.Equal(t, k.ID, k2.ID)
+	assert.Equal(t, "test key", k2.Name)
+
+	_, err = ds.GetAPIKey(ctx, hash.Key("other"))
+	assert.ErrorIs(t, err, datastore.ErrAPIKeyNotFound)
+
+	keys, err := ds.GetAPIKeys(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, keys, 1)
+
+	err = ds.DeleteAPIKey(ctx, k.ID)
+	assert.NoError(t, err)
+
+	_, err = ds.GetAPIKey(ctx, hash.Key("secret"))
+	assert.ErrorIs(t, err, datastore.ErrAPIKeyNotFound)
+
+	err = ds.DeleteAPIKey(ctx, k.ID)
+	assert.ErrorIs(t, err, datastore.ErrAPIKeyNotFound)
+}
+
+func TestInMemoryDeadLetters(t *testing.T) {
+	ctx := context.Background()
+	ds := inmemory.NewInMemoryDatastore()
+	for i := 0; i < 3; i++ {
+		dl := &tork.DeadLetter{
+			ID:       uuid.NewUUID(),
+			Queue:    "default",
+			Task:     &tork.Task{ID: uuid.NewUUID()},
+			Error:    "something bad happened",
+			Attempts: 3,
+		}
+		err := ds.CreateDeadLetter(ctx, dl)
+		assert.NoError(t, err)
+		assert.NotNil(t, dl.CreatedAt)
+	}
+
+	p, err := ds.GetDeadLetters(ctx, 1, 2)
+	assert.NoError(t, err)
+	assert.Len(t, p.Items, 2)
+	assert.Equal(t, 3, p.TotalItems)
+	assert.Equal(t, 2, p.TotalPages)
+
+	dl, err := ds.GetDeadLetterByID(ctx, p.Items[0].ID)
+	assert.NoError(t, err)
+	assert.Equal(t, "default", dl.Queue)
+	assert.Equal(t, 3, dl.Attempts)
+	assert.NotNil(t, dl.Task)
+
+	err = ds.DeleteDeadLetter(ctx, dl.ID)
+	assert.NoError(t, err)
+
+	_, err = ds.GetDeadLetterByID(ctx, dl.ID)
+	assert.ErrorIs(t, err, datastore.ErrDeadLetterNotFound)
+
+	err = ds.DeleteDeadLetter(ctx, dl.ID)
+	assert.ErrorIs(t, err, datastore.ErrDeadLetterNotFound)
+}
+
+func TestInMemorySecrets(t *testing.T) {
+	ctx := context.Background()
+	ds := inmemory.NewInMemoryDatastore()
+	s := &tork.Secret{
+		Name:      "db-password",
+		Value:     "shhh",
+		CreatedBy: "someuser",
+	}
+	err := ds.SetSecret(ctx, s)
+	assert.NoError(t, err)
+	assert.NotNil(t, s.CreatedAt)
+
+	// updating keeps the original creation details
+	err = ds.SetSecret(ctx, &tork.Secret{Name: "db-password", Value: "hush"})
+	assert.NoError(t, err)
+
+	s2, err := ds.GetSecret(ctx, "db-password")
+	assert.NoError(t, err)
+	assert.Equal(t, "hush", s2.Value)
+	assert.Equal(t, "someuser", s2.CreatedBy)
+	assert.Equal(t, s.CreatedAt, s2.CreatedAt)
+
+	_, err = ds.GetSecret(ctx, "other")
+	assert.ErrorIs(t, err, datastore.ErrSecretNotFound)
+
+	secrets, err := ds.GetSecrets(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, secrets, 1)
+
+	err = ds.DeleteSecret(ctx, "db-password")
+	assert.NoError(t, err)
+
+	_, err = ds.GetSecret(ctx, "db-password")
+	assert.ErrorIs(t, err, datastore.ErrSecretNotFound)
+
+	err = ds.DeleteSecret(ctx, "db-password")
+	assert.ErrorIs(t, err, datastore.ErrSecretNotFound)
+}
+
+func TestInMemoryListJobs(t *testing.T) {
+	ds := inmemory.NewInMemoryDatastore()
+	ctx := context.Background()
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	for i := 0; i < 25; i++ {
+		state := tork.JobStateCompleted
+		if i%5 == 0 {
+			state = tork.JobStateFailed
+		}
+		tags := []string{fmt.Sprintf("batch=%d", i%2)}
+		if i < 3 {
+			tags = append(tags, "nightly")
+		}
+		// the others are in the default namespace
+		var namespace string
+		if i%3 == 0 {
+			namespace = "team-a"
+		}
+		err := ds.CreateJob(ctx, &tork.Job{
+			ID:        uuid.NewUUID(),
+			Name:      fmt.Sprintf("Job %02d", i),
+			Namespace: namespace,
+			State:     state,
+			CreatedAt: now.Add(time.Duration(i) * time.Minute),
+			Tags:      tags,
+		})
+		assert.NoError(t, err)
+	}
+
+	// filters
+	p, err := ds.ListJobs(ctx, "", datastore.JobQuery{Namespaces: []string{"team-a"}, Size: 20})
+	assert.NoError(t, err)
+	assert.Equal(t, 9, p.TotalItems)
+	assert.Equal(t, "team-a", p.Items[0].Namespace)
+
+	p, err = ds.ListJobs(ctx, "", datastore.JobQuery{Namespaces: []string{tork.NAMESPACE_DEFAULT}, Size: 20})
+	assert.NoError(t, err)
+	assert.Equal(t, 16, p.TotalItems)
+
+	p, err = ds.ListJobs(ctx, "", datastore.JobQuery{States: []tork.JobState{tork.JobStateFailed}, Size: 10})
+	assert.NoError(t, err)
+	assert.Equal(t, 5, p.TotalItems)
+
+	p, err = ds.ListJobs(ctx, "", datastore.JobQuery{Name: "job 1", Size: 20})
+	assert.NoError(t, err)
+	assert.Equal(t, 10, p.TotalItems)
+
+	after := now.Add(time.Minute * 10)
+	before := now.Add(time.Minute * 20)
+	p, err = ds.ListJobs(ctx, "", datastore.JobQuery{CreatedAfter: &after, CreatedBefore: &before, Size: 20})
+	assert.NoError(t, err)
+	assert.Equal(t, 10, p.TotalItems)
+
+	labels, err := datastore.ParseLabelSelector("batch=0,!nightly")
+	assert.NoError(t, err)
+	p, err = ds.ListJobs(ctx, "", datastore.JobQuery{Labels: labels, Size: 20})
+	assert.NoError(t, err)
+	assert.Equal(t, 11, p.TotalItems)
+
+	// sorting
+	p, err = ds.ListJobs(ctx, "", datastore.JobQuery{Sort: datastore.JOBS_SORT_NAME, Size: 3})
+	assert.NoError(t, err)
+	assert.Equal(t, "Job 00", p.Items[0].Name)
+	assert.Equal(t, "Job 02", p.Items[2].Name)
+
+	// cursor pagination
+	q := datastore.JobQuery{Sort: datastore.JOBS_SORT_CREATED_AT, Desc: true, Size: 10}
+	names := make([]string, 0)
+	for {
+		p, err := ds.ListJobs(ctx, "", q)
+		assert.NoError(t, err)
+		for _, j := range p.Items {
+			names = append(names, j.Name)
+		}
+		if p.NextCursor == "" {
+			break
+		}
+		q.Cursor = p.NextCursor
+	}
+	assert.Len(t, names, 25)
+	assert.Equal(t, "Job 24", names[0])
+	assert.Equal(t, "Job 00", names[24])
+
+	q.Desc = false
+	_, err = ds.ListJobs(ctx, "", q)
+	assert.ErrorIs(t, err, datastore.ErrInvalidCursor)
+}
+
+func TestInMemoryFullTextSearchJobs(t *testing.T) {
+	ds := inmemory.NewInMemoryDatastore()
+	ctx := context.Background()
+	now := time.Now().UTC()
+	jobs := []struct {
+		name  string
+		state tork.JobState
+		task  tork.Task
+		log   string
+	}{
+		{"nightly backup", tork.JobStateFailed, tork.Task{Name: "dump db", Error: "container OOMKilled: out of memory"}, ""},
+		{"nightly report", tork.JobStateCompleted, tork.Task{Name: "render", Result: "report sent to finance"}, ""},
+		{"cleanup", tork.JobStateCompleted, tork.Task{Name: "sweep"}, "warning: disk almost full"},
+	}
+	for _, j := range jobs {
+		job := &tork.Job{
+			ID:        uuid.NewUUID(),
+			Name:      j.name,
+			State:     j.state,
+			CreatedAt: now,
+		}
+		err := ds.CreateJob(ctx, job)
+		assert.NoError(t, err)
+		task := j.task
+		task.ID = uuid.NewUUID()
+		task.JobID = job.ID
+		task.CreatedAt = &now
+		err = ds.CreateTask(ctx, &task)
+		assert.NoError(t, err)
+		if j.log != "" {
+			err = ds.CreateTaskLogPart(ctx, &tork.TaskLogPart{Number: 1, TaskID: task.ID, Contents: j.log})
+			assert.NoError(t, err)
+		}
+	}
+
+	search := func(q datastore.JobQuery) []string {
+		q.Size = 10
+		p, err := ds.ListJobs(ctx, "", q)
+		assert.NoError(t, err)
+		names := make([]string, 0)
+		for _, j := range p.Items {
+			names = append(names, j.Name)
+		}
+		return names
+	}
+	assert.Equal(t, []string{"nightly backup"}, search(datastore.JobQuery{Search: "memory"}))
+	assert.Equal(t, []string{"nightly report"}, search(datastore.JobQuery{Search: "finance"}))
+	assert.Equal(t, []string{"nightly backup", "nightly report"}, search(datastore.JobQuery{Search: "nightly", Sort: datastore.JOBS_SORT_NAME}))
+	assert.Equal(t, []string{"nightly backup"}, search(datastore.JobQuery{Search: "nightly", States: []tork.JobState{tork.JobStateFailed}}))
+	assert.Empty(t, search(datastore.JobQuery{Search: "disk"}))
+	assert.Equal(t, []string{"cleanup"}, search(datastore.JobQuery{Search: "disk", SearchLogs: true}))
+}
+
+func TestInMemoryScheduledJobs(t *testing.T) {
+	ctx := context.Background()
+	ds := inmemory.NewInMemoryDatastore()
+	sj := &tork.ScheduledJob{
+		ID:        uuid.NewUUID(),
+		Name:      "nightly",
+		Cron:      "0 0 * * *",
+		Overlap:   tork.ScheduledJobOverlapSkip,
+		State:     tork.ScheduledJobStateActive,
+		CreatedAt: time.Now().UTC(),
+		Template: &tork.Job{
+			Name:  "nightly",
+			Tasks: []*tork.Task{{Name: "some task"}},
+		},
+	}
+	err := ds.CreateScheduledJob(ctx, sj)
+	assert.NoError(t, err)
+
+	sj2, err := ds.GetScheduledJobByID(ctx, sj.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, "nightly", sj2.Name)
+	assert.Len(t, sj2.Template.Tasks, 1)
+	assert.NotNil(t, sj2.CreatedBy)
+
+	now := time.Now().UTC()
+	err = ds.UpdateScheduledJob(ctx, sj.ID, func(u *tork.ScheduledJob) error {
+		u.LastRunAt = &now
+		u.LastJobID = "1234"
+		return nil
+	})
+	assert.NoError(t, err)
+
+	sjs, err := ds.GetScheduledJobs(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, sjs, 1)
+	assert.Equal(t, "1234", sjs[0].LastJobID)
+	assert.Equal(t, now, *sjs[0].LastRunAt)
+
+	err = ds.DeleteScheduledJob(ctx, sj.ID)
+	assert.NoError(t, err)
+	_, err = ds.GetScheduledJobByID(ctx, sj.ID)
+	assert.ErrorIs(t, err, datastore.ErrScheduledJobNotFound)
+	err = ds.UpdateScheduledJob(ctx, sj.ID, func(u *tork.ScheduledJob) error {
+		return nil
+	})
+	assert.ErrorIs(t, err, datastore.ErrScheduledJobNotFound)
+}
+
+func TestInMemoryGetMetrics(t *testing.T) {
+	ctx := context.Background()
+	ds := inmemory.NewInMemoryDatastore()
+	// a coordinator doesn't report memory and disk usage
+	err := ds.CreateNode(ctx, &tork.Node{
+		ID:              uuid.NewUUID(),
+		LastHeartbeatAt: time.Now().UTC(),
+		CPUPercent:      30,
+	})
+	assert.NoError(t, err)
+	err = ds.CreateNode(ctx, &tork.Node{
+		ID:              uuid.NewUUID(),
+		LastHeartbeatAt: time.Now().UTC(),
+		CPUPercent:      10,
+		MemoryPercent:   40,
+		DiskPercent:     70,
+	})
+	assert.NoError(t, err)
+	err = ds.CreateNode(ctx, &tork.Node{
+		ID:              uuid.NewUUID(),
+		LastHeartbeatAt: time.Now().UTC(),
+		CPUPercent:      20,
+		MemoryPercent:   60,
+		DiskPercent:     50,
+	})
+	assert.NoError(t, err)
+	m, err := ds.GetMetrics(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, m.Nodes.Running)
+	assert.Equal(t, float64(20), m.Nodes.CPUPercent)
+	assert.Equal(t, float64(50), m.Nodes.MemoryPercent)
+	assert.Equal(t, float64(60), m.Nodes.DiskPercent)
+}
+
+func TestInMemoryLeases(t *testing.T) {
+	ctx := context.Background()
+	ds := inmemory.NewInMemoryDatastore()
+	ok, err := ds.AcquireLease(ctx, "leader", "c1", time.Minute)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	// renewing
+	ok, err = ds.AcquireLease(ctx, "leader", "c1", time.Minute)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	// held by another holder
+	ok, err = ds.AcquireLease(ctx, "leader", "c2", time.Minute)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	// released
+	assert.NoError(t, ds.ReleaseLease(ctx, "leader", "c2"))
+	ok, err = ds.AcquireLease(ctx, "leader", "c2", time.Minute)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	assert.NoError(t, ds.ReleaseLease(ctx, "leader", "c1"))
+	ok, err = ds.AcquireLease(ctx, "leader", "c2", time.Millisecond)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	// expired
+	time.Sleep(time.Millisecond * 5)
+	ok, err = ds.AcquireLease(ctx, "leader", "c1", time.Minute)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+}
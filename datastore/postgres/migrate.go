@@ -0,0 +1,85 @@
+package postgres
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	schema "github.com/runabol/tork/db/postgres"
+)
+
+// migrationLock is the key of the advisory lock which keeps
+// concurrent migrations, e.g. of several coordinators, apart.
+const migrationLock = 72_617_301
+
+// Migrate brings the schema up to date by applying the migrations
+// it hasn't applied yet. A database created by an older release,
+// which has the tables of the initial schema but doesn't track
+// its migrations, is assumed to be at the first version.
+func (ds *PostgresDatastore) Migrate(ctx context.Context) error {
+	return ds.migrate(ctx, schema.Migrations)
+}
+
+func (ds *PostgresDatastore) migrate(ctx context.Context, migrations []schema.Migration) error {
+	conn, err := ds.db.Connx(ctx)
+	if err != nil {
+		return errors.Wrapf(err, "error acquiring a connection")
+	}
+	defer conn.Close()
+	if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", migrationLock); err != nil {
+		return errors.Wrapf(err, "error acquiring the migration lock")
+	}
+	defer func() {
+		if _, err := conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", migrationLock); err != nil {
+			log.Error().Err(err).Msg("error releasing the migration lock")
+		}
+	}()
+	if _, err := conn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+        version    int       not null primary key,
+        applied_at timestamp not null
+    )`); err != nil {
+		return errors.Wrapf(err, "error creating the schema_migrations table")
+	}
+	var current int
+	if err := conn.GetContext(ctx, &current, "SELECT coalesce(max(version),0) FROM schema_migrations"); err != nil {
+		return errors.Wrapf(err, "error getting the schema version")
+	}
+	if current == 0 {
+		var legacy bool
+		if err := conn.GetContext(ctx, &legacy, `SELECT EXISTS (
+            SELECT 1 FROM information_schema.tables
+            WHERE table_schema = current_schema() AND table_name = 'jobs'
+        )`); err != nil {
+			return errors.Wrapf(err, "error inspecting the schema")
+		}
+		if legacy {
+			current = 1
+			if _, err := conn.ExecContext(ctx, "INSERT INTO schema_migrations (version,applied_at) VALUES (1,$1)", time.Now().UTC()); err != nil {
+				return errors.Wrapf(err, "error recording the initial schema version")
+			}
+		}
+	}
+	for _, m := range migrations {
+		if m.Version <= current {
+			continue
+		}
+		tx, err := conn.BeginTxx(ctx, nil)
+		if err != nil {
+			return errors.Wrapf(err, "error starting a transaction")
+		}
+		if _, err := tx.ExecContext(ctx, m.Script); err != nil {
+			_ = tx.Rollback()
+			return errors.Wrapf(err, "error applying migration %d (%s)", m.Version, m.Description)
+		}
+		if _, err := tx.ExecContext(ctx, "INSERT INTO schema_migrations (version,applied_at) VALUES ($1,$2)", m.Version, time.Now().UTC()); err != nil {
+			_ = tx.Rollback()
+			return errors.Wrapf(err, "error recording migration %d", m.Version)
+		}
+		if err := tx.Commit(); err != nil {
+			return errors.Wrapf(err, "error committing migration %d", m.Version)
+		}
+		log.Info().Msgf("applied migration %d: %s", m.Version, m.Description)
+	}
+	return nil
+}
@@ -22,6 +22,7 @@ type PostgresDatastore struct {
 	db                      *sqlx.DB
 	tx                      *sqlx.Tx
 	taskLogsRetentionPeriod *time.Duration
+	keyRetentionPeriod      time.Duration
 	cleanupInterval         *time.Duration
 	rand                    *rand.Rand
 	disableCleanup          bool
@@ -42,6 +43,14 @@ func WithTaskLogRetentionPeriod(dur time.Duration) Option {
 	}
 }
 
+// WithIdempotencyKeyRetention sets how long the
+// idempotency key of a job is honored.
+func WithIdempotencyKeyRetention(dur time.Duration) Option {
+	return func(ds *PostgresDatastore) {
+		ds.keyRetentionPeriod = dur
+	}
+}
+
 func WithDisableCleanup(val bool) Option {
 	return func(ds *PostgresDatastore) {
 		ds.disableCleanup = val
@@ -54,8 +63,9 @@ func NewPostgresDataStore(dsn string, opts ...Option) (*PostgresDatastore, error
 		return nil, errors.Wrapf(err, "unable to connect to postgres")
 	}
 	ds := &PostgresDatastore{
-		db:   db,
-		rand: rand.New(rand.NewSource(time.Now().UnixNano())),
+		db:                 db,
+		rand:               rand.New(rand.NewSource(time.Now().UnixNano())),
+		keyRetentionPeriod: datastore.DefaultIdempotencyKeyRetention,
 	}
 	for _, opt := range opts {
 		opt(ds)
@@ -142,6 +152,15 @@ func (ds *PostgresDatastore) CreateTask(ctx context.Context, t *tork.Task) error
 		s := string(b)
 		files = &s
 	}
+	var selector *string
+	if t.Selector != nil {
+		b, err := json.Marshal(t.Selector)
+		if err != nil {
+			return errors.Wrapf(err, "failed to serialize task.selector")
+		}
+		s := string(b)
+		selector = &s
+	}
 	pre, err := json.Marshal(t.Pre)
 	if err != nil {
 		return errors.Wrapf(err, "failed to serialize task.pre")
@@ -222,6 +241,32 @@ func (ds *PostgresDatastore) CreateTask(ctx context.Context, t *tork.Task) error
 		s := string(b)
 		ports = &s
 	}
+	artifacts, err := serializeArtifacts(t.Artifacts)
+	if err != nil {
+		return err
+	}
+	downloads, err := serializeArtifacts(t.Downloads)
+	if err != nil {
+		return err
+	}
+	var service *string
+	if t.Service != nil {
+		b, err := json.Marshal(t.Service)
+		if err != nil {
+			return errors.Wrapf(err, "failed to serialize task.service")
+		}
+		s := string(b)
+		service = &s
+	}
+	var security *string
+	if t.Security != nil {
+		b, err := json.Marshal(t.Security)
+		if err != nil {
+			return errors.Wrapf(err, "failed to serialize task.security")
+		}
+		s := string(b)
+		security = &s
+	}
 	q := `insert into tasks (
 		    id, -- $1
 			job_id, -- $2
@@ -262,13 +307,25 @@ func (ds *PostgresDatastore) CreateTask(ctx context.Context, t *tork.Task) error
 			tags, -- $37
 			priority, -- $38
 			workdir, -- $39
-			ports -- $40
+			ports, -- $40
+			artifacts, -- $41
+			downloads, -- $42
+			service, -- $43
+			user_, -- $44
+			security, -- $45
+			shm_size, -- $46
+			ulimits, -- $47
+			devices, -- $48
+			platform, -- $49
+			selector, -- $50
+			retry_at -- $51
 		  ) 
 	      values (
 			$1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,
 		    $15,$16,$17,$18,$19,$20,$21,$22,$23,$24,$25,$26,
 			$27,$28,$29,$30,$31,$32,$33,$34,$35,$36,$37,$38,
-			$39,$40)`
+			$39,$40,$41,$42,$43,$44,$45,$46,$47,$48,$49,$50,
+			$51)`
 	_, err = ds.exec(q,
 		t.ID,                         // $1
 		t.JobID,                      // $2
@@ -310,6 +367,17 @@ func (ds *PostgresDatastore) CreateTask(ctx context.Context, t *tork.Task) error
 		t.Priority,                   // $38
 		t.Workdir,                    // $39
 		ports,                        // $40
+		artifacts,                    // $41
+		downloads,                    // $42
+		service,                      // $43
+		t.User,                       // $44
+		security,                     // $45
+		t.ShmSize,                    // $46
+		pq.StringArray(t.Ulimits),    // $47
+		pq.StringArray(t.Devices),    // $48
+		t.Platform,                   // $49
+		selector,                     // $50
+		t.RetryAt,                    // $51
 	)
 	if err != nil {
 		return errors.Wrapf(err, "error inserting task to the db")
@@ -321,6 +389,18 @@ func sanitizeString(s string) string {
 	return strings.ReplaceAll(s, "\u0000", "")
 }
 
+func serializeArtifacts(artifacts []*tork.Artifact) (*string, error) {
+	if artifacts == nil {
+		return nil, nil
+	}
+	b, err := json.Marshal(artifacts)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to serialize task artifacts")
+	}
+	s := string(b)
+	return &s, nil
+}
+
 func (ds *PostgresDatastore) GetTaskByID(ctx context.Context, id string) (*tork.Task, error) {
 	r := taskRecord{}
 	if err := ds.get(&r, `SELECT * FROM tasks where id = $1`, id); err != nil {
@@ -394,6 +474,19 @@ func (ds *PostgresDatastore) UpdateTask(ctx context.Context, id string, modify f
 			s := string(b)
 			retry = &s
 		}
+		artifacts, err := serializeArtifacts(t.Artifacts)
+		if err != nil {
+			return err
+		}
+		var stats *string
+		if t.Stats != nil {
+			b, err := json.Marshal(t.Stats)
+			if err != nil {
+				return errors.Wrapf(err, "failed to serialize task.stats")
+			}
+			s := string(b)
+			stats = &s
+		}
 		q := `update tasks set 
 				position = $1,
 				state = $2,
@@ -411,8 +504,15 @@ func (ds *PostgresDatastore) UpdateTask(ctx context.Context, id string, modify f
 				timeout = $14,
 				retry = $15,
 				queue = $16,
-				progress = $17
-			  where id = $18`
+				progress = $17,
+				result_truncated = $18,
+				exit_code = $19,
+				artifacts = $20,
+				result_url = $21,
+				stats = $22,
+				image_digest = $23,
+				retry_at = $24
+			  where id = $25`
 		_, err = ptx.exec(q,
 			t.Position,               // $1
 			t.State,                  // $2
@@ -431,7 +531,14 @@ func (ds *PostgresDatastore) UpdateTask(ctx context.Context, id string, modify f
 			retry,                    // $15
 			t.Queue,                  // $16
 			t.Progress,               // $17
-			t.ID,                     // $18
+			t.ResultTruncated,        // $18
+			t.ExitCode,               // $19
+			artifacts,                // $20
+			t.ResultURL,              // $21
+			stats,                    // $22
+			t.ImageDigest,            // $23
+			t.RetryAt,                // $24
+			t.ID,                     // $25
 		)
 		if err != nil {
 			return errors.Wrapf(err, "error updating task %s", t.ID)
@@ -441,11 +548,20 @@ func (ds *PostgresDatastore) UpdateTask(ctx context.Context, id string, modify f
 }
 
 func (ds *PostgresDatastore) CreateNode(ctx context.Context, n *tork.Node) error {
+	var tags *string
+	if n.Tags != nil {
+		b, err := json.Marshal(n.Tags)
+		if err != nil {
+			return errors.Wrapf(err, "failed to serialize node.tags")
+		}
+		s := string(b)
+		tags = &s
+	}
 	q := `insert into nodes 
-	       (id,name,started_at,last_heartbeat_at,cpu_percent,queue,status,hostname,task_count,version_,port)
+	       (id,name,started_at,last_heartbeat_at,cpu_percent,queue,status,hostname,task_count,version_,port,memory_percent,disk_percent,gpus,platform,tags)
 	      values
-	       ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11)`
-	_, err := ds.exec(q, n.ID, n.Name, n.StartedAt, n.LastHeartbeatAt, n.CPUPercent, n.Queue, n.Status, n.Hostname, n.TaskCount, n.Version, n.Port)
+	       ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$15,$16)`
+	_, err := ds.exec(q, n.ID, n.Name, n.StartedAt, n.LastHeartbeatAt, n.CPUPercent, n.Queue, n.Status, n.Hostname, n.TaskCount, n.Version, n.Port, n.MemoryPercent, n.DiskPercent, n.GPUs, n.Platform, tags)
 	if err != nil {
 		return errors.Wrapf(err, "error inserting node to the db")
 	}
@@ -462,7 +578,10 @@ func (ds *PostgresDatastore) UpdateNode(ctx context.Context, id string, modify f
 		if err := ptx.get(&nr, `SELECT * FROM nodes where id = $1 for update`, id); err != nil {
 			return errors.Wrapf(err, "error fetching node from db")
 		}
-		n := nr.toNode()
+		n, err := nr.toNode()
+		if err != nil {
+			return err
+		}
 		if err := modify(n); err != nil {
 			return err
 		}
@@ -470,9 +589,11 @@ func (ds *PostgresDatastore) UpdateNode(ctx context.Context, id string, modify f
 	        last_heartbeat_at = $1,
 			cpu_percent = $2,
 			status = $3,
-			task_count = $4
-		  where id = $5`
-		_, err := ptx.exec(q, n.LastHeartbeatAt, n.CPUPercent, n.Status, n.TaskCount, id)
+			task_count = $4,
+			memory_percent = $5,
+			disk_percent = $6
+		  where id = $7`
+		_, err = ptx.exec(q, n.LastHeartbeatAt, n.CPUPercent, n.Status, n.TaskCount, n.MemoryPercent, n.DiskPercent, id)
 		if err != nil {
 			return errors.Wrapf(err, "error update node in db")
 		}
@@ -488,7 +609,7 @@ func (ds *PostgresDatastore) GetNodeByID(ctx context.Context, id string) (*tork.
 		}
 		return nil, errors.Wrapf(err, "error fetching task from db")
 	}
-	return nr.toNode(), nil
+	return nr.toNode()
 }
 
 func (ds *PostgresDatastore) GetActiveNodes(ctx context.Context) ([]*tork.Node, error) {
@@ -502,9 +623,12 @@ func (ds *PostgresDatastore) GetActiveNodes(ctx context.Context) ([]*tork.Node,
 		return nil, errors.Wrapf(err, "error getting active nodes from db")
 	}
 	ns := make([]*tork.Node, len(nrs))
-	for i, n := range nrs {
-
-		ns[i] = n.toNode()
+	for i, nr := range nrs {
+		n, err := nr.toNode()
+		if err != nil {
+			return nil, err
+		}
+		ns[i] = n
 	}
 	return ns, nil
 }
@@ -566,19 +690,45 @@ func (ds *PostgresDatastore) CreateJob(ctx context.Context, j *tork.Job) error {
 		s := string(b)
 		secrets = &s
 	}
+	var trace *string
+	if j.Trace != nil {
+		b, err := json.Marshal(j.Trace)
+		if err != nil {
+			return errors.Wrapf(err, "failed to serialize job.trace")
+		}
+		s := string(b)
+		trace = &s
+	}
+	var idempotencyKey, idempotencyHash *string
+	if j.IdempotencyKey != "" {
+		idempotencyKey = &j.IdempotencyKey
+		idempotencyHash = &j.IdempotencyHash
+	}
 	return ds.WithTx(ctx, func(tx datastore.Datastore) error {
 		ptx, ok := tx.(*PostgresDatastore)
 		if !ok {
 			return errors.New("unable to cast to a postgres datastore")
 		}
+		if idempotencyKey != nil {
+			// release the key if it expired
+			if _, err := ptx.exec(`update jobs set idempotency_key = null 
+			                       where created_by = $1 and idempotency_key = $2 and created_at < $3`,
+				j.CreatedBy.ID, idempotencyKey, time.Now().UTC().Add(-ds.keyRetentionPeriod)); err != nil {
+				return errors.Wrapf(err, "error releasing expired idempotency key")
+			}
+		}
+		namespace := j.Namespace
+		if namespace == "" {
+			namespace = tork.NAMESPACE_DEFAULT
+		}
 		sql := `insert into jobs (id,name,description,state,created_at,started_at,tasks,position,
 					inputs,context,parent_id,task_count,output_,result,error_,defaults,webhooks,
-					created_by,tags,auto_delete,secrets) 
+					created_by,tags,auto_delete,secrets,trace,timeout,idempotency_key,idempotency_hash,namespace) 
 				values
-					($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$15,$16,$17,$18,$19,$20,$21)`
+					($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$15,$16,$17,$18,$19,$20,$21,$22,$23,$24,$25,$26)`
 		if _, err := ptx.exec(sql, j.ID, j.Name, j.Description, j.State, j.CreatedAt, j.StartedAt, tasks, j.Position,
 			inputs, c, j.ParentID, j.TaskCount, j.Output, j.Result, j.Error, defaults, webhooks, j.CreatedBy.ID,
-			pq.StringArray(j.Tags), autoDelete, secrets); err != nil {
+			pq.StringArray(j.Tags), autoDelete, secrets, trace, j.Timeout, idempotencyKey, idempotencyHash, namespace); err != nil {
 			return errors.Wrapf(err, "error inserting job to the db")
 		}
 		for _, perm := range j.Permissions {
@@ -643,9 +793,10 @@ func (ds *PostgresDatastore) UpdateJob(ctx context.Context, id string, modify fu
 				result = $7,
 				error_ = $8,
 				delete_at = $9,
-				progress = $10
-			  where id = $11`
-		_, err = ptx.exec(q, j.State, j.StartedAt, j.CompletedAt, j.FailedAt, j.Position, c, j.Result, j.Error, j.DeleteAt, j.Progress, j.ID)
+				progress = $10,
+				timeout_at = $11
+			  where id = $12`
+		_, err = ptx.exec(q, j.State, j.StartedAt, j.CompletedAt, j.FailedAt, j.Position, c, j.Result, j.Error, j.DeleteAt, j.Progress, j.TimeoutAt, j.ID)
 		return err
 	})
 }
@@ -733,6 +884,93 @@ func (ds *PostgresDatastore) GetActiveTasks(ctx context.Context, jobID string) (
 	return actives, nil
 }
 
+func (ds *PostgresDatastore) GetJobByIdempotencyKey(ctx context.Context, userID, key string) (*tork.Job, error) {
+	var id string
+	if err := ds.get(&id, `SELECT id FROM jobs where created_by = $1 and idempotency_key = $2 and created_at >= $3`,
+		userID, key, time.Now().UTC().Add(-ds.keyRetentionPeriod)); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, datastore.ErrJobNotFound
+		}
+		return nil, errors.Wrapf(err, "error fetching job from db")
+	}
+	return ds.GetJobByID(ctx, id)
+}
+
+func (ds *PostgresDatastore) GetTimedOutJobs(ctx context.Context, before time.Time) ([]*tork.Job, error) {
+	ids := make([]string, 0)
+	q := `SELECT id FROM jobs 
+	      WHERE (state = $1 OR state = $2) 
+	      AND timeout_at < $3`
+	if err := ds.select_(&ids, q, tork.JobStateScheduled, tork.JobStateRunning, before); err != nil {
+		return nil, errors.Wrapf(err, "error getting timed out jobs from db")
+	}
+	result := make([]*tork.Job, 0, len(ids))
+	for _, id := range ids {
+		j, err := ds.GetJobByID(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, j)
+	}
+	return result, nil
+}
+
+func (ds *PostgresDatastore) GetStalledTasks(ctx context.Context, heartbeatBefore time.Time) ([]*tork.Task, error) {
+	rs := make([]taskRecord, 0)
+	q := `SELECT t.*
+	      FROM tasks t LEFT JOIN nodes n ON t.node_id = n.id
+	      WHERE t.state = $1
+	      AND t.node_id is not null AND t.node_id != ''
+	      AND (n.last_heartbeat_at < $2 OR (n.id is null AND t.started_at < $2))`
+	if err := ds.select_(&rs, q, tork.TaskStateRunning, heartbeatBefore); err != nil {
+		return nil, errors.Wrapf(err, "error getting stalled tasks from db")
+	}
+	result := make([]*tork.Task, len(rs))
+	for i, r := range rs {
+		t, err := r.toTask()
+		if err != nil {
+			return nil, err
+		}
+		result[i] = t
+	}
+	return result, nil
+}
+
+func (ds *PostgresDatastore) GetDueRetries(ctx context.Context, before time.Time) ([]*tork.Task, error) {
+	rs := make([]taskRecord, 0)
+	q := `SELECT * FROM tasks
+	      WHERE state = $1 AND retry_at <= $2
+	      ORDER BY retry_at`
+	if err := ds.select_(&rs, q, tork.TaskStatePending, before); err != nil {
+		return nil, errors.Wrapf(err, "error getting due retries from db")
+	}
+	result := make([]*tork.Task, len(rs))
+	for i, r := range rs {
+		t, err := r.toTask()
+		if err != nil {
+			return nil, err
+		}
+		result[i] = t
+	}
+	return result, nil
+}
+
+func (ds *PostgresDatastore) GetNextTask(ctx context.Context, parentTaskID string) (*tork.Task, error) {
+	r := taskRecord{}
+	q := `SELECT * 
+	      FROM tasks 
+		  where parent_id = $1 AND state = $2
+		  ORDER BY position,created_at ASC
+		  LIMIT 1`
+	if err := ds.get(&r, q, parentTaskID, tork.TaskStateCreated); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, datastore.ErrTaskNotFound
+		}
+		return nil, errors.Wrapf(err, "error getting next task from db")
+	}
+	return r.toTask()
+}
+
 func (ds *PostgresDatastore) CreateTaskLogPart(ctx context.Context, p *tork.TaskLogPart) error {
 	if p.TaskID == "" {
 		return errors.Errorf("must provide task id")
@@ -881,6 +1119,10 @@ func (ds *PostgresDatastore) GetJobLogParts(ctx context.Context, jobID string, p
 }
 
 func (ds *PostgresDatastore) GetJobs(ctx context.Context, currentUser, q string, page, size int) (*datastore.Page[*tork.JobSummary], error) {
+	return ds.ListJobs(ctx, currentUser, datastore.JobQuery{Q: q, Page: page, Size: size, Desc: true})
+}
+
+func (ds *PostgresDatastore) ListJobs(ctx context.Context, currentUser string, q datastore.JobQuery) (*datastore.Page[*tork.JobSummary], error) {
 	parseQuery := func(query string) (string, []string) {
 		terms := []string{}
 		tags := []string{}
@@ -897,11 +1139,74 @@ func (ds *PostgresDatastore) GetJobs(ctx context.Context, currentUser, q string,
 		return strings.Join(terms, " "), tags
 	}
 
-	searchTerm, tags := parseQuery(q)
+	searchTerm, tags := parseQuery(q.Q)
 
-	offset := (page - 1) * size
-	rs := make([]jobRecord, 0)
-	qry := fmt.Sprintf(`
+	args := []any{searchTerm, pq.StringArray(tags), currentUser}
+	arg := func(v any) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+	where := []string{
+		"($1 = '' OR ts @@ websearch_to_tsquery('english', $1))",
+		"(coalesce(array_length($2::text[], 1),0) = 0 OR j.tags && $2)",
+		`($3 = '' OR EXISTS (select 1 from no_job_perms njp where njp.job_id=j.id) OR EXISTS (
+           SELECT 1
+           FROM job_perms_info jpi
+           WHERE jpi.job_id = j.id
+        ))`,
+	}
+	if q.Name != "" {
+		where = append(where, fmt.Sprintf(`j.name ILIKE %s ESCAPE '\'`, arg("%"+escapeLike(q.Name)+"%")))
+	}
+	if len(q.Namespaces) > 0 {
+		where = append(where, fmt.Sprintf("j.namespace = ANY(%s)", arg(pq.StringArray(q.Namespaces))))
+	}
+	if len(q.States) > 0 {
+		states := make(pq.StringArray, len(q.States))
+		for i, s := range q.States {
+			states[i] = string(s)
+		}
+		where = append(where, fmt.Sprintf("j.state = ANY(%s)", arg(states)))
+	}
+	if q.CreatedAfter != nil {
+		where = append(where, fmt.Sprintf("j.created_at >= %s", arg(q.CreatedAfter.UTC())))
+	}
+	if q.CreatedBefore != nil {
+		where = append(where, fmt.Sprintf("j.created_at < %s", arg(q.CreatedBefore.UTC())))
+	}
+	if q.Search != "" {
+		query := fmt.Sprintf("websearch_to_tsquery('english', %s)", arg(q.Search))
+		search := []string{
+			"j.ts @@ " + query,
+			"EXISTS (SELECT 1 FROM tasks t WHERE t.job_id = j.id AND t.ts @@ " + query + ")",
+		}
+		if q.SearchLogs {
+			search = append(search, `EXISTS (
+          SELECT 1
+          FROM tasks t
+          JOIN tasks_log_parts tlp ON tlp.task_id = t.id
+          WHERE t.job_id = j.id
+          AND to_tsvector('english', left(tlp.contents, 65536)) @@ `+query+`
+        )`)
+		}
+		where = append(where, "("+strings.Join(search, " OR ")+")")
+	}
+	for _, l := range q.Labels {
+		switch l.Op {
+		case datastore.LabelEquals:
+			where = append(where, fmt.Sprintf("%s = ANY(j.tags)", arg(l.Tag())))
+		case datastore.LabelNotEquals:
+			where = append(where, fmt.Sprintf("NOT (%s = ANY(j.tags))", arg(l.Tag())))
+		case datastore.LabelExists, datastore.LabelNotExists:
+			exists := fmt.Sprintf("EXISTS (SELECT 1 FROM unnest(j.tags) t WHERE t = %s OR starts_with(t, %s))", arg(l.Key), arg(l.Key+"="))
+			if l.Op == datastore.LabelNotExists {
+				exists = "NOT " + exists
+			}
+			where = append(where, exists)
+		}
+	}
+
+	with := `
       WITH user_info AS (
         SELECT id AS user_id
         FROM users
@@ -924,24 +1229,55 @@ func (ds *PostgresDatastore) GetJobs(ctx context.Context, currentUser, q string,
         where not exists (
 		  select 1 from jobs_perms jp where j.id = jp.job_id
 		)
-      )
-      SELECT j.*
+      )`
+	from := `
       FROM jobs j
-      WHERE 
-        ($1 = '' OR ts @@ plainto_tsquery('english', $1))
-      AND 
-        (coalesce(array_length($2::text[], 1),0) = 0 OR j.tags && $2)
-      AND
-        ($3 = '' OR EXISTS (select 1 from no_job_perms njp where njp.job_id=j.id) OR EXISTS (
-           SELECT 1
-           FROM job_perms_info jpi
-           WHERE jpi.job_id = j.id
-        ))
-	  ORDER BY created_at DESC 
-	  OFFSET %d LIMIT %d`, offset, size)
-	if err := ds.select_(&rs, qry, searchTerm, pq.StringArray(tags), currentUser); err != nil {
+      WHERE ` + strings.Join(where, "\n      AND ")
+
+	var count *int
+	if err := ds.get(&count, with+"\n      SELECT count(*)"+from, args...); err != nil {
+		return nil, errors.Wrapf(err, "error getting the jobs count")
+	}
+
+	sortKey := "j.created_at"
+	if q.Sort == datastore.JOBS_SORT_NAME {
+		sortKey = "coalesce(j.name,'')"
+	}
+	dir, cmp := "ASC", ">"
+	if q.Desc {
+		dir, cmp = "DESC", "<"
+	}
+	page := q.Page
+	if page < 1 || q.Cursor != "" {
+		page = 1
+	}
+	qry := with + "\n      SELECT j.*" + from
+	if q.Cursor != "" {
+		c, err := datastore.ParseJobCursor(q)
+		if err != nil {
+			return nil, err
+		}
+		var last any = c.Value
+		if q.Sort != datastore.JOBS_SORT_NAME {
+			last = c.CreatedAt()
+		}
+		qry = qry + fmt.Sprintf("\n      AND (%s, j.id) %s (%s, %s)", sortKey, cmp, arg(last), arg(c.ID))
+	}
+	offset := (page - 1) * q.Size
+	// fetch one more job than asked for to
+	// know whether there is a next page
+	qry = qry + fmt.Sprintf(`
+	  ORDER BY %s %s, j.id %s
+	  OFFSET %d LIMIT %d`, sortKey, dir, dir, offset, q.Size+1)
+	rs := make([]jobRecord, 0)
+	if err := ds.select_(&rs, qry, args...); err != nil {
 		return nil, errors.Wrapf(err, "error getting a page of jobs")
 	}
+	hasNext := len(rs) > q.Size
+	if hasNext {
+		rs = rs[:q.Size]
+	}
+	var next string
 	result := make([]*tork.JobSummary, len(rs))
 	for i, r := range rs {
 		createdBy, err := ds.GetUser(ctx, r.CreatedBy)
@@ -953,51 +1289,13 @@ func (ds *PostgresDatastore) GetJobs(ctx context.Context, currentUser, q string,
 			return nil, err
 		}
 		result[i] = tork.NewJobSummary(j)
+		if hasNext && i == len(rs)-1 {
+			next = datastore.NewJobCursor(q, j)
+		}
 	}
 
-	var count *int
-	if err := ds.get(&count, `
-      WITH user_info AS (
-        SELECT id AS user_id
-        FROM users
-        WHERE username_ = $3
-      ),
-      role_info AS (
-        SELECT role_id
-        FROM users_roles ur
-        JOIN user_info ui ON ur.user_id = ui.user_id
-      ),
-      job_perms_info AS (
-        SELECT job_id
-        FROM jobs_perms jp
-        WHERE jp.user_id = (SELECT user_id FROM user_info)
-        OR jp.role_id IN (SELECT role_id FROM role_info)
-      ),
-      no_job_perms AS (
-        SELECT j.id as job_id
-        FROM jobs j
-        where not exists (
-		  select 1 from jobs_perms jp where j.id = jp.job_id
-		)
-      )
-      SELECT count(*)
-      FROM jobs j
-      WHERE 
-        ($1 = '' OR ts @@ plainto_tsquery('english', $1))
-      AND 
-        (coalesce(array_length($2::text[], 1),0) = 0 OR j.tags && $2)
-      AND
-        ($3 = '' OR EXISTS (select 1 from no_job_perms njp where njp.job_id=j.id) OR EXISTS (
-           SELECT 1
-           FROM job_perms_info jpi
-           WHERE jpi.job_id = j.id
-        ));
-	  `, searchTerm, pq.StringArray(tags), currentUser); err != nil {
-		return nil, errors.Wrapf(err, "error getting the jobs count")
-	}
-
-	totalPages := *count / size
-	if *count%size != 0 {
+	totalPages := *count / q.Size
+	if *count%q.Size != 0 {
 		totalPages = totalPages + 1
 	}
 
@@ -1007,9 +1305,15 @@ func (ds *PostgresDatastore) GetJobs(ctx context.Context, currentUser, q string,
 		Size:       len(result),
 		TotalPages: totalPages,
 		TotalItems: *count,
+		NextCursor: next,
 	}, nil
 }
 
+// escapeLike escapes the wildcards of a LIKE pattern.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
+
 func (ds *PostgresDatastore) GetUser(ctx context.Context, uid string) (*tork.User, error) {
 	r := userRecord{}
 	if err := ds.get(&r, `SELECT * FROM users where (username_ = $1 or id = $1)`, uid); err != nil {
@@ -1036,6 +1340,355 @@ func (ds *PostgresDatastore) CreateUser(ctx context.Context, u *tork.User) error
 	return nil
 }
 
+func (ds *PostgresDatastore) CreateAPIKey(ctx context.Context, k *tork.APIKey) error {
+	if k.KeyHash == "" {
+		return errors.New("must provide key hash")
+	}
+	k.ID = uuid.NewUUID()
+	now := time.Now().UTC()
+	k.CreatedAt = &now
+	var createdBy *string
+	if k.CreatedBy != "" {
+		createdBy = &k.CreatedBy
+	}
+	q := `insert into api_keys 
+	       (id,name,key_hash,created_by,created_at) 
+	      values
+	       ($1,$2,$3,$4,$5)`
+	_, err := ds.exec(q, k.ID, k.Name, k.KeyHash, createdBy, k.CreatedAt)
+	if err != nil {
+		return errors.Wrapf(err, "error inserting api key to the db")
+	}
+	return nil
+}
+
+func (ds *PostgresDatastore) GetAPIKey(ctx context.Context, keyHash string) (*tork.APIKey, error) {
+	r := apiKeyRecord{}
+	if err := ds.get(&r, `SELECT * FROM api_keys where key_hash = $1`, keyHash); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, datastore.ErrAPIKeyNotFound
+		}
+		return nil, errors.Wrapf(err, "error fetching api key from db")
+	}
+	return r.toAPIKey(), nil
+}
+
+func (ds *PostgresDatastore) GetAPIKeys(ctx context.Context) ([]*tork.APIKey, error) {
+	rs := []apiKeyRecord{}
+	if err := ds.select_(&rs, `SELECT * FROM api_keys order by created_at`); err != nil {
+		return nil, errors.Wrapf(err, "error getting api keys from the db")
+	}
+	result := make([]*tork.APIKey, len(rs))
+	for i, r := range rs {
+		result[i] = r.toAPIKey()
+	}
+	return result, nil
+}
+
+func (ds *PostgresDatastore) DeleteAPIKey(ctx context.Context, id string) error {
+	res, err := ds.exec(`delete from api_keys where id = $1`, id)
+	if err != nil {
+		return errors.Wrapf(err, "error deleting api key from the db")
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrapf(err, "error deleting api key from the db")
+	}
+	if n == 0 {
+		return datastore.ErrAPIKeyNotFound
+	}
+	return nil
+}
+
+func (ds *PostgresDatastore) CreateDeadLetter(ctx context.Context, dl *tork.DeadLetter) error {
+	if dl.ID == "" {
+		return errors.New("must provide ID")
+	}
+	if dl.CreatedAt == nil {
+		now := time.Now().UTC()
+		dl.CreatedAt = &now
+	}
+	task, err := json.Marshal(dl.Task)
+	if err != nil {
+		return errors.Wrapf(err, "failed to serialize dead_letter.task")
+	}
+	var errMsg *string
+	if dl.Error != "" {
+		errMsg = &dl.Error
+	}
+	q := `insert into dead_letters 
+	       (id,queue,task,error_,attempts,created_at) 
+	      values
+	       ($1,$2,$3,$4,$5,$6)`
+	if _, err := ds.exec(q, dl.ID, dl.Queue, task, errMsg, dl.Attempts, dl.CreatedAt); err != nil {
+		return errors.Wrapf(err, "error inserting dead letter to the db")
+	}
+	return nil
+}
+
+func (ds *PostgresDatastore) GetDeadLetterByID(ctx context.Context, id string) (*tork.DeadLetter, error) {
+	r := deadLetterRecord{}
+	if err := ds.get(&r, `SELECT * FROM dead_letters where id = $1`, id); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, datastore.ErrDeadLetterNotFound
+		}
+		return nil, errors.Wrapf(err, "error fetching dead letter from db")
+	}
+	return r.toDeadLetter()
+}
+
+func (ds *PostgresDatastore) GetDeadLetters(ctx context.Context, page, size int) (*datastore.Page[*tork.DeadLetter], error) {
+	offset := (page - 1) * size
+	rs := []deadLetterRecord{}
+	q := fmt.Sprintf(`select * 
+	      from dead_letters 
+		  order by created_at DESC
+		  offset %d limit %d`, offset, size)
+	if err := ds.select_(&rs, q); err != nil {
+		return nil, errors.Wrapf(err, "error getting dead letters from db")
+	}
+	items := make([]*tork.DeadLetter, len(rs))
+	for i, r := range rs {
+		dl, err := r.toDeadLetter()
+		if err != nil {
+			return nil, err
+		}
+		items[i] = dl
+	}
+	var count *int
+	if err := ds.get(&count, `select count(*) from dead_letters`); err != nil {
+		return nil, errors.Wrapf(err, "error getting the dead letters count")
+	}
+	totalPages := *count / size
+	if *count%size != 0 {
+		totalPages = totalPages + 1
+	}
+	return &datastore.Page[*tork.DeadLetter]{
+		Items:      items,
+		Number:     page,
+		Size:       len(items),
+		TotalPages: totalPages,
+		TotalItems: *count,
+	}, nil
+}
+
+func (ds *PostgresDatastore) DeleteDeadLetter(ctx context.Context, id string) error {
+	res, err := ds.exec(`delete from dead_letters where id = $1`, id)
+	if err != nil {
+		return errors.Wrapf(err, "error deleting dead letter from the db")
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrapf(err, "error deleting dead letter from the db")
+	}
+	if n == 0 {
+		return datastore.ErrDeadLetterNotFound
+	}
+	return nil
+}
+
+func (ds *PostgresDatastore) SetSecret(ctx context.Context, s *tork.Secret) error {
+	if s.Name == "" {
+		return errors.New("must provide secret name")
+	}
+	now := time.Now().UTC()
+	var createdBy *string
+	if s.CreatedBy != "" {
+		createdBy = &s.CreatedBy
+	}
+	q := `insert into secrets 
+	       (name,value_,created_by,created_at,updated_at) 
+	      values
+	       ($1,$2,$3,$4,$4)
+	      on conflict (name) do update set value_ = $2, updated_at = $4`
+	if _, err := ds.exec(q, s.Name, s.Value, createdBy, now); err != nil {
+		return errors.Wrapf(err, "error writing secret to the db")
+	}
+	saved, err := ds.GetSecret(ctx, s.Name)
+	if err != nil {
+		return err
+	}
+	*s = *saved
+	return nil
+}
+
+func (ds *PostgresDatastore) GetSecret(ctx context.Context, name string) (*tork.Secret, error) {
+	r := secretRecord{}
+	if err := ds.get(&r, `SELECT * FROM secrets where name = $1`, name); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, datastore.ErrSecretNotFound
+		}
+		return nil, errors.Wrapf(err, "error fetching secret from db")
+	}
+	return r.toSecret(), nil
+}
+
+func (ds *PostgresDatastore) GetSecrets(ctx context.Context) ([]*tork.Secret, error) {
+	rs := []secretRecord{}
+	if err := ds.select_(&rs, `SELECT * FROM secrets order by name`); err != nil {
+		return nil, errors.Wrapf(err, "error getting secrets from the db")
+	}
+	result := make([]*tork.Secret, len(rs))
+	for i, r := range rs {
+		result[i] = r.toSecret()
+	}
+	return result, nil
+}
+
+func (ds *PostgresDatastore) DeleteSecret(ctx context.Context, name string) error {
+	res, err := ds.exec(`delete from secrets where name = $1`, name)
+	if err != nil {
+		return errors.Wrapf(err, "error deleting secret from the db")
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrapf(err, "error deleting secret from the db")
+	}
+	if n == 0 {
+		return datastore.ErrSecretNotFound
+	}
+	return nil
+}
+
+func (ds *PostgresDatastore) AcquireLease(ctx context.Context, name, holder string, ttl time.Duration) (bool, error) {
+	// the database's clock is used, so that the expiry
+	// doesn't depend on the clocks of the holders agreeing
+	q := `INSERT INTO leases (name,holder,expires_at)
+	      VALUES ($1,$2,(now() at time zone 'utc') + make_interval(secs => $3))
+	      ON CONFLICT (name) DO UPDATE SET holder = EXCLUDED.holder, expires_at = EXCLUDED.expires_at
+	      WHERE leases.holder = EXCLUDED.holder OR leases.expires_at < (now() at time zone 'utc')`
+	res, err := ds.exec(q, name, holder, ttl.Seconds())
+	if err != nil {
+		return false, errors.Wrapf(err, "error acquiring lease %s", name)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, errors.Wrapf(err, "error acquiring lease %s", name)
+	}
+	return n == 1, nil
+}
+
+func (ds *PostgresDatastore) ReleaseLease(ctx context.Context, name, holder string) error {
+	if _, err := ds.exec(`DELETE FROM leases WHERE name = $1 AND holder = $2`, name, holder); err != nil {
+		return errors.Wrapf(err, "error releasing lease %s", name)
+	}
+	return nil
+}
+
+func (ds *PostgresDatastore) CreateScheduledJob(ctx context.Context, sj *tork.ScheduledJob) error {
+	if sj.ID == "" {
+		return errors.Errorf("scheduled job id must not be empty")
+	}
+	if sj.CreatedBy == nil {
+		guest, err := ds.GetUser(ctx, tork.USER_GUEST)
+		if err != nil {
+			return err
+		}
+		sj.CreatedBy = guest
+	}
+	template, err := json.Marshal(sj.Template)
+	if err != nil {
+		return errors.Wrapf(err, "failed to serialize scheduled_job.template")
+	}
+	q := `insert into scheduled_jobs 
+	       (id,name,description,cron_expr,overlap,state,template,created_at,created_by) 
+	      values
+	       ($1,$2,$3,$4,$5,$6,$7,$8,$9)`
+	if _, err := ds.exec(q, sj.ID, sj.Name, sj.Description, sj.Cron, sj.Overlap, sj.State,
+		template, sj.CreatedAt, sj.CreatedBy.ID); err != nil {
+		return errors.Wrapf(err, "error inserting scheduled job to the db")
+	}
+	return nil
+}
+
+func (ds *PostgresDatastore) UpdateScheduledJob(ctx context.Context, id string, modify func(u *tork.ScheduledJob) error) error {
+	return ds.WithTx(ctx, func(tx datastore.Datastore) error {
+		ptx, ok := tx.(*PostgresDatastore)
+		if !ok {
+			return errors.New("unable to cast to a postgres datastore")
+		}
+		r := scheduledJobRecord{}
+		if err := ptx.get(&r, `SELECT * FROM scheduled_jobs where id = $1 for update`, id); err != nil {
+			if err == sql.ErrNoRows {
+				return datastore.ErrScheduledJobNotFound
+			}
+			return errors.Wrapf(err, "error fetching scheduled job from db")
+		}
+		createdBy, err := ptx.GetUser(ctx, r.CreatedBy)
+		if err != nil {
+			return err
+		}
+		sj, err := r.toScheduledJob(createdBy)
+		if err != nil {
+			return err
+		}
+		if err := modify(sj); err != nil {
+			return err
+		}
+		var lastJobID *string
+		if sj.LastJobID != "" {
+			lastJobID = &sj.LastJobID
+		}
+		q := `update scheduled_jobs set 
+				state = $1,
+				last_run_at = $2,
+				last_job_id = $3
+			  where id = $4`
+		_, err = ptx.exec(q, sj.State, sj.LastRunAt, lastJobID, sj.ID)
+		return err
+	})
+}
+
+func (ds *PostgresDatastore) GetScheduledJobByID(ctx context.Context, id string) (*tork.ScheduledJob, error) {
+	r := scheduledJobRecord{}
+	if err := ds.get(&r, `SELECT * FROM scheduled_jobs where id = $1`, id); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, datastore.ErrScheduledJobNotFound
+		}
+		return nil, errors.Wrapf(err, "error fetching scheduled job from db")
+	}
+	createdBy, err := ds.GetUser(ctx, r.CreatedBy)
+	if err != nil {
+		return nil, err
+	}
+	return r.toScheduledJob(createdBy)
+}
+
+func (ds *PostgresDatastore) GetScheduledJobs(ctx context.Context) ([]*tork.ScheduledJob, error) {
+	rs := []scheduledJobRecord{}
+	if err := ds.select_(&rs, `SELECT * FROM scheduled_jobs order by created_at`); err != nil {
+		return nil, errors.Wrapf(err, "error getting scheduled jobs from the db")
+	}
+	result := make([]*tork.ScheduledJob, len(rs))
+	for i, r := range rs {
+		createdBy, err := ds.GetUser(ctx, r.CreatedBy)
+		if err != nil {
+			return nil, err
+		}
+		sj, err := r.toScheduledJob(createdBy)
+		if err != nil {
+			return nil, err
+		}
+		result[i] = sj
+	}
+	return result, nil
+}
+
+func (ds *PostgresDatastore) DeleteScheduledJob(ctx context.Context, id string) error {
+	res, err := ds.exec(`delete from scheduled_jobs where id = $1`, id)
+	if err != nil {
+		return errors.Wrapf(err, "error deleting scheduled job from the db")
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrapf(err, "error deleting scheduled job from the db")
+	}
+	if n == 0 {
+		return datastore.ErrScheduledJobNotFound
+	}
+	return nil
+}
+
 func (ds *PostgresDatastore) CreateRole(ctx context.Context, r *tork.Role) error {
 	r.ID = uuid.NewUUID()
 	now := time.Now().UTC()
@@ -1054,6 +1707,9 @@ func (ds *PostgresDatastore) CreateRole(ctx context.Context, r *tork.Role) error
 func (ds *PostgresDatastore) GetRole(ctx context.Context, id string) (*tork.Role, error) {
 	r := roleRecord{}
 	if err := ds.get(&r, `SELECT * FROM roles where id = $1 or slug = $1`, id); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, datastore.ErrRoleNotFound
+		}
 		return nil, errors.Wrapf(err, "error fetching role from db")
 	}
 	return r.toRole(), nil
@@ -1122,6 +1778,14 @@ func (ds *PostgresDatastore) GetMetrics(ctx context.Context) (*tork.Metrics, err
 		return nil, errors.Wrapf(err, "error getting the running tasks count")
 	}
 
+	if err := ds.get(&s.Nodes.MemoryPercent, "select coalesce(avg(memory_percent),0) from nodes where last_heartbeat_at > current_timestamp - interval '5 minutes' and memory_percent > 0"); err != nil {
+		return nil, errors.Wrapf(err, "error getting the average memory usage")
+	}
+
+	if err := ds.get(&s.Nodes.DiskPercent, "select coalesce(avg(disk_percent),0) from nodes where last_heartbeat_at > current_timestamp - interval '5 minutes' and disk_percent > 0"); err != nil {
+		return nil, errors.Wrapf(err, "error getting the average disk usage")
+	}
+
 	return s, nil
 }
 
@@ -11,7 +11,8 @@ import (
 
 	"github.com/runabol/tork"
 	"github.com/runabol/tork/datastore"
-	"github.com/runabol/tork/db/postgres"
+	schema "github.com/runabol/tork/db/postgres"
+	"github.com/runabol/tork/internal/hash"
 
 	"github.com/runabol/tork/internal/uuid"
 	"github.com/stretchr/testify/assert"
@@ -164,6 +165,149 @@ func TestPostgresCreateTaskBadOutput(t *testing.T) {
 	assert.Equal(t, t1.Description, t2.Description)
 }
 
+func TestPostgresGetNextTask(t *testing.T) {
+	ctx := context.Background()
+	dsn := "host=localhost user=tork password=tork dbname=tork port=5432 sslmode=disable"
+	ds, err := NewPostgresDataStore(dsn)
+	assert.NoError(t, err)
+
+	j1 := tork.Job{
+		ID:        uuid.NewUUID(),
+		CreatedAt: time.Now().UTC(),
+	}
+	err = ds.CreateJob(ctx, &j1)
+	assert.NoError(t, err)
+
+	now := time.Now().UTC()
+
+	pt := tork.Task{
+		ID:        uuid.NewUUID(),
+		State:     tork.TaskStateRunning,
+		CreatedAt: &now,
+		JobID:     j1.ID,
+	}
+	err = ds.CreateTask(ctx, &pt)
+	assert.NoError(t, err)
+
+	_, err = ds.GetNextTask(ctx, pt.ID)
+	assert.ErrorIs(t, err, datastore.ErrTaskNotFound)
+
+	t1 := tork.Task{
+		ID:        uuid.NewUUID(),
+		State:     tork.TaskStateCreated,
+		CreatedAt: &now,
+		JobID:     j1.ID,
+		ParentID:  pt.ID,
+	}
+	err = ds.CreateTask(ctx, &t1)
+	assert.NoError(t, err)
+
+	next, err := ds.GetNextTask(ctx, pt.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, t1.ID, next.ID)
+}
+
+func TestPostgresGetStalledTasks(t *testing.T) {
+	ctx := context.Background()
+	schemaName := fmt.Sprintf("tork%d", rand.Int())
+	dsn := `host=localhost user=tork password=tork dbname=tork search_path=%s sslmode=disable`
+	ds, err := NewPostgresDataStore(fmt.Sprintf(dsn, schemaName))
+	assert.NoError(t, err)
+	_, err = ds.db.Exec(fmt.Sprintf("create schema %s", schemaName))
+	assert.NoError(t, err)
+	defer func() {
+		_, err = ds.db.Exec(fmt.Sprintf("drop schema %s cascade", schemaName))
+		assert.NoError(t, err)
+	}()
+	err = ds.Migrate(context.Background())
+	assert.NoError(t, err)
+
+	now := time.Now().UTC()
+	startedAt := now.Add(-time.Minute * 10)
+
+	j1 := tork.Job{
+		ID:        uuid.NewUUID(),
+		CreatedAt: now,
+	}
+	err = ds.CreateJob(ctx, &j1)
+	assert.NoError(t, err)
+
+	alive := &tork.Node{ID: uuid.NewUUID(), LastHeartbeatAt: now}
+	dead := &tork.Node{ID: uuid.NewUUID(), LastHeartbeatAt: now.Add(-time.Minute * 6)}
+	assert.NoError(t, ds.CreateNode(ctx, alive))
+	assert.NoError(t, ds.CreateNode(ctx, dead))
+
+	t1 := &tork.Task{ID: uuid.NewUUID(), JobID: j1.ID, CreatedAt: &now, State: tork.TaskStateRunning, NodeID: alive.ID, StartedAt: &startedAt}
+	t2 := &tork.Task{ID: uuid.NewUUID(), JobID: j1.ID, CreatedAt: &now, State: tork.TaskStateRunning, NodeID: dead.ID, StartedAt: &startedAt}
+	t3 := &tork.Task{ID: uuid.NewUUID(), JobID: j1.ID, CreatedAt: &now, State: tork.TaskStateCompleted, NodeID: dead.ID, StartedAt: &startedAt}
+	t4 := &tork.Task{ID: uuid.NewUUID(), JobID: j1.ID, CreatedAt: &now, State: tork.TaskStateRunning, NodeID: uuid.NewUUID(), StartedAt: &startedAt}
+	t5 := &tork.Task{ID: uuid.NewUUID(), JobID: j1.ID, CreatedAt: &now, State: tork.TaskStateRunning, StartedAt: &startedAt}
+	for _, ta := range []*tork.Task{t1, t2, t3, t4, t5} {
+		assert.NoError(t, ds.CreateTask(ctx, ta))
+	}
+
+	stalled, err := ds.GetStalledTasks(ctx, now.Add(-time.Minute*5))
+	assert.NoError(t, err)
+	ids := make([]string, len(stalled))
+	for i, st := range stalled {
+		ids[i] = st.ID
+	}
+	assert.ElementsMatch(t, []string{t2.ID, t4.ID}, ids)
+}
+
+func TestPostgresGetJobByIdempotencyKey(t *testing.T) {
+	ctx := context.Background()
+	schemaName := fmt.Sprintf("tork%d", rand.Int())
+	dsn := `host=localhost user=tork password=tork dbname=tork search_path=%s sslmode=disable`
+	ds, err := NewPostgresDataStore(fmt.Sprintf(dsn, schemaName))
+	assert.NoError(t, err)
+	_, err = ds.db.Exec(fmt.Sprintf("create schema %s", schemaName))
+	assert.NoError(t, err)
+	defer func() {
+		_, err = ds.db.Exec(fmt.Sprintf("drop schema %s cascade", schemaName))
+		assert.NoError(t, err)
+	}()
+	err = ds.Migrate(context.Background())
+	assert.NoError(t, err)
+
+	now := time.Now().UTC()
+	j1 := tork.Job{ID: uuid.NewUUID(), CreatedAt: now, IdempotencyKey: "some-key"}
+	assert.NoError(t, ds.CreateJob(ctx, &j1))
+	// jobs without a key don't collide
+	assert.NoError(t, ds.CreateJob(ctx, &tork.Job{ID: uuid.NewUUID(), CreatedAt: now}))
+	assert.NoError(t, ds.CreateJob(ctx, &tork.Job{ID: uuid.NewUUID(), CreatedAt: now}))
+
+	j2, err := ds.GetJobByIdempotencyKey(ctx, j1.CreatedBy.ID, "some-key")
+	assert.NoError(t, err)
+	assert.Equal(t, j1.ID, j2.ID)
+	assert.Equal(t, "some-key", j2.IdempotencyKey)
+
+	_, err = ds.GetJobByIdempotencyKey(ctx, j1.CreatedBy.ID, "other-key")
+	assert.ErrorIs(t, err, datastore.ErrJobNotFound)
+
+	err = ds.CreateJob(ctx, &tork.Job{ID: uuid.NewUUID(), CreatedAt: now, IdempotencyKey: "some-key"})
+	assert.Error(t, err)
+
+	// keys are scoped to the user
+	u := &tork.User{ID: uuid.NewUUID(), Username: uuid.NewShortUUID(), Name: "Some User"}
+	assert.NoError(t, ds.CreateUser(ctx, u))
+	_, err = ds.GetJobByIdempotencyKey(ctx, u.ID, "some-key")
+	assert.ErrorIs(t, err, datastore.ErrJobNotFound)
+	assert.NoError(t, ds.CreateJob(ctx, &tork.Job{ID: uuid.NewUUID(), CreatedAt: now, IdempotencyKey: "some-key", CreatedBy: u}))
+
+	// expired keys are released
+	old := tork.Job{ID: uuid.NewUUID(), CreatedAt: now.Add(-time.Hour * 48), IdempotencyKey: "old-key", IdempotencyHash: "1234"}
+	assert.NoError(t, ds.CreateJob(ctx, &old))
+	_, err = ds.GetJobByIdempotencyKey(ctx, old.CreatedBy.ID, "old-key")
+	assert.ErrorIs(t, err, datastore.ErrJobNotFound)
+	j3 := tork.Job{ID: uuid.NewUUID(), CreatedAt: now, IdempotencyKey: "old-key", IdempotencyHash: "5678"}
+	assert.NoError(t, ds.CreateJob(ctx, &j3))
+	j4, err := ds.GetJobByIdempotencyKey(ctx, j3.CreatedBy.ID, "old-key")
+	assert.NoError(t, err)
+	assert.Equal(t, j3.ID, j4.ID)
+	assert.Equal(t, "5678", j4.IdempotencyHash)
+}
+
 func TestPostgresGetActiveTasks(t *testing.T) {
 	ctx := context.Background()
 	dsn := "host=localhost user=tork password=tork dbname=tork port=5432 sslmode=disable"
@@ -345,6 +489,9 @@ func TestPostgresCreateAndGetNode(t *testing.T) {
 		Hostname: "some-name",
 		Port:     1234,
 		Version:  "1.0.0",
+
+		MemoryPercent: 40,
+		DiskPercent:   60,
 	}
 	err = ds.CreateNode(ctx, n1)
 	assert.NoError(t, err)
@@ -355,6 +502,8 @@ func TestPostgresCreateAndGetNode(t *testing.T) {
 	assert.Equal(t, 1234, n2.Port)
 	assert.Equal(t, "1.0.0", n2.Version)
 	assert.Equal(t, "some node", n2.Name)
+	assert.Equal(t, float64(40), n2.MemoryPercent)
+	assert.Equal(t, float64(60), n2.DiskPercent)
 }
 
 func TestPostgresUpdateNode(t *testing.T) {
@@ -375,6 +524,8 @@ func TestPostgresUpdateNode(t *testing.T) {
 	err = ds.UpdateNode(ctx, n1.ID, func(u *tork.Node) error {
 		u.LastHeartbeatAt = now
 		u.TaskCount = 2
+		u.MemoryPercent = 40
+		u.DiskPercent = 60
 		return nil
 	})
 	assert.NoError(t, err)
@@ -385,6 +536,8 @@ func TestPostgresUpdateNode(t *testing.T) {
 	assert.Equal(t, now.Minute(), n2.LastHeartbeatAt.Minute())
 	assert.Equal(t, now.Second(), n2.LastHeartbeatAt.Second())
 	assert.Equal(t, 2, n2.TaskCount)
+	assert.Equal(t, float64(40), n2.MemoryPercent)
+	assert.Equal(t, float64(60), n2.DiskPercent)
 }
 
 func TestPostgresUpdateNodeConcurrently(t *testing.T) {
@@ -437,7 +590,7 @@ func TestPostgresGetActiveNodes(t *testing.T) {
 		_, err = ds.db.Exec(fmt.Sprintf("drop schema %s cascade", schemaName))
 		assert.NoError(t, err)
 	}()
-	err = ds.ExecScript(postgres.SCHEMA)
+	err = ds.Migrate(context.Background())
 	assert.NoError(t, err)
 	n1 := &tork.Node{
 		ID:              uuid.NewUUID(),
@@ -629,7 +782,7 @@ func TestPostgresGetJobs(t *testing.T) {
 		_, err = ds.db.Exec(fmt.Sprintf("drop schema %s cascade", schemaName))
 		assert.NoError(t, err)
 	}()
-	err = ds.ExecScript(postgres.SCHEMA)
+	err = ds.Migrate(context.Background())
 	assert.NoError(t, err)
 	for i := 0; i < 101; i++ {
 		j1 := tork.Job{
@@ -674,6 +827,171 @@ func TestPostgresGetJobs(t *testing.T) {
 	assert.NotEqual(t, p2.Items[0].ID, p1.Items[9].ID)
 }
 
+func TestPostgresListJobs(t *testing.T) {
+	schemaName := fmt.Sprintf("tork%d", rand.Int())
+	dsn := `host=localhost user=tork password=tork dbname=tork search_path=%s sslmode=disable`
+	ds, err := NewPostgresDataStore(fmt.Sprintf(dsn, schemaName))
+	assert.NoError(t, err)
+	_, err = ds.db.Exec(fmt.Sprintf("create schema %s", schemaName))
+	assert.NoError(t, err)
+	defer func() {
+		_, err = ds.db.Exec(fmt.Sprintf("drop schema %s cascade", schemaName))
+		assert.NoError(t, err)
+	}()
+	err = ds.Migrate(context.Background())
+	assert.NoError(t, err)
+
+	ctx := context.Background()
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	for i := 0; i < 25; i++ {
+		state := tork.JobStateCompleted
+		if i%5 == 0 {
+			state = tork.JobStateFailed
+		}
+		tags := []string{fmt.Sprintf("batch=%d", i%2)}
+		if i < 3 {
+			tags = append(tags, "nightly")
+		}
+		// the others are in the default namespace
+		var namespace string
+		if i%3 == 0 {
+			namespace = "team-a"
+		}
+		err := ds.CreateJob(ctx, &tork.Job{
+			ID:        uuid.NewUUID(),
+			Name:      fmt.Sprintf("Job %02d", i),
+			Namespace: namespace,
+			State:     state,
+			CreatedAt: now.Add(time.Duration(i) * time.Minute),
+			Tags:      tags,
+		})
+		assert.NoError(t, err)
+	}
+
+	// filters
+	p, err := ds.ListJobs(ctx, "", datastore.JobQuery{Namespaces: []string{"team-a"}, Size: 20})
+	assert.NoError(t, err)
+	assert.Equal(t, 9, p.TotalItems)
+	assert.Equal(t, "team-a", p.Items[0].Namespace)
+
+	p, err = ds.ListJobs(ctx, "", datastore.JobQuery{Namespaces: []string{tork.NAMESPACE_DEFAULT}, Size: 20})
+	assert.NoError(t, err)
+	assert.Equal(t, 16, p.TotalItems)
+
+	p, err = ds.ListJobs(ctx, "", datastore.JobQuery{States: []tork.JobState{tork.JobStateFailed}, Size: 10})
+	assert.NoError(t, err)
+	assert.Equal(t, 5, p.TotalItems)
+
+	p, err = ds.ListJobs(ctx, "", datastore.JobQuery{Name: "job 1", Size: 20})
+	assert.NoError(t, err)
+	assert.Equal(t, 10, p.TotalItems)
+
+	after := now.Add(time.Minute * 10)
+	before := now.Add(time.Minute * 20)
+	p, err = ds.ListJobs(ctx, "", datastore.JobQuery{CreatedAfter: &after, CreatedBefore: &before, Size: 20})
+	assert.NoError(t, err)
+	assert.Equal(t, 10, p.TotalItems)
+
+	labels, err := datastore.ParseLabelSelector("batch=0,!nightly")
+	assert.NoError(t, err)
+	p, err = ds.ListJobs(ctx, "", datastore.JobQuery{Labels: labels, Size: 20})
+	assert.NoError(t, err)
+	assert.Equal(t, 11, p.TotalItems)
+
+	// sorting
+	p, err = ds.ListJobs(ctx, "", datastore.JobQuery{Sort: datastore.JOBS_SORT_NAME, Size: 3})
+	assert.NoError(t, err)
+	assert.Equal(t, "Job 00", p.Items[0].Name)
+	assert.Equal(t, "Job 02", p.Items[2].Name)
+
+	// cursor pagination
+	q := datastore.JobQuery{Sort: datastore.JOBS_SORT_CREATED_AT, Desc: true, Size: 10}
+	names := make([]string, 0)
+	for {
+		p, err := ds.ListJobs(ctx, "", q)
+		assert.NoError(t, err)
+		for _, j := range p.Items {
+			names = append(names, j.Name)
+		}
+		if p.NextCursor == "" {
+			break
+		}
+		q.Cursor = p.NextCursor
+	}
+	assert.Len(t, names, 25)
+	assert.Equal(t, "Job 24", names[0])
+	assert.Equal(t, "Job 00", names[24])
+
+	q.Desc = false
+	_, err = ds.ListJobs(ctx, "", q)
+	assert.ErrorIs(t, err, datastore.ErrInvalidCursor)
+}
+
+func TestPostgresFullTextSearchJobs(t *testing.T) {
+	schemaName := fmt.Sprintf("tork%d", rand.Int())
+	dsn := `host=localhost user=tork password=tork dbname=tork search_path=%s sslmode=disable`
+	ds, err := NewPostgresDataStore(fmt.Sprintf(dsn, schemaName))
+	assert.NoError(t, err)
+	_, err = ds.db.Exec(fmt.Sprintf("create schema %s", schemaName))
+	assert.NoError(t, err)
+	defer func() {
+		_, err = ds.db.Exec(fmt.Sprintf("drop schema %s cascade", schemaName))
+		assert.NoError(t, err)
+	}()
+	err = ds.Migrate(context.Background())
+	assert.NoError(t, err)
+
+	ctx := context.Background()
+	now := time.Now().UTC()
+	jobs := []struct {
+		name  string
+		state tork.JobState
+		task  tork.Task
+		log   string
+	}{
+		{"nightly backup", tork.JobStateFailed, tork.Task{Name: "dump db", Error: "container OOMKilled: out of memory"}, ""},
+		{"nightly report", tork.JobStateCompleted, tork.Task{Name: "render", Result: "report sent to finance"}, ""},
+		{"cleanup", tork.JobStateCompleted, tork.Task{Name: "sweep"}, "warning: disk almost full"},
+	}
+	for _, j := range jobs {
+		job := &tork.Job{
+			ID:        uuid.NewUUID(),
+			Name:      j.name,
+			State:     j.state,
+			CreatedAt: now,
+		}
+		err := ds.CreateJob(ctx, job)
+		assert.NoError(t, err)
+		task := j.task
+		task.ID = uuid.NewUUID()
+		task.JobID = job.ID
+		task.CreatedAt = &now
+		err = ds.CreateTask(ctx, &task)
+		assert.NoError(t, err)
+		if j.log != "" {
+			err = ds.CreateTaskLogPart(ctx, &tork.TaskLogPart{Number: 1, TaskID: task.ID, Contents: j.log})
+			assert.NoError(t, err)
+		}
+	}
+
+	search := func(q datastore.JobQuery) []string {
+		q.Size = 10
+		p, err := ds.ListJobs(ctx, "", q)
+		assert.NoError(t, err)
+		names := make([]string, 0)
+		for _, j := range p.Items {
+			names = append(names, j.Name)
+		}
+		return names
+	}
+	assert.Equal(t, []string{"nightly backup"}, search(datastore.JobQuery{Search: "memory"}))
+	assert.Equal(t, []string{"nightly report"}, search(datastore.JobQuery{Search: "finance"}))
+	assert.Equal(t, []string{"nightly backup", "nightly report"}, search(datastore.JobQuery{Search: "nightly", Sort: datastore.JOBS_SORT_NAME}))
+	assert.Equal(t, []string{"nightly backup"}, search(datastore.JobQuery{Search: "nightly", States: []tork.JobState{tork.JobStateFailed}}))
+	assert.Empty(t, search(datastore.JobQuery{Search: "disk"}))
+	assert.Equal(t, []string{"cleanup"}, search(datastore.JobQuery{Search: "disk", SearchLogs: true}))
+}
+
 func TestPostgresSearchJobs(t *testing.T) {
 	ctx := context.Background()
 	schemaName := fmt.Sprintf("tork%d", rand.Int())
@@ -686,7 +1004,7 @@ func TestPostgresSearchJobs(t *testing.T) {
 		_, err = ds.db.Exec(fmt.Sprintf("drop schema %s cascade", schemaName))
 		assert.NoError(t, err)
 	}()
-	err = ds.ExecScript(postgres.SCHEMA)
+	err = ds.Migrate(context.Background())
 	assert.NoError(t, err)
 
 	u1 := &tork.User{
@@ -838,7 +1156,7 @@ func TestPostgresGetMetrics(t *testing.T) {
 		_, err = ds.db.Exec(fmt.Sprintf("drop schema %s cascade", schemaName))
 		assert.NoError(t, err)
 	}()
-	err = ds.ExecScript(postgres.SCHEMA)
+	err = ds.Migrate(context.Background())
 	assert.NoError(t, err)
 	s, err := ds.GetMetrics(ctx)
 	assert.NoError(t, err)
@@ -889,6 +1207,7 @@ func TestPostgresGetMetrics(t *testing.T) {
 			ID:              uuid.NewUUID(),
 			LastHeartbeatAt: time.Now().UTC().Add(-time.Minute * time.Duration(i)),
 			CPUPercent:      float64(i * 10),
+			MemoryPercent:   float64(i * 10),
 		})
 		assert.NoError(t, err)
 	}
@@ -898,6 +1217,7 @@ func TestPostgresGetMetrics(t *testing.T) {
 	assert.Equal(t, 50, s.Jobs.Running)
 	assert.Equal(t, 50, s.Tasks.Running)
 	assert.Equal(t, float64(20), s.Nodes.CPUPercent)
+	assert.Equal(t, float64(25), s.Nodes.MemoryPercent)
 	assert.Equal(t, 5, s.Nodes.Running)
 }
 
@@ -967,7 +1287,7 @@ func TestPostgresHealthCheck(t *testing.T) {
 		_, err = ds.db.Exec(fmt.Sprintf("drop schema %s cascade", schemaName))
 		assert.NoError(t, err)
 	}()
-	err = ds.ExecScript(postgres.SCHEMA)
+	err = ds.Migrate(context.Background())
 	assert.NoError(t, err)
 
 	err = ds.HealthCheck(ctx)
@@ -1297,3 +1617,216 @@ func TestPostgresCreateRole(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Len(t, uroles, 0)
 }
+
+func TestPostgresSecrets(t *testing.T) {
+	ctx := context.Background()
+	dsn := "host=localhost user=tork password=tork dbname=tork port=5432 sslmode=disable"
+	ds, err := NewPostgresDataStore(dsn)
+	assert.NoError(t, err)
+	name := uuid.NewShortUUID()
+	s := &tork.Secret{
+		Name:      name,
+		Value:     "shhh",
+		CreatedBy: "someuser",
+	}
+	err = ds.SetSecret(ctx, s)
+	assert.NoError(t, err)
+	assert.NotNil(t, s.CreatedAt)
+
+	// updating keeps the original creation details
+	err = ds.SetSecret(ctx, &tork.Secret{Name: name, Value: "hush"})
+	assert.NoError(t, err)
+
+	s2, err := ds.GetSecret(ctx, name)
+	assert.NoError(t, err)
+	assert.Equal(t, "hush", s2.Value)
+	assert.Equal(t, "someuser", s2.CreatedBy)
+
+	secrets, err := ds.GetSecrets(ctx)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, secrets)
+
+	err = ds.DeleteSecret(ctx, name)
+	assert.NoError(t, err)
+
+	_, err = ds.GetSecret(ctx, name)
+	assert.ErrorIs(t, err, datastore.ErrSecretNotFound)
+
+	err = ds.DeleteSecret(ctx, name)
+	assert.ErrorIs(t, err, datastore.ErrSecretNotFound)
+}
+
+func TestPostgresDeadLetters(t *testing.T) {
+	ctx := context.Background()
+	dsn := "host=localhost user=tork password=tork dbname=tork port=5432 sslmode=disable"
+	ds, err := NewPostgresDataStore(dsn)
+	assert.NoError(t, err)
+	dl := &tork.DeadLetter{
+		ID:       uuid.NewUUID(),
+		Queue:    "default",
+		Task:     &tork.Task{ID: uuid.NewUUID(), Name: "some task"},
+		Error:    "something bad happened",
+		Attempts: 3,
+	}
+	err = ds.CreateDeadLetter(ctx, dl)
+	assert.NoError(t, err)
+
+	dl2, err := ds.GetDeadLetterByID(ctx, dl.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, "default", dl2.Queue)
+	assert.Equal(t, "some task", dl2.Task.Name)
+	assert.Equal(t, "something bad happened", dl2.Error)
+	assert.Equal(t, 3, dl2.Attempts)
+
+	p, err := ds.GetDeadLetters(ctx, 1, 10)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, p.Items)
+
+	err = ds.DeleteDeadLetter(ctx, dl.ID)
+	assert.NoError(t, err)
+
+	_, err = ds.GetDeadLetterByID(ctx, dl.ID)
+	assert.ErrorIs(t, err, datastore.ErrDeadLetterNotFound)
+
+	err = ds.DeleteDeadLetter(ctx, dl.ID)
+	assert.ErrorIs(t, err, datastore.ErrDeadLetterNotFound)
+}
+
+func TestPostgresAPIKeys(t *testing.T) {
+	ctx := context.Background()
+	dsn := "host=localhost user=tork password=tork dbname=tork port=5432 sslmode=disable"
+	ds, err := NewPostgresDataStore(dsn)
+	assert.NoError(t, err)
+	secret := uuid.NewUUID()
+	k := &tork.APIKey{
+		Name:    "test key",
+		KeyHash: hash.Key(secret),
+	}
+	err = ds.CreateAPIKey(ctx, k)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, k.ID)
+
+	k2, err := ds.GetAPIKey(ctx, hash.Key(secret))
+	assert.NoError(t, err)
+	assert.Equal(t, k.ID, k2.ID)
+	assert.Equal(t, "test key", k2.Name)
+	assert.Empty(t, k2.CreatedBy)
+
+	keys, err := ds.GetAPIKeys(ctx)
+	assert.NoError(t, err)
+	assert.Greater(t, len(keys), 0)
+
+	err = ds.DeleteAPIKey(ctx, k.ID)
+	assert.NoError(t, err)
+
+	_, err = ds.GetAPIKey(ctx, hash.Key(secret))
+	assert.ErrorIs(t, err, datastore.ErrAPIKeyNotFound)
+
+	err = ds.DeleteAPIKey(ctx, k.ID)
+	assert.ErrorIs(t, err, datastore.ErrAPIKeyNotFound)
+}
+
+func TestPostgresScheduledJobs(t *testing.T) {
+	ctx := context.Background()
+	dsn := "host=localhost user=tork password=tork dbname=tork port=5432 sslmode=disable"
+	ds, err := NewPostgresDataStore(dsn)
+	assert.NoError(t, err)
+	sj := &tork.ScheduledJob{
+		ID:        uuid.NewUUID(),
+		Name:      "nightly",
+		Cron:      "0 0 * * *",
+		Overlap:   tork.ScheduledJobOverlapSkip,
+		State:     tork.ScheduledJobStateActive,
+		CreatedAt: time.Now().UTC(),
+		Template: &tork.Job{
+			Name:  "nightly",
+			Tasks: []*tork.Task{{Name: "some task"}},
+		},
+	}
+	err = ds.CreateScheduledJob(ctx, sj)
+	assert.NoError(t, err)
+
+	sj2, err := ds.GetScheduledJobByID(ctx, sj.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, "nightly", sj2.Name)
+	assert.Len(t, sj2.Template.Tasks, 1)
+	assert.Equal(t, tork.USER_GUEST, sj2.CreatedBy.Username)
+
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	err = ds.UpdateScheduledJob(ctx, sj.ID, func(u *tork.ScheduledJob) error {
+		u.LastRunAt = &now
+		u.LastJobID = "1234"
+		u.State = tork.ScheduledJobStatePaused
+		return nil
+	})
+	assert.NoError(t, err)
+
+	sj2, err = ds.GetScheduledJobByID(ctx, sj.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, "1234", sj2.LastJobID)
+	assert.Equal(t, tork.ScheduledJobStatePaused, sj2.State)
+	assert.Equal(t, now.Unix(), sj2.LastRunAt.Unix())
+
+	sjs, err := ds.GetScheduledJobs(ctx)
+	assert.NoError(t, err)
+	assert.Greater(t, len(sjs), 0)
+
+	err = ds.DeleteScheduledJob(ctx, sj.ID)
+	assert.NoError(t, err)
+	_, err = ds.GetScheduledJobByID(ctx, sj.ID)
+	assert.ErrorIs(t, err, datastore.ErrScheduledJobNotFound)
+}
+
+func TestPostgresLeases(t *testing.T) {
+	ctx := context.Background()
+	dsn := "host=localhost user=tork password=tork dbname=tork port=5432 sslmode=disable"
+	ds, err := NewPostgresDataStore(dsn)
+	assert.NoError(t, err)
+	name := uuid.NewShortUUID()
+	ok, err := ds.AcquireLease(ctx, name, "c1", time.Minute)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	ok, err = ds.AcquireLease(ctx, name, "c1", time.Minute)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	ok, err = ds.AcquireLease(ctx, name, "c2", time.Minute)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	assert.NoError(t, ds.ReleaseLease(ctx, name, "c1"))
+	ok, err = ds.AcquireLease(ctx, name, "c2", time.Millisecond)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	time.Sleep(time.Millisecond * 5)
+	ok, err = ds.AcquireLease(ctx, name, "c1", time.Minute)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+}
+
+func TestPostgresMigrate(t *testing.T) {
+	ctx := context.Background()
+	schemaName := fmt.Sprintf("tork%d", rand.Int())
+	dsn := `host=localhost user=tork password=tork dbname=tork search_path=%s sslmode=disable`
+	ds, err := NewPostgresDataStore(fmt.Sprintf(dsn, schemaName))
+	assert.NoError(t, err)
+	_, err = ds.db.Exec(fmt.Sprintf("create schema %s", schemaName))
+	assert.NoError(t, err)
+	defer func() {
+		_, err = ds.db.Exec(fmt.Sprintf("drop schema %s cascade", schemaName))
+		assert.NoError(t, err)
+	}()
+	// a database created before migrations were tracked
+	err = ds.ExecScript(schema.SCHEMA)
+	assert.NoError(t, err)
+	err = ds.Migrate(ctx)
+	assert.NoError(t, err)
+	var version int
+	err = ds.get(&version, "SELECT max(version) FROM schema_migrations")
+	assert.NoError(t, err)
+	assert.Equal(t, schema.Migrations[len(schema.Migrations)-1].Version, version)
+	// migrating again is a no-op
+	err = ds.Migrate(ctx)
+	assert.NoError(t, err)
+	j := &tork.Job{ID: uuid.NewUUID(), IdempotencyKey: "some-key"}
+	err = ds.CreateJob(ctx, j)
+	assert.NoError(t, err)
+}
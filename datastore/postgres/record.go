@@ -10,77 +10,114 @@ import (
 )
 
 type taskRecord struct {
-	ID          string         `db:"id"`
-	JobID       string         `db:"job_id"`
-	Position    int            `db:"position"`
-	Name        string         `db:"name"`
-	Description string         `db:"description"`
-	State       string         `db:"state"`
-	CreatedAt   time.Time      `db:"created_at"`
-	ScheduledAt *time.Time     `db:"scheduled_at"`
-	StartedAt   *time.Time     `db:"started_at"`
-	CompletedAt *time.Time     `db:"completed_at"`
-	FailedAt    *time.Time     `db:"failed_at"`
-	CMD         pq.StringArray `db:"cmd"`
-	Entrypoint  pq.StringArray `db:"entrypoint"`
-	Run         string         `db:"run_script"`
-	Image       string         `db:"image"`
-	Registry    []byte         `db:"registry"`
-	Env         []byte         `db:"env"`
-	Files       []byte         `db:"files_"`
-	Queue       string         `db:"queue"`
-	Error       string         `db:"error_"`
-	Pre         []byte         `db:"pre_tasks"`
-	Post        []byte         `db:"post_tasks"`
-	Mounts      []byte         `db:"mounts"`
-	Networks    pq.StringArray `db:"networks"`
-	NodeID      string         `db:"node_id"`
-	Retry       []byte         `db:"retry"`
-	Limits      []byte         `db:"limits"`
-	Timeout     string         `db:"timeout"`
-	Var         string         `db:"var"`
-	Result      string         `db:"result"`
-	Parallel    []byte         `db:"parallel"`
-	ParentID    string         `db:"parent_id"`
-	Each        []byte         `db:"each_"`
-	SubJob      []byte         `db:"subjob"`
-	SubJobID    string         `db:"subjob_id"`
-	GPUs        string         `db:"gpus"`
-	IF          string         `db:"if_"`
-	Tags        pq.StringArray `db:"tags"`
-	Priority    int            `db:"priority"`
-	Workdir     string         `db:"workdir"`
-	Progress    float64        `db:"progress"`
-	Ports       []byte         `db:"ports"`
+	ID              string         `db:"id"`
+	JobID           string         `db:"job_id"`
+	Position        int            `db:"position"`
+	Name            string         `db:"name"`
+	Description     string         `db:"description"`
+	State           string         `db:"state"`
+	CreatedAt       time.Time      `db:"created_at"`
+	ScheduledAt     *time.Time     `db:"scheduled_at"`
+	StartedAt       *time.Time     `db:"started_at"`
+	CompletedAt     *time.Time     `db:"completed_at"`
+	FailedAt        *time.Time     `db:"failed_at"`
+	CMD             pq.StringArray `db:"cmd"`
+	Entrypoint      pq.StringArray `db:"entrypoint"`
+	Run             string         `db:"run_script"`
+	Image           string         `db:"image"`
+	Registry        []byte         `db:"registry"`
+	Env             []byte         `db:"env"`
+	Files           []byte         `db:"files_"`
+	Queue           string         `db:"queue"`
+	Error           string         `db:"error_"`
+	Pre             []byte         `db:"pre_tasks"`
+	Post            []byte         `db:"post_tasks"`
+	Mounts          []byte         `db:"mounts"`
+	Networks        pq.StringArray `db:"networks"`
+	NodeID          string         `db:"node_id"`
+	Retry           []byte         `db:"retry"`
+	Limits          []byte         `db:"limits"`
+	Timeout         string         `db:"timeout"`
+	Var             string         `db:"var"`
+	Result          string         `db:"result"`
+	ResultTruncated bool           `db:"result_truncated"`
+	ResultURL       string         `db:"result_url"`
+	ExitCode        int            `db:"exit_code"`
+	Parallel        []byte         `db:"parallel"`
+	ParentID        string         `db:"parent_id"`
+	Each            []byte         `db:"each_"`
+	SubJob          []byte         `db:"subjob"`
+	SubJobID        string         `db:"subjob_id"`
+	GPUs            string         `db:"gpus"`
+	IF              string         `db:"if_"`
+	Tags            pq.StringArray `db:"tags"`
+	Priority        int            `db:"priority"`
+	Workdir         string         `db:"workdir"`
+	Progress        float64        `db:"progress"`
+	Ports           []byte         `db:"ports"`
+	Artifacts       []byte         `db:"artifacts"`
+	Downloads       []byte         `db:"downloads"`
+	Service         []byte         `db:"service"`
+	User            string         `db:"user_"`
+	Security        []byte         `db:"security"`
+	ShmSize         string         `db:"shm_size"`
+	Ulimits         pq.StringArray `db:"ulimits"`
+	Devices         pq.StringArray `db:"devices"`
+	Platform        string         `db:"platform"`
+	Selector        []byte         `db:"selector"`
+	Stats           []byte         `db:"stats"`
+	ImageDigest     string         `db:"image_digest"`
+	RetryAt         *time.Time     `db:"retry_at"`
+	TS              string         `db:"ts"`
 }
 
 type jobRecord struct {
-	ID          string         `db:"id"`
-	Name        string         `db:"name"`
-	Description string         `db:"description"`
-	Tags        pq.StringArray `db:"tags"`
-	State       string         `db:"state"`
-	CreatedAt   time.Time      `db:"created_at"`
-	CreatedBy   string         `db:"created_by"`
-	StartedAt   *time.Time     `db:"started_at"`
-	CompletedAt *time.Time     `db:"completed_at"`
-	FailedAt    *time.Time     `db:"failed_at"`
-	DeleteAt    *time.Time     `db:"delete_at"`
-	Tasks       []byte         `db:"tasks"`
-	Position    int            `db:"position"`
-	Inputs      []byte         `db:"inputs"`
-	Context     []byte         `db:"context"`
-	ParentID    string         `db:"parent_id"`
-	TaskCount   int            `db:"task_count"`
-	Output      string         `db:"output_"`
-	Result      string         `db:"result"`
-	Error       string         `db:"error_"`
-	TS          string         `db:"ts"`
-	Defaults    []byte         `db:"defaults"`
-	Webhooks    []byte         `db:"webhooks"`
-	AutoDelete  []byte         `db:"auto_delete"`
-	Secrets     []byte         `db:"secrets"`
-	Progress    float64        `db:"progress"`
+	ID              string         `db:"id"`
+	Name            string         `db:"name"`
+	Description     string         `db:"description"`
+	Namespace       string         `db:"namespace"`
+	Tags            pq.StringArray `db:"tags"`
+	State           string         `db:"state"`
+	CreatedAt       time.Time      `db:"created_at"`
+	CreatedBy       string         `db:"created_by"`
+	StartedAt       *time.Time     `db:"started_at"`
+	CompletedAt     *time.Time     `db:"completed_at"`
+	FailedAt        *time.Time     `db:"failed_at"`
+	DeleteAt        *time.Time     `db:"delete_at"`
+	Tasks           []byte         `db:"tasks"`
+	Position        int            `db:"position"`
+	Inputs          []byte         `db:"inputs"`
+	Context         []byte         `db:"context"`
+	ParentID        string         `db:"parent_id"`
+	TaskCount       int            `db:"task_count"`
+	Output          string         `db:"output_"`
+	Result          string         `db:"result"`
+	Error           string         `db:"error_"`
+	TS              string         `db:"ts"`
+	Defaults        []byte         `db:"defaults"`
+	Webhooks        []byte         `db:"webhooks"`
+	AutoDelete      []byte         `db:"auto_delete"`
+	Secrets         []byte         `db:"secrets"`
+	Progress        float64        `db:"progress"`
+	Trace           []byte         `db:"trace"`
+	Timeout         string         `db:"timeout"`
+	TimeoutAt       *time.Time     `db:"timeout_at"`
+	IdempotencyKey  *string        `db:"idempotency_key"`
+	IdempotencyHash *string        `db:"idempotency_hash"`
+}
+
+type scheduledJobRecord struct {
+	ID          string     `db:"id"`
+	Name        string     `db:"name"`
+	Description string     `db:"description"`
+	Cron        string     `db:"cron_expr"`
+	Overlap     string     `db:"overlap"`
+	State       string     `db:"state"`
+	Template    []byte     `db:"template"`
+	CreatedAt   time.Time  `db:"created_at"`
+	CreatedBy   string     `db:"created_by"`
+	LastRunAt   *time.Time `db:"last_run_at"`
+	LastJobID   *string    `db:"last_job_id"`
 }
 
 type jobPermRecord struct {
@@ -97,6 +134,11 @@ type nodeRecord struct {
 	StartedAt       time.Time `db:"started_at"`
 	LastHeartbeatAt time.Time `db:"last_heartbeat_at"`
 	CPUPercent      float64   `db:"cpu_percent"`
+	MemoryPercent   float64   `db:"memory_percent"`
+	DiskPercent     float64   `db:"disk_percent"`
+	GPUs            int       `db:"gpus"`
+	Platform        string    `db:"platform"`
+	Tags            []byte    `db:"tags"`
 	Queue           string    `db:"queue"`
 	Status          string    `db:"status"`
 	Hostname        string    `db:"hostname"`
@@ -122,6 +164,31 @@ type userRecord struct {
 	Disabled  bool      `db:"is_disabled"`
 }
 
+type apiKeyRecord struct {
+	ID        string    `db:"id"`
+	Name      string    `db:"name"`
+	KeyHash   string    `db:"key_hash"`
+	CreatedBy *string   `db:"created_by"`
+	CreatedAt time.Time `db:"created_at"`
+}
+
+type secretRecord struct {
+	Name      string    `db:"name"`
+	Value     string    `db:"value_"`
+	CreatedBy *string   `db:"created_by"`
+	CreatedAt time.Time `db:"created_at"`
+	UpdatedAt time.Time `db:"updated_at"`
+}
+
+type deadLetterRecord struct {
+	ID        string    `db:"id"`
+	Queue     string    `db:"queue"`
+	Task      []byte    `db:"task"`
+	Error     *string   `db:"error_"`
+	Attempts  int       `db:"attempts"`
+	CreatedAt time.Time `db:"created_at"`
+}
+
 type roleRecord struct {
 	ID        string    `db:"id"`
 	Slug      string    `db:"slug"`
@@ -136,6 +203,12 @@ func (r taskRecord) toTask() (*tork.Task, error) {
 			return nil, errors.Wrapf(err, "error deserializing task.env")
 		}
 	}
+	var selector map[string]string
+	if r.Selector != nil {
+		if err := json.Unmarshal(r.Selector, &selector); err != nil {
+			return nil, errors.Wrapf(err, "error deserializing task.selector")
+		}
+	}
 	var files map[string]string
 	if r.Files != nil {
 		if err := json.Unmarshal(r.Files, &files); err != nil {
@@ -208,57 +281,117 @@ func (r taskRecord) toTask() (*tork.Task, error) {
 			return nil, errors.Wrapf(err, "error deserializing task.ports")
 		}
 	}
+	var artifacts []*tork.Artifact
+	if r.Artifacts != nil {
+		if err := json.Unmarshal(r.Artifacts, &artifacts); err != nil {
+			return nil, errors.Wrapf(err, "error deserializing task.artifacts")
+		}
+	}
+	var downloads []*tork.Artifact
+	if r.Downloads != nil {
+		if err := json.Unmarshal(r.Downloads, &downloads); err != nil {
+			return nil, errors.Wrapf(err, "error deserializing task.downloads")
+		}
+	}
+	var service *tork.ServiceTask
+	if r.Service != nil {
+		service = &tork.ServiceTask{}
+		if err := json.Unmarshal(r.Service, service); err != nil {
+			return nil, errors.Wrapf(err, "error deserializing task.service")
+		}
+	}
+	var stats *tork.TaskStats
+	if r.Stats != nil {
+		stats = &tork.TaskStats{}
+		if err := json.Unmarshal(r.Stats, stats); err != nil {
+			return nil, errors.Wrapf(err, "error deserializing task.stats")
+		}
+	}
+	var security *tork.TaskSecurity
+	if r.Security != nil {
+		security = &tork.TaskSecurity{}
+		if err := json.Unmarshal(r.Security, security); err != nil {
+			return nil, errors.Wrapf(err, "error deserializing task.security")
+		}
+	}
 	return &tork.Task{
-		ID:          r.ID,
-		JobID:       r.JobID,
-		Position:    r.Position,
-		Name:        r.Name,
-		State:       tork.TaskState(r.State),
-		CreatedAt:   &r.CreatedAt,
-		ScheduledAt: r.ScheduledAt,
-		StartedAt:   r.StartedAt,
-		CompletedAt: r.CompletedAt,
-		FailedAt:    r.FailedAt,
-		CMD:         r.CMD,
-		Entrypoint:  r.Entrypoint,
-		Run:         r.Run,
-		Image:       r.Image,
-		Registry:    registry,
-		Env:         env,
-		Files:       files,
-		Queue:       r.Queue,
-		Error:       r.Error,
-		Pre:         pre,
-		Post:        post,
-		Mounts:      mounts,
-		Networks:    r.Networks,
-		NodeID:      r.NodeID,
-		Retry:       retry,
-		Limits:      limits,
-		Timeout:     r.Timeout,
-		Var:         r.Var,
-		Result:      r.Result,
-		Parallel:    parallel,
-		ParentID:    r.ParentID,
-		Each:        each,
-		Description: r.Description,
-		SubJob:      subjob,
-		GPUs:        r.GPUs,
-		If:          r.IF,
-		Tags:        r.Tags,
-		Priority:    r.Priority,
-		Workdir:     r.Workdir,
-		Progress:    r.Progress,
-		Ports:       ports,
+		ID:              r.ID,
+		JobID:           r.JobID,
+		Position:        r.Position,
+		Name:            r.Name,
+		State:           tork.TaskState(r.State),
+		CreatedAt:       &r.CreatedAt,
+		ScheduledAt:     r.ScheduledAt,
+		StartedAt:       r.StartedAt,
+		CompletedAt:     r.CompletedAt,
+		FailedAt:        r.FailedAt,
+		CMD:             r.CMD,
+		Entrypoint:      r.Entrypoint,
+		Run:             r.Run,
+		Image:           r.Image,
+		Registry:        registry,
+		Env:             env,
+		Files:           files,
+		Queue:           r.Queue,
+		Error:           r.Error,
+		Pre:             pre,
+		Post:            post,
+		Mounts:          mounts,
+		Networks:        r.Networks,
+		NodeID:          r.NodeID,
+		Retry:           retry,
+		Limits:          limits,
+		Timeout:         r.Timeout,
+		Var:             r.Var,
+		Result:          r.Result,
+		ResultTruncated: r.ResultTruncated,
+		ResultURL:       r.ResultURL,
+		ExitCode:        r.ExitCode,
+		Parallel:        parallel,
+		ParentID:        r.ParentID,
+		Each:            each,
+		Description:     r.Description,
+		SubJob:          subjob,
+		GPUs:            r.GPUs,
+		If:              r.IF,
+		Tags:            r.Tags,
+		Priority:        r.Priority,
+		Workdir:         r.Workdir,
+		Progress:        r.Progress,
+		Ports:           ports,
+		Artifacts:       artifacts,
+		Downloads:       downloads,
+		Service:         service,
+		User:            r.User,
+		Security:        security,
+		ShmSize:         r.ShmSize,
+		Ulimits:         r.Ulimits,
+		Devices:         r.Devices,
+		Platform:        r.Platform,
+		Selector:        selector,
+		Stats:           stats,
+		ImageDigest:     r.ImageDigest,
+		RetryAt:         r.RetryAt,
 	}, nil
 }
 
-func (r nodeRecord) toNode() *tork.Node {
+func (r nodeRecord) toNode() (*tork.Node, error) {
+	var tags map[string]string
+	if r.Tags != nil {
+		if err := json.Unmarshal(r.Tags, &tags); err != nil {
+			return nil, errors.Wrapf(err, "error deserializing node.tags")
+		}
+	}
 	n := tork.Node{
 		ID:              r.ID,
 		Name:            r.Name,
 		StartedAt:       r.StartedAt,
 		CPUPercent:      r.CPUPercent,
+		MemoryPercent:   r.MemoryPercent,
+		DiskPercent:     r.DiskPercent,
+		GPUs:            r.GPUs,
+		Platform:        r.Platform,
+		Tags:            tags,
 		LastHeartbeatAt: r.LastHeartbeatAt,
 		Queue:           r.Queue,
 		Status:          tork.NodeStatus(r.Status),
@@ -272,7 +405,7 @@ func (r nodeRecord) toNode() *tork.Node {
 	if n.LastHeartbeatAt.Before(time.Now().UTC().Add(-tork.HEARTBEAT_RATE * 2)) {
 		n.Status = tork.NodeStatusOffline
 	}
-	return &n
+	return &n, nil
 }
 
 func (r taskLogPartRecord) toTaskLogPart() *tork.TaskLogPart {
@@ -284,6 +417,29 @@ func (r taskLogPartRecord) toTaskLogPart() *tork.TaskLogPart {
 	}
 }
 
+func (r scheduledJobRecord) toScheduledJob(createdBy *tork.User) (*tork.ScheduledJob, error) {
+	template := &tork.Job{}
+	if err := json.Unmarshal(r.Template, template); err != nil {
+		return nil, errors.Wrapf(err, "error deserializing scheduled_job.template")
+	}
+	sj := &tork.ScheduledJob{
+		ID:          r.ID,
+		Name:        r.Name,
+		Description: r.Description,
+		Cron:        r.Cron,
+		Overlap:     tork.ScheduledJobOverlap(r.Overlap),
+		State:       tork.ScheduledJobState(r.State),
+		Template:    template,
+		CreatedAt:   r.CreatedAt,
+		CreatedBy:   createdBy,
+		LastRunAt:   r.LastRunAt,
+	}
+	if r.LastJobID != nil {
+		sj.LastJobID = *r.LastJobID
+	}
+	return sj, nil
+}
+
 func (r jobRecord) toJob(tasks, execution []*tork.Task, createdBy *tork.User, perms []*tork.Permission) (*tork.Job, error) {
 	var c tork.JobContext
 	if err := json.Unmarshal(r.Context, &c); err != nil {
@@ -317,34 +473,53 @@ func (r jobRecord) toJob(tasks, execution []*tork.Task, createdBy *tork.User, pe
 			return nil, errors.Wrapf(err, "error deserializing job.secrets")
 		}
 	}
+	var trace map[string]string
+	if r.Trace != nil {
+		if err := json.Unmarshal(r.Trace, &trace); err != nil {
+			return nil, errors.Wrapf(err, "error deserializing job.trace")
+		}
+	}
+	var idempotencyKey, idempotencyHash string
+	if r.IdempotencyKey != nil {
+		idempotencyKey = *r.IdempotencyKey
+	}
+	if r.IdempotencyHash != nil {
+		idempotencyHash = *r.IdempotencyHash
+	}
 	return &tork.Job{
-		ID:          r.ID,
-		Name:        r.Name,
-		Tags:        r.Tags,
-		State:       tork.JobState(r.State),
-		CreatedAt:   r.CreatedAt,
-		CreatedBy:   createdBy,
-		StartedAt:   r.StartedAt,
-		CompletedAt: r.CompletedAt,
-		FailedAt:    r.FailedAt,
-		Tasks:       tasks,
-		Execution:   execution,
-		Position:    r.Position,
-		Context:     c,
-		Inputs:      inputs,
-		Description: r.Description,
-		ParentID:    r.ParentID,
-		TaskCount:   r.TaskCount,
-		Output:      r.Output,
-		Result:      r.Result,
-		Error:       r.Error,
-		Defaults:    defaults,
-		Webhooks:    webhooks,
-		Permissions: perms,
-		AutoDelete:  autoDelete,
-		DeleteAt:    r.DeleteAt,
-		Secrets:     secrets,
-		Progress:    r.Progress,
+		ID:              r.ID,
+		Name:            r.Name,
+		Namespace:       r.Namespace,
+		Tags:            r.Tags,
+		State:           tork.JobState(r.State),
+		CreatedAt:       r.CreatedAt,
+		CreatedBy:       createdBy,
+		StartedAt:       r.StartedAt,
+		CompletedAt:     r.CompletedAt,
+		FailedAt:        r.FailedAt,
+		Tasks:           tasks,
+		Execution:       execution,
+		Position:        r.Position,
+		Context:         c,
+		Inputs:          inputs,
+		Description:     r.Description,
+		ParentID:        r.ParentID,
+		TaskCount:       r.TaskCount,
+		Output:          r.Output,
+		Result:          r.Result,
+		Error:           r.Error,
+		Defaults:        defaults,
+		Webhooks:        webhooks,
+		Permissions:     perms,
+		AutoDelete:      autoDelete,
+		DeleteAt:        r.DeleteAt,
+		Secrets:         secrets,
+		Progress:        r.Progress,
+		Timeout:         r.Timeout,
+		TimeoutAt:       r.TimeoutAt,
+		Trace:           trace,
+		IdempotencyKey:  idempotencyKey,
+		IdempotencyHash: idempotencyHash,
 	}, nil
 }
 
@@ -360,6 +535,50 @@ func (r userRecord) toUser() *tork.User {
 	return &n
 }
 
+func (r deadLetterRecord) toDeadLetter() (*tork.DeadLetter, error) {
+	t := &tork.Task{}
+	if err := json.Unmarshal(r.Task, t); err != nil {
+		return nil, errors.Wrapf(err, "error deserializing dead_letter.task")
+	}
+	dl := &tork.DeadLetter{
+		ID:        r.ID,
+		Queue:     r.Queue,
+		Task:      t,
+		Attempts:  r.Attempts,
+		CreatedAt: &r.CreatedAt,
+	}
+	if r.Error != nil {
+		dl.Error = *r.Error
+	}
+	return dl, nil
+}
+
+func (r apiKeyRecord) toAPIKey() *tork.APIKey {
+	k := tork.APIKey{
+		ID:        r.ID,
+		Name:      r.Name,
+		KeyHash:   r.KeyHash,
+		CreatedAt: &r.CreatedAt,
+	}
+	if r.CreatedBy != nil {
+		k.CreatedBy = *r.CreatedBy
+	}
+	return &k
+}
+
+func (r secretRecord) toSecret() *tork.Secret {
+	s := tork.Secret{
+		Name:      r.Name,
+		Value:     r.Value,
+		CreatedAt: &r.CreatedAt,
+		UpdatedAt: &r.UpdatedAt,
+	}
+	if r.CreatedBy != nil {
+		s.CreatedBy = *r.CreatedBy
+	}
+	return &s
+}
+
 func (r roleRecord) toRole() *tork.Role {
 	n := tork.Role{
 		ID:        r.ID,
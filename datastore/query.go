@@ -0,0 +1,173 @@
+package datastore
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/runabol/tork"
+)
+
+const (
+	JOBS_SORT_CREATED_AT = "createdAt"
+	JOBS_SORT_NAME       = "name"
+)
+
+// JobQuery filters, sorts and paginates the jobs returned by ListJobs.
+// Zero values match all jobs.
+type JobQuery struct {
+	// Q is a full-text search. tag:x and tags:x,y
+	// terms match jobs tagged with any of the tags.
+	Q string
+	// Search is a full-text search over the names, errors and
+	// results of the jobs and of their tasks, e.g. "oom killed".
+	Search string
+	// SearchLogs extends the Search to the logs of the tasks.
+	SearchLogs bool
+	// Name matches jobs whose name contains it, ignoring case.
+	Name string
+	// Namespaces, when set, are the only namespaces whose
+	// jobs are returned. Jobs without a namespace are in
+	// the default namespace.
+	Namespaces    []string
+	States        []tork.JobState
+	CreatedAfter  *time.Time
+	CreatedBefore *time.Time
+	Labels        []LabelSelector
+	// Sort is JOBS_SORT_CREATED_AT (the default) or JOBS_SORT_NAME.
+	Sort string
+	Desc bool
+	// Page is used for offset pagination unless a Cursor is
+	// set, in which case the jobs following it are returned.
+	Page   int
+	Cursor string
+	Size   int
+}
+
+// LabelOp is the operator of a LabelSelector.
+type LabelOp string
+
+const (
+	LabelEquals    LabelOp = "="
+	LabelNotEquals LabelOp = "!="
+	LabelExists    LabelOp = "exists"
+	LabelNotExists LabelOp = "!exists"
+)
+
+// LabelSelector matches jobs by their tags, which are either
+// plain labels (e.g. nightly) or key/value pairs (e.g. env=prod).
+type LabelSelector struct {
+	Key   string
+	Op    LabelOp
+	Value string
+}
+
+// ParseLabelSelector parses a comma-separated list of requirements,
+// all of which must match: env=prod, env!=prod, env (a tag named env
+// or any env=... tag) and !env.
+func ParseLabelSelector(s string) ([]LabelSelector, error) {
+	result := make([]LabelSelector, 0)
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		var sel LabelSelector
+		switch {
+		case strings.Contains(part, "!="):
+			kv := strings.SplitN(part, "!=", 2)
+			sel = LabelSelector{Key: kv[0], Op: LabelNotEquals, Value: kv[1]}
+		case strings.Contains(part, "="):
+			kv := strings.SplitN(part, "=", 2)
+			sel = LabelSelector{Key: kv[0], Op: LabelEquals, Value: kv[1]}
+		case strings.HasPrefix(part, "!"):
+			sel = LabelSelector{Key: strings.TrimPrefix(part, "!"), Op: LabelNotExists}
+		default:
+			sel = LabelSelector{Key: part, Op: LabelExists}
+		}
+		sel.Key = strings.TrimSpace(sel.Key)
+		sel.Value = strings.TrimSpace(sel.Value)
+		if sel.Key == "" {
+			return nil, errors.Errorf("invalid label selector: %s", part)
+		}
+		result = append(result, sel)
+	}
+	return result, nil
+}
+
+// Tag returns the tag matched by equality selectors.
+func (l LabelSelector) Tag() string {
+	return l.Key + "=" + l.Value
+}
+
+// Matches reports whether the tags satisfy the selector.
+func (l LabelSelector) Matches(tags []string) bool {
+	var found bool
+	for _, t := range tags {
+		switch l.Op {
+		case LabelEquals, LabelNotEquals:
+			found = t == l.Tag()
+		default:
+			found = t == l.Key || strings.HasPrefix(t, l.Key+"=")
+		}
+		if found {
+			break
+		}
+	}
+	if l.Op == LabelNotEquals || l.Op == LabelNotExists {
+		return !found
+	}
+	return found
+}
+
+// JobCursor points past the last job of a page:
+// its sort value and its ID, which breaks ties.
+type JobCursor struct {
+	Sort  string `json:"s"`
+	Desc  bool   `json:"d,omitempty"`
+	Value string `json:"v"`
+	ID    string `json:"id"`
+}
+
+// NewJobCursor returns the cursor following the job in the given order.
+func NewJobCursor(q JobQuery, j *tork.Job) string {
+	c := JobCursor{Sort: q.Sort, Desc: q.Desc, ID: j.ID}
+	switch q.Sort {
+	case JOBS_SORT_NAME:
+		c.Value = j.Name
+	default:
+		c.Value = j.CreatedAt.UTC().Format(time.RFC3339Nano)
+	}
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// ParseJobCursor decodes the query's cursor, which
+// must have been issued for the same sort order.
+func ParseJobCursor(q JobQuery) (*JobCursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(q.Cursor)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	c := &JobCursor{}
+	if err := json.Unmarshal(b, c); err != nil {
+		return nil, ErrInvalidCursor
+	}
+	if c.Sort != q.Sort || c.Desc != q.Desc {
+		return nil, errors.Wrapf(ErrInvalidCursor, "the cursor was issued for a different sort order")
+	}
+	if c.Sort != JOBS_SORT_NAME {
+		if _, err := time.Parse(time.RFC3339Nano, c.Value); err != nil {
+			return nil, ErrInvalidCursor
+		}
+	}
+	return c, nil
+}
+
+// CreatedAt returns the creation time of the cursor's job.
+func (c *JobCursor) CreatedAt() time.Time {
+	t, _ := time.Parse(time.RFC3339Nano, c.Value)
+	return t
+}
@@ -0,0 +1,60 @@
+package datastore_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/runabol/tork"
+	"github.com/runabol/tork/datastore"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseLabelSelector(t *testing.T) {
+	sels, err := datastore.ParseLabelSelector("env=prod, team!=ops,nightly,!draft")
+	assert.NoError(t, err)
+	assert.Equal(t, []datastore.LabelSelector{
+		{Key: "env", Op: datastore.LabelEquals, Value: "prod"},
+		{Key: "team", Op: datastore.LabelNotEquals, Value: "ops"},
+		{Key: "nightly", Op: datastore.LabelExists},
+		{Key: "draft", Op: datastore.LabelNotExists},
+	}, sels)
+
+	sels, err = datastore.ParseLabelSelector("")
+	assert.NoError(t, err)
+	assert.Empty(t, sels)
+
+	_, err = datastore.ParseLabelSelector("=prod")
+	assert.Error(t, err)
+}
+
+func TestLabelSelectorMatches(t *testing.T) {
+	tags := []string{"env=prod", "nightly"}
+	sels, err := datastore.ParseLabelSelector("env=prod,env,nightly,team!=ops,!team,!draft")
+	assert.NoError(t, err)
+	for _, sel := range sels {
+		assert.True(t, sel.Matches(tags), sel)
+	}
+	sels, err = datastore.ParseLabelSelector("env=dev,env!=prod,team,!nightly,!env")
+	assert.NoError(t, err)
+	for _, sel := range sels {
+		assert.False(t, sel.Matches(tags), sel)
+	}
+}
+
+func TestJobCursor(t *testing.T) {
+	now := time.Now().UTC()
+	q := datastore.JobQuery{Sort: datastore.JOBS_SORT_CREATED_AT, Desc: true}
+	q.Cursor = datastore.NewJobCursor(q, &tork.Job{ID: "1234", CreatedAt: now})
+	c, err := datastore.ParseJobCursor(q)
+	assert.NoError(t, err)
+	assert.Equal(t, "1234", c.ID)
+	assert.True(t, now.Equal(c.CreatedAt()))
+
+	// the sort order must match
+	q.Desc = false
+	_, err = datastore.ParseJobCursor(q)
+	assert.ErrorIs(t, err, datastore.ErrInvalidCursor)
+
+	_, err = datastore.ParseJobCursor(datastore.JobQuery{Cursor: "garbage"})
+	assert.ErrorIs(t, err, datastore.ErrInvalidCursor)
+}
@@ -0,0 +1,298 @@
+package postgres
+
+// Migration is an incremental change to the schema.
+type Migration struct {
+	Version     int
+	Description string
+	Script      string
+}
+
+// Migrations bring a database from an empty schema to the current
+// one. Each migration is applied once, in order of version. Append
+// new migrations rather than editing ones that were released.
+var Migrations = []Migration{
+	{
+		Version:     1,
+		Description: "initial schema",
+		Script:      SCHEMA,
+	},
+	{
+		Version:     2,
+		Description: "task result truncation and exit codes",
+		Script: `
+ALTER TABLE tasks ADD COLUMN result_truncated boolean not null default false;
+ALTER TABLE tasks ADD COLUMN exit_code int not null default 0;
+`,
+	},
+	{
+		Version:     3,
+		Description: "api keys",
+		Script: `
+CREATE TABLE api_keys (
+    id          varchar(32)  not null primary key,
+    name        varchar(64)  not null,
+    key_hash    varchar(64)  not null unique,
+    created_by  varchar(64),
+    created_at  timestamp    not null
+);
+`,
+	},
+	{
+		Version:     4,
+		Description: "rbac roles",
+		Script: `
+insert into roles (id,name,slug,created_at) (SELECT REPLACE(gen_random_uuid()::text, '-', ''),'Submitter','submitter',current_timestamp) ON CONFLICT (slug) DO NOTHING;
+insert into roles (id,name,slug,created_at) (SELECT REPLACE(gen_random_uuid()::text, '-', ''),'Operator','operator',current_timestamp) ON CONFLICT (slug) DO NOTHING;
+insert into roles (id,name,slug,created_at) (SELECT REPLACE(gen_random_uuid()::text, '-', ''),'Admin','admin',current_timestamp) ON CONFLICT (slug) DO NOTHING;
+`,
+	},
+	{
+		Version:     5,
+		Description: "scheduled jobs",
+		Script: `
+CREATE TABLE scheduled_jobs (
+    id            varchar(32)  not null primary key,
+    name          varchar(256),
+    description   text,
+    cron_expr     varchar(64)  not null,
+    overlap       varchar(10)  not null,
+    state         varchar(10)  not null,
+    template      jsonb        not null,
+    created_at    timestamp    not null,
+    created_by    varchar(32)  not null references users(id),
+    last_run_at   timestamp,
+    last_job_id   varchar(32)
+);
+`,
+	},
+	{
+		Version:     6,
+		Description: "node memory and disk usage",
+		Script: `
+ALTER TABLE nodes ADD COLUMN memory_percent float not null default 0;
+ALTER TABLE nodes ADD COLUMN disk_percent float not null default 0;
+`,
+	},
+	{
+		Version:     7,
+		Description: "secrets",
+		Script: `
+CREATE TABLE secrets (
+    name        varchar(64)  not null primary key,
+    value_      text         not null,
+    created_by  varchar(64),
+    created_at  timestamp    not null,
+    updated_at  timestamp    not null
+);
+`,
+	},
+	{
+		Version:     8,
+		Description: "job traces",
+		Script: `
+ALTER TABLE jobs ADD COLUMN trace jsonb;
+`,
+	},
+	{
+		Version:     9,
+		Description: "node gpus",
+		Script: `
+ALTER TABLE nodes ADD COLUMN gpus int not null default 0;
+`,
+	},
+	{
+		Version:     10,
+		Description: "job timeouts",
+		Script: `
+ALTER TABLE jobs ADD COLUMN timeout varchar(16);
+ALTER TABLE jobs ADD COLUMN timeout_at timestamp;
+`,
+	},
+	{
+		Version:     11,
+		Description: "job idempotency keys",
+		Script: `
+ALTER TABLE jobs ADD COLUMN idempotency_key varchar(256);
+CREATE UNIQUE INDEX idx_jobs_idempotency_key ON jobs (idempotency_key);
+`,
+	},
+	{
+		Version:     12,
+		Description: "task artifacts, downloads and spilled results",
+		Script: `
+ALTER TABLE tasks ADD COLUMN artifacts jsonb;
+ALTER TABLE tasks ADD COLUMN downloads jsonb;
+ALTER TABLE tasks ADD COLUMN result_url text not null default '';
+`,
+	},
+	{
+		Version:     13,
+		Description: "task container options",
+		Script: `
+ALTER TABLE tasks ADD COLUMN service jsonb;
+ALTER TABLE tasks ADD COLUMN user_ varchar(64) not null default '';
+ALTER TABLE tasks ADD COLUMN security jsonb;
+ALTER TABLE tasks ADD COLUMN shm_size varchar(16) not null default '';
+ALTER TABLE tasks ADD COLUMN ulimits text[];
+ALTER TABLE tasks ADD COLUMN devices text[];
+`,
+	},
+	{
+		Version:     14,
+		Description: "task stats and image digests",
+		Script: `
+ALTER TABLE tasks ADD COLUMN stats jsonb;
+ALTER TABLE tasks ADD COLUMN image_digest varchar(256) not null default '';
+`,
+	},
+	{
+		Version:     15,
+		Description: "task placement",
+		Script: `
+ALTER TABLE nodes ADD COLUMN platform varchar(64) not null default '';
+ALTER TABLE nodes ADD COLUMN tags jsonb;
+ALTER TABLE tasks ADD COLUMN platform varchar(64) not null default '';
+ALTER TABLE tasks ADD COLUMN selector jsonb;
+`,
+	},
+	{
+		Version:     16,
+		Description: "postgres broker",
+		Script: `
+CREATE TABLE mq_messages (
+    id         varchar(32) not null primary key,
+    queue      varchar(256) not null,
+    priority   int         not null default 0,
+    type_      varchar(64) not null,
+    body       jsonb       not null,
+    created_at timestamp   not null,
+    expires_at timestamp
+);
+
+CREATE INDEX idx_mq_messages_queue ON mq_messages (queue,priority desc,created_at);
+
+CREATE TABLE mq_events (
+    id         varchar(32) not null primary key,
+    topic      varchar(256) not null,
+    type_      varchar(64) not null,
+    body       jsonb       not null,
+    created_at timestamp   not null
+);
+
+CREATE INDEX idx_mq_events_created_at ON mq_events (created_at);
+`,
+	},
+	{
+		Version:     17,
+		Description: "leases",
+		Script: `
+CREATE TABLE leases (
+    name       varchar(64) not null primary key,
+    holder     varchar(64) not null,
+    expires_at timestamp   not null
+);
+`,
+	},
+	{
+		Version:     18,
+		Description: "full-text search over errors and results",
+		Script: `
+-- results are capped so that large outputs
+-- don't exceed the maximum size of a tsvector
+ALTER TABLE jobs DROP COLUMN ts;
+
+ALTER TABLE jobs ADD COLUMN ts tsvector NOT NULL
+    GENERATED ALWAYS AS (
+        setweight(to_tsvector('english',coalesce(description,'')),'C')  ||
+        setweight(to_tsvector('english',coalesce(name,'')),'B') ||
+        setweight(to_tsvector('english',state),'A') ||
+        setweight(to_tsvector('english',coalesce(error_,'')),'B') ||
+        setweight(to_tsvector('english',left(coalesce(result,''),65536)),'D')
+    ) STORED;
+
+CREATE INDEX jobs_ts_idx ON jobs USING GIN (ts);
+
+ALTER TABLE tasks ADD COLUMN ts tsvector NOT NULL
+    GENERATED ALWAYS AS (
+        setweight(to_tsvector('english',coalesce(name,'')),'B') ||
+        setweight(to_tsvector('english',coalesce(error_,'')),'A') ||
+        setweight(to_tsvector('english',left(coalesce(result,''),65536)),'D')
+    ) STORED;
+
+CREATE INDEX tasks_ts_idx ON tasks USING GIN (ts);
+
+CREATE INDEX idx_tasks_log_parts_ts ON tasks_log_parts USING GIN (to_tsvector('english',contents));
+`,
+	},
+	{
+		Version:     19,
+		Description: "delayed task retries",
+		Script: `
+ALTER TABLE tasks ADD COLUMN retry_at timestamp;
+CREATE INDEX idx_tasks_retry_at ON tasks (retry_at) WHERE retry_at IS NOT NULL;
+`,
+	},
+	{
+		Version:     20,
+		Description: "dead letters",
+		Script: `
+CREATE TABLE dead_letters (
+    id          varchar(32)  not null primary key,
+    queue       varchar(256) not null,
+    task        jsonb        not null,
+    error_      text,
+    attempts    int          not null default 0,
+    created_at  timestamp    not null
+);
+
+CREATE INDEX idx_dead_letters_created_at ON dead_letters (created_at);
+`,
+	},
+	{
+		Version:     21,
+		Description: "api key and secret creators by user id",
+		Script: `
+-- like jobs and scheduled jobs, api keys and
+-- secrets record the ID of the user who created them
+UPDATE api_keys k SET created_by = u.id FROM users u WHERE k.created_by = u.username_;
+UPDATE secrets s SET created_by = u.id FROM users u WHERE s.created_by = u.username_;
+`,
+	},
+	{
+		Version:     22,
+		Description: "idempotency keys scoped to the job creator",
+		Script: `
+ALTER TABLE jobs ADD COLUMN idempotency_hash varchar(64);
+DROP INDEX idx_jobs_idempotency_key;
+CREATE UNIQUE INDEX idx_jobs_idempotency_key ON jobs (created_by,idempotency_key);
+`,
+	},
+	{
+		Version:     23,
+		Description: "postgres broker message leases",
+		Script: `
+-- a received message is hidden from the other
+-- subscribers until its lease is over
+ALTER TABLE mq_messages ADD COLUMN visible_at timestamptz;
+`,
+	},
+	{
+		Version:     24,
+		Description: "capped full-text search over logs",
+		Script: `
+-- like results, log parts are capped so that large
+-- parts don't exceed the maximum size of a tsvector
+DROP INDEX idx_tasks_log_parts_ts;
+CREATE INDEX idx_tasks_log_parts_ts ON tasks_log_parts USING GIN (to_tsvector('english',left(contents,65536)));
+`,
+	},
+	{
+		Version:     25,
+		Description: "job namespaces",
+		Script: `
+-- jobs submitted before namespaces are in the default one
+ALTER TABLE jobs ADD COLUMN namespace varchar(64) not null default 'default';
+CREATE INDEX idx_jobs_namespace ON jobs (namespace);
+`,
+	},
+}
@@ -0,0 +1,15 @@
+package postgres
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMigrationsAreSequential(t *testing.T) {
+	for i, m := range Migrations {
+		assert.Equal(t, i+1, m.Version)
+		assert.NotEmpty(t, m.Description)
+		assert.NotEmpty(t, m.Script)
+	}
+}
@@ -1,5 +1,7 @@
 package postgres
 
+// SCHEMA is the initial schema, applied as the first
+// of the Migrations which bring it up to date.
 const SCHEMA = `
 CREATE TABLE nodes (
     id                 varchar(32)  not null primary key,
@@ -0,0 +1,32 @@
+package tork
+
+import "time"
+
+// DeadLetter is a task message which could not be handled and
+// was set aside after exhausting its delivery attempts. It can
+// be inspected and requeued to the queue it was consumed from.
+type DeadLetter struct {
+	ID string `json:"id,omitempty"`
+	// Queue is the queue the message was consumed from
+	Queue string `json:"queue,omitempty"`
+	Task  *Task  `json:"task,omitempty"`
+	// Error is the reason the last attempt failed
+	Error     string     `json:"error,omitempty"`
+	Attempts  int        `json:"attempts,omitempty"`
+	CreatedAt *time.Time `json:"createdAt,omitempty"`
+}
+
+func (d *DeadLetter) Clone() *DeadLetter {
+	var t *Task
+	if d.Task != nil {
+		t = d.Task.Clone()
+	}
+	return &DeadLetter{
+		ID:        d.ID,
+		Queue:     d.Queue,
+		Task:      t,
+		Error:     d.Error,
+		Attempts:  d.Attempts,
+		CreatedAt: d.CreatedAt,
+	}
+}
@@ -0,0 +1,10 @@
+// Package docs embeds the API's OpenAPI 3 document, generated
+// from the API handler annotations by `make generate-openapi`.
+package docs
+
+import (
+	_ "embed"
+)
+
+//go:embed openapi.json
+var OpenAPIJSON []byte
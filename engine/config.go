@@ -0,0 +1,337 @@
+package engine
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/go-units"
+	"github.com/pkg/errors"
+	"github.com/runabol/tork/conf"
+	"github.com/runabol/tork/datastore"
+	"github.com/runabol/tork/input"
+	"github.com/runabol/tork/logstore"
+	"github.com/runabol/tork/mq"
+	"github.com/runabol/tork/runtime"
+	"github.com/runabol/tork/runtime/containerd"
+)
+
+// configErrors collects the problems found in the
+// config, each one prefixed with the offending key.
+type configErrors []string
+
+// ValidateConfig checks the loaded config of the default engine
+// for the given mode. An empty mode checks every section.
+func ValidateConfig(mode Mode) error {
+	return defaultEngine.ValidateConfig(mode)
+}
+
+// ValidateConfig checks the loaded config for the given
+// mode. An empty mode checks every section.
+func (e *Engine) ValidateConfig(mode Mode) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.validateConfig(mode)
+}
+
+func (e *Engine) validateConfig(mode Mode) error {
+	errs := &configErrors{}
+	errs.oneOf("logging.level", strings.ToLower(conf.StringDefault("logging.level", "debug")), "debug", "info", "warn", "warning", "error")
+	errs.oneOf("logging.format", strings.ToLower(conf.StringDefault("logging.format", "pretty")), "pretty", "json")
+	e.validateBrokerConfig(errs)
+	validateArtifactsConfig(errs)
+	if mode == "" || mode == ModeCoordinator || mode == ModeStandalone {
+		e.validateDatastoreConfig(errs)
+		validateCoordinatorConfig(errs)
+		validateLogsConfig(errs)
+		validateNamespacesConfig(errs)
+	}
+	if mode == "" || mode == ModeWorker || mode == ModeStandalone {
+		e.validateWorkerConfig(errs)
+	}
+	if len(*errs) == 0 {
+		return nil
+	}
+	return errors.Errorf("invalid config:\n  %s", strings.Join(*errs, "\n  "))
+}
+
+func (e *Engine) validateBrokerConfig(errs *configErrors) {
+	bt := conf.StringDefault("broker.type", mq.BROKER_INMEMORY)
+	if _, ok := e.mqProviders[bt]; ok {
+		return
+	}
+	if !errs.oneOf("broker.type", bt, append(providerNames(e.mqProviders),
+		mq.BROKER_INMEMORY, mq.BROKER_RABBITMQ, mq.BROKER_POSTGRES, mq.BROKER_PUBSUB, mq.BROKER_NATS, mq.BROKER_KAFKA, mq.BROKER_REDIS, mq.BROKER_SQS)...) {
+		return
+	}
+	switch bt {
+	case mq.BROKER_RABBITMQ:
+		errs.duration("broker.rabbitmq.consumer.timeout")
+	case mq.BROKER_POSTGRES:
+		errs.duration("broker.postgres.poll.interval")
+	case mq.BROKER_PUBSUB:
+		errs.duration("broker.pubsub.ack.deadline")
+		if conf.String("broker.pubsub.project") == "" {
+			errs.add("broker.pubsub.project", "is required by the pubsub broker")
+		}
+		if conf.String("broker.username") != "" {
+			errs.add("broker.username", "is not supported by the pubsub broker, use broker.token")
+		}
+	case mq.BROKER_NATS:
+		errs.duration("broker.nats.ack.wait")
+		errs.integer("broker.nats.replicas", 1, 5)
+	case mq.BROKER_KAFKA:
+		errs.integer("broker.kafka.partitions", 1, -1)
+	case mq.BROKER_REDIS:
+		errs.duration("broker.redis.claim.after")
+	case mq.BROKER_SQS:
+		errs.duration("broker.sqs.visibility.timeout")
+		errs.integer("broker.sqs.max.receives", 0, 1000)
+	}
+	if bt != mq.BROKER_INMEMORY && bt != mq.BROKER_POSTGRES {
+		if _, err := mq.CodecByName(conf.StringDefault("broker.codec", mq.CODEC_JSON)); err != nil {
+			errs.add("broker.codec", "%s", err)
+		}
+	}
+	errs.tls("broker.tls")
+}
+
+func (e *Engine) validateDatastoreConfig(errs *configErrors) {
+	dstype := conf.StringDefault("datastore.type", datastore.DATASTORE_INMEMORY)
+	if _, ok := e.dsProviders[dstype]; ok {
+		return
+	}
+	if !errs.oneOf("datastore.type", dstype, append(providerNames(e.dsProviders),
+		datastore.DATASTORE_INMEMORY, datastore.DATASTORE_POSTGRES, datastore.DATASTORE_BOLT)...) {
+		return
+	}
+	errs.duration("datastore.idempotency.retention")
+	switch dstype {
+	case datastore.DATASTORE_INMEMORY:
+		errs.duration("datastore.inmemory.cleanup.interval")
+		errs.duration("datastore.inmemory.jobs.expiration")
+		errs.duration("datastore.inmemory.nodes.expiration")
+	case datastore.DATASTORE_POSTGRES:
+		errs.duration("datastore.postgres.task.logs.interval")
+	case datastore.DATASTORE_BOLT:
+		errs.duration("datastore.bolt.open.timeout")
+	}
+}
+
+func validateCoordinatorConfig(errs *configErrors) {
+	errs.duration("coordinator.stalled.timeout")
+	errs.duration("coordinator.lease.ttl")
+	errs.duration("middleware.web.jwtauth.cache_ttl")
+	if conf.Bool("middleware.web.jwtauth.enabled") {
+		if conf.Bool("middleware.web.keyauth.enabled") {
+			errs.add("middleware.web.jwtauth.enabled", "can't be combined with middleware.web.keyauth")
+		}
+		if conf.String("middleware.web.jwtauth.audience") == "" {
+			errs.add("middleware.web.jwtauth.audience", "is required")
+		}
+	}
+	errs.integer("middleware.web.ratelimit.rps", 1, -1)
+}
+
+func validateArtifactsConfig(errs *configErrors) {
+	errs.size("artifacts.downloads.maxsize")
+	switch at := conf.StringDefault("artifacts.type", "fs"); at {
+	case "s3":
+		if conf.String("artifacts.s3.bucket") == "" {
+			errs.add("artifacts.s3.bucket", "is required by the s3 artifact store")
+		}
+	case "gcs":
+		if conf.String("artifacts.gcs.bucket") == "" {
+			errs.add("artifacts.gcs.bucket", "is required by the gcs artifact store")
+		}
+	default:
+		errs.oneOf("artifacts.type", at, "fs", "s3", "gcs")
+	}
+}
+
+func validateLogsConfig(errs *configErrors) {
+	switch lt := conf.StringDefault("logs.type", logstore.LOGSTORE_DATASTORE); lt {
+	case logstore.LOGSTORE_FS:
+		if conf.String("logs.fs.dir") == "" {
+			errs.add("logs.fs.dir", "is required by the fs log store")
+		}
+	case logstore.LOGSTORE_S3:
+		if conf.String("logs.s3.bucket") == "" {
+			errs.add("logs.s3.bucket", "is required by the s3 log store")
+		}
+	default:
+		errs.oneOf("logs.type", lt, logstore.LOGSTORE_DATASTORE, logstore.LOGSTORE_FS, logstore.LOGSTORE_S3)
+	}
+}
+
+func validateNamespacesConfig(errs *configErrors) {
+	namespaces, _ := conf.Get("namespaces").(map[string]any)
+	names := make([]string, 0, len(namespaces))
+	for name := range namespaces {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		key := "namespaces." + name
+		if !input.ValidNamespace(name) {
+			errs.add(key, "invalid namespace name %q, expecting lowercase letters, digits and dashes", name)
+			continue
+		}
+		errs.integer(key+".maxjobs", 0, -1)
+		errs.duration(key + ".defaults.timeout")
+		errs.integer(key+".defaults.priority", 0, 9)
+		errs.integer(key+".defaults.retry.limit", 0, 10)
+		errs.integer(key+".defaults.limits.cpushares", 0, 262144)
+		errs.size(key + ".defaults.limits.memory")
+		if cpus := conf.String(key + ".defaults.limits.cpus"); cpus != "" {
+			if v, err := strconv.ParseFloat(cpus, 64); err != nil || v <= 0 {
+				errs.add(key+".defaults.limits.cpus", "invalid number of CPUs %q", cpus)
+			}
+		}
+	}
+}
+
+func (e *Engine) validateWorkerConfig(errs *configErrors) {
+	errs.integer("worker.concurrency", 0, -1)
+	errs.integer("worker.gpus", 0, -1)
+	errs.integer("worker.admission.cpu", 0, 100)
+	errs.integer("worker.admission.memory", 0, 100)
+	errs.integer("worker.admission.disk", 0, 100)
+	errs.duration("worker.drain.timeout")
+	// limits
+	if cpus := conf.String("worker.limits.cpus"); cpus != "" {
+		if v, err := strconv.ParseFloat(cpus, 64); err != nil || v <= 0 {
+			errs.add("worker.limits.cpus", "invalid number of CPUs %q", cpus)
+		}
+	}
+	errs.size("worker.limits.memory")
+	errs.size("worker.limits.output")
+	errs.duration("worker.limits.timeout")
+	// runtime
+	if e.runtime != nil {
+		return
+	}
+	rt := conf.StringDefault("runtime.type", runtime.Docker)
+	if !errs.oneOf("runtime.type", rt, runtime.Docker, runtime.Podman, runtime.Shell, runtime.Kubernetes, runtime.Containerd) {
+		return
+	}
+	if rt == runtime.Docker || rt == runtime.Podman {
+		errs.duration("runtime.docker.keepfailed")
+		errs.duration("runtime.docker.reaper.interval")
+		errs.integer("runtime.docker.pool.size", 0, -1)
+		errs.integer("runtime.docker.pool.maxuses", 0, -1)
+		errs.tls("runtime.docker.tls")
+	}
+	if rt == runtime.Containerd {
+		errs.oneOf("runtime.containerd.network", conf.StringDefault("runtime.containerd.network", containerd.NetworkHost),
+			containerd.NetworkHost, containerd.NetworkCNI, containerd.NetworkNone)
+	}
+}
+
+func (errs *configErrors) add(key, format string, args ...any) {
+	*errs = append(*errs, fmt.Sprintf("%s: %s", key, fmt.Sprintf(format, args...)))
+}
+
+// oneOf reports whether the value is one of the allowed values.
+func (errs *configErrors) oneOf(key, value string, allowed ...string) bool {
+	for _, a := range allowed {
+		if value == a {
+			return true
+		}
+	}
+	errs.add(key, "unknown value %q, expecting one of: %s", value, strings.Join(allowed, ", "))
+	return false
+}
+
+func (errs *configErrors) duration(key string) {
+	v, ok := conf.Get(key).(string)
+	if !ok {
+		return
+	}
+	if _, err := time.ParseDuration(v); err != nil {
+		errs.add(key, "invalid duration %q, expecting a value such as 30s or 5m", v)
+	}
+}
+
+// integer checks the value is a whole number within min and max.
+// A negative max means the value isn't bounded.
+func (errs *configErrors) integer(key string, min, max int) {
+	var n int
+	switch v := conf.Get(key).(type) {
+	case nil:
+		return
+	case string:
+		i, err := strconv.Atoi(v)
+		if err != nil {
+			errs.add(key, "invalid number %q", v)
+			return
+		}
+		n = i
+	case int:
+		n = v
+	case int64:
+		n = int(v)
+	case float64:
+		if v != float64(int(v)) {
+			errs.add(key, "invalid number %v, expecting a whole number", v)
+			return
+		}
+		n = int(v)
+	default:
+		errs.add(key, "invalid number %v", v)
+		return
+	}
+	if n < min || (max >= 0 && n > max) {
+		if max >= 0 {
+			errs.add(key, "%d is out of range, expecting a value between %d and %d", n, min, max)
+		} else {
+			errs.add(key, "%d is out of range, expecting a value of at least %d", n, min)
+		}
+	}
+}
+
+func (errs *configErrors) size(key string) {
+	v := conf.String(key)
+	if v == "" {
+		return
+	}
+	if _, err := units.RAMInBytes(v); err != nil {
+		errs.add(key, "invalid size %q, expecting a value such as 512m or 1g", v)
+	}
+}
+
+// tls checks the certificate files under the
+// given prefix exist and come in pairs.
+func (errs *configErrors) tls(prefix string) {
+	for _, name := range []string{"cacert", "cert", "key"} {
+		key := prefix + "." + name
+		f := conf.String(key)
+		if f == "" {
+			continue
+		}
+		if _, err := os.Stat(f); os.IsNotExist(err) {
+			errs.add(key, "file not found: %s", f)
+		} else if err != nil {
+			errs.add(key, "%s", err)
+		}
+	}
+	cert, key := conf.String(prefix+".cert"), conf.String(prefix+".key")
+	if cert != "" && key == "" {
+		errs.add(prefix+".key", "is required when %s.cert is set", prefix)
+	}
+	if key != "" && cert == "" {
+		errs.add(prefix+".cert", "is required when %s.key is set", prefix)
+	}
+}
+
+func providerNames[T any](providers map[string]T) []string {
+	names := make([]string, 0, len(providers))
+	for name := range providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
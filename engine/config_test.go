@@ -0,0 +1,216 @@
+package engine
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/runabol/tork"
+	"github.com/runabol/tork/conf"
+	"github.com/runabol/tork/datastore"
+	"github.com/runabol/tork/datastore/inmemory"
+	"github.com/stretchr/testify/assert"
+)
+
+func loadConfig(t *testing.T, contents string) {
+	dir := t.TempDir()
+	f := filepath.Join(dir, "config.yaml")
+	assert.NoError(t, os.WriteFile(f, []byte(contents), os.ModePerm))
+	assert.NoError(t, conf.LoadConfigFile(f))
+	t.Cleanup(func() {
+		empty := filepath.Join(dir, "empty.yaml")
+		assert.NoError(t, os.WriteFile(empty, []byte{}, os.ModePerm))
+		assert.NoError(t, conf.LoadConfigFile(empty))
+	})
+}
+
+func TestValidateConfigDefaults(t *testing.T) {
+	loadConfig(t, "")
+	eng := New(Config{})
+	assert.NoError(t, eng.ValidateConfig(""))
+}
+
+func TestValidateConfigInvalid(t *testing.T) {
+	loadConfig(t, `
+broker:
+  type: activemq
+datastore:
+  type: postgres
+  postgres:
+    task:
+      logs:
+        interval: 5 days
+worker:
+  concurrency: -1
+  admission:
+    cpu: 120
+  limits:
+    cpus: none
+    memory: lots
+    timeout: 10x
+runtime:
+  docker:
+    tls:
+      cert: /no/such/cert.pem
+`)
+	eng := New(Config{})
+	err := eng.ValidateConfig("")
+	assert.Error(t, err)
+	assert.ErrorContains(t, err, `broker.type: unknown value "activemq"`)
+	assert.ErrorContains(t, err, `datastore.postgres.task.logs.interval: invalid duration "5 days"`)
+	assert.ErrorContains(t, err, "worker.concurrency: -1 is out of range")
+	assert.ErrorContains(t, err, "worker.admission.cpu: 120 is out of range")
+	assert.ErrorContains(t, err, `worker.limits.cpus: invalid number of CPUs "none"`)
+	assert.ErrorContains(t, err, `worker.limits.memory: invalid size "lots"`)
+	assert.ErrorContains(t, err, `worker.limits.timeout: invalid duration "10x"`)
+	assert.ErrorContains(t, err, "runtime.docker.tls.cert: file not found: /no/such/cert.pem")
+	assert.ErrorContains(t, err, "runtime.docker.tls.key: is required when runtime.docker.tls.cert is set")
+}
+
+func TestValidateConfigAuth(t *testing.T) {
+	loadConfig(t, `
+middleware:
+  web:
+    keyauth:
+      enabled: true
+    jwtauth:
+      enabled: true
+      issuer: https://issuer.example.com
+`)
+	eng := New(Config{})
+	err := eng.ValidateConfig(ModeCoordinator)
+	assert.ErrorContains(t, err, "middleware.web.jwtauth.enabled: can't be combined with middleware.web.keyauth")
+	assert.ErrorContains(t, err, "middleware.web.jwtauth.audience: is required")
+
+	_, err = echoMiddleware(inmemory.NewInMemoryDatastore())
+	assert.ErrorContains(t, err, "can't be enabled together")
+}
+
+func TestValidateConfigArtifacts(t *testing.T) {
+	loadConfig(t, `
+artifacts:
+  type: s3
+`)
+	eng := New(Config{})
+	err := eng.ValidateConfig(ModeWorker)
+	assert.ErrorContains(t, err, "artifacts.s3.bucket: is required")
+
+	loadConfig(t, `
+artifacts:
+  type: azure
+`)
+	err = eng.ValidateConfig(ModeWorker)
+	assert.ErrorContains(t, err, `artifacts.type: unknown value "azure"`)
+}
+
+func TestValidateConfigLogs(t *testing.T) {
+	loadConfig(t, `
+logs:
+  type: fs
+`)
+	eng := New(Config{})
+	err := eng.ValidateConfig(ModeCoordinator)
+	assert.ErrorContains(t, err, "logs.fs.dir: is required")
+	// workers don't store logs
+	assert.NoError(t, eng.ValidateConfig(ModeWorker))
+
+	loadConfig(t, `
+logs:
+  type: elasticsearch
+`)
+	err = eng.ValidateConfig(ModeCoordinator)
+	assert.ErrorContains(t, err, `logs.type: unknown value "elasticsearch"`)
+}
+
+func TestValidateConfigNamespaces(t *testing.T) {
+	loadConfig(t, `
+namespaces:
+  team-a:
+    maxjobs: -1
+    defaults:
+      timeout: soon
+      limits:
+        memory: lots
+  Team_B:
+    users: [bob]
+`)
+	eng := New(Config{})
+	err := eng.ValidateConfig(ModeCoordinator)
+	assert.ErrorContains(t, err, "namespaces.team-a.maxjobs: -1 is out of range")
+	assert.ErrorContains(t, err, `namespaces.team-a.defaults.timeout: invalid duration "soon"`)
+	assert.ErrorContains(t, err, `namespaces.team-a.defaults.limits.memory: invalid size "lots"`)
+	assert.ErrorContains(t, err, `namespaces.Team_B: invalid namespace name "Team_B"`)
+}
+
+func TestNamespacesConfig(t *testing.T) {
+	loadConfig(t, `
+namespaces:
+  team-a:
+    users: [alice]
+    roles: [team-a]
+    queues: [team-a, default]
+    maxjobs: 5
+    defaults:
+      queue: team-a
+      timeout: 10m
+      limits:
+        cpus: "1"
+  team-b: {}
+`)
+	assert.NoError(t, New(Config{}).ValidateConfig(ModeCoordinator))
+	nss, err := namespaces()
+	assert.NoError(t, err)
+	assert.Len(t, nss, 2)
+	byName := map[string]*tork.Namespace{}
+	for _, ns := range nss {
+		byName[ns.Name] = ns
+	}
+	a := byName["team-a"]
+	assert.Equal(t, []string{"alice"}, a.Users)
+	assert.Equal(t, []string{"team-a"}, a.Roles)
+	assert.Equal(t, []string{"team-a", "default"}, a.Queues)
+	assert.Equal(t, 5, a.MaxActiveJobs)
+	assert.Equal(t, "team-a", a.Defaults.Queue)
+	assert.Equal(t, "10m", a.Defaults.Timeout)
+	assert.Equal(t, "1", a.Defaults.Limits.CPUs)
+	assert.Nil(t, a.Defaults.Retry)
+	b := byName["team-b"]
+	assert.True(t, b.IsOpen())
+	assert.Nil(t, b.Defaults)
+}
+
+func TestValidateConfigMode(t *testing.T) {
+	loadConfig(t, `
+datastore:
+  type: mongo
+runtime:
+  type: lxc
+`)
+	eng := New(Config{})
+	err := eng.ValidateConfig(ModeWorker)
+	assert.ErrorContains(t, err, "runtime.type")
+	assert.NotContains(t, err.Error(), "datastore.type")
+	err = eng.ValidateConfig(ModeCoordinator)
+	assert.ErrorContains(t, err, "datastore.type")
+	assert.NotContains(t, err.Error(), "runtime.type")
+	err = eng.Start()
+	assert.Error(t, err)
+	assert.Equal(t, StateIdle, eng.State())
+}
+
+func TestValidateConfigProvider(t *testing.T) {
+	loadConfig(t, `
+datastore:
+  type: mongo
+broker:
+  type: pubsub
+`)
+	eng := New(Config{})
+	eng.RegisterDatastoreProvider("mongo", func() (datastore.Datastore, error) {
+		return inmemory.NewInMemoryDatastore(), nil
+	})
+	err := eng.ValidateConfig(ModeCoordinator)
+	assert.Error(t, err)
+	assert.NotContains(t, err.Error(), "datastore.type")
+	assert.ErrorContains(t, err, "broker.pubsub.project: is required by the pubsub broker")
+}
@@ -4,7 +4,9 @@ import (
 	"context"
 	"crypto/subtle"
 	"fmt"
+	"net/http"
 	"strings"
+	"time"
 
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
@@ -16,9 +18,11 @@ import (
 	"github.com/runabol/tork/datastore"
 	"github.com/runabol/tork/internal/coordinator"
 	"github.com/runabol/tork/internal/hash"
+	"github.com/runabol/tork/internal/oidc"
 	"github.com/runabol/tork/internal/redact"
 	"github.com/runabol/tork/internal/uuid"
 	"github.com/runabol/tork/internal/wildcard"
+	"github.com/runabol/tork/logstore"
 	"github.com/runabol/tork/middleware/job"
 	"github.com/runabol/tork/middleware/task"
 	"golang.org/x/time/rate"
@@ -27,6 +31,26 @@ import (
 func (e *Engine) initCoordinator() error {
 	queues := conf.IntMap("coordinator.queues")
 
+	artifacts, err := e.initArtifactStore()
+	if err != nil {
+		return err
+	}
+
+	logs, err := initLogStore()
+	if err != nil {
+		return err
+	}
+
+	echoMW, err := echoMiddleware(e.ds)
+	if err != nil {
+		return err
+	}
+
+	namespaces, err := namespaces()
+	if err != nil {
+		return err
+	}
+
 	cfg := coordinator.Config{
 		Name:      conf.StringDefault("coordinator.name", "Coordinator"),
 		Broker:    e.broker,
@@ -38,23 +62,35 @@ func (e *Engine) initCoordinator() error {
 			Task: e.cfg.Middleware.Task,
 			Job:  e.cfg.Middleware.Job,
 			Node: e.cfg.Middleware.Node,
-			Echo: echoMiddleware(e.ds),
+			Echo: echoMW,
 		},
-		Endpoints: e.cfg.Endpoints,
-		Enabled:   conf.BoolMap("coordinator.api.endpoints"),
+		Endpoints:          e.cfg.Endpoints,
+		Enabled:            conf.BoolMap("coordinator.api.endpoints"),
+		StalledTaskTimeout: conf.DurationDefault("coordinator.stalled.timeout", tork.LAST_HEARTBEAT_TIMEOUT),
+		Artifacts:          artifacts,
+		Logs:               logs,
+		ImagePolicy:        imagePolicy(),
+		Namespaces:         namespaces,
+		LeaseTTL:           conf.DurationDefault("coordinator.lease.ttl", time.Second*15),
+		Debug:              conf.Bool("debug.enabled"),
 	}
 
 	// redact
-	redactJobEnabled := conf.BoolDefault("middleware.job.redact.enabled", true)
-	if redactJobEnabled {
-		patterns := conf.Strings("middleware.job.redact.patterns")
-		matchers := make([]redact.Matcher, len(patterns))
-		for i, pattern := range patterns {
-			matchers[i] = redact.Wildcard(pattern)
-		}
-		redacter := redact.NewRedacter(e.ds, matchers...)
+	patterns := conf.Strings("middleware.job.redact.patterns")
+	matchers := make([]redact.Matcher, len(patterns))
+	for i, pattern := range patterns {
+		matchers[i] = redact.Wildcard(pattern)
+	}
+	redacter := redact.NewRedacter(e.ds, matchers...)
+	// secret values are always kept out of the stored
+	// task logs and results. the setting only controls
+	// the redaction of the jobs and tasks that are read
+	cfg.Redacter = redacter
+	if conf.BoolDefault("middleware.job.redact.enabled", true) {
 		cfg.Middleware.Job = append(cfg.Middleware.Job, job.Redact(redacter))
 		cfg.Middleware.Task = append(cfg.Middleware.Task, task.Redact(redacter))
+	} else {
+		cfg.Middleware.Task = append(cfg.Middleware.Task, task.RedactResult(redacter))
 	}
 
 	// webhook middleware
@@ -75,7 +111,7 @@ func (e *Engine) initCoordinator() error {
 	return nil
 }
 
-func echoMiddleware(ds datastore.Datastore) []echo.MiddlewareFunc {
+func echoMiddleware(ds datastore.Datastore) ([]echo.MiddlewareFunc, error) {
 	mw := make([]echo.MiddlewareFunc, 0)
 	// cors
 	corsEnabled := conf.Bool("middleware.web.cors.enabled")
@@ -90,9 +126,36 @@ func echoMiddleware(ds datastore.Datastore) []echo.MiddlewareFunc {
 
 	// key auth
 	keyAuthEnabled := conf.Bool("middleware.web.keyauth.enabled")
+	jwtAuthEnabled := conf.Bool("middleware.web.jwtauth.enabled")
+	// both read the bearer token, so
+	// each would reject the other's
+	if keyAuthEnabled && jwtAuthEnabled {
+		return nil, errors.New("middleware.web.keyauth and middleware.web.jwtauth can't be enabled together")
+	}
 	if keyAuthEnabled {
 		key := conf.StringDefault("middleware.web.keyauth.key", "")
-		mw = append(mw, keyAuth(key))
+		mw = append(mw, keyAuth(ds, key))
+	}
+
+	// jwt auth
+	if jwtAuthEnabled {
+		v, err := oidc.NewVerifier(oidc.Config{
+			Issuer:   conf.String("middleware.web.jwtauth.issuer"),
+			Audience: conf.String("middleware.web.jwtauth.audience"),
+			JWKSURL:  conf.String("middleware.web.jwtauth.jwks_url"),
+			CacheTTL: conf.DurationDefault("middleware.web.jwtauth.cache_ttl", time.Hour),
+		})
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid middleware.web.jwtauth config")
+		}
+		claim := conf.StringDefault("middleware.web.jwtauth.claim", "sub")
+		mw = append(mw, jwtAuth(ds, v, claim))
+	}
+
+	// role-based access control
+	rbacEnabled := conf.Bool("middleware.web.rbac.enabled")
+	if rbacEnabled {
+		mw = append(mw, rbac(ds))
 	}
 
 	// rate limit
@@ -107,7 +170,14 @@ func echoMiddleware(ds datastore.Datastore) []echo.MiddlewareFunc {
 		mw = append(mw, logger())
 	}
 
-	return mw
+	return mw, nil
+}
+
+// isHealthCheck reports whether the request is for one of the
+// health endpoints, which probes call without credentials.
+func isHealthCheck(c echo.Context) bool {
+	p := c.Request().URL.Path
+	return p == "/health" || strings.HasPrefix(p, "/health/")
 }
 
 func rateLimit(rps int) echo.MiddlewareFunc {
@@ -129,28 +199,166 @@ func basicAuth(ds datastore.Datastore) echo.MiddlewareFunc {
 	})
 }
 
-func keyAuth(key string) echo.MiddlewareFunc {
+func keyAuth(ds datastore.Datastore, key string) echo.MiddlewareFunc {
 	if key == "" {
 		key = uuid.NewUUID()
 		log.Debug().Msgf("Key Auth Key: %s", key)
 	}
 	cfg := middleware.DefaultKeyAuthConfig
-	cfg.Skipper = func(c echo.Context) bool {
-		return c.Request().URL.Path == "/health"
-	}
+	cfg.Skipper = isHealthCheck
 	cfg.Validator = func(ukey string, c echo.Context) (bool, error) {
-		return ukey == key, nil
+		if subtle.ConstantTimeCompare([]byte(ukey), []byte(key)) == 1 {
+			c.SetRequest(c.Request().WithContext(context.WithValue(c.Request().Context(), rootKey{}, true)))
+			return true, nil
+		}
+		// fall back to keys minted through the API
+		k, err := ds.GetAPIKey(c.Request().Context(), hash.Key(ukey))
+		if err != nil {
+			return false, nil
+		}
+		if k.CreatedBy != "" {
+			u, err := ds.GetUser(c.Request().Context(), k.CreatedBy)
+			if err != nil {
+				if errors.Is(err, datastore.ErrUserNotFound) {
+					return false, nil
+				}
+				return false, err
+			}
+			c.SetRequest(c.Request().WithContext(context.WithValue(c.Request().Context(), tork.USERNAME, u.Username)))
+		}
+		return true, nil
 	}
 	return middleware.KeyAuthWithConfig(cfg)
 }
 
+// jwtAuth authenticates requests bearing a JWT issued by an OIDC
+// provider. The value of the given claim is used as the username
+// of the principal, which is provisioned on first use so that
+// submitted jobs can be attributed to it.
+func jwtAuth(ds datastore.Datastore, v *oidc.Verifier, claim string) echo.MiddlewareFunc {
+	cfg := middleware.DefaultKeyAuthConfig
+	cfg.Skipper = isHealthCheck
+	cfg.Validator = func(token string, c echo.Context) (bool, error) {
+		ctx := c.Request().Context()
+		claims, err := v.Verify(ctx, token)
+		if err != nil {
+			log.Debug().Err(err).Msg("rejected jwt")
+			return false, nil
+		}
+		username, ok := claims[claim].(string)
+		if !ok || username == "" {
+			return false, nil
+		}
+		if _, err := ds.GetUser(ctx, username); errors.Is(err, datastore.ErrUserNotFound) {
+			name, _ := claims["name"].(string)
+			if name == "" {
+				name = username
+			}
+			if err := ds.CreateUser(ctx, &tork.User{
+				ID:       uuid.NewUUID(),
+				Username: username,
+				Name:     name,
+			}); err != nil {
+				return false, errors.Wrapf(err, "error provisioning user %s", username)
+			}
+		} else if err != nil {
+			return false, err
+		}
+		c.SetRequest(c.Request().WithContext(context.WithValue(ctx, tork.USERNAME, username)))
+		return true, nil
+	}
+	return middleware.KeyAuthWithConfig(cfg)
+}
+
+// rbacRules maps a route to the minimum role required to call it.
+// Routes that are not listed are available to any principal.
+var rbacRules = map[string]string{
+	"POST /jobs":                          tork.ROLE_SUBMITTER,
+	"PUT /jobs/:id/cancel":                tork.ROLE_OPERATOR,
+	"PUT /jobs/:id/restart":               tork.ROLE_OPERATOR,
+	"PUT /tasks/:id/complete":             tork.ROLE_OPERATOR,
+	"POST /scheduled-jobs":                tork.ROLE_SUBMITTER,
+	"PUT /scheduled-jobs/:id/pause":       tork.ROLE_OPERATOR,
+	"PUT /scheduled-jobs/:id/resume":      tork.ROLE_OPERATOR,
+	"DELETE /scheduled-jobs/:id":          tork.ROLE_OPERATOR,
+	"GET /nodes":                          tork.ROLE_OPERATOR,
+	"GET /queues":                         tork.ROLE_OPERATOR,
+	"POST /users":                         tork.ROLE_ADMIN,
+	"PUT /users/:username/roles/:role":    tork.ROLE_ADMIN,
+	"DELETE /users/:username/roles/:role": tork.ROLE_ADMIN,
+	"POST /keys":                          tork.ROLE_ADMIN,
+	"GET /keys":                           tork.ROLE_ADMIN,
+	"DELETE /keys/:id":                    tork.ROLE_ADMIN,
+	"GET /secrets":                        tork.ROLE_ADMIN,
+	"PUT /secrets/:name":                  tork.ROLE_ADMIN,
+	"DELETE /secrets/:name":               tork.ROLE_ADMIN,
+	"GET /dead-letters":                   tork.ROLE_OPERATOR,
+	"GET /dead-letters/:id":               tork.ROLE_OPERATOR,
+	"PUT /dead-letters/:id/requeue":       tork.ROLE_OPERATOR,
+	"DELETE /dead-letters/:id":            tork.ROLE_OPERATOR,
+	"GET /debug/state":                    tork.ROLE_ADMIN,
+	"GET /debug/pprof/*":                  tork.ROLE_ADMIN,
+	"GET /debug/pprof/cmdline":            tork.ROLE_ADMIN,
+	"GET /debug/pprof/profile":            tork.ROLE_ADMIN,
+	"GET /debug/pprof/symbol":             tork.ROLE_ADMIN,
+	"POST /debug/pprof/symbol":            tork.ROLE_ADMIN,
+	"GET /debug/pprof/trace":              tork.ROLE_ADMIN,
+}
+
+// each role implies the privileges of the roles below it
+var rbacLevels = map[string]int{
+	tork.ROLE_SUBMITTER: 1,
+	tork.ROLE_OPERATOR:  2,
+	tork.ROLE_ADMIN:     3,
+}
+
+// rootKey marks the requests authenticated by the
+// static keyauth key, which act with every role.
+type rootKey struct{}
+
+// rbac limits the routes a principal may call based on its roles.
+// Requests that carry no principal are denied, except for those
+// authenticated by the static keyauth key.
+func rbac(ds datastore.Datastore) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			required, ok := rbacRules[fmt.Sprintf("%s %s", c.Request().Method, c.Path())]
+			if !ok {
+				return next(c)
+			}
+			if root, _ := c.Request().Context().Value(rootKey{}).(bool); root {
+				return next(c)
+			}
+			username, ok := c.Request().Context().Value(tork.USERNAME).(string)
+			if !ok || username == "" {
+				return echo.NewHTTPError(http.StatusForbidden, fmt.Sprintf("requires the %s role", required))
+			}
+			ctx := c.Request().Context()
+			u, err := ds.GetUser(ctx, username)
+			if err != nil {
+				return echo.ErrForbidden
+			}
+			roles, err := ds.GetUserRoles(ctx, u.ID)
+			if err != nil {
+				return err
+			}
+			for _, r := range roles {
+				if rbacLevels[r.Slug] >= rbacLevels[required] {
+					return next(c)
+				}
+			}
+			return echo.NewHTTPError(http.StatusForbidden, fmt.Sprintf("requires the %s role", required))
+		}
+	}
+}
+
 func logger() echo.MiddlewareFunc {
 	levelStr := conf.StringDefault("middleware.web.logger.level", "DEBUG")
 	level, err := zerolog.ParseLevel(strings.ToLower(levelStr))
 	if err != nil {
 		panic(err)
 	}
-	skip := conf.StringsDefault("middleware.web.logger.skip", []string{"GET /health"})
+	skip := conf.StringsDefault("middleware.web.logger.skip", []string{"GET /health", "GET /health/*"})
 	return middleware.RequestLoggerWithConfig(middleware.RequestLoggerConfig{
 		LogURI:      true,
 		LogStatus:   true,
@@ -224,3 +432,95 @@ func cors() echo.MiddlewareFunc {
 		},
 	)
 }
+
+// namespaceConfig is the config of
+// a namespace, under namespaces.<name>
+type namespaceConfig struct {
+	Users    []string `koanf:"users"`
+	Roles    []string `koanf:"roles"`
+	Queues   []string `koanf:"queues"`
+	MaxJobs  int      `koanf:"maxjobs"`
+	Defaults struct {
+		Queue    string `koanf:"queue"`
+		Timeout  string `koanf:"timeout"`
+		Priority int    `koanf:"priority"`
+		Retry    struct {
+			Limit int `koanf:"limit"`
+		} `koanf:"retry"`
+		Limits struct {
+			CPUs      string `koanf:"cpus"`
+			CPUShares int64  `koanf:"cpushares"`
+			Memory    string `koanf:"memory"`
+		} `koanf:"limits"`
+	} `koanf:"defaults"`
+}
+
+// namespaces reads the namespaces the
+// teams sharing the deployment work in.
+func namespaces() ([]*tork.Namespace, error) {
+	cfgs := map[string]namespaceConfig{}
+	if err := conf.Unmarshal("namespaces", &cfgs); err != nil {
+		return nil, errors.Wrapf(err, "error parsing namespaces config")
+	}
+	result := make([]*tork.Namespace, 0, len(cfgs))
+	for name, cfg := range cfgs {
+		ns := &tork.Namespace{
+			Name:          name,
+			Users:         cfg.Users,
+			Roles:         cfg.Roles,
+			Queues:        cfg.Queues,
+			MaxActiveJobs: cfg.MaxJobs,
+		}
+		d := cfg.Defaults
+		if d.Queue != "" || d.Timeout != "" || d.Priority != 0 || d.Retry.Limit != 0 ||
+			d.Limits.CPUs != "" || d.Limits.CPUShares != 0 || d.Limits.Memory != "" {
+			ns.Defaults = &tork.JobDefaults{
+				Queue:    d.Queue,
+				Timeout:  d.Timeout,
+				Priority: d.Priority,
+			}
+			if d.Retry.Limit != 0 {
+				ns.Defaults.Retry = &tork.TaskRetry{Limit: d.Retry.Limit}
+			}
+			if d.Limits.CPUs != "" || d.Limits.CPUShares != 0 || d.Limits.Memory != "" {
+				ns.Defaults.Limits = &tork.TaskLimits{
+					CPUs:      d.Limits.CPUs,
+					CPUShares: d.Limits.CPUShares,
+					Memory:    d.Limits.Memory,
+				}
+			}
+		}
+		result = append(result, ns)
+	}
+	return result, nil
+}
+
+// initLogStore creates the store the logs of the tasks are
+// kept in. By default the logs are kept in the datastore.
+func initLogStore() (logstore.Store, error) {
+	switch lt := conf.StringDefault("logs.type", logstore.LOGSTORE_DATASTORE); lt {
+	case logstore.LOGSTORE_DATASTORE:
+		return nil, nil
+	case logstore.LOGSTORE_FS:
+		return logstore.NewFileStore(conf.String("logs.fs.dir"))
+	case logstore.LOGSTORE_S3:
+		opts := []logstore.S3Option{}
+		if prefix := conf.String("logs.s3.prefix"); prefix != "" {
+			opts = append(opts, logstore.WithS3Prefix(prefix))
+		}
+		if region := conf.String("logs.s3.region"); region != "" {
+			opts = append(opts, logstore.WithS3Region(region))
+		}
+		if endpoint := conf.String("logs.s3.endpoint"); endpoint != "" {
+			opts = append(opts, logstore.WithS3Endpoint(endpoint))
+		}
+		if accessKey := conf.String("logs.s3.accesskey"); accessKey != "" {
+			opts = append(opts, logstore.WithS3Credentials(accessKey,
+				conf.String("logs.s3.secretkey"),
+				conf.String("logs.s3.sessiontoken")))
+		}
+		return logstore.NewS3Store(conf.String("logs.s3.bucket"), opts...)
+	default:
+		return nil, errors.Errorf("unknown logs type: %s", lt)
+	}
+}
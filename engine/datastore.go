@@ -1,9 +1,13 @@
 package engine
 
 import (
+	"io"
+
 	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
 	"github.com/runabol/tork/conf"
 	"github.com/runabol/tork/datastore"
+	"github.com/runabol/tork/datastore/bolt"
 	"github.com/runabol/tork/datastore/inmemory"
 	"github.com/runabol/tork/datastore/postgres"
 )
@@ -28,9 +32,15 @@ func (e *Engine) createDatastore(dstype string) (datastore.Datastore, error) {
 	if ok {
 		return dsp()
 	}
+	keyRetention := conf.DurationDefault("datastore.idempotency.retention", datastore.DefaultIdempotencyKeyRetention)
 	switch dstype {
 	case datastore.DATASTORE_INMEMORY:
-		return inmemory.NewInMemoryDatastore(), nil
+		return inmemory.NewInMemoryDatastore(
+			inmemory.WithJobExpiration(conf.DurationDefault("datastore.inmemory.jobs.expiration", inmemory.DefaultJobExpiration)),
+			inmemory.WithNodeExpiration(conf.DurationDefault("datastore.inmemory.nodes.expiration", inmemory.DefaultNodeExpiration)),
+			inmemory.WithCleanupInterval(conf.DurationDefault("datastore.inmemory.cleanup.interval", inmemory.DefaultCleanupInterval)),
+			inmemory.WithIdempotencyKeyRetention(keyRetention),
+		), nil
 	case datastore.DATASTORE_POSTGRES:
 		dsn := conf.StringDefault(
 			"datastore.postgres.dsn",
@@ -38,8 +48,25 @@ func (e *Engine) createDatastore(dstype string) (datastore.Datastore, error) {
 		)
 		return postgres.NewPostgresDataStore(dsn,
 			postgres.WithTaskLogRetentionPeriod(conf.DurationDefault("datastore.postgres.task.logs.interval", postgres.DefaultTaskLogsRetentionPeriod)),
+			postgres.WithIdempotencyKeyRetention(keyRetention),
+		)
+	case datastore.DATASTORE_BOLT:
+		return bolt.NewBoltDatastore(
+			conf.StringDefault("datastore.bolt.path", bolt.DefaultPath),
+			bolt.WithOpenTimeout(conf.DurationDefault("datastore.bolt.open.timeout", bolt.DefaultOpenTimeout)),
+			bolt.WithIdempotencyKeyRetention(keyRetention),
 		)
 	default:
 		return nil, errors.Errorf("unknown datastore type: %s", dstype)
 	}
 }
+
+// closeDatastore releases the datastore,
+// e.g. the file of an embedded datastore.
+func (e *Engine) closeDatastore() {
+	if c, ok := e.ds.(io.Closer); ok {
+		if err := c.Close(); err != nil {
+			log.Error().Err(err).Msg("error closing datastore")
+		}
+	}
+}
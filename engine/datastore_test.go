@@ -1,9 +1,13 @@
 package engine
 
 import (
+	"context"
+	"fmt"
+	"path/filepath"
 	"testing"
 
 	"github.com/runabol/tork/datastore"
+	"github.com/runabol/tork/datastore/bolt"
 	"github.com/runabol/tork/datastore/inmemory"
 	"github.com/stretchr/testify/assert"
 )
@@ -16,6 +20,23 @@ func Test_createDatastore(t *testing.T) {
 	assert.IsType(t, &inmemory.InMemoryDatastore{}, ds)
 }
 
+func Test_createBoltDatastore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tork.db")
+	loadConfig(t, fmt.Sprintf(`
+datastore:
+  bolt:
+    path: %s
+`, path))
+	eng := New(Config{Mode: ModeStandalone})
+	ds, err := eng.createDatastore(datastore.DATASTORE_BOLT)
+	assert.NoError(t, err)
+	assert.IsType(t, &bolt.BoltDatastore{}, ds)
+	assert.FileExists(t, path)
+	eng.ds = ds
+	eng.closeDatastore()
+	assert.Error(t, ds.HealthCheck(context.Background()))
+}
+
 func Test_createDatastoreProvider(t *testing.T) {
 	eng := New(Config{Mode: ModeStandalone})
 	assert.Equal(t, StateIdle, eng.state)
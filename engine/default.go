@@ -4,6 +4,7 @@ import (
 	"context"
 
 	"github.com/runabol/tork"
+	"github.com/runabol/tork/artifact"
 	"github.com/runabol/tork/datastore"
 	"github.com/runabol/tork/input"
 	"github.com/runabol/tork/middleware/job"
@@ -11,6 +12,7 @@ import (
 	"github.com/runabol/tork/middleware/task"
 	"github.com/runabol/tork/middleware/web"
 	"github.com/runabol/tork/runtime"
+	"github.com/runabol/tork/secrets"
 
 	"github.com/runabol/tork/mq"
 )
@@ -25,6 +27,10 @@ func RegisterTaskMiddleware(mw task.MiddlewareFunc) {
 	defaultEngine.RegisterTaskMiddleware(mw)
 }
 
+func RegisterWorkerMiddleware(mw task.MiddlewareFunc) {
+	defaultEngine.RegisterWorkerMiddleware(mw)
+}
+
 func RegisterJobMiddleware(mw job.MiddlewareFunc) {
 	defaultEngine.RegisterJobMiddleware(mw)
 }
@@ -33,6 +39,10 @@ func RegisterNodeMiddleware(mw node.MiddlewareFunc) {
 	defaultEngine.RegisterNodeMiddleware(mw)
 }
 
+func RegisterRuntimeMiddleware(mw runtime.Middleware) {
+	defaultEngine.RegisterRuntimeMiddleware(mw)
+}
+
 func RegisterMounter(runtime, name string, mounter runtime.Mounter) {
 	defaultEngine.RegisterMounter(runtime, name, mounter)
 }
@@ -49,6 +59,14 @@ func RegisterBrokerProvider(name string, provider mq.Provider) {
 	defaultEngine.RegisterBrokerProvider(name, provider)
 }
 
+func RegisterArtifactStore(s artifact.Store) {
+	defaultEngine.RegisterArtifactStore(s)
+}
+
+func RegisterSecretsProvider(scheme string, provider secrets.Provider) error {
+	return defaultEngine.RegisterSecretsProvider(scheme, provider)
+}
+
 func RegisterEndpoint(method, path string, handler web.HandlerFunc) {
 	defaultEngine.RegisterEndpoint(method, path, handler)
 }
@@ -12,6 +12,7 @@ import (
 	"github.com/rs/zerolog/log"
 
 	"github.com/runabol/tork"
+	"github.com/runabol/tork/artifact"
 	"github.com/runabol/tork/datastore"
 	"github.com/runabol/tork/input"
 	"github.com/runabol/tork/internal/coordinator"
@@ -21,6 +22,7 @@ import (
 	"github.com/runabol/tork/middleware/task"
 	"github.com/runabol/tork/middleware/web"
 	"github.com/runabol/tork/runtime"
+	"github.com/runabol/tork/secrets"
 
 	"github.com/runabol/tork/mq"
 )
@@ -51,10 +53,13 @@ type Engine struct {
 	ds           datastore.Datastore
 	mounters     map[string]*runtime.MultiMounter
 	runtime      runtime.Runtime
+	runtimeMw    []runtime.Middleware
 	coordinator  *coordinator.Coordinator
 	worker       *worker.Worker
 	dsProviders  map[string]datastore.Provider
 	mqProviders  map[string]mq.Provider
+	secrets      *secrets.MultiProvider
+	artifacts    artifact.Store
 	onBrokerInit []func(b mq.Broker) error
 	onDsInit     []func(ds datastore.Datastore) error
 }
@@ -70,6 +75,9 @@ type Middleware struct {
 	Task []task.MiddlewareFunc
 	Job  []job.MiddlewareFunc
 	Node []node.MiddlewareFunc
+	// Worker middleware only wraps the execution of
+	// tasks by the worker, after the Task middleware.
+	Worker []task.MiddlewareFunc
 }
 
 type JobListener func(j *tork.Job)
@@ -87,6 +95,7 @@ func New(cfg Config) *Engine {
 		mounters:    make(map[string]*runtime.MultiMounter),
 		dsProviders: make(map[string]datastore.Provider),
 		mqProviders: make(map[string]mq.Provider),
+		secrets:     secrets.NewMultiProvider(),
 	}
 }
 
@@ -100,6 +109,9 @@ func (e *Engine) Start() error {
 	e.mu.Lock()
 	defer e.mu.Unlock()
 	e.mustState(StateIdle)
+	if err := e.validateConfig(e.cfg.Mode); err != nil {
+		return err
+	}
 	var err error
 	switch e.cfg.Mode {
 	case ModeCoordinator:
@@ -166,6 +178,7 @@ func (e *Engine) runCoordinator() error {
 				log.Error().Err(err).Msg("error stopping coordinator")
 			}
 		}
+		e.closeDatastore()
 		close(e.terminated)
 	}()
 
@@ -227,6 +240,7 @@ func (e *Engine) runStandalone() error {
 				log.Error().Err(err).Msg("error stopping coordinator")
 			}
 		}
+		e.closeDatastore()
 		close(e.terminated)
 	}()
 
@@ -253,6 +267,13 @@ func (e *Engine) RegisterTaskMiddleware(mw task.MiddlewareFunc) {
 	e.cfg.Middleware.Task = append(e.cfg.Middleware.Task, mw)
 }
 
+func (e *Engine) RegisterWorkerMiddleware(mw task.MiddlewareFunc) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.mustState(StateIdle)
+	e.cfg.Middleware.Worker = append(e.cfg.Middleware.Worker, mw)
+}
+
 func (e *Engine) RegisterJobMiddleware(mw job.MiddlewareFunc) {
 	e.mu.Lock()
 	defer e.mu.Unlock()
@@ -286,6 +307,13 @@ func (e *Engine) RegisterMounter(rt string, name string, mounter runtime.Mounter
 	mounters.RegisterMounter(name, mounter)
 }
 
+func (e *Engine) RegisterRuntimeMiddleware(mw runtime.Middleware) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.mustState(StateIdle)
+	e.runtimeMw = append(e.runtimeMw, mw)
+}
+
 func (e *Engine) RegisterRuntime(rt runtime.Runtime) {
 	e.mu.Lock()
 	defer e.mu.Unlock()
@@ -316,6 +344,26 @@ func (e *Engine) RegisterBrokerProvider(name string, provider mq.Provider) {
 	e.mqProviders[name] = provider
 }
 
+// RegisterArtifactStore sets the store (e.g. S3 or GCS)
+// that task artifacts are uploaded to, overriding the
+// store configured under [artifacts].
+func (e *Engine) RegisterArtifactStore(s artifact.Store) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.mustState(StateIdle)
+	e.artifacts = s
+}
+
+// RegisterSecretsProvider registers a provider for env values
+// of the form <scheme>://<ref>, which workers resolve right
+// before executing a task.
+func (e *Engine) RegisterSecretsProvider(scheme string, provider secrets.Provider) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.mustState(StateIdle)
+	return e.secrets.RegisterProvider(scheme, provider)
+}
+
 func (e *Engine) SubmitJob(ctx context.Context, ij *input.Job, listeners ...JobListener) (*tork.Job, error) {
 	e.mustState(StateRunning)
 	if e.cfg.Mode != ModeStandalone && e.cfg.Mode != ModeCoordinator {
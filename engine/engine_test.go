@@ -2,19 +2,30 @@ package engine
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
 
 	"github.com/labstack/echo/v4"
 	"github.com/runabol/tork"
+	"github.com/runabol/tork/artifact"
 	"github.com/runabol/tork/datastore"
 	"github.com/runabol/tork/datastore/inmemory"
 	"github.com/runabol/tork/input"
 	"github.com/runabol/tork/internal/hash"
+	"github.com/runabol/tork/internal/oidc"
 	"github.com/runabol/tork/internal/uuid"
 	"github.com/runabol/tork/runtime/docker"
 	"github.com/runabol/tork/runtime/shell"
+	"github.com/runabol/tork/secrets/vault"
 
 	"github.com/runabol/tork/mq"
 	"github.com/runabol/tork/runtime"
@@ -267,6 +278,39 @@ func TestRegisterRuntime(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestRegisterRuntimeMiddleware(t *testing.T) {
+	eng := New(Config{Mode: ModeStandalone})
+	assert.Equal(t, StateIdle, eng.state)
+
+	eng.RegisterRuntime(shell.NewShellRuntime(shell.Config{}))
+	eng.RegisterRuntimeMiddleware(runtime.RunMiddleware(func(next runtime.RunFunc) runtime.RunFunc {
+		return next
+	}))
+
+	err := eng.Start()
+	assert.NoError(t, err)
+	assert.Equal(t, StateRunning, eng.state)
+
+	err = eng.Terminate()
+	assert.NoError(t, err)
+}
+
+func TestRegisterArtifactStore(t *testing.T) {
+	eng := New(Config{Mode: ModeStandalone})
+	assert.Equal(t, StateIdle, eng.state)
+
+	store, err := artifact.NewFileStore(t.TempDir())
+	assert.NoError(t, err)
+	eng.RegisterArtifactStore(store)
+
+	err = eng.Start()
+	assert.NoError(t, err)
+	assert.Equal(t, StateRunning, eng.state)
+
+	err = eng.Terminate()
+	assert.NoError(t, err)
+}
+
 func TestRegisterDatastoreProvider(t *testing.T) {
 	eng := New(Config{Mode: ModeStandalone})
 	assert.Equal(t, StateIdle, eng.state)
@@ -283,6 +327,26 @@ func TestRegisterDatastoreProvider(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestRegisterSecretsProvider(t *testing.T) {
+	eng := New(Config{Mode: ModeStandalone})
+	assert.Equal(t, StateIdle, eng.state)
+
+	vp, err := vault.NewProvider(vault.Config{
+		Address: "http://localhost:8200",
+		Token:   "root",
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, eng.RegisterSecretsProvider(vault.Scheme, vp))
+	assert.Error(t, eng.RegisterSecretsProvider(vault.Scheme, vp))
+
+	err = eng.Start()
+	assert.NoError(t, err)
+	assert.Equal(t, StateRunning, eng.state)
+
+	err = eng.Terminate()
+	assert.NoError(t, err)
+}
+
 func TestOnBrokerInit(t *testing.T) {
 	eng := New(Config{Mode: ModeStandalone})
 	assert.Equal(t, StateIdle, eng.state)
@@ -324,3 +388,196 @@ func TestOnDatastoreInit(t *testing.T) {
 	err = eng.Terminate()
 	assert.NoError(t, err)
 }
+
+func Test_keyAuth(t *testing.T) {
+	ds := inmemory.NewInMemoryDatastore()
+	mw := keyAuth(ds, "static-key")
+	h := func(c echo.Context) error {
+		return nil
+	}
+
+	req, err := http.NewRequest("GET", "/jobs", nil)
+	assert.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer static-key")
+	ctx := echo.New().NewContext(req, httptest.NewRecorder())
+	assert.NoError(t, mw(h)(ctx))
+
+	req, err = http.NewRequest("GET", "/jobs", nil)
+	assert.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer wrong-key")
+	ctx = echo.New().NewContext(req, httptest.NewRecorder())
+	assert.Error(t, mw(h)(ctx))
+
+	for _, path := range []string{"/health", "/health/live", "/health/ready"} {
+		req, err = http.NewRequest("GET", path, nil)
+		assert.NoError(t, err)
+		ctx = echo.New().NewContext(req, httptest.NewRecorder())
+		assert.NoError(t, mw(h)(ctx))
+	}
+}
+
+func Test_keyAuthStoredKey(t *testing.T) {
+	ds := inmemory.NewInMemoryDatastore()
+	u := &tork.User{
+		ID:       uuid.NewUUID(),
+		Username: "someuser",
+	}
+	err := ds.CreateUser(context.Background(), u)
+	assert.NoError(t, err)
+	err = ds.CreateAPIKey(context.Background(), &tork.APIKey{
+		Name:      "test",
+		KeyHash:   hash.Key("minted-key"),
+		CreatedBy: u.ID,
+	})
+	assert.NoError(t, err)
+	err = ds.CreateAPIKey(context.Background(), &tork.APIKey{
+		Name:      "orphan",
+		KeyHash:   hash.Key("orphan-key"),
+		CreatedBy: uuid.NewUUID(),
+	})
+	assert.NoError(t, err)
+	mw := keyAuth(ds, "static-key")
+	req, err := http.NewRequest("GET", "/jobs", nil)
+	assert.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer minted-key")
+	ctx := echo.New().NewContext(req, httptest.NewRecorder())
+	var username any
+	h := func(c echo.Context) error {
+		username = c.Request().Context().Value(tork.USERNAME)
+		return nil
+	}
+	assert.NoError(t, mw(h)(ctx))
+	assert.Equal(t, "someuser", username)
+
+	// the key of a user who no longer exists
+	req.Header.Set("Authorization", "Bearer orphan-key")
+	ctx = echo.New().NewContext(req, httptest.NewRecorder())
+	assert.Error(t, mw(h)(ctx))
+}
+
+func Test_jwtAuth(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	jwks := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"keys": []map[string]string{{
+				"kid": "key-1",
+				"kty": "RSA",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	}))
+	defer jwks.Close()
+	v, err := oidc.NewVerifier(oidc.Config{
+		Issuer:   "https://issuer.example.com",
+		Audience: "tork",
+		JWKSURL:  jwks.URL,
+	})
+	assert.NoError(t, err)
+	ds := inmemory.NewInMemoryDatastore()
+	mw := jwtAuth(ds, v, "email")
+
+	tok := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":   "https://issuer.example.com",
+		"aud":   "tork",
+		"email": "someone@example.com",
+		"exp":   time.Now().Add(time.Minute).Unix(),
+	})
+	tok.Header["kid"] = "key-1"
+	s, err := tok.SignedString(key)
+	assert.NoError(t, err)
+
+	req, err := http.NewRequest("GET", "/jobs", nil)
+	assert.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer "+s)
+	ctx := echo.New().NewContext(req, httptest.NewRecorder())
+	var username any
+	h := func(c echo.Context) error {
+		username = c.Request().Context().Value(tork.USERNAME)
+		return nil
+	}
+	assert.NoError(t, mw(h)(ctx))
+	assert.Equal(t, "someone@example.com", username)
+
+	u, err := ds.GetUser(context.Background(), "someone@example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, "someone@example.com", u.Name)
+
+	req, err = http.NewRequest("GET", "/jobs", nil)
+	assert.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer bad-token")
+	ctx = echo.New().NewContext(req, httptest.NewRecorder())
+	assert.Error(t, mw(h)(ctx))
+}
+
+func Test_rbac(t *testing.T) {
+	ctx := context.Background()
+	ds := inmemory.NewInMemoryDatastore()
+	roles := map[string]*tork.Role{}
+	rs, err := ds.GetRoles(ctx)
+	assert.NoError(t, err)
+	for _, r := range rs {
+		roles[r.Slug] = r
+	}
+	newUser := func(role string) string {
+		u := &tork.User{ID: uuid.NewUUID(), Username: uuid.NewShortUUID()}
+		assert.NoError(t, ds.CreateUser(ctx, u))
+		if role != "" {
+			assert.NoError(t, ds.AssignRole(ctx, u.ID, roles[role].ID))
+		}
+		return u.Username
+	}
+	submitter := newUser(tork.ROLE_SUBMITTER)
+	operator := newUser(tork.ROLE_OPERATOR)
+	nobody := newUser("")
+
+	e := echo.New()
+	e.Use(func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			switch u := c.Request().Header.Get("X-User"); u {
+			case "":
+			case "root":
+				c.SetRequest(c.Request().WithContext(context.WithValue(c.Request().Context(), rootKey{}, true)))
+			default:
+				c.SetRequest(c.Request().WithContext(context.WithValue(c.Request().Context(), tork.USERNAME, u)))
+			}
+			return next(c)
+		}
+	})
+	e.Use(rbac(ds))
+	ok := func(c echo.Context) error {
+		return c.NoContent(http.StatusOK)
+	}
+	e.POST("/jobs", ok)
+	e.GET("/jobs", ok)
+	e.PUT("/jobs/:id/cancel", ok)
+	e.POST("/users", ok)
+
+	tests := []struct {
+		method string
+		path   string
+		user   string
+		code   int
+	}{
+		{"GET", "/jobs", nobody, http.StatusOK},
+		{"POST", "/jobs", nobody, http.StatusForbidden},
+		{"POST", "/jobs", submitter, http.StatusOK},
+		{"PUT", "/jobs/1234/cancel", submitter, http.StatusForbidden},
+		{"PUT", "/jobs/1234/cancel", operator, http.StatusOK},
+		{"POST", "/jobs", operator, http.StatusOK},
+		{"POST", "/users", operator, http.StatusForbidden},
+		{"POST", "/users", "", http.StatusForbidden},
+		{"POST", "/jobs", "", http.StatusForbidden},
+		{"GET", "/jobs", "", http.StatusOK},
+		{"POST", "/users", "root", http.StatusOK},
+	}
+	for _, tt := range tests {
+		req, err := http.NewRequest(tt.method, tt.path, nil)
+		assert.NoError(t, err)
+		req.Header.Set("X-User", tt.user)
+		w := httptest.NewRecorder()
+		e.ServeHTTP(w, req)
+		assert.Equal(t, tt.code, w.Code, "%s %s", tt.method, tt.path)
+	}
+}
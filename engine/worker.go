@@ -1,28 +1,60 @@
 package engine
 
 import (
+	goruntime "runtime"
+	"time"
+
+	"github.com/docker/go-units"
 	"github.com/pkg/errors"
+	"github.com/runabol/tork/artifact"
 	"github.com/runabol/tork/conf"
+	"github.com/runabol/tork/internal/host"
 	"github.com/runabol/tork/internal/worker"
 	"github.com/runabol/tork/middleware/task"
+	"github.com/runabol/tork/mq"
 
 	"github.com/runabol/tork/runtime"
+	"github.com/runabol/tork/runtime/containerd"
 	"github.com/runabol/tork/runtime/docker"
+	"github.com/runabol/tork/runtime/kubernetes"
 	"github.com/runabol/tork/runtime/shell"
+	"github.com/runabol/tork/secrets"
+	"github.com/runabol/tork/secrets/vault"
 )
 
 func (e *Engine) initWorker() error {
-	// init the runtime
-	rt, err := e.initRuntime()
+	// init the runtime. it publishes the task logs through the
+	// redactor, which masks the secret values the worker resolves
+	redactor := secrets.NewRedactor()
+	rt, err := e.initRuntime(redactor.Broker(e.broker))
 	if err != nil {
 		return err
 	}
+	rt = runtime.Wrap(rt, e.runtimeMw...)
 	// register host env middleware
 	hostenv, err := task.NewHostEnv(conf.Strings("middleware.task.hostenv.vars")...)
 	if err != nil {
 		return err
 	}
 	e.cfg.Middleware.Task = append(e.cfg.Middleware.Task, hostenv.Execute)
+	mw := make([]task.MiddlewareFunc, 0, len(e.cfg.Middleware.Task)+len(e.cfg.Middleware.Worker))
+	mw = append(mw, e.cfg.Middleware.Task...)
+	mw = append(mw, e.cfg.Middleware.Worker...)
+	// register the vault secrets provider
+	if conf.Bool("secrets.vault.enabled") {
+		vp, err := vault.NewProvider(vault.Config{
+			Address:   conf.String("secrets.vault.address"),
+			Token:     conf.String("secrets.vault.token"),
+			Mount:     conf.String("secrets.vault.mount"),
+			Namespace: conf.String("secrets.vault.namespace"),
+		})
+		if err != nil {
+			return errors.Wrapf(err, "error creating vault secrets provider")
+		}
+		if err := e.secrets.RegisterProvider(vault.Scheme, vp); err != nil {
+			return err
+		}
+	}
 	w, err := worker.NewWorker(worker.Config{
 		Name:    conf.StringDefault("worker.name", "Worker"),
 		Broker:  e.broker,
@@ -31,10 +63,26 @@ func (e *Engine) initWorker() error {
 		Limits: worker.Limits{
 			DefaultCPUsLimit:   conf.String("worker.limits.cpus"),
 			DefaultMemoryLimit: conf.String("worker.limits.memory"),
+			DefaultOutputLimit: conf.String("worker.limits.output"),
 			DefaultTimeout:     conf.String("worker.limits.timeout"),
 		},
-		Address:    conf.String("worker.address"),
-		Middleware: e.cfg.Middleware.Task,
+		Address:      conf.String("worker.address"),
+		Middleware:   mw,
+		Concurrency:  conf.IntDefault("worker.concurrency", 0),
+		DrainTimeout: conf.DurationDefault("worker.drain.timeout", worker.DefaultDrainTimeout),
+		Admission: worker.Admission{
+			MaxCPUPercent:    float64(conf.IntDefault("worker.admission.cpu", 0)),
+			MaxMemoryPercent: float64(conf.IntDefault("worker.admission.memory", 0)),
+			MaxDiskPercent:   float64(conf.IntDefault("worker.admission.disk", 0)),
+			DiskPath:         conf.String("worker.admission.disk_path"),
+		},
+		Secrets:  e.secrets,
+		Redactor: redactor,
+		GPUs:     conf.IntDefault("worker.gpus", host.GetGPUCount()),
+		Platform: conf.StringDefault("worker.platform", defaultPlatform()),
+		Tags:     conf.StringMap("worker.tags"),
+		Debug:    conf.Bool("debug.enabled"),
+		APIKey:   conf.String("worker.api.key"),
 	})
 	if err != nil {
 		return errors.Wrapf(err, "error creating worker")
@@ -46,14 +94,42 @@ func (e *Engine) initWorker() error {
 	return nil
 }
 
-func (e *Engine) initRuntime() (runtime.Runtime, error) {
+// defaultPlatform is the platform of the host. Containers
+// run Linux even on hosts with a different OS.
+func defaultPlatform() string {
+	if conf.StringDefault("runtime.type", runtime.Docker) == runtime.Shell {
+		return goruntime.GOOS + "/" + goruntime.GOARCH
+	}
+	return "linux/" + goruntime.GOARCH
+}
+
+func (e *Engine) initRuntime(broker mq.Broker) (runtime.Runtime, error) {
 	if e.runtime != nil {
 		return e.runtime, nil
 	}
 	runtimeType := conf.StringDefault("runtime.type", runtime.Docker)
+	artifacts, err := e.initArtifactStore()
+	if err != nil {
+		return nil, err
+	}
+	downloads, err := fetchPolicy()
+	if err != nil {
+		return nil, err
+	}
 	switch runtimeType {
-	case runtime.Docker:
-		mounter, ok := e.mounters[runtime.Docker]
+	case runtime.Docker, runtime.Podman:
+		clientCfg := docker.ClientConfig{
+			Host:       conf.String("runtime.docker.host"),
+			CACert:     conf.String("runtime.docker.tls.cacert"),
+			Cert:       conf.String("runtime.docker.tls.cert"),
+			Key:        conf.String("runtime.docker.tls.key"),
+			APIVersion: conf.String("runtime.docker.version"),
+		}
+		if runtimeType == runtime.Podman {
+			// Podman is driven through its Docker-compatible API
+			clientCfg.Host = conf.StringDefault("runtime.podman.host", docker.PodmanHost())
+		}
+		mounter, ok := e.mounters[runtimeType]
 		if !ok {
 			mounter = runtime.NewMultiMounter()
 		}
@@ -64,27 +140,144 @@ func (e *Engine) initRuntime() (runtime.Runtime, error) {
 		})
 		mounter.RegisterMounter("bind", bm)
 		// register volume mounter
-		vm, err := docker.NewVolumeMounter()
+		vm, err := docker.NewVolumeMounter(docker.VolumeConfig{
+			Named: conf.Strings("mounts.volume.named"),
+		}, clientCfg.Opts()...)
 		if err != nil {
 			return nil, err
 		}
 		mounter.RegisterMounter("volume", vm)
 		// register tmpfs mounter
 		mounter.RegisterMounter("tmpfs", docker.NewTmpfsMounter())
+		var reapInterval time.Duration
+		if conf.Bool("runtime.docker.reaper.enabled") {
+			reapInterval = conf.DurationDefault("runtime.docker.reaper.interval", time.Minute)
+		}
 		return docker.NewDockerRuntime(
 			docker.WithMounter(mounter),
 			docker.WithConfig(conf.String("runtime.docker.config")),
-			docker.WithBroker(e.broker),
+			docker.WithBroker(broker),
 			docker.WithSandbox(conf.BoolDefault("runtime.docker.sandbox", false)),
+			docker.WithUser(conf.String("runtime.docker.user")),
+			docker.WithNonRoot(conf.Bool("runtime.docker.nonroot")),
+			docker.WithUlimits(conf.Strings("runtime.docker.ulimits")),
+			docker.WithSecurity(docker.SecurityConfig{
+				CapDrop:            conf.Strings("runtime.docker.security.capdrop"),
+				SecurityOpt:        conf.Strings("runtime.docker.security.opt"),
+				ReadOnlyRootfs:     conf.Bool("runtime.docker.security.readonly"),
+				AllowPrivileged:    conf.Bool("runtime.docker.security.privileged"),
+				AllowedDevices:     conf.Strings("runtime.docker.security.devices"),
+				AllowedSecurityOpt: conf.Strings("runtime.docker.security.allowedopts"),
+			}),
+			docker.WithClientConfig(clientCfg),
+			docker.WithReaper(reapInterval),
+			docker.WithKeepFailed(conf.DurationDefault("runtime.docker.keepfailed", 0)),
+			docker.WithImagePolicy(imagePolicy()),
+			docker.WithCosignKey(conf.String("runtime.docker.cosign.key")),
+			docker.WithPool(docker.PoolConfig{
+				Images:  conf.Strings("runtime.docker.pool.images"),
+				Size:    conf.IntDefault("runtime.docker.pool.size", 1),
+				MaxUses: conf.IntDefault("runtime.docker.pool.maxuses", 1),
+			}),
+			docker.WithArtifactStore(artifacts),
+			docker.WithFetchPolicy(downloads),
+			docker.WithIgnoreExitCode(conf.Bool("runtime.ignoreexitcode")),
 		)
 	case runtime.Shell:
 		return shell.NewShellRuntime(shell.Config{
-			CMD:    conf.Strings("runtime.shell.cmd"),
-			UID:    conf.StringDefault("runtime.shell.uid", shell.DEFAULT_UID),
-			GID:    conf.StringDefault("runtime.shell.gid", shell.DEFAULT_GID),
-			Broker: e.broker,
+			CMD:       conf.Strings("runtime.shell.cmd"),
+			UID:       conf.StringDefault("runtime.shell.uid", shell.DEFAULT_UID),
+			GID:       conf.StringDefault("runtime.shell.gid", shell.DEFAULT_GID),
+			Rlimits:   conf.IntMap("runtime.shell.rlimits"),
+			Broker:    broker,
+			Artifacts: artifacts,
+			Downloads: downloads,
+
+			IgnoreExitCode: conf.Bool("runtime.ignoreexitcode"),
 		}), nil
+	case runtime.Containerd:
+		return containerd.NewContainerdRuntime(
+			containerd.WithCTR(conf.StringDefault("runtime.containerd.ctr", containerd.DEFAULT_CTR)),
+			containerd.WithAddress(conf.StringDefault("runtime.containerd.address", containerd.DEFAULT_ADDRESS)),
+			containerd.WithNamespace(conf.StringDefault("runtime.containerd.namespace", containerd.DEFAULT_NAMESPACE)),
+			containerd.WithNetwork(conf.StringDefault("runtime.containerd.network", containerd.NetworkHost)),
+			containerd.WithBroker(broker),
+			containerd.WithArtifactStore(artifacts),
+			containerd.WithIgnoreExitCode(conf.Bool("runtime.ignoreexitcode")),
+		)
+	case runtime.Kubernetes:
+		return kubernetes.NewKubernetesRuntime(
+			kubernetes.WithHost(conf.String("runtime.kubernetes.host")),
+			kubernetes.WithToken(conf.String("runtime.kubernetes.token")),
+			kubernetes.WithCACert(conf.String("runtime.kubernetes.cacert")),
+			kubernetes.WithNamespace(conf.String("runtime.kubernetes.namespace")),
+			kubernetes.WithServiceAccount(conf.String("runtime.kubernetes.serviceaccount")),
+			kubernetes.WithBroker(broker),
+			kubernetes.WithArtifactStore(artifacts),
+			kubernetes.WithIgnoreExitCode(conf.Bool("runtime.ignoreexitcode")),
+		)
 	default:
 		return nil, errors.Errorf("unknown runtime type: %s", runtimeType)
 	}
 }
+
+func (e *Engine) initArtifactStore() (artifact.Store, error) {
+	if e.artifacts != nil {
+		return e.artifacts, nil
+	}
+	switch conf.StringDefault("artifacts.type", "fs") {
+	case "fs":
+		dir := conf.String("artifacts.fs.dir")
+		if dir == "" {
+			return nil, nil
+		}
+		return artifact.NewFileStore(dir)
+	case "s3":
+		opts := []artifact.S3Option{}
+		if region := conf.String("artifacts.s3.region"); region != "" {
+			opts = append(opts, artifact.WithS3Region(region))
+		}
+		if endpoint := conf.String("artifacts.s3.endpoint"); endpoint != "" {
+			opts = append(opts, artifact.WithS3Endpoint(endpoint))
+		}
+		if accessKey := conf.String("artifacts.s3.accesskey"); accessKey != "" {
+			opts = append(opts, artifact.WithS3Credentials(accessKey,
+				conf.String("artifacts.s3.secretkey"),
+				conf.String("artifacts.s3.sessiontoken")))
+		}
+		return artifact.NewS3Store(conf.String("artifacts.s3.bucket"), opts...)
+	case "gcs":
+		opts := []artifact.GCSOption{}
+		if endpoint := conf.String("artifacts.gcs.endpoint"); endpoint != "" {
+			opts = append(opts, artifact.WithGCSEndpoint(endpoint))
+		}
+		return artifact.NewGCSStore(conf.String("artifacts.gcs.bucket"), opts...)
+	default:
+		return nil, errors.Errorf("unknown artifacts type: %s", conf.String("artifacts.type"))
+	}
+}
+
+// fetchPolicy restricts the downloads of the inputs of tasks.
+func fetchPolicy() (artifact.FetchPolicy, error) {
+	maxSize := artifact.DEFAULT_FETCH_MAXSIZE
+	if v := conf.String("artifacts.downloads.maxsize"); v != "" {
+		size, err := units.RAMInBytes(v)
+		if err != nil {
+			return artifact.FetchPolicy{}, errors.Wrapf(err, "invalid artifacts.downloads.maxsize")
+		}
+		maxSize = size
+	}
+	return artifact.FetchPolicy{
+		Hosts:   conf.Strings("artifacts.downloads.hosts"),
+		MaxSize: maxSize,
+	}, nil
+}
+
+// imagePolicy is enforced by both the coordinator,
+// when jobs are submitted, and the docker runtime.
+func imagePolicy() runtime.ImagePolicy {
+	return runtime.ImagePolicy{
+		Allow: conf.Strings("images.allow"),
+		Deny:  conf.Strings("images.deny"),
+	}
+}
@@ -0,0 +1,116 @@
+package input
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/pkg/errors"
+)
+
+var validationMessages = map[string]string{
+	"required":             "is required",
+	"duration":             "is not a valid duration (e.g. 10s, 5m, 1h)",
+	"expr":                 "is not a valid expression",
+	"queue":                "is not a valid queue name",
+	"namespace":            "is not a valid namespace name (e.g. team-a)",
+	"cpus":                 "is not a valid cpus value (e.g. 1, 0.5)",
+	"memory":               "is not a valid size (e.g. 512m, 1g)",
+	"cron":                 "is not a valid cron expression (e.g. 0 * * * *)",
+	"typerequired":         "type is required",
+	"targetrequired":       "target is required",
+	"sourcerequired":       "source is required",
+	"invalidsource":        "source is invalid",
+	"invalidtarget":        "target is invalid",
+	"invalidrole":          "refers to an unknown role",
+	"invalidusername":      "refers to an unknown user",
+	"roleoruser":           "must specify either a role or a user",
+	"invalidcompositetask": "is not allowed on a parallel, each or subjob task",
+	"paralleloreach":       "can't be used together with parallel/each",
+	"parallelorsubjob":     "can't be used together with parallel/subjob",
+	"eachorsubjob":         "can't be used together with each/subjob",
+}
+
+// FormatValidationError converts the errors returned by Job.Validate
+// into a single error that refers to the offending fields by their
+// path in the submitted job definition (e.g. tasks[0].image).
+// Errors of any other type are returned as-is.
+func FormatValidationError(err error) error {
+	var verrs validator.ValidationErrors
+	if !errors.As(err, &verrs) {
+		return err
+	}
+	msgs := make([]string, len(verrs))
+	for i, fe := range verrs {
+		msgs[i] = fmt.Sprintf("%s %s", fieldPath(fe.StructNamespace()), validationMessage(fe))
+	}
+	return errors.New(strings.Join(msgs, "; "))
+}
+
+func validationMessage(fe validator.FieldError) string {
+	switch fe.Tag() {
+	case "min":
+		if fe.Kind() == reflect.Slice || fe.Kind() == reflect.Map {
+			return fmt.Sprintf("must have at least %s item(s)", fe.Param())
+		}
+		return fmt.Sprintf("must be at least %s", fe.Param())
+	case "max":
+		if fe.Kind() == reflect.String {
+			return fmt.Sprintf("must be at most %s characters long", fe.Param())
+		}
+		return fmt.Sprintf("must be at most %s", fe.Param())
+	case "oneof":
+		return fmt.Sprintf("must be one of: %s", strings.ReplaceAll(fe.Param(), " ", ", "))
+	}
+	if msg, ok := validationMessages[fe.Tag()]; ok {
+		return msg
+	}
+	return fmt.Sprintf("failed the %s validation", fe.Tag())
+}
+
+// fieldPath maps a validator namespace (e.g. Job.Tasks[0].Image)
+// to the field names used in job definitions (e.g. tasks[0].image)
+func fieldPath(ns string) string {
+	parts := strings.Split(ns, ".")
+	t := reflect.TypeOf(Job{})
+	if len(parts) > 0 {
+		if parts[0] == "ScheduledJob" {
+			t = reflect.TypeOf(ScheduledJob{})
+		}
+		// drop the root struct name
+		parts = parts[1:]
+	}
+	path := make([]string, 0, len(parts))
+	for _, part := range parts {
+		name, index := part, ""
+		if ix := strings.Index(part, "["); ix != -1 {
+			name, index = part[:ix], part[ix:]
+		}
+		for t != nil && (t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice || t.Kind() == reflect.Map) {
+			t = t.Elem()
+		}
+		var f reflect.StructField
+		var ok bool
+		if t != nil && t.Kind() == reflect.Struct {
+			f, ok = t.FieldByName(name)
+		}
+		if !ok {
+			path = append(path, strings.ToLower(name)+index)
+			t = nil
+			continue
+		}
+		// embedded fields are inlined in job definitions
+		if f.Anonymous {
+			t = f.Type
+			continue
+		}
+		tag := strings.Split(f.Tag.Get("json"), ",")[0]
+		if tag == "" {
+			tag = strings.ToLower(name)
+		}
+		path = append(path, tag+index)
+		t = f.Type
+	}
+	return strings.Join(path, ".")
+}
@@ -0,0 +1,86 @@
+package input
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/runabol/tork/datastore/inmemory"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFormatValidationError(t *testing.T) {
+	j := Job{
+		Name: "test job",
+		Tasks: []Task{
+			{
+				Name: "some task",
+			},
+			{
+				Name:    "other task",
+				Image:   "some:image",
+				Timeout: "1234",
+			},
+		},
+	}
+	err := j.Validate(inmemory.NewInMemoryDatastore())
+	assert.Error(t, err)
+	err = FormatValidationError(err)
+	assert.Contains(t, err.Error(), "tasks[1].timeout is not a valid duration")
+}
+
+func TestFormatValidationErrorNested(t *testing.T) {
+	j := Job{
+		Name: "test job",
+		Tasks: []Task{
+			{
+				Name:  "some task",
+				Image: "some:image",
+				Limits: &Limits{
+					CPUs: "abc",
+				},
+			},
+		},
+	}
+	err := j.Validate(inmemory.NewInMemoryDatastore())
+	assert.Error(t, err)
+	err = FormatValidationError(err)
+	assert.Equal(t, "tasks[0].limits.cpus is not a valid cpus value (e.g. 1, 0.5)", err.Error())
+}
+
+func TestFormatValidationErrorNoTasks(t *testing.T) {
+	j := Job{
+		Name: "test job",
+	}
+	err := j.Validate(inmemory.NewInMemoryDatastore())
+	assert.Error(t, err)
+	err = FormatValidationError(err)
+	assert.Equal(t, "tasks is required", err.Error())
+}
+
+func TestFormatValidationErrorOther(t *testing.T) {
+	err := errors.New("something else")
+	assert.Equal(t, err, FormatValidationError(err))
+}
+
+func TestFormatValidationErrorScheduledJob(t *testing.T) {
+	sj := ScheduledJob{
+		Job: Job{
+			Name: "test job",
+			Tasks: []Task{
+				{
+					Name:    "some task",
+					Image:   "some:image",
+					Timeout: "1234",
+				},
+			},
+		},
+		Cron:    "not a cron",
+		Overlap: "sometimes",
+	}
+	err := sj.Validate(inmemory.NewInMemoryDatastore())
+	assert.Error(t, err)
+	err = FormatValidationError(err)
+	assert.Contains(t, err.Error(), "tasks[0].timeout is not a valid duration")
+	assert.Contains(t, err.Error(), "cron is not a valid cron expression")
+	assert.Contains(t, err.Error(), "overlap must be one of: skip, queue, replace")
+}
@@ -12,6 +12,7 @@ type Job struct {
 	id          string
 	Name        string            `json:"name,omitempty" yaml:"name,omitempty" validate:"required"`
 	Description string            `json:"description,omitempty" yaml:"description,omitempty"`
+	Namespace   string            `json:"namespace,omitempty" yaml:"namespace,omitempty" validate:"namespace"`
 	Tags        []string          `json:"tags,omitempty" yaml:"tags,omitempty"`
 	Tasks       []Task            `json:"tasks,omitempty" yaml:"tasks,omitempty" validate:"required,min=1,dive"`
 	Inputs      map[string]string `json:"inputs,omitempty" yaml:"inputs,omitempty"`
@@ -21,6 +22,18 @@ type Job struct {
 	Webhooks    []Webhook         `json:"webhooks,omitempty" yaml:"webhooks,omitempty" validate:"dive"`
 	Permissions []Permission      `json:"permissions,omitempty" yaml:"permissions,omitempty" validate:"dive"`
 	AutoDelete  *AutoDelete       `json:"autoDelete,omitempty" yaml:"autoDelete,omitempty"`
+	Timeout     string            `json:"timeout,omitempty" yaml:"timeout,omitempty" validate:"duration"`
+	// IdempotencyKey is supplied out of band (i.e. the
+	// Idempotency-Key header) rather than in the job definition.
+	IdempotencyKey string `json:"-" yaml:"-" validate:"max=256"`
+}
+
+// ScheduledJob is a job definition that is
+// triggered according to a cron expression.
+type ScheduledJob struct {
+	Job     `yaml:",inline"`
+	Cron    string `json:"cron,omitempty" yaml:"cron,omitempty" validate:"required,cron"`
+	Overlap string `json:"overlap,omitempty" yaml:"overlap,omitempty" validate:"omitempty,oneof=skip queue replace"`
 }
 
 type Defaults struct {
@@ -39,6 +52,7 @@ type Webhook struct {
 	URL     string            `json:"url,omitempty" yaml:"url,omitempty" validate:"required"`
 	Headers map[string]string `json:"headers,omitempty" yaml:"headers,omitempty"`
 	Event   string            `json:"event,omitempty" yaml:"event,omitempty"`
+	Secret  string            `json:"secret,omitempty" yaml:"secret,omitempty"`
 }
 
 type Permission struct {
@@ -58,6 +72,7 @@ func (ji *Job) ToJob() *tork.Job {
 	j := &tork.Job{}
 	j.ID = ji.ID()
 	j.Description = ji.Description
+	j.Namespace = ji.Namespace
 	j.Inputs = ji.Inputs
 	j.Secrets = ji.Secrets
 	j.Tags = ji.Tags
@@ -78,6 +93,8 @@ func (ji *Job) ToJob() *tork.Job {
 	}
 	j.TaskCount = len(tasks)
 	j.Output = ji.Output
+	j.Timeout = ji.Timeout
+	j.IdempotencyKey = ji.IdempotencyKey
 	if ji.Defaults != nil {
 		j.Defaults = ji.Defaults.ToJobDefaults()
 	}
@@ -99,6 +116,23 @@ func (ji *Job) ToJob() *tork.Job {
 	return j
 }
 
+func (sji *ScheduledJob) ToScheduledJob() *tork.ScheduledJob {
+	overlap := tork.ScheduledJobOverlap(sji.Overlap)
+	if overlap == "" {
+		overlap = tork.ScheduledJobOverlapSkip
+	}
+	return &tork.ScheduledJob{
+		ID:          uuid.NewUUID(),
+		Name:        sji.Name,
+		Description: sji.Description,
+		Cron:        sji.Cron,
+		Overlap:     overlap,
+		State:       tork.ScheduledJobStateActive,
+		Template:    sji.Job.ToJob(),
+		CreatedAt:   time.Now().UTC(),
+	}
+}
+
 func (d Defaults) ToJobDefaults() *tork.JobDefaults {
 	jd := tork.JobDefaults{}
 	if d.Retry != nil {
@@ -118,6 +152,7 @@ func (w Webhook) toWebhook() *tork.Webhook {
 		URL:     w.URL,
 		Headers: maps.Clone(w.Headers),
 		Event:   w.Event,
+		Secret:  w.Secret,
 	}
 }
 
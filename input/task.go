@@ -12,6 +12,7 @@ type Task struct {
 	Entrypoint  []string          `json:"entrypoint,omitempty" yaml:"entrypoint,omitempty"`
 	Run         string            `json:"run,omitempty" yaml:"run,omitempty"`
 	Image       string            `json:"image,omitempty" yaml:"image,omitempty"`
+	User        string            `json:"user,omitempty" yaml:"user,omitempty" validate:"max=64"`
 	Registry    *Registry         `json:"registry,omitempty" yaml:"registry,omitempty"`
 	Env         map[string]string `json:"env,omitempty" yaml:"env,omitempty"`
 	Files       map[string]string `json:"files,omitempty" yaml:"files,omitempty"`
@@ -22,6 +23,7 @@ type Task struct {
 	Networks    []string          `json:"networks,omitempty" yaml:"networks,omitempty"`
 	Retry       *Retry            `json:"retry,omitempty" yaml:"retry,omitempty"`
 	Limits      *Limits           `json:"limits,omitempty" yaml:"limits,omitempty"`
+	Security    *Security         `json:"security,omitempty" yaml:"security,omitempty"`
 	Timeout     string            `json:"timeout,omitempty" yaml:"timeout,omitempty" validate:"duration"`
 	Var         string            `json:"var,omitempty" yaml:"var,omitempty" validate:"max=64"`
 	If          string            `json:"if,omitempty" yaml:"if,omitempty" validate:"expr"`
@@ -29,10 +31,29 @@ type Task struct {
 	Each        *Each             `json:"each,omitempty" yaml:"each,omitempty"`
 	SubJob      *SubJob           `json:"subjob,omitempty" yaml:"subjob,omitempty"`
 	GPUs        string            `json:"gpus,omitempty" yaml:"gpus,omitempty"`
+	ShmSize     string            `json:"shmSize,omitempty" yaml:"shmSize,omitempty" validate:"memory"`
+	Ulimits     []string          `json:"ulimits,omitempty" yaml:"ulimits,omitempty" validate:"dive,ulimit"`
+	Devices     []string          `json:"devices,omitempty" yaml:"devices,omitempty" validate:"dive,startswith=/"`
+	Platform    string            `json:"platform,omitempty" yaml:"platform,omitempty" validate:"platform"`
+	Selector    map[string]string `json:"selector,omitempty" yaml:"selector,omitempty" validate:"dive,keys,required,max=64,endkeys,max=256"`
 	Tags        []string          `json:"tags,omitempty" yaml:"tags,omitempty"`
 	Workdir     string            `json:"workdir,omitempty" yaml:"workdir,omitempty" validate:"max=256"`
 	Priority    int               `json:"priority,omitempty" yaml:"priority,omitempty" validate:"min=0,max=9"`
 	Ports       []Port            `json:"ports,omitempty" yaml:"ports,omitempty" validate:"dive"`
+	Artifacts   []Artifact        `json:"artifacts,omitempty" yaml:"artifacts,omitempty" validate:"dive"`
+	Downloads   []Download        `json:"downloads,omitempty" yaml:"downloads,omitempty" validate:"dive"`
+	Service     *Service          `json:"service,omitempty" yaml:"service,omitempty"`
+}
+
+type Service struct {
+	Probe *Probe `json:"probe,omitempty" yaml:"probe,omitempty"`
+}
+
+type Probe struct {
+	Port    string   `json:"port,omitempty" yaml:"port,omitempty"`
+	Path    string   `json:"path,omitempty" yaml:"path,omitempty"`
+	CMD     []string `json:"cmd,omitempty" yaml:"cmd,omitempty"`
+	Timeout string   `json:"timeout,omitempty" yaml:"timeout,omitempty" validate:"duration"`
 }
 
 type SubJob struct {
@@ -50,6 +71,9 @@ type Each struct {
 	Var  string `json:"var,omitempty" yaml:"var,omitempty" `
 	List string `json:"list,omitempty" yaml:"list,omitempty" validate:"required,expr"`
 	Task Task   `json:"task,omitempty" yaml:"task,omitempty" validate:"required"`
+	// Concurrency limits the number of items that are
+	// executed at the same time. 0 means no limit.
+	Concurrency int `json:"concurrency,omitempty" yaml:"concurrency,omitempty" validate:"min=0,max=99999"`
 }
 
 type Parallel struct {
@@ -57,12 +81,24 @@ type Parallel struct {
 }
 
 type Retry struct {
-	Limit int `json:"limit,omitempty" yaml:"limit,omitempty" validate:"required,min=1,max=10"`
+	Limit         int     `json:"limit,omitempty" yaml:"limit,omitempty" validate:"required,min=1,max=10"`
+	InitialDelay  string  `json:"initialDelay,omitempty" yaml:"initialDelay,omitempty" validate:"duration"`
+	ScalingFactor float64 `json:"scalingFactor,omitempty" yaml:"scalingFactor,omitempty" validate:"omitempty,min=1,max=10"`
+	MaxDelay      string  `json:"maxDelay,omitempty" yaml:"maxDelay,omitempty" validate:"duration"`
 }
 
 type Limits struct {
-	CPUs   string `json:"cpus,omitempty" yaml:"cpus,omitempty"`
-	Memory string `json:"memory,omitempty" yaml:"memory,omitempty"`
+	CPUs      string `json:"cpus,omitempty" yaml:"cpus,omitempty" validate:"cpus"`
+	CPUShares int64  `json:"cpuShares,omitempty" yaml:"cpuShares,omitempty" validate:"omitempty,min=2,max=262144"`
+	Memory    string `json:"memory,omitempty" yaml:"memory,omitempty" validate:"memory"`
+	Output    string `json:"output,omitempty" yaml:"output,omitempty" validate:"memory"`
+}
+
+type Security struct {
+	CapDrop        []string `json:"capDrop,omitempty" yaml:"capDrop,omitempty"`
+	SecurityOpt    []string `json:"securityOpt,omitempty" yaml:"securityOpt,omitempty"`
+	ReadOnlyRootfs bool     `json:"readOnlyRootfs,omitempty" yaml:"readOnlyRootfs,omitempty"`
+	Privileged     bool     `json:"privileged,omitempty" yaml:"privileged,omitempty"`
 }
 
 type Registry struct {
@@ -92,6 +128,15 @@ type Port struct {
 	Port string `json:"port,omitempty" yaml:"port,omitempty" validate:"required"`
 }
 
+type Artifact struct {
+	Path string `json:"path,omitempty" yaml:"path,omitempty" validate:"required"`
+}
+
+type Download struct {
+	URL  string `json:"url,omitempty" yaml:"url,omitempty" validate:"required"`
+	Path string `json:"path,omitempty" yaml:"path,omitempty" validate:"required"`
+}
+
 func (m Mount) toMount() tork.Mount {
 	return tork.Mount{
 		Type:   m.Type,
@@ -135,9 +180,10 @@ func (i Task) toTask() *tork.Task {
 	var each *tork.EachTask
 	if i.Each != nil {
 		each = &tork.EachTask{
-			Var:  i.Each.Var,
-			List: i.Each.List,
-			Task: i.Each.Task.toTask(),
+			Var:         i.Each.Var,
+			List:        i.Each.List,
+			Task:        i.Each.Task.toTask(),
+			Concurrency: i.Each.Concurrency,
 		}
 	}
 	var subjob *tork.SubJobTask
@@ -169,12 +215,46 @@ func (i Task) toTask() *tork.Task {
 			Password: i.Registry.Password,
 		}
 	}
+	var artifacts []*tork.Artifact
+	for _, a := range i.Artifacts {
+		artifacts = append(artifacts, &tork.Artifact{
+			Path: a.Path,
+		})
+	}
+	var downloads []*tork.Artifact
+	for _, d := range i.Downloads {
+		downloads = append(downloads, &tork.Artifact{
+			URL:  d.URL,
+			Path: d.Path,
+		})
+	}
 	ports := make([]*tork.Port, len(i.Ports))
 	for ix, p := range i.Ports {
 		ports[ix] = &tork.Port{
 			Port: p.Port,
 		}
 	}
+	var security *tork.TaskSecurity
+	if i.Security != nil {
+		security = &tork.TaskSecurity{
+			CapDrop:        i.Security.CapDrop,
+			SecurityOpt:    i.Security.SecurityOpt,
+			ReadOnlyRootfs: i.Security.ReadOnlyRootfs,
+			Privileged:     i.Security.Privileged,
+		}
+	}
+	var service *tork.ServiceTask
+	if i.Service != nil {
+		service = &tork.ServiceTask{}
+		if i.Service.Probe != nil {
+			service.Probe = &tork.Probe{
+				Port:    i.Service.Probe.Port,
+				Path:    i.Service.Probe.Path,
+				CMD:     i.Service.Probe.CMD,
+				Timeout: i.Service.Probe.Timeout,
+			}
+		}
+	}
 	return &tork.Task{
 		Name:        i.Name,
 		Description: i.Description,
@@ -182,6 +262,7 @@ func (i Task) toTask() *tork.Task {
 		Entrypoint:  i.Entrypoint,
 		Run:         i.Run,
 		Image:       i.Image,
+		User:        i.User,
 		Registry:    registry,
 		Env:         i.Env,
 		Files:       i.Files,
@@ -192,6 +273,7 @@ func (i Task) toTask() *tork.Task {
 		Networks:    i.Networks,
 		Retry:       retry,
 		Limits:      limits,
+		Security:    security,
 		Timeout:     i.Timeout,
 		Var:         i.Var,
 		If:          i.If,
@@ -199,10 +281,18 @@ func (i Task) toTask() *tork.Task {
 		Each:        each,
 		SubJob:      subjob,
 		GPUs:        i.GPUs,
+		ShmSize:     i.ShmSize,
+		Ulimits:     i.Ulimits,
+		Devices:     i.Devices,
+		Platform:    i.Platform,
+		Selector:    i.Selector,
 		Tags:        i.Tags,
 		Workdir:     i.Workdir,
 		Priority:    i.Priority,
 		Ports:       ports,
+		Artifacts:   artifacts,
+		Downloads:   downloads,
+		Service:     service,
 	}
 }
 
@@ -232,13 +322,18 @@ func toTasks(tis []Task) []*tork.Task {
 
 func (l *Limits) toTaskLimits() *tork.TaskLimits {
 	return &tork.TaskLimits{
-		CPUs:   l.CPUs,
-		Memory: l.Memory,
+		CPUs:      l.CPUs,
+		CPUShares: l.CPUShares,
+		Memory:    l.Memory,
+		Output:    l.Output,
 	}
 }
 
 func (r *Retry) toTaskRetry() *tork.TaskRetry {
 	return &tork.TaskRetry{
-		Limit: r.Limit,
+		Limit:         r.Limit,
+		InitialDelay:  r.InitialDelay,
+		ScalingFactor: r.ScalingFactor,
+		MaxDelay:      r.MaxDelay,
 	}
 }
@@ -2,36 +2,79 @@ package input
 
 import (
 	"context"
+	"math/big"
 	"regexp"
+	"slices"
 	"strings"
 	"time"
 
+	"github.com/docker/go-units"
 	"github.com/go-playground/validator/v10"
 	"github.com/runabol/tork"
 	"github.com/runabol/tork/datastore"
+	"github.com/runabol/tork/internal/cron"
 	"github.com/runabol/tork/internal/eval"
 	"github.com/runabol/tork/mq"
 )
 
 var (
-	mountPattern = regexp.MustCompile(`^[-/\.0-9a-zA-Z_/= ]+$`)
+	mountPattern  = regexp.MustCompile(`^[-/\.0-9a-zA-Z_/= ]+$`)
+	volumePattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]+$`)
+	// os/arch[/variant], e.g. linux/arm64 or linux/arm/v7
+	platformPattern = regexp.MustCompile(`^[a-z0-9]+/[a-z0-9_]+(/[a-z0-9]+)?$`)
+	// lowercase letters, digits and dashes, e.g. team-a
+	namespacePattern = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,62}[a-z0-9])?$`)
 )
 
 func (ji Job) Validate(ds datastore.Datastore) error {
+	validate, err := newValidator(ds)
+	if err != nil {
+		return err
+	}
+	return validate.Struct(ji)
+}
+
+func (sj ScheduledJob) Validate(ds datastore.Datastore) error {
+	validate, err := newValidator(ds)
+	if err != nil {
+		return err
+	}
+	return validate.Struct(sj)
+}
+
+func newValidator(ds datastore.Datastore) (*validator.Validate, error) {
 	validate := validator.New()
 	if err := validate.RegisterValidation("duration", validateDuration); err != nil {
-		return err
+		return nil, err
 	}
 	if err := validate.RegisterValidation("queue", validateQueue); err != nil {
-		return err
+		return nil, err
+	}
+	if err := validate.RegisterValidation("namespace", validateNamespace); err != nil {
+		return nil, err
 	}
 	if err := validate.RegisterValidation("expr", validateExpr); err != nil {
-		return err
+		return nil, err
+	}
+	if err := validate.RegisterValidation("cpus", validateCPUs); err != nil {
+		return nil, err
+	}
+	if err := validate.RegisterValidation("memory", validateMemory); err != nil {
+		return nil, err
+	}
+	if err := validate.RegisterValidation("ulimit", validateUlimit); err != nil {
+		return nil, err
+	}
+	if err := validate.RegisterValidation("platform", validatePlatform); err != nil {
+		return nil, err
+	}
+	if err := validate.RegisterValidation("cron", validateCron); err != nil {
+		return nil, err
 	}
 	validate.RegisterStructValidation(validateMount, Mount{})
 	validate.RegisterStructValidation(taskInputValidation, Task{})
 	validate.RegisterStructValidation(validatePermission(ds), Permission{})
-	return validate.Struct(ji)
+	return validate, nil
 }
 
 func validateExpr(fl validator.FieldLevel) bool {
@@ -46,8 +89,8 @@ func validateMount(sl validator.StructLevel) {
 	mnt := sl.Current().Interface().(Mount)
 	if mnt.Type == "" {
 		sl.ReportError(mnt, "mount", "Mount", "typerequired", "")
-	} else if mnt.Type == tork.MountTypeVolume && mnt.Source != "" {
-		sl.ReportError(mnt, "mount", "Mount", "sourcenotempty", "")
+	} else if mnt.Type == tork.MountTypeVolume && mnt.Source != "" && !volumePattern.MatchString(mnt.Source) {
+		sl.ReportError(mnt, "mount", "Mount", "invalidsource", "")
 	} else if mnt.Type == tork.MountTypeVolume && mnt.Target == "" {
 		sl.ReportError(mnt, "mount", "Mount", "targetrequired", "")
 	} else if mnt.Type == tork.MountTypeBind && mnt.Source == "" {
@@ -83,6 +126,15 @@ func validatePermission(ds datastore.Datastore) func(sl validator.StructLevel) {
 	}
 }
 
+func validateCron(fl validator.FieldLevel) bool {
+	v := fl.Field().String()
+	if v == "" {
+		return true
+	}
+	_, err := cron.Parse(v)
+	return err == nil
+}
+
 func validateDuration(fl validator.FieldLevel) bool {
 	v := fl.Field().String()
 	if v == "" {
@@ -92,6 +144,49 @@ func validateDuration(fl validator.FieldLevel) bool {
 	return err == nil
 }
 
+func validateCPUs(fl validator.FieldLevel) bool {
+	v := fl.Field().String()
+	if v == "" {
+		return true
+	}
+	cpus, ok := new(big.Rat).SetString(v)
+	if !ok || cpus.Sign() <= 0 {
+		return false
+	}
+	// the docker runtime expresses CPUs in nano-CPUs
+	return cpus.Mul(cpus, big.NewRat(1e9, 1)).IsInt()
+}
+
+func validateMemory(fl validator.FieldLevel) bool {
+	v := fl.Field().String()
+	if v == "" {
+		return true
+	}
+	mem, err := units.RAMInBytes(v)
+	return err == nil && mem > 0
+}
+
+func validateUlimit(fl validator.FieldLevel) bool {
+	_, err := units.ParseUlimit(fl.Field().String())
+	return err == nil
+}
+
+func validatePlatform(fl validator.FieldLevel) bool {
+	v := fl.Field().String()
+	return v == "" || platformPattern.MatchString(v)
+}
+
+// ValidNamespace reports whether the
+// name can be used as a namespace.
+func ValidNamespace(name string) bool {
+	return namespacePattern.MatchString(name)
+}
+
+func validateNamespace(fl validator.FieldLevel) bool {
+	v := fl.Field().String()
+	return v == "" || ValidNamespace(v)
+}
+
 func validateQueue(fl validator.FieldLevel) bool {
 	v := fl.Field().String()
 	if v == "" {
@@ -109,6 +204,7 @@ func validateQueue(fl validator.FieldLevel) bool {
 func taskInputValidation(sl validator.StructLevel) {
 	taskTypeValidation(sl)
 	compositeTaskValidation(sl)
+	serviceTaskValidation(sl)
 }
 
 func taskTypeValidation(sl validator.StructLevel) {
@@ -148,6 +244,9 @@ func compositeTaskValidation(sl validator.StructLevel) {
 	if t.Run != "" {
 		sl.ReportError(t.Run, "run", "Run", "invalidcompositetask", "")
 	}
+	if t.User != "" {
+		sl.ReportError(t.User, "user", "User", "invalidcompositetask", "")
+	}
 	if len(t.Env) > 0 {
 		sl.ReportError(t.Env, "env", "Env", "invalidcompositetask", "")
 	}
@@ -169,7 +268,43 @@ func compositeTaskValidation(sl validator.StructLevel) {
 	if t.Limits != nil {
 		sl.ReportError(t.Limits, "limits", "Limits", "invalidcompositetask", "")
 	}
+	if t.Security != nil {
+		sl.ReportError(t.Security, "security", "Security", "invalidcompositetask", "")
+	}
+	if t.ShmSize != "" {
+		sl.ReportError(t.ShmSize, "shmSize", "ShmSize", "invalidcompositetask", "")
+	}
+	if len(t.Ulimits) > 0 {
+		sl.ReportError(t.Ulimits, "ulimits", "Ulimits", "invalidcompositetask", "")
+	}
+	if len(t.Devices) > 0 {
+		sl.ReportError(t.Devices, "devices", "Devices", "invalidcompositetask", "")
+	}
+	if t.Platform != "" {
+		sl.ReportError(t.Platform, "platform", "Platform", "invalidcompositetask", "")
+	}
+	if len(t.Selector) > 0 {
+		sl.ReportError(t.Selector, "selector", "Selector", "invalidcompositetask", "")
+	}
 	if t.Timeout != "" {
 		sl.ReportError(t.Timeout, "timeout", "Timeout", "invalidcompositetask", "")
 	}
+	if t.Service != nil {
+		sl.ReportError(t.Service, "service", "Service", "invalidcompositetask", "")
+	}
+}
+
+func serviceTaskValidation(sl validator.StructLevel) {
+	t := sl.Current().Interface().(Task)
+	if t.Service == nil || t.Service.Probe == nil {
+		return
+	}
+	p := t.Service.Probe
+	if p.Path != "" && p.Port == "" {
+		sl.ReportError(p.Path, "path", "Path", "probeport", "")
+	}
+	// the probe connects to the service through its published port
+	if p.Port != "" && !slices.ContainsFunc(t.Ports, func(tp Port) bool { return tp.Port == p.Port }) {
+		sl.ReportError(p.Port, "port", "Port", "probeport", "")
+	}
 }
@@ -75,6 +75,27 @@ func TestValidateQueue(t *testing.T) {
 	assert.Error(t, err)
 }
 
+func TestValidateNamespace(t *testing.T) {
+	j := Job{
+		Name:      "test job",
+		Namespace: "team-a",
+		Tasks: []Task{
+			{
+				Name:  "test task",
+				Image: "some:image",
+			},
+		},
+	}
+	err := j.Validate(inmemory.NewInMemoryDatastore())
+	assert.NoError(t, err)
+
+	for _, ns := range []string{"Team-A", "team_a", "-team", strings.Repeat("a", 65)} {
+		j.Namespace = ns
+		err = j.Validate(inmemory.NewInMemoryDatastore())
+		assert.Error(t, err, ns)
+	}
+}
+
 func TestValidateJobNoName(t *testing.T) {
 	j := Job{
 		Name: "test job",
@@ -218,6 +239,93 @@ func TestValidateJobTaskTimeout(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestValidateJobTaskLimits(t *testing.T) {
+	j := Job{
+		Name: "test job",
+		Tasks: []Task{
+			{
+				Name:  "test task",
+				Image: "some:image",
+				Limits: &Limits{
+					CPUs:      ".5",
+					CPUShares: 512,
+					Memory:    "10m",
+				},
+			},
+		},
+	}
+	err := j.Validate(inmemory.NewInMemoryDatastore())
+	assert.NoError(t, err)
+
+	j = Job{
+		Name: "test job",
+		Tasks: []Task{
+			{
+				Name:  "test task",
+				Image: "some:image",
+				Limits: &Limits{
+					CPUs: "abc",
+				},
+			},
+		},
+	}
+	err = j.Validate(inmemory.NewInMemoryDatastore())
+	assert.Error(t, err)
+	errs := err.(validator.ValidationErrors)
+	assert.Equal(t, "CPUs", errs[0].Field())
+
+	j = Job{
+		Name: "test job",
+		Tasks: []Task{
+			{
+				Name:  "test task",
+				Image: "some:image",
+				Limits: &Limits{
+					Memory: "10 parsecs",
+				},
+			},
+		},
+	}
+	err = j.Validate(inmemory.NewInMemoryDatastore())
+	assert.Error(t, err)
+	errs = err.(validator.ValidationErrors)
+	assert.Equal(t, "Memory", errs[0].Field())
+
+	j = Job{
+		Name: "test job",
+		Tasks: []Task{
+			{
+				Name:  "test task",
+				Image: "some:image",
+				Limits: &Limits{
+					CPUShares: 1,
+				},
+			},
+		},
+	}
+	err = j.Validate(inmemory.NewInMemoryDatastore())
+	assert.Error(t, err)
+	errs = err.(validator.ValidationErrors)
+	assert.Equal(t, "CPUShares", errs[0].Field())
+
+	j = Job{
+		Name: "test job",
+		Tasks: []Task{
+			{
+				Name:  "some task",
+				Image: "some:image",
+			},
+		},
+		Defaults: &Defaults{
+			Limits: &Limits{
+				CPUs: "-1",
+			},
+		},
+	}
+	err = j.Validate(inmemory.NewInMemoryDatastore())
+	assert.Error(t, err)
+}
+
 func TestValidateSubJob(t *testing.T) {
 	j := Job{
 		Name: "test job",
@@ -493,6 +601,46 @@ func TestValidateMounts(t *testing.T) {
 	err = j.Validate(inmemory.NewInMemoryDatastore())
 	assert.NoError(t, err)
 
+	j = Job{
+		Name: "test job",
+		Tasks: []Task{
+			{
+				Name:  "test task",
+				Image: "some:image",
+				Run:   "some script",
+				Mounts: []Mount{
+					{
+						Type:   tork.MountTypeVolume,
+						Source: "shared-data",
+						Target: "/some/target",
+					},
+				},
+			},
+		},
+	}
+	err = j.Validate(inmemory.NewInMemoryDatastore())
+	assert.NoError(t, err)
+
+	j = Job{
+		Name: "test job",
+		Tasks: []Task{
+			{
+				Name:  "test task",
+				Image: "some:image",
+				Run:   "some script",
+				Mounts: []Mount{
+					{
+						Type:   tork.MountTypeVolume,
+						Source: "/not/a/volume/name",
+						Target: "/some/target",
+					},
+				},
+			},
+		},
+	}
+	err = j.Validate(inmemory.NewInMemoryDatastore())
+	assert.Error(t, err)
+
 	j = Job{
 		Name: "test job",
 		Tasks: []Task{
@@ -645,3 +793,249 @@ func TestValidateWebhook(t *testing.T) {
 	err = j.Validate(inmemory.NewInMemoryDatastore())
 	assert.Error(t, err)
 }
+
+func TestValidateJobTaskOutputLimit(t *testing.T) {
+	j := Job{
+		Name: "test job",
+		Tasks: []Task{
+			{
+				Name:   "some task",
+				Image:  "some:image",
+				Limits: &Limits{Output: "1m"},
+			},
+		},
+	}
+	err := j.Validate(inmemory.NewInMemoryDatastore())
+	assert.NoError(t, err)
+
+	j.Tasks[0].Limits.Output = "abc"
+	err = j.Validate(inmemory.NewInMemoryDatastore())
+	assert.Error(t, err)
+}
+
+func TestValidateJobTaskRetryDelay(t *testing.T) {
+	j := Job{
+		Name: "test job",
+		Tasks: []Task{
+			{
+				Name:  "some task",
+				Image: "some:image",
+				Retry: &Retry{Limit: 3, InitialDelay: "5s", ScalingFactor: 2},
+			},
+		},
+	}
+	err := j.Validate(inmemory.NewInMemoryDatastore())
+	assert.NoError(t, err)
+
+	j.Tasks[0].Retry.InitialDelay = "abc"
+	err = j.Validate(inmemory.NewInMemoryDatastore())
+	assert.Error(t, err)
+
+	j.Tasks[0].Retry.InitialDelay = "5s"
+	j.Tasks[0].Retry.ScalingFactor = 0.5
+	err = j.Validate(inmemory.NewInMemoryDatastore())
+	assert.Error(t, err)
+}
+
+func TestValidateJobServiceTask(t *testing.T) {
+	j := Job{
+		Name: "test job",
+		Tasks: []Task{
+			{
+				Name:  "postgres",
+				Image: "postgres:16",
+				Ports: []Port{{Port: "5432"}},
+				Service: &Service{
+					Probe: &Probe{Port: "5432", Timeout: "30s"},
+				},
+			},
+		},
+	}
+	err := j.Validate(inmemory.NewInMemoryDatastore())
+	assert.NoError(t, err)
+
+	// the probe port must be published
+	j.Tasks[0].Service.Probe.Port = "5433"
+	err = j.Validate(inmemory.NewInMemoryDatastore())
+	assert.Error(t, err)
+
+	// an http probe needs a port
+	j.Tasks[0].Service.Probe.Port = ""
+	j.Tasks[0].Service.Probe.Path = "/health"
+	err = j.Validate(inmemory.NewInMemoryDatastore())
+	assert.Error(t, err)
+
+	j.Tasks[0].Service.Probe = &Probe{CMD: []string{"pg_isready"}, Timeout: "abc"}
+	err = j.Validate(inmemory.NewInMemoryDatastore())
+	assert.Error(t, err)
+
+	j.Tasks[0].Service.Probe.Timeout = "1m"
+	err = j.Validate(inmemory.NewInMemoryDatastore())
+	assert.NoError(t, err)
+
+	// composite tasks can't be services
+	j.Tasks[0] = Task{
+		Name:    "parallel",
+		Service: &Service{},
+		Parallel: &Parallel{
+			Tasks: []Task{{Name: "some task", Image: "some:image"}},
+		},
+	}
+	err = j.Validate(inmemory.NewInMemoryDatastore())
+	assert.Error(t, err)
+}
+
+func TestValidateJobTaskUser(t *testing.T) {
+	j := Job{
+		Name: "test job",
+		Tasks: []Task{
+			{
+				Name:  "some task",
+				Image: "some:image",
+				User:  "1000:1000",
+			},
+		},
+	}
+	err := j.Validate(inmemory.NewInMemoryDatastore())
+	assert.NoError(t, err)
+
+	j.Tasks[0].User = strings.Repeat("a", 65)
+	err = j.Validate(inmemory.NewInMemoryDatastore())
+	assert.Error(t, err)
+}
+
+func TestValidateJobTaskSecurity(t *testing.T) {
+	j := Job{
+		Name: "test job",
+		Tasks: []Task{
+			{
+				Name:  "some task",
+				Image: "some:image",
+				Security: &Security{
+					CapDrop:        []string{"ALL"},
+					SecurityOpt:    []string{"no-new-privileges"},
+					ReadOnlyRootfs: true,
+				},
+			},
+		},
+	}
+	err := j.Validate(inmemory.NewInMemoryDatastore())
+	assert.NoError(t, err)
+	tk := j.Tasks[0].toTask()
+	assert.Equal(t, []string{"ALL"}, tk.Security.CapDrop)
+	assert.True(t, tk.Security.ReadOnlyRootfs)
+
+	// composite tasks have no container to secure
+	j.Tasks[0] = Task{
+		Name:     "parallel",
+		Security: &Security{ReadOnlyRootfs: true},
+		Parallel: &Parallel{
+			Tasks: []Task{{Name: "some task", Image: "some:image"}},
+		},
+	}
+	err = j.Validate(inmemory.NewInMemoryDatastore())
+	assert.Error(t, err)
+}
+
+func TestValidateJobTaskShmSize(t *testing.T) {
+	j := Job{
+		Name: "test job",
+		Tasks: []Task{
+			{
+				Name:    "some task",
+				Image:   "some:image",
+				ShmSize: "1g",
+			},
+		},
+	}
+	err := j.Validate(inmemory.NewInMemoryDatastore())
+	assert.NoError(t, err)
+
+	j.Tasks[0].ShmSize = "lots"
+	err = j.Validate(inmemory.NewInMemoryDatastore())
+	assert.Error(t, err)
+}
+
+func TestValidateJobTaskUlimits(t *testing.T) {
+	j := Job{
+		Name: "test job",
+		Tasks: []Task{
+			{
+				Name:    "some task",
+				Image:   "some:image",
+				Ulimits: []string{"nofile=1024:65536", "nproc=4096"},
+			},
+		},
+	}
+	err := j.Validate(inmemory.NewInMemoryDatastore())
+	assert.NoError(t, err)
+
+	j.Tasks[0].Ulimits = []string{"nofile"}
+	err = j.Validate(inmemory.NewInMemoryDatastore())
+	assert.Error(t, err)
+
+	j.Tasks[0].Ulimits = []string{"bogus=1"}
+	err = j.Validate(inmemory.NewInMemoryDatastore())
+	assert.Error(t, err)
+}
+
+func TestValidateJobTaskPlatform(t *testing.T) {
+	j := Job{
+		Name: "test job",
+		Tasks: []Task{
+			{
+				Name:     "some task",
+				Image:    "some:image",
+				Platform: "linux/arm64",
+			},
+		},
+	}
+	err := j.Validate(inmemory.NewInMemoryDatastore())
+	assert.NoError(t, err)
+
+	j.Tasks[0].Platform = "linux/arm/v7"
+	err = j.Validate(inmemory.NewInMemoryDatastore())
+	assert.NoError(t, err)
+
+	j.Tasks[0].Platform = "arm64"
+	err = j.Validate(inmemory.NewInMemoryDatastore())
+	assert.Error(t, err)
+}
+
+func TestValidateJobTaskDevices(t *testing.T) {
+	j := Job{
+		Name: "test job",
+		Tasks: []Task{
+			{
+				Name:    "some task",
+				Image:   "some:image",
+				Devices: []string{"/dev/dri"},
+			},
+		},
+	}
+	err := j.Validate(inmemory.NewInMemoryDatastore())
+	assert.NoError(t, err)
+
+	j.Tasks[0].Devices = []string{"dev/dri"}
+	err = j.Validate(inmemory.NewInMemoryDatastore())
+	assert.Error(t, err)
+}
+
+func TestValidateJobTaskSelector(t *testing.T) {
+	j := Job{
+		Name: "test job",
+		Tasks: []Task{
+			{
+				Name:     "some task",
+				Image:    "some:image",
+				Selector: map[string]string{"region": "eu"},
+			},
+		},
+	}
+	err := j.Validate(inmemory.NewInMemoryDatastore())
+	assert.NoError(t, err)
+
+	j.Tasks[0].Selector = map[string]string{"": "eu"}
+	err = j.Validate(inmemory.NewInMemoryDatastore())
+	assert.Error(t, err)
+}
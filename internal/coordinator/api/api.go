@@ -2,6 +2,8 @@ package api
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -12,24 +14,38 @@ import (
 	"net/http"
 	"net/http/httputil"
 	"net/url"
+	"regexp"
+	"slices"
 	"strings"
 
+	"github.com/docker/go-units"
 	"github.com/labstack/echo/v4"
 	"github.com/pkg/errors"
 	"github.com/rs/zerolog/log"
 
+	"github.com/runabol/tork/artifact"
 	"github.com/runabol/tork/datastore"
+	"github.com/runabol/tork/docs"
 	"github.com/runabol/tork/health"
 
 	"github.com/runabol/tork/input"
+	"github.com/runabol/tork/internal/debug"
 	"github.com/runabol/tork/internal/hash"
 	"github.com/runabol/tork/internal/httpx"
+	"github.com/runabol/tork/internal/metrics"
+	"github.com/runabol/tork/internal/redact"
+	"github.com/runabol/tork/internal/tracing"
+	"github.com/runabol/tork/logstore"
 	"github.com/runabol/tork/middleware/job"
 	"github.com/runabol/tork/middleware/task"
 	"github.com/runabol/tork/middleware/web"
 
 	"github.com/runabol/tork"
 	"github.com/runabol/tork/mq"
+	"github.com/runabol/tork/runtime"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/net/websocket"
 	"gopkg.in/yaml.v3"
 )
 
@@ -37,16 +53,65 @@ const (
 	MIN_PORT          = 8000
 	MAX_PORT          = 8100
 	MAX_LOG_PAGE_SIZE = 100
+	// the default and the maximum number of bytes
+	// of a task's log read at once by offset
+	DEFAULT_LOG_CHUNK_SIZE = 64 * units.KiB
+	MAX_LOG_CHUNK_SIZE     = units.MiB
 )
 
+// how often a job is checked for
+// changes when streaming its events
+var eventsPollInterval = time.Second
+
+// how long an event stream may stay silent before a
+// comment is sent to keep proxies from closing it
+var eventsKeepAliveInterval = time.Second * 15
+
+// how many more polls the log of a finished task is read
+// for the parts which are shipped after it has finished
+const logDrainPolls = 3
+
+// the maximum size of a result fetched from the artifact store
+var maxResultSize int64 = 16 * units.MiB
+
+// ErrIdempotencyKeyReused is returned when a job is submitted with
+// the idempotency key of a different job submitted by the user.
+var ErrIdempotencyKeyReused = errors.New("idempotency key was already used for a different job")
+
+// ErrNamespaceNotFound is returned when a job
+// names a namespace which isn't configured.
+var ErrNamespaceNotFound = errors.New("namespace not found")
+
+// ErrNamespaceForbidden is returned when a job is submitted to a
+// namespace the user submitting it isn't a member of.
+var ErrNamespaceForbidden = errors.New("not a member of the namespace")
+
+// ErrNamespaceQuotaExceeded is returned when a job is submitted to
+// a namespace which has reached its maximum number of active jobs.
+var ErrNamespaceQuotaExceeded = errors.New("the namespace has reached its maximum number of active jobs")
+
 type HealthResponse struct {
 	Status string `json:"status"`
 }
 
+// TaskLogChunk is a chunk of the full log of a task. The whole
+// log is read by requesting the chunk at the next offset until
+// one comes back empty.
+type TaskLogChunk struct {
+	Offset     int64  `json:"offset"`
+	NextOffset int64  `json:"nextOffset"`
+	Contents   string `json:"contents"`
+}
+
 type API struct {
 	server     *http.Server
 	broker     mq.Broker
 	ds         datastore.Datastore
+	artifacts  artifact.Store
+	logs       logstore.Store
+	redacter   *redact.Redacter
+	images     runtime.ImagePolicy
+	namespaces map[string]*tork.Namespace
 	terminate  chan any
 	onReadJob  job.HandlerFunc
 	onReadTask task.HandlerFunc
@@ -59,6 +124,25 @@ type Config struct {
 	Middleware Middleware
 	Endpoints  map[string]web.HandlerFunc
 	Enabled    map[string]bool
+	// Artifacts is used to fetch task results that
+	// were too large to be stored inline.
+	Artifacts artifact.Store
+	// Logs is where the logs of the tasks are
+	// read from. Default: the parts in the datastore
+	Logs logstore.Store
+	// Redacter, when set, masks the secret values in
+	// the results fetched from the artifact store.
+	Redacter *redact.Redacter
+	// ImagePolicy restricts the images
+	// that submitted tasks may use.
+	ImagePolicy runtime.ImagePolicy
+	// Namespaces isolate the jobs of the teams sharing the
+	// deployment. Jobs which don't name a namespace are in
+	// the default one, which is open unless it's configured.
+	Namespaces []*tork.Namespace
+	// Debug exposes the pprof profiles
+	// and the /debug/state endpoint.
+	Debug bool
 }
 
 type Middleware struct {
@@ -90,8 +174,13 @@ func NewAPI(cfg Config) (*API, error) {
 			Addr:    cfg.Address,
 			Handler: r,
 		},
-		ds:        cfg.DataStore,
-		terminate: make(chan any),
+		ds:         cfg.DataStore,
+		artifacts:  cfg.Artifacts,
+		logs:       cfg.Logs,
+		redacter:   cfg.Redacter,
+		images:     cfg.ImagePolicy,
+		namespaces: make(map[string]*tork.Namespace),
+		terminate:  make(chan any),
 		onReadJob: job.ApplyMiddleware(
 			job.NoOpHandlerFunc,
 			cfg.Middleware.Job,
@@ -102,6 +191,14 @@ func NewAPI(cfg Config) (*API, error) {
 		),
 	}
 
+	if s.logs == nil {
+		s.logs = logstore.NewDatastoreStore(cfg.DataStore)
+	}
+
+	for _, ns := range cfg.Namespaces {
+		s.namespaces[ns.Name] = ns
+	}
+
 	// registering custom middleware
 	for _, m := range cfg.Middleware.Web {
 		r.Use(s.middlewareAdapter(m))
@@ -115,13 +212,17 @@ func NewAPI(cfg Config) (*API, error) {
 	// built-in endpoints
 	if v, ok := cfg.Enabled["health"]; !ok || v {
 		r.GET("/health", s.health)
+		r.GET("/health/live", s.live)
+		r.GET("/health/ready", s.health)
 	}
 	if v, ok := cfg.Enabled["tasks"]; !ok || v {
-		r.GET("/tasks/:id", s.getTask)
-		r.GET("/tasks/:id/log", s.getTaskLog)
-		r.Any("/tasks/:id/proxy/:port", s.proxy)
-		r.Any("/tasks/:id/proxy/:port/*", s.proxy)
-		r.PUT("/tasks/:id/complete", s.completeTask)
+		inNamespace := s.inNamespace(s.taskNamespace)
+		r.GET("/tasks/:id", s.getTask, inNamespace)
+		r.GET("/tasks/:id/log", s.getTaskLog, inNamespace)
+		r.GET("/tasks/:id/log/ws", s.tailTaskLog, inNamespace)
+		r.Any("/tasks/:id/proxy/:port", s.proxy, inNamespace)
+		r.Any("/tasks/:id/proxy/:port/*", s.proxy, inNamespace)
+		r.PUT("/tasks/:id/complete", s.completeTask, inNamespace)
 	}
 	if v, ok := cfg.Enabled["queues"]; !ok || v {
 		r.GET("/queues", s.listQueues)
@@ -130,18 +231,52 @@ func NewAPI(cfg Config) (*API, error) {
 		r.GET("/nodes", s.listActiveNodes)
 	}
 	if v, ok := cfg.Enabled["jobs"]; !ok || v {
+		inNamespace := s.inNamespace(s.jobNamespace)
 		r.POST("/jobs", s.createJob)
-		r.GET("/jobs/:id", s.getJob)
-		r.GET("/jobs/:id/log", s.getJobLog)
+		r.GET("/jobs/:id", s.getJob, inNamespace)
+		r.GET("/jobs/:id/log", s.getJobLog, inNamespace)
+		r.GET("/jobs/:id/events", s.streamJobEvents, inNamespace)
 		r.GET("/jobs", s.listJobs)
-		r.PUT("/jobs/:id/cancel", s.cancelJob)
-		r.PUT("/jobs/:id/restart", s.restartJob)
+		r.PUT("/jobs/:id/cancel", s.cancelJob, inNamespace)
+		r.PUT("/jobs/:id/restart", s.restartJob, inNamespace)
+		inScheduledNamespace := s.inNamespace(s.scheduledJobNamespace)
+		r.POST("/scheduled-jobs", s.createScheduledJob)
+		r.GET("/scheduled-jobs", s.listScheduledJobs)
+		r.GET("/scheduled-jobs/:id", s.getScheduledJob, inScheduledNamespace)
+		r.PUT("/scheduled-jobs/:id/pause", s.pauseScheduledJob, inScheduledNamespace)
+		r.PUT("/scheduled-jobs/:id/resume", s.resumeScheduledJob, inScheduledNamespace)
+		r.DELETE("/scheduled-jobs/:id", s.deleteScheduledJob, inScheduledNamespace)
 	}
 	if v, ok := cfg.Enabled["metrics"]; !ok || v {
 		r.GET("/metrics", s.getMetrics)
 	}
+	if cfg.Debug {
+		debug.RegisterPprof(r)
+		r.GET("/debug/state", s.getDebugState)
+	}
 	if v, ok := cfg.Enabled["users"]; !ok || v {
 		r.POST("/users", s.createUser)
+		r.PUT("/users/:username/roles/:role", s.assignRole)
+		r.DELETE("/users/:username/roles/:role", s.unassignRole)
+	}
+	if v, ok := cfg.Enabled["keys"]; !ok || v {
+		r.POST("/keys", s.createAPIKey)
+		r.GET("/keys", s.listAPIKeys)
+		r.DELETE("/keys/:id", s.deleteAPIKey)
+	}
+	if v, ok := cfg.Enabled["secrets"]; !ok || v {
+		r.GET("/secrets", s.listSecrets)
+		r.PUT("/secrets/:name", s.setSecret)
+		r.DELETE("/secrets/:name", s.deleteSecret)
+	}
+	if v, ok := cfg.Enabled["dead-letters"]; !ok || v {
+		r.GET("/dead-letters", s.listDeadLetters)
+		r.GET("/dead-letters/:id", s.getDeadLetter)
+		r.PUT("/dead-letters/:id/requeue", s.requeueDeadLetter)
+		r.DELETE("/dead-letters/:id", s.deleteDeadLetter)
+	}
+	if v, ok := cfg.Enabled["docs"]; !ok || v {
+		r.GET("/docs/openapi.json", s.getOpenAPIDoc)
 	}
 
 	// register additional custom endpoints
@@ -194,13 +329,25 @@ func (s *API) middlewareAdapter(m web.MiddlewareFunc) echo.MiddlewareFunc {
 	}
 }
 
+// getOpenAPIDoc serves the API's OpenAPI document
+// @Summary Get the API's OpenAPI 3 document
+// @Tags management
+// @Produce application/json
+// @Success 200 {object} object
+// @Router /docs/openapi.json [get]
+func (s *API) getOpenAPIDoc(c echo.Context) error {
+	return c.Blob(http.StatusOK, echo.MIMEApplicationJSON, docs.OpenAPIJSON)
+}
+
 // health
 // @Summary Shows application health information.
-// @Description get the status of server.
+// @Description get the status of server, which is DOWN when the datastore or the broker can't be reached.
 // @Tags management
 // @Produce json
 // @Success 200 {object} HealthResponse
+// @Failure 503 {object} HealthResponse
 // @Router /health [get]
+// @Router /health/ready [get]
 func (s *API) health(c echo.Context) error {
 	result := health.NewHealthCheck().
 		WithIndicator(health.ServiceDatastore, s.ds.HealthCheck).
@@ -213,6 +360,17 @@ func (s *API) health(c echo.Context) error {
 	}
 }
 
+// live
+// @Summary Shows whether the server is up.
+// @Description unlike /health/ready it doesn't check the datastore or the broker.
+// @Tags management
+// @Produce json
+// @Success 200 {object} HealthResponse
+// @Router /health/live [get]
+func (s *API) live(c echo.Context) error {
+	return c.JSON(http.StatusOK, health.NewHealthCheck().Do(c.Request().Context()))
+}
+
 // listQueues
 // @Summary get a list of queues
 // @Tags queues
@@ -247,51 +405,102 @@ func (s *API) listActiveNodes(c echo.Context) error {
 // @Accept json
 // @Produce json
 // @Success 200 {object} tork.JobSummary
+// @Failure 400 {object} echo.HTTPError
+// @Failure 403 {object} echo.HTTPError "not a member of the job's namespace"
+// @Failure 422 {object} echo.HTTPError "the idempotency key was used for a different job"
+// @Failure 429 {object} echo.HTTPError "the job's namespace has reached its maximum number of active jobs"
 // @Router /jobs [post]
 // @Param request body input.Job true "body"
+// @Param Idempotency-Key header string false "resubmitting the same job with the same key returns the existing job"
 func (s *API) createJob(c echo.Context) error {
 	var ji *input.Job
 	var err error
 	contentType := c.Request().Header.Get("content-type")
 	switch contentType {
 	case "application/json":
-		ji, err = bindJobInputJSON(c.Request().Body)
+		ji, err = bindInputJSON[input.Job](c.Request().Body)
 		if err != nil {
 			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
 		}
 	case "text/yaml":
-		ji, err = bindJobInputYAML(c.Request().Body)
+		ji, err = bindInputYAML[input.Job](c.Request().Body)
 		if err != nil {
 			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
 		}
 	default:
 		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("unknown content type: %s", contentType))
 	}
-	if j, err := s.SubmitJob(c.Request().Context(), ji); err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	ji.IdempotencyKey = c.Request().Header.Get("Idempotency-Key")
+	if j, err := s.SubmitJob(c.Request().Context(), ji); errors.Is(err, ErrIdempotencyKeyReused) {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, err.Error())
+	} else if errors.Is(err, ErrNamespaceForbidden) {
+		return echo.NewHTTPError(http.StatusForbidden, err.Error())
+	} else if errors.Is(err, ErrNamespaceQuotaExceeded) {
+		return echo.NewHTTPError(http.StatusTooManyRequests, err.Error())
+	} else if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, input.FormatValidationError(err).Error())
 	} else {
 		return c.JSON(http.StatusOK, tork.NewJobSummary(j))
 	}
 }
 
-func (s *API) SubmitJob(ctx context.Context, ji *input.Job) (*tork.Job, error) {
+func (s *API) SubmitJob(ctx context.Context, ji *input.Job) (_ *tork.Job, err error) {
 	if err := ji.Validate(s.ds); err != nil {
 		return nil, err
 	}
-	j := ji.ToJob()
-	currentUser := ctx.Value(tork.USERNAME)
-	if currentUser != nil {
+	if err := checkImages(s.images, ji.Tasks); err != nil {
+		return nil, err
+	}
+	username := tork.USER_GUEST
+	if currentUser := ctx.Value(tork.USERNAME); currentUser != nil {
 		cu, ok := currentUser.(string)
 		if !ok {
 			return nil, errors.Errorf("error casting current user")
 		}
-		u, err := s.ds.GetUser(ctx, cu)
+		username = cu
+	}
+	u, err := s.ds.GetUser(ctx, username)
+	if err != nil {
+		return nil, err
+	}
+	// a retried submission returns the job
+	// created by the original one
+	var idempotencyHash string
+	if ji.IdempotencyKey != "" {
+		body, err := json.Marshal(ji)
 		if err != nil {
+			return nil, errors.Wrapf(err, "error serializing the job")
+		}
+		idempotencyHash = hash.Key(string(body))
+		if existing, err := s.idempotentJob(ctx, u, ji.IdempotencyKey, idempotencyHash); err == nil {
+			return existing, nil
+		} else if !errors.Is(err, datastore.ErrJobNotFound) {
 			return nil, err
 		}
-		j.CreatedBy = u
 	}
+	j := ji.ToJob()
+	ns, err := s.admitToNamespace(ctx, j)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.checkNamespaceQuota(ctx, ns); err != nil {
+		return nil, err
+	}
+	j.IdempotencyHash = idempotencyHash
+	ctx, span := tracing.Start(ctx, "tork.job.submit", trace.WithAttributes(attribute.String("job.id", j.ID)))
+	defer func() { tracing.End(span, err) }()
+	j.Trace = tracing.Inject(ctx)
+	j.CreatedBy = u
 	if err := s.ds.CreateJob(ctx, j); err != nil {
+		// lost a race against a concurrent
+		// submission with the same key
+		if j.IdempotencyKey != "" {
+			if existing, err := s.idempotentJob(ctx, u, j.IdempotencyKey, idempotencyHash); err == nil {
+				return existing, nil
+			} else if !errors.Is(err, datastore.ErrJobNotFound) {
+				return nil, err
+			}
+		}
 		return nil, err
 	}
 	log.Info().Str("job-id", j.ID).Msg("created job")
@@ -301,8 +510,67 @@ func (s *API) SubmitJob(ctx context.Context, ji *input.Job) (*tork.Job, error) {
 	return j, nil
 }
 
-func bindJobInputJSON(r io.ReadCloser) (*input.Job, error) {
-	ji := input.Job{}
+// idempotentJob returns the job the user previously submitted
+// with the key. The key can't be reused for a different job.
+func (s *API) idempotentJob(ctx context.Context, u *tork.User, key, h string) (*tork.Job, error) {
+	j, err := s.ds.GetJobByIdempotencyKey(ctx, u.ID, key)
+	if err != nil {
+		return nil, err
+	}
+	if j.IdempotencyHash != h {
+		return nil, ErrIdempotencyKeyReused
+	}
+	ok, err := s.canReadJob(ctx, j)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrIdempotencyKeyReused
+	}
+	return j, nil
+}
+
+// checkImages verifies that the policy allows the images of
+// the tasks. Images that are expressions are evaluated later
+// and are left to the runtime to enforce.
+func checkImages(p runtime.ImagePolicy, tasks []input.Task) error {
+	for _, t := range tasks {
+		images := []string{t.Image}
+		for _, aux := range t.Pre {
+			images = append(images, aux.Image)
+		}
+		for _, aux := range t.Post {
+			images = append(images, aux.Image)
+		}
+		for _, image := range images {
+			if image == "" || strings.Contains(image, "{{") {
+				continue
+			}
+			if err := p.Check(image); err != nil {
+				return err
+			}
+		}
+		if t.Parallel != nil {
+			if err := checkImages(p, t.Parallel.Tasks); err != nil {
+				return err
+			}
+		}
+		if t.Each != nil {
+			if err := checkImages(p, []input.Task{t.Each.Task}); err != nil {
+				return err
+			}
+		}
+		if t.SubJob != nil {
+			if err := checkImages(p, t.SubJob.Tasks); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func bindInputJSON[T any](r io.ReadCloser) (*T, error) {
+	var ji T
 	body, err := io.ReadAll(r)
 	if err != nil {
 		return nil, err
@@ -315,8 +583,8 @@ func bindJobInputJSON(r io.ReadCloser) (*input.Job, error) {
 	return &ji, nil
 }
 
-func bindJobInputYAML(r io.ReadCloser) (*input.Job, error) {
-	ji := input.Job{}
+func bindInputYAML[T any](r io.ReadCloser) (*T, error) {
+	var ji T
 	body, err := io.ReadAll(r)
 	if err != nil {
 		return nil, err
@@ -385,24 +653,154 @@ func (s *API) getJobLog(c echo.Context) error {
 	} else if size > MAX_LOG_PAGE_SIZE {
 		size = MAX_LOG_PAGE_SIZE
 	}
-	_, err = s.ds.GetJobByID(c.Request().Context(), id)
+	j, err := s.ds.GetJobByID(c.Request().Context(), id)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusNotFound, err.Error())
 	}
-	l, err := s.ds.GetJobLogParts(c.Request().Context(), id, page, size)
+	l, err := s.logs.JobParts(c.Request().Context(), j, page, size)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusNotFound, err.Error())
 	}
 	return c.JSON(http.StatusOK, l)
 }
 
+// streamJobEvents
+// @Summary Stream a job's state changes and log as Server-Sent Events
+// @Description The log parts of the job's tasks are sent as log events and the job's
+// @Description summary as job events. A comment is sent when the stream is idle.
+// @Tags jobs
+// @Produce text/event-stream
+// @Success 200
+// @Router /jobs/{id}/events [get]
+// @Param id path string true "Job ID"
+func (s *API) streamJobEvents(c echo.Context) error {
+	ctx := c.Request().Context()
+	id := c.Param("id")
+	j, err := s.ds.GetJobByID(ctx, id)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+	if err := s.onReadJob(ctx, job.Read, j); err != nil {
+		return err
+	}
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, "text/event-stream")
+	res.Header().Set(echo.HeaderCacheControl, "no-cache")
+	res.Header().Set(echo.HeaderConnection, "keep-alive")
+	res.WriteHeader(http.StatusOK)
+	lastWrite := time.Now()
+	write := func(format string, args ...any) error {
+		if _, err := fmt.Fprintf(res, format, args...); err != nil {
+			return err
+		}
+		res.Flush()
+		lastWrite = time.Now()
+		return nil
+	}
+	send := func(event string, v any) error {
+		data, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		return write("event: %s\ndata: %s\n\n", event, data)
+	}
+	// the last log part number sent for each task
+	sent := make(map[string]int)
+	// the number of polls since each task has finished
+	finished := make(map[string]int)
+	// the number of polls since the job has finished
+	var drained int
+	var last *tork.JobSummary
+	for {
+		var parts []*tork.TaskLogPart
+		if last == nil {
+			parts, err = s.jobLogParts(ctx, j)
+			if err != nil {
+				return err
+			}
+		} else {
+			// every task of the job is followed, including the ones
+			// which started and finished since the previous poll
+			for _, t := range j.Execution {
+				if t.State == tork.TaskStateCreated ||
+					t.State == tork.TaskStatePending ||
+					finished[t.ID] > logDrainPolls {
+					continue
+				}
+				tparts, err := s.newTaskLogParts(ctx, t.ID, sent[t.ID])
+				if err != nil {
+					return err
+				}
+				parts = append(parts, tparts...)
+			}
+		}
+		for _, p := range parts {
+			if p.Number <= sent[p.TaskID] {
+				continue
+			}
+			if err := send("log", p); err != nil {
+				return err
+			}
+			sent[p.TaskID] = p.Number
+		}
+		for _, t := range j.Execution {
+			if t.State != tork.TaskStateCreated && !t.State.IsActive() {
+				finished[t.ID] = finished[t.ID] + 1
+			}
+		}
+		js := tork.NewJobSummary(j)
+		if last == nil || last.State != js.State || last.Position != js.Position || last.Progress != js.Progress {
+			if err := send("job", js); err != nil {
+				return err
+			}
+			last = js
+		}
+		if j.State == tork.JobStateCompleted || j.State == tork.JobStateFailed || j.State == tork.JobStateCancelled {
+			// the stream is closed once the last
+			// log parts of the job have been sent
+			if drained >= logDrainPolls {
+				return nil
+			}
+			drained = drained + 1
+		}
+		if time.Since(lastWrite) >= eventsKeepAliveInterval {
+			if err := write(": keepalive\n\n"); err != nil {
+				return err
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-s.terminate:
+			return nil
+		case <-time.After(eventsPollInterval):
+		}
+		j, err = s.ds.GetJobByID(ctx, id)
+		if err != nil {
+			return err
+		}
+	}
+}
+
 // listJobs
 // @Summary Show a list of jobs
+// @Description Jobs are paginated by page number, or by passing the nextCursor of a page as the cursor of the next request.
 // @Tags jobs
 // @Produce application/json
 // @Success 200 {object} []tork.JobSummary
+// @Failure 400 {object} echo.HTTPError
 // @Router /jobs [get]
 // @Param q query string false "search string"
+// @Param search query string false "full-text search over the names, errors and results of the jobs and their tasks"
+// @Param searchLogs query bool false "extend the search to the logs of the tasks"
+// @Param name query string false "jobs whose name contains it"
+// @Param state query string false "comma-separated job states"
+// @Param namespace query string false "comma-separated namespaces. jobs without one are in the default namespace"
+// @Param createdAfter query string false "RFC 3339 time the jobs were created at or after"
+// @Param createdBefore query string false "RFC 3339 time the jobs were created before"
+// @Param label query string false "label selector over the job tags, e.g. env=prod,!draft"
+// @Param sort query string false "createdAt, name, -createdAt (default) or -name"
+// @Param cursor query string false "the nextCursor of the previous page"
 // @Param page query int false "page number"
 // @Param size query int false "page size"
 func (s *API) listJobs(c echo.Context) error {
@@ -430,7 +828,31 @@ func (s *API) listJobs(c echo.Context) error {
 	} else if size > 20 {
 		size = 20
 	}
-	q := c.QueryParam("q")
+	q, err := jobQuery(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	q.Page = page
+	q.Size = size
+	namespaces, err := s.memberNamespaces(c.Request().Context())
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	if namespaces != nil {
+		// only the namespaces the user is a member of
+		if len(q.Namespaces) > 0 {
+			namespaces = slices.DeleteFunc(q.Namespaces, func(ns string) bool {
+				return !slices.Contains(namespaces, ns)
+			})
+		}
+		if len(namespaces) == 0 {
+			return c.JSON(http.StatusOK, datastore.Page[*tork.JobSummary]{
+				Number: 1,
+				Items:  make([]*tork.JobSummary, 0),
+			})
+		}
+		q.Namespaces = namespaces
+	}
 	currentUser := c.Request().Context().Value(tork.USERNAME)
 	var username string
 	if currentUser != nil {
@@ -440,8 +862,11 @@ func (s *API) listJobs(c echo.Context) error {
 		}
 		username = cu
 	}
-	res, err := s.ds.GetJobs(c.Request().Context(), username, q, page, size)
+	res, err := s.ds.ListJobs(c.Request().Context(), username, q)
 	if err != nil {
+		if errors.Is(err, datastore.ErrInvalidCursor) {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
 		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
 	}
 	return c.JSON(http.StatusOK, datastore.Page[*tork.JobSummary]{
@@ -450,9 +875,82 @@ func (s *API) listJobs(c echo.Context) error {
 		TotalPages: res.TotalPages,
 		Items:      res.Items,
 		TotalItems: res.TotalItems,
+		NextCursor: res.NextCursor,
 	})
 }
 
+// jobQuery reads the filters and the sort
+// order of the jobs from the query string.
+func jobQuery(c echo.Context) (datastore.JobQuery, error) {
+	q := datastore.JobQuery{
+		Q:      c.QueryParam("q"),
+		Search: c.QueryParam("search"),
+		Name:   c.QueryParam("name"),
+		Cursor: c.QueryParam("cursor"),
+	}
+	if v := c.QueryParam("searchLogs"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return q, errors.Errorf("invalid searchLogs: %s. expecting true or false", v)
+		}
+		q.SearchLogs = b
+	}
+	for _, v := range c.QueryParams()["state"] {
+		for _, state := range strings.Split(v, ",") {
+			state = strings.ToUpper(strings.TrimSpace(state))
+			switch tork.JobState(state) {
+			case tork.JobStatePending, tork.JobStateScheduled, tork.JobStateRunning,
+				tork.JobStateCancelled, tork.JobStateCompleted, tork.JobStateFailed, tork.JobStateRestart:
+				q.States = append(q.States, tork.JobState(state))
+			case "":
+			default:
+				return q, errors.Errorf("invalid state: %s", state)
+			}
+		}
+	}
+	for _, v := range c.QueryParams()["namespace"] {
+		for _, ns := range strings.Split(v, ",") {
+			ns = strings.TrimSpace(ns)
+			if ns == "" {
+				continue
+			}
+			if !input.ValidNamespace(ns) {
+				return q, errors.Errorf("invalid namespace: %s", ns)
+			}
+			q.Namespaces = append(q.Namespaces, ns)
+		}
+	}
+	for param, t := range map[string]**time.Time{
+		"createdAfter":  &q.CreatedAfter,
+		"createdBefore": &q.CreatedBefore,
+	} {
+		v := c.QueryParam(param)
+		if v == "" {
+			continue
+		}
+		ts, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return q, errors.Errorf("invalid %s: %s. expecting an RFC 3339 time", param, v)
+		}
+		*t = &ts
+	}
+	labels, err := datastore.ParseLabelSelector(c.QueryParam("label"))
+	if err != nil {
+		return q, err
+	}
+	q.Labels = labels
+	sort := c.QueryParam("sort")
+	if sort == "" {
+		sort = "-" + datastore.JOBS_SORT_CREATED_AT
+	}
+	q.Desc = strings.HasPrefix(sort, "-")
+	q.Sort = strings.TrimPrefix(sort, "-")
+	if q.Sort != datastore.JOBS_SORT_CREATED_AT && q.Sort != datastore.JOBS_SORT_NAME {
+		return q, errors.Errorf("invalid sort: %s. expecting createdAt or name, prefixed with - for descending order", sort)
+	}
+	return q, nil
+}
+
 // getTask
 // @Summary Get a task by id
 // @Tags tasks
@@ -466,12 +964,151 @@ func (s *API) getTask(c echo.Context) error {
 	if err != nil {
 		return echo.NewHTTPError(http.StatusNotFound, err.Error())
 	}
+	if t.ResultURL != "" {
+		if err := s.loadResult(c.Request().Context(), t); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+		}
+	}
 	if err := s.onReadTask(c.Request().Context(), task.Read, t); err != nil {
 		return err
 	}
 	return c.JSON(http.StatusOK, t)
 }
 
+// loadResult replaces the truncated result of the task
+// with its full result from the artifact store. Results
+// larger than maxResultSize are left truncated.
+func (s *API) loadResult(ctx context.Context, t *tork.Task) error {
+	r, err := artifact.Fetch(ctx, s.artifacts, t.ResultURL)
+	if err != nil {
+		return errors.Wrapf(err, "error fetching the result of task %s", t.ID)
+	}
+	defer r.Close()
+	// read one extra byte to detect a result that's too large
+	b, err := io.ReadAll(io.LimitReader(r, maxResultSize+1))
+	if err != nil {
+		return errors.Wrapf(err, "error reading the result of task %s", t.ID)
+	}
+	if int64(len(b)) > maxResultSize {
+		log.Warn().Msgf("the result of task %s exceeds %d bytes. returning it truncated", t.ID, maxResultSize)
+		return nil
+	}
+	t.Result = string(b)
+	t.ResultTruncated = false
+	// the result was spilled by the worker,
+	// before the coordinator redacted it
+	if s.redacter != nil {
+		s.redacter.RedactTaskResult(t)
+	}
+	return nil
+}
+
+// tailTaskLog
+// @Summary Tail a task's log over a WebSocket
+// @Description Each message is a tork.TaskLogPart. To resume after a
+// @Description disconnect, pass the number of the last part received.
+// @Tags tasks
+// @Success 101 "Switching Protocols"
+// @Failure 404 {object} echo.HTTPError
+// @Router /tasks/{id}/log/ws [get]
+// @Param id path string true "Task ID"
+// @Param offset query int false "the last part number already received"
+func (s *API) tailTaskLog(c echo.Context) error {
+	ctx := c.Request().Context()
+	id := c.Param("id")
+	t, err := s.ds.GetTaskByID(ctx, id)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+	if err := s.onReadTask(ctx, task.Read, t); err != nil {
+		return err
+	}
+	var offset int
+	if v := c.QueryParam("offset"); v != "" {
+		offset, err = strconv.Atoi(v)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid offset: %s", v))
+		}
+	}
+	websocket.Handler(func(ws *websocket.Conn) {
+		defer ws.Close()
+		for {
+			parts, err := s.newTaskLogParts(ctx, id, offset)
+			if err != nil {
+				log.Error().Err(err).Msgf("error reading log for task %s", id)
+				return
+			}
+			for _, p := range parts {
+				if err := websocket.JSON.Send(ws, p); err != nil {
+					log.Debug().Err(err).Msgf("error sending log for task %s", id)
+					return
+				}
+				offset = p.Number
+			}
+			if !t.State.IsActive() {
+				return
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-s.terminate:
+				return
+			case <-time.After(eventsPollInterval):
+			}
+			t, err = s.ds.GetTaskByID(ctx, id)
+			if err != nil {
+				log.Error().Err(err).Msgf("error getting task %s", id)
+				return
+			}
+		}
+	}).ServeHTTP(c.Response(), c.Request())
+	return nil
+}
+
+// jobLogParts returns all the job's log parts, oldest first
+func (s *API) jobLogParts(ctx context.Context, j *tork.Job) ([]*tork.TaskLogPart, error) {
+	result := make([]*tork.TaskLogPart, 0)
+	for page := 1; ; page++ {
+		l, err := s.logs.JobParts(ctx, j, page, MAX_LOG_PAGE_SIZE)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, l.Items...)
+		if page >= l.TotalPages {
+			break
+		}
+	}
+	// parts are returned newest first
+	slices.Reverse(result)
+	return result, nil
+}
+
+// newTaskLogParts returns the task's log parts numbered
+// after the given offset, oldest first
+func (s *API) newTaskLogParts(ctx context.Context, taskID string, offset int) ([]*tork.TaskLogPart, error) {
+	result := make([]*tork.TaskLogPart, 0)
+	for page := 1; ; page++ {
+		l, err := s.logs.TaskParts(ctx, taskID, page, MAX_LOG_PAGE_SIZE)
+		if err != nil {
+			return nil, err
+		}
+		done := page >= l.TotalPages
+		// parts are returned newest first
+		for _, p := range l.Items {
+			if p.Number <= offset {
+				done = true
+				break
+			}
+			result = append(result, p)
+		}
+		if done {
+			break
+		}
+	}
+	slices.Reverse(result)
+	return result, nil
+}
+
 // Task
 // @Summary Manually complete a running task
 // @Tags tasks
@@ -512,6 +1149,8 @@ func (s *API) completeTask(c echo.Context) error {
 
 // getTaskLog
 // @Summary Get a task's log
+// @Description Returns a page of the task's log parts, or, when an offset is
+// @Description given, a chunk of the task's full log (a TaskLogChunk) read from the log store.
 // @Tags tasks
 // @Produce application/json
 // @Success 200 {object} []tork.TaskLogPart
@@ -519,8 +1158,13 @@ func (s *API) completeTask(c echo.Context) error {
 // @Param id path string true "Task ID"
 // @Param page query int false "page number"
 // @Param size query int false "page size"
+// @Param offset query int false "the byte offset of the chunk of the full log to read"
+// @Param limit query int false "the maximum number of bytes of the chunk"
 func (s *API) getTaskLog(c echo.Context) error {
 	id := c.Param("id")
+	if c.QueryParam("offset") != "" {
+		return s.getTaskLogChunk(c, id)
+	}
 	ps := c.QueryParam("page")
 	if ps == "" {
 		ps = "1"
@@ -549,19 +1193,103 @@ func (s *API) getTaskLog(c echo.Context) error {
 	if err != nil {
 		return echo.NewHTTPError(http.StatusNotFound, err.Error())
 	}
-	l, err := s.ds.GetTaskLogParts(c.Request().Context(), id, page, size)
+	l, err := s.logs.TaskParts(c.Request().Context(), id, page, size)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusNotFound, err.Error())
 	}
 	return c.JSON(http.StatusOK, l)
 }
 
+func (s *API) getTaskLogChunk(c echo.Context, id string) error {
+	offset, err := strconv.ParseInt(c.QueryParam("offset"), 10, 64)
+	if err != nil || offset < 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid offset: %s", c.QueryParam("offset")))
+	}
+	limit := DEFAULT_LOG_CHUNK_SIZE
+	if l := c.QueryParam("limit"); l != "" {
+		limit, err = strconv.Atoi(l)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid limit: %s", l))
+		}
+	}
+	if limit < 1 {
+		limit = 1
+	} else if limit > MAX_LOG_CHUNK_SIZE {
+		limit = MAX_LOG_CHUNK_SIZE
+	}
+	ctx := c.Request().Context()
+	if _, err := s.ds.GetTaskByID(ctx, id); err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+	b, err := s.logs.Read(ctx, id, offset, limit)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, TaskLogChunk{
+		Offset:     offset,
+		NextOffset: offset + int64(len(b)),
+		Contents:   string(b),
+	})
+}
+
+// getMetrics
+// @Summary Get the cluster's metrics
+// @Description Served in the Prometheus text format when requested with Accept: text/plain
+// @Tags management
+// @Produce application/json
+// @Produce text/plain
+// @Success 200 {object} tork.Metrics
+// @Router /metrics [get]
 func (s *API) getMetrics(c echo.Context) error {
-	metrics, err := s.ds.GetMetrics(c.Request().Context())
+	m, err := s.ds.GetMetrics(c.Request().Context())
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	// Prometheus scrapers ask for the text format
+	accept := c.Request().Header.Get(echo.HeaderAccept)
+	if strings.Contains(accept, "text/plain") || strings.Contains(accept, "application/openmetrics-text") {
+		c.Response().Header().Set(echo.HeaderContentType, metrics.ContentType)
+		c.Response().WriteHeader(http.StatusOK)
+		w := c.Response()
+		metrics.WriteGauge(w, "tork_jobs_running", "Number of running jobs.", float64(m.Jobs.Running))
+		metrics.WriteGauge(w, "tork_tasks_running", "Number of running tasks.", float64(m.Tasks.Running))
+		metrics.WriteGauge(w, "tork_nodes_online", "Number of online worker nodes.", float64(m.Nodes.Running))
+		metrics.WriteGauge(w, "tork_nodes_cpu_percent", "Average CPU usage of the worker nodes.", m.Nodes.CPUPercent)
+		metrics.WriteGauge(w, "tork_nodes_memory_percent", "Average memory usage of the worker nodes.", m.Nodes.MemoryPercent)
+		metrics.WriteGauge(w, "tork_nodes_disk_percent", "Average disk usage of the worker nodes.", m.Nodes.DiskPercent)
+		metrics.Write(w)
+		return nil
+	}
+	return c.JSON(http.StatusOK, m)
+}
+
+// debugState is the state of the coordinator
+// served by the /debug/state endpoint.
+type debugState struct {
+	debug.Stats
+	Jobs   tork.JobMetrics  `json:"jobs"`
+	Tasks  tork.TaskMetrics `json:"tasks"`
+	Queues []mq.QueueInfo   `json:"queues"`
+}
+
+// getDebugState dumps the state of the coordinator
+// process along with the queued and running tasks.
+func (s *API) getDebugState(c echo.Context) error {
+	ctx := c.Request().Context()
+	m, err := s.ds.GetMetrics(ctx)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
 	}
-	return c.JSON(http.StatusOK, metrics)
+	qs, err := s.broker.Queues(ctx)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return c.JSON(http.StatusOK, debugState{
+		Stats:  debug.ReadStats(),
+		Jobs:   m.Jobs,
+		Tasks:  m.Tasks,
+		Queues: qs,
+	})
 }
 
 // Job
@@ -619,20 +1347,240 @@ func (s *API) cancelJob(c echo.Context) error {
 	return c.JSON(http.StatusOK, map[string]string{"status": "OK"})
 }
 
-// createUser
-// @Summary Create a new user
-// @Tags users
+// createScheduledJob
+// @Summary Create a new scheduled job
+// @Tags scheduled-jobs
 // @Accept json
 // @Produce json
-// @Success 200 {object} tork.User
-// @Router /users [post]
-// @Param request body tork.User true "body"
-func (s *API) createUser(c echo.Context) error {
-	var u tork.User
-	body, err := io.ReadAll(c.Request().Body)
-	if err != nil {
-		return err
-	}
+// @Success 200 {object} tork.ScheduledJob
+// @Router /scheduled-jobs [post]
+// @Param request body input.ScheduledJob true "body"
+func (s *API) createScheduledJob(c echo.Context) error {
+	var sji *input.ScheduledJob
+	var err error
+	contentType := c.Request().Header.Get("content-type")
+	switch contentType {
+	case "application/json":
+		sji, err = bindInputJSON[input.ScheduledJob](c.Request().Body)
+	case "text/yaml":
+		sji, err = bindInputYAML[input.ScheduledJob](c.Request().Body)
+	default:
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("unknown content type: %s", contentType))
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	ctx := c.Request().Context()
+	if err := sji.Validate(s.ds); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, input.FormatValidationError(err).Error())
+	}
+	if err := checkImages(s.images, sji.Tasks); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	sj := sji.ToScheduledJob()
+	if _, err := s.admitToNamespace(ctx, sj.Template); errors.Is(err, ErrNamespaceForbidden) {
+		return echo.NewHTTPError(http.StatusForbidden, err.Error())
+	} else if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if cu, ok := ctx.Value(tork.USERNAME).(string); ok {
+		u, err := s.ds.GetUser(ctx, cu)
+		if err != nil {
+			return err
+		}
+		sj.CreatedBy = u
+	}
+	if err := s.ds.CreateScheduledJob(ctx, sj); err != nil {
+		return err
+	}
+	log.Info().Str("scheduled-job-id", sj.ID).Msg("created scheduled job")
+	if err := s.onReadJob(ctx, job.Read, sj.Template); err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, sj)
+}
+
+// listScheduledJobs
+// @Summary Get a list of scheduled jobs
+// @Tags scheduled-jobs
+// @Produce application/json
+// @Success 200 {object} []tork.ScheduledJob
+// @Router /scheduled-jobs [get]
+func (s *API) listScheduledJobs(c echo.Context) error {
+	ctx := c.Request().Context()
+	sjs, err := s.ds.GetScheduledJobs(ctx)
+	if err != nil {
+		return err
+	}
+	result := make([]*tork.ScheduledJob, 0, len(sjs))
+	for _, sj := range sjs {
+		ok, err := s.canReadJob(ctx, sj.Template)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+		if err := s.onReadJob(ctx, job.Read, sj.Template); err != nil {
+			log.Warn().Err(err).Str("scheduled-job-id", sj.ID).Msg("skipping scheduled job")
+			continue
+		}
+		result = append(result, sj)
+	}
+	return c.JSON(http.StatusOK, result)
+}
+
+// getScheduledJob
+// @Summary Get a scheduled job by id
+// @Tags scheduled-jobs
+// @Produce application/json
+// @Success 200 {object} tork.ScheduledJob
+// @Failure 404 {object} echo.HTTPError
+// @Router /scheduled-jobs/{id} [get]
+// @Param id path string true "Scheduled job ID"
+func (s *API) getScheduledJob(c echo.Context) error {
+	ctx := c.Request().Context()
+	sj, err := s.ds.GetScheduledJobByID(ctx, c.Param("id"))
+	if err != nil {
+		if errors.Is(err, datastore.ErrScheduledJobNotFound) {
+			return echo.ErrNotFound
+		}
+		return err
+	}
+	if ok, err := s.canReadJob(ctx, sj.Template); err != nil {
+		return err
+	} else if !ok {
+		return echo.ErrNotFound
+	}
+	if err := s.onReadJob(ctx, job.Read, sj.Template); err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, sj)
+}
+
+// canReadJob reports whether the user making the request
+// may read the job, applying the same permissions the
+// jobs list does: jobs without any are public.
+func (s *API) canReadJob(ctx context.Context, j *tork.Job) (bool, error) {
+	if j == nil {
+		return true, nil
+	}
+	if ok, err := s.canUseNamespace(ctx, j.Namespace); err != nil || !ok {
+		return false, err
+	}
+	if len(j.Permissions) == 0 {
+		return true, nil
+	}
+	cu, ok := ctx.Value(tork.USERNAME).(string)
+	if !ok || cu == "" {
+		return true, nil
+	}
+	u, err := s.ds.GetUser(ctx, cu)
+	if err != nil {
+		if errors.Is(err, datastore.ErrUserNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+	roles, err := s.ds.GetUserRoles(ctx, u.ID)
+	if err != nil {
+		return false, err
+	}
+	for _, p := range j.Permissions {
+		if p.User != nil && (p.User.Username == u.Username || p.User.ID == u.ID) {
+			return true, nil
+		}
+		if p.Role == nil {
+			continue
+		}
+		for _, r := range roles {
+			if p.Role.Slug == r.Slug || p.Role.ID == r.ID {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// pauseScheduledJob
+// @Summary Pause a scheduled job
+// @Tags scheduled-jobs
+// @Produce application/json
+// @Success 200 {string} string "OK"
+// @Router /scheduled-jobs/{id}/pause [put]
+// @Param id path string true "Scheduled job ID"
+func (s *API) pauseScheduledJob(c echo.Context) error {
+	return s.updateScheduledJobState(c, tork.ScheduledJobStateActive, tork.ScheduledJobStatePaused)
+}
+
+// resumeScheduledJob
+// @Summary Resume a paused scheduled job
+// @Tags scheduled-jobs
+// @Produce application/json
+// @Success 200 {string} string "OK"
+// @Router /scheduled-jobs/{id}/resume [put]
+// @Param id path string true "Scheduled job ID"
+func (s *API) resumeScheduledJob(c echo.Context) error {
+	return s.updateScheduledJobState(c, tork.ScheduledJobStatePaused, tork.ScheduledJobStateActive)
+}
+
+func (s *API) updateScheduledJobState(c echo.Context, from, to tork.ScheduledJobState) error {
+	err := s.ds.UpdateScheduledJob(c.Request().Context(), c.Param("id"), func(u *tork.ScheduledJob) error {
+		if u.State != from {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("scheduled job is not %s", strings.ToLower(string(from))))
+		}
+		u.State = to
+		if to == tork.ScheduledJobStateActive {
+			// don't catch up on runs missed while paused
+			now := time.Now().UTC()
+			u.LastRunAt = &now
+		}
+		return nil
+	})
+	if err != nil {
+		if errors.Is(err, datastore.ErrScheduledJobNotFound) {
+			return echo.ErrNotFound
+		}
+		var herr *echo.HTTPError
+		if errors.As(err, &herr) {
+			return herr
+		}
+		return err
+	}
+	return c.JSON(http.StatusOK, map[string]string{"status": "OK"})
+}
+
+// deleteScheduledJob
+// @Summary Delete a scheduled job
+// @Tags scheduled-jobs
+// @Produce application/json
+// @Success 200 {string} string "OK"
+// @Router /scheduled-jobs/{id} [delete]
+// @Param id path string true "Scheduled job ID"
+func (s *API) deleteScheduledJob(c echo.Context) error {
+	if err := s.ds.DeleteScheduledJob(c.Request().Context(), c.Param("id")); err != nil {
+		if errors.Is(err, datastore.ErrScheduledJobNotFound) {
+			return echo.ErrNotFound
+		}
+		return err
+	}
+	return c.JSON(http.StatusOK, map[string]string{"status": "OK"})
+}
+
+// createUser
+// @Summary Create a new user
+// @Tags users
+// @Accept json
+// @Produce json
+// @Success 200 {object} tork.User
+// @Router /users [post]
+// @Param request body tork.User true "body"
+func (s *API) createUser(c echo.Context) error {
+	var u tork.User
+	body, err := io.ReadAll(c.Request().Body)
+	if err != nil {
+		return err
+	}
 	if err := json.Unmarshal(body, &u); err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
 	}
@@ -663,6 +1611,356 @@ func (s *API) createUser(c echo.Context) error {
 	}
 }
 
+// assignRole
+// @Summary Assign a role to a user
+// @Tags users
+// @Produce application/json
+// @Success 200 {string} string "OK"
+// @Router /users/{username}/roles/{role} [put]
+// @Param username path string true "Username"
+// @Param role path string true "Role slug"
+func (s *API) assignRole(c echo.Context) error {
+	ctx := c.Request().Context()
+	u, r, err := s.lookupUserRole(c)
+	if err != nil {
+		return err
+	}
+	roles, err := s.ds.GetUserRoles(ctx, u.ID)
+	if err != nil {
+		return err
+	}
+	for _, ur := range roles {
+		if ur.ID == r.ID {
+			return c.JSON(http.StatusOK, map[string]string{"status": "OK"})
+		}
+	}
+	if err := s.ds.AssignRole(ctx, u.ID, r.ID); err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, map[string]string{"status": "OK"})
+}
+
+// unassignRole
+// @Summary Remove a role from a user
+// @Tags users
+// @Produce application/json
+// @Success 200 {string} string "OK"
+// @Router /users/{username}/roles/{role} [delete]
+// @Param username path string true "Username"
+// @Param role path string true "Role slug"
+func (s *API) unassignRole(c echo.Context) error {
+	u, r, err := s.lookupUserRole(c)
+	if err != nil {
+		return err
+	}
+	if err := s.ds.UnassignRole(c.Request().Context(), u.ID, r.ID); err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, map[string]string{"status": "OK"})
+}
+
+func (s *API) lookupUserRole(c echo.Context) (*tork.User, *tork.Role, error) {
+	ctx := c.Request().Context()
+	u, err := s.ds.GetUser(ctx, c.Param("username"))
+	if err != nil {
+		if errors.Is(err, datastore.ErrUserNotFound) {
+			return nil, nil, echo.NewHTTPError(http.StatusNotFound, "user not found")
+		}
+		return nil, nil, err
+	}
+	r, err := s.ds.GetRole(ctx, c.Param("role"))
+	if err != nil {
+		if errors.Is(err, datastore.ErrRoleNotFound) {
+			return nil, nil, echo.NewHTTPError(http.StatusNotFound, "role not found")
+		}
+		return nil, nil, err
+	}
+	return u, r, nil
+}
+
+// createAPIKey
+// @Summary Create a new API key
+// @Description The plaintext key is only returned in the response to this request
+// @Tags keys
+// @Accept json
+// @Produce json
+// @Success 200 {object} tork.APIKey
+// @Router /keys [post]
+// @Param request body tork.APIKey true "body"
+func (s *API) createAPIKey(c echo.Context) error {
+	var k tork.APIKey
+	body, err := io.ReadAll(c.Request().Body)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(body, &k); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	k.Name = strings.TrimSpace(k.Name)
+	if k.Name == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "must provide name")
+	}
+	key, err := newAPIKey()
+	if err != nil {
+		return err
+	}
+	k.KeyHash = hash.Key(key)
+	k.CreatedBy, err = s.currentUserID(c.Request().Context())
+	if err != nil {
+		return err
+	}
+	if err := s.ds.CreateAPIKey(c.Request().Context(), &k); err != nil {
+		return err
+	}
+	k.Key = key
+	return c.JSON(http.StatusOK, k)
+}
+
+// currentUserID returns the ID of the user making the
+// request, which is recorded as the creator of the keys
+// and secrets like it is of jobs.
+func (s *API) currentUserID(ctx context.Context) (string, error) {
+	cu, ok := ctx.Value(tork.USERNAME).(string)
+	if !ok || cu == "" {
+		return "", nil
+	}
+	u, err := s.ds.GetUser(ctx, cu)
+	if err != nil {
+		return "", err
+	}
+	return u.ID, nil
+}
+
+// listAPIKeys
+// @Summary Get a list of API keys
+// @Tags keys
+// @Produce application/json
+// @Success 200 {object} []tork.APIKey
+// @Router /keys [get]
+func (s *API) listAPIKeys(c echo.Context) error {
+	keys, err := s.ds.GetAPIKeys(c.Request().Context())
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, keys)
+}
+
+// deleteAPIKey
+// @Summary Revoke an API key
+// @Tags keys
+// @Produce application/json
+// @Success 200 {string} string "OK"
+// @Router /keys/{id} [delete]
+// @Param id path string true "API key ID"
+func (s *API) deleteAPIKey(c echo.Context) error {
+	if err := s.ds.DeleteAPIKey(c.Request().Context(), c.Param("id")); err != nil {
+		if errors.Is(err, datastore.ErrAPIKeyNotFound) {
+			return echo.ErrNotFound
+		}
+		return err
+	}
+	return c.JSON(http.StatusOK, map[string]string{"status": "OK"})
+}
+
+var secretNamePattern = regexp.MustCompile(`^[a-zA-Z0-9_.-]{1,64}$`)
+
+// listSecrets
+// @Summary Get a list of the managed secrets
+// @Description Secret values are never returned
+// @Tags secrets
+// @Produce application/json
+// @Success 200 {object} []tork.Secret
+// @Router /secrets [get]
+func (s *API) listSecrets(c echo.Context) error {
+	secrets, err := s.ds.GetSecrets(c.Request().Context())
+	if err != nil {
+		return err
+	}
+	for _, secret := range secrets {
+		secret.Value = ""
+	}
+	return c.JSON(http.StatusOK, secrets)
+}
+
+// setSecret
+// @Summary Create or update a managed secret
+// @Description Tasks reference the secret in their env as secret://{name}
+// @Tags secrets
+// @Accept json
+// @Produce json
+// @Success 200 {object} tork.Secret
+// @Router /secrets/{name} [put]
+// @Param name path string true "Secret name"
+// @Param request body tork.Secret true "body"
+func (s *API) setSecret(c echo.Context) error {
+	name := c.Param("name")
+	if !secretNamePattern.MatchString(name) {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid secret name")
+	}
+	var secret tork.Secret
+	body, err := io.ReadAll(c.Request().Body)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(body, &secret); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if secret.Value == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "must provide value")
+	}
+	secret.Name = name
+	secret.CreatedBy, err = s.currentUserID(c.Request().Context())
+	if err != nil {
+		return err
+	}
+	if err := s.ds.SetSecret(c.Request().Context(), &secret); err != nil {
+		return err
+	}
+	secret.Value = ""
+	return c.JSON(http.StatusOK, secret)
+}
+
+// deleteSecret
+// @Summary Delete a managed secret
+// @Tags secrets
+// @Produce application/json
+// @Success 200 {string} string "OK"
+// @Router /secrets/{name} [delete]
+// @Param name path string true "Secret name"
+func (s *API) deleteSecret(c echo.Context) error {
+	if err := s.ds.DeleteSecret(c.Request().Context(), c.Param("name")); err != nil {
+		if errors.Is(err, datastore.ErrSecretNotFound) {
+			return echo.ErrNotFound
+		}
+		return err
+	}
+	return c.JSON(http.StatusOK, map[string]string{"status": "OK"})
+}
+
+// listDeadLetters
+// @Summary Get a list of the task messages that exhausted their delivery attempts
+// @Tags dead-letters
+// @Produce application/json
+// @Success 200 {object} []tork.DeadLetter
+// @Router /dead-letters [get]
+// @Param page query int false "page number"
+// @Param size query int false "page size"
+func (s *API) listDeadLetters(c echo.Context) error {
+	ps := c.QueryParam("page")
+	if ps == "" {
+		ps = "1"
+	}
+	page, err := strconv.Atoi(ps)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid page number: %s", ps))
+	}
+	if page < 1 {
+		page = 1
+	}
+	si := c.QueryParam("size")
+	if si == "" {
+		si = "10"
+	}
+	size, err := strconv.Atoi(si)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid size: %s", si))
+	}
+	if size < 1 {
+		size = 1
+	} else if size > 20 {
+		size = 20
+	}
+	res, err := s.ds.GetDeadLetters(c.Request().Context(), page, size)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, res)
+}
+
+// getDeadLetter
+// @Summary Get a dead-lettered task message by ID
+// @Tags dead-letters
+// @Produce application/json
+// @Success 200 {object} tork.DeadLetter
+// @Router /dead-letters/{id} [get]
+// @Param id path string true "Dead letter ID"
+func (s *API) getDeadLetter(c echo.Context) error {
+	dl, err := s.ds.GetDeadLetterByID(c.Request().Context(), c.Param("id"))
+	if err != nil {
+		if errors.Is(err, datastore.ErrDeadLetterNotFound) {
+			return echo.ErrNotFound
+		}
+		return err
+	}
+	return c.JSON(http.StatusOK, dl)
+}
+
+// requeueDeadLetter
+// @Summary Publish a dead-lettered task message back to its queue
+// @Tags dead-letters
+// @Produce application/json
+// @Success 200 {string} string "OK"
+// @Router /dead-letters/{id}/requeue [put]
+// @Param id path string true "Dead letter ID"
+func (s *API) requeueDeadLetter(c echo.Context) error {
+	ctx := c.Request().Context()
+	dl, err := s.ds.GetDeadLetterByID(ctx, c.Param("id"))
+	if err != nil {
+		if errors.Is(err, datastore.ErrDeadLetterNotFound) {
+			return echo.ErrNotFound
+		}
+		return err
+	}
+	if err := s.ds.DeleteDeadLetter(ctx, dl.ID); err != nil {
+		if errors.Is(err, datastore.ErrDeadLetterNotFound) {
+			// requeued by a concurrent request
+			return echo.ErrNotFound
+		}
+		return err
+	}
+	if err := s.broker.PublishTask(ctx, dl.Queue, dl.Task); err != nil {
+		if rerr := s.ds.CreateDeadLetter(ctx, dl); rerr != nil {
+			log.Error().Err(rerr).Msgf("error restoring dead letter %s", dl.ID)
+		}
+		return err
+	}
+	return c.JSON(http.StatusOK, map[string]string{"status": "OK"})
+}
+
+// deleteDeadLetter
+// @Summary Discard a dead-lettered task message
+// @Tags dead-letters
+// @Produce application/json
+// @Success 200 {string} string "OK"
+// @Router /dead-letters/{id} [delete]
+// @Param id path string true "Dead letter ID"
+func (s *API) deleteDeadLetter(c echo.Context) error {
+	if err := s.ds.DeleteDeadLetter(c.Request().Context(), c.Param("id")); err != nil {
+		if errors.Is(err, datastore.ErrDeadLetterNotFound) {
+			return echo.ErrNotFound
+		}
+		return err
+	}
+	return c.JSON(http.StatusOK, map[string]string{"status": "OK"})
+}
+
+func newAPIKey() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.Wrapf(err, "error generating api key")
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// proxy
+// @Summary Proxy a request to a port exposed by a running task
+// @Description Any method is proxied to the task's service port
+// @Tags tasks
+// @Success 200
+// @Failure 404 {object} echo.HTTPError
+// @Router /tasks/{id}/proxy/{port} [get]
+// @Param id path string true "Task ID"
+// @Param port path int true "the task's port"
 func (a *API) proxy(c echo.Context) error {
 	id := c.Param("id")
 	port := c.Param("port")
@@ -9,6 +9,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"regexp"
 	"strconv"
 	"strings"
 	"testing"
@@ -17,15 +18,21 @@ import (
 	"github.com/labstack/echo/v4"
 	"github.com/pkg/errors"
 	"github.com/runabol/tork"
+	"github.com/runabol/tork/artifact"
 	"github.com/runabol/tork/datastore"
 	"github.com/runabol/tork/datastore/inmemory"
 	"github.com/runabol/tork/datastore/postgres"
+	"github.com/runabol/tork/internal/hash"
+	"github.com/runabol/tork/internal/redact"
+	"github.com/runabol/tork/logstore"
 	"github.com/runabol/tork/middleware/web"
 
 	"github.com/runabol/tork/mq"
+	"github.com/runabol/tork/runtime"
 
 	"github.com/runabol/tork/internal/uuid"
 	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/websocket"
 )
 
 func Test_getQueues(t *testing.T) {
@@ -123,6 +130,131 @@ func Test_listJobs(t *testing.T) {
 	assert.Equal(t, http.StatusOK, w.Code)
 }
 
+func Test_listJobsFilters(t *testing.T) {
+	b := mq.NewInMemoryBroker()
+	ds := inmemory.NewInMemoryDatastore()
+	api, err := NewAPI(Config{
+		DataStore: ds,
+		Broker:    b,
+	})
+	assert.NoError(t, err)
+
+	now := time.Now().UTC()
+	for i := 0; i < 15; i++ {
+		state := tork.JobStateCompleted
+		if i%3 == 0 {
+			state = tork.JobStateFailed
+		}
+		var namespace string
+		if i%5 == 0 {
+			namespace = "team-a"
+		}
+		err := ds.CreateJob(context.Background(), &tork.Job{
+			ID:        uuid.NewUUID(),
+			Name:      fmt.Sprintf("job %02d", i),
+			Namespace: namespace,
+			State:     state,
+			CreatedAt: now.Add(time.Duration(i) * time.Second),
+			Tags:      []string{fmt.Sprintf("env=%d", i%2)},
+		})
+		assert.NoError(t, err)
+	}
+
+	list := func(qs string) (int, datastore.Page[*tork.JobSummary]) {
+		req, err := http.NewRequest("GET", "/jobs?"+qs, nil)
+		assert.NoError(t, err)
+		w := httptest.NewRecorder()
+		api.server.Handler.ServeHTTP(w, req)
+		js := datastore.Page[*tork.JobSummary]{}
+		if w.Code == http.StatusOK {
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &js))
+		}
+		return w.Code, js
+	}
+
+	code, js := list("state=failed&label=env=1&sort=name")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, 2, js.TotalItems)
+	assert.Equal(t, "job 03", js.Items[0].Name)
+	assert.Equal(t, "job 09", js.Items[1].Name)
+
+	code, js = list("namespace=team-a")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, 3, js.TotalItems)
+
+	code, js = list("namespace=team-a&state=failed")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, 1, js.TotalItems)
+	assert.Equal(t, "job 00", js.Items[0].Name)
+
+	code, js = list("namespace=default,team-a")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, 15, js.TotalItems)
+
+	code, js = list("name=JOB+1&createdAfter=" + url.QueryEscape(now.Add(time.Second*11).Format(time.RFC3339Nano)))
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, 4, js.TotalItems)
+
+	// cursor pagination
+	code, js = list("sort=-name&size=10")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Len(t, js.Items, 10)
+	assert.Equal(t, "job 14", js.Items[0].Name)
+	assert.NotEmpty(t, js.NextCursor)
+	code, js = list("sort=-name&size=10&cursor=" + js.NextCursor)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Len(t, js.Items, 5)
+	assert.Equal(t, "job 04", js.Items[0].Name)
+	assert.Empty(t, js.NextCursor)
+
+	for _, qs := range []string{
+		"state=bogus",
+		"sort=size",
+		"createdAfter=yesterday",
+		"label==x",
+		"cursor=bogus",
+		"namespace=Team_A",
+	} {
+		code, _ = list(qs)
+		assert.Equal(t, http.StatusBadRequest, code, qs)
+	}
+}
+
+func Test_listJobsSearch(t *testing.T) {
+	ds := inmemory.NewInMemoryDatastore()
+	api, err := NewAPI(Config{
+		DataStore: ds,
+		Broker:    mq.NewInMemoryBroker(),
+	})
+	assert.NoError(t, err)
+
+	j1 := &tork.Job{ID: uuid.NewUUID(), Name: "backup", State: tork.JobStateFailed}
+	assert.NoError(t, ds.CreateJob(context.Background(), j1))
+	assert.NoError(t, ds.CreateTask(context.Background(), &tork.Task{
+		ID:    uuid.NewUUID(),
+		JobID: j1.ID,
+		Error: "OOMKilled",
+	}))
+	j2 := &tork.Job{ID: uuid.NewUUID(), Name: "report", State: tork.JobStateFailed}
+	assert.NoError(t, ds.CreateJob(context.Background(), j2))
+
+	req, err := http.NewRequest("GET", "/jobs?state=failed&search=oomkilled", nil)
+	assert.NoError(t, err)
+	w := httptest.NewRecorder()
+	api.server.Handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	js := datastore.Page[*tork.JobSummary]{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &js))
+	assert.Len(t, js.Items, 1)
+	assert.Equal(t, j1.ID, js.Items[0].ID)
+
+	req, err = http.NewRequest("GET", "/jobs?search=oomkilled&searchLogs=maybe", nil)
+	assert.NoError(t, err)
+	w = httptest.NewRecorder()
+	api.server.Handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
 func Test_getActiveNodes(t *testing.T) {
 	ds := inmemory.NewInMemoryDatastore()
 	active := &tork.Node{
@@ -177,6 +309,49 @@ func Test_healthOK(t *testing.T) {
 	assert.Equal(t, http.StatusOK, w.Code)
 }
 
+func Test_healthLiveAndReady(t *testing.T) {
+	api, err := NewAPI(Config{
+		DataStore: inmemory.NewInMemoryDatastore(),
+		Broker:    mq.NewInMemoryBroker(),
+	})
+	assert.NoError(t, err)
+	for _, path := range []string{"/health/live", "/health/ready"} {
+		req, err := http.NewRequest("GET", path, nil)
+		assert.NoError(t, err)
+		w := httptest.NewRecorder()
+		api.server.Handler.ServeHTTP(w, req)
+		body, err := io.ReadAll(w.Body)
+		assert.NoError(t, err)
+		assert.Contains(t, string(body), "\"status\":\"UP\"")
+		assert.Equal(t, http.StatusOK, w.Code)
+	}
+}
+
+func Test_healthNotReady(t *testing.T) {
+	b := mq.NewInMemoryBroker()
+	assert.NoError(t, b.Shutdown(context.Background()))
+	api, err := NewAPI(Config{
+		DataStore: inmemory.NewInMemoryDatastore(),
+		Broker:    b,
+	})
+	assert.NoError(t, err)
+
+	req, err := http.NewRequest("GET", "/health/ready", nil)
+	assert.NoError(t, err)
+	w := httptest.NewRecorder()
+	api.server.Handler.ServeHTTP(w, req)
+	assert.Contains(t, w.Body.String(), "\"status\":\"DOWN\"")
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+
+	// the process is still alive
+	req, err = http.NewRequest("GET", "/health/live", nil)
+	assert.NoError(t, err)
+	w = httptest.NewRecorder()
+	api.server.Handler.ServeHTTP(w, req)
+	assert.Contains(t, w.Body.String(), "\"status\":\"UP\"")
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
 func Test_healthNotOK(t *testing.T) {
 	schemaName := fmt.Sprintf("tork%d", rand.Int())
 	dsn := `host=localhost user=tork password=tork dbname=tork search_path=%s sslmode=disable`
@@ -199,6 +374,33 @@ func Test_healthNotOK(t *testing.T) {
 	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
 }
 
+func Test_getMetricsPrometheus(t *testing.T) {
+	api, err := NewAPI(Config{
+		DataStore: inmemory.NewInMemoryDatastore(),
+		Broker:    mq.NewInMemoryBroker(),
+	})
+	assert.NoError(t, err)
+
+	req, err := http.NewRequest("GET", "/metrics", nil)
+	assert.NoError(t, err)
+	w := httptest.NewRecorder()
+	api.server.Handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Header().Get("Content-Type"), "application/json")
+
+	req, err = http.NewRequest("GET", "/metrics", nil)
+	assert.NoError(t, err)
+	req.Header.Set("Accept", "text/plain;version=0.0.4;q=0.5,*/*;q=0.1")
+	w = httptest.NewRecorder()
+	api.server.Handler.ServeHTTP(w, req)
+	body, err := io.ReadAll(w.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Header().Get("Content-Type"), "text/plain")
+	assert.Contains(t, string(body), "tork_jobs_running 0")
+	assert.Contains(t, string(body), "# TYPE tork_broker_messages_published_total counter")
+}
+
 func Test_getUnknownTask(t *testing.T) {
 	api, err := NewAPI(Config{
 		DataStore: inmemory.NewInMemoryDatastore(),
@@ -243,6 +445,179 @@ func Test_getTask(t *testing.T) {
 	assert.Equal(t, http.StatusOK, w.Code)
 }
 
+func Test_getTaskWithSpilledResult(t *testing.T) {
+	ctx := context.Background()
+	ds := inmemory.NewInMemoryDatastore()
+	store, err := artifact.NewFileStore(t.TempDir())
+	assert.NoError(t, err)
+	err = ds.CreateJob(ctx, &tork.Job{
+		ID:      "5678",
+		Secrets: map[string]string{"password": "world"},
+	})
+	assert.NoError(t, err)
+	ta := tork.Task{
+		ID:              "1234",
+		JobID:           "5678",
+		Name:            "test task",
+		Result:          "hello",
+		ResultTruncated: true,
+	}
+	resultURL, err := store.Put(ctx, artifact.ResultKey(&ta), strings.NewReader("hello world"))
+	assert.NoError(t, err)
+	ta.ResultURL = resultURL
+	err = ds.CreateTask(ctx, &ta)
+	assert.NoError(t, err)
+	api, err := NewAPI(Config{
+		DataStore: ds,
+		Broker:    mq.NewInMemoryBroker(),
+		Artifacts: store,
+		Redacter:  redact.NewRedacter(ds),
+	})
+	assert.NoError(t, err)
+	getTask := func() tork.Task {
+		req, err := http.NewRequest("GET", "/tasks/1234", nil)
+		assert.NoError(t, err)
+		w := httptest.NewRecorder()
+		api.server.Handler.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+		tr := tork.Task{}
+		err = json.Unmarshal(w.Body.Bytes(), &tr)
+		assert.NoError(t, err)
+		return tr
+	}
+	tr := getTask()
+	assert.Equal(t, "hello [REDACTED]", tr.Result)
+	assert.False(t, tr.ResultTruncated)
+
+	// results that are too large are left truncated
+	defer func(v int64) { maxResultSize = v }(maxResultSize)
+	maxResultSize = 5
+	tr = getTask()
+	assert.Equal(t, "hello", tr.Result)
+	assert.True(t, tr.ResultTruncated)
+}
+
+func Test_getTaskLogChunk(t *testing.T) {
+	ctx := context.Background()
+	ds := inmemory.NewInMemoryDatastore()
+	tk := &tork.Task{ID: uuid.NewUUID()}
+	assert.NoError(t, ds.CreateTask(ctx, tk))
+	assert.NoError(t, ds.CreateTaskLogPart(ctx, &tork.TaskLogPart{TaskID: tk.ID, Number: 1, Contents: "line 1\n"}))
+	assert.NoError(t, ds.CreateTaskLogPart(ctx, &tork.TaskLogPart{TaskID: tk.ID, Number: 2, Contents: "line 2\n"}))
+	api, err := NewAPI(Config{
+		DataStore: ds,
+		Broker:    mq.NewInMemoryBroker(),
+	})
+	assert.NoError(t, err)
+
+	read := func(query string) (int, TaskLogChunk) {
+		req, err := http.NewRequest("GET", fmt.Sprintf("/tasks/%s/log?%s", tk.ID, query), nil)
+		assert.NoError(t, err)
+		w := httptest.NewRecorder()
+		api.server.Handler.ServeHTTP(w, req)
+		chunk := TaskLogChunk{}
+		if w.Code == http.StatusOK {
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &chunk))
+		}
+		return w.Code, chunk
+	}
+
+	code, chunk := read("offset=0")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "line 1\nline 2\n", chunk.Contents)
+	assert.Equal(t, int64(14), chunk.NextOffset)
+
+	code, chunk = read("offset=5&limit=4")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "1\nli", chunk.Contents)
+	assert.Equal(t, int64(5), chunk.Offset)
+	assert.Equal(t, int64(9), chunk.NextOffset)
+
+	code, chunk = read("offset=14")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "", chunk.Contents)
+	assert.Equal(t, int64(14), chunk.NextOffset)
+
+	code, _ = read("offset=-1")
+	assert.Equal(t, http.StatusBadRequest, code)
+}
+
+func Test_getTaskLogChunkFromStore(t *testing.T) {
+	ctx := context.Background()
+	ds := inmemory.NewInMemoryDatastore()
+	tk := &tork.Task{ID: uuid.NewUUID()}
+	assert.NoError(t, ds.CreateTask(ctx, tk))
+	logs, err := logstore.NewFileStore(t.TempDir())
+	assert.NoError(t, err)
+	assert.NoError(t, logs.Append(ctx, &tork.TaskLogPart{TaskID: tk.ID, Number: 1, Contents: "from the store\n"}))
+	api, err := NewAPI(Config{
+		DataStore: ds,
+		Broker:    mq.NewInMemoryBroker(),
+		Logs:      logs,
+	})
+	assert.NoError(t, err)
+
+	req, err := http.NewRequest("GET", fmt.Sprintf("/tasks/%s/log?offset=5", tk.ID), nil)
+	assert.NoError(t, err)
+	w := httptest.NewRecorder()
+	api.server.Handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	chunk := TaskLogChunk{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &chunk))
+	assert.Equal(t, "the store\n", chunk.Contents)
+
+	req, err = http.NewRequest("GET", "/tasks/no-such-task/log?offset=0", nil)
+	assert.NoError(t, err)
+	w = httptest.NewRecorder()
+	api.server.Handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func Test_getLogFromStore(t *testing.T) {
+	ctx := context.Background()
+	ds := inmemory.NewInMemoryDatastore()
+	j := &tork.Job{ID: uuid.NewUUID(), State: tork.JobStateRunning}
+	assert.NoError(t, ds.CreateJob(ctx, j))
+	tk := &tork.Task{ID: uuid.NewUUID(), JobID: j.ID, Position: 1, State: tork.TaskStateRunning}
+	assert.NoError(t, ds.CreateTask(ctx, tk))
+	logs, err := logstore.NewFileStore(t.TempDir())
+	assert.NoError(t, err)
+	assert.NoError(t, logs.Append(ctx, &tork.TaskLogPart{TaskID: tk.ID, Number: 1, Contents: "line 1\n"}))
+	assert.NoError(t, logs.Append(ctx, &tork.TaskLogPart{TaskID: tk.ID, Number: 2, Contents: "line 2\n"}))
+	api, err := NewAPI(Config{
+		DataStore: ds,
+		Broker:    mq.NewInMemoryBroker(),
+		Logs:      logs,
+	})
+	assert.NoError(t, err)
+
+	for _, path := range []string{"/tasks/" + tk.ID + "/log", "/jobs/" + j.ID + "/log"} {
+		req, err := http.NewRequest("GET", path+"?size=1", nil)
+		assert.NoError(t, err)
+		w := httptest.NewRecorder()
+		api.server.Handler.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+		page := datastore.Page[*tork.TaskLogPart]{}
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &page))
+		assert.Equal(t, 2, page.TotalItems)
+		assert.Equal(t, 2, page.TotalPages)
+		assert.Len(t, page.Items, 1)
+		assert.Equal(t, "line 2\n", page.Items[0].Contents)
+	}
+
+	// the readers of the event stream and of the web socket
+	jj, err := ds.GetJobByID(ctx, j.ID)
+	assert.NoError(t, err)
+	parts, err := api.jobLogParts(ctx, jj)
+	assert.NoError(t, err)
+	assert.Len(t, parts, 2)
+	assert.Equal(t, 1, parts[0].Number)
+	parts, err = api.newTaskLogParts(ctx, tk.ID, 1)
+	assert.NoError(t, err)
+	assert.Len(t, parts, 1)
+	assert.Equal(t, "line 2\n", parts[0].Contents)
+}
+
 func Test_createJob(t *testing.T) {
 	api, err := NewAPI(Config{
 		DataStore: inmemory.NewInMemoryDatastore(),
@@ -270,6 +645,73 @@ func Test_createJob(t *testing.T) {
 	assert.Equal(t, http.StatusOK, w.Code)
 }
 
+func Test_createJobIdempotencyKey(t *testing.T) {
+	ctx := context.Background()
+	ds := inmemory.NewInMemoryDatastore()
+	u := &tork.User{
+		ID:       uuid.NewUUID(),
+		Username: "someuser",
+	}
+	assert.NoError(t, ds.CreateUser(ctx, u))
+	api, err := NewAPI(Config{
+		DataStore: ds,
+		Broker:    mq.NewInMemoryBroker(),
+		Middleware: Middleware{
+			Echo: []echo.MiddlewareFunc{func(next echo.HandlerFunc) echo.HandlerFunc {
+				return func(c echo.Context) error {
+					if username := c.Request().Header.Get("X-Username"); username != "" {
+						c.SetRequest(c.Request().WithContext(context.WithValue(c.Request().Context(), tork.USERNAME, username)))
+					}
+					return next(c)
+				}
+			}},
+		},
+	})
+	assert.NoError(t, err)
+	assert.NotNil(t, api)
+	submit := func(username, key, name string) (int, tork.Job) {
+		req, err := http.NewRequest("POST", "/jobs", strings.NewReader(`{
+			"name":"`+name+`",
+			"tasks":[{
+				"name":"test task",
+				"image":"some:image"
+			}]
+		}`))
+		assert.NoError(t, err)
+		req.Header.Add("Content-Type", "application/json")
+		req.Header.Add("Idempotency-Key", key)
+		req.Header.Add("X-Username", username)
+		w := httptest.NewRecorder()
+		api.server.Handler.ServeHTTP(w, req)
+		j := tork.Job{}
+		if w.Code == http.StatusOK {
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &j))
+		}
+		return w.Code, j
+	}
+	code, j1 := submit("", "key-1", "test job")
+	assert.Equal(t, http.StatusOK, code)
+	code, j2 := submit("", "key-1", "test job")
+	assert.Equal(t, http.StatusOK, code)
+	code, j3 := submit("", "key-2", "test job")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, j1.ID, j2.ID)
+	assert.NotEqual(t, j1.ID, j3.ID)
+
+	// the key can't be reused for a different job
+	code, _ = submit("", "key-1", "other job")
+	assert.Equal(t, http.StatusUnprocessableEntity, code)
+
+	// keys are scoped to the user
+	code, j4 := submit("someuser", "key-1", "test job")
+	assert.Equal(t, http.StatusOK, code)
+	assert.NotEqual(t, j1.ID, j4.ID)
+
+	j5, err := ds.GetJobByIdempotencyKey(ctx, u.ID, "key-1")
+	assert.NoError(t, err)
+	assert.Equal(t, j4.ID, j5.ID)
+}
+
 func Test_createJobInvalidProperty(t *testing.T) {
 	api, err := NewAPI(Config{
 		DataStore: inmemory.NewInMemoryDatastore(),
@@ -290,6 +732,191 @@ func Test_createJobInvalidProperty(t *testing.T) {
 	assert.Equal(t, http.StatusBadRequest, w.Code)
 }
 
+func Test_createJobValidationError(t *testing.T) {
+	api, err := NewAPI(Config{
+		DataStore: inmemory.NewInMemoryDatastore(),
+		Broker:    mq.NewInMemoryBroker(),
+	})
+	assert.NoError(t, err)
+	assert.NotNil(t, api)
+	req, err := http.NewRequest("POST", "/jobs", strings.NewReader(`
+name: test job
+tasks:
+  - name: test task
+    image: some:image
+    timeout: forever
+`))
+	req.Header.Add("Content-Type", "text/yaml")
+	assert.NoError(t, err)
+	w := httptest.NewRecorder()
+	api.server.Handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	body, err := io.ReadAll(w.Body)
+	assert.NoError(t, err)
+	assert.Contains(t, string(body), "tasks[0].timeout is not a valid duration")
+}
+
+func Test_createJobImagePolicy(t *testing.T) {
+	api, err := NewAPI(Config{
+		DataStore:   inmemory.NewInMemoryDatastore(),
+		Broker:      mq.NewInMemoryBroker(),
+		ImagePolicy: runtime.ImagePolicy{Allow: []string{"docker.io/library/*"}},
+	})
+	assert.NoError(t, err)
+	assert.NotNil(t, api)
+	req, err := http.NewRequest("POST", "/jobs", strings.NewReader(`
+name: test job
+tasks:
+  - name: test task
+    image: ubuntu:mantic
+  - name: parallel task
+    parallel:
+      tasks:
+        - name: nested task
+          image: evil/miner
+`))
+	req.Header.Add("Content-Type", "text/yaml")
+	assert.NoError(t, err)
+	w := httptest.NewRecorder()
+	api.server.Handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	body, err := io.ReadAll(w.Body)
+	assert.NoError(t, err)
+	assert.Contains(t, string(body), "image evil/miner is not allowed")
+}
+
+func Test_namespaces(t *testing.T) {
+	ctx := context.Background()
+	ds := inmemory.NewInMemoryDatastore()
+	alice := &tork.User{ID: uuid.NewUUID(), Username: "alice"}
+	assert.NoError(t, ds.CreateUser(ctx, alice))
+	bob := &tork.User{ID: uuid.NewUUID(), Username: "bob"}
+	assert.NoError(t, ds.CreateUser(ctx, bob))
+	carol := &tork.User{ID: uuid.NewUUID(), Username: "carol"}
+	assert.NoError(t, ds.CreateUser(ctx, carol))
+	admin := &tork.User{ID: uuid.NewUUID(), Username: "theadmin"}
+	assert.NoError(t, ds.CreateUser(ctx, admin))
+	teamB := &tork.Role{ID: uuid.NewUUID(), Slug: "team-b", Name: "Team B"}
+	assert.NoError(t, ds.CreateRole(ctx, teamB))
+	assert.NoError(t, ds.AssignRole(ctx, carol.ID, teamB.ID))
+	roles, err := ds.GetRoles(ctx)
+	assert.NoError(t, err)
+	for _, r := range roles {
+		if r.Slug == tork.ROLE_ADMIN {
+			assert.NoError(t, ds.AssignRole(ctx, admin.ID, r.ID))
+		}
+	}
+	api, err := NewAPI(Config{
+		DataStore: ds,
+		Broker:    mq.NewInMemoryBroker(),
+		Namespaces: []*tork.Namespace{{
+			Name:          "team-a",
+			Users:         []string{"alice"},
+			Queues:        []string{"team-a"},
+			MaxActiveJobs: 1,
+			Defaults:      &tork.JobDefaults{Queue: "team-a", Timeout: "10m"},
+		}, {
+			Name:  "team-b",
+			Roles: []string{"team-b"},
+		}},
+		Middleware: Middleware{
+			Echo: []echo.MiddlewareFunc{func(next echo.HandlerFunc) echo.HandlerFunc {
+				return func(c echo.Context) error {
+					c.SetRequest(c.Request().WithContext(context.WithValue(c.Request().Context(), tork.USERNAME, c.Request().Header.Get("X-Username"))))
+					return next(c)
+				}
+			}},
+		},
+	})
+	assert.NoError(t, err)
+	do := func(username, method, path, body string) *httptest.ResponseRecorder {
+		var r io.Reader
+		if body != "" {
+			r = strings.NewReader(body)
+		}
+		req, err := http.NewRequest(method, path, r)
+		assert.NoError(t, err)
+		req.Header.Add("Content-Type", "application/json")
+		req.Header.Add("X-Username", username)
+		w := httptest.NewRecorder()
+		api.server.Handler.ServeHTTP(w, req)
+		return w
+	}
+	submit := func(username, namespace, queue string) *httptest.ResponseRecorder {
+		return do(username, "POST", "/jobs", `{
+			"name":"test job",
+			"namespace":"`+namespace+`",
+			"tasks":[{
+				"name":"test task",
+				"image":"some:image",
+				"queue":"`+queue+`"
+			}]
+		}`)
+	}
+
+	w := submit("alice", "team-a", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	js := tork.JobSummary{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &js))
+	assert.Equal(t, "team-a", js.Namespace)
+	ja, err := ds.GetJobByID(ctx, js.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, "team-a", ja.Defaults.Queue)
+	assert.Equal(t, "10m", ja.Defaults.Timeout)
+
+	// the queue isn't allowed in the namespace
+	w = submit("alice", "team-a", "default")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// the namespace has reached its quota
+	w = submit("alice", "team-a", "")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+
+	// not a member
+	w = submit("bob", "team-a", "")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = submit("bob", "no-such-namespace", "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// a member through a role
+	w = submit("carol", "team-b", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// everyone may use the default namespace
+	w = submit("bob", "", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &js))
+	assert.Equal(t, tork.NAMESPACE_DEFAULT, js.Namespace)
+
+	// jobs are only listed to the members of their namespace
+	for username, expected := range map[string]int{"alice": 2, "bob": 1, "carol": 2, "theadmin": 3} {
+		w = do(username, "GET", "/jobs", "")
+		assert.Equal(t, http.StatusOK, w.Code)
+		page := datastore.Page[*tork.JobSummary]{}
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &page))
+		assert.Equal(t, expected, page.TotalItems, username)
+	}
+
+	// filtering by namespace doesn't reveal the jobs of others
+	for username, expected := range map[string]int{"alice": 1, "bob": 0} {
+		w = do(username, "GET", "/jobs?namespace=team-a", "")
+		assert.Equal(t, http.StatusOK, w.Code)
+		page := datastore.Page[*tork.JobSummary]{}
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &page))
+		assert.Equal(t, expected, page.TotalItems, username)
+	}
+
+	tk := &tork.Task{ID: uuid.NewUUID(), JobID: ja.ID, State: tork.TaskStatePending}
+	assert.NoError(t, ds.CreateTask(ctx, tk))
+	for _, path := range []string{"/jobs/" + ja.ID, "/jobs/" + ja.ID + "/log", "/tasks/" + tk.ID} {
+		assert.Equal(t, http.StatusOK, do("alice", "GET", path, "").Code, path)
+		assert.Equal(t, http.StatusOK, do("theadmin", "GET", path, "").Code, path)
+		assert.Equal(t, http.StatusNotFound, do("bob", "GET", path, "").Code, path)
+	}
+	assert.Equal(t, http.StatusNotFound, do("bob", "PUT", "/jobs/"+ja.ID+"/cancel", "").Code)
+}
+
 func Test_getJob(t *testing.T) {
 	ds := inmemory.NewInMemoryDatastore()
 	err := ds.CreateJob(context.Background(), &tork.Job{
@@ -848,25 +1475,67 @@ func Test_customEndpointBind(t *testing.T) {
 	assert.Equal(t, "Me", string(body))
 }
 
-func Test_disableEndpoint(t *testing.T) {
+func Test_debugState(t *testing.T) {
+	b := mq.NewInMemoryBroker()
+	err := b.PublishTask(context.Background(), "some-queue", &tork.Task{ID: uuid.NewUUID()})
+	assert.NoError(t, err)
 	api, err := NewAPI(Config{
 		DataStore: inmemory.NewInMemoryDatastore(),
-		Broker:    mq.NewInMemoryBroker(),
-		Enabled:   map[string]bool{"health": false},
+		Broker:    b,
+		Debug:     true,
 	})
 	assert.NoError(t, err)
-	assert.NotNil(t, api)
-	req, err := http.NewRequest("GET", "/health", nil)
+	req, err := http.NewRequest("GET", "/debug/state", nil)
 	assert.NoError(t, err)
 	w := httptest.NewRecorder()
 	api.server.Handler.ServeHTTP(w, req)
-	_, err = io.ReadAll(w.Body)
+	assert.Equal(t, http.StatusOK, w.Code)
+	state := debugState{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &state))
+	assert.Greater(t, state.Goroutines, 0)
+	assert.Contains(t, state.Queues, mq.QueueInfo{Name: "some-queue", Size: 1})
 
+	req, err = http.NewRequest("GET", "/debug/pprof/goroutine?debug=1", nil)
 	assert.NoError(t, err)
-	assert.Equal(t, http.StatusNotFound, w.Code)
+	w = httptest.NewRecorder()
+	api.server.Handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
 }
 
-func TestShutdown(t *testing.T) {
+func Test_debugStateDisabled(t *testing.T) {
+	api, err := NewAPI(Config{
+		DataStore: inmemory.NewInMemoryDatastore(),
+		Broker:    mq.NewInMemoryBroker(),
+	})
+	assert.NoError(t, err)
+	for _, path := range []string{"/debug/state", "/debug/pprof/heap"} {
+		req, err := http.NewRequest("GET", path, nil)
+		assert.NoError(t, err)
+		w := httptest.NewRecorder()
+		api.server.Handler.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	}
+}
+
+func Test_disableEndpoint(t *testing.T) {
+	api, err := NewAPI(Config{
+		DataStore: inmemory.NewInMemoryDatastore(),
+		Broker:    mq.NewInMemoryBroker(),
+		Enabled:   map[string]bool{"health": false},
+	})
+	assert.NoError(t, err)
+	assert.NotNil(t, api)
+	req, err := http.NewRequest("GET", "/health", nil)
+	assert.NoError(t, err)
+	w := httptest.NewRecorder()
+	api.server.Handler.ServeHTTP(w, req)
+	_, err = io.ReadAll(w.Body)
+
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestShutdown(t *testing.T) {
 	mw := func(next web.HandlerFunc) web.HandlerFunc {
 		return func(c web.Context) error {
 			select {
@@ -1054,3 +1723,694 @@ func Test_proxyTaskSomePath(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, http.StatusOK, w.Code)
 }
+
+func Test_streamJobEvents(t *testing.T) {
+	ctx := context.Background()
+	ds := inmemory.NewInMemoryDatastore()
+	j1 := &tork.Job{
+		ID:    uuid.NewUUID(),
+		State: tork.JobStateRunning,
+	}
+	err := ds.CreateJob(ctx, j1)
+	assert.NoError(t, err)
+	tk := &tork.Task{
+		ID:    uuid.NewUUID(),
+		JobID: j1.ID,
+	}
+	err = ds.CreateTask(ctx, tk)
+	assert.NoError(t, err)
+	err = ds.CreateTaskLogPart(ctx, &tork.TaskLogPart{
+		TaskID:   tk.ID,
+		Number:   1,
+		Contents: "hello world",
+	})
+	assert.NoError(t, err)
+	api, err := NewAPI(Config{
+		DataStore: ds,
+		Broker:    mq.NewInMemoryBroker(),
+	})
+	assert.NoError(t, err)
+	assert.NotNil(t, api)
+
+	go func() {
+		time.Sleep(time.Millisecond * 100)
+		err := ds.UpdateJob(ctx, j1.ID, func(u *tork.Job) error {
+			u.State = tork.JobStateCompleted
+			return nil
+		})
+		assert.NoError(t, err)
+	}()
+
+	eventsPollInterval = time.Millisecond * 50
+	req, err := http.NewRequest("GET", fmt.Sprintf("/jobs/%s/events", j1.ID), nil)
+	assert.NoError(t, err)
+	w := httptest.NewRecorder()
+	api.server.Handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "text/event-stream", w.Header().Get("Content-Type"))
+	body, err := io.ReadAll(w.Body)
+	assert.NoError(t, err)
+	assert.Contains(t, string(body), "event: log\ndata: {")
+	assert.Contains(t, string(body), "hello world")
+	assert.Contains(t, string(body), `"state":"RUNNING"`)
+	assert.Contains(t, string(body), `"state":"COMPLETED"`)
+}
+
+func Test_streamJobEventsAllLogParts(t *testing.T) {
+	ctx := context.Background()
+	ds := inmemory.NewInMemoryDatastore()
+	j1 := &tork.Job{
+		ID:    uuid.NewUUID(),
+		State: tork.JobStateRunning,
+	}
+	err := ds.CreateJob(ctx, j1)
+	assert.NoError(t, err)
+	tk := &tork.Task{
+		ID:    uuid.NewUUID(),
+		JobID: j1.ID,
+		State: tork.TaskStateRunning,
+	}
+	err = ds.CreateTask(ctx, tk)
+	assert.NoError(t, err)
+	// more than a page of parts
+	for i := 1; i <= 250; i++ {
+		err = ds.CreateTaskLogPart(ctx, &tork.TaskLogPart{
+			TaskID:   tk.ID,
+			Number:   i,
+			Contents: fmt.Sprintf("line-%d|", i),
+		})
+		assert.NoError(t, err)
+	}
+	api, err := NewAPI(Config{
+		DataStore: ds,
+		Broker:    mq.NewInMemoryBroker(),
+	})
+	assert.NoError(t, err)
+
+	go func() {
+		time.Sleep(time.Millisecond * 100)
+		for i := 251; i <= 400; i++ {
+			err := ds.CreateTaskLogPart(ctx, &tork.TaskLogPart{
+				TaskID:   tk.ID,
+				Number:   i,
+				Contents: fmt.Sprintf("line-%d|", i),
+			})
+			assert.NoError(t, err)
+		}
+		err := ds.UpdateTask(ctx, tk.ID, func(u *tork.Task) error {
+			u.State = tork.TaskStateCompleted
+			return nil
+		})
+		assert.NoError(t, err)
+		// shipped after the task has finished
+		err = ds.CreateTaskLogPart(ctx, &tork.TaskLogPart{
+			TaskID:   tk.ID,
+			Number:   401,
+			Contents: "line-401|",
+		})
+		assert.NoError(t, err)
+		time.Sleep(time.Millisecond * 100)
+		err = ds.UpdateJob(ctx, j1.ID, func(u *tork.Job) error {
+			u.State = tork.JobStateCompleted
+			return nil
+		})
+		assert.NoError(t, err)
+	}()
+
+	eventsPollInterval = time.Millisecond * 50
+	req, err := http.NewRequest("GET", fmt.Sprintf("/jobs/%s/events", j1.ID), nil)
+	assert.NoError(t, err)
+	w := httptest.NewRecorder()
+	api.server.Handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	body := w.Body.String()
+	assert.Equal(t, 401, strings.Count(body, "event: log\n"))
+	prev := -1
+	for i := 1; i <= 401; i++ {
+		idx := strings.Index(body, fmt.Sprintf("line-%d|", i))
+		assert.Greater(t, idx, prev, "line %d", i)
+		prev = idx
+	}
+}
+
+func Test_streamJobEventsShortTasks(t *testing.T) {
+	ctx := context.Background()
+	ds := inmemory.NewInMemoryDatastore()
+	j1 := &tork.Job{
+		ID:    uuid.NewUUID(),
+		State: tork.JobStateRunning,
+	}
+	err := ds.CreateJob(ctx, j1)
+	assert.NoError(t, err)
+	api, err := NewAPI(Config{
+		DataStore: ds,
+		Broker:    mq.NewInMemoryBroker(),
+	})
+	assert.NoError(t, err)
+
+	go func() {
+		time.Sleep(time.Millisecond * 100)
+		// a task which started and finished between two polls
+		tk := &tork.Task{
+			ID:    uuid.NewUUID(),
+			JobID: j1.ID,
+			State: tork.TaskStateCompleted,
+		}
+		err := ds.CreateTask(ctx, tk)
+		assert.NoError(t, err)
+		err = ds.CreateTaskLogPart(ctx, &tork.TaskLogPart{
+			TaskID:   tk.ID,
+			Number:   1,
+			Contents: "short task",
+		})
+		assert.NoError(t, err)
+		time.Sleep(time.Millisecond * 100)
+		err = ds.UpdateJob(ctx, j1.ID, func(u *tork.Job) error {
+			u.State = tork.JobStateCompleted
+			return nil
+		})
+		assert.NoError(t, err)
+		// shipped after the job has finished
+		err = ds.CreateTaskLogPart(ctx, &tork.TaskLogPart{
+			TaskID:   tk.ID,
+			Number:   2,
+			Contents: "late part",
+		})
+		assert.NoError(t, err)
+	}()
+
+	eventsPollInterval = time.Millisecond * 50
+	eventsKeepAliveInterval = time.Millisecond * 10
+	defer func() {
+		eventsKeepAliveInterval = time.Second * 15
+	}()
+	req, err := http.NewRequest("GET", fmt.Sprintf("/jobs/%s/events", j1.ID), nil)
+	assert.NoError(t, err)
+	w := httptest.NewRecorder()
+	api.server.Handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	body := w.Body.String()
+	assert.Contains(t, body, "short task")
+	assert.Contains(t, body, "late part")
+	assert.Contains(t, body, `"state":"COMPLETED"`)
+	assert.Contains(t, body, ": keepalive\n\n")
+}
+
+func Test_tailTaskLog(t *testing.T) {
+	ctx := context.Background()
+	ds := inmemory.NewInMemoryDatastore()
+	tk := &tork.Task{
+		ID:    uuid.NewUUID(),
+		State: tork.TaskStateRunning,
+	}
+	err := ds.CreateTask(ctx, tk)
+	assert.NoError(t, err)
+	for i := 1; i <= 3; i++ {
+		err = ds.CreateTaskLogPart(ctx, &tork.TaskLogPart{
+			TaskID:   tk.ID,
+			Number:   i,
+			Contents: fmt.Sprintf("line %d", i),
+		})
+		assert.NoError(t, err)
+	}
+	api, err := NewAPI(Config{
+		DataStore: ds,
+		Broker:    mq.NewInMemoryBroker(),
+	})
+	assert.NoError(t, err)
+
+	go func() {
+		time.Sleep(time.Millisecond * 100)
+		err := ds.CreateTaskLogPart(ctx, &tork.TaskLogPart{
+			TaskID:   tk.ID,
+			Number:   4,
+			Contents: "line 4",
+		})
+		assert.NoError(t, err)
+		err = ds.UpdateTask(ctx, tk.ID, func(u *tork.Task) error {
+			u.State = tork.TaskStateCompleted
+			return nil
+		})
+		assert.NoError(t, err)
+	}()
+
+	eventsPollInterval = time.Millisecond * 50
+	srv := httptest.NewServer(api.server.Handler)
+	defer srv.Close()
+	url := fmt.Sprintf("ws%s/tasks/%s/log/ws?offset=1", strings.TrimPrefix(srv.URL, "http"), tk.ID)
+	ws, err := websocket.Dial(url, "", srv.URL)
+	assert.NoError(t, err)
+	defer ws.Close()
+
+	contents := make([]string, 0)
+	for {
+		p := tork.TaskLogPart{}
+		if err := websocket.JSON.Receive(ws, &p); err != nil {
+			break
+		}
+		contents = append(contents, p.Contents)
+	}
+	assert.Equal(t, []string{"line 2", "line 3", "line 4"}, contents)
+}
+
+func Test_getOpenAPIDoc(t *testing.T) {
+	api, err := NewAPI(Config{
+		DataStore: inmemory.NewInMemoryDatastore(),
+		Broker:    mq.NewInMemoryBroker(),
+	})
+	assert.NoError(t, err)
+	req, err := http.NewRequest("GET", "/docs/openapi.json", nil)
+	assert.NoError(t, err)
+	w := httptest.NewRecorder()
+	api.server.Handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	doc := map[string]any{}
+	err = json.Unmarshal(w.Body.Bytes(), &doc)
+	assert.NoError(t, err)
+	assert.Equal(t, "3.0.3", doc["openapi"])
+
+	// every endpoint is documented
+	paths, ok := doc["paths"].(map[string]any)
+	assert.True(t, ok)
+	for _, route := range api.server.Handler.(*echo.Echo).Routes() {
+		if strings.HasSuffix(route.Path, "/*") || route.Method == echo.RouteNotFound {
+			continue
+		}
+		path := regexp.MustCompile(`:(\w+)`).ReplaceAllString(route.Path, "{$1}")
+		// the proxy takes any method
+		if route.Method != http.MethodGet && strings.Contains(path, "/proxy/") {
+			continue
+		}
+		item, ok := paths[path].(map[string]any)
+		if !assert.True(t, ok, "undocumented path %s", path) {
+			continue
+		}
+		assert.Contains(t, item, strings.ToLower(route.Method), "undocumented %s %s", route.Method, path)
+	}
+}
+
+func Test_apiKeys(t *testing.T) {
+	ds := inmemory.NewInMemoryDatastore()
+	api, err := NewAPI(Config{
+		DataStore: ds,
+		Broker:    mq.NewInMemoryBroker(),
+	})
+	assert.NoError(t, err)
+
+	req, err := http.NewRequest("POST", "/keys", strings.NewReader(`{"name":"ci"}`))
+	assert.NoError(t, err)
+	req.Header.Add("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	api.server.Handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	k := tork.APIKey{}
+	err = json.Unmarshal(w.Body.Bytes(), &k)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, k.ID)
+	assert.NotEmpty(t, k.Key)
+	assert.Equal(t, "ci", k.Name)
+
+	stored, err := ds.GetAPIKey(context.Background(), hash.Key(k.Key))
+	assert.NoError(t, err)
+	assert.Equal(t, k.ID, stored.ID)
+
+	req, err = http.NewRequest("GET", "/keys", nil)
+	assert.NoError(t, err)
+	w = httptest.NewRecorder()
+	api.server.Handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	keys := []*tork.APIKey{}
+	err = json.Unmarshal(w.Body.Bytes(), &keys)
+	assert.NoError(t, err)
+	assert.Len(t, keys, 1)
+	assert.Empty(t, keys[0].Key)
+
+	req, err = http.NewRequest("DELETE", "/keys/"+k.ID, nil)
+	assert.NoError(t, err)
+	w = httptest.NewRecorder()
+	api.server.Handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	req, err = http.NewRequest("DELETE", "/keys/"+k.ID, nil)
+	assert.NoError(t, err)
+	w = httptest.NewRecorder()
+	api.server.Handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func Test_createAPIKeyCreatedBy(t *testing.T) {
+	ctx := context.Background()
+	ds := inmemory.NewInMemoryDatastore()
+	u := &tork.User{
+		ID:       uuid.NewUUID(),
+		Username: "someuser",
+	}
+	assert.NoError(t, ds.CreateUser(ctx, u))
+	api, err := NewAPI(Config{
+		DataStore: ds,
+		Broker:    mq.NewInMemoryBroker(),
+		Middleware: Middleware{
+			Echo: []echo.MiddlewareFunc{func(next echo.HandlerFunc) echo.HandlerFunc {
+				return func(c echo.Context) error {
+					c.SetRequest(c.Request().WithContext(context.WithValue(c.Request().Context(), tork.USERNAME, "someuser")))
+					return next(c)
+				}
+			}},
+		},
+	})
+	assert.NoError(t, err)
+
+	req, err := http.NewRequest("POST", "/keys", strings.NewReader(`{"name":"ci"}`))
+	assert.NoError(t, err)
+	w := httptest.NewRecorder()
+	api.server.Handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	k := tork.APIKey{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &k))
+	assert.Equal(t, u.ID, k.CreatedBy)
+
+	req, err = http.NewRequest("PUT", "/secrets/db-password", strings.NewReader(`{"value":"shhh"}`))
+	assert.NoError(t, err)
+	w = httptest.NewRecorder()
+	api.server.Handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	secret, err := ds.GetSecret(ctx, "db-password")
+	assert.NoError(t, err)
+	assert.Equal(t, u.ID, secret.CreatedBy)
+}
+
+func Test_createAPIKeyNoName(t *testing.T) {
+	api, err := NewAPI(Config{
+		DataStore: inmemory.NewInMemoryDatastore(),
+		Broker:    mq.NewInMemoryBroker(),
+	})
+	assert.NoError(t, err)
+	req, err := http.NewRequest("POST", "/keys", strings.NewReader(`{}`))
+	assert.NoError(t, err)
+	w := httptest.NewRecorder()
+	api.server.Handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func Test_assignRole(t *testing.T) {
+	ctx := context.Background()
+	ds := inmemory.NewInMemoryDatastore()
+	u := &tork.User{ID: uuid.NewUUID(), Username: uuid.NewShortUUID()}
+	assert.NoError(t, ds.CreateUser(ctx, u))
+	api, err := NewAPI(Config{
+		DataStore: ds,
+		Broker:    mq.NewInMemoryBroker(),
+	})
+	assert.NoError(t, err)
+
+	for i := 0; i < 2; i++ {
+		req, err := http.NewRequest("PUT", "/users/"+u.Username+"/roles/operator", nil)
+		assert.NoError(t, err)
+		w := httptest.NewRecorder()
+		api.server.Handler.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+	}
+	roles, err := ds.GetUserRoles(ctx, u.ID)
+	assert.NoError(t, err)
+	assert.Len(t, roles, 1)
+
+	req, err := http.NewRequest("PUT", "/users/"+u.Username+"/roles/no-such-role", nil)
+	assert.NoError(t, err)
+	w := httptest.NewRecorder()
+	api.server.Handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	req, err = http.NewRequest("DELETE", "/users/"+u.Username+"/roles/operator", nil)
+	assert.NoError(t, err)
+	w = httptest.NewRecorder()
+	api.server.Handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	roles, err = ds.GetUserRoles(ctx, u.ID)
+	assert.NoError(t, err)
+	assert.Len(t, roles, 0)
+}
+
+func Test_scheduledJobsPermissions(t *testing.T) {
+	ctx := context.Background()
+	ds := inmemory.NewInMemoryDatastore()
+	u := &tork.User{ID: uuid.NewUUID(), Username: "someuser"}
+	assert.NoError(t, ds.CreateUser(ctx, u))
+	public := &tork.ScheduledJob{ID: uuid.NewUUID(), Template: &tork.Job{Name: "public"}}
+	assert.NoError(t, ds.CreateScheduledJob(ctx, public))
+	private := &tork.ScheduledJob{ID: uuid.NewUUID(), Template: &tork.Job{
+		Name:        "private",
+		Permissions: []*tork.Permission{{User: &tork.User{Username: "otheruser"}}},
+	}}
+	assert.NoError(t, ds.CreateScheduledJob(ctx, private))
+	api, err := NewAPI(Config{
+		DataStore: ds,
+		Broker:    mq.NewInMemoryBroker(),
+		Middleware: Middleware{
+			Echo: []echo.MiddlewareFunc{func(next echo.HandlerFunc) echo.HandlerFunc {
+				return func(c echo.Context) error {
+					c.SetRequest(c.Request().WithContext(context.WithValue(c.Request().Context(), tork.USERNAME, "someuser")))
+					return next(c)
+				}
+			}},
+		},
+	})
+	assert.NoError(t, err)
+
+	req, err := http.NewRequest("GET", "/scheduled-jobs", nil)
+	assert.NoError(t, err)
+	w := httptest.NewRecorder()
+	api.server.Handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	sjs := []*tork.ScheduledJob{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &sjs))
+	assert.Len(t, sjs, 1)
+	assert.Equal(t, public.ID, sjs[0].ID)
+
+	req, err = http.NewRequest("GET", "/scheduled-jobs/"+private.ID, nil)
+	assert.NoError(t, err)
+	w = httptest.NewRecorder()
+	api.server.Handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func Test_scheduledJobs(t *testing.T) {
+	ds := inmemory.NewInMemoryDatastore()
+	api, err := NewAPI(Config{
+		DataStore: ds,
+		Broker:    mq.NewInMemoryBroker(),
+	})
+	assert.NoError(t, err)
+
+	body := `{
+		"name":"nightly",
+		"cron":"0 0 * * *",
+		"overlap":"queue",
+		"tasks":[{"name":"some task","image":"some:image"}]
+	}`
+	req, err := http.NewRequest("POST", "/scheduled-jobs", strings.NewReader(body))
+	assert.NoError(t, err)
+	req.Header.Add("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	api.server.Handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	sj := tork.ScheduledJob{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &sj))
+	assert.NotEmpty(t, sj.ID)
+	assert.Equal(t, "nightly", sj.Name)
+	assert.Equal(t, tork.ScheduledJobOverlapQueue, sj.Overlap)
+	assert.Equal(t, tork.ScheduledJobStateActive, sj.State)
+	assert.Len(t, sj.Template.Tasks, 1)
+
+	req, err = http.NewRequest("GET", "/scheduled-jobs", nil)
+	assert.NoError(t, err)
+	w = httptest.NewRecorder()
+	api.server.Handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	sjs := []*tork.ScheduledJob{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &sjs))
+	assert.Len(t, sjs, 1)
+
+	req, err = http.NewRequest("PUT", "/scheduled-jobs/"+sj.ID+"/pause", nil)
+	assert.NoError(t, err)
+	w = httptest.NewRecorder()
+	api.server.Handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	req, err = http.NewRequest("PUT", "/scheduled-jobs/"+sj.ID+"/pause", nil)
+	assert.NoError(t, err)
+	w = httptest.NewRecorder()
+	api.server.Handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	req, err = http.NewRequest("PUT", "/scheduled-jobs/"+sj.ID+"/resume", nil)
+	assert.NoError(t, err)
+	w = httptest.NewRecorder()
+	api.server.Handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	req, err = http.NewRequest("GET", "/scheduled-jobs/"+sj.ID, nil)
+	assert.NoError(t, err)
+	w = httptest.NewRecorder()
+	api.server.Handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &sj))
+	assert.Equal(t, tork.ScheduledJobStateActive, sj.State)
+	assert.NotNil(t, sj.LastRunAt)
+
+	req, err = http.NewRequest("DELETE", "/scheduled-jobs/"+sj.ID, nil)
+	assert.NoError(t, err)
+	w = httptest.NewRecorder()
+	api.server.Handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	req, err = http.NewRequest("GET", "/scheduled-jobs/"+sj.ID, nil)
+	assert.NoError(t, err)
+	w = httptest.NewRecorder()
+	api.server.Handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func Test_createScheduledJobInvalidCron(t *testing.T) {
+	api, err := NewAPI(Config{
+		DataStore: inmemory.NewInMemoryDatastore(),
+		Broker:    mq.NewInMemoryBroker(),
+	})
+	assert.NoError(t, err)
+	body := `
+name: nightly
+cron: every night
+tasks:
+  - name: some task
+    image: some:image
+`
+	req, err := http.NewRequest("POST", "/scheduled-jobs", strings.NewReader(body))
+	assert.NoError(t, err)
+	req.Header.Add("Content-Type", "text/yaml")
+	w := httptest.NewRecorder()
+	api.server.Handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "cron is not a valid cron expression")
+}
+
+func Test_secrets(t *testing.T) {
+	ctx := context.Background()
+	ds := inmemory.NewInMemoryDatastore()
+	api, err := NewAPI(Config{
+		DataStore: ds,
+		Broker:    mq.NewInMemoryBroker(),
+	})
+	assert.NoError(t, err)
+
+	req, err := http.NewRequest("PUT", "/secrets/db-password", strings.NewReader(`{"value":"shhh"}`))
+	assert.NoError(t, err)
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	api.server.Handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotContains(t, w.Body.String(), "shhh")
+
+	s, err := ds.GetSecret(ctx, "db-password")
+	assert.NoError(t, err)
+	assert.Equal(t, "shhh", s.Value)
+
+	req, err = http.NewRequest("GET", "/secrets", nil)
+	assert.NoError(t, err)
+	w = httptest.NewRecorder()
+	api.server.Handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	secrets := []*tork.Secret{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &secrets))
+	assert.Len(t, secrets, 1)
+	assert.Equal(t, "db-password", secrets[0].Name)
+	assert.Empty(t, secrets[0].Value)
+
+	req, err = http.NewRequest("DELETE", "/secrets/db-password", nil)
+	assert.NoError(t, err)
+	w = httptest.NewRecorder()
+	api.server.Handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	req, err = http.NewRequest("DELETE", "/secrets/db-password", nil)
+	assert.NoError(t, err)
+	w = httptest.NewRecorder()
+	api.server.Handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func Test_deadLetters(t *testing.T) {
+	ctx := context.Background()
+	ds := inmemory.NewInMemoryDatastore()
+	b := mq.NewInMemoryBroker()
+	api, err := NewAPI(Config{
+		DataStore: ds,
+		Broker:    b,
+	})
+	assert.NoError(t, err)
+
+	dl := &tork.DeadLetter{
+		ID:       uuid.NewUUID(),
+		Queue:    "some-queue",
+		Task:     &tork.Task{ID: uuid.NewUUID()},
+		Error:    "something bad happened",
+		Attempts: 3,
+	}
+	assert.NoError(t, ds.CreateDeadLetter(ctx, dl))
+
+	req, err := http.NewRequest("GET", "/dead-letters", nil)
+	assert.NoError(t, err)
+	w := httptest.NewRecorder()
+	api.server.Handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	p := datastore.Page[*tork.DeadLetter]{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &p))
+	assert.Len(t, p.Items, 1)
+	assert.Equal(t, dl.ID, p.Items[0].ID)
+
+	req, err = http.NewRequest("GET", "/dead-letters/"+dl.ID, nil)
+	assert.NoError(t, err)
+	w = httptest.NewRecorder()
+	api.server.Handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	requeued := make(chan *tork.Task, 1)
+	assert.NoError(t, b.SubscribeForTasks("some-queue", func(t *tork.Task) error {
+		requeued <- t
+		return nil
+	}))
+
+	req, err = http.NewRequest("PUT", "/dead-letters/"+dl.ID+"/requeue", nil)
+	assert.NoError(t, err)
+	w = httptest.NewRecorder()
+	api.server.Handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, dl.Task.ID, (<-requeued).ID)
+
+	_, err = ds.GetDeadLetterByID(ctx, dl.ID)
+	assert.ErrorIs(t, err, datastore.ErrDeadLetterNotFound)
+
+	req, err = http.NewRequest("PUT", "/dead-letters/"+dl.ID+"/requeue", nil)
+	assert.NoError(t, err)
+	w = httptest.NewRecorder()
+	api.server.Handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func Test_setSecretInvalid(t *testing.T) {
+	api, err := NewAPI(Config{
+		DataStore: inmemory.NewInMemoryDatastore(),
+		Broker:    mq.NewInMemoryBroker(),
+	})
+	assert.NoError(t, err)
+
+	req, err := http.NewRequest("PUT", "/secrets/db-password", strings.NewReader(`{}`))
+	assert.NoError(t, err)
+	w := httptest.NewRecorder()
+	api.server.Handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	req, err = http.NewRequest("PUT", "/secrets/bad%20name", strings.NewReader(`{"value":"shhh"}`))
+	assert.NoError(t, err)
+	w = httptest.NewRecorder()
+	api.server.Handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
@@ -0,0 +1,257 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/pkg/errors"
+	"github.com/runabol/tork"
+	"github.com/runabol/tork/datastore"
+	"github.com/runabol/tork/mq"
+)
+
+// member is the user making a request,
+// as far as namespaces are concerned.
+type member struct {
+	username string
+	roles    []*tork.Role
+	// admins, and requests which aren't authenticated,
+	// may use any namespace
+	unrestricted bool
+}
+
+func (m *member) canUse(ns *tork.Namespace) bool {
+	return m.unrestricted || ns.IsMember(m.username, m.roles)
+}
+
+func (s *API) currentMember(ctx context.Context) (*member, error) {
+	cu, ok := ctx.Value(tork.USERNAME).(string)
+	if !ok || cu == "" {
+		return &member{unrestricted: true}, nil
+	}
+	u, err := s.ds.GetUser(ctx, cu)
+	if err != nil {
+		if errors.Is(err, datastore.ErrUserNotFound) {
+			return &member{username: cu}, nil
+		}
+		return nil, err
+	}
+	roles, err := s.ds.GetUserRoles(ctx, u.ID)
+	if err != nil {
+		return nil, err
+	}
+	m := &member{username: u.Username, roles: roles}
+	for _, r := range roles {
+		if r.Slug == tork.ROLE_ADMIN {
+			m.unrestricted = true
+		}
+	}
+	return m, nil
+}
+
+// namespace returns the namespace with the name. An empty
+// name is the default namespace, which is open to everyone
+// unless it's configured.
+func (s *API) namespace(name string) (*tork.Namespace, error) {
+	if name == "" {
+		name = tork.NAMESPACE_DEFAULT
+	}
+	if ns, ok := s.namespaces[name]; ok {
+		return ns, nil
+	}
+	if name == tork.NAMESPACE_DEFAULT {
+		return &tork.Namespace{Name: name}, nil
+	}
+	return nil, errors.Wrapf(ErrNamespaceNotFound, "unknown namespace: %s", name)
+}
+
+// canUseNamespace reports whether the user making the
+// request may see the jobs of the namespace.
+func (s *API) canUseNamespace(ctx context.Context, name string) (bool, error) {
+	if len(s.namespaces) == 0 {
+		return true, nil
+	}
+	m, err := s.currentMember(ctx)
+	if err != nil {
+		return false, err
+	}
+	if m.unrestricted {
+		return true, nil
+	}
+	ns, err := s.namespace(name)
+	if err != nil {
+		// the namespace was removed from the config
+		return false, nil
+	}
+	return m.canUse(ns), nil
+}
+
+// memberNamespaces returns the namespaces the user making
+// the request may see the jobs of, or nil when they may
+// see the jobs of every namespace.
+func (s *API) memberNamespaces(ctx context.Context) ([]string, error) {
+	if len(s.namespaces) == 0 {
+		return nil, nil
+	}
+	m, err := s.currentMember(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if m.unrestricted {
+		return nil, nil
+	}
+	result := make([]string, 0)
+	if _, ok := s.namespaces[tork.NAMESPACE_DEFAULT]; !ok {
+		result = append(result, tork.NAMESPACE_DEFAULT)
+	}
+	for name, ns := range s.namespaces {
+		if m.canUse(ns) {
+			result = append(result, name)
+		}
+	}
+	sort.Strings(result)
+	return result, nil
+}
+
+// admitToNamespace checks that the user making the request may
+// submit the job to its namespace and that the namespace allows
+// the queues of its tasks, filling in the namespace's defaults.
+func (s *API) admitToNamespace(ctx context.Context, j *tork.Job) (*tork.Namespace, error) {
+	ns, err := s.namespace(j.Namespace)
+	if err != nil {
+		return nil, err
+	}
+	m, err := s.currentMember(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !m.canUse(ns) {
+		return nil, errors.Wrapf(ErrNamespaceForbidden, "not a member of namespace %s", ns.Name)
+	}
+	j.Namespace = ns.Name
+	ns.ApplyDefaults(j)
+	defaultQueue := mq.QUEUE_DEFAULT
+	if j.Defaults != nil && j.Defaults.Queue != "" {
+		defaultQueue = j.Defaults.Queue
+	}
+	if err := checkQueues(ns, defaultQueue, j.Tasks); err != nil {
+		return nil, err
+	}
+	return ns, nil
+}
+
+// checkQueues verifies that the namespace allows the queues of
+// the tasks. Queues that are expressions are evaluated later
+// and are left unchecked.
+func checkQueues(ns *tork.Namespace, defaultQueue string, tasks []*tork.Task) error {
+	for _, t := range tasks {
+		queue := t.Queue
+		if queue == "" {
+			queue = defaultQueue
+		}
+		if !strings.Contains(queue, "{{") && !ns.AllowsQueue(queue) {
+			return errors.Errorf("the tasks of namespace %s may not run on queue %s", ns.Name, queue)
+		}
+		if t.Parallel != nil {
+			if err := checkQueues(ns, defaultQueue, t.Parallel.Tasks); err != nil {
+				return err
+			}
+		}
+		if t.Each != nil && t.Each.Task != nil {
+			if err := checkQueues(ns, defaultQueue, []*tork.Task{t.Each.Task}); err != nil {
+				return err
+			}
+		}
+		// subjobs don't inherit the defaults of their parent
+		if t.SubJob != nil {
+			if err := checkQueues(ns, mq.QUEUE_DEFAULT, t.SubJob.Tasks); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// checkNamespaceQuota verifies that the namespace has room for
+// another active job. The jobs are counted before the new one is
+// created, so concurrent submissions may briefly exceed the quota.
+func (s *API) checkNamespaceQuota(ctx context.Context, ns *tork.Namespace) error {
+	if ns.MaxActiveJobs <= 0 {
+		return nil
+	}
+	active, err := s.ds.ListJobs(ctx, "", datastore.JobQuery{
+		Namespaces: []string{ns.Name},
+		States: []tork.JobState{
+			tork.JobStatePending,
+			tork.JobStateScheduled,
+			tork.JobStateRunning,
+			tork.JobStateRestart,
+		},
+		Page: 1,
+		Size: 1,
+	})
+	if err != nil {
+		return errors.Wrapf(err, "error counting the active jobs of namespace %s", ns.Name)
+	}
+	if active.TotalItems >= ns.MaxActiveJobs {
+		return errors.Wrapf(ErrNamespaceQuotaExceeded, "namespace %s has %d active jobs", ns.Name, active.TotalItems)
+	}
+	return nil
+}
+
+// inNamespace hides the resources of the namespaces the user making
+// the request isn't a member of. namespaceOf looks up the namespace
+// of the resource with the id of the path.
+func (s *API) inNamespace(namespaceOf func(ctx context.Context, id string) (string, error)) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if len(s.namespaces) == 0 {
+				return next(c)
+			}
+			ctx := c.Request().Context()
+			name, err := namespaceOf(ctx, c.Param("id"))
+			if err != nil {
+				// left to the handler to report
+				return next(c)
+			}
+			ok, err := s.canUseNamespace(ctx, name)
+			if err != nil {
+				return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+			}
+			if !ok {
+				return echo.ErrNotFound
+			}
+			return next(c)
+		}
+	}
+}
+
+func (s *API) jobNamespace(ctx context.Context, id string) (string, error) {
+	j, err := s.ds.GetJobByID(ctx, id)
+	if err != nil {
+		return "", err
+	}
+	return j.Namespace, nil
+}
+
+func (s *API) taskNamespace(ctx context.Context, id string) (string, error) {
+	t, err := s.ds.GetTaskByID(ctx, id)
+	if err != nil {
+		return "", err
+	}
+	return s.jobNamespace(ctx, t.JobID)
+}
+
+func (s *API) scheduledJobNamespace(ctx context.Context, id string) (string, error) {
+	sj, err := s.ds.GetScheduledJobByID(ctx, id)
+	if err != nil {
+		return "", err
+	}
+	if sj.Template == nil {
+		return "", nil
+	}
+	return sj.Template.Namespace, nil
+}
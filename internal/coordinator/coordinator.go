@@ -3,6 +3,7 @@ package coordinator
 import (
 	"context"
 	"os"
+	"sync/atomic"
 	"time"
 
 	"github.com/labstack/echo/v4"
@@ -10,18 +11,22 @@ import (
 	"github.com/rs/zerolog/log"
 
 	"github.com/runabol/tork"
+	"github.com/runabol/tork/artifact"
 	"github.com/runabol/tork/datastore"
 	"github.com/runabol/tork/internal/coordinator/api"
 	"github.com/runabol/tork/internal/coordinator/handlers"
 	"github.com/runabol/tork/internal/host"
+	"github.com/runabol/tork/internal/redact"
 
 	"github.com/runabol/tork/input"
+	"github.com/runabol/tork/logstore"
 	"github.com/runabol/tork/middleware/job"
 	"github.com/runabol/tork/middleware/node"
 	"github.com/runabol/tork/middleware/task"
 	"github.com/runabol/tork/middleware/web"
 
 	"github.com/runabol/tork/mq"
+	"github.com/runabol/tork/runtime"
 
 	"github.com/runabol/tork/internal/uuid"
 )
@@ -46,6 +51,9 @@ type Coordinator struct {
 	onLogPart   func(*tork.TaskLogPart)
 	onProgress  task.HandlerFunc
 	stop        chan any
+	stallAfter  time.Duration
+	leaseTTL    time.Duration
+	leader      atomic.Bool
 }
 
 type Config struct {
@@ -57,6 +65,30 @@ type Config struct {
 	Endpoints  map[string]web.HandlerFunc
 	Enabled    map[string]bool
 	Middleware Middleware
+	// Redacter, when set, masks secret values in task logs
+	// and in the results fetched from the artifact store.
+	Redacter *redact.Redacter
+	// StalledTaskTimeout is how long a worker node may go without
+	// sending a heartbeat before its running tasks are failed.
+	StalledTaskTimeout time.Duration
+	// Artifacts, when set, is used to fetch task results
+	// that were spilled to the artifact store.
+	Artifacts artifact.Store
+	// Logs, when set, is where the logs of the tasks are
+	// stored instead of the datastore.
+	Logs logstore.Store
+	// ImagePolicy restricts the images
+	// that submitted tasks may use.
+	ImagePolicy runtime.ImagePolicy
+	// Namespaces isolate the jobs of the
+	// teams sharing the deployment.
+	Namespaces []*tork.Namespace
+	// LeaseTTL is how long the leader lease is held without
+	// being renewed before another coordinator takes over.
+	LeaseTTL time.Duration
+	// Debug exposes the pprof profiles
+	// and the /debug/state endpoint.
+	Debug bool
 }
 
 type Middleware struct {
@@ -77,6 +109,12 @@ func NewCoordinator(cfg Config) (*Coordinator, error) {
 	if cfg.Queues == nil {
 		cfg.Queues = make(map[string]int)
 	}
+	if cfg.StalledTaskTimeout == 0 {
+		cfg.StalledTaskTimeout = tork.LAST_HEARTBEAT_TIMEOUT
+	}
+	if cfg.LeaseTTL == 0 {
+		cfg.LeaseTTL = defaultLeaseTTL
+	}
 	if cfg.Endpoints == nil {
 		cfg.Endpoints = make(map[string]web.HandlerFunc)
 	}
@@ -107,6 +145,9 @@ func NewCoordinator(cfg Config) (*Coordinator, error) {
 	if cfg.Queues[mq.QUEUE_PROGRESS] < 1 {
 		cfg.Queues[mq.QUEUE_PROGRESS] = 1
 	}
+	if cfg.Queues[mq.QUEUE_DEAD_LETTER] < 1 {
+		cfg.Queues[mq.QUEUE_DEAD_LETTER] = 1
+	}
 	api, err := api.NewAPI(api.Config{
 		Broker:    cfg.Broker,
 		DataStore: cfg.DataStore,
@@ -117,8 +158,14 @@ func NewCoordinator(cfg Config) (*Coordinator, error) {
 			Job:  cfg.Middleware.Job,
 			Task: cfg.Middleware.Task,
 		},
-		Endpoints: cfg.Endpoints,
-		Enabled:   cfg.Enabled,
+		Endpoints:   cfg.Endpoints,
+		Enabled:     cfg.Enabled,
+		Artifacts:   cfg.Artifacts,
+		Logs:        cfg.Logs,
+		Redacter:    cfg.Redacter,
+		ImagePolicy: cfg.ImagePolicy,
+		Namespaces:  cfg.Namespaces,
+		Debug:       cfg.Debug,
 	})
 	if err != nil {
 		return nil, err
@@ -170,7 +217,7 @@ func NewCoordinator(cfg Config) (*Coordinator, error) {
 		cfg.Middleware.Node,
 	)
 
-	onLogPart := handlers.NewLogHandler(cfg.DataStore)
+	onLogPart := handlers.NewLogHandler(cfg.DataStore, cfg.Logs, cfg.Redacter)
 
 	onProgress := task.ApplyMiddleware(
 		handlers.NewProgressHandler(
@@ -197,6 +244,8 @@ func NewCoordinator(cfg Config) (*Coordinator, error) {
 		onLogPart:   onLogPart,
 		onProgress:  onProgress,
 		stop:        make(chan any),
+		stallAfter:  cfg.StalledTaskTimeout,
+		leaseTTL:    cfg.LeaseTTL,
 	}, nil
 }
 
@@ -256,13 +305,27 @@ func (c *Coordinator) Start() error {
 				err = c.broker.SubscribeForTaskProgress(func(t *tork.Task) error {
 					return progressHandler(context.Background(), task.Progress, t)
 				})
+			case mq.QUEUE_DEAD_LETTER:
+				err = c.broker.SubscribeForDeadLetters(func(dl *tork.DeadLetter) error {
+					log.Warn().
+						Str("queue", dl.Queue).
+						Int("attempts", dl.Attempts).
+						Msgf("dead-lettered task %s: %s", dl.Task.ID, dl.Error)
+					return c.ds.CreateDeadLetter(context.Background(), dl)
+				})
 			}
 			if err != nil {
 				return err
 			}
 		}
 	}
+	c.renewLeadership(context.Background())
+	go c.electLeader()
 	go c.sendHeartbeats()
+	go c.runScheduledJobs()
+	go c.failStalledTasks()
+	go c.failTimedOutJobs()
+	go c.publishDueRetries()
 	return nil
 }
 
@@ -300,6 +363,12 @@ func (c *Coordinator) Stop() error {
 	close(c.stop)
 	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
 	defer cancel()
+	// let another coordinator take over without waiting for the lease to expire
+	if c.leader.Load() {
+		if err := c.ds.ReleaseLease(ctx, leaderLease, c.id); err != nil {
+			log.Error().Err(err).Msgf("error releasing the leader lease")
+		}
+	}
 	if err := c.broker.Shutdown(ctx); err != nil {
 		return errors.Wrapf(err, "error shutting down broker")
 	}
@@ -312,6 +381,12 @@ func (c *Coordinator) Stop() error {
 func (c *Coordinator) sendHeartbeats() {
 	for {
 		status := tork.NodeStatusUP
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+		if err := c.broker.HealthCheck(ctx); err != nil {
+			log.Warn().Err(err).Msgf("coordinator %s broker connection is degraded", c.id)
+			status = tork.NodeStatusDegraded
+		}
+		cancel()
 		hostname, err := os.Hostname()
 		if err != nil {
 			log.Error().Err(err).Msgf("failed to get hostname for coordinator %s", c.id)
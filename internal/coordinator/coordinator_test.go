@@ -445,3 +445,319 @@ func doRunJob(t *testing.T, filename string) *tork.Job {
 
 	return j2
 }
+
+func newTestScheduledJob(t *testing.T, ds *inmemory.InMemoryDatastore, overlap tork.ScheduledJobOverlap, createdAt time.Time) *tork.ScheduledJob {
+	sj := &tork.ScheduledJob{
+		ID:        uuid.NewUUID(),
+		Name:      "every minute",
+		Cron:      "* * * * *",
+		Overlap:   overlap,
+		State:     tork.ScheduledJobStateActive,
+		CreatedAt: createdAt,
+		Template: &tork.Job{
+			Name:   "scheduled",
+			Inputs: map[string]string{"var1": "val1"},
+			Tasks: []*tork.Task{
+				{
+					Name:  "some task",
+					Image: "ubuntu:mantic",
+				},
+			},
+		},
+	}
+	assert.NoError(t, ds.CreateScheduledJob(context.Background(), sj))
+	return sj
+}
+
+func Test_triggerScheduledJob(t *testing.T) {
+	ctx := context.Background()
+	ds := inmemory.NewInMemoryDatastore()
+	c, err := NewCoordinator(Config{
+		Broker:    mq.NewInMemoryBroker(),
+		DataStore: ds,
+	})
+	assert.NoError(t, err)
+
+	now := time.Date(2024, 1, 15, 10, 30, 30, 0, time.UTC)
+	sj := newTestScheduledJob(t, ds, tork.ScheduledJobOverlapSkip, now.Add(-time.Minute))
+
+	assert.NoError(t, c.triggerScheduledJobs(ctx, now))
+	sj, err = ds.GetScheduledJobByID(ctx, sj.ID)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, sj.LastJobID)
+	assert.Equal(t, time.Date(2024, 1, 15, 10, 30, 0, 0, time.UTC), *sj.LastRunAt)
+
+	j, err := ds.GetJobByID(ctx, sj.LastJobID)
+	assert.NoError(t, err)
+	assert.Equal(t, "scheduled", j.Name)
+	assert.Equal(t, tork.JobStatePending, j.State)
+	assert.Equal(t, 1, j.TaskCount)
+	assert.Equal(t, "val1", j.Context.Inputs["var1"])
+	assert.Equal(t, j.ID, j.Context.Job["id"])
+
+	// not due again yet
+	lastJobID := sj.LastJobID
+	assert.NoError(t, c.triggerScheduledJobs(ctx, now.Add(time.Second)))
+	sj, err = ds.GetScheduledJobByID(ctx, sj.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, lastJobID, sj.LastJobID)
+
+	// due, but the last job is still active so the run is skipped
+	assert.NoError(t, c.triggerScheduledJobs(ctx, now.Add(time.Minute)))
+	sj, err = ds.GetScheduledJobByID(ctx, sj.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, lastJobID, sj.LastJobID)
+	assert.Equal(t, time.Date(2024, 1, 15, 10, 31, 0, 0, time.UTC), *sj.LastRunAt)
+
+	// once the last job is done, missed runs are caught up with a single run
+	assert.NoError(t, ds.UpdateJob(ctx, lastJobID, func(u *tork.Job) error {
+		u.State = tork.JobStateCompleted
+		return nil
+	}))
+	assert.NoError(t, c.triggerScheduledJobs(ctx, now.Add(time.Hour)))
+	sj, err = ds.GetScheduledJobByID(ctx, sj.ID)
+	assert.NoError(t, err)
+	assert.NotEqual(t, lastJobID, sj.LastJobID)
+	assert.Equal(t, time.Date(2024, 1, 15, 11, 30, 0, 0, time.UTC), *sj.LastRunAt)
+}
+
+func Test_triggerScheduledJobQueue(t *testing.T) {
+	ctx := context.Background()
+	ds := inmemory.NewInMemoryDatastore()
+	c, err := NewCoordinator(Config{
+		Broker:    mq.NewInMemoryBroker(),
+		DataStore: ds,
+	})
+	assert.NoError(t, err)
+
+	now := time.Date(2024, 1, 15, 10, 30, 30, 0, time.UTC)
+	sj := newTestScheduledJob(t, ds, tork.ScheduledJobOverlapQueue, now.Add(-time.Minute))
+
+	assert.NoError(t, c.triggerScheduledJobs(ctx, now))
+	sj, err = ds.GetScheduledJobByID(ctx, sj.ID)
+	assert.NoError(t, err)
+	lastJobID := sj.LastJobID
+
+	// the run stays due while the last job is active
+	assert.NoError(t, c.triggerScheduledJobs(ctx, now.Add(time.Minute)))
+	sj, err = ds.GetScheduledJobByID(ctx, sj.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, lastJobID, sj.LastJobID)
+	assert.Equal(t, time.Date(2024, 1, 15, 10, 30, 0, 0, time.UTC), *sj.LastRunAt)
+
+	assert.NoError(t, ds.UpdateJob(ctx, lastJobID, func(u *tork.Job) error {
+		u.State = tork.JobStateFailed
+		return nil
+	}))
+	assert.NoError(t, c.triggerScheduledJobs(ctx, now.Add(time.Minute)))
+	sj, err = ds.GetScheduledJobByID(ctx, sj.ID)
+	assert.NoError(t, err)
+	assert.NotEqual(t, lastJobID, sj.LastJobID)
+}
+
+func Test_triggerScheduledJobReplace(t *testing.T) {
+	ctx := context.Background()
+	ds := inmemory.NewInMemoryDatastore()
+	b := mq.NewInMemoryBroker()
+	cancelled := make(chan string, 1)
+	err := b.SubscribeForJobs(func(j *tork.Job) error {
+		if j.State == tork.JobStateCancelled {
+			cancelled <- j.ID
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	c, err := NewCoordinator(Config{
+		Broker:    b,
+		DataStore: ds,
+	})
+	assert.NoError(t, err)
+
+	now := time.Date(2024, 1, 15, 10, 30, 30, 0, time.UTC)
+	sj := newTestScheduledJob(t, ds, tork.ScheduledJobOverlapReplace, now.Add(-time.Minute))
+
+	assert.NoError(t, c.triggerScheduledJobs(ctx, now))
+	sj, err = ds.GetScheduledJobByID(ctx, sj.ID)
+	assert.NoError(t, err)
+	lastJobID := sj.LastJobID
+
+	assert.NoError(t, c.triggerScheduledJobs(ctx, now.Add(time.Minute)))
+	sj, err = ds.GetScheduledJobByID(ctx, sj.ID)
+	assert.NoError(t, err)
+	assert.NotEqual(t, lastJobID, sj.LastJobID)
+	assert.Equal(t, lastJobID, <-cancelled)
+}
+
+func Test_triggerScheduledJobPaused(t *testing.T) {
+	ctx := context.Background()
+	ds := inmemory.NewInMemoryDatastore()
+	c, err := NewCoordinator(Config{
+		Broker:    mq.NewInMemoryBroker(),
+		DataStore: ds,
+	})
+	assert.NoError(t, err)
+	now := time.Date(2024, 1, 15, 10, 30, 30, 0, time.UTC)
+	sj := newTestScheduledJob(t, ds, tork.ScheduledJobOverlapSkip, now.Add(-time.Minute))
+	assert.NoError(t, ds.UpdateScheduledJob(ctx, sj.ID, func(u *tork.ScheduledJob) error {
+		u.State = tork.ScheduledJobStatePaused
+		return nil
+	}))
+	assert.NoError(t, c.triggerScheduledJobs(ctx, now))
+	sj, err = ds.GetScheduledJobByID(ctx, sj.ID)
+	assert.NoError(t, err)
+	assert.Empty(t, sj.LastJobID)
+}
+
+func Test_failStalledTasks(t *testing.T) {
+	ctx := context.Background()
+	ds := inmemory.NewInMemoryDatastore()
+	b := mq.NewInMemoryBroker()
+	c, err := NewCoordinator(Config{
+		Broker:    b,
+		DataStore: ds,
+	})
+	assert.NoError(t, err)
+
+	failed := make(chan *tork.Task, 10)
+	err = b.SubscribeForTasks(mq.QUEUE_ERROR, func(t *tork.Task) error {
+		failed <- t
+		return nil
+	})
+	assert.NoError(t, err)
+
+	now := time.Now().UTC()
+	startedAt := now.Add(-time.Minute * 10)
+	n := &tork.Node{ID: uuid.NewUUID(), LastHeartbeatAt: now.Add(-time.Minute * 6)}
+	assert.NoError(t, ds.CreateNode(ctx, n))
+
+	t1 := &tork.Task{ID: uuid.NewUUID(), State: tork.TaskStateRunning, NodeID: n.ID, StartedAt: &startedAt}
+	assert.NoError(t, ds.CreateTask(ctx, t1))
+
+	assert.NoError(t, c.failStalledTasksBefore(ctx, now.Add(-time.Minute*5)))
+
+	t2, err := ds.GetTaskByID(ctx, t1.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, tork.TaskStateFailed, t2.State)
+	assert.NotNil(t, t2.FailedAt)
+	assert.Contains(t, t2.Error, n.ID)
+
+	select {
+	case ft := <-failed:
+		assert.Equal(t, t1.ID, ft.ID)
+		assert.Equal(t, tork.TaskStateFailed, ft.State)
+	case <-time.After(time.Second):
+		t.Fatal("expected the stalled task to be published")
+	}
+
+	// already failed, so it isn't failed again
+	assert.NoError(t, c.failStalledTasksBefore(ctx, now.Add(-time.Minute*5)))
+	select {
+	case <-failed:
+		t.Fatal("expected the task to be failed only once")
+	case <-time.After(time.Millisecond * 100):
+	}
+}
+
+func Test_failTimedOutJobs(t *testing.T) {
+	ctx := context.Background()
+	ds := inmemory.NewInMemoryDatastore()
+	b := mq.NewInMemoryBroker()
+	c, err := NewCoordinator(Config{
+		Broker:    b,
+		DataStore: ds,
+	})
+	assert.NoError(t, err)
+
+	failed := make(chan *tork.Job, 10)
+	err = b.SubscribeForJobs(func(j *tork.Job) error {
+		failed <- j
+		return nil
+	})
+	assert.NoError(t, err)
+
+	now := time.Now().UTC()
+	timeoutAt := now.Add(-time.Second)
+	j1 := &tork.Job{ID: uuid.NewUUID(), State: tork.JobStateRunning, Timeout: "1m", TimeoutAt: &timeoutAt}
+	assert.NoError(t, ds.CreateJob(ctx, j1))
+
+	assert.NoError(t, c.failTimedOutJobsBefore(ctx, now))
+
+	j2, err := ds.GetJobByID(ctx, j1.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, tork.JobStateFailed, j2.State)
+	assert.NotNil(t, j2.FailedAt)
+	assert.Equal(t, "job timed out after 1m", j2.Error)
+
+	select {
+	case fj := <-failed:
+		assert.Equal(t, j1.ID, fj.ID)
+		assert.Equal(t, tork.JobStateFailed, fj.State)
+	case <-time.After(time.Second):
+		t.Fatal("expected the timed out job to be published")
+	}
+
+	// already failed, so it isn't failed again
+	assert.NoError(t, c.failTimedOutJobsBefore(ctx, now))
+	select {
+	case <-failed:
+		t.Fatal("expected the job to be failed only once")
+	case <-time.After(time.Millisecond * 100):
+	}
+}
+
+func Test_publishDueRetries(t *testing.T) {
+	ctx := context.Background()
+	ds := inmemory.NewInMemoryDatastore()
+	b := mq.NewInMemoryBroker()
+	c, err := NewCoordinator(Config{
+		Broker:    b,
+		DataStore: ds,
+	})
+	assert.NoError(t, err)
+
+	published := make(chan *tork.Task, 10)
+	err = b.SubscribeForTasks(mq.QUEUE_PENDING, func(tk *tork.Task) error {
+		published <- tk
+		return nil
+	})
+	assert.NoError(t, err)
+
+	now := time.Now().UTC()
+	due := now.Add(-time.Second)
+	notDue := now.Add(time.Minute)
+
+	j1 := &tork.Job{ID: uuid.NewUUID(), State: tork.JobStateRunning}
+	assert.NoError(t, ds.CreateJob(ctx, j1))
+	j2 := &tork.Job{ID: uuid.NewUUID(), State: tork.JobStateCancelled}
+	assert.NoError(t, ds.CreateJob(ctx, j2))
+
+	t1 := &tork.Task{ID: uuid.NewUUID(), JobID: j1.ID, State: tork.TaskStatePending, RetryAt: &due}
+	t2 := &tork.Task{ID: uuid.NewUUID(), JobID: j1.ID, State: tork.TaskStatePending, RetryAt: &notDue}
+	t3 := &tork.Task{ID: uuid.NewUUID(), JobID: j2.ID, State: tork.TaskStatePending, RetryAt: &due}
+	for _, tk := range []*tork.Task{t1, t2, t3} {
+		assert.NoError(t, ds.CreateTask(ctx, tk))
+	}
+
+	assert.NoError(t, c.publishRetriesDueBefore(ctx, now))
+
+	select {
+	case pt := <-published:
+		assert.Equal(t, t1.ID, pt.ID)
+		assert.Nil(t, pt.RetryAt)
+	case <-time.After(time.Second):
+		t.Fatal("expected the due retry to be published")
+	}
+
+	// the retry of the cancelled job is cancelled
+	t31, err := ds.GetTaskByID(ctx, t3.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, tork.TaskStateCancelled, t31.State)
+
+	// already published, so it isn't published again
+	assert.NoError(t, c.publishRetriesDueBefore(ctx, now))
+	select {
+	case <-published:
+		t.Fatal("expected the retry to be published only once")
+	case <-time.After(time.Millisecond * 100):
+	}
+}
@@ -0,0 +1,140 @@
+package coordinator
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/runabol/tork"
+	"github.com/runabol/tork/datastore"
+	"github.com/runabol/tork/internal/cron"
+	"github.com/runabol/tork/internal/tracing"
+	"github.com/runabol/tork/internal/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/exp/maps"
+)
+
+// how often scheduled jobs are checked for due runs
+var scheduledJobsInterval = time.Second * 10
+
+func (c *Coordinator) runScheduledJobs() {
+	for {
+		if c.IsLeader() {
+			if err := c.triggerScheduledJobs(context.Background(), time.Now().UTC()); err != nil {
+				log.Error().Err(err).Msg("error triggering scheduled jobs")
+			}
+		}
+		select {
+		case <-c.stop:
+			return
+		case <-time.After(scheduledJobsInterval):
+		}
+	}
+}
+
+func (c *Coordinator) triggerScheduledJobs(ctx context.Context, now time.Time) error {
+	sjs, err := c.ds.GetScheduledJobs(ctx)
+	if err != nil {
+		return err
+	}
+	for _, sj := range sjs {
+		if sj.State != tork.ScheduledJobStateActive {
+			continue
+		}
+		if err := c.triggerScheduledJob(ctx, sj.ID, now); err != nil {
+			log.Error().Err(err).Msgf("error triggering scheduled job %s", sj.ID)
+		}
+	}
+	return nil
+}
+
+// triggerScheduledJob starts a new instance of the scheduled job if it
+// is due. Runs that were missed (e.g. while no coordinator was running)
+// are caught up with a single run. The decision is made while holding
+// the scheduled job's lock so only one coordinator triggers each run.
+func (c *Coordinator) triggerScheduledJob(ctx context.Context, id string, now time.Time) (err error) {
+	var next, prev *tork.Job
+	err = c.ds.UpdateScheduledJob(ctx, id, func(u *tork.ScheduledJob) error {
+		next, prev = nil, nil
+		if u.State != tork.ScheduledJobStateActive {
+			return nil
+		}
+		sched, err := cron.Parse(u.Cron)
+		if err != nil {
+			return errors.Wrapf(err, "invalid cron expression")
+		}
+		from := u.CreatedAt
+		if u.LastRunAt != nil {
+			from = *u.LastRunAt
+		}
+		due := sched.Next(from.UTC())
+		if due.IsZero() || due.After(now) {
+			return nil
+		}
+		for n := sched.Next(due); !n.IsZero() && !n.After(now); n = sched.Next(n) {
+			due = n
+		}
+		if u.LastJobID != "" {
+			last, err := c.ds.GetJobByID(ctx, u.LastJobID)
+			if err != nil && !errors.Is(err, datastore.ErrJobNotFound) {
+				return err
+			}
+			if last != nil && last.State.IsActive() {
+				switch u.Overlap {
+				case tork.ScheduledJobOverlapQueue:
+					// keep the run due until the last job is done
+					return nil
+				case tork.ScheduledJobOverlapReplace:
+					prev = last
+				default:
+					u.LastRunAt = &due
+					return nil
+				}
+			}
+		}
+		next = newScheduledJobInstance(u, now)
+		u.LastRunAt = &due
+		u.LastJobID = next.ID
+		return nil
+	})
+	if err != nil || next == nil {
+		return err
+	}
+	if prev != nil {
+		prev.State = tork.JobStateCancelled
+		if err := c.broker.PublishJob(ctx, prev); err != nil {
+			return errors.Wrapf(err, "error cancelling job %s", prev.ID)
+		}
+	}
+	ctx, span := tracing.Start(ctx, "tork.job.submit", trace.WithAttributes(
+		attribute.String("job.id", next.ID),
+		attribute.String("scheduled_job.id", id),
+	))
+	defer func() { tracing.End(span, err) }()
+	next.Trace = tracing.Inject(ctx)
+	if err := c.ds.CreateJob(ctx, next); err != nil {
+		return err
+	}
+	log.Info().Str("job-id", next.ID).Str("scheduled-job-id", id).Msg("created scheduled job instance")
+	return c.broker.PublishJob(ctx, next)
+}
+
+func newScheduledJobInstance(sj *tork.ScheduledJob, now time.Time) *tork.Job {
+	j := sj.Template.Clone()
+	j.ID = uuid.NewUUID()
+	j.State = tork.JobStatePending
+	j.CreatedAt = now
+	j.CreatedBy = sj.CreatedBy
+	j.TaskCount = len(j.Tasks)
+	j.Context = tork.JobContext{
+		Inputs:  maps.Clone(j.Inputs),
+		Secrets: maps.Clone(j.Secrets),
+		Job: map[string]string{
+			"id":   j.ID,
+			"name": j.Name,
+		},
+	}
+	return j
+}
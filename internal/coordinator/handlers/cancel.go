@@ -97,5 +97,32 @@ func cancelActiveTasks(ctx context.Context, ds datastore.Datastore, b mq.Broker,
 			}
 		}
 	}
+	return stopServices(ctx, ds, b, jobID)
+}
+
+// stopServices tears down the services of the job which,
+// having been reported as completed once they were ready,
+// are still running on their nodes.
+func stopServices(ctx context.Context, ds datastore.Datastore, b mq.Broker, jobID string) error {
+	j, err := ds.GetJobByID(ctx, jobID)
+	if err != nil {
+		return errors.Wrapf(err, "error getting job: %s", jobID)
+	}
+	for _, t := range j.Execution {
+		if t.Service == nil || t.State != tork.TaskStateCompleted || t.NodeID == "" {
+			continue
+		}
+		node, err := ds.GetNodeByID(ctx, t.NodeID)
+		if err != nil {
+			// the node is gone and so is the service
+			log.Warn().Err(err).Msgf("error looking up node %s of service %s", t.NodeID, t.ID)
+			continue
+		}
+		st := t.Clone()
+		st.State = tork.TaskStateCancelled
+		if err := b.PublishTask(ctx, node.Queue, st); err != nil {
+			return errors.Wrapf(err, "error stopping service %s", t.ID)
+		}
+	}
 	return nil
 }
@@ -53,3 +53,73 @@ func Test_cancelActiveTasks(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Len(t, actives, 0)
 }
+
+func Test_stopServices(t *testing.T) {
+	ctx := context.Background()
+
+	ds := inmemory.NewInMemoryDatastore()
+	b := mq.NewInMemoryBroker()
+
+	node := &tork.Node{
+		ID:    uuid.NewUUID(),
+		Queue: uuid.NewUUID(),
+	}
+	err := ds.CreateNode(ctx, node)
+	assert.NoError(t, err)
+
+	j1 := &tork.Job{
+		ID:    uuid.NewUUID(),
+		State: tork.JobStateCompleted,
+	}
+	err = ds.CreateJob(ctx, j1)
+	assert.NoError(t, err)
+
+	now := time.Now().UTC()
+	svc := &tork.Task{
+		ID:        uuid.NewUUID(),
+		JobID:     j1.ID,
+		State:     tork.TaskStateCompleted,
+		NodeID:    node.ID,
+		Service:   &tork.ServiceTask{},
+		CreatedAt: &now,
+	}
+	// regular tasks and services on nodes that
+	// are gone are left alone
+	others := []*tork.Task{{
+		ID:        uuid.NewUUID(),
+		JobID:     j1.ID,
+		State:     tork.TaskStateCompleted,
+		NodeID:    node.ID,
+		CreatedAt: &now,
+	}, {
+		ID:        uuid.NewUUID(),
+		JobID:     j1.ID,
+		State:     tork.TaskStateCompleted,
+		NodeID:    uuid.NewUUID(),
+		Service:   &tork.ServiceTask{},
+		CreatedAt: &now,
+	}}
+	for _, tk := range append(others, svc) {
+		err = ds.CreateTask(ctx, tk)
+		assert.NoError(t, err)
+	}
+
+	stopped := make(chan *tork.Task, 3)
+	err = b.SubscribeForTasks(node.Queue, func(tk *tork.Task) error {
+		stopped <- tk
+		return nil
+	})
+	assert.NoError(t, err)
+
+	err = stopServices(ctx, ds, b, j1.ID)
+	assert.NoError(t, err)
+
+	tk := <-stopped
+	assert.Equal(t, svc.ID, tk.ID)
+	assert.Equal(t, tork.TaskStateCancelled, tk.State)
+	select {
+	case tk := <-stopped:
+		t.Fatalf("unexpected stop of task %s", tk.ID)
+	case <-time.After(time.Millisecond * 100):
+	}
+}
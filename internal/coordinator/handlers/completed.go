@@ -61,6 +61,7 @@ func (h *completedHandler) completeSubTask(ctx context.Context, t *tork.Task) er
 
 func (h *completedHandler) completeEachTask(ctx context.Context, t *tork.Task) error {
 	var isLast bool
+	var next *tork.Task
 	err := h.ds.WithTx(ctx, func(tx datastore.Datastore) error {
 		// update actual task
 		if err := tx.UpdateTask(ctx, t.ID, func(u *tork.Task) error {
@@ -72,6 +73,12 @@ func (h *completedHandler) completeEachTask(ctx context.Context, t *tork.Task) e
 			u.State = t.State
 			u.CompletedAt = t.CompletedAt
 			u.Result = t.Result
+			u.ResultTruncated = t.ResultTruncated
+			u.ResultURL = t.ResultURL
+			u.ExitCode = t.ExitCode
+			u.Stats = t.Stats
+			u.ImageDigest = t.ImageDigest
+			u.Artifacts = t.Artifacts
 			return nil
 		}); err != nil {
 			return errors.Wrapf(err, "error updating task in datastore")
@@ -84,6 +91,23 @@ func (h *completedHandler) completeEachTask(ctx context.Context, t *tork.Task) e
 		}); err != nil {
 			return errors.Wrapf(err, "error updating task in datastore")
 		}
+		// release the next item held back by the concurrency limit
+		if !isLast {
+			n, err := tx.GetNextTask(ctx, t.ParentID)
+			if err != nil && !errors.Is(err, datastore.ErrTaskNotFound) {
+				return errors.Wrapf(err, "error getting next task")
+			}
+			if n != nil {
+				if err := tx.UpdateTask(ctx, n.ID, func(u *tork.Task) error {
+					u.State = tork.TaskStatePending
+					return nil
+				}); err != nil {
+					return errors.Wrapf(err, "error updating task in datastore")
+				}
+				n.State = tork.TaskStatePending
+				next = n
+			}
+		}
 		// update job context
 		if t.Result != "" && t.Var != "" {
 			if err := tx.UpdateJob(ctx, t.JobID, func(u *tork.Job) error {
@@ -101,6 +125,11 @@ func (h *completedHandler) completeEachTask(ctx context.Context, t *tork.Task) e
 	if err != nil {
 		return errors.Wrapf(err, "error complating each task: %s", t.ID)
 	}
+	if next != nil {
+		if err := h.broker.PublishTask(ctx, mq.QUEUE_PENDING, next); err != nil {
+			return errors.Wrapf(err, "error publishing next task: %s", next.ID)
+		}
+	}
 	// complete the parent task
 	if isLast {
 		parent, err := h.ds.GetTaskByID(ctx, t.ParentID)
@@ -128,6 +157,12 @@ func (h *completedHandler) completeParallelTask(ctx context.Context, t *tork.Tas
 			u.State = t.State
 			u.CompletedAt = t.CompletedAt
 			u.Result = t.Result
+			u.ResultTruncated = t.ResultTruncated
+			u.ResultURL = t.ResultURL
+			u.ExitCode = t.ExitCode
+			u.Stats = t.Stats
+			u.ImageDigest = t.ImageDigest
+			u.Artifacts = t.Artifacts
 			return nil
 		}); err != nil {
 			return errors.Wrapf(err, "error updating task in datastore")
@@ -184,6 +219,12 @@ func (c *completedHandler) completeTopLevelTask(ctx context.Context, t *tork.Tas
 			u.State = t.State
 			u.CompletedAt = t.CompletedAt
 			u.Result = t.Result
+			u.ResultTruncated = t.ResultTruncated
+			u.ResultURL = t.ResultURL
+			u.ExitCode = t.ExitCode
+			u.Stats = t.Stats
+			u.ImageDigest = t.ImageDigest
+			u.Artifacts = t.Artifacts
 			return nil
 		}); err != nil {
 			return errors.Wrapf(err, "error updating task in datastore")
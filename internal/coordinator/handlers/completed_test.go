@@ -254,6 +254,9 @@ func Test_handleCompletedFirstTask(t *testing.T) {
 	assert.NoError(t, err)
 
 	t1.State = tork.TaskStateCompleted
+	// a non-zero exit code of a task completed
+	// with runtime.ignoreexitcode is recorded
+	t1.ExitCode = 2
 
 	err = handler(ctx, task.StateChange, t1)
 	assert.NoError(t, err)
@@ -264,6 +267,7 @@ func Test_handleCompletedFirstTask(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, tork.TaskStateCompleted, t2.State)
 	assert.Equal(t, t1.CompletedAt, t2.CompletedAt)
+	assert.Equal(t, 2, t2.ExitCode)
 
 	// verify that the job was NOT
 	// marked as COMPLETED
@@ -412,6 +416,7 @@ func Test_handleCompletedParallelTask(t *testing.T) {
 	assert.NoError(t, err)
 
 	t1.State = tork.TaskStateCompleted
+	t1.ExitCode = 2
 
 	err = handler(ctx, task.StateChange, t1)
 	assert.NoError(t, err)
@@ -425,6 +430,7 @@ func Test_handleCompletedParallelTask(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, tork.TaskStateCompleted, t2.State)
 	assert.Equal(t, t1.CompletedAt, t2.CompletedAt)
+	assert.Equal(t, 2, t2.ExitCode)
 
 	pt1, err := ds.GetTaskByID(ctx, t1.ParentID)
 	assert.NoError(t, err)
@@ -515,6 +521,7 @@ func Test_handleCompletedEachTask(t *testing.T) {
 	assert.NoError(t, err)
 
 	t1.State = tork.TaskStateCompleted
+	t1.ExitCode = 2
 
 	err = ds.CreateTask(ctx, t5)
 	assert.NoError(t, err)
@@ -531,6 +538,7 @@ func Test_handleCompletedEachTask(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, tork.TaskStateCompleted, t2.State)
 	assert.Equal(t, t1.CompletedAt, t2.CompletedAt)
+	assert.Equal(t, 2, t2.ExitCode)
 
 	pt1, err := ds.GetTaskByID(ctx, t1.ParentID)
 	assert.NoError(t, err)
@@ -544,6 +552,85 @@ func Test_handleCompletedEachTask(t *testing.T) {
 	assert.Equal(t, tork.JobStateRunning, j2.State)
 }
 
+func Test_handleCompletedEachTaskConcurrency(t *testing.T) {
+	ctx := context.Background()
+	b := mq.NewInMemoryBroker()
+
+	released := make(chan *tork.Task, 1)
+	err := b.SubscribeForTasks(mq.QUEUE_PENDING, func(tk *tork.Task) error {
+		released <- tk
+		return nil
+	})
+	assert.NoError(t, err)
+
+	ds := inmemory.NewInMemoryDatastore()
+	handler := NewCompletedHandler(ds, b)
+	assert.NotNil(t, handler)
+
+	now := time.Now().UTC()
+
+	j1 := &tork.Job{
+		ID:       uuid.NewUUID(),
+		State:    tork.JobStateRunning,
+		Position: 1,
+	}
+	err = ds.CreateJob(ctx, j1)
+	assert.NoError(t, err)
+
+	pt := &tork.Task{
+		ID:       uuid.NewUUID(),
+		JobID:    j1.ID,
+		Position: 1,
+		Name:     "parent task",
+		Each: &tork.EachTask{
+			Size:        2,
+			Concurrency: 1,
+			List:        "some expression",
+			Task: &tork.Task{
+				Name: "some task",
+			},
+		},
+		State: tork.TaskStateRunning,
+	}
+	err = ds.CreateTask(ctx, pt)
+	assert.NoError(t, err)
+
+	t1 := &tork.Task{
+		ID:        uuid.NewUUID(),
+		State:     tork.TaskStateRunning,
+		StartedAt: &now,
+		JobID:     j1.ID,
+		Position:  1,
+		ParentID:  pt.ID,
+	}
+	err = ds.CreateTask(ctx, t1)
+	assert.NoError(t, err)
+
+	t2 := &tork.Task{
+		ID:       uuid.NewUUID(),
+		State:    tork.TaskStateCreated,
+		JobID:    j1.ID,
+		Position: 1,
+		ParentID: pt.ID,
+	}
+	err = ds.CreateTask(ctx, t2)
+	assert.NoError(t, err)
+
+	t1.State = tork.TaskStateCompleted
+	t1.CompletedAt = &now
+
+	err = handler(ctx, task.StateChange, t1)
+	assert.NoError(t, err)
+
+	// the held back item should be released
+	next := <-released
+	assert.Equal(t, t2.ID, next.ID)
+
+	t22, err := ds.GetTaskByID(ctx, t2.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, tork.TaskStatePending, t22.State)
+}
+
 func Test_completeTopLevelTaskWithTxRollback(t *testing.T) {
 	ctx := context.Background()
 	dsn := "host=localhost user=tork password=tork dbname=tork port=5432 sslmode=disable"
@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"context"
+	"math"
 	"time"
 
 	"github.com/pkg/errors"
@@ -50,6 +51,9 @@ func (h *errorHandler) handle(ctx context.Context, et task.EventType, t *tork.Ta
 			u.State = tork.TaskStateFailed
 			u.FailedAt = t.FailedAt
 			u.Error = t.Error
+			u.ExitCode = t.ExitCode
+			u.Stats = t.Stats
+			u.ImageDigest = t.ImageDigest
 		}
 		return nil
 	}); err != nil {
@@ -68,14 +72,30 @@ func (h *errorHandler) handle(ctx context.Context, et task.EventType, t *tork.Ta
 		rt.State = tork.TaskStatePending
 		rt.Error = ""
 		rt.FailedAt = nil
+		rt.Stats = nil
+		rt.ImageDigest = ""
 		if err := eval.EvaluateTask(rt, j.Context.AsMap()); err != nil {
 			return errors.Wrapf(err, "error evaluating task")
 		}
+		delay, err := retryDelay(t.Retry)
+		if err != nil {
+			return errors.Wrapf(err, "error calculating retry delay")
+		}
+		if delay > 0 {
+			// the leader coordinator publishes
+			// the retry once it's due
+			retryAt := now.Add(delay)
+			rt.RetryAt = &retryAt
+		}
 		if err := h.ds.CreateTask(ctx, rt); err != nil {
 			return errors.Wrapf(err, "error creating a retry task")
 		}
-		if err := h.broker.PublishTask(ctx, mq.QUEUE_PENDING, rt); err != nil {
-			log.Error().Err(err).Msg("error publishing retry task")
+		if delay == 0 {
+			if err := h.broker.PublishTask(ctx, mq.QUEUE_PENDING, rt); err != nil {
+				log.Error().Err(err).Msg("error publishing retry task")
+			}
+		} else {
+			log.Debug().Msgf("retrying task %s in %s", t.ID, delay)
 		}
 	} else {
 		j.State = tork.JobStateFailed
@@ -84,3 +104,37 @@ func (h *errorHandler) handle(ctx context.Context, et task.EventType, t *tork.Ta
 	}
 	return nil
 }
+
+// DefaultMaxRetryDelay caps the delay between retries
+// of tasks which don't set a maximum delay.
+const DefaultMaxRetryDelay = time.Hour
+
+// retryDelay calculates the delay before the next retry
+// attempt: initialDelay * scalingFactor^attempts, capped
+// at the retry's maximum delay.
+func retryDelay(r *tork.TaskRetry) (time.Duration, error) {
+	if r.InitialDelay == "" {
+		return 0, nil
+	}
+	delay, err := time.ParseDuration(r.InitialDelay)
+	if err != nil {
+		return 0, err
+	}
+	maxDelay := DefaultMaxRetryDelay
+	if r.MaxDelay != "" {
+		maxDelay, err = time.ParseDuration(r.MaxDelay)
+		if err != nil {
+			return 0, err
+		}
+	}
+	factor := r.ScalingFactor
+	if factor == 0 {
+		factor = 2
+	}
+	// compare as floats, the product may not fit a duration
+	d := float64(delay) * math.Pow(factor, float64(r.Attempts))
+	if d >= float64(maxDelay) {
+		return maxDelay, nil
+	}
+	return time.Duration(d), nil
+}
@@ -172,3 +172,104 @@ func Test_handleFailedTaskRetry(t *testing.T) {
 	assert.Equal(t, j1.ID, j2.ID)
 	assert.Equal(t, tork.JobStateRunning, j2.State)
 }
+
+func Test_handleFailedTaskRetryWithDelay(t *testing.T) {
+	ctx := context.Background()
+	b := mq.NewInMemoryBroker()
+
+	processed := make(chan any, 1)
+	err := b.SubscribeForTasks(mq.QUEUE_PENDING, func(tk *tork.Task) error {
+		processed <- 1
+		return nil
+	})
+	assert.NoError(t, err)
+
+	ds := inmemory.NewInMemoryDatastore()
+
+	handler := NewErrorHandler(ds, b)
+	assert.NotNil(t, handler)
+
+	now := time.Now().UTC()
+
+	j1 := &tork.Job{
+		ID:       uuid.NewUUID(),
+		State:    tork.JobStateRunning,
+		Position: 1,
+	}
+	err = ds.CreateJob(ctx, j1)
+	assert.NoError(t, err)
+
+	t1 := &tork.Task{
+		ID:        uuid.NewUUID(),
+		State:     tork.TaskStateRunning,
+		StartedAt: &now,
+		JobID:     j1.ID,
+		Position:  1,
+		Retry: &tork.TaskRetry{
+			Limit:        1,
+			InitialDelay: "200ms",
+		},
+	}
+
+	err = ds.CreateTask(ctx, t1)
+	assert.NoError(t, err)
+
+	start := time.Now().UTC()
+	err = handler(ctx, task.StateChange, t1)
+	assert.NoError(t, err)
+
+	// the retry is stored for the leader to publish once it's due
+	due, err := ds.GetDueRetries(ctx, start.Add(time.Second))
+	assert.NoError(t, err)
+	assert.Len(t, due, 1)
+	assert.Equal(t, 1, due[0].Retry.Attempts)
+	assert.Equal(t, tork.TaskStatePending, due[0].State)
+	assert.GreaterOrEqual(t, due[0].RetryAt.Sub(start), time.Millisecond*200)
+
+	due, err = ds.GetDueRetries(ctx, start)
+	assert.NoError(t, err)
+	assert.Len(t, due, 0)
+
+	select {
+	case <-processed:
+		t.Fatal("the retry should not be published before it's due")
+	case <-time.After(time.Millisecond * 300):
+	}
+}
+
+func Test_retryDelay(t *testing.T) {
+	d, err := retryDelay(&tork.TaskRetry{})
+	assert.NoError(t, err)
+	assert.Equal(t, time.Duration(0), d)
+
+	d, err = retryDelay(&tork.TaskRetry{InitialDelay: "1s"})
+	assert.NoError(t, err)
+	assert.Equal(t, time.Second, d)
+
+	d, err = retryDelay(&tork.TaskRetry{InitialDelay: "1s", Attempts: 2})
+	assert.NoError(t, err)
+	assert.Equal(t, time.Second*4, d)
+
+	d, err = retryDelay(&tork.TaskRetry{InitialDelay: "1s", Attempts: 2, ScalingFactor: 3})
+	assert.NoError(t, err)
+	assert.Equal(t, time.Second*9, d)
+
+	d, err = retryDelay(&tork.TaskRetry{InitialDelay: "1m", Attempts: 10})
+	assert.NoError(t, err)
+	assert.Equal(t, DefaultMaxRetryDelay, d)
+
+	d, err = retryDelay(&tork.TaskRetry{InitialDelay: "1s", Attempts: 5, MaxDelay: "10s"})
+	assert.NoError(t, err)
+	assert.Equal(t, time.Second*10, d)
+
+	// would overflow a duration
+	d, err = retryDelay(&tork.TaskRetry{InitialDelay: "1h", Attempts: 100, ScalingFactor: 10, MaxDelay: "24h"})
+	assert.NoError(t, err)
+	assert.Equal(t, time.Hour*24, d)
+
+	_, err = retryDelay(&tork.TaskRetry{InitialDelay: "abc"})
+	assert.Error(t, err)
+
+	_, err = retryDelay(&tork.TaskRetry{InitialDelay: "1s", MaxDelay: "abc"})
+	assert.Error(t, err)
+}
@@ -36,6 +36,8 @@ func (h *heartbeatHandler) handle(ctx context.Context, n *tork.Node) error {
 		}
 		u.LastHeartbeatAt = n.LastHeartbeatAt
 		u.CPUPercent = n.CPUPercent
+		u.MemoryPercent = n.MemoryPercent
+		u.DiskPercent = n.DiskPercent
 		u.Status = n.Status
 		u.TaskCount = n.TaskCount
 		return nil
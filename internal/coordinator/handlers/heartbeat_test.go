@@ -42,6 +42,8 @@ func Test_handleHeartbeat(t *testing.T) {
 		CPUPercent:      75,
 		Status:          tork.NodeStatusDown,
 		TaskCount:       3,
+		MemoryPercent:   40,
+		DiskPercent:     60,
 	}
 
 	err = handler(ctx, &n2)
@@ -53,6 +55,8 @@ func Test_handleHeartbeat(t *testing.T) {
 	assert.Equal(t, n2.CPUPercent, n22.CPUPercent)
 	assert.Equal(t, n2.Status, n22.Status)
 	assert.Equal(t, n2.TaskCount, n22.TaskCount)
+	assert.Equal(t, n2.MemoryPercent, n22.MemoryPercent)
+	assert.Equal(t, n2.DiskPercent, n22.DiskPercent)
 
 	n3 := tork.Node{
 		ID:              n1.ID,
@@ -76,7 +76,7 @@ func (h *jobHandler) startJob(ctx context.Context, j *tork.Job) error {
 		u.State = tork.JobStateScheduled
 		u.StartedAt = &n
 		u.Position = 1
-		return nil
+		return setTimeoutAt(u, n)
 	}); err != nil {
 		return err
 	}
@@ -126,6 +126,9 @@ func (h *jobHandler) completeJob(ctx context.Context, j *tork.Job) error {
 	}); err != nil {
 		return errors.Wrapf(err, "error updating job in datastore")
 	}
+	if err := stopServices(ctx, h.ds, h.broker, j.ID); err != nil {
+		return err
+	}
 	// if this is a sub-job -- complete/fail the parent task
 	if j.ParentID != "" {
 		parent, err := h.ds.GetTaskByID(ctx, j.ParentID)
@@ -171,7 +174,8 @@ func (h *jobHandler) restartJob(ctx context.Context, j *tork.Job) error {
 		}
 		u.State = tork.JobStateRunning
 		u.FailedAt = nil
-		return nil
+		u.Error = ""
+		return setTimeoutAt(u, time.Now().UTC())
 	}); err != nil {
 		return err
 	}
@@ -208,6 +212,10 @@ func (h *jobHandler) failJob(ctx context.Context, j *tork.Job) error {
 	}); err != nil {
 		return errors.Wrapf(err, "error marking the job as failed in the datastore")
 	}
+	// cancel all currently running tasks
+	if err := cancelActiveTasks(ctx, h.ds, h.broker, j.ID); err != nil {
+		return err
+	}
 	// if this is a sub-job -- FAIL the parent task
 	if j.ParentID != "" {
 		parent, err := h.ds.GetTaskByID(ctx, j.ParentID)
@@ -219,10 +227,6 @@ func (h *jobHandler) failJob(ctx context.Context, j *tork.Job) error {
 		parent.Error = j.Error
 		return h.broker.PublishTask(ctx, mq.QUEUE_ERROR, parent)
 	}
-	// cancel all currently running tasks
-	if err := cancelActiveTasks(ctx, h.ds, h.broker, j.ID); err != nil {
-		return err
-	}
 	j, err := h.ds.GetJobByID(ctx, j.ID)
 	if err != nil {
 		return errors.Wrapf(err, "unknown job: %s", j.ID)
@@ -232,3 +236,18 @@ func (h *jobHandler) failJob(ctx context.Context, j *tork.Job) error {
 	}
 	return nil
 }
+
+// setTimeoutAt sets the deadline of a job with
+// a timeout, counting from the given time.
+func setTimeoutAt(j *tork.Job, from time.Time) error {
+	if j.Timeout == "" {
+		return nil
+	}
+	d, err := time.ParseDuration(j.Timeout)
+	if err != nil {
+		return errors.Wrapf(err, "invalid job timeout: %s", j.Timeout)
+	}
+	timeoutAt := from.Add(d)
+	j.TimeoutAt = &timeoutAt
+	return nil
+}
@@ -42,6 +42,38 @@ func Test_handleJobs(t *testing.T) {
 	assert.Equal(t, tork.JobStateScheduled, j2.State)
 }
 
+func Test_handleJobWithTimeout(t *testing.T) {
+	ctx := context.Background()
+	b := mq.NewInMemoryBroker()
+
+	ds := inmemory.NewInMemoryDatastore()
+	handler := NewJobHandler(ds, b)
+	assert.NotNil(t, handler)
+
+	j1 := &tork.Job{
+		ID:      uuid.NewUUID(),
+		State:   tork.JobStatePending,
+		Timeout: "5m",
+		Tasks: []*tork.Task{
+			{
+				Name: "task-1",
+			},
+		},
+	}
+
+	err := ds.CreateJob(ctx, j1)
+	assert.NoError(t, err)
+
+	err = handler(ctx, job.StateChange, j1)
+	assert.NoError(t, err)
+
+	j2, err := ds.GetJobByID(ctx, j1.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, tork.JobStateScheduled, j2.State)
+	assert.NotNil(t, j2.TimeoutAt)
+	assert.WithinDuration(t, j2.StartedAt.Add(time.Minute*5), *j2.TimeoutAt, time.Second)
+}
+
 func Test_handleCancelJob(t *testing.T) {
 	ctx := context.Background()
 	b := mq.NewInMemoryBroker()
@@ -172,6 +204,73 @@ func Test_handleRestartJob(t *testing.T) {
 	assert.Error(t, err)
 }
 
+func Test_handleRestartFailedJob(t *testing.T) {
+	ctx := context.Background()
+	b := mq.NewInMemoryBroker()
+
+	ds := inmemory.NewInMemoryDatastore()
+	handler := NewJobHandler(ds, b)
+	assert.NotNil(t, handler)
+
+	pending := make(chan *tork.Task, 1)
+	err := b.SubscribeForTasks(mq.QUEUE_PENDING, func(t *tork.Task) error {
+		pending <- t
+		return nil
+	})
+	assert.NoError(t, err)
+
+	now := time.Now().UTC()
+
+	j1 := &tork.Job{
+		ID:        uuid.NewUUID(),
+		State:     tork.JobStateFailed,
+		CreatedAt: now,
+		FailedAt:  &now,
+		Error:     "something bad happened",
+		Position:  2,
+		Context: tork.JobContext{
+			Tasks: map[string]string{"first": "hello"},
+		},
+		Tasks: []*tork.Task{
+			{
+				Name: "task-1",
+				Var:  "first",
+			},
+			{
+				Name: "task-2",
+				Env: map[string]string{
+					"FIRST": "{{ tasks.first }}",
+				},
+			},
+		},
+	}
+
+	err = ds.CreateJob(ctx, j1)
+	assert.NoError(t, err)
+
+	// restart the job
+	j1.State = tork.JobStateRestart
+	err = handler(ctx, job.StateChange, j1)
+	assert.NoError(t, err)
+
+	j2, err := ds.GetJobByID(ctx, j1.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, tork.JobStateRunning, j2.State)
+	assert.Nil(t, j2.FailedAt)
+	assert.Empty(t, j2.Error)
+
+	// the job resumes from the failed task
+	// with its prior context
+	select {
+	case pt := <-pending:
+		assert.Equal(t, "task-2", pt.Name)
+		assert.Equal(t, 2, pt.Position)
+		assert.Equal(t, "hello", pt.Env["FIRST"])
+	case <-time.After(time.Second):
+		t.Fatal("expected the failed task to be re-queued")
+	}
+}
+
 func Test_handleJobWithTaskEvalFailure(t *testing.T) {
 	ctx := context.Background()
 	b := mq.NewInMemoryBroker()
@@ -6,23 +6,36 @@ import (
 	"github.com/rs/zerolog/log"
 	"github.com/runabol/tork"
 	"github.com/runabol/tork/datastore"
+	"github.com/runabol/tork/internal/redact"
+	"github.com/runabol/tork/logstore"
 )
 
 type logHandler struct {
-	ds datastore.Datastore
+	logs     logstore.Store
+	redacter *redact.Redacter
 }
 
-func NewLogHandler(ds datastore.Datastore) func(p *tork.TaskLogPart) {
+// NewLogHandler returns a handler that stores task log parts in
+// the log store, or in the datastore when no log store is given.
+// When a redacter is given, secret values are masked first.
+func NewLogHandler(ds datastore.Datastore, logs logstore.Store, redacter *redact.Redacter) func(p *tork.TaskLogPart) {
+	if logs == nil {
+		logs = logstore.NewDatastoreStore(ds)
+	}
 	h := &logHandler{
-		ds: ds,
+		logs:     logs,
+		redacter: redacter,
 	}
 	return h.handle
 }
 
 func (h *logHandler) handle(p *tork.TaskLogPart) {
 	ctx := context.Background()
+	if h.redacter != nil {
+		h.redacter.RedactLogPart(p)
+	}
 	log.Debug().Msgf("[Task][%s] %s", p.TaskID, p.Contents)
-	if err := h.ds.CreateTaskLogPart(ctx, p); err != nil {
+	if err := h.logs.Append(ctx, p); err != nil {
 		log.Error().Err(err).Msgf("error writing task log: %s", err.Error())
 	}
 }
@@ -6,7 +6,9 @@ import (
 
 	"github.com/runabol/tork"
 	"github.com/runabol/tork/datastore/inmemory"
+	"github.com/runabol/tork/internal/redact"
 	"github.com/runabol/tork/internal/uuid"
+	"github.com/runabol/tork/logstore"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -14,7 +16,7 @@ func Test_handleLog(t *testing.T) {
 	ctx := context.Background()
 
 	ds := inmemory.NewInMemoryDatastore()
-	handler := NewLogHandler(ds)
+	handler := NewLogHandler(ds, nil, nil)
 	assert.NotNil(t, handler)
 
 	j1 := &tork.Job{
@@ -46,3 +48,58 @@ func Test_handleLog(t *testing.T) {
 	assert.Equal(t, 1, n11.TotalItems)
 	assert.Equal(t, "line 1", n11.Items[0].Contents)
 }
+
+func Test_handleLogRedacted(t *testing.T) {
+	ctx := context.Background()
+
+	ds := inmemory.NewInMemoryDatastore()
+	err := ds.SetSecret(ctx, &tork.Secret{Name: "db-password", Value: "p4ssw0rd"})
+	assert.NoError(t, err)
+	handler := NewLogHandler(ds, nil, redact.NewRedacter(ds))
+
+	tk := &tork.Task{
+		ID: uuid.NewUUID(),
+	}
+	err = ds.CreateTask(ctx, tk)
+	assert.NoError(t, err)
+
+	p1 := tork.TaskLogPart{
+		TaskID:   tk.ID,
+		Number:   1,
+		Contents: "using p4ssw0rd",
+	}
+
+	handler(&p1)
+
+	n11, err := ds.GetTaskLogParts(ctx, p1.TaskID, 1, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n11.TotalItems)
+	assert.Equal(t, "using [REDACTED]", n11.Items[0].Contents)
+}
+
+func Test_handleLogStore(t *testing.T) {
+	ctx := context.Background()
+
+	ds := inmemory.NewInMemoryDatastore()
+	logs, err := logstore.NewFileStore(t.TempDir())
+	assert.NoError(t, err)
+	handler := NewLogHandler(ds, logs, nil)
+
+	tk := &tork.Task{
+		ID: uuid.NewUUID(),
+	}
+	err = ds.CreateTask(ctx, tk)
+	assert.NoError(t, err)
+
+	handler(&tork.TaskLogPart{TaskID: tk.ID, Number: 1, Contents: "line 1\n"})
+	handler(&tork.TaskLogPart{TaskID: tk.ID, Number: 2, Contents: "line 2\n"})
+
+	// the parts aren't kept in the datastore
+	n11, err := ds.GetTaskLogParts(ctx, tk.ID, 1, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n11.TotalItems)
+
+	b, err := logs.Read(ctx, tk.ID, 0, 1024)
+	assert.NoError(t, err)
+	assert.Equal(t, "line 1\nline 2\n", string(b))
+}
@@ -0,0 +1,51 @@
+package coordinator
+
+import (
+	"context"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// the lease held by the coordinator which runs the periodic
+// maintenance work: triggering scheduled jobs, publishing delayed
+// retries and failing stalled tasks and timed out jobs. The other
+// replicas keep consuming the coordinator queues and take over
+// once the lease expires.
+const leaderLease = "coordinator"
+
+var defaultLeaseTTL = time.Second * 15
+
+func (c *Coordinator) electLeader() {
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-time.After(c.leaseTTL / 3):
+		}
+		c.renewLeadership(context.Background())
+	}
+}
+
+// renewLeadership acquires or renews the leader lease. The
+// coordinator steps down when the lease can't be renewed.
+func (c *Coordinator) renewLeadership(ctx context.Context) {
+	ok, err := c.ds.AcquireLease(ctx, leaderLease, c.id, c.leaseTTL)
+	if err != nil {
+		log.Error().Err(err).Msgf("coordinator %s failed to renew the leader lease", c.id)
+		ok = false
+	}
+	if c.leader.Swap(ok) != ok {
+		if ok {
+			log.Info().Msgf("coordinator %s is now the leader", c.id)
+		} else {
+			log.Warn().Msgf("coordinator %s is no longer the leader", c.id)
+		}
+	}
+}
+
+// IsLeader reports whether the coordinator
+// currently holds the leader lease.
+func (c *Coordinator) IsLeader() bool {
+	return c.leader.Load()
+}
@@ -0,0 +1,40 @@
+package coordinator
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/runabol/tork/datastore/inmemory"
+	"github.com/runabol/tork/mq"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_renewLeadership(t *testing.T) {
+	ds := inmemory.NewInMemoryDatastore()
+	c1, err := NewCoordinator(Config{
+		Broker:    mq.NewInMemoryBroker(),
+		DataStore: ds,
+		LeaseTTL:  time.Millisecond * 50,
+	})
+	assert.NoError(t, err)
+	c2, err := NewCoordinator(Config{
+		Broker:    mq.NewInMemoryBroker(),
+		DataStore: ds,
+		LeaseTTL:  time.Millisecond * 50,
+	})
+	assert.NoError(t, err)
+
+	ctx := context.Background()
+	c1.renewLeadership(ctx)
+	c2.renewLeadership(ctx)
+	assert.True(t, c1.IsLeader())
+	assert.False(t, c2.IsLeader())
+
+	// c1 dies and its lease expires
+	time.Sleep(time.Millisecond * 60)
+	c2.renewLeadership(ctx)
+	assert.True(t, c2.IsLeader())
+	c1.renewLeadership(ctx)
+	assert.False(t, c1.IsLeader())
+}
@@ -0,0 +1,72 @@
+package coordinator
+
+import (
+	"context"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/runabol/tork"
+	"github.com/runabol/tork/mq"
+)
+
+// how often delayed task retries are checked for being due
+var dueRetriesInterval = time.Second * 5
+
+func (c *Coordinator) publishDueRetries() {
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-time.After(dueRetriesInterval):
+		}
+		if !c.IsLeader() {
+			continue
+		}
+		if err := c.publishRetriesDueBefore(context.Background(), time.Now().UTC()); err != nil {
+			log.Error().Err(err).Msg("error publishing due retries")
+		}
+	}
+}
+
+// publishRetriesDueBefore publishes the delayed retries which are due
+// at the given time. The retries of jobs which are no longer active
+// are cancelled instead. A retry is only claimed while it's still
+// pending and due, so only one coordinator publishes it.
+func (c *Coordinator) publishRetriesDueBefore(ctx context.Context, before time.Time) error {
+	tasks, err := c.ds.GetDueRetries(ctx, before)
+	if err != nil {
+		return err
+	}
+	for _, t := range tasks {
+		j, err := c.ds.GetJobByID(ctx, t.JobID)
+		if err != nil {
+			log.Error().Err(err).Msgf("error getting the job of retry %s", t.ID)
+			continue
+		}
+		active := j.State == tork.JobStateRunning || j.State == tork.JobStateScheduled
+		var due *tork.Task
+		if err := c.ds.UpdateTask(ctx, t.ID, func(u *tork.Task) error {
+			due = nil
+			if u.State != tork.TaskStatePending || u.RetryAt == nil {
+				return nil
+			}
+			u.RetryAt = nil
+			if !active {
+				u.State = tork.TaskStateCancelled
+				return nil
+			}
+			due = u.Clone()
+			return nil
+		}); err != nil {
+			log.Error().Err(err).Msgf("error claiming retry %s", t.ID)
+			continue
+		}
+		if due == nil {
+			continue
+		}
+		if err := c.broker.PublishTask(ctx, mq.QUEUE_PENDING, due); err != nil {
+			log.Error().Err(err).Msgf("error publishing retry %s", t.ID)
+		}
+	}
+	return nil
+}
@@ -4,14 +4,19 @@ import (
 	"context"
 	"fmt"
 	"reflect"
+	"strings"
 	"time"
 
 	"github.com/pkg/errors"
 	"github.com/runabol/tork"
 	"github.com/runabol/tork/datastore"
 	"github.com/runabol/tork/internal/eval"
+	"github.com/runabol/tork/internal/host"
+	"github.com/runabol/tork/internal/tracing"
 	"github.com/runabol/tork/internal/uuid"
 	"github.com/runabol/tork/mq"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type Scheduler struct {
@@ -34,13 +39,18 @@ func (s *Scheduler) ScheduleTask(ctx context.Context, t *tork.Task) error {
 	return s.scheduleRegularTask(ctx, t)
 }
 
-func (s *Scheduler) scheduleRegularTask(ctx context.Context, t *tork.Task) error {
+func (s *Scheduler) scheduleRegularTask(ctx context.Context, t *tork.Task) (err error) {
 	now := time.Now().UTC()
 	// apply job-level defaults
 	job, err := s.ds.GetJobByID(ctx, t.JobID)
 	if err != nil {
 		return err
 	}
+	ctx, span := tracing.Start(tracing.Extract(ctx, job.Trace), "tork.task.schedule", trace.WithAttributes(
+		attribute.String("task.id", t.ID),
+		attribute.String("job.id", t.JobID),
+	))
+	defer func() { tracing.End(span, err) }()
 	if job.Defaults != nil {
 		if t.Queue == "" {
 			t.Queue = job.Defaults.Queue
@@ -52,6 +62,9 @@ func (s *Scheduler) scheduleRegularTask(ctx context.Context, t *tork.Task) error
 			if t.Limits.CPUs == "" {
 				t.Limits.CPUs = job.Defaults.Limits.CPUs
 			}
+			if t.Limits.CPUShares == 0 {
+				t.Limits.CPUShares = job.Defaults.Limits.CPUShares
+			}
 			if t.Limits.Memory == "" {
 				t.Limits.Memory = job.Defaults.Limits.Memory
 			}
@@ -71,6 +84,21 @@ func (s *Scheduler) scheduleRegularTask(ctx context.Context, t *tork.Task) error
 			t.Priority = job.Defaults.Priority
 		}
 	}
+	if t.Queue == "" && (len(t.Selector) > 0 || t.GPUs != "" || t.Platform != "") {
+		q, err := s.selectQueue(ctx, t)
+		// without a selector, the task waits on the gpu or
+		// platform queue for a capable worker to come up
+		if err != nil && len(t.Selector) > 0 {
+			return err
+		}
+		t.Queue = q
+	}
+	if t.Queue == "" && t.GPUs != "" {
+		t.Queue = mq.QUEUE_GPU
+	}
+	if t.Queue == "" && t.Platform != "" {
+		t.Queue = mq.PlatformQueue(t.Platform)
+	}
 	if t.Queue == "" {
 		t.Queue = mq.QUEUE_DEFAULT
 	}
@@ -89,7 +117,88 @@ func (s *Scheduler) scheduleRegularTask(ctx context.Context, t *tork.Task) error
 	}); err != nil {
 		return errors.Wrapf(err, "error updating task in datastore")
 	}
-	return s.broker.PublishTask(ctx, t.Queue, t)
+	// only the copy that is sent to the
+	// worker holds the secret values
+	resolved := t.Clone()
+	if err := s.resolveSecrets(ctx, resolved); err != nil {
+		return err
+	}
+	span.SetAttributes(attribute.String("queue", t.Queue))
+	// the worker's spans are children of the scheduling span
+	resolved.Trace = tracing.Inject(ctx)
+	// the envelope identifies who submitted the task's job
+	return s.broker.PublishTask(mq.WithMetadata(ctx, mq.JobMetadata(job)), t.Queue, resolved)
+}
+
+// selectQueue returns the queue of the least busy active worker
+// that matches the task's selector, GPUs and platform. The queue
+// is only shared with workers that have the same capabilities.
+func (s *Scheduler) selectQueue(ctx context.Context, t *tork.Task) (string, error) {
+	nodes, err := s.ds.GetActiveNodes(ctx)
+	if err != nil {
+		return "", errors.Wrapf(err, "error getting active nodes")
+	}
+	var selected *tork.Node
+	for _, n := range nodes {
+		if (n.Status != tork.NodeStatusUP && n.Status != tork.NodeStatusDegraded) || !matchesSelector(n, t) {
+			continue
+		}
+		if selected == nil || n.TaskCount < selected.TaskCount {
+			selected = n
+		}
+	}
+	if selected == nil {
+		return "", errors.Errorf("no active worker matches the task's selector")
+	}
+	return mq.NodeQueue(selected.Tags, selected.GPUs, selected.Platform), nil
+}
+
+// matchesSelector reports whether the node's tags hold all the
+// key/value pairs of the task's selector, and whether the node
+// can run the task's GPUs and platform requirements.
+func matchesSelector(n *tork.Node, t *tork.Task) bool {
+	for k, v := range t.Selector {
+		if tv, ok := n.Tags[k]; !ok || tv != v {
+			return false
+		}
+	}
+	if n.GPUs < host.RequiredGPUs(t.GPUs) {
+		return false
+	}
+	if t.Platform != "" && n.Platform != "" && n.Platform != t.Platform {
+		return false
+	}
+	return true
+}
+
+// resolveSecrets replaces the task's env references to
+// managed secrets (secret://<name>) with their values.
+func (s *Scheduler) resolveSecrets(ctx context.Context, t *tork.Task) error {
+	for k, v := range t.Env {
+		name, ok := strings.CutPrefix(v, tork.SECRET_REF_PREFIX)
+		if !ok {
+			continue
+		}
+		secret, err := s.ds.GetSecret(ctx, name)
+		if err != nil {
+			if errors.Is(err, datastore.ErrSecretNotFound) {
+				return errors.Errorf("unknown secret %s referenced by env var %s", name, k)
+			}
+			return err
+		}
+		t.Env[k] = secret.Value
+	}
+	for _, pre := range t.Pre {
+		if err := s.resolveSecrets(ctx, pre); err != nil {
+			return err
+		}
+	}
+	for _, post := range t.Post {
+		if err := s.resolveSecrets(ctx, post); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func (s *Scheduler) scheduleSubJob(ctx context.Context, t *tork.Task) error {
@@ -110,6 +219,7 @@ func (s *Scheduler) scheduleAttachedSubJob(ctx context.Context, t *tork.Task) er
 		CreatedAt:   now,
 		CreatedBy:   job.CreatedBy,
 		ParentID:    t.ID,
+		Namespace:   job.Namespace,
 		Name:        t.SubJob.Name,
 		Description: t.SubJob.Description,
 		State:       tork.JobStatePending,
@@ -119,6 +229,7 @@ func (s *Scheduler) scheduleAttachedSubJob(ctx context.Context, t *tork.Task) er
 		TaskCount:   len(t.SubJob.Tasks),
 		Output:      t.SubJob.Output,
 		Webhooks:    t.SubJob.Webhooks,
+		Trace:       job.Trace,
 	}
 	if err := s.ds.UpdateTask(ctx, t.ID, func(u *tork.Task) error {
 		u.State = tork.TaskStateRunning
@@ -145,6 +256,7 @@ func (s *Scheduler) scheduleDetachedSubJob(ctx context.Context, t *tork.Task) er
 		ID:          uuid.NewUUID(),
 		CreatedBy:   job.CreatedBy,
 		CreatedAt:   now,
+		Namespace:   job.Namespace,
 		Name:        t.SubJob.Name,
 		Description: t.SubJob.Description,
 		State:       tork.JobStatePending,
@@ -154,6 +266,7 @@ func (s *Scheduler) scheduleDetachedSubJob(ctx context.Context, t *tork.Task) er
 		TaskCount:   len(t.SubJob.Tasks),
 		Output:      t.SubJob.Output,
 		Webhooks:    t.SubJob.Webhooks,
+		Trace:       job.Trace,
 	}
 	if err := s.ds.CreateJob(ctx, subjob); err != nil {
 		return errors.Wrapf(err, "error creating subjob")
@@ -225,8 +338,16 @@ func (s *Scheduler) scheduleEachTask(ctx context.Context, t *tork.Task) error {
 		et.ID = uuid.NewUUID()
 		et.JobID = j.ID
 		et.State = tork.TaskStatePending
+		// items beyond the concurrency limit are held back
+		// until a running item completes
+		if t.Each.Concurrency > 0 && ix >= t.Each.Concurrency {
+			et.State = tork.TaskStateCreated
+		}
 		et.Position = t.Position
-		et.CreatedAt = &now
+		// held back items are released in the order of the
+		// list, so each item gets a distinct creation time
+		createdAt := itemCreatedAt(now, ix)
+		et.CreatedAt = &createdAt
 		et.ParentID = t.ID
 		if err := eval.EvaluateTask(et, cx); err != nil {
 			t.Error = err.Error()
@@ -236,6 +357,9 @@ func (s *Scheduler) scheduleEachTask(ctx context.Context, t *tork.Task) error {
 		if err := s.ds.CreateTask(ctx, et); err != nil {
 			return err
 		}
+		if et.State != tork.TaskStatePending {
+			continue
+		}
 		if err := s.broker.PublishTask(ctx, mq.QUEUE_PENDING, et); err != nil {
 			return err
 		}
@@ -260,12 +384,13 @@ func (s *Scheduler) scheduleParallelTask(ctx context.Context, t *tork.Task) erro
 		return errors.Wrapf(err, "error getting job: %s", t.JobID)
 	}
 	// fire all parallel tasks
-	for _, pt := range t.Parallel.Tasks {
+	for ix, pt := range t.Parallel.Tasks {
 		pt.ID = uuid.NewUUID()
 		pt.JobID = j.ID
 		pt.State = tork.TaskStatePending
 		pt.Position = t.Position
-		pt.CreatedAt = &now
+		createdAt := itemCreatedAt(now, ix)
+		pt.CreatedAt = &createdAt
 		pt.ParentID = t.ID
 		if err := eval.EvaluateTask(pt, j.Context.AsMap()); err != nil {
 			t.Error = err.Error()
@@ -281,3 +406,12 @@ func (s *Scheduler) scheduleParallelTask(ctx context.Context, t *tork.Task) erro
 	}
 	return nil
 }
+
+// itemCreatedAt returns the creation time of the ix-th
+// child of an each or parallel task. The children share
+// their parent's position so their creation time is what
+// orders them. Microseconds are the finest resolution
+// the postgres datastore keeps.
+func itemCreatedAt(now time.Time, ix int) time.Time {
+	return now.Add(time.Duration(ix) * time.Microsecond)
+}
@@ -8,6 +8,7 @@ import (
 	"time"
 
 	"github.com/runabol/tork"
+	"github.com/runabol/tork/datastore"
 	"github.com/runabol/tork/datastore/inmemory"
 	"github.com/runabol/tork/internal/uuid"
 	"github.com/runabol/tork/mq"
@@ -56,6 +57,115 @@ func Test_scheduleRegularTask(t *testing.T) {
 	assert.Equal(t, tork.TaskStateScheduled, tk.State)
 }
 
+func Test_scheduleRegularTaskTrace(t *testing.T) {
+	ctx := context.Background()
+	b := mq.NewInMemoryBroker()
+
+	published := make(chan *tork.Task, 1)
+	err := b.SubscribeForTasks("test-queue", func(t *tork.Task) error {
+		published <- t
+		return nil
+	})
+	assert.NoError(t, err)
+
+	ds := inmemory.NewInMemoryDatastore()
+	s := NewScheduler(ds, b)
+
+	j1 := &tork.Job{
+		ID:   uuid.NewUUID(),
+		Name: "test job",
+		Trace: map[string]string{
+			"traceparent": "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		},
+	}
+	err = ds.CreateJob(ctx, j1)
+	assert.NoError(t, err)
+
+	tk := &tork.Task{
+		ID:    uuid.NewUUID(),
+		Queue: "test-queue",
+		JobID: j1.ID,
+	}
+	err = ds.CreateTask(ctx, tk)
+	assert.NoError(t, err)
+
+	err = s.scheduleRegularTask(ctx, tk)
+	assert.NoError(t, err)
+
+	// the task sent to the worker continues the job's trace
+	pt := <-published
+	assert.Contains(t, pt.Trace["traceparent"], "4bf92f3577b34da6a3ce929d0e0e4736")
+}
+
+func Test_scheduleRegularTaskWithSecrets(t *testing.T) {
+	ctx := context.Background()
+	b := mq.NewInMemoryBroker()
+
+	processed := make(chan *tork.Task, 1)
+	err := b.SubscribeForTasks("test-queue", func(t *tork.Task) error {
+		processed <- t
+		return nil
+	})
+	assert.NoError(t, err)
+
+	ds := inmemory.NewInMemoryDatastore()
+	s := NewScheduler(ds, b)
+
+	err = ds.SetSecret(ctx, &tork.Secret{Name: "db-password", Value: "shhh"})
+	assert.NoError(t, err)
+
+	j1 := &tork.Job{
+		ID:   uuid.NewUUID(),
+		Name: "test job",
+	}
+	err = ds.CreateJob(ctx, j1)
+	assert.NoError(t, err)
+
+	tk := &tork.Task{
+		ID:    uuid.NewUUID(),
+		Queue: "test-queue",
+		JobID: j1.ID,
+		Env: map[string]string{
+			"DB_PASSWORD": "secret://db-password",
+			"OTHER":       "value",
+		},
+		Pre: []*tork.Task{{
+			Env: map[string]string{
+				"DB_PASSWORD": "secret://db-password",
+			},
+		}},
+	}
+
+	err = ds.CreateTask(ctx, tk)
+	assert.NoError(t, err)
+
+	err = s.scheduleRegularTask(ctx, tk)
+	assert.NoError(t, err)
+
+	published := <-processed
+	assert.Equal(t, "shhh", published.Env["DB_PASSWORD"])
+	assert.Equal(t, "value", published.Env["OTHER"])
+	assert.Equal(t, "shhh", published.Pre[0].Env["DB_PASSWORD"])
+
+	// the stored task keeps the reference
+	tk, err = ds.GetTaskByID(ctx, tk.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, "secret://db-password", tk.Env["DB_PASSWORD"])
+
+	t2 := &tork.Task{
+		ID:    uuid.NewUUID(),
+		Queue: "test-queue",
+		JobID: j1.ID,
+		Env: map[string]string{
+			"API_KEY": "secret://no-such-secret",
+		},
+	}
+	err = ds.CreateTask(ctx, t2)
+	assert.NoError(t, err)
+	err = s.scheduleRegularTask(ctx, t2)
+	assert.ErrorContains(t, err, "unknown secret no-such-secret")
+}
+
 func Test_scheduleRegularTaskOverrideDefaultQueue(t *testing.T) {
 	ctx := context.Background()
 	b := mq.NewInMemoryBroker()
@@ -101,6 +211,200 @@ func Test_scheduleRegularTaskOverrideDefaultQueue(t *testing.T) {
 	assert.Equal(t, tork.TaskStateScheduled, tk.State)
 }
 
+func Test_scheduleRegularTaskGPUQueue(t *testing.T) {
+	ctx := context.Background()
+	b := mq.NewInMemoryBroker()
+
+	processed := make(chan any)
+	err := b.SubscribeForTasks(mq.QUEUE_GPU, func(t *tork.Task) error {
+		close(processed)
+		return nil
+	})
+	assert.NoError(t, err)
+
+	ds := inmemory.NewInMemoryDatastore()
+	s := NewScheduler(ds, b)
+
+	j1 := &tork.Job{
+		ID:   uuid.NewUUID(),
+		Name: "test job",
+	}
+	err = ds.CreateJob(ctx, j1)
+	assert.NoError(t, err)
+
+	tk := &tork.Task{
+		ID:    uuid.NewUUID(),
+		JobID: j1.ID,
+		GPUs:  "all",
+	}
+	err = ds.CreateTask(ctx, tk)
+	assert.NoError(t, err)
+
+	err = s.scheduleRegularTask(ctx, tk)
+	assert.NoError(t, err)
+
+	<-processed
+
+	tk, err = ds.GetTaskByID(ctx, tk.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, mq.QUEUE_GPU, tk.Queue)
+}
+
+func Test_scheduleRegularTaskPlatformQueue(t *testing.T) {
+	ctx := context.Background()
+	b := mq.NewInMemoryBroker()
+
+	processed := make(chan any)
+	err := b.SubscribeForTasks("platform-linux-arm64", func(t *tork.Task) error {
+		close(processed)
+		return nil
+	})
+	assert.NoError(t, err)
+
+	ds := inmemory.NewInMemoryDatastore()
+	s := NewScheduler(ds, b)
+
+	j1 := &tork.Job{
+		ID:   uuid.NewUUID(),
+		Name: "test job",
+	}
+	err = ds.CreateJob(ctx, j1)
+	assert.NoError(t, err)
+
+	tk := &tork.Task{
+		ID:       uuid.NewUUID(),
+		JobID:    j1.ID,
+		Platform: "linux/arm64",
+	}
+	err = ds.CreateTask(ctx, tk)
+	assert.NoError(t, err)
+
+	err = s.scheduleRegularTask(ctx, tk)
+	assert.NoError(t, err)
+
+	<-processed
+
+	tk, err = ds.GetTaskByID(ctx, tk.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, "platform-linux-arm64", tk.Queue)
+}
+
+func Test_scheduleRegularTaskSelector(t *testing.T) {
+	ctx := context.Background()
+	b := mq.NewInMemoryBroker()
+
+	processed := make(chan any)
+	err := b.SubscribeForTasks("tags-disk=ssd,region=eu", func(t *tork.Task) error {
+		close(processed)
+		return nil
+	})
+	assert.NoError(t, err)
+
+	ds := inmemory.NewInMemoryDatastore()
+	s := NewScheduler(ds, b)
+
+	now := time.Now().UTC()
+	err = ds.CreateNode(ctx, &tork.Node{
+		ID:              uuid.NewUUID(),
+		Status:          tork.NodeStatusUP,
+		LastHeartbeatAt: now,
+		Tags:            map[string]string{"region": "us", "disk": "ssd"},
+	})
+	assert.NoError(t, err)
+	err = ds.CreateNode(ctx, &tork.Node{
+		ID:              uuid.NewUUID(),
+		Status:          tork.NodeStatusUP,
+		LastHeartbeatAt: now,
+		Tags:            map[string]string{"region": "eu", "disk": "ssd"},
+	})
+	assert.NoError(t, err)
+
+	j1 := &tork.Job{
+		ID:   uuid.NewUUID(),
+		Name: "test job",
+	}
+	err = ds.CreateJob(ctx, j1)
+	assert.NoError(t, err)
+
+	tk := &tork.Task{
+		ID:       uuid.NewUUID(),
+		JobID:    j1.ID,
+		Selector: map[string]string{"region": "eu"},
+	}
+	err = ds.CreateTask(ctx, tk)
+	assert.NoError(t, err)
+
+	err = s.scheduleRegularTask(ctx, tk)
+	assert.NoError(t, err)
+
+	<-processed
+
+	tk, err = ds.GetTaskByID(ctx, tk.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, "tags-disk=ssd,region=eu", tk.Queue)
+
+	tk2 := &tork.Task{
+		ID:       uuid.NewUUID(),
+		JobID:    j1.ID,
+		Selector: map[string]string{"region": "ap"},
+	}
+	err = ds.CreateTask(ctx, tk2)
+	assert.NoError(t, err)
+
+	err = s.scheduleRegularTask(ctx, tk2)
+	assert.Error(t, err)
+}
+
+func Test_scheduleRegularTaskGPUCount(t *testing.T) {
+	ctx := context.Background()
+	b := mq.NewInMemoryBroker()
+
+	processed := make(chan any)
+	err := b.SubscribeForTasks(mq.NodeQueue(nil, 4, ""), func(t *tork.Task) error {
+		close(processed)
+		return nil
+	})
+	assert.NoError(t, err)
+
+	ds := inmemory.NewInMemoryDatastore()
+	s := NewScheduler(ds, b)
+
+	now := time.Now().UTC()
+	for _, gpus := range []int{1, 4} {
+		err = ds.CreateNode(ctx, &tork.Node{
+			ID:              uuid.NewUUID(),
+			Status:          tork.NodeStatusUP,
+			LastHeartbeatAt: now,
+			GPUs:            gpus,
+		})
+		assert.NoError(t, err)
+	}
+
+	j1 := &tork.Job{
+		ID:   uuid.NewUUID(),
+		Name: "test job",
+	}
+	err = ds.CreateJob(ctx, j1)
+	assert.NoError(t, err)
+
+	tk := &tork.Task{
+		ID:    uuid.NewUUID(),
+		JobID: j1.ID,
+		GPUs:  "2",
+	}
+	err = ds.CreateTask(ctx, tk)
+	assert.NoError(t, err)
+
+	err = s.scheduleRegularTask(ctx, tk)
+	assert.NoError(t, err)
+
+	<-processed
+
+	tk, err = ds.GetTaskByID(ctx, tk.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, "tags-tork.gpus=4", tk.Queue)
+}
+
 func Test_scheduleRegularTaskJobDefaults(t *testing.T) {
 	ctx := context.Background()
 	b := mq.NewInMemoryBroker()
@@ -264,6 +568,110 @@ func Test_scheduleEachTask(t *testing.T) {
 	assert.Equal(t, int32(2), counter.Load())
 }
 
+func Test_scheduleEachTaskConcurrency(t *testing.T) {
+	ctx := context.Background()
+	b := mq.NewInMemoryBroker()
+
+	processed := make(chan any, 3)
+	var counter atomic.Int32
+	err := b.SubscribeForTasks(mq.QUEUE_PENDING, func(tk *tork.Task) error {
+		processed <- 1
+		counter.Add(1)
+		return nil
+	})
+	assert.NoError(t, err)
+
+	ds := inmemory.NewInMemoryDatastore()
+	s := NewScheduler(ds, b)
+
+	j := &tork.Job{
+		ID:   uuid.NewUUID(),
+		Name: "test job",
+	}
+
+	err = ds.CreateJob(ctx, j)
+	assert.NoError(t, err)
+
+	tk := &tork.Task{
+		ID:    uuid.NewUUID(),
+		JobID: j.ID,
+		Each: &tork.EachTask{
+			List:        "{{ sequence (1,4) }}",
+			Concurrency: 1,
+			Task: &tork.Task{
+				Queue: "test-queue",
+			},
+		},
+	}
+
+	err = ds.CreateTask(ctx, tk)
+	assert.NoError(t, err)
+
+	err = s.scheduleEachTask(ctx, tk)
+	assert.NoError(t, err)
+
+	<-processed
+	time.Sleep(time.Millisecond * 100)
+
+	// only one item should be released
+	assert.Equal(t, int32(1), counter.Load())
+
+	next, err := ds.GetNextTask(ctx, tk.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, tork.TaskStateCreated, next.State)
+}
+
+func Test_scheduleEachTaskConcurrencyOrder(t *testing.T) {
+	ctx := context.Background()
+	b := mq.NewInMemoryBroker()
+
+	ds := inmemory.NewInMemoryDatastore()
+	s := NewScheduler(ds, b)
+
+	j := &tork.Job{
+		ID:   uuid.NewUUID(),
+		Name: "test job",
+	}
+
+	err := ds.CreateJob(ctx, j)
+	assert.NoError(t, err)
+
+	tk := &tork.Task{
+		ID:    uuid.NewUUID(),
+		JobID: j.ID,
+		Each: &tork.EachTask{
+			List:        "{{ sequence (1,11) }}",
+			Concurrency: 2,
+			Task: &tork.Task{
+				Queue: "test-queue",
+				Env: map[string]string{
+					"INDEX": "{{ item.index }}",
+				},
+			},
+		},
+	}
+
+	err = ds.CreateTask(ctx, tk)
+	assert.NoError(t, err)
+
+	err = s.scheduleEachTask(ctx, tk)
+	assert.NoError(t, err)
+
+	// the held back items are released in the order of the list
+	for ix := 2; ix < 10; ix++ {
+		next, err := ds.GetNextTask(ctx, tk.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, fmt.Sprintf("%d", ix), next.Env["INDEX"])
+		err = ds.UpdateTask(ctx, next.ID, func(u *tork.Task) error {
+			u.State = tork.TaskStatePending
+			return nil
+		})
+		assert.NoError(t, err)
+	}
+	_, err = ds.GetNextTask(ctx, tk.ID)
+	assert.ErrorIs(t, err, datastore.ErrTaskNotFound)
+}
+
 func Test_scheduleEachTaskNotaList(t *testing.T) {
 	ctx := context.Background()
 	b := mq.NewInMemoryBroker()
@@ -459,6 +867,7 @@ func Test_scheduleDetachedSubJobTask(t *testing.T) {
 	processed := make(chan any)
 	err := b.SubscribeForJobs(func(j *tork.Job) error {
 		assert.Empty(t, j.ParentID)
+		assert.Equal(t, "team-a", j.Namespace)
 		assert.Equal(t, "http://example.com/callback", j.Webhooks[0].URL)
 		close(processed)
 		return nil
@@ -478,8 +887,9 @@ func Test_scheduleDetachedSubJobTask(t *testing.T) {
 	assert.NotNil(t, s)
 
 	j := &tork.Job{
-		ID:   uuid.NewUUID(),
-		Name: "test job",
+		ID:        uuid.NewUUID(),
+		Name:      "test job",
+		Namespace: "team-a",
 	}
 
 	err = ds.CreateJob(ctx, j)
@@ -0,0 +1,68 @@
+package coordinator
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/runabol/tork"
+	"github.com/runabol/tork/mq"
+)
+
+// how often running tasks are checked for unresponsive worker nodes
+var stalledTasksInterval = time.Minute
+
+func (c *Coordinator) failStalledTasks() {
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-time.After(stalledTasksInterval):
+		}
+		if !c.IsLeader() {
+			continue
+		}
+		if err := c.failStalledTasksBefore(context.Background(), time.Now().UTC().Add(-c.stallAfter)); err != nil {
+			log.Error().Err(err).Msg("error failing stalled tasks")
+		}
+	}
+}
+
+// failStalledTasksBefore fails the running tasks of worker nodes whose
+// last heartbeat is older than the given time, and hands them over to
+// the error handler so that their retry policy, if any, is honored.
+// A task is only claimed while it is still running on the same node,
+// so only one coordinator fails each task.
+func (c *Coordinator) failStalledTasksBefore(ctx context.Context, before time.Time) error {
+	tasks, err := c.ds.GetStalledTasks(ctx, before)
+	if err != nil {
+		return err
+	}
+	for _, t := range tasks {
+		var failed *tork.Task
+		if err := c.ds.UpdateTask(ctx, t.ID, func(u *tork.Task) error {
+			failed = nil
+			if u.State != tork.TaskStateRunning || u.NodeID != t.NodeID {
+				return nil
+			}
+			now := time.Now().UTC()
+			u.State = tork.TaskStateFailed
+			u.FailedAt = &now
+			u.Error = fmt.Sprintf("worker node %s stopped responding", t.NodeID)
+			failed = u.Clone()
+			return nil
+		}); err != nil {
+			log.Error().Err(err).Msgf("error failing stalled task %s", t.ID)
+			continue
+		}
+		if failed == nil {
+			continue
+		}
+		log.Warn().Msgf("task %s failed: worker node %s stopped responding", t.ID, t.NodeID)
+		if err := c.broker.PublishTask(ctx, mq.QUEUE_ERROR, failed); err != nil {
+			log.Error().Err(err).Msgf("error publishing stalled task %s", t.ID)
+		}
+	}
+	return nil
+}
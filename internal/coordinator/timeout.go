@@ -0,0 +1,66 @@
+package coordinator
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/runabol/tork"
+)
+
+// how often active jobs are checked for an exceeded timeout
+var timedOutJobsInterval = time.Second * 10
+
+func (c *Coordinator) failTimedOutJobs() {
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-time.After(timedOutJobsInterval):
+		}
+		if !c.IsLeader() {
+			continue
+		}
+		if err := c.failTimedOutJobsBefore(context.Background(), time.Now().UTC()); err != nil {
+			log.Error().Err(err).Msg("error failing timed out jobs")
+		}
+	}
+}
+
+// failTimedOutJobsBefore fails the active jobs whose deadline is
+// older than the given time and hands them over to the job handler,
+// which cancels their pending and running tasks. A job is only
+// claimed while it is still active, so only one coordinator fails it.
+func (c *Coordinator) failTimedOutJobsBefore(ctx context.Context, before time.Time) error {
+	jobs, err := c.ds.GetTimedOutJobs(ctx, before)
+	if err != nil {
+		return err
+	}
+	for _, j := range jobs {
+		var failed *tork.Job
+		if err := c.ds.UpdateJob(ctx, j.ID, func(u *tork.Job) error {
+			failed = nil
+			if u.State != tork.JobStateRunning && u.State != tork.JobStateScheduled {
+				return nil
+			}
+			now := time.Now().UTC()
+			u.State = tork.JobStateFailed
+			u.FailedAt = &now
+			u.Error = fmt.Sprintf("job timed out after %s", u.Timeout)
+			failed = u.Clone()
+			return nil
+		}); err != nil {
+			log.Error().Err(err).Msgf("error failing timed out job %s", j.ID)
+			continue
+		}
+		if failed == nil {
+			continue
+		}
+		log.Warn().Msgf("job %s failed: timed out after %s", j.ID, j.Timeout)
+		if err := c.broker.PublishJob(ctx, failed); err != nil {
+			log.Error().Err(err).Msgf("error publishing timed out job %s", j.ID)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package cron_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/runabol/tork/internal/cron"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseInvalid(t *testing.T) {
+	specs := []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"x * * * *",
+		"@sometimes",
+	}
+	for _, spec := range specs {
+		_, err := cron.Parse(spec)
+		assert.Error(t, err, spec)
+	}
+}
+
+func TestNext(t *testing.T) {
+	from := time.Date(2024, 1, 15, 10, 30, 45, 0, time.UTC) // a monday
+	tests := []struct {
+		spec string
+		next time.Time
+	}{
+		{"* * * * *", time.Date(2024, 1, 15, 10, 31, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2024, 1, 15, 10, 45, 0, 0, time.UTC)},
+		{"0 * * * *", time.Date(2024, 1, 15, 11, 0, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2024, 1, 15, 11, 0, 0, 0, time.UTC)},
+		{"@daily", time.Date(2024, 1, 16, 0, 0, 0, 0, time.UTC)},
+		{"0 9 * * MON-FRI", time.Date(2024, 1, 16, 9, 0, 0, 0, time.UTC)},
+		{"0 9 * * 0", time.Date(2024, 1, 21, 9, 0, 0, 0, time.UTC)},
+		{"0 9 * * 7", time.Date(2024, 1, 21, 9, 0, 0, 0, time.UTC)},
+		{"30 10 1 * *", time.Date(2024, 2, 1, 10, 30, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"0 0 1 jan *", time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"5,10 12 * * *", time.Date(2024, 1, 15, 12, 5, 0, 0, time.UTC)},
+		{"0-10/5 11 * * *", time.Date(2024, 1, 15, 11, 0, 0, 0, time.UTC)},
+		// day-of-month or day-of-week when both are restricted
+		{"0 0 20 * 3", time.Date(2024, 1, 17, 0, 0, 0, 0, time.UTC)},
+		// a stepped wildcard still counts as unrestricted
+		{"0 0 */2 * 1", time.Date(2024, 1, 29, 0, 0, 0, 0, time.UTC)},
+		{"0 0 1 * */2", time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		s, err := cron.Parse(tt.spec)
+		assert.NoError(t, err, tt.spec)
+		assert.Equal(t, tt.next, s.Next(from), tt.spec)
+	}
+}
@@ -0,0 +1,102 @@
+package debug
+
+import (
+	"bufio"
+	"bytes"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+const modulePrefix = "github.com/runabol/tork/"
+
+// Stats describes the state of the process.
+type Stats struct {
+	Goroutines int `json:"goroutines"`
+	// the number of goroutines started by each subsystem,
+	// keyed by the package of the function that started them.
+	Subsystems map[string]int `json:"subsystems"`
+	HeapAlloc  uint64         `json:"heapAlloc"`
+	HeapInuse  uint64         `json:"heapInuse"`
+	NumGC      uint32         `json:"numGC"`
+}
+
+// RegisterPprof registers the net/http/pprof handlers
+// under /debug/pprof, behind the given middleware.
+func RegisterPprof(r *echo.Echo, m ...echo.MiddlewareFunc) {
+	r.GET("/debug/pprof/*", echo.WrapHandler(http.HandlerFunc(pprof.Index)), m...)
+	r.GET("/debug/pprof/cmdline", echo.WrapHandler(http.HandlerFunc(pprof.Cmdline)), m...)
+	r.GET("/debug/pprof/profile", echo.WrapHandler(http.HandlerFunc(pprof.Profile)), m...)
+	r.GET("/debug/pprof/symbol", echo.WrapHandler(http.HandlerFunc(pprof.Symbol)), m...)
+	r.POST("/debug/pprof/symbol", echo.WrapHandler(http.HandlerFunc(pprof.Symbol)), m...)
+	r.GET("/debug/pprof/trace", echo.WrapHandler(http.HandlerFunc(pprof.Trace)), m...)
+}
+
+// ReadStats returns the current state of the process.
+func ReadStats() Stats {
+	ms := runtime.MemStats{}
+	runtime.ReadMemStats(&ms)
+	return Stats{
+		Goroutines: runtime.NumGoroutine(),
+		Subsystems: goroutinesBySubsystem(stacks()),
+		HeapAlloc:  ms.HeapAlloc,
+		HeapInuse:  ms.HeapInuse,
+		NumGC:      ms.NumGC,
+	}
+}
+
+// stacks returns the stack traces of all the goroutines.
+func stacks() []byte {
+	buf := make([]byte, 64*1024)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			return buf[:n]
+		}
+		buf = make([]byte, len(buf)*2)
+	}
+}
+
+func goroutinesBySubsystem(stacks []byte) map[string]int {
+	result := make(map[string]int)
+	scanner := bufio.NewScanner(bytes.NewReader(stacks))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	var inGoroutine bool
+	var subsystem string
+	flush := func() {
+		if inGoroutine {
+			result[subsystem]++
+		}
+	}
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "goroutine "):
+			flush()
+			inGoroutine = true
+			subsystem = "main"
+		case strings.HasPrefix(line, "created by "):
+			fn := strings.TrimPrefix(line, "created by ")
+			if i := strings.Index(fn, " in goroutine"); i >= 0 {
+				fn = fn[:i]
+			}
+			subsystem = packageOf(fn)
+		}
+	}
+	flush()
+	return result
+}
+
+// packageOf returns the package of a fully qualified
+// function name, relative to the module for Tork's own.
+func packageOf(fn string) string {
+	pkg := fn
+	slash := strings.LastIndex(pkg, "/")
+	if dot := strings.Index(pkg[slash+1:], "."); dot >= 0 {
+		pkg = pkg[:slash+1+dot]
+	}
+	return strings.TrimPrefix(pkg, modulePrefix)
+}
@@ -0,0 +1,65 @@
+package debug
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadStats(t *testing.T) {
+	stop := make(chan any)
+	defer close(stop)
+	go func() {
+		<-stop
+	}()
+	s := ReadStats()
+	assert.Greater(t, s.Goroutines, 1)
+	assert.Greater(t, s.HeapAlloc, uint64(0))
+	assert.Greater(t, s.Subsystems["internal/debug"], 0)
+}
+
+func TestGoroutinesBySubsystem(t *testing.T) {
+	stacks := `goroutine 1 [running]:
+main.main()
+	/src/main.go:12 +0x1d
+
+goroutine 7 [chan receive]:
+github.com/runabol/tork/internal/worker.(*Worker).sendHeartbeats(0xc000132000)
+	/src/internal/worker/worker.go:540 +0x5a
+created by github.com/runabol/tork/internal/worker.(*Worker).Start in goroutine 1
+	/src/internal/worker/worker.go:620 +0x1b2
+
+goroutine 8 [IO wait]:
+internal/poll.runtime_pollWait(0x7f, 0x72)
+	/go/src/runtime/netpoll.go:345 +0x85
+created by net/http.(*Server).Serve in goroutine 1
+	/go/src/net/http/server.go:3285 +0x4b4
+
+goroutine 9 [select]:
+github.com/runabol/tork/mq.(*RabbitMQBroker).consume.func1()
+	/src/mq/rabbitmq.go:200 +0x45
+created by github.com/runabol/tork/mq.(*RabbitMQBroker).consume
+	/src/mq/rabbitmq.go:190 +0x20
+`
+	assert.Equal(t, map[string]int{
+		"main":            1,
+		"internal/worker": 1,
+		"net/http":        1,
+		"mq":              1,
+	}, goroutinesBySubsystem([]byte(stacks)))
+}
+
+func TestRegisterPprof(t *testing.T) {
+	r := echo.New()
+	RegisterPprof(r)
+	for _, path := range []string{"/debug/pprof/", "/debug/pprof/goroutine?debug=1", "/debug/pprof/cmdline"} {
+		req, err := http.NewRequest("GET", path, nil)
+		assert.NoError(t, err)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code, path)
+	}
+}
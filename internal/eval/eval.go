@@ -47,6 +47,14 @@ func EvaluateTask(t *tork.Task, c map[string]any) error {
 		env[k] = result
 	}
 	t.Env = env
+	// evaluate the download urls
+	for _, d := range t.Downloads {
+		u, err := EvaluateTemplate(d.URL, c)
+		if err != nil {
+			return err
+		}
+		d.URL = u
+	}
 	// evaluate if expr
 	ifExpr, err := EvaluateTemplate(t.If, c)
 	if err != nil {
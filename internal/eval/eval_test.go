@@ -98,6 +98,22 @@ func TestEvalQueue(t *testing.T) {
 	assert.Equal(t, "default", t1.Queue)
 }
 
+func TestEvalDownloads(t *testing.T) {
+	t1 := &tork.Task{
+		Downloads: []*tork.Artifact{{
+			URL:  "{{ inputs.URL }}",
+			Path: "data.csv",
+		}},
+	}
+	err := eval.EvaluateTask(t1, map[string]any{
+		"inputs": map[string]string{
+			"URL": "https://example.com/data.csv",
+		},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "https://example.com/data.csv", t1.Downloads[0].URL)
+}
+
 func TestEvalFunc(t *testing.T) {
 	t1 := &tork.Task{
 		Env: map[string]string{
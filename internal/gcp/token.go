@@ -0,0 +1,56 @@
+// Package gcp holds the helpers shared by the
+// clients of the Google Cloud REST APIs.
+package gcp
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// the metadata server endpoint of the service
+// account's access token on GCE, GKE and Cloud Run
+var MetadataTokenURL = "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token"
+
+// MetadataTokenSource returns a function which fetches the access
+// token of the instance's service account and caches it until
+// shortly before it expires.
+func MetadataTokenSource(client *http.Client) func(ctx context.Context) (string, error) {
+	var mu sync.Mutex
+	var token string
+	var expiresAt time.Time
+	return func(ctx context.Context) (string, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if token != "" && time.Now().Before(expiresAt) {
+			return token, nil
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, MetadataTokenURL, nil)
+		if err != nil {
+			return "", err
+		}
+		req.Header.Set("Metadata-Flavor", "Google")
+		resp, err := client.Do(req)
+		if err != nil {
+			return "", errors.Wrapf(err, "error fetching access token from the metadata server")
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return "", errors.Errorf("error fetching access token from the metadata server: %s", resp.Status)
+		}
+		t := struct {
+			AccessToken string `json:"access_token"`
+			ExpiresIn   int    `json:"expires_in"`
+		}{}
+		if err := json.NewDecoder(resp.Body).Decode(&t); err != nil {
+			return "", errors.Wrapf(err, "error decoding access token")
+		}
+		token = t.AccessToken
+		expiresAt = time.Now().Add(time.Duration(t.ExpiresIn)*time.Second - time.Minute)
+		return token, nil
+	}
+}
@@ -0,0 +1,14 @@
+package hash
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// Key returns the SHA-256 digest of an API key. Unlike passwords,
+// API keys are high-entropy random values so a fast hash is
+// sufficient and allows looking keys up by their hash.
+func Key(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,16 @@
+package hash_test
+
+import (
+	"testing"
+
+	"github.com/runabol/tork/internal/hash"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHashKey(t *testing.T) {
+	h1 := hash.Key("1234")
+	h2 := hash.Key("1234")
+	assert.Equal(t, h1, h2)
+	assert.Len(t, h1, 64)
+	assert.NotEqual(t, h1, hash.Key("12345"))
+}
@@ -1,8 +1,15 @@
 package host
 
 import (
+	"encoding/csv"
+	"path/filepath"
+	"strconv"
+	"strings"
+
 	"github.com/rs/zerolog/log"
 	"github.com/shirou/gopsutil/v3/cpu"
+	"github.com/shirou/gopsutil/v3/disk"
+	"github.com/shirou/gopsutil/v3/mem"
 )
 
 func GetCPUPercent() float64 {
@@ -15,3 +22,79 @@ func GetCPUPercent() float64 {
 	}
 	return perc[0]
 }
+
+func GetMemoryPercent() float64 {
+	v, err := mem.VirtualMemory()
+	if err != nil {
+		log.Warn().
+			Err(err).
+			Msgf("error getting memory usage")
+		return 0
+	}
+	return v.UsedPercent
+}
+
+// GetGPUCount returns the number of NVIDIA
+// GPU devices that are available on the host.
+func GetGPUCount() int {
+	devices, err := filepath.Glob("/dev/nvidia[0-9]*")
+	if err != nil {
+		log.Warn().
+			Err(err).
+			Msgf("error listing GPU devices")
+		return 0
+	}
+	return len(devices)
+}
+
+// RequiredGPUs returns the number of GPUs requested by a task's
+// gpus spec, which follows the syntax of docker run's --gpus flag:
+// all, a count (2), or key/value pairs (count=2 or "device=0,1").
+// A request for all the GPUs requires at least one.
+func RequiredGPUs(spec string) int {
+	spec = strings.TrimSpace(spec)
+	if spec == "" {
+		return 0
+	}
+	fields, err := csv.NewReader(strings.NewReader(spec)).Read()
+	if err != nil {
+		return 1
+	}
+	required := 1
+	var devices bool
+	for _, f := range fields {
+		k, v, ok := strings.Cut(f, "=")
+		if !ok && devices {
+			// an unquoted device list, e.g. device=0,1
+			required++
+			continue
+		}
+		devices = k == "device"
+		switch {
+		case !ok:
+			if n, err := strconv.Atoi(k); err == nil && n > 0 {
+				required = n
+			}
+		case k == "count":
+			if n, err := strconv.Atoi(v); err == nil && n > 0 {
+				required = n
+			}
+		case k == "device":
+			required = len(strings.Split(v, ","))
+		}
+	}
+	return required
+}
+
+// GetDiskPercent returns the usage of the
+// filesystem the given path resides on.
+func GetDiskPercent(path string) float64 {
+	u, err := disk.Usage(path)
+	if err != nil {
+		log.Warn().
+			Err(err).
+			Msgf("error getting disk usage for %s", path)
+		return 0
+	}
+	return u.UsedPercent
+}
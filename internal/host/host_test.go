@@ -9,4 +9,21 @@ import (
 func TestGetStats(t *testing.T) {
 	cpuPercent := GetCPUPercent()
 	assert.GreaterOrEqual(t, cpuPercent, float64(0))
+	memPercent := GetMemoryPercent()
+	assert.Greater(t, memPercent, float64(0))
+	assert.LessOrEqual(t, memPercent, float64(100))
+	diskPercent := GetDiskPercent("/")
+	assert.GreaterOrEqual(t, diskPercent, float64(0))
+	assert.LessOrEqual(t, diskPercent, float64(100))
+	assert.GreaterOrEqual(t, GetGPUCount(), 0)
+}
+
+func TestRequiredGPUs(t *testing.T) {
+	assert.Equal(t, 0, RequiredGPUs(""))
+	assert.Equal(t, 1, RequiredGPUs("all"))
+	assert.Equal(t, 2, RequiredGPUs("2"))
+	assert.Equal(t, 3, RequiredGPUs("count=3,capabilities=utility"))
+	assert.Equal(t, 1, RequiredGPUs("count=all"))
+	assert.Equal(t, 2, RequiredGPUs(`"device=0,1"`))
+	assert.Equal(t, 3, RequiredGPUs("device=0,1,2"))
 }
@@ -0,0 +1,126 @@
+package metrics
+
+import (
+	"context"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/runabol/tork"
+	"github.com/runabol/tork/mq"
+)
+
+// InstrumentBroker wraps the broker so that every message
+// published and consumed through it is counted per queue.
+func InstrumentBroker(b mq.Broker) mq.Broker {
+	return &broker{Broker: b}
+}
+
+type broker struct {
+	mq.Broker
+}
+
+// queueLabel returns the label the messages of the queue are counted
+// under. Every node has an exclusive queue and every combination of
+// tags, GPUs and platform has a queue of its own, so these are counted
+// under a fixed label each to keep the number of series bounded.
+func queueLabel(qname string) string {
+	switch {
+	case strings.HasPrefix(qname, mq.QUEUE_EXCLUSIVE_PREFIX):
+		return "exclusive"
+	case strings.HasPrefix(qname, mq.QUEUE_TAGS_PREFIX):
+		return "node"
+	case strings.HasPrefix(qname, mq.QUEUE_PLATFORM_PREFIX):
+		return "platform"
+	}
+	return qname
+}
+
+func (b *broker) PublishTask(ctx context.Context, qname string, t *tork.Task) error {
+	if err := b.Broker.PublishTask(ctx, qname, t); err != nil {
+		return err
+	}
+	MessagesPublished.Inc(queueLabel(qname))
+	return nil
+}
+
+func (b *broker) SubscribeForTasks(qname string, handler func(t *tork.Task) error) error {
+	return b.Broker.SubscribeForTasks(qname, func(t *tork.Task) error {
+		MessagesConsumed.Inc(queueLabel(qname))
+		return handler(t)
+	})
+}
+
+func (b *broker) UnsubscribeForTasks(qname string) error {
+	u, ok := b.Broker.(mq.TaskUnsubscriber)
+	if !ok {
+		return errors.Errorf("the broker can't unsubscribe from %s", qname)
+	}
+	return u.UnsubscribeForTasks(qname)
+}
+
+func (b *broker) SubscribeForDeadLetters(handler func(dl *tork.DeadLetter) error) error {
+	return b.Broker.SubscribeForDeadLetters(func(dl *tork.DeadLetter) error {
+		MessagesConsumed.Inc(mq.QUEUE_DEAD_LETTER)
+		return handler(dl)
+	})
+}
+
+func (b *broker) PublishTaskProgress(ctx context.Context, t *tork.Task) error {
+	if err := b.Broker.PublishTaskProgress(ctx, t); err != nil {
+		return err
+	}
+	MessagesPublished.Inc(mq.QUEUE_PROGRESS)
+	return nil
+}
+
+func (b *broker) SubscribeForTaskProgress(handler func(t *tork.Task) error) error {
+	return b.Broker.SubscribeForTaskProgress(func(t *tork.Task) error {
+		MessagesConsumed.Inc(mq.QUEUE_PROGRESS)
+		return handler(t)
+	})
+}
+
+func (b *broker) PublishHeartbeat(ctx context.Context, n *tork.Node) error {
+	if err := b.Broker.PublishHeartbeat(ctx, n); err != nil {
+		return err
+	}
+	MessagesPublished.Inc(mq.QUEUE_HEARTBEAT)
+	return nil
+}
+
+func (b *broker) SubscribeForHeartbeats(handler func(n *tork.Node) error) error {
+	return b.Broker.SubscribeForHeartbeats(func(n *tork.Node) error {
+		MessagesConsumed.Inc(mq.QUEUE_HEARTBEAT)
+		return handler(n)
+	})
+}
+
+func (b *broker) PublishJob(ctx context.Context, j *tork.Job) error {
+	if err := b.Broker.PublishJob(ctx, j); err != nil {
+		return err
+	}
+	MessagesPublished.Inc(mq.QUEUE_JOBS)
+	return nil
+}
+
+func (b *broker) SubscribeForJobs(handler func(j *tork.Job) error) error {
+	return b.Broker.SubscribeForJobs(func(j *tork.Job) error {
+		MessagesConsumed.Inc(mq.QUEUE_JOBS)
+		return handler(j)
+	})
+}
+
+func (b *broker) PublishTaskLogPart(ctx context.Context, p *tork.TaskLogPart) error {
+	if err := b.Broker.PublishTaskLogPart(ctx, p); err != nil {
+		return err
+	}
+	MessagesPublished.Inc(mq.QUEUE_LOGS)
+	return nil
+}
+
+func (b *broker) SubscribeForTaskLogPart(handler func(p *tork.TaskLogPart)) error {
+	return b.Broker.SubscribeForTaskLogPart(func(p *tork.TaskLogPart) {
+		MessagesConsumed.Inc(mq.QUEUE_LOGS)
+		handler(p)
+	})
+}
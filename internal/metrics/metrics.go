@@ -0,0 +1,264 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ContentType is the content type of
+// the text exposition format.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Tork's own metrics. They are process-wide, so a
+// standalone engine reports the coordinator's and the
+// worker's metrics together.
+var (
+	TasksStarted = NewCounter(
+		"tork_tasks_started_total",
+		"Number of tasks a worker started executing.",
+	)
+	TasksCompleted = NewCounter(
+		"tork_tasks_completed_total",
+		"Number of tasks that completed successfully.",
+	)
+	TasksFailed = NewCounter(
+		"tork_tasks_failed_total",
+		"Number of tasks that failed.",
+	)
+	TaskDuration = NewHistogram(
+		"tork_task_duration_seconds",
+		"Time spent executing tasks.",
+		[]float64{1, 5, 15, 30, 60, 300, 900, 1800, 3600, 10800},
+	)
+	RuntimeErrors = NewCounter(
+		"tork_runtime_errors_total",
+		"Number of errors returned by the task runtime.",
+	)
+	MessagesPublished = NewCounterVec(
+		"tork_broker_messages_published_total",
+		"Number of messages published to the broker.",
+		"queue",
+	)
+	MessagesConsumed = NewCounterVec(
+		"tork_broker_messages_consumed_total",
+		"Number of messages consumed from the broker.",
+		"queue",
+	)
+)
+
+type metric interface {
+	name() string
+	write(w io.Writer)
+}
+
+var (
+	mu       sync.Mutex
+	registry = make(map[string]metric)
+)
+
+func register(m metric) {
+	mu.Lock()
+	defer mu.Unlock()
+	if _, ok := registry[m.name()]; ok {
+		panic("metrics: metric registered twice: " + m.name())
+	}
+	registry[m.name()] = m
+}
+
+// Write writes all the registered metrics in the
+// Prometheus text exposition format.
+func Write(w io.Writer) {
+	mu.Lock()
+	ms := make([]metric, 0, len(registry))
+	for _, m := range registry {
+		ms = append(ms, m)
+	}
+	mu.Unlock()
+	sort.Slice(ms, func(i, j int) bool {
+		return ms[i].name() < ms[j].name()
+	})
+	for _, m := range ms {
+		m.write(w)
+	}
+}
+
+// Counter is a monotonically increasing value.
+type Counter struct {
+	n    string
+	help string
+	mu   sync.Mutex
+	v    float64
+}
+
+func NewCounter(name, help string) *Counter {
+	c := &Counter{n: name, help: help}
+	register(c)
+	return c
+}
+
+func (c *Counter) Inc() {
+	c.Add(1)
+}
+
+func (c *Counter) Add(v float64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.v += v
+}
+
+func (c *Counter) Value() float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.v
+}
+
+func (c *Counter) name() string { return c.n }
+
+func (c *Counter) write(w io.Writer) {
+	writeHeader(w, c.n, c.help, "counter")
+	writeSample(w, c.n, "", c.Value())
+}
+
+// CounterVec is a set of counters that
+// are told apart by a single label.
+type CounterVec struct {
+	n      string
+	help   string
+	label  string
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+func NewCounterVec(name, help, label string) *CounterVec {
+	c := &CounterVec{n: name, help: help, label: label, values: make(map[string]float64)}
+	register(c)
+	return c
+}
+
+func (c *CounterVec) Inc(lv string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.values[lv]++
+}
+
+func (c *CounterVec) Value(lv string) float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.values[lv]
+}
+
+func (c *CounterVec) name() string { return c.n }
+
+func (c *CounterVec) write(w io.Writer) {
+	c.mu.Lock()
+	lvs := make([]string, 0, len(c.values))
+	for lv := range c.values {
+		lvs = append(lvs, lv)
+	}
+	sort.Strings(lvs)
+	values := make([]float64, len(lvs))
+	for i, lv := range lvs {
+		values[i] = c.values[lv]
+	}
+	c.mu.Unlock()
+	writeHeader(w, c.n, c.help, "counter")
+	for i, lv := range lvs {
+		writeSample(w, c.n, labels(c.label, lv), values[i])
+	}
+}
+
+// Histogram counts observations in
+// cumulative, upper-bounded buckets.
+type Histogram struct {
+	n       string
+	help    string
+	buckets []float64
+	mu      sync.Mutex
+	counts  []uint64
+	sum     float64
+	count   uint64
+}
+
+func NewHistogram(name, help string, buckets []float64) *Histogram {
+	h := &Histogram{
+		n:       name,
+		help:    help,
+		buckets: buckets,
+		counts:  make([]uint64, len(buckets)),
+	}
+	register(h)
+	return h
+}
+
+func (h *Histogram) Observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, b := range h.buckets {
+		if v <= b {
+			h.counts[i]++
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+func (h *Histogram) Count() uint64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.count
+}
+
+func (h *Histogram) name() string { return h.n }
+
+func (h *Histogram) write(w io.Writer) {
+	h.mu.Lock()
+	counts := make([]uint64, len(h.counts))
+	copy(counts, h.counts)
+	sum, count := h.sum, h.count
+	h.mu.Unlock()
+	writeHeader(w, h.n, h.help, "histogram")
+	for i, b := range h.buckets {
+		writeSample(w, h.n+"_bucket", labels("le", formatFloat(b)), float64(counts[i]))
+	}
+	writeSample(w, h.n+"_bucket", labels("le", "+Inf"), float64(count))
+	writeSample(w, h.n+"_sum", "", sum)
+	writeSample(w, h.n+"_count", "", float64(count))
+}
+
+// WriteGauge writes a single gauge sample,
+// for values that are computed on demand.
+func WriteGauge(w io.Writer, name, help string, v float64) {
+	writeHeader(w, name, help, "gauge")
+	writeSample(w, name, "", v)
+}
+
+func writeHeader(w io.Writer, name, help, typ string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, typ)
+}
+
+func writeSample(w io.Writer, name, labels string, v float64) {
+	fmt.Fprintf(w, "%s%s %s\n", name, labels, formatFloat(v))
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func labels(name, value string) string {
+	return fmt.Sprintf(`{%s="%s"}`, name, labelEscaper.Replace(value))
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	default:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
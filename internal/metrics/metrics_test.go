@@ -0,0 +1,96 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/runabol/tork"
+	"github.com/runabol/tork/mq"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWrite(t *testing.T) {
+	c := NewCounter("test_counter_total", "A test counter.")
+	c.Inc()
+	c.Add(2)
+	cv := NewCounterVec("test_counter_vec_total", "A test counter vec.", "queue")
+	cv.Inc("b")
+	cv.Inc("a")
+	cv.Inc("a")
+	h := NewHistogram("test_duration_seconds", "A test histogram.", []float64{1, 5})
+	h.Observe(0.5)
+	h.Observe(3)
+	h.Observe(10)
+
+	buf := new(bytes.Buffer)
+	Write(buf)
+	out := buf.String()
+
+	assert.Contains(t, out, "# TYPE test_counter_total counter\ntest_counter_total 3\n")
+	assert.Contains(t, out, "test_counter_vec_total{queue=\"a\"} 2\ntest_counter_vec_total{queue=\"b\"} 1\n")
+	assert.Contains(t, out, "# TYPE test_duration_seconds histogram\n")
+	assert.Contains(t, out, "test_duration_seconds_bucket{le=\"1\"} 1\n")
+	assert.Contains(t, out, "test_duration_seconds_bucket{le=\"5\"} 2\n")
+	assert.Contains(t, out, "test_duration_seconds_bucket{le=\"+Inf\"} 3\n")
+	assert.Contains(t, out, "test_duration_seconds_sum 13.5\n")
+	assert.Contains(t, out, "test_duration_seconds_count 3\n")
+	assert.Contains(t, out, "# TYPE tork_tasks_failed_total counter\n")
+}
+
+func TestRegisterTwice(t *testing.T) {
+	NewCounter("test_twice_total", "")
+	assert.Panics(t, func() {
+		NewCounter("test_twice_total", "")
+	})
+}
+
+func TestInstrumentBroker(t *testing.T) {
+	b := InstrumentBroker(mq.NewInMemoryBroker())
+	qname := "test-instrument"
+	done := make(chan any)
+	err := b.SubscribeForTasks(qname, func(t *tork.Task) error {
+		close(done)
+		return nil
+	})
+	assert.NoError(t, err)
+	err = b.PublishTask(context.Background(), qname, &tork.Task{})
+	assert.NoError(t, err)
+	<-done
+	assert.Equal(t, float64(1), MessagesPublished.Value(qname))
+	assert.Equal(t, float64(1), MessagesConsumed.Value(qname))
+}
+
+func TestInstrumentBrokerQueueLabels(t *testing.T) {
+	b := InstrumentBroker(mq.NewInMemoryBroker())
+	qnames := []string{
+		mq.QUEUE_EXCLUSIVE_PREFIX + "node-1",
+		mq.QUEUE_EXCLUSIVE_PREFIX + "node-2",
+		mq.NodeQueue(map[string]string{"disk": "ssd"}, 2, "linux/amd64"),
+		mq.TagsQueue(map[string]string{"region": "eu"}),
+		mq.PlatformQueue("linux/arm64"),
+	}
+	done := make(chan any, len(qnames))
+	for _, qname := range qnames {
+		err := b.SubscribeForTasks(qname, func(t *tork.Task) error {
+			done <- 1
+			return nil
+		})
+		assert.NoError(t, err)
+		err = b.PublishTask(context.Background(), qname, &tork.Task{})
+		assert.NoError(t, err)
+	}
+	for range qnames {
+		<-done
+	}
+	for _, qname := range qnames {
+		assert.Equal(t, float64(0), MessagesPublished.Value(qname))
+		assert.Equal(t, float64(0), MessagesConsumed.Value(qname))
+	}
+	assert.Equal(t, float64(2), MessagesPublished.Value("exclusive"))
+	assert.Equal(t, float64(2), MessagesConsumed.Value("exclusive"))
+	assert.Equal(t, float64(2), MessagesPublished.Value("node"))
+	assert.Equal(t, float64(2), MessagesConsumed.Value("node"))
+	assert.Equal(t, float64(1), MessagesPublished.Value("platform"))
+	assert.Equal(t, float64(1), MessagesConsumed.Value("platform"))
+}
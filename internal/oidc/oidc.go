@@ -0,0 +1,285 @@
+package oidc
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultCacheTTL        = time.Hour
+	defaultMinRefreshDelay = time.Minute
+	defaultHTTPTimeout     = time.Second * 10
+)
+
+var signingMethods = []string{
+	"RS256", "RS384", "RS512",
+	"ES256", "ES384", "ES512",
+}
+
+type Config struct {
+	// Issuer is the expected value of the iss claim. It is also
+	// used to discover the JWKS endpoint when JWKSURL is not set.
+	Issuer string
+	// Audience must be present in the aud claim, so that tokens
+	// the issuer minted for other applications are rejected.
+	Audience string
+	// JWKSURL overrides the jwks_uri advertised by the issuer.
+	JWKSURL string
+	// CacheTTL is how long fetched keys are trusted for.
+	CacheTTL time.Duration
+}
+
+// Verifier validates bearer JWTs against the signing
+// keys published by an OIDC issuer. Keys are cached and
+// re-fetched when they expire or when a token refers to
+// an unknown key ID.
+type Verifier struct {
+	cfg       Config
+	client    *http.Client
+	parser    *jwt.Parser
+	mu        sync.Mutex
+	keys      map[string]any
+	fetchedAt time.Time
+	// fetching is closed once the
+	// fetch in progress is done
+	fetching chan struct{}
+	fetchErr error
+}
+
+func NewVerifier(cfg Config) (*Verifier, error) {
+	if cfg.Issuer == "" {
+		return nil, errors.New("must provide issuer")
+	}
+	if cfg.Audience == "" {
+		return nil, errors.New("must provide audience")
+	}
+	if cfg.CacheTTL == 0 {
+		cfg.CacheTTL = defaultCacheTTL
+	}
+	return &Verifier{
+		cfg:    cfg,
+		client: &http.Client{Timeout: defaultHTTPTimeout},
+		parser: jwt.NewParser(
+			jwt.WithValidMethods(signingMethods),
+			jwt.WithIssuer(cfg.Issuer),
+			jwt.WithAudience(cfg.Audience),
+			jwt.WithExpirationRequired(),
+		),
+		keys: make(map[string]any),
+	}, nil
+}
+
+// Verify parses the token, checks its signature, issuer,
+// audience and validity window and returns its claims.
+func (v *Verifier) Verify(ctx context.Context, token string) (jwt.MapClaims, error) {
+	claims := jwt.MapClaims{}
+	if _, err := v.parser.ParseWithClaims(token, claims, func(t *jwt.Token) (any, error) {
+		kid, _ := t.Header["kid"].(string)
+		return v.key(ctx, kid)
+	}); err != nil {
+		return nil, errors.Wrapf(err, "invalid token")
+	}
+	return claims, nil
+}
+
+func (v *Verifier) key(ctx context.Context, kid string) (any, error) {
+	v.mu.Lock()
+	k, ok := v.lookup(kid)
+	// refresh on expiry, or when the issuer may have rotated
+	// its keys, but don't hammer the issuer on bogus key IDs
+	refresh := time.Since(v.fetchedAt) > v.cfg.CacheTTL ||
+		(!ok && time.Since(v.fetchedAt) > defaultMinRefreshDelay)
+	// known keys are used while they are being refreshed
+	if ok && v.fetching != nil {
+		refresh = false
+	}
+	v.mu.Unlock()
+	if refresh {
+		if err := v.refresh(ctx); err != nil {
+			if ok {
+				return k, nil
+			}
+			return nil, err
+		}
+		v.mu.Lock()
+		k, ok = v.lookup(kid)
+		v.mu.Unlock()
+	}
+	if !ok {
+		return nil, errors.Errorf("unknown signing key: %s", kid)
+	}
+	return k, nil
+}
+
+// refresh fetches the keys, without holding the lock
+// so that verifying tokens with cached keys isn't held
+// up by a slow issuer. Concurrent callers wait for the
+// fetch in progress rather than starting their own.
+func (v *Verifier) refresh(ctx context.Context) error {
+	v.mu.Lock()
+	if v.fetching != nil {
+		done := v.fetching
+		v.mu.Unlock()
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		v.mu.Lock()
+		defer v.mu.Unlock()
+		return v.fetchErr
+	}
+	done := make(chan struct{})
+	v.fetching = done
+	v.mu.Unlock()
+	// the fetch outlives the request
+	// of the caller that started it
+	keys, err := v.fetchKeys(context.WithoutCancel(ctx))
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if err == nil {
+		v.keys = keys
+		v.fetchedAt = time.Now()
+	}
+	v.fetchErr = err
+	v.fetching = nil
+	close(done)
+	return err
+}
+
+func (v *Verifier) lookup(kid string) (any, bool) {
+	if kid == "" && len(v.keys) == 1 {
+		for _, k := range v.keys {
+			return k, true
+		}
+	}
+	k, ok := v.keys[kid]
+	return k, ok
+}
+
+type jwk struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (v *Verifier) fetchKeys(ctx context.Context) (map[string]any, error) {
+	jwksURL := v.cfg.JWKSURL
+	if jwksURL == "" {
+		doc := struct {
+			JWKSURI string `json:"jwks_uri"`
+		}{}
+		discoveryURL := strings.TrimSuffix(v.cfg.Issuer, "/") + "/.well-known/openid-configuration"
+		if err := v.getJSON(ctx, discoveryURL, &doc); err != nil {
+			return nil, err
+		}
+		if doc.JWKSURI == "" {
+			return nil, errors.Errorf("no jwks_uri found at %s", discoveryURL)
+		}
+		jwksURL = doc.JWKSURI
+	}
+	set := struct {
+		Keys []jwk `json:"keys"`
+	}{}
+	if err := v.getJSON(ctx, jwksURL, &set); err != nil {
+		return nil, err
+	}
+	keys := make(map[string]any)
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		pk, err := k.publicKey()
+		if err != nil {
+			return nil, errors.Wrapf(err, "error parsing key %s", k.Kid)
+		}
+		if pk != nil {
+			keys[k.Kid] = pk
+		}
+	}
+	return keys, nil
+}
+
+func (v *Verifier) getJSON(ctx context.Context, url string, dst any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return errors.Wrapf(err, "error fetching %s", url)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return errors.Errorf("error fetching %s: %d", url, resp.StatusCode)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(dst); err != nil {
+		return errors.Wrapf(err, "error decoding %s", url)
+	}
+	return nil
+}
+
+// publicKey returns nil for key types that
+// can't be used to verify a supported algorithm
+func (k jwk) publicKey() (any, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, nil
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	default:
+		return nil, nil
+	}
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
@@ -0,0 +1,222 @@
+package oidc_test
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/runabol/tork/internal/oidc"
+	"github.com/stretchr/testify/assert"
+)
+
+func newIssuer(t *testing.T, key *rsa.PrivateKey) *httptest.Server {
+	mux := http.NewServeMux()
+	var srv *httptest.Server
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"issuer":   srv.URL,
+			"jwks_uri": srv.URL + "/keys",
+		})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"keys": []map[string]string{{
+				"kid": "key-1",
+				"kty": "RSA",
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	srv = httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func sign(t *testing.T, key *rsa.PrivateKey, kid string, claims jwt.MapClaims) string {
+	tok := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	tok.Header["kid"] = kid
+	s, err := tok.SignedString(key)
+	assert.NoError(t, err)
+	return s
+}
+
+func TestVerify(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	srv := newIssuer(t, key)
+	v, err := oidc.NewVerifier(oidc.Config{
+		Issuer:   srv.URL,
+		Audience: "tork",
+	})
+	assert.NoError(t, err)
+
+	token := sign(t, key, "key-1", jwt.MapClaims{
+		"iss": srv.URL,
+		"aud": "tork",
+		"sub": "someuser",
+		"exp": time.Now().Add(time.Minute).Unix(),
+	})
+	claims, err := v.Verify(context.Background(), token)
+	assert.NoError(t, err)
+	assert.Equal(t, "someuser", claims["sub"])
+}
+
+func TestVerifyInvalid(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	srv := newIssuer(t, key)
+	v, err := oidc.NewVerifier(oidc.Config{
+		Issuer:   srv.URL,
+		Audience: "tork",
+	})
+	assert.NoError(t, err)
+
+	valid := jwt.MapClaims{
+		"iss": srv.URL,
+		"aud": "tork",
+		"sub": "someuser",
+		"exp": time.Now().Add(time.Minute).Unix(),
+	}
+
+	// wrong signing key
+	_, err = v.Verify(context.Background(), sign(t, other, "key-1", valid))
+	assert.Error(t, err)
+
+	// unknown key id
+	_, err = v.Verify(context.Background(), sign(t, key, "key-2", valid))
+	assert.Error(t, err)
+
+	// expired
+	_, err = v.Verify(context.Background(), sign(t, key, "key-1", jwt.MapClaims{
+		"iss": srv.URL,
+		"aud": "tork",
+		"exp": time.Now().Add(-time.Minute).Unix(),
+	}))
+	assert.Error(t, err)
+
+	// wrong issuer
+	_, err = v.Verify(context.Background(), sign(t, key, "key-1", jwt.MapClaims{
+		"iss": "https://example.com",
+		"aud": "tork",
+		"exp": time.Now().Add(time.Minute).Unix(),
+	}))
+	assert.Error(t, err)
+
+	// wrong audience
+	_, err = v.Verify(context.Background(), sign(t, key, "key-1", jwt.MapClaims{
+		"iss": srv.URL,
+		"aud": "other",
+		"exp": time.Now().Add(time.Minute).Unix(),
+	}))
+	assert.Error(t, err)
+
+	// symmetric algorithms are not accepted
+	hs := jwt.NewWithClaims(jwt.SigningMethodHS256, valid)
+	s, err := hs.SignedString([]byte("secret"))
+	assert.NoError(t, err)
+	_, err = v.Verify(context.Background(), s)
+	assert.Error(t, err)
+}
+
+func TestVerifyRequiredClaims(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	srv := newIssuer(t, key)
+	v, err := oidc.NewVerifier(oidc.Config{
+		Issuer:   srv.URL,
+		Audience: "tork",
+	})
+	assert.NoError(t, err)
+
+	// no audience
+	_, err = v.Verify(context.Background(), sign(t, key, "key-1", jwt.MapClaims{
+		"iss": srv.URL,
+		"exp": time.Now().Add(time.Minute).Unix(),
+	}))
+	assert.Error(t, err)
+
+	// no expiry
+	_, err = v.Verify(context.Background(), sign(t, key, "key-1", jwt.MapClaims{
+		"iss": srv.URL,
+		"aud": "tork",
+	}))
+	assert.Error(t, err)
+}
+
+func TestVerifyDuringRefresh(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	var calls atomic.Int32
+	fetching := make(chan struct{})
+	release := make(chan struct{})
+	jwks := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 2 {
+			close(fetching)
+			<-release
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"keys": []map[string]string{{
+				"kid": "key-1",
+				"kty": "RSA",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	}))
+	defer jwks.Close()
+	v, err := oidc.NewVerifier(oidc.Config{
+		Issuer:   "https://issuer.example.com",
+		Audience: "tork",
+		JWKSURL:  jwks.URL,
+		CacheTTL: time.Millisecond * 10,
+	})
+	assert.NoError(t, err)
+	token := sign(t, key, "key-1", jwt.MapClaims{
+		"iss": "https://issuer.example.com",
+		"aud": "tork",
+		"exp": time.Now().Add(time.Minute).Unix(),
+	})
+	_, err = v.Verify(context.Background(), token)
+	assert.NoError(t, err)
+
+	time.Sleep(time.Millisecond * 20)
+	done := make(chan error)
+	go func() {
+		_, err := v.Verify(context.Background(), token)
+		done <- err
+	}()
+	<-fetching
+
+	// the cached key is used while the slow refresh is in progress
+	_, err = v.Verify(context.Background(), token)
+	assert.NoError(t, err)
+
+	close(release)
+	assert.NoError(t, <-done)
+	assert.Equal(t, int32(2), calls.Load())
+}
+
+func TestNewVerifierNoIssuer(t *testing.T) {
+	_, err := oidc.NewVerifier(oidc.Config{})
+	assert.Error(t, err)
+}
+
+func TestNewVerifierNoAudience(t *testing.T) {
+	_, err := oidc.NewVerifier(oidc.Config{
+		Issuer: "https://issuer.example.com",
+	})
+	assert.Error(t, err)
+}
@@ -0,0 +1,33 @@
+// Command gen converts the Swagger 2.0 document generated by
+// swag to the OpenAPI 3 document served by the API.
+//
+//	go run ./internal/openapi/gen swagger.json openapi.json
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/runabol/tork/internal/openapi"
+)
+
+func main() {
+	if len(os.Args) != 3 {
+		fmt.Fprintln(os.Stderr, "usage: gen swagger.json openapi.json")
+		os.Exit(2)
+	}
+	swagger, err := os.ReadFile(os.Args[1])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	doc, err := openapi.Convert(swagger)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if err := os.WriteFile(os.Args[2], doc, 0644); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
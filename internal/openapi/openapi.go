@@ -0,0 +1,327 @@
+// Package openapi converts the Swagger 2.0 document generated
+// from the API handler annotations to an OpenAPI 3 document.
+package openapi
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const Version = "3.0.3"
+
+// the keywords of a Swagger 2.0 non-body parameter
+// which make up the schema of an OpenAPI 3 parameter
+var schemaKeywords = []string{
+	"type", "format", "items", "enum", "default",
+	"minimum", "maximum", "minLength", "maxLength", "pattern",
+}
+
+// document keeps the top-level fields of
+// the OpenAPI document in their usual order
+type document struct {
+	OpenAPI    string         `json:"openapi"`
+	Info       any            `json:"info"`
+	Servers    []any          `json:"servers,omitempty"`
+	Tags       any            `json:"tags,omitempty"`
+	Paths      map[string]any `json:"paths"`
+	Components map[string]any `json:"components,omitempty"`
+	Security   any            `json:"security,omitempty"`
+}
+
+// Convert converts a Swagger 2.0 document to OpenAPI 3.
+func Convert(swagger []byte) ([]byte, error) {
+	doc := map[string]any{}
+	if err := json.Unmarshal(swagger, &doc); err != nil {
+		return nil, errors.Wrapf(err, "error parsing the swagger document")
+	}
+	if v, _ := doc["swagger"].(string); v != "2.0" {
+		return nil, errors.Errorf("unsupported swagger version: %v", doc["swagger"])
+	}
+	result := document{
+		OpenAPI:  Version,
+		Info:     doc["info"],
+		Servers:  convertServers(doc),
+		Tags:     doc["tags"],
+		Security: doc["security"],
+	}
+	produces := stringList(doc["produces"])
+	consumes := stringList(doc["consumes"])
+	result.Paths = map[string]any{}
+	for path, v := range asMap(doc["paths"]) {
+		item := map[string]any{}
+		for method, op := range asMap(v) {
+			if method == "parameters" {
+				item[method] = convertParameters(op)
+				continue
+			}
+			converted, err := convertOperation(asMap(op), produces, consumes)
+			if err != nil {
+				return nil, errors.Wrapf(err, "error converting %s %s", strings.ToUpper(method), path)
+			}
+			item[method] = converted
+		}
+		result.Paths[path] = item
+	}
+	components := map[string]any{}
+	if defs, ok := doc["definitions"]; ok {
+		components["schemas"] = defs
+	}
+	if sds, ok := doc["securityDefinitions"]; ok {
+		components["securitySchemes"] = convertSecuritySchemes(asMap(sds))
+	}
+	if len(components) > 0 {
+		result.Components = components
+	}
+	rewriteRefs(result.Paths)
+	rewriteRefs(result.Components)
+	out, err := json.MarshalIndent(result, "", "    ")
+	if err != nil {
+		return nil, errors.Wrapf(err, "error serializing the openapi document")
+	}
+	return append(out, '\n'), nil
+}
+
+func convertServers(doc map[string]any) []any {
+	host, _ := doc["host"].(string)
+	basePath, _ := doc["basePath"].(string)
+	if host == "" {
+		if basePath == "" || basePath == "/" {
+			return nil
+		}
+		return []any{map[string]any{"url": basePath}}
+	}
+	schemes := stringList(doc["schemes"])
+	if len(schemes) == 0 {
+		schemes = []string{"http"}
+	}
+	servers := make([]any, 0, len(schemes))
+	for _, scheme := range schemes {
+		servers = append(servers, map[string]any{
+			"url": scheme + "://" + host + strings.TrimSuffix(basePath, "/"),
+		})
+	}
+	return servers
+}
+
+func convertOperation(op map[string]any, produces, consumes []string) (map[string]any, error) {
+	result := map[string]any{}
+	for k, v := range op {
+		switch k {
+		case "produces", "consumes", "parameters", "responses", "schemes":
+		default:
+			result[k] = v
+		}
+	}
+	if p := stringList(op["produces"]); len(p) > 0 {
+		produces = p
+	}
+	if len(produces) == 0 {
+		produces = []string{"application/json"}
+	}
+	if c := stringList(op["consumes"]); len(c) > 0 {
+		consumes = c
+	}
+	if len(consumes) == 0 {
+		consumes = []string{"application/json"}
+	}
+	params := make([]any, 0)
+	form := map[string]any{}
+	var formRequired []any
+	for _, v := range asList(op["parameters"]) {
+		p := asMap(v)
+		switch p["in"] {
+		case "body":
+			content := map[string]any{}
+			for _, ct := range consumes {
+				content[ct] = map[string]any{"schema": p["schema"]}
+			}
+			body := map[string]any{"content": content}
+			if d, ok := p["description"]; ok {
+				body["description"] = d
+			}
+			if r, ok := p["required"]; ok {
+				body["required"] = r
+			}
+			result["requestBody"] = body
+		case "formData":
+			name, _ := p["name"].(string)
+			form[name] = parameterSchema(p)
+			if r, _ := p["required"].(bool); r {
+				formRequired = append(formRequired, name)
+			}
+		default:
+			params = append(params, convertParameter(p))
+		}
+	}
+	if len(form) > 0 {
+		if _, ok := result["requestBody"]; ok {
+			return nil, errors.New("an operation can't have both body and form parameters")
+		}
+		schema := map[string]any{"type": "object", "properties": form}
+		if len(formRequired) > 0 {
+			schema["required"] = formRequired
+		}
+		content := map[string]any{}
+		for _, ct := range consumes {
+			content[ct] = map[string]any{"schema": schema}
+		}
+		result["requestBody"] = map[string]any{"content": content}
+	}
+	if len(params) > 0 {
+		result["parameters"] = params
+	}
+	responses := map[string]any{}
+	for code, v := range asMap(op["responses"]) {
+		r := asMap(v)
+		converted := map[string]any{}
+		for k, v := range r {
+			switch k {
+			case "schema", "examples":
+			case "headers":
+				headers := map[string]any{}
+				for name, h := range asMap(v) {
+					headers[name] = convertHeader(asMap(h))
+				}
+				converted[k] = headers
+			default:
+				converted[k] = v
+			}
+		}
+		if _, ok := converted["description"]; !ok {
+			converted["description"] = ""
+		}
+		if schema, ok := r["schema"]; ok {
+			content := map[string]any{}
+			for _, ct := range produces {
+				content[ct] = map[string]any{"schema": schema}
+			}
+			converted["content"] = content
+		}
+		responses[code] = converted
+	}
+	result["responses"] = responses
+	return result, nil
+}
+
+func convertParameters(v any) []any {
+	params := make([]any, 0)
+	for _, p := range asList(v) {
+		params = append(params, convertParameter(asMap(p)))
+	}
+	return params
+}
+
+func convertParameter(p map[string]any) map[string]any {
+	result := map[string]any{}
+	for _, k := range []string{"name", "in", "description", "required", "$ref"} {
+		if v, ok := p[k]; ok {
+			result[k] = v
+		}
+	}
+	if _, ok := p["$ref"]; ok {
+		return result
+	}
+	result["schema"] = parameterSchema(p)
+	if p["in"] == "path" {
+		result["required"] = true
+	}
+	return result
+}
+
+func convertHeader(h map[string]any) map[string]any {
+	result := map[string]any{}
+	if d, ok := h["description"]; ok {
+		result["description"] = d
+	}
+	result["schema"] = parameterSchema(h)
+	return result
+}
+
+func parameterSchema(p map[string]any) map[string]any {
+	schema := map[string]any{}
+	for _, k := range schemaKeywords {
+		if v, ok := p[k]; ok {
+			schema[k] = v
+		}
+	}
+	if schema["type"] == "file" {
+		schema["type"] = "string"
+		schema["format"] = "binary"
+	}
+	return schema
+}
+
+func convertSecuritySchemes(sds map[string]any) map[string]any {
+	result := map[string]any{}
+	for name, v := range sds {
+		sd := asMap(v)
+		switch sd["type"] {
+		case "basic":
+			result[name] = map[string]any{"type": "http", "scheme": "basic"}
+		case "oauth2":
+			flow := map[string]any{"scopes": sd["scopes"]}
+			if u, ok := sd["authorizationUrl"]; ok {
+				flow["authorizationUrl"] = u
+			}
+			if u, ok := sd["tokenUrl"]; ok {
+				flow["tokenUrl"] = u
+			}
+			flows := map[string]any{}
+			switch sd["flow"] {
+			case "implicit":
+				flows["implicit"] = flow
+			case "password":
+				flows["password"] = flow
+			case "application":
+				flows["clientCredentials"] = flow
+			case "accessCode":
+				flows["authorizationCode"] = flow
+			}
+			result[name] = map[string]any{"type": "oauth2", "flows": flows}
+		default:
+			result[name] = sd
+		}
+	}
+	return result
+}
+
+// rewriteRefs points the references to the definitions
+// of the swagger document to the schemas components.
+func rewriteRefs(v any) {
+	switch vv := v.(type) {
+	case map[string]any:
+		for k, e := range vv {
+			if ref, ok := e.(string); ok && k == "$ref" {
+				vv[k] = strings.Replace(ref, "#/definitions/", "#/components/schemas/", 1)
+				continue
+			}
+			rewriteRefs(e)
+		}
+	case []any:
+		for _, e := range vv {
+			rewriteRefs(e)
+		}
+	}
+}
+
+func asMap(v any) map[string]any {
+	m, _ := v.(map[string]any)
+	return m
+}
+
+func asList(v any) []any {
+	l, _ := v.([]any)
+	return l
+}
+
+func stringList(v any) []string {
+	result := make([]string, 0)
+	for _, e := range asList(v) {
+		if s, ok := e.(string); ok {
+			result = append(result, s)
+		}
+	}
+	return result
+}
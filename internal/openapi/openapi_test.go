@@ -0,0 +1,86 @@
+package openapi
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/runabol/tork/docs"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConvert(t *testing.T) {
+	swagger := `{
+    "swagger": "2.0",
+    "schemes": ["http"],
+    "host": "localhost:8000",
+    "basePath": "/",
+    "info": {"title": "Tork API", "version": "1.0"},
+    "paths": {
+        "/jobs/{id}": {
+            "put": {
+                "consumes": ["application/json"],
+                "produces": ["application/json"],
+                "parameters": [
+                    {"type": "string", "description": "Job ID", "name": "id", "in": "path", "required": true},
+                    {"type": "integer", "name": "size", "in": "query"},
+                    {"description": "body", "name": "request", "in": "body", "required": true, "schema": {"$ref": "#/definitions/input.Job"}}
+                ],
+                "responses": {
+                    "200": {"description": "OK", "schema": {"$ref": "#/definitions/tork.Job"}},
+                    "404": {"description": "Not Found"}
+                }
+            }
+        }
+    },
+    "definitions": {
+        "input.Job": {"type": "object", "properties": {"tasks": {"type": "array", "items": {"$ref": "#/definitions/input.Task"}}}},
+        "input.Task": {"type": "object"},
+        "tork.Job": {"type": "object"}
+    }
+}`
+	out, err := Convert([]byte(swagger))
+	assert.NoError(t, err)
+	doc := map[string]any{}
+	assert.NoError(t, json.Unmarshal(out, &doc))
+	assert.Equal(t, "3.0.3", doc["openapi"])
+	assert.Equal(t, []any{map[string]any{"url": "http://localhost:8000"}}, doc["servers"])
+
+	op := doc["paths"].(map[string]any)["/jobs/{id}"].(map[string]any)["put"].(map[string]any)
+	assert.Equal(t, []any{
+		map[string]any{"name": "id", "in": "path", "description": "Job ID", "required": true, "schema": map[string]any{"type": "string"}},
+		map[string]any{"name": "size", "in": "query", "schema": map[string]any{"type": "integer"}},
+	}, op["parameters"])
+	assert.Equal(t, map[string]any{
+		"description": "body",
+		"required":    true,
+		"content": map[string]any{
+			"application/json": map[string]any{"schema": map[string]any{"$ref": "#/components/schemas/input.Job"}},
+		},
+	}, op["requestBody"])
+	assert.Equal(t, map[string]any{
+		"200": map[string]any{
+			"description": "OK",
+			"content": map[string]any{
+				"application/json": map[string]any{"schema": map[string]any{"$ref": "#/components/schemas/tork.Job"}},
+			},
+		},
+		"404": map[string]any{"description": "Not Found"},
+	}, op["responses"])
+
+	schemas := doc["components"].(map[string]any)["schemas"].(map[string]any)
+	assert.Len(t, schemas, 3)
+	items := schemas["input.Job"].(map[string]any)["properties"].(map[string]any)["tasks"].(map[string]any)["items"]
+	assert.Equal(t, map[string]any{"$ref": "#/components/schemas/input.Task"}, items)
+}
+
+func TestConvertUnsupportedVersion(t *testing.T) {
+	_, err := Convert([]byte(`{"openapi":"3.0.3"}`))
+	assert.Error(t, err)
+}
+
+func TestEmbeddedDoc(t *testing.T) {
+	doc := map[string]any{}
+	assert.NoError(t, json.Unmarshal(docs.OpenAPIJSON, &doc))
+	assert.Equal(t, Version, doc["openapi"])
+	assert.NotContains(t, string(docs.OpenAPIJSON), "#/definitions/")
+}
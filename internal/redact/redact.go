@@ -3,10 +3,12 @@ package redact
 import (
 	"context"
 	"strings"
+	"time"
 
 	"github.com/rs/zerolog/log"
 	"github.com/runabol/tork"
 	"github.com/runabol/tork/datastore"
+	"github.com/runabol/tork/internal/cache"
 	"github.com/runabol/tork/internal/wildcard"
 )
 
@@ -14,9 +16,24 @@ const (
 	redactedStr = "[REDACTED]"
 )
 
+// how long the secrets of a job are cached
+// after they were first looked up
+var secretsTTL = time.Minute
+
 type Redacter struct {
 	matchers []Matcher
 	ds       datastore.Datastore
+	// the secrets of the jobs by job id, and the job ids
+	// by task id, so that the log parts and results of
+	// the tasks don't each have to look them up
+	secrets *cache.Cache[*jobSecrets]
+	jobIDs  *cache.Cache[string]
+}
+
+type jobSecrets struct {
+	// the values of the job secrets and of the managed secrets
+	values  []string
+	managed []*tork.Secret
 }
 
 func NewRedacter(ds datastore.Datastore, matchers ...Matcher) *Redacter {
@@ -26,6 +43,8 @@ func NewRedacter(ds datastore.Datastore, matchers ...Matcher) *Redacter {
 	return &Redacter{
 		matchers: matchers,
 		ds:       ds,
+		secrets:  cache.New[*jobSecrets](secretsTTL, secretsTTL),
+		jobIDs:   cache.New[string](secretsTTL, secretsTTL),
 	}
 }
 
@@ -50,15 +69,126 @@ func Wildcard(pattern string) func(s string) bool {
 }
 
 func (r *Redacter) RedactTask(t *tork.Task) {
-	job, err := r.ds.GetJobByID(context.Background(), t.JobID)
+	js, err := r.jobSecrets(context.Background(), t.JobID)
+	if err != nil {
+		log.Error().Err(err).Msgf("error getting job for task %s", t.ID)
+		return
+	}
+	r.doRedactTask(t, js.values)
+}
+
+// RedactTaskResult masks the secret values found in the result
+// and error of a task reported by a worker, and turns the env
+// values that were resolved from managed secrets back into
+// secret references, so that no secret values get stored.
+func (r *Redacter) RedactTaskResult(t *tork.Task) {
+	js, err := r.jobSecrets(context.Background(), t.JobID)
 	if err != nil {
 		log.Error().Err(err).Msgf("error getting job for task %s", t.ID)
 		return
 	}
-	r.doRedactTask(t, job.Secrets)
+	t.Result = redactString(t.Result, js.values)
+	t.Error = redactString(t.Error, js.values)
+	unresolveSecrets(t, js.managed)
+}
+
+// RedactLogPart masks the values of the job secrets
+// and managed secrets found in a task's log output.
+func (r *Redacter) RedactLogPart(p *tork.TaskLogPart) {
+	ctx := context.Background()
+	jobID, ok := r.jobIDs.Get(p.TaskID)
+	if !ok {
+		t, err := r.ds.GetTaskByID(ctx, p.TaskID)
+		if err != nil {
+			// mask the managed secrets at least
+			p.Contents = redactString(p.Contents, secretValues(nil, r.managedSecrets(ctx)))
+			return
+		}
+		jobID = t.JobID
+		r.jobIDs.Set(p.TaskID, jobID)
+	}
+	js, err := r.jobSecrets(ctx, jobID)
+	if err != nil {
+		log.Error().Err(err).Msgf("error getting job for task %s", p.TaskID)
+		p.Contents = redactString(p.Contents, secretValues(nil, r.managedSecrets(ctx)))
+		return
+	}
+	p.Contents = redactString(p.Contents, js.values)
+}
+
+func unresolveSecrets(t *tork.Task, managed []*tork.Secret) {
+	for k, v := range t.Env {
+		for _, s := range managed {
+			if s.Value != "" && s.Value == v {
+				t.Env[k] = tork.SECRET_REF_PREFIX + s.Name
+				break
+			}
+		}
+	}
+	for _, p := range t.Pre {
+		unresolveSecrets(p, managed)
+	}
+	for _, p := range t.Post {
+		unresolveSecrets(p, managed)
+	}
+}
+
+// jobSecrets returns the secrets of the job, from
+// the cache unless they were looked up a while ago.
+func (r *Redacter) jobSecrets(ctx context.Context, jobID string) (*jobSecrets, error) {
+	if js, ok := r.secrets.Get(jobID); ok {
+		return js, nil
+	}
+	job, err := r.ds.GetJobByID(ctx, jobID)
+	if err != nil {
+		return nil, err
+	}
+	managed, err := r.ds.GetSecrets(ctx)
+	if err != nil {
+		// don't cache the job secrets alone
+		log.Error().Err(err).Msg("error getting secrets")
+		return &jobSecrets{values: secretValues(job.Secrets, nil)}, nil
+	}
+	js := &jobSecrets{
+		values:  secretValues(job.Secrets, managed),
+		managed: managed,
+	}
+	r.secrets.Set(jobID, js)
+	return js, nil
 }
 
-func (r *Redacter) doRedactTask(t *tork.Task, secrets map[string]string) {
+func (r *Redacter) managedSecrets(ctx context.Context) []*tork.Secret {
+	managed, err := r.ds.GetSecrets(ctx)
+	if err != nil {
+		log.Error().Err(err).Msg("error getting secrets")
+		return nil
+	}
+	return managed
+}
+
+// secretValues returns the values of the given
+// job secrets and of the managed secrets.
+func secretValues(jobSecrets map[string]string, managed []*tork.Secret) []string {
+	values := make([]string, 0, len(jobSecrets)+len(managed))
+	for _, v := range jobSecrets {
+		values = append(values, v)
+	}
+	for _, s := range managed {
+		values = append(values, s.Value)
+	}
+	return values
+}
+
+func redactString(s string, secrets []string) string {
+	for _, secret := range secrets {
+		if secret != "" {
+			s = strings.ReplaceAll(s, secret, redactedStr)
+		}
+	}
+	return s
+}
+
+func (r *Redacter) doRedactTask(t *tork.Task, secrets []string) {
 	redacted := t
 	// redact env vars
 	redacted.Env = r.redactVars(redacted.Env, secrets)
@@ -84,32 +214,43 @@ func (r *Redacter) doRedactTask(t *tork.Task, secrets map[string]string) {
 
 func (r *Redacter) RedactJob(j *tork.Job) {
 	redacted := j
+	var managed []*tork.Secret
+	if js, err := r.jobSecrets(context.Background(), j.ID); err == nil && js.managed != nil {
+		managed = js.managed
+	} else {
+		// e.g. the template of a scheduled job
+		managed = r.managedSecrets(context.Background())
+	}
+	secrets := secretValues(j.Secrets, managed)
 	// redact inputs
-	redacted.Inputs = r.redactVars(redacted.Inputs, j.Secrets)
+	redacted.Inputs = r.redactVars(redacted.Inputs, secrets)
 	// redact webhook headers
 	for _, w := range j.Webhooks {
 		if w.Headers != nil {
-			w.Headers = r.redactVars(w.Headers, j.Secrets)
+			w.Headers = r.redactVars(w.Headers, secrets)
+		}
+		if w.Secret != "" {
+			w.Secret = redactedStr
 		}
 	}
 	// redact context
-	redacted.Context.Inputs = r.redactVars(redacted.Context.Inputs, j.Secrets)
-	redacted.Context.Secrets = r.redactVars(redacted.Context.Secrets, j.Secrets)
-	redacted.Context.Tasks = r.redactVars(redacted.Context.Tasks, j.Secrets)
+	redacted.Context.Inputs = r.redactVars(redacted.Context.Inputs, secrets)
+	redacted.Context.Secrets = r.redactVars(redacted.Context.Secrets, secrets)
+	redacted.Context.Tasks = r.redactVars(redacted.Context.Tasks, secrets)
 	// redact tasks
 	for _, t := range redacted.Tasks {
-		r.doRedactTask(t, j.Secrets)
+		r.doRedactTask(t, secrets)
 	}
 	// redact execution
 	for _, t := range redacted.Execution {
-		r.doRedactTask(t, j.Secrets)
+		r.doRedactTask(t, secrets)
 	}
 	for k := range j.Secrets {
 		redacted.Secrets[k] = redactedStr
 	}
 }
 
-func (r *Redacter) redactVars(m map[string]string, secrets map[string]string) map[string]string {
+func (r *Redacter) redactVars(m map[string]string, secrets []string) map[string]string {
 	redacted := make(map[string]string)
 	for k, v := range m {
 		for _, m := range r.matchers {
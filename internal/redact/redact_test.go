@@ -127,7 +127,8 @@ func TestRedactJob(t *testing.T) {
 		},
 		Webhooks: []*tork.Webhook{
 			{
-				URL: "http://example.com/1",
+				URL:    "http://example.com/1",
+				Secret: "signing-secret",
 			},
 			{
 				URL: "http://example.com/2",
@@ -161,6 +162,8 @@ func TestRedactJob(t *testing.T) {
 	}, j.Context.Tasks)
 	assert.Equal(t, "[REDACTED]", j.Execution[0].Env["secret_1"])
 	assert.Equal(t, "http://example.com/1", j.Webhooks[0].URL)
+	assert.Equal(t, "[REDACTED]", j.Webhooks[0].Secret)
+	assert.Empty(t, j.Webhooks[1].Secret)
 	assert.Equal(t, "http://example.com/2", j.Webhooks[1].URL)
 	assert.Equal(t, map[string]string{"my-header": "my-value", "my-secret": "[REDACTED]", "another-header": "[REDACTED]"}, j.Webhooks[1].Headers)
 }
@@ -209,3 +212,94 @@ func TestRedactJobWildcard(t *testing.T) {
 	assert.Equal(t, "password", j.Tasks[0].Env["PASSword"])
 	assert.Equal(t, "hello world", j.Tasks[0].Env["harmless"])
 }
+
+func TestRedactTaskResult(t *testing.T) {
+	ds := inmemory.NewInMemoryDatastore()
+	ctx := context.Background()
+	err := ds.SetSecret(ctx, &tork.Secret{Name: "db-password", Value: "p4ssw0rd"})
+	assert.NoError(t, err)
+	j1 := tork.Job{
+		ID: uuid.NewUUID(),
+		Secrets: map[string]string{
+			"hush": "shhhhh",
+		},
+	}
+	err = ds.CreateJob(ctx, &j1)
+	assert.NoError(t, err)
+
+	ta := tork.Task{
+		JobID:  j1.ID,
+		State:  tork.TaskStateCompleted,
+		Result: "connected with p4ssw0rd and shhhhh",
+		Error:  "p4ssw0rd",
+		Env: map[string]string{
+			"DB_PASSWORD": "p4ssw0rd",
+			"harmless":    "hello world",
+		},
+		Pre: []*tork.Task{{
+			Env: map[string]string{
+				"DB_PASSWORD": "p4ssw0rd",
+			},
+		}},
+	}
+	redacter := NewRedacter(ds)
+	redacter.RedactTaskResult(&ta)
+	assert.Equal(t, "connected with [REDACTED] and [REDACTED]", ta.Result)
+	assert.Equal(t, "[REDACTED]", ta.Error)
+	assert.Equal(t, "secret://db-password", ta.Env["DB_PASSWORD"])
+	assert.Equal(t, "hello world", ta.Env["harmless"])
+	assert.Equal(t, "secret://db-password", ta.Pre[0].Env["DB_PASSWORD"])
+
+	// managed secrets are also redacted on read
+	ta.Env["thing"] = "p4ssw0rd"
+	redacter.RedactTask(&ta)
+	assert.Equal(t, "[REDACTED]", ta.Env["thing"])
+}
+
+func TestRedactLogPart(t *testing.T) {
+	ds := inmemory.NewInMemoryDatastore()
+	ctx := context.Background()
+	err := ds.SetSecret(ctx, &tork.Secret{Name: "db-password", Value: "p4ssw0rd"})
+	assert.NoError(t, err)
+	p := &tork.TaskLogPart{
+		Contents: "connecting with p4ssw0rd",
+	}
+	NewRedacter(ds).RedactLogPart(p)
+	assert.Equal(t, "connecting with [REDACTED]", p.Contents)
+}
+
+func TestRedactLogPartJobSecrets(t *testing.T) {
+	ds := inmemory.NewInMemoryDatastore()
+	ctx := context.Background()
+	err := ds.SetSecret(ctx, &tork.Secret{Name: "db-password", Value: "p4ssw0rd"})
+	assert.NoError(t, err)
+	j1 := tork.Job{
+		ID: uuid.NewUUID(),
+		Secrets: map[string]string{
+			"hush": "shhhhh",
+		},
+	}
+	assert.NoError(t, ds.CreateJob(ctx, &j1))
+	t1 := tork.Task{
+		ID:    uuid.NewUUID(),
+		JobID: j1.ID,
+	}
+	assert.NoError(t, ds.CreateTask(ctx, &t1))
+
+	redacter := NewRedacter(ds)
+	p := &tork.TaskLogPart{
+		TaskID:   t1.ID,
+		Contents: "connecting with p4ssw0rd and shhhhh",
+	}
+	redacter.RedactLogPart(p)
+	assert.Equal(t, "connecting with [REDACTED] and [REDACTED]", p.Contents)
+
+	// the secrets of the job are cached
+	assert.NoError(t, ds.DeleteSecret(ctx, "db-password"))
+	p = &tork.TaskLogPart{
+		TaskID:   t1.ID,
+		Contents: "connecting with p4ssw0rd",
+	}
+	redacter.RedactLogPart(p)
+	assert.Equal(t, "connecting with [REDACTED]", p.Contents)
+}
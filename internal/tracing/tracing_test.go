@@ -0,0 +1,43 @@
+package tracing_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/runabol/tork/internal/tracing"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestInjectExtract(t *testing.T) {
+	assert.Nil(t, tracing.Inject(context.Background()))
+
+	traceID, err := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+	assert.NoError(t, err)
+	spanID, err := trace.SpanIDFromHex("00f067aa0ba902b7")
+	assert.NoError(t, err)
+	ctx := trace.ContextWithSpanContext(context.Background(), trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    traceID,
+		SpanID:     spanID,
+		TraceFlags: trace.FlagsSampled,
+	}))
+
+	carrier := tracing.Inject(ctx)
+	assert.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", carrier["traceparent"])
+
+	sc := trace.SpanContextFromContext(tracing.Extract(context.Background(), carrier))
+	assert.True(t, sc.IsRemote())
+	assert.Equal(t, traceID, sc.TraceID())
+	assert.Equal(t, spanID, sc.SpanID())
+
+	// spans started from the extracted context
+	// belong to the same trace
+	ctx, span := tracing.Start(tracing.Extract(context.Background(), carrier), "test")
+	defer span.End()
+	assert.Equal(t, traceID, trace.SpanContextFromContext(ctx).TraceID())
+}
+
+func TestExtractEmpty(t *testing.T) {
+	ctx := tracing.Extract(context.Background(), nil)
+	assert.False(t, trace.SpanContextFromContext(ctx).IsValid())
+}
@@ -2,6 +2,9 @@ package webhook
 
 import (
 	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"net/http"
 	"time"
@@ -15,6 +18,10 @@ const (
 	webhookDefaultTimeout     = time.Second * 5
 )
 
+// SignatureHeader carries the hex-encoded HMAC-SHA256
+// of the request body, keyed by the webhook's secret.
+const SignatureHeader = "X-Tork-Signature"
+
 const (
 	EventJobStateChange  = "job.StateChange"
 	EventJobProgress     = "job.Progress"
@@ -23,6 +30,14 @@ const (
 	EventDefault         = ""
 )
 
+// Sign returns the signature of the body in the
+// form sha256=<hex>, suitable for SignatureHeader.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
 func Call(wh *tork.Webhook, body any) error {
 	b, err := json.Marshal(body)
 	if err != nil {
@@ -43,6 +58,9 @@ func Call(wh *tork.Webhook, body any) error {
 				req.Header.Set(name, val)
 			}
 		}
+		if wh.Secret != "" {
+			req.Header.Set(SignatureHeader, Sign(wh.Secret, b))
+		}
 		resp, err := client.Do(req)
 		if err != nil {
 			return err
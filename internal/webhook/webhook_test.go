@@ -117,3 +117,29 @@ func TestWebhookOKWithHeaders(t *testing.T) {
 	assert.NoError(t, Call(wh, tork.NewJobSummary(j)))
 	<-received
 }
+
+func TestWebhookSignature(t *testing.T) {
+	received := make(chan string, 1)
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			panic(err)
+		}
+		assert.Equal(t, Sign("my-secret", body), r.Header.Get(SignatureHeader))
+		w.WriteHeader(http.StatusOK)
+		received <- r.Header.Get(SignatureHeader)
+	}))
+	defer svr.Close()
+
+	wh := &tork.Webhook{
+		URL:    svr.URL,
+		Secret: "my-secret",
+	}
+	assert.NoError(t, Call(wh, tork.NewJobSummary(&tork.Job{ID: "1234"})))
+	assert.Contains(t, <-received, "sha256=")
+}
+
+func TestSign(t *testing.T) {
+	// echo -n 'hello' | openssl dgst -sha256 -hmac 'secret'
+	assert.Equal(t, "sha256=88aab3ede8d3adf94d26ab90d3bafd4a2083070c3bcce9c014ee04a443847c0b", Sign("secret", []byte("hello")))
+}
@@ -2,7 +2,9 @@ package worker
 
 import (
 	"context"
+	"crypto/subtle"
 	"fmt"
+	"sort"
 	"strings"
 	"syscall"
 
@@ -11,10 +13,13 @@ import (
 	"net/url"
 
 	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
 	"github.com/pkg/errors"
 	"github.com/rs/zerolog/log"
 	"github.com/runabol/tork/health"
+	"github.com/runabol/tork/internal/debug"
 	"github.com/runabol/tork/internal/httpx"
+	"github.com/runabol/tork/internal/metrics"
 	"github.com/runabol/tork/internal/syncx"
 	"github.com/runabol/tork/mq"
 	"github.com/runabol/tork/runtime"
@@ -45,11 +50,33 @@ func newAPI(cfg Config, tasks *syncx.Map[string, runningTask]) *api {
 		},
 	}
 	r.GET("/health", s.health)
+	r.GET("/health/live", s.live)
+	r.GET("/health/ready", s.health)
+	r.GET("/metrics", s.metrics)
+	if cfg.APIKey != "" {
+		r.POST("/images/pull", s.pullImages, keyAuth(cfg.APIKey))
+	}
 	r.Any("/tasks/:id/:port", s.proxy)
 	r.Any("/tasks/:id/:port/*", s.proxy)
+	// the debug endpoints expose the worker's command line and
+	// memory, so they require the API key like /images/pull
+	if cfg.Debug && cfg.APIKey != "" {
+		debug.RegisterPprof(r, keyAuth(cfg.APIKey))
+		r.GET("/debug/state", s.debugState, keyAuth(cfg.APIKey))
+	} else if cfg.Debug {
+		log.Warn().Msg("the worker's debug endpoints are disabled: worker.api.key is not set")
+	}
 	return s
 }
 
+// keyAuth authenticates the requests
+// bearing the worker's API key.
+func keyAuth(key string) echo.MiddlewareFunc {
+	return middleware.KeyAuth(func(k string, c echo.Context) (bool, error) {
+		return subtle.ConstantTimeCompare([]byte(k), []byte(key)) == 1, nil
+	})
+}
+
 func (s *api) health(c echo.Context) error {
 	result := health.NewHealthCheck().
 		WithIndicator(health.ServiceRuntime, s.runtime.HealthCheck).
@@ -62,6 +89,62 @@ func (s *api) health(c echo.Context) error {
 	}
 }
 
+// live reports the worker is up without
+// checking the runtime or the broker.
+func (s *api) live(c echo.Context) error {
+	return c.JSON(http.StatusOK, health.NewHealthCheck().Do(c.Request().Context()))
+}
+
+// debugState dumps the state of the worker
+// process along with its running tasks.
+func (s *api) debugState(c echo.Context) error {
+	running := make([]string, 0)
+	s.tasks.Iterate(func(id string, _ runningTask) {
+		running = append(running, id)
+	})
+	sort.Strings(running)
+	return c.JSON(http.StatusOK, struct {
+		debug.Stats
+		Running []string `json:"running"`
+	}{
+		Stats:   debug.ReadStats(),
+		Running: running,
+	})
+}
+
+func (s *api) metrics(c echo.Context) error {
+	c.Response().Header().Set(echo.HeaderContentType, metrics.ContentType)
+	c.Response().WriteHeader(http.StatusOK)
+	metrics.Write(c.Response())
+	return nil
+}
+
+type pullImagesRequest struct {
+	Images []string `json:"images"`
+}
+
+// pullImages pulls images ahead of a job
+// that runs many tasks using them.
+func (s *api) pullImages(c echo.Context) error {
+	puller, ok := s.runtime.(runtime.ImagePuller)
+	if !ok {
+		return echo.NewHTTPError(http.StatusNotImplemented, "the runtime can't pull images")
+	}
+	req := pullImagesRequest{}
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if len(req.Images) == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "images are required")
+	}
+	for _, image := range req.Images {
+		if err := puller.PullImage(c.Request().Context(), image); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("error pulling image %s: %s", image, err))
+		}
+	}
+	return c.NoContent(http.StatusOK)
+}
+
 func (s *api) proxy(c echo.Context) error {
 	taskID := c.Param("id")
 	port := c.Param("port")
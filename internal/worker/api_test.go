@@ -1,13 +1,16 @@
 package worker
 
 import (
+	"context"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"strconv"
+	"strings"
 	"testing"
 
+	"github.com/pkg/errors"
 	"github.com/runabol/tork"
 	"github.com/runabol/tork/internal/syncx"
 	"github.com/runabol/tork/mq"
@@ -34,6 +37,171 @@ func Test_health(t *testing.T) {
 	assert.Equal(t, http.StatusOK, w.Code)
 }
 
+func Test_healthLiveAndReady(t *testing.T) {
+	api := newAPI(Config{
+		Broker:  mq.NewInMemoryBroker(),
+		Runtime: &fakeRuntime{},
+	}, &syncx.Map[string, runningTask]{})
+	for _, path := range []string{"/health/live", "/health/ready"} {
+		req, err := http.NewRequest("GET", path, nil)
+		assert.NoError(t, err)
+		w := httptest.NewRecorder()
+		api.server.Handler.ServeHTTP(w, req)
+		assert.Contains(t, w.Body.String(), "\"status\":\"UP\"")
+		assert.Equal(t, http.StatusOK, w.Code)
+	}
+}
+
+func Test_debugState(t *testing.T) {
+	tasks := &syncx.Map[string, runningTask]{}
+	tasks.Set("some-task", runningTask{task: &tork.Task{ID: "some-task"}})
+	api := newAPI(Config{
+		Broker:  mq.NewInMemoryBroker(),
+		Runtime: &fakeRuntime{},
+		Debug:   true,
+		APIKey:  "secret",
+	}, tasks)
+	req, err := http.NewRequest("GET", "/debug/state", nil)
+	assert.NoError(t, err)
+	req.Header.Add("Authorization", "Bearer secret")
+	w := httptest.NewRecorder()
+	api.server.Handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"running":["some-task"]`)
+	assert.Contains(t, w.Body.String(), `"goroutines":`)
+}
+
+func Test_debugUnauthorized(t *testing.T) {
+	get := func(api *api, path, key string) int {
+		req, err := http.NewRequest("GET", path, nil)
+		assert.NoError(t, err)
+		if key != "" {
+			req.Header.Add("Authorization", "Bearer "+key)
+		}
+		w := httptest.NewRecorder()
+		api.server.Handler.ServeHTTP(w, req)
+		return w.Code
+	}
+	withKey := newAPI(Config{
+		Broker:  mq.NewInMemoryBroker(),
+		Runtime: &fakeRuntime{},
+		Debug:   true,
+		APIKey:  "secret",
+	}, &syncx.Map[string, runningTask]{})
+	for _, path := range []string{"/debug/state", "/debug/pprof/cmdline", "/debug/pprof/heap"} {
+		assert.Equal(t, http.StatusUnauthorized, get(withKey, path, "wrong"), path)
+	}
+	assert.Equal(t, http.StatusOK, get(withKey, "/debug/pprof/cmdline", "secret"))
+
+	withoutKey := newAPI(Config{
+		Broker:  mq.NewInMemoryBroker(),
+		Runtime: &fakeRuntime{},
+		Debug:   true,
+	}, &syncx.Map[string, runningTask]{})
+	assert.Equal(t, http.StatusNotFound, get(withoutKey, "/debug/state", ""))
+	assert.Equal(t, http.StatusNotFound, get(withoutKey, "/debug/pprof/cmdline", ""))
+}
+
+func Test_metrics(t *testing.T) {
+	api := newAPI(Config{
+		Broker:  mq.NewInMemoryBroker(),
+		Runtime: &fakeRuntime{},
+	}, &syncx.Map[string, runningTask]{})
+	req, err := http.NewRequest("GET", "/metrics", nil)
+	assert.NoError(t, err)
+	w := httptest.NewRecorder()
+	api.server.Handler.ServeHTTP(w, req)
+	body, err := io.ReadAll(w.Body)
+
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Header().Get("Content-Type"), "text/plain")
+	assert.Contains(t, string(body), "# TYPE tork_tasks_started_total counter")
+	assert.Contains(t, string(body), "# TYPE tork_task_duration_seconds histogram")
+}
+
+type fakePuller struct {
+	fakeRuntime
+	pulled []string
+}
+
+func (p *fakePuller) PullImage(ctx context.Context, image string) error {
+	if image == "no:such" {
+		return errors.New("not found")
+	}
+	p.pulled = append(p.pulled, image)
+	return nil
+}
+
+func Test_pullImages(t *testing.T) {
+	rt := &fakePuller{}
+	api := newAPI(Config{
+		Broker:  mq.NewInMemoryBroker(),
+		Runtime: rt,
+		APIKey:  "secret",
+	}, &syncx.Map[string, runningTask]{})
+	req, err := http.NewRequest("POST", "/images/pull", strings.NewReader(`{"images":["ubuntu:mantic","alpine:3.18"]}`))
+	assert.NoError(t, err)
+	req.Header.Add("Content-Type", "application/json")
+	req.Header.Add("Authorization", "Bearer secret")
+	w := httptest.NewRecorder()
+	api.server.Handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, []string{"ubuntu:mantic", "alpine:3.18"}, rt.pulled)
+
+	req, err = http.NewRequest("POST", "/images/pull", strings.NewReader(`{"images":["no:such"]}`))
+	assert.NoError(t, err)
+	req.Header.Add("Content-Type", "application/json")
+	req.Header.Add("Authorization", "Bearer secret")
+	w = httptest.NewRecorder()
+	api.server.Handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func Test_pullImagesUnauthorized(t *testing.T) {
+	rt := &fakePuller{}
+	pull := func(api *api, key string) int {
+		req, err := http.NewRequest("POST", "/images/pull", strings.NewReader(`{"images":["ubuntu:mantic"]}`))
+		assert.NoError(t, err)
+		req.Header.Add("Content-Type", "application/json")
+		if key != "" {
+			req.Header.Add("Authorization", "Bearer "+key)
+		}
+		w := httptest.NewRecorder()
+		api.server.Handler.ServeHTTP(w, req)
+		return w.Code
+	}
+	withKey := newAPI(Config{
+		Broker:  mq.NewInMemoryBroker(),
+		Runtime: rt,
+		APIKey:  "secret",
+	}, &syncx.Map[string, runningTask]{})
+	assert.Equal(t, http.StatusBadRequest, pull(withKey, ""))
+	assert.Equal(t, http.StatusUnauthorized, pull(withKey, "wrong"))
+
+	withoutKey := newAPI(Config{
+		Broker:  mq.NewInMemoryBroker(),
+		Runtime: rt,
+	}, &syncx.Map[string, runningTask]{})
+	assert.Equal(t, http.StatusNotFound, pull(withoutKey, ""))
+	assert.Empty(t, rt.pulled)
+}
+
+func Test_pullImagesNotSupported(t *testing.T) {
+	api := newAPI(Config{
+		Broker:  mq.NewInMemoryBroker(),
+		Runtime: &fakeRuntime{},
+		APIKey:  "secret",
+	}, &syncx.Map[string, runningTask]{})
+	req, err := http.NewRequest("POST", "/images/pull", strings.NewReader(`{"images":["ubuntu:mantic"]}`))
+	assert.NoError(t, err)
+	req.Header.Add("Content-Type", "application/json")
+	req.Header.Add("Authorization", "Bearer secret")
+	w := httptest.NewRecorder()
+	api.server.Handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotImplemented, w.Code)
+}
+
 func Test_proxyTaskRoot(t *testing.T) {
 	rt, err := docker.NewDockerRuntime()
 	assert.NoError(t, err)
@@ -3,6 +3,8 @@ package worker
 import (
 	"context"
 	"fmt"
+	"io"
+	"maps"
 	"net"
 	"os"
 	"sync"
@@ -17,12 +19,38 @@ import (
 	"github.com/runabol/tork/mq"
 
 	"github.com/runabol/tork/internal/host"
+	"github.com/runabol/tork/internal/metrics"
 	"github.com/runabol/tork/internal/syncx"
+	"github.com/runabol/tork/internal/tracing"
 	"github.com/runabol/tork/runtime"
+	"github.com/runabol/tork/secrets"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 
 	"github.com/runabol/tork/internal/uuid"
 )
 
+// DefaultDrainTimeout is how long a stopping worker waits
+// for its running tasks to finish before requeueing them.
+const DefaultDrainTimeout = time.Second * 30
+
+// how long an overloaded worker holds on to a
+// task before returning it to its queue
+var admissionBackoff = time.Second * 5
+
+// how long the secret values of a task are still masked after
+// it's done. the log shipper flushes the logs every second, so
+// the last log part of the task is published after it's done.
+var redactorGracePeriod = time.Second * 5
+
+// host resource usage, replaceable in tests
+var (
+	hostCPUPercent    = host.GetCPUPercent
+	hostMemoryPercent = host.GetMemoryPercent
+	hostDiskPercent   = host.GetDiskPercent
+)
+
 type Worker struct {
 	id         string
 	name       string
@@ -33,11 +61,24 @@ type Worker struct {
 	queues     map[string]int
 	tasks      *syncx.Map[string, runningTask]
 	limits     Limits
+	admission  Admission
 	api        *api
 	taskCount  int32
 	middleware []task.MiddlewareFunc
+	secrets    *secrets.MultiProvider
+	redactor   *secrets.Redactor
+	gpus       int
+	platform   string
+	tags       map[string]string
+	status     tork.NodeStatus
 	usedPorts  map[int]struct{}
 	mu         sync.Mutex
+	sem        chan struct{}
+	// drain state, guarded by mu
+	draining     bool
+	requeueing   bool
+	inflight     sync.WaitGroup
+	drainTimeout time.Duration
 }
 
 type Config struct {
@@ -48,11 +89,60 @@ type Config struct {
 	Queues     map[string]int
 	Limits     Limits
 	Middleware []task.MiddlewareFunc
+	// Concurrency caps the number of tasks the worker executes
+	// at the same time across all of its queues. Zero means
+	// no cap beyond the number of subscribers on each queue.
+	Concurrency int
+	// DrainTimeout is how long Stop waits for running tasks
+	// to finish before cancelling and requeueing them.
+	DrainTimeout time.Duration
+	Admission    Admission
+	// Secrets resolves env values that reference an external
+	// secrets store right before the task is executed, so that
+	// their plaintext never leaves the worker.
+	Secrets *secrets.MultiProvider
+	// Redactor masks the resolved secret values in the results
+	// the worker publishes. The runtime should publish the task
+	// logs through Redactor.Broker so that they are masked too.
+	Redactor *secrets.Redactor
+	// GPUs is the number of GPUs the worker advertises. Workers
+	// with GPUs also consume tasks from the gpu queue.
+	GPUs int
+	// Platform is the platform (e.g. linux/arm64) of the tasks the
+	// worker runs. The worker also consumes tasks from its queue.
+	Platform string
+	// Tags are arbitrary key/value pairs (e.g. region=eu) the
+	// worker advertises. Tasks whose selector matches them, along
+	// with their GPUs and platform, are routed to the queue the
+	// worker shares with the workers with the same tags, GPUs and
+	// platform (see mq.NodeQueue).
+	Tags map[string]string
+	// Debug exposes the pprof profiles and the /debug/state
+	// endpoint, which require the API key.
+	Debug bool
+	// APIKey authenticates the requests to the privileged
+	// endpoints of the worker's API (e.g. /images/pull),
+	// which are only served when it is set.
+	APIKey string
+}
+
+// Admission holds the host resource usage thresholds
+// above which the worker stops accepting new tasks and
+// returns them to their queue instead. Zero disables
+// the respective check.
+type Admission struct {
+	MaxCPUPercent    float64
+	MaxMemoryPercent float64
+	MaxDiskPercent   float64
+	// DiskPath is a path on the filesystem whose
+	// usage is checked. Defaults to /
+	DiskPath string
 }
 
 type Limits struct {
 	DefaultCPUsLimit   string
 	DefaultMemoryLimit string
+	DefaultOutputLimit string
 	DefaultTimeout     string
 }
 
@@ -71,7 +161,43 @@ func NewWorker(cfg Config) (*Worker, error) {
 	if cfg.Runtime == nil {
 		return nil, errors.New("must provide runtime")
 	}
+	if cfg.Concurrency < 0 {
+		return nil, errors.Errorf("invalid concurrency: %d", cfg.Concurrency)
+	}
+	if cfg.Admission.DiskPath == "" {
+		cfg.Admission.DiskPath = "/"
+	}
+	if cfg.DrainTimeout == 0 {
+		cfg.DrainTimeout = DefaultDrainTimeout
+	}
+	if cfg.GPUs > 0 {
+		if _, ok := cfg.Queues[mq.QUEUE_GPU]; !ok {
+			cfg.Queues = maps.Clone(cfg.Queues)
+			cfg.Queues[mq.QUEUE_GPU] = 1
+		}
+	}
+	if cfg.Platform != "" {
+		if _, ok := cfg.Queues[mq.PlatformQueue(cfg.Platform)]; !ok {
+			cfg.Queues = maps.Clone(cfg.Queues)
+			cfg.Queues[mq.PlatformQueue(cfg.Platform)] = 1
+		}
+	}
+	if len(cfg.Tags) > 0 || cfg.GPUs > 0 || cfg.Platform != "" {
+		nq := mq.NodeQueue(cfg.Tags, cfg.GPUs, cfg.Platform)
+		if _, ok := cfg.Queues[nq]; !ok {
+			cfg.Queues = maps.Clone(cfg.Queues)
+			cfg.Queues[nq] = 1
+		}
+	}
+	var sem chan struct{}
+	if cfg.Concurrency > 0 {
+		sem = make(chan struct{}, cfg.Concurrency)
+	}
 	tasks := new(syncx.Map[string, runningTask])
+	redactor := cfg.Redactor
+	if redactor == nil {
+		redactor = secrets.NewRedactor()
+	}
 	w := &Worker{
 		id:         uuid.NewShortUUID(),
 		name:       cfg.Name,
@@ -81,10 +207,20 @@ func NewWorker(cfg Config) (*Worker, error) {
 		queues:     cfg.Queues,
 		tasks:      tasks,
 		limits:     cfg.Limits,
+		admission:  cfg.Admission,
 		api:        newAPI(cfg, tasks),
 		stop:       make(chan any),
 		middleware: cfg.Middleware,
+		secrets:    cfg.Secrets,
+		redactor:   redactor,
+		gpus:       cfg.GPUs,
+		platform:   cfg.Platform,
+		tags:       cfg.Tags,
+		status:     tork.NodeStatusUP,
 		usedPorts:  make(map[int]struct{}),
+		sem:        sem,
+
+		drainTimeout: cfg.DrainTimeout,
 	}
 	return w, nil
 }
@@ -102,13 +238,65 @@ func (w *Worker) cancelTask(t *tork.Task) error {
 }
 
 func (w *Worker) handleTask(t *tork.Task) error {
-	ctx := context.Background()
+	// wait for a free slot. since every subscriber prefetches a
+	// single message, at most one message per subscriber is held
+	// back from the other workers while waiting.
+	if w.sem != nil {
+		w.sem <- struct{}{}
+		defer func() { <-w.sem }()
+	}
+	ctx := tracing.Extract(context.Background(), t.Trace)
+	if !w.admit() {
+		// hand the task back to its queue rather than failing
+		// the delivery, which would count toward dead-lettering it
+		log.Info().Msgf("worker %s is shutting down. returning task %s to its queue", w.id, t.ID)
+		// the broker may not have been able to unsubscribe, in
+		// which case the task may well come back to this worker
+		time.Sleep(admissionBackoff)
+		return w.requeueTask(ctx, t)
+	}
+	defer w.inflight.Done()
+	defer func() {
+		time.AfterFunc(redactorGracePeriod, func() {
+			w.redactor.Remove(t.ID)
+		})
+	}()
+	if reason := w.overloaded(); reason != "" {
+		log.Warn().Msgf("worker %s is overloaded (%s). returning task %s to its queue", w.id, reason, t.ID)
+		// give the other workers a chance to pick up the task
+		time.Sleep(admissionBackoff)
+		return w.requeueTask(ctx, t)
+	}
+	if reason := w.cannotRun(t); reason != "" {
+		// the task reached a queue shared with workers of other
+		// capabilities, e.g. the gpu queue or a custom queue
+		now := time.Now().UTC()
+		t.Error = fmt.Sprintf("worker %s can't run the task: %s", w.id, reason)
+		t.FailedAt = &now
+		t.State = tork.TaskStateFailed
+		metrics.TasksFailed.Inc()
+		return w.broker.PublishTask(ctx, mq.QUEUE_ERROR, t)
+	}
+	orig := t.Clone()
+	if t.ScheduledAt != nil {
+		// the time the task spent waiting in its queue
+		_, qspan := tracing.Start(ctx, "tork.task.queued",
+			trace.WithTimestamp(*t.ScheduledAt),
+			trace.WithAttributes(attribute.String("task.id", t.ID), attribute.String("queue", t.Queue)))
+		qspan.End()
+	}
+	ctx, span := tracing.Start(ctx, "tork.task.run", trace.WithAttributes(
+		attribute.String("task.id", t.ID),
+		attribute.String("job.id", t.JobID),
+		attribute.String("node.id", w.id),
+	))
+	defer span.End()
 	started := time.Now().UTC()
 	t.StartedAt = &started
 	t.NodeID = w.id
 	t.State = tork.TaskStateRunning
 	// prepare limits
-	if t.Limits == nil && (w.limits.DefaultCPUsLimit != "" || w.limits.DefaultMemoryLimit != "") {
+	if t.Limits == nil && (w.limits.DefaultCPUsLimit != "" || w.limits.DefaultMemoryLimit != "" || w.limits.DefaultOutputLimit != "") {
 		t.Limits = &tork.TaskLimits{}
 	}
 	if t.Limits != nil && t.Limits.CPUs == "" {
@@ -117,7 +305,11 @@ func (w *Worker) handleTask(t *tork.Task) error {
 	if t.Limits != nil && t.Limits.Memory == "" {
 		t.Limits.Memory = w.limits.DefaultMemoryLimit
 	}
-	if t.Timeout == "" {
+	if t.Limits != nil && t.Limits.Output == "" {
+		t.Limits.Output = w.limits.DefaultOutputLimit
+	}
+	// services run for as long as their job does
+	if t.Timeout == "" && t.Service == nil {
 		t.Timeout = w.limits.DefaultTimeout
 	}
 	// assign host ports
@@ -128,6 +320,7 @@ func (w *Worker) handleTask(t *tork.Task) error {
 			t.Error = err.Error()
 			t.FailedAt = &now
 			t.State = tork.TaskStateFailed
+			metrics.TasksFailed.Inc()
 			return w.broker.PublishTask(ctx, mq.QUEUE_ERROR, t)
 		}
 		log.Debug().Msgf("Port mapping %d->%s", hostPort, p.Port)
@@ -135,42 +328,216 @@ func (w *Worker) handleTask(t *tork.Task) error {
 		p.HostPort = hostPort
 	}
 	adapter := func(ctx context.Context, et task.EventType, t *tork.Task) error {
-		return w.runTask(t)
+		return w.runTask(ctx, t)
 	}
 	// clone the task so that the downstream
 	// process can mutate the task without
 	// affecting the original
 	rt := t.Clone()
+	// a service is reported as completed as soon as it's
+	// ready, so that its job can move on while it's running
+	var ready atomic.Bool
+	if t.Service != nil {
+		pctx := ctx
+		ctx = runtime.WithReadyFunc(ctx, func() {
+			ready.Store(true)
+			w.reportReady(pctx, t)
+		})
+	}
 	mw := task.ApplyMiddleware(adapter, w.middleware)
-	if err := mw(ctx, task.StateChange, rt); err != nil {
+	err := mw(ctx, task.StateChange, rt)
+	if ready.Load() && rt.State != tork.TaskStateFailed {
+		// the service has since been stopped
+		return nil
+	}
+	if rt.State != tork.TaskStateCompleted && w.isRequeueing() {
+		return w.requeueTask(ctx, orig)
+	}
+	if err != nil {
 		now := time.Now().UTC()
 		t.Error = err.Error()
 		t.FailedAt = &now
 		t.State = tork.TaskStateFailed
-		return w.broker.PublishTask(ctx, mq.QUEUE_ERROR, t)
+		metrics.TasksFailed.Inc()
+		span.SetStatus(codes.Error, t.Error)
+		return w.publishResult(ctx, mq.QUEUE_ERROR, t)
 	}
 	switch rt.State {
 	case tork.TaskStateCompleted:
+		metrics.TasksCompleted.Inc()
 		t.Result = rt.Result
+		t.ResultTruncated = rt.ResultTruncated
+		t.ResultURL = rt.ResultURL
+		t.Stats = rt.Stats
+		t.ImageDigest = rt.ImageDigest
+		t.Artifacts = rt.Artifacts
 		t.CompletedAt = rt.CompletedAt
 		t.State = rt.State
-		if err := w.broker.PublishTask(ctx, mq.QUEUE_COMPLETED, t); err != nil {
+		if err := w.publishResult(ctx, mq.QUEUE_COMPLETED, t); err != nil {
 			return err
 		}
 	case tork.TaskStateFailed:
+		metrics.TasksFailed.Inc()
 		t.Error = rt.Error
 		t.FailedAt = rt.FailedAt
+		t.Stats = rt.Stats
+		t.ImageDigest = rt.ImageDigest
 		t.State = rt.State
-		if err := w.broker.PublishTask(ctx, mq.QUEUE_ERROR, t); err != nil {
+		span.SetStatus(codes.Error, t.Error)
+		if err := w.publishResult(ctx, mq.QUEUE_ERROR, t); err != nil {
 			return err
 		}
+	case tork.TaskStateStopped:
+		// stopped by the coordinator. nothing to report
 	default:
 		return errors.Errorf("unexpected state %s for task %s", rt.State, t.ID)
 	}
 	return nil
 }
 
-func (w *Worker) runTask(t *tork.Task) error {
+// reportReady reports the service task as completed
+// while it keeps running until its job is done.
+func (w *Worker) reportReady(ctx context.Context, t *tork.Task) {
+	ct := t.Clone()
+	now := time.Now().UTC()
+	ct.CompletedAt = &now
+	ct.State = tork.TaskStateCompleted
+	metrics.TasksCompleted.Inc()
+	if err := w.publishResult(ctx, mq.QUEUE_COMPLETED, ct); err != nil {
+		log.Error().Err(err).Msgf("error reporting service task %s as ready", t.ID)
+	}
+}
+
+func (w *Worker) publishResult(ctx context.Context, qname string, t *tork.Task) error {
+	t.Result = w.redactor.Redact(t.ID, t.Result)
+	t.Error = w.redactor.Redact(t.ID, t.Error)
+	ctx, span := tracing.Start(ctx, "tork.task.publish", trace.WithAttributes(attribute.String("queue", qname)))
+	err := w.broker.PublishTask(ctx, qname, t)
+	tracing.End(span, err)
+	return err
+}
+
+// admit registers a new in-flight task
+// unless the worker is draining.
+func (w *Worker) admit() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.draining {
+		return false
+	}
+	w.inflight.Add(1)
+	return true
+}
+
+// overloaded returns a description of the first resource whose
+// usage exceeds its admission threshold, or an empty string.
+func (w *Worker) overloaded() string {
+	if w.admission.MaxCPUPercent > 0 {
+		if p := hostCPUPercent(); p > w.admission.MaxCPUPercent {
+			return fmt.Sprintf("cpu %.1f%%", p)
+		}
+	}
+	if w.admission.MaxMemoryPercent > 0 {
+		if p := hostMemoryPercent(); p > w.admission.MaxMemoryPercent {
+			return fmt.Sprintf("memory %.1f%%", p)
+		}
+	}
+	if w.admission.MaxDiskPercent > 0 {
+		if p := hostDiskPercent(w.admission.DiskPath); p > w.admission.MaxDiskPercent {
+			return fmt.Sprintf("disk %.1f%%", p)
+		}
+	}
+	return ""
+}
+
+// cannotRun returns why the worker can't run the task,
+// if its GPUs, platform or tags don't meet its requirements.
+func (w *Worker) cannotRun(t *tork.Task) string {
+	if required := host.RequiredGPUs(t.GPUs); required > w.gpus {
+		return fmt.Sprintf("the task requires %d GPUs, the worker has %d", required, w.gpus)
+	}
+	if t.Platform != "" && w.platform != "" && t.Platform != w.platform {
+		return fmt.Sprintf("the task requires the %s platform, the worker runs %s", t.Platform, w.platform)
+	}
+	for k, v := range t.Selector {
+		if tv, ok := w.tags[k]; !ok || tv != v {
+			return fmt.Sprintf("the worker's tags don't match the task's selector %s=%s", k, v)
+		}
+	}
+	return ""
+}
+
+func (w *Worker) isRequeueing() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.requeueing
+}
+
+// requeueTask returns a task that the worker can't
+// run to its queue so another worker can pick it up.
+func (w *Worker) requeueTask(ctx context.Context, t *tork.Task) error {
+	qname := t.Queue
+	if qname == "" {
+		qname = mq.QUEUE_DEFAULT
+	}
+	log.Info().Msgf("requeueing task %s to %s", t.ID, qname)
+	return w.broker.PublishTask(ctx, qname, t)
+}
+
+// drain stops the worker from accepting new tasks and waits for the
+// running ones to finish. Tasks that are still running once the drain
+// timeout elapses are cancelled and requeued.
+func (w *Worker) drain() {
+	w.mu.Lock()
+	w.draining = true
+	w.mu.Unlock()
+	// stop receiving new tasks, which would otherwise be handed
+	// back to their queue only to be received again
+	w.unsubscribe()
+	done := make(chan any)
+	go func() {
+		w.inflight.Wait()
+		close(done)
+	}()
+	log.Info().Msgf("waiting for the tasks running on worker %s to finish", w.id)
+	select {
+	case <-done:
+		return
+	case <-time.After(w.drainTimeout):
+	}
+	log.Warn().Msgf("drain timeout elapsed. requeueing the tasks still running on worker %s", w.id)
+	w.mu.Lock()
+	w.requeueing = true
+	w.mu.Unlock()
+	w.tasks.Iterate(func(_ string, rt runningTask) {
+		rt.cancel()
+	})
+	select {
+	case <-done:
+	case <-time.After(time.Second * 15):
+		log.Error().Msgf("timed out waiting for the tasks of worker %s to be requeued", w.id)
+	}
+}
+
+// unsubscribe stops the subscriptions of the worker to the shared
+// work queues, when the broker supports it.
+func (w *Worker) unsubscribe() {
+	u, ok := w.broker.(mq.TaskUnsubscriber)
+	if !ok {
+		log.Debug().Msgf("the broker can't unsubscribe worker %s from its queues", w.id)
+		return
+	}
+	for qname := range w.queues {
+		if !mq.IsWorkerQueue(qname) {
+			continue
+		}
+		if err := u.UnsubscribeForTasks(qname); err != nil {
+			log.Warn().Err(err).Msgf("error unsubscribing worker %s from queue %s", w.id, qname)
+		}
+	}
+}
+
+func (w *Worker) runTask(ctx context.Context, t *tork.Task) error {
 	atomic.AddInt32(&w.taskCount, 1)
 	defer func() {
 		atomic.AddInt32(&w.taskCount, -1)
@@ -178,7 +545,7 @@ func (w *Worker) runTask(t *tork.Task) error {
 	// create a cancellation context in case
 	// the coordinator wants to cancel the
 	// task later on
-	ctx, cancel := context.WithCancel(context.Background())
+	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 	w.tasks.Set(t.ID, runningTask{
 		cancel: cancel,
@@ -189,6 +556,7 @@ func (w *Worker) runTask(t *tork.Task) error {
 	if err := w.broker.PublishTask(ctx, mq.QUEUE_STARTED, t); err != nil {
 		return err
 	}
+	metrics.TasksStarted.Inc()
 	if err := w.doRunTask(ctx, t); err != nil {
 		return err
 	}
@@ -207,8 +575,25 @@ func (w *Worker) doRunTask(ctx context.Context, t *tork.Task) error {
 		defer cancel()
 		rctx = tctx
 	}
+	// resolve external secrets
+	if err := w.resolveSecrets(ctx, t.ID, t); err != nil {
+		finished := time.Now().UTC()
+		t.FailedAt = &finished
+		t.State = tork.TaskStateFailed
+		t.Error = err.Error()
+		return nil
+	}
 	// run the task
-	if err := w.runtime.Run(rctx, t); err != nil {
+	started := time.Now()
+	err := w.runtime.Run(rctx, t)
+	metrics.TaskDuration.Observe(time.Since(started).Seconds())
+	if err != nil && t.Service != nil && ctx.Err() != nil {
+		// the service was stopped by the coordinator
+		t.State = tork.TaskStateStopped
+		return nil
+	}
+	if err != nil {
+		metrics.RuntimeErrors.Inc()
 		finished := time.Now().UTC()
 		t.FailedAt = &finished
 		t.State = tork.TaskStateFailed
@@ -221,6 +606,39 @@ func (w *Worker) doRunTask(ctx context.Context, t *tork.Task) error {
 	return nil
 }
 
+// resolveSecrets replaces env values referencing an external
+// secrets store with the secret values. t is the worker's
+// private copy of the task, so the resolved values are not
+// reported back to the coordinator. They are registered with
+// the redactor under the ID of the task, which the logs of
+// its pre and post tasks are also published for.
+func (w *Worker) resolveSecrets(ctx context.Context, taskID string, t *tork.Task) error {
+	if w.secrets == nil {
+		return nil
+	}
+	for name, v := range t.Env {
+		resolved, err := w.secrets.Resolve(ctx, v)
+		if err != nil {
+			return errors.Wrapf(err, "error resolving env var %s", name)
+		}
+		if resolved != v {
+			w.redactor.Add(taskID, resolved)
+		}
+		t.Env[name] = resolved
+	}
+	for _, pre := range t.Pre {
+		if err := w.resolveSecrets(ctx, taskID, pre); err != nil {
+			return err
+		}
+	}
+	for _, post := range t.Post {
+		if err := w.resolveSecrets(ctx, taskID, post); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (w *Worker) sendHeartbeats() {
 	for {
 		ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
@@ -229,12 +647,21 @@ func (w *Worker) sendHeartbeats() {
 		if err := w.runtime.HealthCheck(ctx); err != nil {
 			log.Error().Err(err).Msgf("node %s failed health check", w.id)
 			status = tork.NodeStatusDown
+		} else if err := w.broker.HealthCheck(ctx); err != nil {
+			log.Warn().Err(err).Msgf("node %s broker connection is degraded", w.id)
+			status = tork.NodeStatusDegraded
+		}
+		if status != w.status {
+			log.Info().Msgf("node %s status changed from %s to %s", w.id, w.status, status)
+			w.status = status
 		}
 		hostname, err := os.Hostname()
 		if err != nil {
 			log.Error().Err(err).Msgf("failed to get hostname for worker %s", w.id)
 		}
 		cpuPercent := host.GetCPUPercent()
+		memPercent := host.GetMemoryPercent()
+		diskPercent := host.GetDiskPercent(w.admission.DiskPath)
 		err = w.broker.PublishHeartbeat(
 			context.Background(),
 			&tork.Node{
@@ -242,6 +669,11 @@ func (w *Worker) sendHeartbeats() {
 				Name:            w.name,
 				StartedAt:       w.startTime,
 				CPUPercent:      cpuPercent,
+				MemoryPercent:   memPercent,
+				DiskPercent:     diskPercent,
+				GPUs:            w.gpus,
+				Platform:        w.platform,
+				Tags:            w.tags,
 				Queue:           fmt.Sprintf("%s%s", mq.QUEUE_EXCLUSIVE_PREFIX, w.id),
 				Status:          status,
 				LastHeartbeatAt: time.Now().UTC(),
@@ -292,6 +724,7 @@ func (w *Worker) Start() error {
 func (w *Worker) Stop() error {
 	log.Debug().Msgf("shutting down worker %s", w.id)
 	w.stop <- 1
+	w.drain()
 	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
 	defer cancel()
 	if err := w.broker.Shutdown(ctx); err != nil {
@@ -300,6 +733,11 @@ func (w *Worker) Stop() error {
 	if err := w.api.shutdown(ctx); err != nil {
 		return errors.Wrapf(err, "error shutting down worker %s", w.id)
 	}
+	if c, ok := w.runtime.(io.Closer); ok {
+		if err := c.Close(); err != nil {
+			return errors.Wrapf(err, "error closing the runtime")
+		}
+	}
 	return nil
 }
 
@@ -2,6 +2,7 @@ package worker
 
 import (
 	"context"
+	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
@@ -11,7 +12,9 @@ import (
 	"github.com/runabol/tork/internal/uuid"
 	"github.com/runabol/tork/middleware/task"
 	"github.com/runabol/tork/mq"
+	"github.com/runabol/tork/runtime"
 	"github.com/runabol/tork/runtime/docker"
+	"github.com/runabol/tork/secrets"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -31,6 +34,49 @@ func TestNewWorker(t *testing.T) {
 	assert.NotNil(t, w)
 }
 
+func TestNewWorkerGPUs(t *testing.T) {
+	queues := map[string]int{"some-queue": 2}
+	w, err := NewWorker(Config{
+		Broker:  mq.NewInMemoryBroker(),
+		Runtime: &fakeRuntime{},
+		Queues:  queues,
+		GPUs:    2,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]int{"some-queue": 2, mq.QUEUE_GPU: 1, "tags-tork.gpus=2": 1}, w.queues)
+	// the caller's map is left alone
+	assert.Equal(t, map[string]int{"some-queue": 2}, queues)
+
+	w, err = NewWorker(Config{
+		Broker:  mq.NewInMemoryBroker(),
+		Runtime: &fakeRuntime{},
+		Queues:  map[string]int{mq.QUEUE_GPU: 3},
+		GPUs:    1,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]int{mq.QUEUE_GPU: 3, "tags-tork.gpus=1": 1}, w.queues)
+}
+
+func TestNewWorkerPlatform(t *testing.T) {
+	w, err := NewWorker(Config{
+		Broker:   mq.NewInMemoryBroker(),
+		Runtime:  &fakeRuntime{},
+		Platform: "linux/arm64",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]int{mq.QUEUE_DEFAULT: 1, "platform-linux-arm64": 1, "tags-tork.platform=linux-arm64": 1}, w.queues)
+}
+
+func TestNewWorkerTags(t *testing.T) {
+	w, err := NewWorker(Config{
+		Broker:  mq.NewInMemoryBroker(),
+		Runtime: &fakeRuntime{},
+		Tags:    map[string]string{"region": "eu", "disk": "ssd"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]int{mq.QUEUE_DEFAULT: 1, "tags-disk=ssd,region=eu": 1}, w.queues)
+}
+
 func TestStart(t *testing.T) {
 	rt, err := docker.NewDockerRuntime()
 	assert.NoError(t, err)
@@ -414,6 +460,7 @@ func Test_sendHeartbeat(t *testing.T) {
 	heartbeats := make(chan any)
 	err = b.SubscribeForHeartbeats(func(n *tork.Node) error {
 		assert.Contains(t, n.Version, tork.Version)
+		assert.Greater(t, n.MemoryPercent, float64(0))
 		heartbeats <- 1
 		return nil
 	})
@@ -432,6 +479,36 @@ func Test_sendHeartbeat(t *testing.T) {
 	assert.NoError(t, w.Stop())
 }
 
+type degradedBroker struct {
+	*mq.InMemoryBroker
+}
+
+func (b degradedBroker) HealthCheck(ctx context.Context) error {
+	return errors.New("1 subscription(s) are reconnecting")
+}
+
+func Test_sendHeartbeatDegraded(t *testing.T) {
+	b := degradedBroker{mq.NewInMemoryBroker()}
+	heartbeats := make(chan *tork.Node)
+	err := b.SubscribeForHeartbeats(func(n *tork.Node) error {
+		heartbeats <- n
+		return nil
+	})
+	assert.NoError(t, err)
+
+	w, err := NewWorker(Config{
+		Broker:  b,
+		Runtime: &fakeRuntime{},
+	})
+	assert.NoError(t, err)
+	err = w.Start()
+	assert.NoError(t, err)
+
+	n := <-heartbeats
+	assert.Equal(t, tork.NodeStatusDegraded, n.Status)
+	assert.NoError(t, w.Stop())
+}
+
 func Test_handleTaskRunDefaultLimitExceeded(t *testing.T) {
 	rt, err := docker.NewDockerRuntime()
 	assert.NoError(t, err)
@@ -545,6 +622,387 @@ func Test_handleTaskRunDefaultLimitOK(t *testing.T) {
 	assert.Equal(t, "/somevolume", t1.Mounts[0].Target)
 }
 
+type fakeRuntime struct {
+	mu      sync.Mutex
+	running int
+	max     int
+	delay   time.Duration
+	env     map[string]string
+}
+
+func (r *fakeRuntime) Run(ctx context.Context, t *tork.Task) error {
+	r.mu.Lock()
+	r.running++
+	if r.running > r.max {
+		r.max = r.running
+	}
+	r.env = t.Env
+	r.mu.Unlock()
+	defer func() {
+		r.mu.Lock()
+		r.running--
+		r.mu.Unlock()
+	}()
+	select {
+	case <-time.After(r.delay):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (r *fakeRuntime) Stop(ctx context.Context, t *tork.Task) error {
+	return nil
+}
+
+func (r *fakeRuntime) HealthCheck(ctx context.Context) error {
+	return nil
+}
+
+var _ runtime.Runtime = &fakeRuntime{}
+
+func Test_handleTaskConcurrency(t *testing.T) {
+	rt := &fakeRuntime{delay: time.Millisecond * 50}
+	b := mq.NewInMemoryBroker()
+
+	_, err := NewWorker(Config{
+		Broker:      b,
+		Runtime:     rt,
+		Concurrency: -1,
+	})
+	assert.Error(t, err)
+
+	w, err := NewWorker(Config{
+		Broker:      b,
+		Runtime:     rt,
+		Concurrency: 2,
+		Queues: map[string]int{
+			mq.QUEUE_DEFAULT: 3,
+			"other":          3,
+		},
+	})
+	assert.NoError(t, err)
+
+	var wg sync.WaitGroup
+	wg.Add(10)
+	err = b.SubscribeForTasks(mq.QUEUE_COMPLETED, func(tk *tork.Task) error {
+		wg.Done()
+		return nil
+	})
+	assert.NoError(t, err)
+
+	err = w.Start()
+	assert.NoError(t, err)
+
+	for i := 0; i < 10; i++ {
+		qname := mq.QUEUE_DEFAULT
+		if i%2 == 0 {
+			qname = "other"
+		}
+		err := b.PublishTask(context.Background(), qname, &tork.Task{
+			ID:    uuid.NewUUID(),
+			State: tork.TaskStateScheduled,
+		})
+		assert.NoError(t, err)
+	}
+	wg.Wait()
+
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	assert.Equal(t, 2, rt.max)
+}
+
+func Test_stopDrain(t *testing.T) {
+	rt := &fakeRuntime{delay: time.Millisecond * 500}
+	b := mq.NewInMemoryBroker()
+
+	w, err := NewWorker(Config{
+		Broker:  b,
+		Runtime: rt,
+	})
+	assert.NoError(t, err)
+
+	completed := make(chan *tork.Task, 1)
+	err = b.SubscribeForTasks(mq.QUEUE_COMPLETED, func(tk *tork.Task) error {
+		completed <- tk
+		return nil
+	})
+	assert.NoError(t, err)
+
+	starts := make(chan any)
+	err = b.SubscribeForTasks(mq.QUEUE_STARTED, func(tk *tork.Task) error {
+		close(starts)
+		return nil
+	})
+	assert.NoError(t, err)
+
+	err = w.Start()
+	assert.NoError(t, err)
+
+	t1 := &tork.Task{
+		ID:    uuid.NewUUID(),
+		State: tork.TaskStateScheduled,
+	}
+	err = b.PublishTask(context.Background(), mq.QUEUE_DEFAULT, t1)
+	assert.NoError(t, err)
+
+	<-starts
+	assert.NoError(t, w.Stop())
+
+	// the running task was allowed to finish
+	select {
+	case tk := <-completed:
+		assert.Equal(t, t1.ID, tk.ID)
+	case <-time.After(time.Second):
+		t.Fatal("expected the task to complete")
+	}
+}
+
+// deliveryCounter counts the tasks delivered from a queue.
+type deliveryCounter struct {
+	*mq.InMemoryBroker
+	qname      string
+	deliveries atomic.Int32
+}
+
+func (b *deliveryCounter) SubscribeForTasks(qname string, handler func(t *tork.Task) error) error {
+	return b.InMemoryBroker.SubscribeForTasks(qname, func(t *tork.Task) error {
+		if qname == b.qname {
+			b.deliveries.Add(1)
+		}
+		return handler(t)
+	})
+}
+
+func Test_drainUnsubscribes(t *testing.T) {
+	defer func(b time.Duration) {
+		admissionBackoff = b
+	}(admissionBackoff)
+	admissionBackoff = time.Millisecond
+
+	rt := &fakeRuntime{delay: time.Millisecond * 500}
+	b := &deliveryCounter{InMemoryBroker: mq.NewInMemoryBroker(), qname: mq.QUEUE_DEFAULT}
+
+	w, err := NewWorker(Config{
+		Broker:  b,
+		Runtime: rt,
+	})
+	assert.NoError(t, err)
+
+	starts := make(chan any)
+	err = b.SubscribeForTasks(mq.QUEUE_STARTED, func(tk *tork.Task) error {
+		close(starts)
+		return nil
+	})
+	assert.NoError(t, err)
+
+	err = w.Start()
+	assert.NoError(t, err)
+
+	err = b.PublishTask(context.Background(), mq.QUEUE_DEFAULT, &tork.Task{
+		ID:    uuid.NewUUID(),
+		State: tork.TaskStateScheduled,
+	})
+	assert.NoError(t, err)
+	<-starts
+
+	drained := make(chan any)
+	go func() {
+		w.drain()
+		close(drained)
+	}()
+	assert.Eventually(t, func() bool {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		return w.draining
+	}, time.Second, time.Millisecond*10)
+
+	// a task published while draining waits
+	// in its queue for the other workers
+	err = b.PublishTask(context.Background(), mq.QUEUE_DEFAULT, &tork.Task{
+		ID:    uuid.NewUUID(),
+		State: tork.TaskStateScheduled,
+	})
+	assert.NoError(t, err)
+	<-drained
+
+	assert.Equal(t, int32(1), b.deliveries.Load())
+	qs, err := b.Queues(context.Background())
+	assert.NoError(t, err)
+	for _, q := range qs {
+		if q.Name == mq.QUEUE_DEFAULT {
+			assert.Equal(t, 0, q.Subscribers)
+			assert.Equal(t, 1, q.Size)
+		}
+	}
+}
+
+func Test_handleTaskWhileDraining(t *testing.T) {
+	defer func(b time.Duration) {
+		admissionBackoff = b
+	}(admissionBackoff)
+	admissionBackoff = time.Millisecond
+
+	b := mq.NewInMemoryBroker()
+	w, err := NewWorker(Config{
+		Broker:  b,
+		Runtime: &fakeRuntime{},
+	})
+	assert.NoError(t, err)
+
+	requeued := make(chan *tork.Task, 1)
+	err = b.SubscribeForTasks("drain-test", func(tk *tork.Task) error {
+		requeued <- tk
+		return nil
+	})
+	assert.NoError(t, err)
+
+	w.drain()
+	t1 := &tork.Task{
+		ID:    uuid.NewUUID(),
+		State: tork.TaskStateScheduled,
+		Queue: "drain-test",
+	}
+	// the task is handed back to its queue
+	// instead of failing its delivery
+	assert.NoError(t, w.handleTask(t1))
+	select {
+	case tk := <-requeued:
+		assert.Equal(t, t1.ID, tk.ID)
+	case <-time.After(time.Second):
+		t.Fatal("expected the task to be requeued")
+	}
+}
+
+func Test_stopDrainTimeout(t *testing.T) {
+	rt := &fakeRuntime{delay: time.Minute}
+	b := mq.NewInMemoryBroker()
+
+	w, err := NewWorker(Config{
+		Broker:       b,
+		Runtime:      rt,
+		DrainTimeout: time.Millisecond * 100,
+	})
+	assert.NoError(t, err)
+
+	requeued := make(chan *tork.Task, 1)
+	err = b.SubscribeForTasks("requeue-test", func(tk *tork.Task) error {
+		requeued <- tk
+		return nil
+	})
+	assert.NoError(t, err)
+
+	failed := make(chan any, 1)
+	err = b.SubscribeForTasks(mq.QUEUE_ERROR, func(tk *tork.Task) error {
+		failed <- 1
+		return nil
+	})
+	assert.NoError(t, err)
+
+	starts := make(chan any)
+	err = b.SubscribeForTasks(mq.QUEUE_STARTED, func(tk *tork.Task) error {
+		close(starts)
+		return nil
+	})
+	assert.NoError(t, err)
+
+	err = w.Start()
+	assert.NoError(t, err)
+
+	t1 := &tork.Task{
+		ID:    uuid.NewUUID(),
+		State: tork.TaskStateScheduled,
+		Queue: "requeue-test",
+	}
+	err = b.PublishTask(context.Background(), mq.QUEUE_DEFAULT, t1)
+	assert.NoError(t, err)
+
+	<-starts
+	assert.NoError(t, w.Stop())
+
+	// the task didn't finish in time so it was put back on its queue
+	select {
+	case tk := <-requeued:
+		assert.Equal(t, t1.ID, tk.ID)
+		assert.Equal(t, tork.TaskStateScheduled, tk.State)
+		assert.Empty(t, tk.NodeID)
+	case <-time.After(time.Second):
+		t.Fatal("expected the task to be requeued")
+	}
+	assert.Empty(t, failed)
+}
+
+func Test_handleTaskOverloaded(t *testing.T) {
+	defer func(b time.Duration, f func() float64) {
+		admissionBackoff = b
+		hostMemoryPercent = f
+	}(admissionBackoff, hostMemoryPercent)
+	admissionBackoff = time.Millisecond
+	usage := atomic.Value{}
+	usage.Store(float64(95))
+	hostMemoryPercent = func() float64 {
+		return usage.Load().(float64)
+	}
+
+	rt := &fakeRuntime{}
+	b := mq.NewInMemoryBroker()
+
+	w, err := NewWorker(Config{
+		Broker:  b,
+		Runtime: rt,
+		Admission: Admission{
+			MaxMemoryPercent: 90,
+		},
+	})
+	assert.NoError(t, err)
+
+	requeued := make(chan *tork.Task, 1)
+	err = b.SubscribeForTasks("admission-test", func(tk *tork.Task) error {
+		requeued <- tk
+		return nil
+	})
+	assert.NoError(t, err)
+
+	completed := make(chan *tork.Task, 1)
+	err = b.SubscribeForTasks(mq.QUEUE_COMPLETED, func(tk *tork.Task) error {
+		completed <- tk
+		return nil
+	})
+	assert.NoError(t, err)
+
+	err = w.Start()
+	assert.NoError(t, err)
+
+	t1 := &tork.Task{
+		ID:    uuid.NewUUID(),
+		State: tork.TaskStateScheduled,
+		Queue: "admission-test",
+	}
+	err = b.PublishTask(context.Background(), mq.QUEUE_DEFAULT, t1)
+	assert.NoError(t, err)
+
+	// memory pressure: the task is returned to its queue
+	select {
+	case tk := <-requeued:
+		assert.Equal(t, t1.ID, tk.ID)
+		assert.Equal(t, tork.TaskStateScheduled, tk.State)
+	case <-time.After(time.Second):
+		t.Fatal("expected the task to be requeued")
+	}
+	assert.Equal(t, 0, rt.max)
+
+	// back under the threshold: the task runs
+	usage.Store(float64(50))
+	err = b.PublishTask(context.Background(), mq.QUEUE_DEFAULT, t1)
+	assert.NoError(t, err)
+	select {
+	case tk := <-completed:
+		assert.Equal(t, t1.ID, tk.ID)
+	case <-time.After(time.Second):
+		t.Fatal("expected the task to complete")
+	}
+}
+
 func Test_reservePort(t *testing.T) {
 	rt, err := docker.NewDockerRuntime()
 	assert.NoError(t, err)
@@ -565,3 +1023,218 @@ func Test_reservePort(t *testing.T) {
 	w.releasePort(port)
 	assert.NotContains(t, w.usedPorts, port)
 }
+
+type fakeSecrets map[string]string
+
+func (p fakeSecrets) GetSecret(ctx context.Context, ref string) (string, error) {
+	v, ok := p[ref]
+	if !ok {
+		return "", errors.Errorf("secret not found: %s", ref)
+	}
+	return v, nil
+}
+
+func Test_handleTaskSecrets(t *testing.T) {
+	rt := &fakeRuntime{}
+	b := mq.NewInMemoryBroker()
+
+	sp := secrets.NewMultiProvider()
+	assert.NoError(t, sp.RegisterProvider("fake", fakeSecrets{"db#password": "s3cr3t"}))
+
+	w, err := NewWorker(Config{
+		Broker:  b,
+		Runtime: rt,
+		Secrets: sp,
+	})
+	assert.NoError(t, err)
+
+	completed := make(chan *tork.Task, 1)
+	err = b.SubscribeForTasks(mq.QUEUE_COMPLETED, func(tk *tork.Task) error {
+		completed <- tk
+		return nil
+	})
+	assert.NoError(t, err)
+	failed := make(chan *tork.Task, 1)
+	err = b.SubscribeForTasks(mq.QUEUE_ERROR, func(tk *tork.Task) error {
+		failed <- tk
+		return nil
+	})
+	assert.NoError(t, err)
+
+	err = w.handleTask(&tork.Task{
+		ID:    uuid.NewUUID(),
+		State: tork.TaskStateScheduled,
+		Env: map[string]string{
+			"PASSWORD": "fake://db#password",
+			"OTHER":    "plain",
+		},
+	})
+	assert.NoError(t, err)
+
+	tk := <-completed
+	// the runtime sees the secret but the coordinator doesn't
+	assert.Equal(t, "s3cr3t", rt.env["PASSWORD"])
+	assert.Equal(t, "plain", rt.env["OTHER"])
+	assert.Equal(t, "fake://db#password", tk.Env["PASSWORD"])
+
+	err = w.handleTask(&tork.Task{
+		ID:    uuid.NewUUID(),
+		State: tork.TaskStateScheduled,
+		Env: map[string]string{
+			"PASSWORD": "fake://db#username",
+		},
+	})
+	assert.NoError(t, err)
+
+	tk = <-failed
+	assert.Equal(t, tork.TaskStateFailed, tk.State)
+	assert.Contains(t, tk.Error, "PASSWORD")
+}
+
+func Test_handleTaskRedactsSecrets(t *testing.T) {
+	b := mq.NewInMemoryBroker()
+	redactor := secrets.NewRedactor()
+	logs := redactor.Broker(b)
+	// the runtime echoes the secret to the logs and the result
+	rt := runtime.Wrap(&fakeRuntime{}, runtime.RunMiddleware(func(next runtime.RunFunc) runtime.RunFunc {
+		return func(ctx context.Context, t *tork.Task) error {
+			if err := logs.PublishTaskLogPart(ctx, &tork.TaskLogPart{
+				TaskID:   t.ID,
+				Number:   1,
+				Contents: "connecting with " + t.Env["PASSWORD"],
+			}); err != nil {
+				return err
+			}
+			t.Result = "password=" + t.Env["PASSWORD"]
+			return next(ctx, t)
+		}
+	}))
+
+	sp := secrets.NewMultiProvider()
+	assert.NoError(t, sp.RegisterProvider("fake", fakeSecrets{"db#password": "s3cr3t"}))
+
+	w, err := NewWorker(Config{
+		Broker:   b,
+		Runtime:  rt,
+		Secrets:  sp,
+		Redactor: redactor,
+	})
+	assert.NoError(t, err)
+
+	parts := make(chan *tork.TaskLogPart, 1)
+	err = b.SubscribeForTaskLogPart(func(p *tork.TaskLogPart) {
+		parts <- p
+	})
+	assert.NoError(t, err)
+	completed := make(chan *tork.Task, 1)
+	err = b.SubscribeForTasks(mq.QUEUE_COMPLETED, func(tk *tork.Task) error {
+		completed <- tk
+		return nil
+	})
+	assert.NoError(t, err)
+
+	err = w.handleTask(&tork.Task{
+		ID:    uuid.NewUUID(),
+		State: tork.TaskStateScheduled,
+		Env: map[string]string{
+			"PASSWORD": "fake://db#password",
+		},
+	})
+	assert.NoError(t, err)
+
+	tk := <-completed
+	assert.Equal(t, "password=[REDACTED]", tk.Result)
+	p := <-parts
+	assert.Equal(t, "connecting with [REDACTED]", p.Contents)
+}
+
+func Test_handleServiceTask(t *testing.T) {
+	// signal readiness as soon as the service starts
+	rt := runtime.Wrap(&fakeRuntime{delay: time.Minute}, runtime.RunMiddleware(func(next runtime.RunFunc) runtime.RunFunc {
+		return func(ctx context.Context, t *tork.Task) error {
+			runtime.Ready(ctx)
+			return next(ctx, t)
+		}
+	}))
+	b := mq.NewInMemoryBroker()
+
+	w, err := NewWorker(Config{
+		Broker:  b,
+		Runtime: rt,
+	})
+	assert.NoError(t, err)
+
+	completed := make(chan *tork.Task, 1)
+	err = b.SubscribeForTasks(mq.QUEUE_COMPLETED, func(tk *tork.Task) error {
+		completed <- tk
+		return nil
+	})
+	assert.NoError(t, err)
+	failed := make(chan *tork.Task, 1)
+	err = b.SubscribeForTasks(mq.QUEUE_ERROR, func(tk *tork.Task) error {
+		failed <- tk
+		return nil
+	})
+	assert.NoError(t, err)
+
+	tid := uuid.NewUUID()
+	done := make(chan error)
+	go func() {
+		done <- w.handleTask(&tork.Task{
+			ID:      tid,
+			State:   tork.TaskStateScheduled,
+			Service: &tork.ServiceTask{},
+		})
+	}()
+
+	// the service is reported as completed while it's still running
+	tk := <-completed
+	assert.Equal(t, tork.TaskStateCompleted, tk.State)
+	assert.NotNil(t, tk.CompletedAt)
+	_, running := w.tasks.Get(tid)
+	assert.True(t, running)
+
+	// stopping the service isn't reported as a failure
+	err = w.cancelTask(&tork.Task{ID: tid, State: tork.TaskStateCancelled})
+	assert.NoError(t, err)
+	assert.NoError(t, <-done)
+	select {
+	case tk := <-failed:
+		t.Fatalf("unexpected failure of service task: %s", tk.Error)
+	case tk := <-completed:
+		t.Fatalf("service task %s completed twice", tk.ID)
+	case <-time.After(time.Millisecond * 100):
+	}
+}
+
+func Test_handleTaskCannotRun(t *testing.T) {
+	b := mq.NewInMemoryBroker()
+
+	errs := make(chan *tork.Task, 3)
+	err := b.SubscribeForTasks(mq.QUEUE_ERROR, func(tk *tork.Task) error {
+		errs <- tk
+		return nil
+	})
+	assert.NoError(t, err)
+
+	w, err := NewWorker(Config{
+		Broker:   b,
+		Runtime:  &fakeRuntime{},
+		GPUs:     1,
+		Platform: "linux/amd64",
+		Tags:     map[string]string{"region": "eu"},
+	})
+	assert.NoError(t, err)
+
+	for _, tk := range []*tork.Task{
+		{ID: uuid.NewUUID(), GPUs: "2"},
+		{ID: uuid.NewUUID(), Platform: "linux/arm64"},
+		{ID: uuid.NewUUID(), Selector: map[string]string{"region": "us"}},
+	} {
+		err = w.handleTask(tk)
+		assert.NoError(t, err)
+		failed := <-errs
+		assert.Equal(t, tork.TaskStateFailed, failed.State)
+		assert.Contains(t, failed.Error, "can't run the task")
+	}
+}
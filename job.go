@@ -18,11 +18,19 @@ const (
 	JobStateRestart   JobState = "RESTART"
 )
 
+func (s JobState) IsActive() bool {
+	return s == JobStatePending ||
+		s == JobStateScheduled ||
+		s == JobStateRunning ||
+		s == JobStateRestart
+}
+
 type Job struct {
 	ID          string            `json:"id,omitempty"`
 	ParentID    string            `json:"parentId,omitempty"`
 	Name        string            `json:"name,omitempty"`
 	Description string            `json:"description,omitempty"`
+	Namespace   string            `json:"namespace,omitempty"`
 	Tags        []string          `json:"tags,omitempty"`
 	State       JobState          `json:"state,omitempty"`
 	CreatedAt   time.Time         `json:"createdAt,omitempty"`
@@ -46,6 +54,22 @@ type Job struct {
 	DeleteAt    *time.Time        `json:"deleteAt,omitempty"`
 	Secrets     map[string]string `json:"secrets,omitempty"`
 	Progress    float64           `json:"progress,omitempty"`
+	// Timeout is the total time the job is allowed to run
+	// for. When exceeded, the job fails and its active tasks
+	// are cancelled.
+	Timeout   string     `json:"timeout,omitempty"`
+	TimeoutAt *time.Time `json:"timeoutAt,omitempty"`
+	// IdempotencyKey is the client-supplied key the
+	// job was submitted with, if any. Keys are scoped
+	// to the user who submitted the job.
+	IdempotencyKey string `json:"idempotencyKey,omitempty"`
+	// IdempotencyHash is the hash of the submission the key
+	// was first used with, to tell apart a retry of the same
+	// submission from a different one reusing the key.
+	IdempotencyHash string `json:"-"`
+	// Trace is the W3C trace context of the span the job
+	// was submitted in. Spans of the job's tasks are its children.
+	Trace map[string]string `json:"trace,omitempty"`
 }
 
 type JobSummary struct {
@@ -55,6 +79,7 @@ type JobSummary struct {
 	Inputs      map[string]string `json:"inputs,omitempty"`
 	Name        string            `json:"name,omitempty"`
 	Description string            `json:"description,omitempty"`
+	Namespace   string            `json:"namespace,omitempty"`
 	Tags        []string          `json:"tags,omitempty"`
 	State       JobState          `json:"state,omitempty"`
 	CreatedAt   time.Time         `json:"createdAt,omitempty"`
@@ -96,6 +121,8 @@ type Webhook struct {
 	URL     string            `json:"url,omitempty"`
 	Headers map[string]string `json:"headers,omitempty"`
 	Event   string            `json:"event,omitempty"`
+	// Secret, when set, is used to sign the request body
+	Secret string `json:"secret,omitempty"`
 }
 
 func (j *Job) Clone() *Job {
@@ -112,32 +139,38 @@ func (j *Job) Clone() *Job {
 		autoDelete = j.AutoDelete.Clone()
 	}
 	return &Job{
-		ID:          j.ID,
-		Name:        j.Name,
-		Description: j.Description,
-		Tags:        j.Tags,
-		State:       j.State,
-		CreatedAt:   j.CreatedAt,
-		CreatedBy:   createdBy,
-		StartedAt:   j.StartedAt,
-		CompletedAt: j.CompletedAt,
-		FailedAt:    j.FailedAt,
-		Tasks:       CloneTasks(j.Tasks),
-		Execution:   CloneTasks(j.Execution),
-		Position:    j.Position,
-		Inputs:      maps.Clone(j.Inputs),
-		Secrets:     maps.Clone(j.Secrets),
-		Context:     j.Context.Clone(),
-		ParentID:    j.ParentID,
-		TaskCount:   j.TaskCount,
-		Output:      j.Output,
-		Result:      j.Result,
-		Error:       j.Error,
-		Defaults:    defaults,
-		Webhooks:    CloneWebhooks(j.Webhooks),
-		Permissions: ClonePermissions(j.Permissions),
-		AutoDelete:  autoDelete,
-		Progress:    j.Progress,
+		ID:              j.ID,
+		Name:            j.Name,
+		Description:     j.Description,
+		Namespace:       j.Namespace,
+		Tags:            j.Tags,
+		State:           j.State,
+		CreatedAt:       j.CreatedAt,
+		CreatedBy:       createdBy,
+		StartedAt:       j.StartedAt,
+		CompletedAt:     j.CompletedAt,
+		FailedAt:        j.FailedAt,
+		Tasks:           CloneTasks(j.Tasks),
+		Execution:       CloneTasks(j.Execution),
+		Position:        j.Position,
+		Inputs:          maps.Clone(j.Inputs),
+		Secrets:         maps.Clone(j.Secrets),
+		Context:         j.Context.Clone(),
+		ParentID:        j.ParentID,
+		TaskCount:       j.TaskCount,
+		Output:          j.Output,
+		Result:          j.Result,
+		Error:           j.Error,
+		Defaults:        defaults,
+		Webhooks:        CloneWebhooks(j.Webhooks),
+		Permissions:     ClonePermissions(j.Permissions),
+		AutoDelete:      autoDelete,
+		Progress:        j.Progress,
+		Timeout:         j.Timeout,
+		TimeoutAt:       j.TimeoutAt,
+		IdempotencyKey:  j.IdempotencyKey,
+		IdempotencyHash: j.IdempotencyHash,
+		Trace:           maps.Clone(j.Trace),
 	}
 }
 
@@ -180,6 +213,7 @@ func NewJobSummary(j *Job) *JobSummary {
 		ParentID:    j.ParentID,
 		Name:        j.Name,
 		Description: j.Description,
+		Namespace:   j.Namespace,
 		Tags:        j.Tags,
 		Inputs:      maps.Clone(j.Inputs),
 		State:       j.State,
@@ -208,6 +242,7 @@ func (w *Webhook) Clone() *Webhook {
 		URL:     w.URL,
 		Headers: maps.Clone(w.Headers),
 		Event:   w.Event,
+		Secret:  w.Secret,
 	}
 }
 
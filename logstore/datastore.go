@@ -0,0 +1,72 @@
+package logstore
+
+import (
+	"context"
+	"sort"
+
+	"github.com/runabol/tork"
+	"github.com/runabol/tork/datastore"
+)
+
+// the number of parts read from the datastore at a time
+const datastorePageSize = 100
+
+// DatastoreStore stores the logs as parts in the datastore
+// (e.g. the tasks_log_parts table of the postgres datastore),
+// which is where the coordinator keeps them by default.
+type DatastoreStore struct {
+	ds datastore.Datastore
+}
+
+func NewDatastoreStore(ds datastore.Datastore) *DatastoreStore {
+	return &DatastoreStore{ds: ds}
+}
+
+func (s *DatastoreStore) Append(ctx context.Context, p *tork.TaskLogPart) error {
+	return s.ds.CreateTaskLogPart(ctx, p)
+}
+
+func (s *DatastoreStore) Read(ctx context.Context, taskID string, offset int64, size int) ([]byte, error) {
+	parts := make([]*tork.TaskLogPart, 0)
+	for page := 1; ; page++ {
+		p, err := s.ds.GetTaskLogParts(ctx, taskID, page, datastorePageSize)
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, p.Items...)
+		if page >= p.TotalPages {
+			break
+		}
+	}
+	sort.Slice(parts, func(i, j int) bool {
+		return parts[i].Number < parts[j].Number
+	})
+	result := make([]byte, 0)
+	var pos int64
+	for _, p := range parts {
+		if len(result) >= size {
+			break
+		}
+		end := pos + int64(len(p.Contents))
+		if end > offset {
+			start := int64(0)
+			if offset > pos {
+				start = offset - pos
+			}
+			result = append(result, p.Contents[start:]...)
+		}
+		pos = end
+	}
+	if len(result) > size {
+		result = result[:size]
+	}
+	return result, nil
+}
+
+func (s *DatastoreStore) TaskParts(ctx context.Context, taskID string, page, size int) (*datastore.Page[*tork.TaskLogPart], error) {
+	return s.ds.GetTaskLogParts(ctx, taskID, page, size)
+}
+
+func (s *DatastoreStore) JobParts(ctx context.Context, j *tork.Job, page, size int) (*datastore.Page[*tork.TaskLogPart], error) {
+	return s.ds.GetJobLogParts(ctx, j.ID, page, size)
+}
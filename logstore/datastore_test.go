@@ -0,0 +1,39 @@
+package logstore_test
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/runabol/tork"
+	"github.com/runabol/tork/datastore/inmemory"
+	"github.com/runabol/tork/internal/uuid"
+	"github.com/runabol/tork/logstore"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDatastoreStoreAppendRead(t *testing.T) {
+	ctx := context.Background()
+	ds := inmemory.NewInMemoryDatastore()
+	t1 := &tork.Task{ID: uuid.NewUUID()}
+	assert.NoError(t, ds.CreateTask(ctx, t1))
+	s := logstore.NewDatastoreStore(ds)
+	var expected strings.Builder
+	// spans more than one page of parts
+	for i := 1; i <= 150; i++ {
+		line := fmt.Sprintf("line %d\n", i)
+		expected.WriteString(line)
+		assert.NoError(t, s.Append(ctx, &tork.TaskLogPart{TaskID: t1.ID, Number: i, Contents: line}))
+	}
+
+	b, err := s.Read(ctx, t1.ID, 0, 1<<20)
+	assert.NoError(t, err)
+	assert.Equal(t, expected.String(), string(b))
+	b, err = s.Read(ctx, t1.ID, 9, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, expected.String()[9:19], string(b))
+	b, err = s.Read(ctx, t1.ID, int64(expected.Len()), 10)
+	assert.NoError(t, err)
+	assert.Empty(t, b)
+}
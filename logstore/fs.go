@@ -0,0 +1,107 @@
+package logstore
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/runabol/tork"
+	"github.com/runabol/tork/datastore"
+)
+
+// FileStore stores the log of each task in a directory of its
+// own, in which every part of the log is a file of its own.
+type FileStore struct {
+	dir string
+}
+
+func NewFileStore(dir string) (*FileStore, error) {
+	if dir == "" {
+		return nil, errors.New("must provide a logs directory")
+	}
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, errors.Wrapf(err, "error creating logs directory %s", dir)
+	}
+	return &FileStore{dir: dir}, nil
+}
+
+func (s *FileStore) Append(ctx context.Context, p *tork.TaskLogPart) error {
+	if err := validateTaskID(p.TaskID); err != nil {
+		return err
+	}
+	dir := filepath.Join(s.dir, p.TaskID)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return errors.Wrapf(err, "error creating the log directory of task %s", p.TaskID)
+	}
+	// the part numbers are padded so that
+	// the parts are listed in order
+	name := filepath.Join(dir, fmt.Sprintf("%010d.log", p.Number))
+	if err := os.WriteFile(name, []byte(p.Contents), 0644); err != nil {
+		return errors.Wrapf(err, "error writing the log of task %s", p.TaskID)
+	}
+	return nil
+}
+
+func (s *FileStore) Read(ctx context.Context, taskID string, offset int64, size int) ([]byte, error) {
+	return readLog(ctx, s, taskID, offset, size)
+}
+
+func (s *FileStore) TaskParts(ctx context.Context, taskID string, page, size int) (*datastore.Page[*tork.TaskLogPart], error) {
+	return taskParts(ctx, s, taskID, page, size)
+}
+
+func (s *FileStore) JobParts(ctx context.Context, j *tork.Job, page, size int) (*datastore.Page[*tork.TaskLogPart], error) {
+	return jobParts(ctx, s, j, page, size)
+}
+
+func (s *FileStore) listParts(ctx context.Context, taskID string) ([]part, error) {
+	dir := filepath.Join(s.dir, taskID)
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return []part{}, nil
+	} else if err != nil {
+		return nil, errors.Wrapf(err, "error listing the log of task %s", taskID)
+	}
+	parts := make([]part, 0, len(entries))
+	for _, e := range entries {
+		number, err := strconv.Atoi(strings.TrimSuffix(e.Name(), ".log"))
+		if err != nil || e.IsDir() {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			return nil, errors.Wrapf(err, "error listing the log of task %s", taskID)
+		}
+		parts = append(parts, part{
+			taskID:    taskID,
+			number:    number,
+			key:       filepath.Join(dir, e.Name()),
+			size:      info.Size(),
+			createdAt: info.ModTime().UTC(),
+		})
+	}
+	return parts, nil
+}
+
+func (s *FileStore) readPart(ctx context.Context, p part, first, last int64) ([]byte, error) {
+	f, err := os.Open(p.key)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error opening the log of task %s", p.taskID)
+	}
+	defer f.Close()
+	b := make([]byte, last-first+1)
+	n, err := f.ReadAt(b, first)
+	if err != nil && err != io.EOF {
+		return nil, errors.Wrapf(err, "error reading the log of task %s", p.taskID)
+	}
+	return b[:n], nil
+}
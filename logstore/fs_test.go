@@ -0,0 +1,84 @@
+package logstore_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/runabol/tork"
+	"github.com/runabol/tork/logstore"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileStoreAppendRead(t *testing.T) {
+	ctx := context.Background()
+	s, err := logstore.NewFileStore(t.TempDir())
+	assert.NoError(t, err)
+	assert.NoError(t, s.Append(ctx, &tork.TaskLogPart{TaskID: "task-1", Number: 1, Contents: "hello "}))
+	assert.NoError(t, s.Append(ctx, &tork.TaskLogPart{TaskID: "task-1", Number: 2, Contents: "world\n"}))
+
+	b, err := s.Read(ctx, "task-1", 0, 1024)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello world\n", string(b))
+	b, err = s.Read(ctx, "task-1", 3, 5)
+	assert.NoError(t, err)
+	assert.Equal(t, "lo wo", string(b))
+	b, err = s.Read(ctx, "task-1", 12, 1024)
+	assert.NoError(t, err)
+	assert.Empty(t, b)
+	// a task without a log
+	b, err = s.Read(ctx, "task-2", 0, 1024)
+	assert.NoError(t, err)
+	assert.Empty(t, b)
+}
+
+func TestFileStoreInvalidTaskID(t *testing.T) {
+	ctx := context.Background()
+	s, err := logstore.NewFileStore(t.TempDir())
+	assert.NoError(t, err)
+	assert.Error(t, s.Append(ctx, &tork.TaskLogPart{TaskID: "../task-1", Number: 1, Contents: "bad"}))
+	_, err = s.Read(ctx, "..", 0, 1024)
+	assert.Error(t, err)
+	_, err = logstore.NewFileStore("")
+	assert.Error(t, err)
+}
+
+func TestFileStoreParts(t *testing.T) {
+	ctx := context.Background()
+	s, err := logstore.NewFileStore(t.TempDir())
+	assert.NoError(t, err)
+	for i := 1; i <= 3; i++ {
+		assert.NoError(t, s.Append(ctx, &tork.TaskLogPart{TaskID: "task-1", Number: i, Contents: fmt.Sprintf("line %d\n", i)}))
+	}
+	for i := 1; i <= 2; i++ {
+		assert.NoError(t, s.Append(ctx, &tork.TaskLogPart{TaskID: "task-2", Number: i, Contents: fmt.Sprintf("other %d\n", i)}))
+	}
+
+	p, err := s.TaskParts(ctx, "task-1", 1, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, p.TotalItems)
+	assert.Equal(t, 2, p.TotalPages)
+	assert.Len(t, p.Items, 2)
+	assert.Equal(t, 3, p.Items[0].Number)
+	assert.Equal(t, "line 3\n", p.Items[0].Contents)
+	assert.Equal(t, "task-1", p.Items[0].TaskID)
+	assert.NotNil(t, p.Items[0].CreatedAt)
+	assert.Equal(t, 2, p.Items[1].Number)
+	p, err = s.TaskParts(ctx, "task-1", 2, 2)
+	assert.NoError(t, err)
+	assert.Len(t, p.Items, 1)
+	assert.Equal(t, "line 1\n", p.Items[0].Contents)
+	p, err = s.TaskParts(ctx, "task-3", 1, 2)
+	assert.NoError(t, err)
+	assert.Empty(t, p.Items)
+
+	j := &tork.Job{Execution: []*tork.Task{{ID: "task-1"}, {ID: "task-2"}, {ID: "task-3"}}}
+	p, err = s.JobParts(ctx, j, 1, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 5, p.TotalItems)
+	contents := make([]string, 0)
+	for _, item := range p.Items {
+		contents = append(contents, item.Contents)
+	}
+	assert.Equal(t, []string{"other 2\n", "other 1\n", "line 3\n", "line 2\n", "line 1\n"}, contents)
+}
@@ -0,0 +1,141 @@
+package logstore
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"github.com/runabol/tork"
+	"github.com/runabol/tork/datastore"
+)
+
+// part is a part of a log which is stored
+// in a file or an object of its own.
+type part struct {
+	taskID    string
+	number    int
+	key       string
+	size      int64
+	createdAt time.Time
+}
+
+// partStore is implemented by the stores which keep every
+// part of a log in a file or an object of its own.
+type partStore interface {
+	// listParts lists the parts of the log of the task, oldest first.
+	listParts(ctx context.Context, taskID string) ([]part, error)
+	// readPart reads the bytes first through last of the part.
+	readPart(ctx context.Context, p part, first, last int64) ([]byte, error)
+}
+
+// readLog reads up to size bytes of the log of
+// the task, starting at the offset.
+func readLog(ctx context.Context, s partStore, taskID string, offset int64, size int) ([]byte, error) {
+	if err := validateTaskID(taskID); err != nil {
+		return nil, err
+	}
+	parts, err := s.listParts(ctx, taskID)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]byte, 0)
+	var pos int64
+	for _, p := range parts {
+		remaining := int64(size - len(result))
+		if remaining <= 0 {
+			break
+		}
+		end := pos + p.size
+		if end > offset && p.size > 0 {
+			start := int64(0)
+			if offset > pos {
+				start = offset - pos
+			}
+			last := p.size - 1
+			if start+remaining-1 < last {
+				last = start + remaining - 1
+			}
+			b, err := s.readPart(ctx, p, start, last)
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, b...)
+		}
+		pos = end
+	}
+	return result, nil
+}
+
+// taskParts returns a page of the parts of the
+// log of the task, the most recent first.
+func taskParts(ctx context.Context, s partStore, taskID string, page, size int) (*datastore.Page[*tork.TaskLogPart], error) {
+	if err := validateTaskID(taskID); err != nil {
+		return nil, err
+	}
+	parts, err := s.listParts(ctx, taskID)
+	if err != nil {
+		return nil, err
+	}
+	slices.Reverse(parts)
+	return readPage(ctx, s, parts, page, size)
+}
+
+// jobParts returns a page of the parts of the logs of the
+// tasks of the job, those of its last tasks first.
+func jobParts(ctx context.Context, s partStore, j *tork.Job, page, size int) (*datastore.Page[*tork.TaskLogPart], error) {
+	parts := make([]part, 0)
+	// the execution of the job is ordered by position
+	for i := len(j.Execution) - 1; i >= 0; i-- {
+		taskID := j.Execution[i].ID
+		if err := validateTaskID(taskID); err != nil {
+			return nil, err
+		}
+		tparts, err := s.listParts(ctx, taskID)
+		if err != nil {
+			return nil, err
+		}
+		slices.Reverse(tparts)
+		parts = append(parts, tparts...)
+	}
+	return readPage(ctx, s, parts, page, size)
+}
+
+// readPage reads the contents of the parts on the page only.
+func readPage(ctx context.Context, s partStore, parts []part, page, size int) (*datastore.Page[*tork.TaskLogPart], error) {
+	if page < 1 {
+		page = 1
+	}
+	if size < 1 {
+		size = 1
+	}
+	items := make([]*tork.TaskLogPart, 0)
+	for i := (page - 1) * size; i < page*size && i < len(parts); i++ {
+		p := parts[i]
+		contents := []byte{}
+		if p.size > 0 {
+			b, err := s.readPart(ctx, p, 0, p.size-1)
+			if err != nil {
+				return nil, err
+			}
+			contents = b
+		}
+		createdAt := p.createdAt
+		items = append(items, &tork.TaskLogPart{
+			Number:    p.number,
+			TaskID:    p.taskID,
+			Contents:  string(contents),
+			CreatedAt: &createdAt,
+		})
+	}
+	totalPages := len(parts) / size
+	if len(parts)%size != 0 {
+		totalPages = totalPages + 1
+	}
+	return &datastore.Page[*tork.TaskLogPart]{
+		Items:      items,
+		Number:     page,
+		Size:       len(items),
+		TotalPages: totalPages,
+		TotalItems: len(parts),
+	}, nil
+}
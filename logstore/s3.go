@@ -0,0 +1,285 @@
+package logstore
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/runabol/tork"
+	"github.com/runabol/tork/datastore"
+	"github.com/runabol/tork/internal/aws"
+)
+
+const (
+	S3_DEFAULT_REGION = "us-east-1"
+	S3_DEFAULT_PREFIX = "logs/"
+)
+
+// S3Store stores the logs in an S3 bucket (or a service
+// compatible with the S3 API, such as MinIO). S3 objects
+// can't be appended to, so every part of a log is stored
+// as an object of its own under <prefix><task id>/.
+type S3Store struct {
+	bucket       string
+	prefix       string
+	region       string
+	endpoint     string
+	pathStyle    bool
+	accessKey    string
+	secretKey    string
+	sessionToken string
+	client       *http.Client
+}
+
+type S3Option = func(s *S3Store)
+
+// WithS3Prefix sets the prefix of the keys of the logs.
+// Default: logs/
+func WithS3Prefix(prefix string) S3Option {
+	return func(s *S3Store) {
+		s.prefix = prefix
+	}
+}
+
+// WithS3Region sets the region of the bucket.
+// Default: $AWS_REGION or us-east-1
+func WithS3Region(region string) S3Option {
+	return func(s *S3Store) {
+		s.region = region
+	}
+}
+
+// WithS3Endpoint sets the URL of an S3 compatible service
+// (e.g. http://localhost:9000). Buckets are addressed
+// path-style on custom endpoints.
+func WithS3Endpoint(endpoint string) S3Option {
+	return func(s *S3Store) {
+		s.endpoint = strings.TrimSuffix(endpoint, "/")
+		s.pathStyle = true
+	}
+}
+
+// WithS3Credentials sets the access keys used to sign the
+// requests. Default: $AWS_ACCESS_KEY_ID, $AWS_SECRET_ACCESS_KEY
+// and $AWS_SESSION_TOKEN
+func WithS3Credentials(accessKey, secretKey, sessionToken string) S3Option {
+	return func(s *S3Store) {
+		s.accessKey = accessKey
+		s.secretKey = secretKey
+		s.sessionToken = sessionToken
+	}
+}
+
+func NewS3Store(bucket string, opts ...S3Option) (*S3Store, error) {
+	if bucket == "" {
+		return nil, errors.New("must provide an S3 bucket")
+	}
+	creds := aws.EnvCredentials()
+	s := &S3Store{
+		bucket:       bucket,
+		prefix:       S3_DEFAULT_PREFIX,
+		region:       os.Getenv("AWS_REGION"),
+		accessKey:    creds.AccessKey,
+		secretKey:    creds.SecretKey,
+		sessionToken: creds.SessionToken,
+		client:       &http.Client{Timeout: time.Minute},
+	}
+	for _, o := range opts {
+		o(s)
+	}
+	if s.region == "" {
+		s.region = S3_DEFAULT_REGION
+	}
+	if s.accessKey == "" || s.secretKey == "" {
+		return nil, errors.New("must provide the S3 access key and secret key")
+	}
+	if s.endpoint == "" {
+		s.endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", s.region)
+	}
+	return s, nil
+}
+
+func (s *S3Store) Append(ctx context.Context, p *tork.TaskLogPart) error {
+	if err := validateTaskID(p.TaskID); err != nil {
+		return err
+	}
+	// the part numbers are padded so that
+	// the parts are listed in order
+	key := fmt.Sprintf("%s%s/%010d", s.prefix, p.TaskID, p.Number)
+	body := []byte(p.Contents)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, s.bucketURL(key), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.ContentLength = int64(len(body))
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	s.sign(req, aws.PayloadHash(body), time.Now().UTC())
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return errors.Wrapf(err, "error uploading %s", key)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return errors.Errorf("error uploading %s: %s", key, s3Error(resp))
+	}
+	return nil
+}
+
+type s3Object struct {
+	Key          string    `xml:"Key"`
+	Size         int64     `xml:"Size"`
+	LastModified time.Time `xml:"LastModified"`
+}
+
+type listBucketResult struct {
+	Contents              []s3Object `xml:"Contents"`
+	IsTruncated           bool       `xml:"IsTruncated"`
+	NextContinuationToken string     `xml:"NextContinuationToken"`
+}
+
+func (s *S3Store) Read(ctx context.Context, taskID string, offset int64, size int) ([]byte, error) {
+	return readLog(ctx, s, taskID, offset, size)
+}
+
+func (s *S3Store) TaskParts(ctx context.Context, taskID string, page, size int) (*datastore.Page[*tork.TaskLogPart], error) {
+	return taskParts(ctx, s, taskID, page, size)
+}
+
+func (s *S3Store) JobParts(ctx context.Context, j *tork.Job, page, size int) (*datastore.Page[*tork.TaskLogPart], error) {
+	return jobParts(ctx, s, j, page, size)
+}
+
+func (s *S3Store) listParts(ctx context.Context, taskID string) ([]part, error) {
+	objects, err := s.list(ctx, s.prefix+taskID+"/")
+	if err != nil {
+		return nil, err
+	}
+	parts := make([]part, 0, len(objects))
+	for _, o := range objects {
+		number, err := strconv.Atoi(path.Base(o.Key))
+		if err != nil {
+			continue
+		}
+		parts = append(parts, part{
+			taskID:    taskID,
+			number:    number,
+			key:       o.Key,
+			size:      o.Size,
+			createdAt: o.LastModified.UTC(),
+		})
+	}
+	return parts, nil
+}
+
+func (s *S3Store) readPart(ctx context.Context, p part, first, last int64) ([]byte, error) {
+	return s.get(ctx, p.key, first, last)
+}
+
+// list lists the objects under the prefix in the order of their keys.
+func (s *S3Store) list(ctx context.Context, prefix string) ([]s3Object, error) {
+	objects := make([]s3Object, 0)
+	var token string
+	for {
+		q := url.Values{}
+		q.Set("list-type", "2")
+		q.Set("prefix", prefix)
+		if token != "" {
+			q.Set("continuation-token", token)
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.bucketURL("")+"?"+q.Encode(), nil)
+		if err != nil {
+			return nil, err
+		}
+		s.sign(req, aws.EmptyPayloadHash, time.Now().UTC())
+		resp, err := s.client.Do(req)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error listing %s", prefix)
+		}
+		if resp.StatusCode != http.StatusOK {
+			defer resp.Body.Close()
+			return nil, errors.Errorf("error listing %s: %s", prefix, s3Error(resp))
+		}
+		result := listBucketResult{}
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, errors.Wrapf(err, "error decoding the listing of %s", prefix)
+		}
+		objects = append(objects, result.Contents...)
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return objects, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+// get reads the bytes first through last of the object.
+func (s *S3Store) get(ctx context.Context, key string, first, last int64) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.bucketURL(key), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", first, last))
+	s.sign(req, aws.EmptyPayloadHash, time.Now().UTC())
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error fetching %s", key)
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		return io.ReadAll(resp.Body)
+	case http.StatusOK:
+		// the range was ignored
+		b, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+		if first >= int64(len(b)) {
+			return []byte{}, nil
+		}
+		if last >= int64(len(b)) {
+			last = int64(len(b)) - 1
+		}
+		return b[first : last+1], nil
+	default:
+		return nil, errors.Errorf("error fetching %s: %s", key, s3Error(resp))
+	}
+}
+
+func (s *S3Store) bucketURL(key string) string {
+	if s.pathStyle {
+		return fmt.Sprintf("%s/%s/%s", s.endpoint, s.bucket, aws.URIEncode(key, false))
+	}
+	u, _ := url.Parse(s.endpoint)
+	return fmt.Sprintf("%s://%s.%s/%s", u.Scheme, s.bucket, u.Host, aws.URIEncode(key, false))
+}
+
+func (s *S3Store) sign(req *http.Request, payloadHash string, now time.Time) {
+	aws.Sign(req, aws.Credentials{
+		AccessKey:    s.accessKey,
+		SecretKey:    s.secretKey,
+		SessionToken: s.sessionToken,
+	}, s.region, "s3", payloadHash, now)
+}
+
+// s3Error extracts the error code of an S3 error response.
+func s3Error(resp *http.Response) string {
+	b, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if i := strings.Index(string(b), "<Code>"); i >= 0 {
+		if j := strings.Index(string(b[i:]), "</Code>"); j >= 0 {
+			return fmt.Sprintf("%s (%s)", resp.Status, string(b[i+len("<Code>"):i+j]))
+		}
+	}
+	return resp.Status
+}
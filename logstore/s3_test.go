@@ -0,0 +1,130 @@
+package logstore
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/runabol/tork"
+	"github.com/stretchr/testify/assert"
+)
+
+// newFakeS3 serves the objects of a single bucket, listing
+// at most two of them at a time to exercise the pagination.
+func newFakeS3(t *testing.T, objects map[string]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.True(t, strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=some-key/"))
+		key := strings.TrimPrefix(r.URL.Path, "/some-bucket/")
+		switch {
+		case r.Method == http.MethodPut:
+			b, err := io.ReadAll(r.Body)
+			assert.NoError(t, err)
+			objects[key] = string(b)
+		case r.URL.Query().Get("list-type") == "2":
+			keys := make([]string, 0)
+			for k := range objects {
+				if strings.HasPrefix(k, r.URL.Query().Get("prefix")) && k > r.URL.Query().Get("continuation-token") {
+					keys = append(keys, k)
+				}
+			}
+			sort.Strings(keys)
+			result := listBucketResult{}
+			for i, k := range keys {
+				if i == 2 {
+					result.IsTruncated = true
+					result.NextContinuationToken = keys[i-1]
+					break
+				}
+				result.Contents = append(result.Contents, s3Object{Key: k, Size: int64(len(objects[k]))})
+			}
+			assert.NoError(t, xml.NewEncoder(w).Encode(result))
+		default:
+			o, ok := objects[key]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				_, _ = w.Write([]byte("<Error><Code>NoSuchKey</Code></Error>"))
+				return
+			}
+			var first, last int
+			_, err := fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-%d", &first, &last)
+			assert.NoError(t, err)
+			w.WriteHeader(http.StatusPartialContent)
+			_, _ = w.Write([]byte(o[first : last+1]))
+		}
+	}))
+}
+
+func TestS3AppendRead(t *testing.T) {
+	ctx := context.Background()
+	objects := map[string]string{}
+	srv := newFakeS3(t, objects)
+	defer srv.Close()
+
+	s, err := NewS3Store("some-bucket", WithS3Endpoint(srv.URL), WithS3Credentials("some-key", "some-secret", ""))
+	assert.NoError(t, err)
+	for i, line := range []string{"first\n", "second\n", "third\n", "fourth\n", "fifth\n"} {
+		assert.NoError(t, s.Append(ctx, &tork.TaskLogPart{TaskID: "task-1", Number: i + 1, Contents: line}))
+	}
+	assert.Equal(t, "second\n", objects["logs/task-1/0000000002"])
+	assert.NoError(t, s.Append(ctx, &tork.TaskLogPart{TaskID: "task-2", Number: 1, Contents: "other\n"}))
+
+	b, err := s.Read(ctx, "task-1", 0, 1024)
+	assert.NoError(t, err)
+	assert.Equal(t, "first\nsecond\nthird\nfourth\nfifth\n", string(b))
+	b, err = s.Read(ctx, "task-1", 3, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, "st\nsecond\n", string(b))
+	b, err = s.Read(ctx, "task-1", 100, 10)
+	assert.NoError(t, err)
+	assert.Empty(t, b)
+	b, err = s.Read(ctx, "task-3", 0, 10)
+	assert.NoError(t, err)
+	assert.Empty(t, b)
+	_, err = s.Read(ctx, "../task-1", 0, 10)
+	assert.Error(t, err)
+}
+
+func TestS3Parts(t *testing.T) {
+	ctx := context.Background()
+	objects := map[string]string{}
+	srv := newFakeS3(t, objects)
+	defer srv.Close()
+
+	s, err := NewS3Store("some-bucket", WithS3Endpoint(srv.URL), WithS3Credentials("some-key", "some-secret", ""))
+	assert.NoError(t, err)
+	for i, line := range []string{"first\n", "second\n", "third\n"} {
+		assert.NoError(t, s.Append(ctx, &tork.TaskLogPart{TaskID: "task-1", Number: i + 1, Contents: line}))
+	}
+	assert.NoError(t, s.Append(ctx, &tork.TaskLogPart{TaskID: "task-2", Number: 1, Contents: "other\n"}))
+
+	p, err := s.TaskParts(ctx, "task-1", 1, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, p.TotalItems)
+	assert.Equal(t, 2, p.TotalPages)
+	assert.Len(t, p.Items, 2)
+	assert.Equal(t, 3, p.Items[0].Number)
+	assert.Equal(t, "third\n", p.Items[0].Contents)
+	assert.Equal(t, "second\n", p.Items[1].Contents)
+
+	p, err = s.JobParts(ctx, &tork.Job{Execution: []*tork.Task{{ID: "task-1"}, {ID: "task-2"}}}, 1, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, p.TotalItems)
+	assert.Len(t, p.Items, 2)
+	assert.Equal(t, "other\n", p.Items[0].Contents)
+	assert.Equal(t, "third\n", p.Items[1].Contents)
+}
+
+func TestNewS3StoreNoCredentials(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "")
+	_, err := NewS3Store("some-bucket")
+	assert.Error(t, err)
+	_, err = NewS3Store("", WithS3Credentials("some-key", "some-secret", ""))
+	assert.Error(t, err)
+}
@@ -0,0 +1,42 @@
+package logstore
+
+import (
+	"context"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/runabol/tork"
+	"github.com/runabol/tork/datastore"
+)
+
+const (
+	LOGSTORE_DATASTORE = "datastore"
+	LOGSTORE_FS        = "fs"
+	LOGSTORE_S3        = "s3"
+)
+
+// Store persists the full logs of tasks (in the datastore,
+// on a shared filesystem or in S3), so that they can be read
+// long after the tasks completed.
+type Store interface {
+	// Append adds a part of the log of its task.
+	Append(ctx context.Context, p *tork.TaskLogPart) error
+	// Read reads up to size bytes of the log of the task,
+	// starting at the offset. Nothing is read past the end.
+	Read(ctx context.Context, taskID string, offset int64, size int) ([]byte, error)
+	// TaskParts returns a page of the parts of the
+	// log of the task, the most recent first.
+	TaskParts(ctx context.Context, taskID string, page, size int) (*datastore.Page[*tork.TaskLogPart], error)
+	// JobParts returns a page of the parts of the logs of
+	// the tasks of the job, those of its last tasks first.
+	JobParts(ctx context.Context, j *tork.Job, page, size int) (*datastore.Page[*tork.TaskLogPart], error)
+}
+
+// validateTaskID rejects the task IDs which can't be
+// used as a file name or as a part of an object key.
+func validateTaskID(taskID string) error {
+	if taskID == "" || taskID == "." || taskID == ".." || strings.ContainsAny(taskID, `/\`) {
+		return errors.Errorf("invalid task id: %s", taskID)
+	}
+	return nil
+}
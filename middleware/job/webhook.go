@@ -48,6 +48,20 @@ func callWebhook(wh *tork.Webhook, job *tork.Job) {
 		}
 		wh.Headers[name] = newv
 	}
+	// evaluate secret
+	if wh.Secret != "" {
+		secret, err := eval.EvaluateTemplate(wh.Secret, job.Context.AsMap())
+		if err != nil {
+			// never fall back to an unsigned call
+			log.Error().Err(err).Msgf("[Webhook] error evaluating secret, skipping job webhook %s", wh.URL)
+			return
+		}
+		if secret == "" {
+			log.Error().Msgf("[Webhook] secret evaluated to an empty value, skipping job webhook %s", wh.URL)
+			return
+		}
+		wh.Secret = secret
+	}
 	summary := tork.NewJobSummary(job)
 	if err := webhook.Call(wh, summary); err != nil {
 		log.Error().Err(err).Msgf("[Webhook] error calling job webhook %s", wh.URL)
@@ -192,6 +192,56 @@ func TestWebhookOKWithHeaders(t *testing.T) {
 	<-received
 }
 
+func TestWebhookOKWithSecret(t *testing.T) {
+	hm := ApplyMiddleware(NoOpHandlerFunc, []MiddlewareFunc{Webhook})
+
+	received := make(chan any)
+
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			panic(err)
+		}
+		assert.Equal(t, webhook.Sign("1234-5678", body), r.Header.Get(webhook.SignatureHeader))
+		w.WriteHeader(http.StatusOK)
+		close(received)
+	}))
+
+	j := &tork.Job{
+		ID:    "1234",
+		State: tork.JobStateFailed,
+		Context: tork.JobContext{
+			Secrets: map[string]string{
+				"webhook_secret": "1234-5678",
+			},
+		},
+		Webhooks: []*tork.Webhook{{
+			URL:    svr.URL,
+			Secret: "{{secrets.webhook_secret}}",
+		}},
+	}
+
+	assert.NoError(t, hm(context.Background(), StateChange, j))
+	<-received
+}
+
+func TestWebhookBadSecret(t *testing.T) {
+	calls := 0
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer svr.Close()
+	for _, secret := range []string{"{{ bad( }}", "{{secrets.no_such_key}}"} {
+		j := &tork.Job{
+			ID:    "1234",
+			State: tork.JobStateFailed,
+		}
+		callWebhook(&tork.Webhook{URL: svr.URL, Secret: secret}, j)
+	}
+	assert.Equal(t, 0, calls)
+}
+
 func TestWebhookIgnored(t *testing.T) {
 	hm := ApplyMiddleware(NoOpHandlerFunc, []MiddlewareFunc{Webhook})
 	assert.NoError(t, hm(context.Background(), Read, nil))
@@ -0,0 +1,43 @@
+package task
+
+import (
+	"context"
+
+	"github.com/runabol/tork"
+)
+
+// PreTask returns a middleware which calls fn before the
+// task is handed to the next handler, e.g. to fetch
+// credentials or to set up a workspace. An error returned
+// by fn fails the task without it being executed.
+func PreTask(fn HandlerFunc) MiddlewareFunc {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(ctx context.Context, et EventType, t *tork.Task) error {
+			if et == StateChange {
+				if err := fn(ctx, et, t); err != nil {
+					return err
+				}
+			}
+			return next(ctx, et, t)
+		}
+	}
+}
+
+// PostTask returns a middleware which calls fn once the
+// next handler returns, with the task in its final state.
+// fn is called even when the next handler fails so that
+// it can release whatever a PreTask hook acquired.
+func PostTask(fn HandlerFunc) MiddlewareFunc {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(ctx context.Context, et EventType, t *tork.Task) error {
+			err := next(ctx, et, t)
+			if et != StateChange {
+				return err
+			}
+			if herr := fn(ctx, et, t); herr != nil && err == nil {
+				return herr
+			}
+			return err
+		}
+	}
+}
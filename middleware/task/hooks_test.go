@@ -0,0 +1,61 @@
+package task
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/runabol/tork"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrePostTask(t *testing.T) {
+	calls := make([]string, 0)
+	h := func(ctx context.Context, et EventType, tk *tork.Task) error {
+		calls = append(calls, "run")
+		tk.State = tork.TaskStateCompleted
+		return nil
+	}
+	pre := PreTask(func(ctx context.Context, et EventType, tk *tork.Task) error {
+		calls = append(calls, "pre")
+		return nil
+	})
+	post := PostTask(func(ctx context.Context, et EventType, tk *tork.Task) error {
+		assert.Equal(t, tork.TaskStateCompleted, tk.State)
+		calls = append(calls, "post")
+		return nil
+	})
+	hm := ApplyMiddleware(h, []MiddlewareFunc{pre, post})
+	assert.NoError(t, hm(context.Background(), StateChange, &tork.Task{}))
+	assert.Equal(t, []string{"pre", "run", "post"}, calls)
+}
+
+func TestPreTaskError(t *testing.T) {
+	h := func(ctx context.Context, et EventType, tk *tork.Task) error {
+		t.Fatal("should not run the task")
+		return nil
+	}
+	pre := PreTask(func(ctx context.Context, et EventType, tk *tork.Task) error {
+		return errors.New("no credentials")
+	})
+	hm := ApplyMiddleware(h, []MiddlewareFunc{pre})
+	assert.EqualError(t, hm(context.Background(), StateChange, &tork.Task{}), "no credentials")
+}
+
+func TestPostTaskError(t *testing.T) {
+	called := false
+	h := func(ctx context.Context, et EventType, tk *tork.Task) error {
+		return errors.New("task failed")
+	}
+	post := PostTask(func(ctx context.Context, et EventType, tk *tork.Task) error {
+		called = true
+		return errors.New("cleanup failed")
+	})
+	hm := ApplyMiddleware(h, []MiddlewareFunc{post})
+	// the task's error takes precedence
+	assert.EqualError(t, hm(context.Background(), StateChange, &tork.Task{}), "task failed")
+	assert.True(t, called)
+
+	hm = ApplyMiddleware(NoOpHandlerFunc, []MiddlewareFunc{post})
+	assert.EqualError(t, hm(context.Background(), StateChange, &tork.Task{}), "cleanup failed")
+}
@@ -9,6 +9,7 @@ import (
 
 func Redact(redacter *redact.Redacter) MiddlewareFunc {
 	return func(next HandlerFunc) HandlerFunc {
+		next = RedactResult(redacter)(next)
 		return func(ctx context.Context, et EventType, t *tork.Task) error {
 			if et == Read {
 				redacter.RedactTask(t)
@@ -17,3 +18,16 @@ func Redact(redacter *redact.Redacter) MiddlewareFunc {
 		}
 	}
 }
+
+// RedactResult masks the secret values in the results of
+// the tasks reported by the workers before they're stored.
+func RedactResult(redacter *redact.Redacter) MiddlewareFunc {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(ctx context.Context, et EventType, t *tork.Task) error {
+			if et == StateChange && (t.State == tork.TaskStateCompleted || t.State == tork.TaskStateFailed) {
+				redacter.RedactTaskResult(t)
+			}
+			return next(ctx, et, t)
+		}
+	}
+}
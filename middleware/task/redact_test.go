@@ -48,3 +48,49 @@ func TestNoRedact(t *testing.T) {
 	assert.NoError(t, hm(context.Background(), StateChange, t1))
 	assert.Equal(t, "1234", t1.Env["secret"])
 }
+
+func TestRedactOnCompleted(t *testing.T) {
+	ds := inmemory.NewInMemoryDatastore()
+	ctx := context.Background()
+	j1 := tork.Job{
+		ID: uuid.NewUUID(),
+		Secrets: map[string]string{
+			"hush": "shhhhh",
+		},
+	}
+	err := ds.CreateJob(ctx, &j1)
+	assert.NoError(t, err)
+	hm := ApplyMiddleware(NoOpHandlerFunc, []MiddlewareFunc{Redact(redact.NewRedacter(ds))})
+	t1 := &tork.Task{
+		JobID:  j1.ID,
+		State:  tork.TaskStateCompleted,
+		Result: "the secret is shhhhh",
+	}
+	assert.NoError(t, hm(context.Background(), StateChange, t1))
+	assert.Equal(t, "the secret is [REDACTED]", t1.Result)
+}
+
+func TestRedactResult(t *testing.T) {
+	ds := inmemory.NewInMemoryDatastore()
+	ctx := context.Background()
+	j1 := tork.Job{
+		ID:      uuid.NewUUID(),
+		Secrets: map[string]string{"hush": "shhhhh"},
+	}
+	err := ds.CreateJob(ctx, &j1)
+	assert.NoError(t, err)
+	hm := ApplyMiddleware(NoOpHandlerFunc, []MiddlewareFunc{RedactResult(redact.NewRedacter(ds))})
+	t1 := &tork.Task{
+		JobID:  j1.ID,
+		State:  tork.TaskStateCompleted,
+		Result: "shhhhh",
+		Env: map[string]string{
+			"secret": "1234",
+		},
+	}
+	assert.NoError(t, hm(context.Background(), StateChange, t1))
+	assert.Equal(t, "[REDACTED]", t1.Result)
+	// reads are left alone
+	assert.NoError(t, hm(context.Background(), Read, t1))
+	assert.Equal(t, "1234", t1.Env["secret"])
+}
@@ -70,6 +70,20 @@ func callWebhook(wh *tork.Webhook, job *tork.Job, summary *tork.TaskSummary) {
 		}
 		wh.Headers[name] = newv
 	}
+	// evaluate secret
+	if wh.Secret != "" {
+		secret, err := eval.EvaluateTemplate(wh.Secret, job.Context.AsMap())
+		if err != nil {
+			// never fall back to an unsigned call
+			log.Error().Err(err).Msgf("[Webhook] error evaluating secret, skipping task webhook %s", wh.URL)
+			return
+		}
+		if secret == "" {
+			log.Error().Msgf("[Webhook] secret evaluated to an empty value, skipping task webhook %s", wh.URL)
+			return
+		}
+		wh.Secret = secret
+	}
 	if err := webhook.Call(wh, summary); err != nil {
 		log.Error().Err(err).Msgf("[Webhook] error calling task webhook %s", wh.URL)
 	}
@@ -2,15 +2,26 @@ package mq
 
 import (
 	"context"
+	"time"
 
 	"github.com/runabol/tork"
 )
 
 type Provider func() (Broker, error)
 
+// the longest delay between two attempts
+// to re-establish a lost subscription
+const maxReconnectBackoff = time.Second * 30
+
 const (
 	BROKER_INMEMORY     = "inmemory"
 	BROKER_RABBITMQ     = "rabbitmq"
+	BROKER_POSTGRES     = "postgres"
+	BROKER_PUBSUB       = "pubsub"
+	BROKER_NATS         = "nats"
+	BROKER_KAFKA        = "kafka"
+	BROKER_REDIS        = "redis"
+	BROKER_SQS          = "sqs"
 	TOPIC_JOB           = "job.*"
 	TOPIC_JOB_COMPLETED = "job.completed"
 	TOPIC_JOB_FAILED    = "job.failed"
@@ -20,6 +31,7 @@ const (
 type Broker interface {
 	PublishTask(ctx context.Context, qname string, t *tork.Task) error
 	SubscribeForTasks(qname string, handler func(t *tork.Task) error) error
+	SubscribeForDeadLetters(handler func(dl *tork.DeadLetter) error) error
 
 	PublishTaskProgress(ctx context.Context, t *tork.Task) error
 	SubscribeForTaskProgress(handler func(t *tork.Task) error) error
@@ -40,3 +52,26 @@ type Broker interface {
 	HealthCheck(ctx context.Context) error
 	Shutdown(ctx context.Context) error
 }
+
+// TaskUnsubscriber is implemented by the brokers whose subscriptions
+// to a queue can be stopped without shutting the broker down, e.g. by
+// a worker which is draining.
+type TaskUnsubscriber interface {
+	// UnsubscribeForTasks stops the subscriptions of the broker to the
+	// queue from receiving more tasks. The tasks which are being handled
+	// are left to finish.
+	UnsubscribeForTasks(qname string) error
+}
+
+// reconnectBackoff returns how long to wait before the given
+// attempt to re-establish a lost subscription. The delay doubles
+// with every attempt, up to maxReconnectBackoff.
+func reconnectBackoff(attempt int) time.Duration {
+	if attempt < 1 {
+		attempt = 1
+	}
+	if attempt > 6 {
+		return maxReconnectBackoff
+	}
+	return min(time.Second*time.Duration(1<<(attempt-1)), maxReconnectBackoff)
+}
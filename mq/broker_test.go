@@ -0,0 +1,17 @@
+package mq
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_reconnectBackoff(t *testing.T) {
+	assert.Equal(t, time.Second, reconnectBackoff(0))
+	assert.Equal(t, time.Second, reconnectBackoff(1))
+	assert.Equal(t, time.Second*2, reconnectBackoff(2))
+	assert.Equal(t, time.Second*16, reconnectBackoff(5))
+	assert.Equal(t, maxReconnectBackoff, reconnectBackoff(6))
+	assert.Equal(t, maxReconnectBackoff, reconnectBackoff(100))
+}
@@ -0,0 +1,157 @@
+package mq
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+const (
+	CODEC_JSON    = "json"
+	CODEC_MSGPACK = "msgpack"
+	// CODEC_GZIP_PREFIX marks a codec whose
+	// output is compressed with gzip
+	CODEC_GZIP_PREFIX = "gzip+"
+)
+
+// Codec encodes the payload of the messages published
+// through the broker. The codec's name is recorded in the
+// message's envelope so consumers can decode it.
+type Codec interface {
+	Name() string
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+}
+
+var (
+	JSONCodec    Codec = jsonCodec{}
+	MsgpackCodec Codec = msgpackCodec{}
+)
+
+var codecs = map[string]Codec{
+	CODEC_JSON:    JSONCodec,
+	CODEC_MSGPACK: MsgpackCodec,
+}
+
+var codecsMu sync.RWMutex
+
+// RegisterCodec makes a codec available to consumers by
+// its name. Publishers and consumers must both register
+// custom codecs (e.g. Protobuf).
+func RegisterCodec(c Codec) {
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+	codecs[c.Name()] = c
+}
+
+// CodecByName returns the registered codec with the given
+// name. Names prefixed with gzip+ return the gzip-compressed
+// variant of the codec (e.g. gzip+msgpack).
+func CodecByName(name string) (Codec, error) {
+	if inner, ok := strings.CutPrefix(name, CODEC_GZIP_PREFIX); ok {
+		c, err := CodecByName(inner)
+		if err != nil {
+			return nil, err
+		}
+		return Gzip(c), nil
+	}
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+	c, ok := codecs[name]
+	if !ok {
+		return nil, errors.Errorf("unknown codec: %s", name)
+	}
+	return c, nil
+}
+
+// Gzip returns a codec compressing the output of the given codec.
+func Gzip(c Codec) Codec {
+	return gzipCodec{inner: c}
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string {
+	return CODEC_JSON
+}
+
+func (jsonCodec) Marshal(v any) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v any) error {
+	return json.Unmarshal(data, v)
+}
+
+// msgpackCodec encodes the messages in MessagePack. The fields
+// are named after their JSON tags, so the fields which are left
+// out of the JSON (e.g. the password hash of a user) are left out
+// of the messages too.
+type msgpackCodec struct{}
+
+func (msgpackCodec) Name() string {
+	return CODEC_MSGPACK
+}
+
+func (msgpackCodec) Marshal(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := msgpack.GetEncoder()
+	defer msgpack.PutEncoder(enc)
+	enc.Reset(&buf)
+	enc.SetCustomStructTag("json")
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (msgpackCodec) Unmarshal(data []byte, v any) error {
+	dec := msgpack.GetDecoder()
+	defer msgpack.PutDecoder(dec)
+	dec.Reset(bytes.NewReader(data))
+	dec.SetCustomStructTag("json")
+	return dec.Decode(v)
+}
+
+type gzipCodec struct {
+	inner Codec
+}
+
+func (c gzipCodec) Name() string {
+	return CODEC_GZIP_PREFIX + c.inner.Name()
+}
+
+func (c gzipCodec) Marshal(v any) ([]byte, error) {
+	data, err := c.inner.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(data); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (c gzipCodec) Unmarshal(data []byte, v any) error {
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+	raw, err := io.ReadAll(zr)
+	if err != nil {
+		return err
+	}
+	return c.inner.Unmarshal(raw, v)
+}
@@ -0,0 +1,123 @@
+package mq
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/runabol/tork"
+	"github.com/runabol/tork/internal/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCodecByName(t *testing.T) {
+	for _, name := range []string{"json", "msgpack", "gzip+json", "gzip+msgpack"} {
+		c, err := CodecByName(name)
+		assert.NoError(t, err)
+		assert.Equal(t, name, c.Name())
+	}
+	_, err := CodecByName("protobuf")
+	assert.Error(t, err)
+	_, err = CodecByName("gzip+protobuf")
+	assert.Error(t, err)
+}
+
+func TestCodecRoundTrip(t *testing.T) {
+	t1 := &tork.Task{
+		ID:    uuid.NewUUID(),
+		Name:  "some task",
+		Env:   map[string]string{"VAR": "value"},
+		Run:   "echo hello",
+		Retry: &tork.TaskRetry{Limit: 2},
+	}
+	for _, name := range []string{"json", "msgpack", "gzip+json", "gzip+msgpack"} {
+		c, err := CodecByName(name)
+		assert.NoError(t, err)
+		body, md, err := seal(context.Background(), c, t1)
+		assert.NoError(t, err)
+		peeked, err := PeekMetadata(body)
+		assert.NoError(t, err)
+		assert.Equal(t, md.ID, peeked.ID)
+		msg, md2, err := open("", body)
+		assert.NoError(t, err, name)
+		assert.Equal(t, md.ID, md2.ID)
+		t2, ok := msg.(*tork.Task)
+		assert.True(t, ok)
+		assert.Equal(t, t1.ID, t2.ID)
+		assert.Equal(t, t1.Env, t2.Env)
+		assert.Equal(t, 2, t2.Retry.Limit)
+	}
+}
+
+func TestCodecOmitsHiddenFields(t *testing.T) {
+	j1 := &tork.Job{
+		ID: uuid.NewUUID(),
+		CreatedBy: &tork.User{
+			ID:           uuid.NewUUID(),
+			Username:     "someuser",
+			PasswordHash: "some-hash",
+		},
+		IdempotencyHash: "some-idempotency-hash",
+		Tasks: []*tork.Task{{
+			Name:     "some task",
+			Internal: true,
+			Mounts:   []tork.Mount{{ID: "some-mount", Type: tork.MountTypeVolume, Target: "/data"}},
+		}},
+	}
+	for _, name := range []string{"json", "msgpack", "gzip+json", "gzip+msgpack"} {
+		c, err := CodecByName(name)
+		assert.NoError(t, err)
+		body, _, err := seal(context.Background(), c, j1)
+		assert.NoError(t, err)
+		msg, _, err := open("", body)
+		assert.NoError(t, err, name)
+		j2, ok := msg.(*tork.Job)
+		assert.True(t, ok)
+		assert.Equal(t, j1.CreatedBy.ID, j2.CreatedBy.ID, name)
+		assert.Equal(t, "someuser", j2.CreatedBy.Username, name)
+		assert.Empty(t, j2.CreatedBy.PasswordHash, name)
+		assert.Empty(t, j2.IdempotencyHash, name)
+		assert.Equal(t, "some task", j2.Tasks[0].Name, name)
+		assert.False(t, j2.Tasks[0].Internal, name)
+		assert.Equal(t, "/data", j2.Tasks[0].Mounts[0].Target, name)
+		assert.Empty(t, j2.Tasks[0].Mounts[0].ID, name)
+	}
+}
+
+func TestGzipCodecSize(t *testing.T) {
+	t1 := &tork.Task{
+		ID:  uuid.NewUUID(),
+		Run: strings.Repeat("echo hello world\n", 1000),
+	}
+	plain, _, err := seal(context.Background(), JSONCodec, t1)
+	assert.NoError(t, err)
+	compressed, _, err := seal(context.Background(), Gzip(MsgpackCodec), t1)
+	assert.NoError(t, err)
+	assert.Less(t, len(compressed)*10, len(plain))
+}
+
+func TestPubSubCodec(t *testing.T) {
+	srv := newFakePubSub()
+	defer srv.Close()
+	b, err := NewPubSubBroker("test-project",
+		WithPubSubEndpoint(srv.URL),
+		WithTokenSource(nil),
+		WithPubSubCodec(Gzip(MsgpackCodec)),
+	)
+	assert.NoError(t, err)
+	defer func() {
+		assert.NoError(t, b.Shutdown(context.Background()))
+	}()
+	qname := fmt.Sprintf("%stest-%s", QUEUE_EXCLUSIVE_PREFIX, uuid.NewUUID())
+	processed := make(chan *tork.Task)
+	err = b.SubscribeForTasks(qname, func(t *tork.Task) error {
+		processed <- t
+		return nil
+	})
+	assert.NoError(t, err)
+	t1 := &tork.Task{ID: uuid.NewUUID()}
+	assert.NoError(t, b.PublishTask(context.Background(), qname, t1))
+	t2 := <-processed
+	assert.Equal(t, t1.ID, t2.ID)
+}
@@ -0,0 +1,79 @@
+package mq
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// ConnectionConfig holds the settings used to connect to the
+// broker. It is understood by all the broker implementations,
+// each mapping it onto its own connection mechanism.
+type ConnectionConfig struct {
+	// URL overrides the address the broker was created with
+	URL string
+	// CACert is the path to the PEM-encoded CA certificate
+	// used to verify the broker's certificate
+	CACert string
+	// Cert and Key are the paths to the PEM-encoded client
+	// certificate and key presented to the broker
+	Cert string
+	Key  string
+	// InsecureSkipVerify disables the verification of
+	// the broker's certificate. Meant for development only
+	InsecureSkipVerify bool
+	Username           string
+	Password           string
+	// Token is used in place of the password by brokers
+	// which support token authentication
+	Token string
+}
+
+// HasTLS reports whether any TLS setting is configured.
+func (c ConnectionConfig) HasTLS() bool {
+	return c.CACert != "" || c.Cert != "" || c.Key != "" || c.InsecureSkipVerify
+}
+
+// TLSConfig returns the TLS configuration of the connection,
+// or nil when no TLS setting is configured.
+func (c ConnectionConfig) TLSConfig() (*tls.Config, error) {
+	if !c.HasTLS() {
+		return nil, nil
+	}
+	cfg := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: c.InsecureSkipVerify, // #nosec G402 -- opt-in
+	}
+	if c.CACert != "" {
+		pem, err := os.ReadFile(c.CACert)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error reading CA certificate")
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.Errorf("no certificate found in %s", c.CACert)
+		}
+		cfg.RootCAs = pool
+	}
+	if c.Cert != "" || c.Key != "" {
+		if c.Cert == "" || c.Key == "" {
+			return nil, errors.New("both the client certificate and key are required")
+		}
+		cert, err := tls.LoadX509KeyPair(c.Cert, c.Key)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error loading client certificate")
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
+
+// secret returns the token when set, the password otherwise.
+func (c ConnectionConfig) secret() string {
+	if c.Token != "" {
+		return c.Token
+	}
+	return c.Password
+}
@@ -0,0 +1,74 @@
+package mq
+
+import (
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/runabol/tork"
+	"github.com/runabol/tork/internal/uuid"
+)
+
+// DEFAULT_MAX_DELIVERIES is the number of times a task message is
+// delivered before it's routed to the dead-letter queue.
+const DEFAULT_MAX_DELIVERIES = 3
+
+// deliverTask hands the task consumed from the queue over to the
+// handler. When the handler fails, the task is redelivered until
+// it was delivered maxDeliveries times, after which it's routed to
+// the dead-letter queue along with the reason of the last failure.
+func deliverTask(
+	qname string,
+	t *tork.Task,
+	attempt, maxDeliveries int,
+	handler func(t *tork.Task) error,
+	redeliver func(t *tork.Task, attempt int) error,
+	deadLetter func(dl *tork.DeadLetter) error,
+) error {
+	if maxDeliveries < 1 {
+		maxDeliveries = DEFAULT_MAX_DELIVERIES
+	}
+	err := handler(t.Clone())
+	if err == nil {
+		return nil
+	}
+	if attempt < maxDeliveries {
+		log.Debug().Msgf("redelivering task %s on %s (attempt %d)", t.ID, qname, attempt+1)
+		if rerr := redeliver(t, attempt+1); rerr != nil {
+			log.Error().Err(rerr).Msgf("error redelivering task %s", t.ID)
+		}
+		return err
+	}
+	now := time.Now().UTC()
+	dl := &tork.DeadLetter{
+		ID:        uuid.NewUUID(),
+		Queue:     qname,
+		Task:      t,
+		Error:     err.Error(),
+		Attempts:  attempt,
+		CreatedAt: &now,
+	}
+	log.Debug().Msgf("routing task %s to the %s queue", t.ID, QUEUE_DEAD_LETTER)
+	if dlerr := deadLetter(dl); dlerr != nil {
+		log.Error().Err(dlerr).Msgf("error dead-lettering task %s", t.ID)
+	}
+	return err
+}
+
+// asDeadLetter converts a message consumed from the dead-letter
+// queue. Tasks dead-lettered by older versions, which recorded
+// the failure on the task itself, are wrapped as well.
+func asDeadLetter(msg any) (*tork.DeadLetter, bool) {
+	switch m := msg.(type) {
+	case *tork.DeadLetter:
+		return m, true
+	case *tork.Task:
+		return &tork.DeadLetter{
+			ID:       uuid.NewUUID(),
+			Queue:    m.Queue,
+			Task:     m,
+			Error:    m.Error,
+			Attempts: 1,
+		}, true
+	}
+	return nil, false
+}
@@ -0,0 +1,207 @@
+package mq
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/runabol/tork"
+	"github.com/runabol/tork/internal/uuid"
+)
+
+// ENVELOPE_VERSION is the schema version of the
+// envelopes published by this version of Tork.
+const ENVELOPE_VERSION = 1
+
+// envelopes of payloads which are not encoded as JSON are
+// framed as: the marker byte, the length of the metadata
+// (4 bytes, big endian), the metadata (JSON) and the payload.
+const frameMarker = byte(0)
+
+// Metadata describes a message published through the broker.
+// It travels in the message's envelope, next to the payload,
+// so it can be inspected without unmarshaling the payload.
+type Metadata struct {
+	SchemaVersion int    `json:"schemaVersion"`
+	ID            string `json:"id"`
+	Type          string `json:"type"`
+	// Codec is the name of the codec the
+	// payload is encoded with. Default: json
+	Codec string `json:"codec,omitempty"`
+	// Attempt is the delivery attempt of the message,
+	// starting at 1. Brokers which track redeliveries
+	// update it upon receipt.
+	Attempt int    `json:"attempt"`
+	TraceID string `json:"traceId,omitempty"`
+	// Tenant is the ID of the user who submitted the job
+	// the message belongs to, and SubmittedBy their username.
+	Tenant      string     `json:"tenant,omitempty"`
+	SubmittedBy string     `json:"submittedBy,omitempty"`
+	CreatedAt   *time.Time `json:"createdAt,omitempty"`
+}
+
+// JobMetadata returns the metadata of the messages of
+// the job (e.g. its tasks), identifying who submitted it.
+func JobMetadata(j *tork.Job) Metadata {
+	md := Metadata{}
+	if j.CreatedBy != nil {
+		md.Tenant = j.CreatedBy.ID
+		md.SubmittedBy = j.CreatedBy.Username
+	}
+	return md
+}
+
+// Envelope wraps the payload of a message with its metadata.
+type Envelope struct {
+	Metadata
+	Payload json.RawMessage `json:"payload"`
+}
+
+type metadataKey struct{}
+
+// WithMetadata returns a context carrying metadata to be included
+// in the envelope of the messages published with it. Empty fields
+// are derived from the message where possible.
+func WithMetadata(ctx context.Context, md Metadata) context.Context {
+	return context.WithValue(ctx, metadataKey{}, md)
+}
+
+// MetadataFromContext returns the metadata set by WithMetadata.
+func MetadataFromContext(ctx context.Context) (Metadata, bool) {
+	md, ok := ctx.Value(metadataKey{}).(Metadata)
+	return md, ok
+}
+
+// PeekMetadata reads the metadata of an envelope
+// without unmarshaling its payload.
+func PeekMetadata(body []byte) (Metadata, error) {
+	md := Metadata{}
+	if mdata, _, ok := unframe(body); ok {
+		body = mdata
+	}
+	if err := json.Unmarshal(body, &md); err != nil {
+		return md, errors.Wrapf(err, "invalid envelope")
+	}
+	if md.SchemaVersion == 0 {
+		return md, errors.New("not an envelope")
+	}
+	return md, nil
+}
+
+// seal serializes the message with the codec
+// and wraps it in an envelope.
+func seal(ctx context.Context, c Codec, msg any) ([]byte, Metadata, error) {
+	payload, err := serialize(c, msg)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	md, _ := MetadataFromContext(ctx)
+	md.SchemaVersion = ENVELOPE_VERSION
+	md.Type = fmt.Sprintf("%T", msg)
+	md.Codec = ""
+	if c.Name() != CODEC_JSON {
+		md.Codec = c.Name()
+	}
+	if md.ID == "" {
+		md.ID = uuid.NewUUID()
+	}
+	if md.Attempt == 0 {
+		md.Attempt = 1
+	}
+	if md.CreatedAt == nil {
+		now := time.Now().UTC()
+		md.CreatedAt = &now
+	}
+	switch m := msg.(type) {
+	case *tork.Task:
+		if md.TraceID == "" {
+			md.TraceID = traceID(m.Trace)
+		}
+	case *tork.Job:
+		if md.TraceID == "" {
+			md.TraceID = traceID(m.Trace)
+		}
+		jmd := JobMetadata(m)
+		if md.Tenant == "" {
+			md.Tenant = jmd.Tenant
+		}
+		if md.SubmittedBy == "" {
+			md.SubmittedBy = jmd.SubmittedBy
+		}
+	}
+	if md.Codec != "" {
+		mdata, err := json.Marshal(md)
+		if err != nil {
+			return nil, Metadata{}, errors.Wrapf(err, "unable to serialize the envelope")
+		}
+		body := make([]byte, 0, 5+len(mdata)+len(payload))
+		body = append(body, frameMarker)
+		body = binary.BigEndian.AppendUint32(body, uint32(len(mdata)))
+		body = append(body, mdata...)
+		return append(body, payload...), md, nil
+	}
+	body, err := json.Marshal(Envelope{Metadata: md, Payload: payload})
+	if err != nil {
+		return nil, Metadata{}, errors.Wrapf(err, "unable to serialize the envelope")
+	}
+	return body, md, nil
+}
+
+// open unwraps the message from its envelope. Messages published
+// before envelopes were introduced are deserialized as-is, using
+// the type carried by the broker.
+func open(tname string, body []byte) (any, Metadata, error) {
+	if mdata, payload, ok := unframe(body); ok {
+		md := Metadata{}
+		if err := json.Unmarshal(mdata, &md); err != nil {
+			return nil, md, errors.Wrapf(err, "invalid envelope")
+		}
+		return openPayload(md, payload)
+	}
+	env := Envelope{}
+	if err := json.Unmarshal(body, &env); err != nil || env.SchemaVersion == 0 {
+		msg, err := deserialize(JSONCodec, tname, body)
+		return msg, Metadata{Type: tname, Attempt: 1}, err
+	}
+	return openPayload(env.Metadata, env.Payload)
+}
+
+func openPayload(md Metadata, payload []byte) (any, Metadata, error) {
+	if md.SchemaVersion > ENVELOPE_VERSION {
+		return nil, md, errors.Errorf("unsupported envelope version: %d", md.SchemaVersion)
+	}
+	c := JSONCodec
+	if md.Codec != "" {
+		var err error
+		if c, err = CodecByName(md.Codec); err != nil {
+			return nil, md, err
+		}
+	}
+	msg, err := deserialize(c, md.Type, payload)
+	return msg, md, err
+}
+
+// unframe splits a framed envelope into its metadata and payload.
+func unframe(body []byte) ([]byte, []byte, bool) {
+	if len(body) < 5 || body[0] != frameMarker {
+		return nil, nil, false
+	}
+	n := binary.BigEndian.Uint32(body[1:5])
+	if uint64(n) > uint64(len(body)-5) {
+		return nil, nil, false
+	}
+	return body[5 : 5+n], body[5+n:], true
+}
+
+// traceID extracts the trace ID from a W3C trace context.
+func traceID(trace map[string]string) string {
+	parts := strings.Split(trace["traceparent"], "-")
+	if len(parts) != 4 {
+		return ""
+	}
+	return parts[1]
+}
@@ -0,0 +1,100 @@
+package mq
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/runabol/tork"
+	"github.com/runabol/tork/internal/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSealAndOpen(t *testing.T) {
+	t1 := &tork.Task{
+		ID: uuid.NewUUID(),
+		Trace: map[string]string{
+			"traceparent": "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		},
+	}
+	ctx := WithMetadata(context.Background(), Metadata{Tenant: "acme"})
+	body, md, err := seal(ctx, JSONCodec, t1)
+	assert.NoError(t, err)
+	assert.Equal(t, ENVELOPE_VERSION, md.SchemaVersion)
+	assert.Equal(t, "*tork.Task", md.Type)
+	assert.Equal(t, 1, md.Attempt)
+	assert.NotEmpty(t, md.ID)
+	assert.NotNil(t, md.CreatedAt)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", md.TraceID)
+	assert.Equal(t, "acme", md.Tenant)
+
+	msg, md2, err := open("", body)
+	assert.NoError(t, err)
+	assert.Equal(t, md.ID, md2.ID)
+	assert.Equal(t, "acme", md2.Tenant)
+	t2, ok := msg.(*tork.Task)
+	assert.True(t, ok)
+	assert.Equal(t, t1.ID, t2.ID)
+}
+
+func TestSealJobSubmittedBy(t *testing.T) {
+	_, md, err := seal(context.Background(), JSONCodec, &tork.Job{
+		ID:        uuid.NewUUID(),
+		CreatedBy: &tork.User{ID: "1234", Username: "someuser"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "someuser", md.SubmittedBy)
+	assert.Equal(t, "1234", md.Tenant)
+}
+
+func TestOpenLegacyMessage(t *testing.T) {
+	body, err := json.Marshal(&tork.Node{ID: "1234", Version: "1.0.0"})
+	assert.NoError(t, err)
+	msg, md, err := open("*tork.Node", body)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, md.Attempt)
+	n, ok := msg.(*tork.Node)
+	assert.True(t, ok)
+	assert.Equal(t, "1234", n.ID)
+}
+
+func TestOpenUnsupportedVersion(t *testing.T) {
+	body, err := json.Marshal(Envelope{
+		Metadata: Metadata{SchemaVersion: ENVELOPE_VERSION + 1, Type: "*tork.Task"},
+		Payload:  json.RawMessage(`{}`),
+	})
+	assert.NoError(t, err)
+	_, _, err = open("*tork.Task", body)
+	assert.Error(t, err)
+}
+
+func TestPeekMetadata(t *testing.T) {
+	body, md, err := seal(context.Background(), JSONCodec, &tork.Task{ID: uuid.NewUUID()})
+	assert.NoError(t, err)
+	peeked, err := PeekMetadata(body)
+	assert.NoError(t, err)
+	assert.Equal(t, md.ID, peeked.ID)
+	assert.Equal(t, "*tork.Task", peeked.Type)
+
+	_, err = PeekMetadata([]byte(`{"id":"1234"}`))
+	assert.Error(t, err)
+}
+
+func Test_rabbitAttempt(t *testing.T) {
+	md := Metadata{Attempt: 1}
+	assert.Equal(t, 1, rabbitAttempt(md, amqp.Delivery{}))
+	assert.Equal(t, 2, rabbitAttempt(md, amqp.Delivery{Redelivered: true}))
+	assert.Equal(t, 4, rabbitAttempt(md, amqp.Delivery{
+		Redelivered: true,
+		Headers:     amqp.Table{"x-delivery-count": int64(3)},
+	}))
+}
+
+func Test_rabbitHeaders(t *testing.T) {
+	assert.Nil(t, rabbitHeaders(Metadata{}))
+	assert.Equal(t, amqp.Table{
+		"x-tork-tenant":       "1234",
+		"x-tork-submitted-by": "someuser",
+	}, rabbitHeaders(JobMetadata(&tork.Job{CreatedBy: &tork.User{ID: "1234", Username: "someuser"}})))
+}
@@ -14,15 +14,37 @@ import (
 	"github.com/runabol/tork/internal/wildcard"
 )
 
-const defaultQueueSize = 1000
+const (
+	defaultQueueSize = 1000
+	// one lane per task priority level (0-9)
+	priorityLanes = 10
+)
 
 // InMemoryBroker a very simple implementation of the Broker interface
 // which uses in-memory channels to exchange messages. Meant for local
 // development, tests etc.
 type InMemoryBroker struct {
-	queues    *syncx.Map[string, *queue]
-	topics    *syncx.Map[string, *topic]
-	terminate *atomic.Bool
+	queues        *syncx.Map[string, *queue]
+	topics        *syncx.Map[string, *topic]
+	terminate     *atomic.Bool
+	maxDeliveries int
+}
+
+type InMemoryOption = func(b *InMemoryBroker)
+
+// WithInMemoryMaxDeliveries sets the number of times a task message
+// is delivered before it's dead-lettered. Default: DEFAULT_MAX_DELIVERIES
+func WithInMemoryMaxDeliveries(n int) InMemoryOption {
+	return func(b *InMemoryBroker) {
+		b.maxDeliveries = n
+	}
+}
+
+// redelivery is a task message which is
+// delivered again after its handler failed
+type redelivery struct {
+	task    *tork.Task
+	attempt int
 }
 type topic struct {
 	name       string
@@ -74,34 +96,72 @@ func (t *topic) close() {
 	<-t.terminated
 }
 
+// queue delivers messages in priority order. Each message
+// is placed in the lane of its priority followed by a token
+// on the ready channel, so a subscriber that takes a token
+// is guaranteed to find a message in one of the lanes.
 type queue struct {
 	name    string
-	ch      chan any
+	lanes   [priorityLanes]chan any
+	ready   chan struct{}
 	subs    []*qsub
 	unacked int32
 	mu      sync.Mutex
 }
 
 func newQueue(name string) *queue {
-	return &queue{
+	q := &queue{
 		name:    name,
-		ch:      make(chan any, defaultQueueSize),
+		ready:   make(chan struct{}, defaultQueueSize),
 		subs:    make([]*qsub, 0),
 		unacked: 0,
 	}
+	for i := range q.lanes {
+		q.lanes[i] = make(chan any, defaultQueueSize)
+	}
+	return q
 }
 
 type qsub struct {
-	terminate  chan any
-	terminated chan any
+	terminate    chan any
+	terminated   chan any
+	unsubscribed chan any
 }
 
 func (q *queue) send(m any) {
-	q.ch <- m
+	q.lanes[priority(m)] <- m
+	q.ready <- struct{}{}
+}
+
+// receive returns the highest priority message.
+// It must only be called after taking a ready token.
+func (q *queue) receive() any {
+	for {
+		for i := len(q.lanes) - 1; i >= 0; i-- {
+			select {
+			case m := <-q.lanes[i]:
+				return m
+			default:
+			}
+		}
+	}
 }
 
 func (q *queue) size() int {
-	return len(q.ch)
+	return len(q.ready)
+}
+
+func priority(m any) int {
+	var t *tork.Task
+	switch v := m.(type) {
+	case *tork.Task:
+		t = v
+	case redelivery:
+		t = v.task
+	default:
+		return 0
+	}
+	return min(max(t.Priority, 0), priorityLanes-1)
 }
 
 func (q *queue) close() {
@@ -115,13 +175,26 @@ func (q *queue) close() {
 	}
 }
 
+// unsubscribe stops the subscribers of the queue. The
+// messages they are handling are left to finish.
+func (q *queue) unsubscribe() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, sub := range q.subs {
+		close(sub.unsubscribed)
+	}
+	q.subs = make([]*qsub, 0)
+}
+
 func (q *queue) subscribe(sub func(m any) error) {
 	terminate := make(chan any)
 	terminated := make(chan any)
+	unsubscribed := make(chan any)
 	q.mu.Lock()
 	q.subs = append(q.subs, &qsub{
-		terminate:  terminate,
-		terminated: terminated,
+		terminate:    terminate,
+		terminated:   terminated,
+		unsubscribed: unsubscribed,
 	})
 	q.mu.Unlock()
 	go func() {
@@ -130,7 +203,17 @@ func (q *queue) subscribe(sub func(m any) error) {
 			case <-terminate:
 				close(terminated)
 				return
-			case m := <-q.ch:
+			case <-unsubscribed:
+				return
+			case <-q.ready:
+				select {
+				case <-unsubscribed:
+					// leave the message to the other subscribers
+					q.ready <- struct{}{}
+					return
+				default:
+				}
+				m := q.receive()
 				atomic.AddInt32(&q.unacked, 1)
 				if err := sub(m); err != nil {
 					log.Error().
@@ -144,12 +227,17 @@ func (q *queue) subscribe(sub func(m any) error) {
 	}()
 }
 
-func NewInMemoryBroker() *InMemoryBroker {
-	return &InMemoryBroker{
-		queues:    new(syncx.Map[string, *queue]),
-		topics:    new(syncx.Map[string, *topic]),
-		terminate: new(atomic.Bool),
+func NewInMemoryBroker(opts ...InMemoryOption) *InMemoryBroker {
+	b := &InMemoryBroker{
+		queues:        new(syncx.Map[string, *queue]),
+		topics:        new(syncx.Map[string, *topic]),
+		terminate:     new(atomic.Bool),
+		maxDeliveries: DEFAULT_MAX_DELIVERIES,
 	}
+	for _, o := range opts {
+		o(b)
+	}
+	return b
 }
 
 func (b *InMemoryBroker) PublishTask(ctx context.Context, qname string, t *tork.Task) error {
@@ -159,11 +247,45 @@ func (b *InMemoryBroker) PublishTask(ctx context.Context, qname string, t *tork.
 
 func (b *InMemoryBroker) SubscribeForTasks(qname string, handler func(t *tork.Task) error) error {
 	return b.subscribe(qname, func(m any) error {
+		attempt := 1
 		t, ok := m.(*tork.Task)
+		if rd, isRedelivery := m.(redelivery); isRedelivery {
+			t, attempt, ok = rd.task, rd.attempt, true
+		}
 		if !ok {
 			return errors.Errorf("can't cast message to task")
 		}
-		return handler(t.Clone())
+		return deliverTask(qname, t, attempt, b.maxDeliveries, handler,
+			func(t *tork.Task, attempt int) error {
+				// sent asynchronously as the queue may be full,
+				// with this subscriber being the one to drain it
+				go func() {
+					if err := b.publish(qname, redelivery{task: t, attempt: attempt}); err != nil {
+						log.Error().Err(err).Msgf("error redelivering task %s", t.ID)
+					}
+				}()
+				return nil
+			},
+			func(dl *tork.DeadLetter) error {
+				return b.publish(QUEUE_DEAD_LETTER, dl)
+			})
+	})
+}
+
+func (b *InMemoryBroker) UnsubscribeForTasks(qname string) error {
+	if q, ok := b.queues.Get(qname); ok {
+		q.unsubscribe()
+	}
+	return nil
+}
+
+func (b *InMemoryBroker) SubscribeForDeadLetters(handler func(dl *tork.DeadLetter) error) error {
+	return b.subscribe(QUEUE_DEAD_LETTER, func(m any) error {
+		dl, ok := asDeadLetter(m)
+		if !ok {
+			return errors.Errorf("can't cast message to dead letter")
+		}
+		return handler(dl.Clone())
 	})
 }
 
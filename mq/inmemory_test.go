@@ -2,8 +2,10 @@ package mq_test
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -38,6 +40,96 @@ func TestInMemoryPublishAndSubsribeForTask(t *testing.T) {
 	assert.Equal(t, "/somevolume", t1.Mounts[0].Target)
 }
 
+func TestInMemoryTaskPriority(t *testing.T) {
+	ctx := context.Background()
+	b := mq.NewInMemoryBroker()
+	qname := "test-queue"
+	for _, p := range []int{0, 3, 9, 3, 0} {
+		err := b.PublishTask(ctx, qname, &tork.Task{
+			ID:       uuid.NewUUID(),
+			Priority: p,
+		})
+		assert.NoError(t, err)
+	}
+	qs, err := b.Queues(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 5, qs[0].Size)
+
+	received := make(chan int, 5)
+	err = b.SubscribeForTasks(qname, func(t *tork.Task) error {
+		received <- t.Priority
+		return nil
+	})
+	assert.NoError(t, err)
+	priorities := make([]int, 0)
+	for i := 0; i < 5; i++ {
+		priorities = append(priorities, <-received)
+	}
+	assert.Equal(t, []int{9, 3, 3, 0, 0}, priorities)
+}
+
+func TestInMemoryDeadLetterTask(t *testing.T) {
+	ctx := context.Background()
+	b := mq.NewInMemoryBroker()
+	var attempts atomic.Int32
+	err := b.SubscribeForTasks("test-queue", func(t *tork.Task) error {
+		attempts.Add(1)
+		return errors.New("something bad happened")
+	})
+	assert.NoError(t, err)
+
+	deadLettered := make(chan *tork.DeadLetter)
+	err = b.SubscribeForDeadLetters(func(dl *tork.DeadLetter) error {
+		deadLettered <- dl
+		return nil
+	})
+	assert.NoError(t, err)
+
+	t1 := &tork.Task{
+		ID: uuid.NewUUID(),
+	}
+	err = b.PublishTask(ctx, "test-queue", t1)
+	assert.NoError(t, err)
+
+	dl := <-deadLettered
+	assert.Equal(t, t1.ID, dl.Task.ID)
+	assert.Equal(t, "test-queue", dl.Queue)
+	assert.Equal(t, "something bad happened", dl.Error)
+	assert.Equal(t, mq.DEFAULT_MAX_DELIVERIES, dl.Attempts)
+	assert.Equal(t, int32(mq.DEFAULT_MAX_DELIVERIES), attempts.Load())
+}
+
+func TestInMemoryRedeliverTask(t *testing.T) {
+	ctx := context.Background()
+	b := mq.NewInMemoryBroker(mq.WithInMemoryMaxDeliveries(2))
+	handled := make(chan *tork.Task)
+	var attempts atomic.Int32
+	err := b.SubscribeForTasks("test-queue", func(t *tork.Task) error {
+		if attempts.Add(1) == 1 {
+			return errors.New("something bad happened")
+		}
+		handled <- t
+		return nil
+	})
+	assert.NoError(t, err)
+
+	err = b.SubscribeForDeadLetters(func(dl *tork.DeadLetter) error {
+		t.Fatal("the task should not be dead-lettered")
+		return nil
+	})
+	assert.NoError(t, err)
+
+	t1 := &tork.Task{
+		ID: uuid.NewUUID(),
+	}
+	err = b.PublishTask(ctx, "test-queue", t1)
+	assert.NoError(t, err)
+
+	t2 := <-handled
+	assert.Equal(t, t1.ID, t2.ID)
+	assert.Equal(t, int32(2), attempts.Load())
+}
+
 func TestInMemoryGetQueues(t *testing.T) {
 	ctx := context.Background()
 	b := mq.NewInMemoryBroker()
@@ -195,6 +287,43 @@ func TestInMemoryShutdown(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestInMemoryUnsubscribeForTasks(t *testing.T) {
+	ctx := context.Background()
+	b := mq.NewInMemoryBroker()
+	qname := fmt.Sprintf("test-%s", uuid.NewUUID())
+	received := make(chan *tork.Task, 10)
+	release := make(chan any)
+	err := b.SubscribeForTasks(qname, func(t *tork.Task) error {
+		received <- t
+		<-release
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, b.PublishTask(ctx, qname, &tork.Task{ID: "1"}))
+	assert.Equal(t, "1", (<-received).ID)
+
+	// the task being handled is left to finish
+	assert.NoError(t, b.UnsubscribeForTasks(qname))
+	close(release)
+	for i := 0; i < 5; i++ {
+		assert.NoError(t, b.PublishTask(ctx, qname, &tork.Task{}))
+	}
+	select {
+	case <-received:
+		t.Fatal("received a task after unsubscribing")
+	case <-time.After(time.Millisecond * 100):
+	}
+	qs, err := b.Queues(ctx)
+	assert.NoError(t, err)
+	for _, q := range qs {
+		if q.Name == qname {
+			assert.Equal(t, 0, q.Subscribers)
+			assert.Equal(t, 5, q.Size)
+		}
+	}
+	assert.NoError(t, b.Shutdown(ctx))
+}
+
 func TestInMemorSubsribeForEvent(t *testing.T) {
 	ctx := context.Background()
 	b := mq.NewInMemoryBroker()
@@ -0,0 +1,793 @@
+package mq
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/runabol/tork"
+	"github.com/runabol/tork/internal/syncx"
+	"github.com/runabol/tork/internal/uuid"
+	"github.com/runabol/tork/internal/wildcard"
+)
+
+const (
+	KAFKA_DEFAULT_PREFIX     = "tork."
+	KAFKA_DEFAULT_PARTITIONS = 8
+)
+
+const (
+	kafkaContentType       = "application/vnd.kafka.v2+json"
+	kafkaBinaryContentType = "application/vnd.kafka.binary.v2+json"
+	// the topic all the events are published to. Each
+	// event subscriber matches their topic on its own.
+	kafkaEventsTopic = "events"
+	// how long a fetch waits for records
+	kafkaFetchTimeout = time.Second * 5
+)
+
+// KafkaBroker is an implementation of the Broker interface which
+// uses Apache Kafka through the Confluent REST Proxy. Each queue is
+// a topic, consumed by a consumer group of the same name which its
+// subscribers join, so every message is handled by one of them. Tasks
+// are keyed (and thus partitioned) by their ID. The offset of a
+// message is committed once its handler returned, so messages whose
+// subscriber went away before that are delivered again. Handlers
+// running for longer than the proxy's consumer.instance.timeout.ms
+// lose their consumer and their message is redelivered, so the
+// timeout must exceed the duration of the longest task. Kafka doesn't
+// support priorities so tasks are delivered in the order they were
+// published to their partition.
+type KafkaBroker struct {
+	endpoint      string
+	prefix        string
+	partitions    int
+	conn          ConnectionConfig
+	codec         Codec
+	client        *http.Client
+	declared      *syncx.Map[string, bool]
+	clusterID     string
+	clusterMu     sync.Mutex
+	subscriptions []*kafkaSubscription
+	mu            sync.RWMutex
+	shuttingDown  bool
+	ctx           context.Context
+	cancel        context.CancelFunc
+	maxDeliveries int
+	// the number of subscriptions which
+	// fail to fetch their messages
+	failing int32
+}
+
+type kafkaSubscription struct {
+	qname  string
+	topic  string
+	group  string
+	events bool
+	// the URL of the consumer instance
+	// the subscription fetches through
+	instance string
+	mu       sync.Mutex
+	done     chan struct{}
+	// cancelled by UnsubscribeForTasks
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+type kafkaRecord struct {
+	Topic     string  `json:"topic"`
+	Key       *string `json:"key"`
+	Value     string  `json:"value"`
+	Partition int     `json:"partition"`
+	Offset    int64   `json:"offset"`
+}
+
+type KafkaOption = func(b *KafkaBroker)
+
+// WithKafkaTopicPrefix sets the prefix of the topics and
+// consumer groups created by the broker. Default: tork.
+func WithKafkaTopicPrefix(prefix string) KafkaOption {
+	return func(b *KafkaBroker) {
+		b.prefix = prefix
+	}
+}
+
+// WithKafkaPartitions sets the number of partitions of the topics
+// created by the broker, which caps the number of subscribers of
+// a queue that receive messages. Default: 8
+func WithKafkaPartitions(n int) KafkaOption {
+	return func(b *KafkaBroker) {
+		b.partitions = n
+	}
+}
+
+// WithKafkaConnection sets the URL of the REST Proxy, the TLS
+// settings and the credentials used to connect to it. A token is
+// sent as a bearer token, a username and password with basic auth.
+func WithKafkaConnection(cfg ConnectionConfig) KafkaOption {
+	return func(b *KafkaBroker) {
+		b.conn = cfg
+	}
+}
+
+// WithKafkaCodec sets the codec used to encode the published
+// messages. Consumers decode messages with the codec recorded
+// in their envelope. Default: JSON
+func WithKafkaCodec(c Codec) KafkaOption {
+	return func(b *KafkaBroker) {
+		b.codec = c
+	}
+}
+
+// WithKafkaMaxDeliveries sets the number of times a task message
+// is delivered before it's dead-lettered. Default: DEFAULT_MAX_DELIVERIES
+func WithKafkaMaxDeliveries(n int) KafkaOption {
+	return func(b *KafkaBroker) {
+		b.maxDeliveries = n
+	}
+}
+
+func NewKafkaBroker(endpoint string, opts ...KafkaOption) (*KafkaBroker, error) {
+	b := &KafkaBroker{
+		endpoint:      endpoint,
+		prefix:        KAFKA_DEFAULT_PREFIX,
+		partitions:    KAFKA_DEFAULT_PARTITIONS,
+		codec:         JSONCodec,
+		client:        &http.Client{Timeout: time.Second * 90},
+		declared:      new(syncx.Map[string, bool]),
+		subscriptions: make([]*kafkaSubscription, 0),
+	}
+	for _, o := range opts {
+		o(b)
+	}
+	if b.conn.URL != "" {
+		b.endpoint = b.conn.URL
+	}
+	b.endpoint = strings.TrimSuffix(b.endpoint, "/")
+	if b.endpoint == "" {
+		return nil, errors.New("kafka REST proxy endpoint must not be empty")
+	}
+	if b.partitions < 1 {
+		return nil, errors.Errorf("invalid number of partitions: %d", b.partitions)
+	}
+	tlsConfig, err := b.conn.TLSConfig()
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig != nil {
+		b.client.Transport = &http.Transport{TLSClientConfig: tlsConfig}
+	}
+	b.ctx, b.cancel = context.WithCancel(context.Background())
+	return b, nil
+}
+
+// kafkaAPIError is an error response of the REST Proxy.
+type kafkaAPIError struct {
+	status  int
+	code    int
+	message string
+}
+
+func (e *kafkaAPIError) Error() string {
+	return fmt.Sprintf("kafka REST proxy responded with %d: %s", e.status, e.message)
+}
+
+func kafkaStatus(err error) int {
+	var apiErr *kafkaAPIError
+	if errors.As(err, &apiErr) {
+		return apiErr.status
+	}
+	return 0
+}
+
+// call invokes the REST Proxy. URLs which are not absolute
+// are relative to the proxy's endpoint.
+func (b *KafkaBroker) call(ctx context.Context, method, u, contentType string, body, result any) error {
+	var r io.Reader
+	if body != nil {
+		bs, err := json.Marshal(body)
+		if err != nil {
+			return errors.Wrapf(err, "error serializing request")
+		}
+		r = bytes.NewReader(bs)
+	}
+	if !strings.HasPrefix(u, "http://") && !strings.HasPrefix(u, "https://") {
+		u = b.endpoint + u
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, r)
+	if err != nil {
+		return errors.Wrapf(err, "error building request")
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", contentType)
+	}
+	req.Header.Set("Accept", contentType)
+	switch {
+	case b.conn.Token != "":
+		req.Header.Set("Authorization", "Bearer "+b.conn.Token)
+	case b.conn.Username != "" || b.conn.Password != "":
+		req.SetBasicAuth(b.conn.Username, b.conn.Password)
+	}
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return errors.Wrapf(err, "error calling the kafka REST proxy")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		e := struct {
+			ErrorCode int    `json:"error_code"`
+			Message   string `json:"message"`
+		}{}
+		_ = json.NewDecoder(io.LimitReader(resp.Body, 4096)).Decode(&e)
+		return &kafkaAPIError{status: resp.StatusCode, code: e.ErrorCode, message: e.Message}
+	}
+	if result != nil && resp.StatusCode != http.StatusNoContent {
+		if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+			return errors.Wrapf(err, "error decoding kafka REST proxy response")
+		}
+	}
+	return nil
+}
+
+// topicName returns the topic (and consumer group) of the queue.
+// Characters not allowed in topic names are escaped as _XX.
+func (b *KafkaBroker) topicName(qname string) string {
+	var sb strings.Builder
+	sb.WriteString(b.prefix)
+	for i := 0; i < len(qname); i++ {
+		switch c := qname[i]; {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-', c == '.':
+			sb.WriteByte(c)
+		default:
+			fmt.Fprintf(&sb, "_%02X", c)
+		}
+	}
+	return sb.String()
+}
+
+// queueName reverses topicName.
+func (b *KafkaBroker) queueName(topic string) (string, bool) {
+	if !strings.HasPrefix(topic, b.prefix) {
+		return "", false
+	}
+	name := strings.TrimPrefix(topic, b.prefix)
+	var sb strings.Builder
+	for i := 0; i < len(name); i++ {
+		if name[i] == '_' && i+2 < len(name) {
+			c, err := strconv.ParseUint(name[i+1:i+3], 16, 8)
+			if err != nil {
+				return "", false
+			}
+			sb.WriteByte(byte(c))
+			i += 2
+			continue
+		}
+		sb.WriteByte(name[i])
+	}
+	return sb.String(), true
+}
+
+// cluster returns the ID of the Kafka cluster
+// behind the proxy, used by its v3 API.
+func (b *KafkaBroker) cluster(ctx context.Context) (string, error) {
+	b.clusterMu.Lock()
+	defer b.clusterMu.Unlock()
+	if b.clusterID != "" {
+		return b.clusterID, nil
+	}
+	resp := struct {
+		Data []struct {
+			ClusterID string `json:"cluster_id"`
+		} `json:"data"`
+	}{}
+	if err := b.call(ctx, http.MethodGet, "/v3/clusters", "application/json", nil, &resp); err != nil {
+		return "", errors.Wrapf(err, "error getting the kafka cluster")
+	}
+	if len(resp.Data) == 0 {
+		return "", errors.New("no kafka cluster found")
+	}
+	b.clusterID = resp.Data[0].ClusterID
+	return b.clusterID, nil
+}
+
+// declareTopic creates the topic, so that it has the configured
+// number of partitions rather than the broker's default.
+func (b *KafkaBroker) declareTopic(ctx context.Context, topic string) error {
+	if _, ok := b.declared.Get(topic); ok {
+		return nil
+	}
+	cluster, err := b.cluster(ctx)
+	if err != nil {
+		return err
+	}
+	log.Debug().Msgf("declaring topic: %s", topic)
+	req := map[string]any{
+		"topic_name":       topic,
+		"partitions_count": b.partitions,
+	}
+	err = b.call(ctx, http.MethodPost, fmt.Sprintf("/v3/clusters/%s/topics", cluster), "application/json", req, nil)
+	// 40002: the topic already exists
+	var apiErr *kafkaAPIError
+	if err != nil && !(errors.As(err, &apiErr) && (apiErr.code == 40002 || apiErr.status == http.StatusConflict)) {
+		return errors.Wrapf(err, "error declaring topic %s", topic)
+	}
+	b.declared.Set(topic, true)
+	return nil
+}
+
+func (b *KafkaBroker) Queues(ctx context.Context) ([]QueueInfo, error) {
+	subscribers := make(map[string]int)
+	b.mu.RLock()
+	for _, sub := range b.subscriptions {
+		subscribers[sub.topic]++
+	}
+	b.mu.RUnlock()
+	topics := make([]string, 0)
+	if err := b.call(ctx, http.MethodGet, "/topics", kafkaContentType, nil, &topics); err != nil {
+		return nil, errors.Wrapf(err, "error listing topics")
+	}
+	cluster, err := b.cluster(ctx)
+	if err != nil {
+		return nil, err
+	}
+	qis := make([]QueueInfo, 0)
+	for _, topic := range topics {
+		qname, ok := b.queueName(topic)
+		if !ok || topic == b.topicName(kafkaEventsTopic) {
+			continue
+		}
+		// the number of messages of the queue is the
+		// lag of its consumer group, if it exists
+		lag := struct {
+			TotalLag int `json:"total_lag"`
+		}{}
+		err := b.call(ctx, http.MethodGet, fmt.Sprintf("/v3/clusters/%s/consumer-groups/%s/lag-summary", cluster, topic), "application/json", nil, &lag)
+		if err != nil && kafkaStatus(err) != http.StatusNotFound {
+			return nil, errors.Wrapf(err, "error getting the lag of %s", topic)
+		}
+		qis = append(qis, QueueInfo{
+			Name:        qname,
+			Size:        lag.TotalLag,
+			Subscribers: subscribers[topic],
+		})
+	}
+	return qis, nil
+}
+
+func (b *KafkaBroker) PublishTask(ctx context.Context, qname string, t *tork.Task) error {
+	return b.publish(ctx, qname, t.ID, t)
+}
+
+func (b *KafkaBroker) SubscribeForTasks(qname string, handler func(t *tork.Task) error) error {
+	return b.subscribe(qname, func(msg any, md Metadata) error {
+		t, ok := msg.(*tork.Task)
+		if !ok {
+			return errors.Errorf("expecting a *tork.Task but got %T", msg)
+		}
+		return deliverTask(qname, t, md.Attempt, b.maxDeliveries, handler,
+			func(t *tork.Task, attempt int) error {
+				md.Attempt = attempt
+				return b.publish(WithMetadata(context.Background(), md), qname, t.ID, t)
+			},
+			func(dl *tork.DeadLetter) error {
+				return b.publish(context.Background(), QUEUE_DEAD_LETTER, dl.Task.ID, dl)
+			})
+	})
+}
+
+func (b *KafkaBroker) SubscribeForDeadLetters(handler func(dl *tork.DeadLetter) error) error {
+	return b.subscribe(QUEUE_DEAD_LETTER, func(msg any, _ Metadata) error {
+		dl, ok := asDeadLetter(msg)
+		if !ok {
+			return errors.Errorf("expecting a *tork.DeadLetter but got %T", msg)
+		}
+		return handler(dl)
+	})
+}
+
+func (b *KafkaBroker) publish(ctx context.Context, qname, key string, msg any) error {
+	topic := b.topicName(qname)
+	if err := b.declareTopic(ctx, topic); err != nil {
+		return err
+	}
+	return b.publishTo(ctx, topic, key, msg)
+}
+
+// publishTo produces the message to the topic. Messages with
+// the same key are routed to the same partition.
+func (b *KafkaBroker) publishTo(ctx context.Context, topic, key string, msg any) error {
+	if b.isShuttingDown() {
+		return errors.New("broker is shutting down")
+	}
+	body, md, err := seal(ctx, b.codec, msg)
+	if err != nil {
+		return err
+	}
+	// the type of the message is recorded
+	// in the key, ahead of the routing key
+	k := base64.StdEncoding.EncodeToString([]byte(md.Type + "|" + key))
+	req := map[string]any{
+		"records": []kafkaRecord{{
+			Key:   &k,
+			Value: base64.StdEncoding.EncodeToString(body),
+		}},
+	}
+	resp := struct {
+		Offsets []struct {
+			ErrorCode *int   `json:"error_code"`
+			Error     string `json:"error"`
+		} `json:"offsets"`
+	}{}
+	if err := b.call(ctx, http.MethodPost, "/topics/"+topic, kafkaBinaryContentType, req, &resp); err != nil {
+		return errors.Wrapf(err, "unable to publish message")
+	}
+	for _, o := range resp.Offsets {
+		if o.ErrorCode != nil {
+			return errors.Errorf("unable to publish message: %s", o.Error)
+		}
+	}
+	return nil
+}
+
+func (b *KafkaBroker) subscribe(qname string, handler func(msg any, md Metadata) error) error {
+	topic := b.topicName(qname)
+	if err := b.declareTopic(b.ctx, topic); err != nil {
+		return err
+	}
+	return b.startSubscription(&kafkaSubscription{
+		qname: qname,
+		topic: topic,
+		group: topic,
+		done:  make(chan struct{}),
+	}, "earliest", func(r kafkaRecord, mtype, _ string) {
+		msg, md, err := b.decode(mtype, r.Value)
+		if err != nil {
+			log.Error().
+				Err(err).
+				Str("queue", qname).
+				Str("type", mtype).
+				Msg("failed to deserialized message")
+			return
+		}
+		// stale heartbeats are dropped
+		if qname == QUEUE_HEARTBEAT && md.CreatedAt != nil &&
+			time.Since(*md.CreatedAt) > time.Millisecond*defaultHeartbeatTTL {
+			return
+		}
+		if err := handler(msg, md); err != nil {
+			log.Error().
+				Err(err).
+				Str("queue", qname).
+				Str("traceId", md.TraceID).
+				Int("attempt", md.Attempt).
+				Msg("failed to handle message")
+		}
+	})
+}
+
+func (b *KafkaBroker) decode(mtype, value string) (any, Metadata, error) {
+	body, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return nil, Metadata{}, errors.Wrapf(err, "error decoding message value")
+	}
+	return open(mtype, body)
+}
+
+// createConsumer creates an instance of the subscription's
+// consumer group and subscribes it to the topic.
+func (b *KafkaBroker) createConsumer(ctx context.Context, sub *kafkaSubscription, offsetReset string) error {
+	resp := struct {
+		BaseURI string `json:"base_uri"`
+	}{}
+	req := map[string]string{
+		"name":               uuid.NewUUID(),
+		"format":             "binary",
+		"auto.offset.reset":  offsetReset,
+		"auto.commit.enable": "false",
+	}
+	if err := b.call(ctx, http.MethodPost, "/consumers/"+sub.group, kafkaContentType, req, &resp); err != nil {
+		return errors.Wrapf(err, "error creating a consumer of %s", sub.group)
+	}
+	if err := b.call(ctx, http.MethodPost, resp.BaseURI+"/subscription", kafkaContentType,
+		map[string]any{"topics": []string{sub.topic}}, nil); err != nil {
+		b.deleteConsumer(resp.BaseURI)
+		return errors.Wrapf(err, "error subscribing to %s", sub.topic)
+	}
+	sub.mu.Lock()
+	sub.instance = resp.BaseURI
+	sub.mu.Unlock()
+	return nil
+}
+
+func (b *KafkaBroker) deleteConsumer(instance string) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+	if err := b.call(ctx, http.MethodDelete, instance, kafkaContentType, nil, nil); err != nil && kafkaStatus(err) != http.StatusNotFound {
+		log.Error().Err(err).Msgf("error deleting consumer %s", instance)
+	}
+}
+
+func (b *KafkaBroker) startSubscription(sub *kafkaSubscription, offsetReset string, handler func(r kafkaRecord, mtype, key string)) error {
+	if err := b.createConsumer(b.ctx, sub, offsetReset); err != nil {
+		return err
+	}
+	b.mu.Lock()
+	if b.shuttingDown {
+		b.mu.Unlock()
+		b.deleteConsumer(sub.instance)
+		return errors.New("broker is shutting down")
+	}
+	sub.ctx, sub.cancel = context.WithCancel(b.ctx)
+	b.subscriptions = append(b.subscriptions, sub)
+	b.mu.Unlock()
+	log.Debug().Msgf("subscribing for messages on %s", sub.topic)
+	go func() {
+		defer close(sub.done)
+		attempt := 0
+		defer func() {
+			if attempt > 0 {
+				atomic.AddInt32(&b.failing, -1)
+			}
+		}()
+		for sub.ctx.Err() == nil {
+			err := b.fetch(sub, handler)
+			if err == nil {
+				if attempt > 0 {
+					log.Info().Msgf("resumed fetching messages from %s", sub.topic)
+					atomic.AddInt32(&b.failing, -1)
+					attempt = 0
+				}
+				continue
+			}
+			if sub.ctx.Err() != nil {
+				return
+			}
+			attempt++
+			if attempt == 1 {
+				atomic.AddInt32(&b.failing, 1)
+			}
+			log.Error().
+				Err(err).
+				Msgf("error fetching messages from %s (attempt %d)", sub.topic, attempt)
+			select {
+			case <-sub.ctx.Done():
+				return
+			case <-time.After(reconnectBackoff(attempt)):
+			}
+			// the proxy removes consumers which were idle for
+			// too long, in which case a new one is created
+			if kafkaStatus(err) == http.StatusNotFound {
+				if err := b.createConsumer(b.ctx, sub, offsetReset); err != nil {
+					log.Error().Err(err).Msgf("error recreating the consumer of %s", sub.topic)
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+func (b *KafkaBroker) UnsubscribeForTasks(qname string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	remaining := make([]*kafkaSubscription, 0, len(b.subscriptions))
+	for _, sub := range b.subscriptions {
+		if sub.qname != qname || sub.events {
+			remaining = append(remaining, sub)
+			continue
+		}
+		sub.cancel()
+		// leave the consumer group once the record being
+		// handled is committed, so that the partitions of
+		// the subscription are reassigned right away
+		go func(sub *kafkaSubscription) {
+			<-sub.done
+			sub.mu.Lock()
+			instance := sub.instance
+			sub.mu.Unlock()
+			b.deleteConsumer(instance)
+		}(sub)
+	}
+	b.subscriptions = remaining
+	return nil
+}
+
+// fetch handles the next batch of records of the subscription,
+// committing the offset of each record once it was handled.
+func (b *KafkaBroker) fetch(sub *kafkaSubscription, handler func(r kafkaRecord, mtype, key string)) error {
+	sub.mu.Lock()
+	instance := sub.instance
+	sub.mu.Unlock()
+	records := make([]kafkaRecord, 0)
+	u := fmt.Sprintf("%s/records?timeout=%d", instance, kafkaFetchTimeout.Milliseconds())
+	if err := b.call(b.ctx, http.MethodGet, u, kafkaBinaryContentType, nil, &records); err != nil {
+		return err
+	}
+	for i, r := range records {
+		// the rest of the batch is left uncommitted, so
+		// that it's delivered to the group's next consumer
+		if sub.ctx.Err() != nil && !IsCoordinatorQueue(sub.qname) && !sub.events {
+			log.Debug().Msgf("leaving %d message(s) of %s to other subscribers", len(records)-i, sub.topic)
+			return nil
+		}
+		var mtype, key string
+		if r.Key != nil {
+			k, err := base64.StdEncoding.DecodeString(*r.Key)
+			if err == nil {
+				mtype, key, _ = strings.Cut(string(k), "|")
+			}
+		}
+		handler(r, mtype, key)
+		commit := map[string]any{
+			"offsets": []map[string]any{{
+				"topic":     r.Topic,
+				"partition": r.Partition,
+				"offset":    r.Offset,
+			}},
+		}
+		if err := b.call(context.Background(), http.MethodPost, instance+"/offsets", kafkaContentType, commit, nil); err != nil {
+			log.Error().Err(err).Msgf("error committing the offset of a message on %s", sub.topic)
+		}
+	}
+	return nil
+}
+
+func (b *KafkaBroker) PublishTaskProgress(ctx context.Context, t *tork.Task) error {
+	return b.publish(ctx, QUEUE_PROGRESS, t.ID, t)
+}
+
+func (b *KafkaBroker) SubscribeForTaskProgress(handler func(t *tork.Task) error) error {
+	return b.subscribe(QUEUE_PROGRESS, func(msg any, _ Metadata) error {
+		t, ok := msg.(*tork.Task)
+		if !ok {
+			return errors.Errorf("expecting a *tork.Task but got %T", msg)
+		}
+		return handler(t)
+	})
+}
+
+func (b *KafkaBroker) PublishHeartbeat(ctx context.Context, n *tork.Node) error {
+	return b.publish(ctx, QUEUE_HEARTBEAT, n.ID, n)
+}
+
+func (b *KafkaBroker) SubscribeForHeartbeats(handler func(n *tork.Node) error) error {
+	return b.subscribe(QUEUE_HEARTBEAT, func(msg any, _ Metadata) error {
+		n, ok := msg.(*tork.Node)
+		if !ok {
+			return errors.Errorf("expecting a *tork.Node but got %T", msg)
+		}
+		return handler(n)
+	})
+}
+
+func (b *KafkaBroker) PublishJob(ctx context.Context, j *tork.Job) error {
+	return b.publish(ctx, QUEUE_JOBS, j.ID, j)
+}
+
+func (b *KafkaBroker) SubscribeForJobs(handler func(j *tork.Job) error) error {
+	return b.subscribe(QUEUE_JOBS, func(msg any, _ Metadata) error {
+		j, ok := msg.(*tork.Job)
+		if !ok {
+			return errors.Errorf("expecting a *tork.Job but got %T", msg)
+		}
+		return handler(j)
+	})
+}
+
+func (b *KafkaBroker) PublishEvent(ctx context.Context, topic string, event any) error {
+	t := b.topicName(kafkaEventsTopic)
+	if err := b.declareTopic(ctx, t); err != nil {
+		return err
+	}
+	return b.publishTo(ctx, t, topic, event)
+}
+
+// SubscribeForEvents receives the events published while
+// subscribed, through a consumer group of its own.
+func (b *KafkaBroker) SubscribeForEvents(ctx context.Context, pattern string, handler func(event any)) error {
+	topic := b.topicName(kafkaEventsTopic)
+	if err := b.declareTopic(ctx, topic); err != nil {
+		return err
+	}
+	return b.startSubscription(&kafkaSubscription{
+		qname:  kafkaEventsTopic,
+		topic:  topic,
+		group:  b.topicName(fmt.Sprintf("%s%s-%s", QUEUE_EXCLUSIVE_PREFIX, kafkaEventsTopic, uuid.NewUUID())),
+		events: true,
+		done:   make(chan struct{}),
+	}, "latest", func(r kafkaRecord, mtype, key string) {
+		if !wildcard.Match(pattern, key) {
+			return
+		}
+		ev, _, err := b.decode(mtype, r.Value)
+		if err != nil {
+			log.Error().
+				Err(err).
+				Str("type", mtype).
+				Msg("failed to deserialized event")
+			return
+		}
+		handler(ev)
+	})
+}
+
+func (b *KafkaBroker) PublishTaskLogPart(ctx context.Context, p *tork.TaskLogPart) error {
+	return b.publish(ctx, QUEUE_LOGS, p.TaskID, p)
+}
+
+func (b *KafkaBroker) SubscribeForTaskLogPart(handler func(p *tork.TaskLogPart)) error {
+	return b.subscribe(QUEUE_LOGS, func(msg any, _ Metadata) error {
+		p, ok := msg.(*tork.TaskLogPart)
+		if !ok {
+			return errors.Errorf("expecting a *tork.TaskLogPart but got %T", msg)
+		}
+		handler(p)
+		return nil
+	})
+}
+
+func (b *KafkaBroker) HealthCheck(ctx context.Context) error {
+	if b.isShuttingDown() {
+		return errors.New("broker is shutting down")
+	}
+	topics := make([]string, 0)
+	if err := b.call(ctx, http.MethodGet, "/topics", kafkaContentType, nil, &topics); err != nil {
+		return errors.Wrapf(err, "error listing topics")
+	}
+	if n := atomic.LoadInt32(&b.failing); n > 0 {
+		return errors.Errorf("%d subscription(s) fail to fetch messages", n)
+	}
+	return nil
+}
+
+func (b *KafkaBroker) isShuttingDown() bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.shuttingDown
+}
+
+func (b *KafkaBroker) Shutdown(ctx context.Context) error {
+	// when running in standalone mode both the coordinator
+	// and the worker will attempt to shutdown the broker
+	b.mu.Lock()
+	if b.shuttingDown {
+		b.mu.Unlock()
+		return nil
+	}
+	b.shuttingDown = true
+	subs := b.subscriptions
+	b.subscriptions = []*kafkaSubscription{}
+	b.mu.Unlock()
+	// stop fetching messages
+	b.cancel()
+	// let the coordinator's subscribers finish
+	// handling the message they're processing
+	for _, sub := range subs {
+		if !IsCoordinatorQueue(sub.qname) {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+		case <-sub.done:
+		}
+	}
+	// leave the consumer groups, so that their
+	// partitions are reassigned right away
+	for _, sub := range subs {
+		sub.mu.Lock()
+		instance := sub.instance
+		sub.mu.Unlock()
+		b.deleteConsumer(instance)
+	}
+	return nil
+}
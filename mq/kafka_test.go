@@ -0,0 +1,387 @@
+package mq
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/runabol/tork"
+	"github.com/runabol/tork/internal/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeKafka is a minimal, single-partition stand-in for the
+// Confluent REST Proxy. The topic of a consumer group is assigned
+// to the group's first consumer, like Kafka does with a partition.
+type fakeKafka struct {
+	mu        sync.Mutex
+	topics    map[string][]kafkaRecord
+	committed map[string]int64
+	consumers map[string]*fakeKafkaConsumer
+	seq       int
+	srv       *httptest.Server
+}
+
+type fakeKafkaConsumer struct {
+	seq      int
+	group    string
+	topic    string
+	position int64
+	latest   bool
+}
+
+func newFakeKafka(t *testing.T) *fakeKafka {
+	f := &fakeKafka{
+		topics:    make(map[string][]kafkaRecord),
+		committed: make(map[string]int64),
+		consumers: make(map[string]*fakeKafkaConsumer),
+	}
+	f.srv = httptest.NewServer(http.HandlerFunc(f.serve))
+	t.Cleanup(f.srv.Close)
+	return f
+}
+
+func (f *fakeKafka) serve(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	body := map[string]any{}
+	_ = json.NewDecoder(r.Body).Decode(&body)
+	reply := func(v any) {
+		_ = json.NewEncoder(w).Encode(v)
+	}
+	switch {
+	case r.URL.Path == "/v3/clusters":
+		reply(map[string]any{"data": []map[string]string{{"cluster_id": "c1"}}})
+	case len(parts) == 4 && parts[3] == "topics" && r.Method == http.MethodPost:
+		name := body["topic_name"].(string)
+		if _, ok := f.topics[name]; ok {
+			w.WriteHeader(http.StatusBadRequest)
+			reply(map[string]any{"error_code": 40002, "message": "exists"})
+			return
+		}
+		f.topics[name] = []kafkaRecord{}
+	case len(parts) == 6 && parts[5] == "lag-summary":
+		c, ok := f.committed[parts[4]]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		reply(map[string]int64{"total_lag": int64(len(f.topics[parts[4]])) - c})
+	case r.URL.Path == "/topics":
+		names := make([]string, 0)
+		for name := range f.topics {
+			names = append(names, name)
+		}
+		reply(names)
+	case len(parts) == 2 && parts[0] == "topics":
+		records, ok := f.topics[parts[1]]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		for _, rec := range body["records"].([]any) {
+			m := rec.(map[string]any)
+			k := m["key"].(string)
+			records = append(records, kafkaRecord{
+				Topic:  parts[1],
+				Key:    &k,
+				Value:  m["value"].(string),
+				Offset: int64(len(records)),
+			})
+		}
+		f.topics[parts[1]] = records
+		reply(map[string]any{"offsets": []map[string]any{{"partition": 0}}})
+	case len(parts) == 2 && parts[0] == "consumers":
+		id := uuid.NewUUID()
+		f.seq++
+		f.consumers[id] = &fakeKafkaConsumer{
+			seq:    f.seq,
+			group:  parts[1],
+			latest: body["auto.offset.reset"] == "latest",
+		}
+		reply(map[string]string{"base_uri": fmt.Sprintf("%s/consumers/%s/instances/%s", f.srv.URL, parts[1], id)})
+	case len(parts) >= 4 && parts[0] == "consumers":
+		c, ok := f.consumers[parts[3]]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			reply(map[string]any{"error_code": 40403, "message": "consumer instance not found"})
+			return
+		}
+		switch {
+		case r.Method == http.MethodDelete:
+			delete(f.consumers, parts[3])
+		case parts[4] == "subscription":
+			c.topic = body["topics"].([]any)[0].(string)
+			c.position = f.committed[c.group]
+			if c.latest {
+				c.position = int64(len(f.topics[c.topic]))
+			}
+		case parts[4] == "offsets":
+			o := body["offsets"].([]any)[0].(map[string]any)
+			f.committed[c.group] = int64(o["offset"].(float64)) + 1
+		case parts[4] == "records":
+			records := make([]kafkaRecord, 0)
+			if f.owner(c) {
+				records = append(records, f.topics[c.topic][c.position:]...)
+				c.position = int64(len(f.topics[c.topic]))
+			}
+			reply(records)
+		}
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// owner reports whether the consumer is the one its group's
+// topic is assigned to: the oldest consumer of the group.
+func (f *fakeKafka) owner(c *fakeKafkaConsumer) bool {
+	for _, other := range f.consumers {
+		if other.group == c.group && other.topic != "" && other.seq < c.seq {
+			return false
+		}
+	}
+	return true
+}
+
+func TestKafkaTopicName(t *testing.T) {
+	b, err := NewKafkaBroker("http://localhost:8082")
+	assert.NoError(t, err)
+	for qname, topic := range map[string]string{
+		"default":                        "tork.default",
+		"x-abc":                          "tork.x-abc",
+		"tags-disk=ssd,region=eu":        "tork.tags-disk_3Dssd_2Cregion_3Deu",
+		"tags-tork.platform=linux-amd64": "tork.tags-tork.platform_3Dlinux-amd64",
+		"a_b":                            "tork.a_5Fb",
+	} {
+		assert.Equal(t, topic, b.topicName(qname))
+		q, ok := b.queueName(topic)
+		assert.True(t, ok)
+		assert.Equal(t, qname, q)
+	}
+	_, ok := b.queueName("other.default")
+	assert.False(t, ok)
+}
+
+func TestKafkaInvalidConfig(t *testing.T) {
+	_, err := NewKafkaBroker("")
+	assert.Error(t, err)
+	_, err = NewKafkaBroker("http://localhost:8082", WithKafkaPartitions(0))
+	assert.Error(t, err)
+}
+
+func TestKafkaPublishAndSubsribeForTask(t *testing.T) {
+	ctx := context.Background()
+	f := newFakeKafka(t)
+	b, err := NewKafkaBroker(f.srv.URL)
+	assert.NoError(t, err)
+	processed := make(chan *tork.Task, 1)
+	err = b.SubscribeForTasks("test-queue", func(t *tork.Task) error {
+		processed <- t
+		return nil
+	})
+	assert.NoError(t, err)
+	t1 := &tork.Task{ID: uuid.NewUUID()}
+	assert.NoError(t, b.PublishTask(ctx, "test-queue", t1))
+	t2 := <-processed
+	assert.Equal(t, t1.ID, t2.ID)
+	// the offset is committed once handled
+	assert.Eventually(t, func() bool {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		return f.committed["tork.test-queue"] == 1
+	}, time.Second, time.Millisecond*10)
+	assert.NoError(t, b.Shutdown(ctx))
+	// the task is the key of the message
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	records := f.topics["tork.test-queue"]
+	assert.Len(t, records, 1)
+	k, err := base64.StdEncoding.DecodeString(*records[0].Key)
+	assert.NoError(t, err)
+	assert.Equal(t, "*tork.Task|"+t1.ID, string(k))
+	assert.Empty(t, f.consumers)
+}
+
+func TestKafkaRedeliverUncommitted(t *testing.T) {
+	ctx := context.Background()
+	f := newFakeKafka(t)
+	b1, err := NewKafkaBroker(f.srv.URL)
+	assert.NoError(t, err)
+	handling := make(chan any)
+	release := make(chan any)
+	defer close(release)
+	err = b1.SubscribeForTasks("test-queue", func(t *tork.Task) error {
+		handling <- 1
+		<-release
+		return nil
+	})
+	assert.NoError(t, err)
+	t1 := &tork.Task{ID: uuid.NewUUID()}
+	assert.NoError(t, b1.PublishTask(ctx, "test-queue", t1))
+	<-handling
+	// the subscriber goes away before it's done with the task
+	assert.NoError(t, b1.Shutdown(ctx))
+	b2, err := NewKafkaBroker(f.srv.URL)
+	assert.NoError(t, err)
+	processed := make(chan *tork.Task, 1)
+	err = b2.SubscribeForTasks("test-queue", func(t *tork.Task) error {
+		processed <- t
+		return nil
+	})
+	assert.NoError(t, err)
+	t2 := <-processed
+	assert.Equal(t, t1.ID, t2.ID)
+	assert.NoError(t, b2.Shutdown(ctx))
+}
+
+func TestKafkaConsumerGroup(t *testing.T) {
+	ctx := context.Background()
+	f := newFakeKafka(t)
+	processed := make(chan string, 10)
+	brokers := make([]*KafkaBroker, 0)
+	for i := 0; i < 2; i++ {
+		b, err := NewKafkaBroker(f.srv.URL)
+		assert.NoError(t, err)
+		err = b.SubscribeForTasks("test-queue", func(t *tork.Task) error {
+			processed <- t.ID
+			return nil
+		})
+		assert.NoError(t, err)
+		brokers = append(brokers, b)
+	}
+	for i := 0; i < 10; i++ {
+		assert.NoError(t, brokers[0].PublishTask(ctx, "test-queue", &tork.Task{ID: uuid.NewUUID()}))
+	}
+	// every task is handled by one of the subscribers
+	seen := make(map[string]bool)
+	for i := 0; i < 10; i++ {
+		id := <-processed
+		assert.False(t, seen[id])
+		seen[id] = true
+	}
+	select {
+	case id := <-processed:
+		t.Fatalf("task %s was delivered twice", id)
+	case <-time.After(time.Millisecond * 500):
+	}
+	for _, b := range brokers {
+		assert.NoError(t, b.Shutdown(ctx))
+	}
+}
+
+func TestKafkaDeadLetterTask(t *testing.T) {
+	ctx := context.Background()
+	f := newFakeKafka(t)
+	b, err := NewKafkaBroker(f.srv.URL)
+	assert.NoError(t, err)
+	err = b.SubscribeForTasks("test-queue", func(t *tork.Task) error {
+		return errors.New("something bad happened")
+	})
+	assert.NoError(t, err)
+	deadLettered := make(chan *tork.DeadLetter, 1)
+	err = b.SubscribeForDeadLetters(func(dl *tork.DeadLetter) error {
+		deadLettered <- dl
+		return nil
+	})
+	assert.NoError(t, err)
+	t1 := &tork.Task{ID: uuid.NewUUID()}
+	assert.NoError(t, b.PublishTask(ctx, "test-queue", t1))
+	dl := <-deadLettered
+	assert.Equal(t, t1.ID, dl.Task.ID)
+	assert.Equal(t, "something bad happened", dl.Error)
+	assert.Equal(t, "test-queue", dl.Queue)
+	assert.Equal(t, DEFAULT_MAX_DELIVERIES, dl.Attempts)
+	assert.NoError(t, b.Shutdown(ctx))
+}
+
+func TestKafkaGetQueues(t *testing.T) {
+	ctx := context.Background()
+	f := newFakeKafka(t)
+	b, err := NewKafkaBroker(f.srv.URL)
+	assert.NoError(t, err)
+	assert.NoError(t, b.PublishTask(ctx, "tags-disk=ssd", &tork.Task{ID: uuid.NewUUID()}))
+	err = b.SubscribeForJobs(func(j *tork.Job) error {
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, b.PublishEvent(ctx, TOPIC_JOB_COMPLETED, &tork.Job{}))
+	qis, err := b.Queues(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, qis, 2)
+	for _, qi := range qis {
+		switch qi.Name {
+		case "tags-disk=ssd":
+			assert.Equal(t, 0, qi.Subscribers)
+		case QUEUE_JOBS:
+			assert.Equal(t, 1, qi.Subscribers)
+		default:
+			t.Fatalf("unexpected queue %s", qi.Name)
+		}
+	}
+	assert.NoError(t, b.Shutdown(ctx))
+}
+
+func TestKafkaPublishAndSubsribeForEvent(t *testing.T) {
+	ctx := context.Background()
+	f := newFakeKafka(t)
+	b, err := NewKafkaBroker(f.srv.URL)
+	assert.NoError(t, err)
+	// events published before subscribing are not received
+	assert.NoError(t, b.PublishEvent(ctx, "job.completed", &tork.Job{ID: uuid.NewUUID()}))
+	processed := make(chan *tork.Job, 1)
+	err = b.SubscribeForEvents(ctx, TOPIC_JOB, func(event any) {
+		j, ok := event.(*tork.Job)
+		assert.True(t, ok)
+		processed <- j
+	})
+	assert.NoError(t, err)
+	j1 := &tork.Job{ID: uuid.NewUUID(), State: tork.JobStateCompleted}
+	assert.NoError(t, b.PublishEvent(ctx, "job.completed", j1))
+	// events of other topics are not received
+	assert.NoError(t, b.PublishEvent(ctx, "node.created", &tork.Job{ID: uuid.NewUUID()}))
+	j2 := <-processed
+	assert.Equal(t, j1.ID, j2.ID)
+	select {
+	case j := <-processed:
+		t.Fatalf("unexpected event for job %s", j.ID)
+	case <-time.After(time.Millisecond * 200):
+	}
+	assert.NoError(t, b.Shutdown(ctx))
+}
+
+func TestKafkaHealthCheck(t *testing.T) {
+	ctx := context.Background()
+	f := newFakeKafka(t)
+	b, err := NewKafkaBroker(f.srv.URL)
+	assert.NoError(t, err)
+	assert.NoError(t, b.HealthCheck(ctx))
+	assert.NoError(t, b.Shutdown(ctx))
+	assert.Error(t, b.HealthCheck(ctx))
+}
+
+func TestKafkaShutdown(t *testing.T) {
+	ctx := context.Background()
+	f := newFakeKafka(t)
+	b, err := NewKafkaBroker(f.srv.URL)
+	assert.NoError(t, err)
+	mu := make(chan int)
+	err = b.SubscribeForJobs(func(j *tork.Job) error {
+		mu <- 1
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, b.PublishJob(ctx, &tork.Job{ID: uuid.NewUUID()}))
+	<-mu
+	assert.NoError(t, b.Shutdown(ctx))
+	// publishing after shutdown fails
+	assert.Error(t, b.PublishJob(ctx, &tork.Job{}))
+}
@@ -0,0 +1,674 @@
+package mq
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/runabol/tork"
+	"github.com/runabol/tork/internal/syncx"
+	"github.com/runabol/tork/internal/wildcard"
+)
+
+const (
+	NATS_DEFAULT_STREAM   = "tork"
+	NATS_DEFAULT_ACK_WAIT = time.Minute
+)
+
+const (
+	// how long a subscriber waits for a message
+	// before it asks for the next one
+	natsFetchWait = time.Second * 5
+	// the consumers of exclusive queues whose subscribers
+	// went away are removed after this long
+	natsInactiveThreshold = time.Hour * 24
+)
+
+// NATSBroker is an implementation of the Broker interface which
+// uses NATS JetStream. The queues are subjects of a work-queue
+// stream, each with a durable consumer of the same name which its
+// subscribers share, so every message is handled by one of them.
+// Messages are acked once their handler returned and redelivered
+// by JetStream if their subscriber went away before that, so they
+// are delivered at least once. The ack deadline of a message is
+// extended while it's handled. JetStream doesn't support priorities
+// so tasks are delivered in the order they were published. Events
+// are published on a plain NATS subject.
+type NATSBroker struct {
+	url           string
+	stream        string
+	ackWait       time.Duration
+	replicas      int
+	conn          ConnectionConfig
+	codec         Codec
+	maxDeliveries int
+	nc            *nats.Conn
+	js            nats.JetStreamContext
+	consumers     *syncx.Map[string, string]
+	subscriptions []*natsSubscription
+	mu            sync.RWMutex
+	shuttingDown  bool
+	ctx           context.Context
+	cancel        context.CancelFunc
+	// the number of subscriptions which
+	// fail to fetch their messages
+	failing int32
+}
+
+type natsSubscription struct {
+	qname string
+	sub   *nats.Subscription
+	done  chan struct{}
+	// cancelled by UnsubscribeForTasks
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+type NATSOption = func(b *NATSBroker)
+
+// WithNATSStream sets the name of the stream of the queues,
+// which also prefixes the subjects used by the broker.
+// Default: tork
+func WithNATSStream(name string) NATSOption {
+	return func(b *NATSBroker) {
+		b.stream = name
+	}
+}
+
+// WithNATSAckWait sets how long JetStream waits for a message to
+// be acked before it's redelivered. It is extended for as long as
+// the message's handler is running. Default: 1 minute
+func WithNATSAckWait(d time.Duration) NATSOption {
+	return func(b *NATSBroker) {
+		b.ackWait = d
+	}
+}
+
+// WithNATSReplicas sets the number of replicas of the stream
+// on a clustered NATS server. Default: 1
+func WithNATSReplicas(n int) NATSOption {
+	return func(b *NATSBroker) {
+		b.replicas = n
+	}
+}
+
+// WithNATSConnection sets the URL, TLS settings and credentials
+// used to connect to NATS. A token is used in place of the
+// username and password.
+func WithNATSConnection(cfg ConnectionConfig) NATSOption {
+	return func(b *NATSBroker) {
+		b.conn = cfg
+	}
+}
+
+// WithNATSCodec sets the codec used to encode the published
+// messages. Consumers decode messages with the codec recorded
+// in their envelope. Default: JSON
+func WithNATSCodec(c Codec) NATSOption {
+	return func(b *NATSBroker) {
+		b.codec = c
+	}
+}
+
+// WithNATSMaxDeliveries sets the number of times a task message
+// is delivered before it's dead-lettered. Default: DEFAULT_MAX_DELIVERIES
+func WithNATSMaxDeliveries(n int) NATSOption {
+	return func(b *NATSBroker) {
+		b.maxDeliveries = n
+	}
+}
+
+func NewNATSBroker(url string, opts ...NATSOption) (*NATSBroker, error) {
+	b := &NATSBroker{
+		url:           url,
+		stream:        NATS_DEFAULT_STREAM,
+		ackWait:       NATS_DEFAULT_ACK_WAIT,
+		replicas:      1,
+		codec:         JSONCodec,
+		consumers:     new(syncx.Map[string, string]),
+		subscriptions: make([]*natsSubscription, 0),
+	}
+	for _, o := range opts {
+		o(b)
+	}
+	if b.conn.URL != "" {
+		b.url = b.conn.URL
+	}
+	if b.stream == "" || strings.ContainsAny(b.stream, ".*> \t") {
+		return nil, errors.Errorf("invalid stream name: %s", b.stream)
+	}
+	if b.ackWait < time.Second {
+		return nil, errors.Errorf("invalid ack wait: %s", b.ackWait)
+	}
+	nopts := []nats.Option{
+		nats.Name("tork"),
+		nats.MaxReconnects(-1),
+	}
+	if b.conn.Token != "" {
+		nopts = append(nopts, nats.Token(b.conn.Token))
+	} else if b.conn.Username != "" || b.conn.Password != "" {
+		nopts = append(nopts, nats.UserInfo(b.conn.Username, b.conn.Password))
+	}
+	tlsConfig, err := b.conn.TLSConfig()
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig != nil {
+		nopts = append(nopts, nats.Secure(tlsConfig))
+	}
+	nc, err := nats.Connect(b.url, nopts...)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error connecting to NATS")
+	}
+	js, err := nc.JetStream()
+	if err != nil {
+		nc.Close()
+		return nil, errors.Wrapf(err, "error getting the JetStream context")
+	}
+	b.nc, b.js = nc, js
+	if err := b.declareStream(); err != nil {
+		nc.Close()
+		return nil, err
+	}
+	b.ctx, b.cancel = context.WithCancel(context.Background())
+	return b, nil
+}
+
+// declareStream creates the stream of the queues. Messages
+// are removed from it once they were acked.
+func (b *NATSBroker) declareStream() error {
+	_, err := b.js.StreamInfo(b.stream)
+	if err == nil {
+		return nil
+	}
+	if !errors.Is(err, nats.ErrStreamNotFound) {
+		return errors.Wrapf(err, "error getting stream %s", b.stream)
+	}
+	log.Debug().Msgf("declaring stream: %s", b.stream)
+	_, err = b.js.AddStream(&nats.StreamConfig{
+		Name:      b.stream,
+		Subjects:  []string{b.stream + ".queue.>"},
+		Retention: nats.WorkQueuePolicy,
+		Storage:   nats.FileStorage,
+		Replicas:  b.replicas,
+	})
+	if err != nil && !errors.Is(err, nats.ErrStreamNameAlreadyInUse) {
+		return errors.Wrapf(err, "error declaring stream %s", b.stream)
+	}
+	return nil
+}
+
+// natsName encodes the queue name as a subject token and consumer
+// name, which may not contain dots, wildcards or whitespace.
+func natsName(qname string) string {
+	var sb strings.Builder
+	for i := 0; i < len(qname); i++ {
+		switch c := qname[i]; {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9',
+			c == '-', c == '_', c == '=', c == ',', c == '+', c == '~':
+			sb.WriteByte(c)
+		default:
+			fmt.Fprintf(&sb, "%%%02X", c)
+		}
+	}
+	return sb.String()
+}
+
+func (b *NATSBroker) subject(qname string) string {
+	return fmt.Sprintf("%s.queue.%s", b.stream, natsName(qname))
+}
+
+func (b *NATSBroker) eventsSubject() string {
+	return b.stream + ".events"
+}
+
+// declareQueue creates the consumer of the queue, so messages
+// published before anyone subscribed are counted as pending.
+func (b *NATSBroker) declareQueue(qname string) error {
+	name := natsName(qname)
+	if _, ok := b.consumers.Get(name); ok {
+		return nil
+	}
+	log.Debug().Msgf("declaring consumer: %s", name)
+	cfg := &nats.ConsumerConfig{
+		Durable:       name,
+		FilterSubject: b.subject(qname),
+		AckPolicy:     nats.AckExplicitPolicy,
+		AckWait:       b.ackWait,
+		DeliverPolicy: nats.DeliverAllPolicy,
+		// deliveries are limited by the broker, which
+		// dead-letters tasks whose handler keeps failing
+		MaxDeliver:    -1,
+		MaxAckPending: -1,
+	}
+	if strings.HasPrefix(qname, QUEUE_EXCLUSIVE_PREFIX) {
+		cfg.InactiveThreshold = natsInactiveThreshold
+	}
+	if _, err := b.js.AddConsumer(b.stream, cfg); err != nil && !errors.Is(err, nats.ErrConsumerNameAlreadyInUse) {
+		return errors.Wrapf(err, "error declaring consumer %s", name)
+	}
+	b.consumers.Set(name, qname)
+	return nil
+}
+
+func (b *NATSBroker) Queues(ctx context.Context) ([]QueueInfo, error) {
+	subscribers := make(map[string]int)
+	b.mu.RLock()
+	for _, sub := range b.subscriptions {
+		subscribers[sub.qname]++
+	}
+	b.mu.RUnlock()
+	qis := make([]QueueInfo, 0)
+	for ci := range b.js.ConsumersInfo(b.stream, nats.Context(ctx)) {
+		qname, err := url.PathUnescape(ci.Name)
+		if err != nil {
+			qname = ci.Name
+		}
+		qis = append(qis, QueueInfo{
+			Name:        qname,
+			Size:        int(ci.NumPending),
+			Subscribers: subscribers[qname],
+			Unacked:     ci.NumAckPending,
+		})
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return qis, nil
+}
+
+func (b *NATSBroker) PublishTask(ctx context.Context, qname string, t *tork.Task) error {
+	return b.publish(ctx, qname, t)
+}
+
+func (b *NATSBroker) SubscribeForTasks(qname string, handler func(t *tork.Task) error) error {
+	return b.subscribe(qname, func(msg any, md Metadata) error {
+		t, ok := msg.(*tork.Task)
+		if !ok {
+			return errors.Errorf("expecting a *tork.Task but got %T", msg)
+		}
+		return deliverTask(qname, t, md.Attempt, b.maxDeliveries, handler,
+			func(t *tork.Task, attempt int) error {
+				md.Attempt = attempt
+				return b.publish(WithMetadata(context.Background(), md), qname, t)
+			},
+			func(dl *tork.DeadLetter) error {
+				return b.publish(context.Background(), QUEUE_DEAD_LETTER, dl)
+			})
+	})
+}
+
+func (b *NATSBroker) SubscribeForDeadLetters(handler func(dl *tork.DeadLetter) error) error {
+	return b.subscribe(QUEUE_DEAD_LETTER, func(msg any, _ Metadata) error {
+		dl, ok := asDeadLetter(msg)
+		if !ok {
+			return errors.Errorf("expecting a *tork.DeadLetter but got %T", msg)
+		}
+		return handler(dl)
+	})
+}
+
+func (b *NATSBroker) publish(ctx context.Context, qname string, msg any) error {
+	if b.isShuttingDown() {
+		return errors.New("broker is shutting down")
+	}
+	if err := b.declareQueue(qname); err != nil {
+		return err
+	}
+	m, md, err := b.message(ctx, b.subject(qname), msg)
+	if err != nil {
+		return err
+	}
+	// the message ID lets JetStream drop the duplicates
+	// of a message which was published more than once
+	if _, err := b.js.PublishMsg(m, nats.Context(ctx), nats.MsgId(md.ID)); err != nil {
+		return errors.Wrapf(err, "unable to publish message")
+	}
+	return nil
+}
+
+func (b *NATSBroker) message(ctx context.Context, subject string, msg any) (*nats.Msg, Metadata, error) {
+	body, md, err := seal(ctx, b.codec, msg)
+	if err != nil {
+		return nil, md, err
+	}
+	m := nats.NewMsg(subject)
+	m.Data = body
+	m.Header.Set("type", md.Type)
+	if md.Tenant != "" || md.SubmittedBy != "" {
+		m.Header.Set("x-tork-tenant", md.Tenant)
+		m.Header.Set("x-tork-submitted-by", md.SubmittedBy)
+	}
+	return m, md, nil
+}
+
+func (b *NATSBroker) subscribe(qname string, handler func(msg any, md Metadata) error) error {
+	if err := b.declareQueue(qname); err != nil {
+		return err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.shuttingDown {
+		return errors.New("broker is shutting down")
+	}
+	name := natsName(qname)
+	ps, err := b.js.PullSubscribe(b.subject(qname), name, nats.Bind(b.stream, name))
+	if err != nil {
+		return errors.Wrapf(err, "unable to subscribe on q: %s", qname)
+	}
+	sub := &natsSubscription{
+		qname: qname,
+		sub:   ps,
+		done:  make(chan struct{}),
+	}
+	sub.ctx, sub.cancel = context.WithCancel(b.ctx)
+	b.subscriptions = append(b.subscriptions, sub)
+	log.Debug().Msgf("subscribing for messages on %s", qname)
+	go func() {
+		defer close(sub.done)
+		attempt := 0
+		defer func() {
+			if attempt > 0 {
+				atomic.AddInt32(&b.failing, -1)
+			}
+		}()
+		for sub.ctx.Err() == nil {
+			ctx, cancel := context.WithTimeout(sub.ctx, natsFetchWait)
+			msgs, err := ps.Fetch(1, nats.Context(ctx))
+			cancel()
+			if err != nil {
+				if sub.ctx.Err() != nil {
+					return
+				}
+				if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, nats.ErrTimeout) {
+					continue
+				}
+				attempt++
+				if attempt == 1 {
+					atomic.AddInt32(&b.failing, 1)
+				}
+				log.Error().
+					Err(err).
+					Msgf("error fetching messages from %s (attempt %d)", qname, attempt)
+				select {
+				case <-sub.ctx.Done():
+				case <-time.After(reconnectBackoff(attempt)):
+				}
+				continue
+			}
+			if attempt > 0 {
+				log.Info().Msgf("resumed fetching messages from %s", qname)
+				atomic.AddInt32(&b.failing, -1)
+				attempt = 0
+			}
+			for _, m := range msgs {
+				if sub.ctx.Err() != nil {
+					// fetched as the subscription was cancelled
+					if err := m.Nak(); err != nil {
+						log.Error().Err(err).Msg("failed to nak message")
+					}
+					continue
+				}
+				b.handle(qname, m, handler)
+			}
+		}
+	}()
+	return nil
+}
+
+func (b *NATSBroker) UnsubscribeForTasks(qname string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	remaining := make([]*natsSubscription, 0, len(b.subscriptions))
+	for _, sub := range b.subscriptions {
+		if sub.qname != qname || sub.cancel == nil {
+			remaining = append(remaining, sub)
+			continue
+		}
+		sub.cancel()
+		// the message being handled is acked
+		// independently of the subscription
+		go func(sub *natsSubscription) {
+			<-sub.done
+			if err := sub.sub.Unsubscribe(); err != nil && !errors.Is(err, nats.ErrConnectionClosed) {
+				log.Error().
+					Err(err).
+					Msgf("error unsubscribing from %s", sub.qname)
+			}
+		}(sub)
+	}
+	b.subscriptions = remaining
+	return nil
+}
+
+// handle runs the handler of the message while extending its
+// ack deadline, and acks it once the handler returns. Messages
+// that could not be handled are not redelivered.
+func (b *NATSBroker) handle(qname string, m *nats.Msg, handler func(msg any, md Metadata) error) {
+	defer func() {
+		if err := m.Ack(); err != nil {
+			log.Error().Err(err).Msg("failed to ack message")
+		}
+	}()
+	meta, err := m.Metadata()
+	if err != nil {
+		log.Error().Err(err).Str("queue", qname).Msg("invalid message metadata")
+		return
+	}
+	// stale heartbeats are dropped
+	if qname == QUEUE_HEARTBEAT && time.Since(meta.Timestamp) > time.Millisecond*defaultHeartbeatTTL {
+		return
+	}
+	msg, md, err := open(m.Header.Get("type"), m.Data)
+	if err != nil {
+		log.Error().
+			Err(err).
+			Str("queue", qname).
+			Str("type", m.Header.Get("type")).
+			Msg("failed to deserialized message")
+		return
+	}
+	if meta.NumDelivered > 1 {
+		md.Attempt = md.Attempt + int(meta.NumDelivered) - 1
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(b.ackWait / 2)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if err := m.InProgress(); err != nil {
+					log.Error().
+						Err(err).
+						Msgf("error extending the ack deadline of a message on %s", qname)
+				}
+			}
+		}
+	}()
+	if err := handler(msg, md); err != nil {
+		log.Error().
+			Err(err).
+			Str("queue", qname).
+			Str("traceId", md.TraceID).
+			Int("attempt", md.Attempt).
+			Msg("failed to handle message")
+	}
+}
+
+func (b *NATSBroker) PublishTaskProgress(ctx context.Context, t *tork.Task) error {
+	return b.publish(ctx, QUEUE_PROGRESS, t)
+}
+
+func (b *NATSBroker) SubscribeForTaskProgress(handler func(t *tork.Task) error) error {
+	return b.subscribe(QUEUE_PROGRESS, func(msg any, _ Metadata) error {
+		t, ok := msg.(*tork.Task)
+		if !ok {
+			return errors.Errorf("expecting a *tork.Task but got %T", msg)
+		}
+		return handler(t)
+	})
+}
+
+func (b *NATSBroker) PublishHeartbeat(ctx context.Context, n *tork.Node) error {
+	return b.publish(ctx, QUEUE_HEARTBEAT, n)
+}
+
+func (b *NATSBroker) SubscribeForHeartbeats(handler func(n *tork.Node) error) error {
+	return b.subscribe(QUEUE_HEARTBEAT, func(msg any, _ Metadata) error {
+		n, ok := msg.(*tork.Node)
+		if !ok {
+			return errors.Errorf("expecting a *tork.Node but got %T", msg)
+		}
+		return handler(n)
+	})
+}
+
+func (b *NATSBroker) PublishJob(ctx context.Context, j *tork.Job) error {
+	return b.publish(ctx, QUEUE_JOBS, j)
+}
+
+func (b *NATSBroker) SubscribeForJobs(handler func(j *tork.Job) error) error {
+	return b.subscribe(QUEUE_JOBS, func(msg any, _ Metadata) error {
+		j, ok := msg.(*tork.Job)
+		if !ok {
+			return errors.Errorf("expecting a *tork.Job but got %T", msg)
+		}
+		return handler(j)
+	})
+}
+
+func (b *NATSBroker) PublishEvent(ctx context.Context, topic string, event any) error {
+	if b.isShuttingDown() {
+		return errors.New("broker is shutting down")
+	}
+	m, _, err := b.message(ctx, b.eventsSubject(), event)
+	if err != nil {
+		return err
+	}
+	m.Header.Set("topic", topic)
+	if err := b.nc.PublishMsg(m); err != nil {
+		return errors.Wrapf(err, "unable to publish event")
+	}
+	return nil
+}
+
+// SubscribeForEvents receives the events published while
+// subscribed, like an exclusive queue of the other brokers.
+func (b *NATSBroker) SubscribeForEvents(ctx context.Context, pattern string, handler func(event any)) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.shuttingDown {
+		return errors.New("broker is shutting down")
+	}
+	sub, err := b.nc.Subscribe(b.eventsSubject(), func(m *nats.Msg) {
+		if !wildcard.Match(pattern, m.Header.Get("topic")) {
+			return
+		}
+		ev, _, err := open(m.Header.Get("type"), m.Data)
+		if err != nil {
+			log.Error().
+				Err(err).
+				Str("type", m.Header.Get("type")).
+				Msg("failed to deserialized event")
+			return
+		}
+		handler(ev)
+	})
+	if err != nil {
+		return errors.Wrapf(err, "error subscribing for events")
+	}
+	done := make(chan struct{})
+	close(done)
+	b.subscriptions = append(b.subscriptions, &natsSubscription{
+		qname: b.eventsSubject(),
+		sub:   sub,
+		done:  done,
+	})
+	return nil
+}
+
+func (b *NATSBroker) PublishTaskLogPart(ctx context.Context, p *tork.TaskLogPart) error {
+	return b.publish(ctx, QUEUE_LOGS, p)
+}
+
+func (b *NATSBroker) SubscribeForTaskLogPart(handler func(p *tork.TaskLogPart)) error {
+	return b.subscribe(QUEUE_LOGS, func(msg any, _ Metadata) error {
+		p, ok := msg.(*tork.TaskLogPart)
+		if !ok {
+			return errors.Errorf("expecting a *tork.TaskLogPart but got %T", msg)
+		}
+		handler(p)
+		return nil
+	})
+}
+
+func (b *NATSBroker) HealthCheck(ctx context.Context) error {
+	if b.isShuttingDown() {
+		return errors.New("broker is shutting down")
+	}
+	if status := b.nc.Status(); status != nats.CONNECTED {
+		return errors.Errorf("NATS connection is %s", status)
+	}
+	if _, err := b.js.AccountInfo(nats.Context(ctx)); err != nil {
+		return errors.Wrapf(err, "error getting the JetStream account info")
+	}
+	if n := atomic.LoadInt32(&b.failing); n > 0 {
+		return errors.Errorf("%d subscription(s) fail to fetch messages", n)
+	}
+	return nil
+}
+
+func (b *NATSBroker) isShuttingDown() bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.shuttingDown
+}
+
+func (b *NATSBroker) Shutdown(ctx context.Context) error {
+	// when running in standalone mode both the coordinator
+	// and the worker will attempt to shutdown the broker
+	b.mu.Lock()
+	if b.shuttingDown {
+		b.mu.Unlock()
+		return nil
+	}
+	b.shuttingDown = true
+	subs := b.subscriptions
+	b.subscriptions = []*natsSubscription{}
+	b.mu.Unlock()
+	// stop fetching messages
+	b.cancel()
+	// let the coordinator's subscribers finish
+	// handling the message they're processing
+	for _, sub := range subs {
+		if !IsCoordinatorQueue(sub.qname) {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+		case <-sub.done:
+		}
+	}
+	// the consumers are bound, not owned, by the
+	// subscriptions so they outlive them
+	for _, sub := range subs {
+		if err := sub.sub.Unsubscribe(); err != nil && !errors.Is(err, nats.ErrConnectionClosed) {
+			log.Error().
+				Err(err).
+				Msgf("error unsubscribing from %s", sub.qname)
+		}
+	}
+	b.nc.Close()
+	return nil
+}
@@ -0,0 +1,178 @@
+package mq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/runabol/tork"
+	"github.com/runabol/tork/internal/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+const natsTestURL = "nats://localhost:4222"
+
+func TestNATSName(t *testing.T) {
+	assert.Equal(t, "default", natsName("default"))
+	assert.Equal(t, "x-abc", natsName("x-abc"))
+	assert.Equal(t, "tags-disk=ssd,region=eu", natsName("tags-disk=ssd,region=eu"))
+	assert.Equal(t, "tags-tork%2Eplatform=linux-amd64", natsName("tags-tork.platform=linux-amd64"))
+	assert.Equal(t, "a%20b%2A%3E", natsName("a b*>"))
+}
+
+func TestNATSInvalidStream(t *testing.T) {
+	_, err := NewNATSBroker(natsTestURL, WithNATSStream("tork.queues"))
+	assert.Error(t, err)
+}
+
+func TestNATSPublishAndSubsribeForTask(t *testing.T) {
+	ctx := context.Background()
+	b, err := NewNATSBroker(natsTestURL)
+	assert.NoError(t, err)
+	processed := make(chan any)
+	qname := fmt.Sprintf("%stest-%s", QUEUE_EXCLUSIVE_PREFIX, uuid.NewUUID())
+	err = b.SubscribeForTasks(qname, func(t *tork.Task) error {
+		processed <- 1
+		return nil
+	})
+	assert.NoError(t, err)
+	err = b.PublishTask(ctx, qname, &tork.Task{})
+	assert.NoError(t, err)
+	<-processed
+	assert.NoError(t, b.Shutdown(ctx))
+}
+
+func TestNATSConsumerGroup(t *testing.T) {
+	ctx := context.Background()
+	b1, err := NewNATSBroker(natsTestURL)
+	assert.NoError(t, err)
+	b2, err := NewNATSBroker(natsTestURL)
+	assert.NoError(t, err)
+	qname := fmt.Sprintf("%stest-%s", QUEUE_EXCLUSIVE_PREFIX, uuid.NewUUID())
+	processed := make(chan string, 10)
+	for _, b := range []*NATSBroker{b1, b2} {
+		err = b.SubscribeForTasks(qname, func(t *tork.Task) error {
+			processed <- t.ID
+			return nil
+		})
+		assert.NoError(t, err)
+	}
+	for i := 0; i < 10; i++ {
+		assert.NoError(t, b1.PublishTask(ctx, qname, &tork.Task{ID: uuid.NewUUID()}))
+	}
+	// every task is handled by one of the subscribers
+	seen := make(map[string]bool)
+	for i := 0; i < 10; i++ {
+		id := <-processed
+		assert.False(t, seen[id])
+		seen[id] = true
+	}
+	select {
+	case id := <-processed:
+		t.Fatalf("task %s was delivered twice", id)
+	case <-time.After(time.Millisecond * 500):
+	}
+	assert.NoError(t, b1.Shutdown(ctx))
+	assert.NoError(t, b2.Shutdown(ctx))
+}
+
+func TestNATSDeadLetterTask(t *testing.T) {
+	ctx := context.Background()
+	b, err := NewNATSBroker(natsTestURL)
+	assert.NoError(t, err)
+	qname := fmt.Sprintf("%stest-%s", QUEUE_EXCLUSIVE_PREFIX, uuid.NewUUID())
+	err = b.SubscribeForTasks(qname, func(t *tork.Task) error {
+		return errors.New("something bad happened")
+	})
+	assert.NoError(t, err)
+	t1 := &tork.Task{ID: uuid.NewUUID()}
+	deadLettered := make(chan *tork.DeadLetter)
+	err = b.SubscribeForDeadLetters(func(dl *tork.DeadLetter) error {
+		if dl.Task.ID == t1.ID {
+			deadLettered <- dl
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	err = b.PublishTask(ctx, qname, t1)
+	assert.NoError(t, err)
+	dl := <-deadLettered
+	assert.Equal(t, "something bad happened", dl.Error)
+	assert.Equal(t, qname, dl.Queue)
+	assert.Equal(t, DEFAULT_MAX_DELIVERIES, dl.Attempts)
+}
+
+func TestNATSGetQueues(t *testing.T) {
+	ctx := context.Background()
+	b, err := NewNATSBroker(natsTestURL)
+	assert.NoError(t, err)
+	qname := fmt.Sprintf("%stest-%s", QUEUE_EXCLUSIVE_PREFIX, uuid.NewUUID())
+	err = b.SubscribeForTasks(qname, func(t *tork.Task) error {
+		return nil
+	})
+	assert.NoError(t, err)
+	qis, err := b.Queues(ctx)
+	assert.NoError(t, err)
+	found := false
+	for _, qi := range qis {
+		if qi.Name == qname {
+			found = true
+			assert.Equal(t, 1, qi.Subscribers)
+		}
+	}
+	assert.True(t, found)
+}
+
+func TestNATSPublishAndSubsribeForEvent(t *testing.T) {
+	ctx := context.Background()
+	b, err := NewNATSBroker(natsTestURL)
+	assert.NoError(t, err)
+	processed := make(chan *tork.Job, 1)
+	err = b.SubscribeForEvents(ctx, TOPIC_JOB, func(event any) {
+		j, ok := event.(*tork.Job)
+		assert.True(t, ok)
+		processed <- j
+	})
+	assert.NoError(t, err)
+	j1 := &tork.Job{ID: uuid.NewUUID(), State: tork.JobStateCompleted}
+	err = b.PublishEvent(ctx, "job.completed", j1)
+	assert.NoError(t, err)
+	// events of other topics are not received
+	err = b.PublishEvent(ctx, "node.created", &tork.Job{ID: uuid.NewUUID()})
+	assert.NoError(t, err)
+	j2 := <-processed
+	assert.Equal(t, j1.ID, j2.ID)
+	select {
+	case j := <-processed:
+		t.Fatalf("unexpected event for job %s", j.ID)
+	case <-time.After(time.Millisecond * 200):
+	}
+}
+
+func TestNATSHealthCheck(t *testing.T) {
+	ctx := context.Background()
+	b, err := NewNATSBroker(natsTestURL)
+	assert.NoError(t, err)
+	assert.NoError(t, b.HealthCheck(ctx))
+	assert.NoError(t, b.Shutdown(ctx))
+	assert.Error(t, b.HealthCheck(ctx))
+}
+
+func TestNATSShutdown(t *testing.T) {
+	ctx := context.Background()
+	b, err := NewNATSBroker(natsTestURL)
+	assert.NoError(t, err)
+	mu := make(chan int)
+	err = b.SubscribeForJobs(func(j *tork.Job) error {
+		mu <- 1
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, b.PublishJob(ctx, &tork.Job{}))
+	<-mu
+	assert.NoError(t, b.Shutdown(ctx))
+	// publishing after shutdown fails
+	assert.Error(t, b.PublishJob(ctx, &tork.Job{}))
+}
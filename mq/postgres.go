@@ -0,0 +1,696 @@
+package mq
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/runabol/tork"
+	"github.com/runabol/tork/internal/syncx"
+	"github.com/runabol/tork/internal/uuid"
+	"github.com/runabol/tork/internal/wildcard"
+)
+
+const (
+	POSTGRES_DEFAULT_POLL_INTERVAL = time.Second
+)
+
+const (
+	// the channel on which the name of the queue
+	// is notified when a message is published to it
+	pgChannelMessages = "tork_mq_messages"
+	// the channel on which the id of a published event
+	// is notified to the broker's listeners
+	pgChannelEvents = "tork_mq_events"
+	// how long events are retained for listeners to fetch them
+	pgEventsRetention = time.Minute
+)
+
+// how long a received message is hidden from the other subscribers
+// before it's delivered again, unless its lease is extended
+var pgMessageLease = time.Minute
+
+// PostgresBroker is an implementation of the Broker interface which
+// stores messages in Postgres. Subscribers poll their queue using
+// SELECT ... FOR UPDATE SKIP LOCKED and are woken up early through
+// LISTEN/NOTIFY. A received message is leased to its subscriber, which
+// hides it from the others until the lease is over, and is deleted
+// when the handler returns. The lease is extended while the message
+// is being handled, so a message whose subscriber dies is delivered
+// again once its lease expires. Meant for installations which already use
+// Postgres for their datastore and don't want to run a separate
+// message queue. Messages are stored as jsonb and are therefore
+// always encoded with the JSON codec.
+type PostgresBroker struct {
+	db            *sqlx.DB
+	listener      *pq.Listener
+	pollInterval  time.Duration
+	conn          ConnectionConfig
+	subscriptions []*pgsubscription
+	queues        *syncx.Map[string, []*pgsubscription]
+	events        *syncx.Map[string, *pgevents]
+	mu            sync.RWMutex
+	shuttingDown  bool
+	stop          chan struct{}
+	maxDeliveries int
+	// set while the LISTEN connection is down
+	listenerDown atomic.Bool
+	// the number of subscriptions which
+	// fail to receive their messages
+	failing int32
+}
+
+type pgsubscription struct {
+	qname string
+	wake  chan struct{}
+	done  chan struct{}
+	// closed by UnsubscribeForTasks
+	stop chan struct{}
+}
+
+type pgevents struct {
+	pattern string
+	handler func(event any)
+}
+
+type pgmessage struct {
+	ID   string `db:"id"`
+	Type string `db:"type_"`
+	Body []byte `db:"body"`
+}
+
+type PostgresOption = func(b *PostgresBroker)
+
+// WithPollInterval sets how often subscribers check their queue
+// for messages when no notification was received. Default: 1 second
+func WithPollInterval(interval time.Duration) PostgresOption {
+	return func(b *PostgresBroker) {
+		b.pollInterval = interval
+	}
+}
+
+// WithPostgresConnection sets the DSN, TLS and credentials
+// used to connect to Postgres. The token, when set, is used as
+// the password (e.g. a cloud IAM authentication token).
+func WithPostgresConnection(cfg ConnectionConfig) PostgresOption {
+	return func(b *PostgresBroker) {
+		b.conn = cfg
+	}
+}
+
+// WithPostgresMaxDeliveries sets the number of times a task message
+// is delivered before it's dead-lettered. Default: DEFAULT_MAX_DELIVERIES
+func WithPostgresMaxDeliveries(n int) PostgresOption {
+	return func(b *PostgresBroker) {
+		b.maxDeliveries = n
+	}
+}
+
+func NewPostgresBroker(dsn string, opts ...PostgresOption) (*PostgresBroker, error) {
+	b := &PostgresBroker{
+		pollInterval:  POSTGRES_DEFAULT_POLL_INTERVAL,
+		subscriptions: make([]*pgsubscription, 0),
+		queues:        new(syncx.Map[string, []*pgsubscription]),
+		events:        new(syncx.Map[string, *pgevents]),
+		stop:          make(chan struct{}),
+	}
+	for _, o := range opts {
+		o(b)
+	}
+	dsn, err := postgresDSN(dsn, b.conn)
+	if err != nil {
+		return nil, err
+	}
+	db, err := sqlx.Connect("postgres", dsn)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to connect to postgres")
+	}
+	b.db = db
+	b.listener = pq.NewListener(dsn, time.Second, maxReconnectBackoff, func(ev pq.ListenerEventType, err error) {
+		switch ev {
+		case pq.ListenerEventDisconnected:
+			log.Warn().Err(err).Msg("postgres broker listener disconnected. reconnecting")
+			b.listenerDown.Store(true)
+		case pq.ListenerEventConnectionAttemptFailed:
+			log.Error().Err(err).Msg("error reconnecting the postgres broker listener")
+		case pq.ListenerEventReconnected:
+			log.Info().Msg("postgres broker listener reconnected")
+			b.listenerDown.Store(false)
+		}
+	})
+	if err := b.listener.Listen(pgChannelMessages); err != nil {
+		return nil, errors.Wrapf(err, "error listening on %s", pgChannelMessages)
+	}
+	if err := b.listener.Listen(pgChannelEvents); err != nil {
+		return nil, errors.Wrapf(err, "error listening on %s", pgChannelEvents)
+	}
+	go b.listen()
+	go b.cleanupProcess()
+	return b, nil
+}
+
+// postgresDSN applies the connection settings to the DSN,
+// converting URL-style DSNs to the key/value form first.
+func postgresDSN(dsn string, c ConnectionConfig) (string, error) {
+	if c.URL != "" {
+		dsn = c.URL
+	}
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		kv, err := pq.ParseURL(dsn)
+		if err != nil {
+			return "", errors.Wrapf(err, "invalid postgres url")
+		}
+		dsn = kv
+	}
+	params := make([]string, 0)
+	if c.Username != "" {
+		params = append(params, "user="+pgQuote(c.Username))
+	}
+	if c.secret() != "" {
+		params = append(params, "password="+pgQuote(c.secret()))
+	}
+	switch {
+	case c.CACert != "":
+		params = append(params, "sslmode=verify-full", "sslrootcert="+pgQuote(c.CACert))
+	case c.HasTLS():
+		params = append(params, "sslmode=require")
+	}
+	if c.Cert != "" || c.Key != "" {
+		if c.Cert == "" || c.Key == "" {
+			return "", errors.New("both the client certificate and key are required")
+		}
+		params = append(params, "sslcert="+pgQuote(c.Cert), "sslkey="+pgQuote(c.Key))
+	}
+	if len(params) == 0 {
+		return dsn, nil
+	}
+	// later parameters take precedence over earlier ones
+	return strings.TrimSpace(dsn + " " + strings.Join(params, " ")), nil
+}
+
+func pgQuote(v string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(v) + "'"
+}
+
+// listen dispatches the notifications received from
+// Postgres to the subscribers they concern.
+func (b *PostgresBroker) listen() {
+	for {
+		select {
+		case <-b.stop:
+			return
+		case n := <-b.listener.Notify:
+			// a nil notification means the connection was
+			// re-established and notifications may have been lost
+			if n == nil {
+				b.queues.Iterate(func(_ string, subs []*pgsubscription) {
+					for _, sub := range subs {
+						sub.notify()
+					}
+				})
+				continue
+			}
+			switch n.Channel {
+			case pgChannelMessages:
+				subs, _ := b.queues.Get(n.Extra)
+				for _, sub := range subs {
+					sub.notify()
+				}
+			case pgChannelEvents:
+				b.dispatchEvent(n.Extra)
+			}
+		}
+	}
+}
+
+func (s *pgsubscription) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (b *PostgresBroker) dispatchEvent(id string) {
+	subscribed := false
+	b.events.Iterate(func(_ string, _ *pgevents) {
+		subscribed = true
+	})
+	if !subscribed {
+		return
+	}
+	ev := struct {
+		Topic string `db:"topic"`
+		Type  string `db:"type_"`
+		Body  []byte `db:"body"`
+	}{}
+	if err := b.db.Get(&ev, `SELECT topic, type_, body FROM mq_events WHERE id = $1`, id); err != nil {
+		log.Error().Err(err).Msgf("error fetching event %s", id)
+		return
+	}
+	msg, _, err := open(ev.Type, ev.Body)
+	if err != nil {
+		log.Error().Err(err).Msgf("failed to deserialize event %s", id)
+		return
+	}
+	b.events.Iterate(func(_ string, e *pgevents) {
+		if wildcard.Match(e.pattern, ev.Topic) {
+			e.handler(msg)
+		}
+	})
+}
+
+func (b *PostgresBroker) cleanupProcess() {
+	ticker := time.NewTicker(pgEventsRetention)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-b.stop:
+			return
+		case <-ticker.C:
+			if err := b.cleanup(); err != nil {
+				log.Error().Err(err).Msg("error expunging expired messages")
+			}
+		}
+	}
+}
+
+func (b *PostgresBroker) cleanup() error {
+	if _, err := b.db.Exec(`DELETE FROM mq_events WHERE created_at < $1`, time.Now().UTC().Add(-pgEventsRetention)); err != nil {
+		return errors.Wrapf(err, "error expunging expired events")
+	}
+	if _, err := b.db.Exec(`DELETE FROM mq_messages WHERE expires_at < $1`, time.Now().UTC()); err != nil {
+		return errors.Wrapf(err, "error expunging expired messages")
+	}
+	return nil
+}
+
+func (b *PostgresBroker) Queues(ctx context.Context) ([]QueueInfo, error) {
+	rows := []struct {
+		Queue string `db:"queue"`
+		Size  int    `db:"size"`
+	}{}
+	q := `SELECT queue, count(*) as size FROM mq_messages GROUP BY queue ORDER BY queue`
+	if err := b.db.SelectContext(ctx, &rows, q); err != nil {
+		return nil, errors.Wrapf(err, "error getting queues from the db")
+	}
+	sizes := make(map[string]int)
+	for _, r := range rows {
+		sizes[r.Queue] = r.Size
+	}
+	qis := make([]QueueInfo, 0)
+	b.queues.Iterate(func(qname string, subs []*pgsubscription) {
+		qis = append(qis, QueueInfo{
+			Name:        qname,
+			Size:        sizes[qname],
+			Subscribers: len(subs),
+		})
+		delete(sizes, qname)
+	})
+	// queues with messages but no subscribers in this process
+	for _, r := range rows {
+		if _, ok := sizes[r.Queue]; ok {
+			qis = append(qis, QueueInfo{Name: r.Queue, Size: r.Size})
+		}
+	}
+	return qis, nil
+}
+
+func (b *PostgresBroker) PublishTask(ctx context.Context, qname string, t *tork.Task) error {
+	return b.publish(ctx, qname, t)
+}
+
+func (b *PostgresBroker) SubscribeForTasks(qname string, handler func(t *tork.Task) error) error {
+	return b.subscribe(qname, func(msg any, md Metadata) error {
+		t, ok := msg.(*tork.Task)
+		if !ok {
+			return errors.Errorf("expecting a *tork.Task but got %T", msg)
+		}
+		return deliverTask(qname, t, md.Attempt, b.maxDeliveries, handler,
+			func(t *tork.Task, attempt int) error {
+				md.Attempt = attempt
+				return b.publish(WithMetadata(context.Background(), md), qname, t)
+			},
+			func(dl *tork.DeadLetter) error {
+				return b.publish(context.Background(), QUEUE_DEAD_LETTER, dl)
+			})
+	})
+}
+
+func (b *PostgresBroker) SubscribeForDeadLetters(handler func(dl *tork.DeadLetter) error) error {
+	return b.subscribe(QUEUE_DEAD_LETTER, func(msg any, _ Metadata) error {
+		dl, ok := asDeadLetter(msg)
+		if !ok {
+			return errors.Errorf("expecting a *tork.DeadLetter but got %T", msg)
+		}
+		return handler(dl)
+	})
+}
+
+func (b *PostgresBroker) publish(ctx context.Context, qname string, msg any) error {
+	var priority int
+	if t, ok := msg.(*tork.Task); ok {
+		priority = t.Priority
+	}
+	body, md, err := seal(ctx, JSONCodec, msg)
+	if err != nil {
+		return err
+	}
+	// heartbeats are only relevant for a short while
+	var expiresAt *time.Time
+	if qname == QUEUE_HEARTBEAT {
+		exp := time.Now().UTC().Add(time.Millisecond * defaultHeartbeatTTL)
+		expiresAt = &exp
+	}
+	q := `WITH m AS (
+	        INSERT INTO mq_messages (id,queue,priority,type_,body,created_at,expires_at)
+	        VALUES ($1,$2,$3,$4,$5,$6,$7)
+	        RETURNING queue
+	      )
+	      SELECT pg_notify($8, queue) FROM m`
+	if _, err := b.db.ExecContext(ctx, q,
+		uuid.NewUUID(),
+		qname,
+		priority,
+		md.Type,
+		string(body),
+		*md.CreatedAt,
+		expiresAt,
+		pgChannelMessages,
+	); err != nil {
+		return errors.Wrapf(err, "unable to publish message")
+	}
+	return nil
+}
+
+func (b *PostgresBroker) subscribe(qname string, handler func(msg any, md Metadata) error) error {
+	if b.isShuttingDown() {
+		return errors.New("broker is shutting down")
+	}
+	log.Debug().Msgf("subscribing for messages on %s", qname)
+	sub := &pgsubscription{
+		qname: qname,
+		wake:  make(chan struct{}, 1),
+		done:  make(chan struct{}),
+		stop:  make(chan struct{}),
+	}
+	b.mu.Lock()
+	b.subscriptions = append(b.subscriptions, sub)
+	subs, _ := b.queues.Get(qname)
+	b.queues.Set(qname, append(subs, sub))
+	b.mu.Unlock()
+	go func() {
+		defer close(sub.done)
+		ticker := time.NewTicker(b.pollInterval)
+		defer ticker.Stop()
+		failures := 0
+		for {
+			// drain the queue before waiting
+			for !b.isShuttingDown() && !sub.stopped() {
+				ok, err := b.receive(qname, handler)
+				if err != nil {
+					failures++
+					if failures == 1 {
+						atomic.AddInt32(&b.failing, 1)
+					}
+					log.Error().
+						Err(err).
+						Str("queue", qname).
+						Msgf("error receiving message (attempt %d)", failures)
+					break
+				}
+				if failures > 0 {
+					log.Info().Msgf("resumed receiving messages on %s", qname)
+					atomic.AddInt32(&b.failing, -1)
+					failures = 0
+				}
+				if !ok {
+					break
+				}
+			}
+			wait := ticker.C
+			if failures > 0 {
+				wait = time.After(reconnectBackoff(failures))
+			}
+			select {
+			case <-b.stop:
+				if failures > 0 {
+					atomic.AddInt32(&b.failing, -1)
+				}
+				return
+			case <-sub.stop:
+				if failures > 0 {
+					atomic.AddInt32(&b.failing, -1)
+				}
+				return
+			case <-sub.wake:
+			case <-wait:
+			}
+		}
+	}()
+	return nil
+}
+
+func (sub *pgsubscription) stopped() bool {
+	select {
+	case <-sub.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+func (b *PostgresBroker) UnsubscribeForTasks(qname string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	remaining := make([]*pgsubscription, 0, len(b.subscriptions))
+	for _, sub := range b.subscriptions {
+		if sub.qname == qname {
+			close(sub.stop)
+		} else {
+			remaining = append(remaining, sub)
+		}
+	}
+	b.subscriptions = remaining
+	b.queues.Set(qname, nil)
+	return nil
+}
+
+// receive handles the next message on the queue, if there's one.
+// The message is leased for the duration of the handler, without
+// holding a transaction open, and then deleted, whether it was
+// handled successfully or not.
+func (b *PostgresBroker) receive(qname string, handler func(msg any, md Metadata) error) (bool, error) {
+	m := pgmessage{}
+	q := `UPDATE mq_messages SET visible_at = now() + make_interval(secs => $2)
+	      WHERE id = (
+	        SELECT id
+	        FROM mq_messages
+	        WHERE queue = $1
+	          AND (expires_at IS NULL OR expires_at > $3)
+	          AND (visible_at IS NULL OR visible_at <= now())
+	        ORDER BY priority DESC, created_at ASC
+	        FOR UPDATE SKIP LOCKED
+	        LIMIT 1
+	      )
+	      RETURNING id, type_, body`
+	if err := b.db.Get(&m, q, qname, pgMessageLease.Seconds(), time.Now().UTC()); err != nil {
+		if err == sql.ErrNoRows {
+			return false, nil
+		}
+		return false, errors.Wrapf(err, "error fetching message from the db")
+	}
+	stop := make(chan struct{})
+	extended := make(chan struct{})
+	go func() {
+		defer close(extended)
+		b.extendLease(m.ID, stop)
+	}()
+	if msg, md, err := open(m.Type, m.Body); err != nil {
+		log.Error().
+			Err(err).
+			Str("queue", qname).
+			Str("body", string(m.Body)).
+			Str("type", m.Type).
+			Msg("failed to deserialized message")
+	} else if err := handler(msg, md); err != nil {
+		log.Error().
+			Err(err).
+			Str("queue", qname).
+			Str("body", string(m.Body)).
+			Str("traceId", md.TraceID).
+			Msg("failed to handle message")
+	}
+	close(stop)
+	<-extended
+	if _, err := b.db.Exec(`DELETE FROM mq_messages WHERE id = $1`, m.ID); err != nil {
+		return false, errors.Wrapf(err, "error deleting message from the db")
+	}
+	return true, nil
+}
+
+// extendLease keeps the message hidden from the
+// other subscribers until stop is closed.
+func (b *PostgresBroker) extendLease(id string, stop <-chan struct{}) {
+	ticker := time.NewTicker(pgMessageLease / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if _, err := b.db.Exec(`UPDATE mq_messages SET visible_at = now() + make_interval(secs => $2) WHERE id = $1`,
+				id, pgMessageLease.Seconds()); err != nil {
+				log.Error().Err(err).Msgf("error extending the lease of message %s", id)
+			}
+		}
+	}
+}
+
+func (b *PostgresBroker) PublishTaskProgress(ctx context.Context, t *tork.Task) error {
+	return b.publish(ctx, QUEUE_PROGRESS, t)
+}
+
+func (b *PostgresBroker) SubscribeForTaskProgress(handler func(t *tork.Task) error) error {
+	return b.subscribe(QUEUE_PROGRESS, func(msg any, _ Metadata) error {
+		t, ok := msg.(*tork.Task)
+		if !ok {
+			return errors.Errorf("expecting a *tork.Task but got %T", msg)
+		}
+		return handler(t)
+	})
+}
+
+func (b *PostgresBroker) PublishHeartbeat(ctx context.Context, n *tork.Node) error {
+	return b.publish(ctx, QUEUE_HEARTBEAT, n)
+}
+
+func (b *PostgresBroker) SubscribeForHeartbeats(handler func(n *tork.Node) error) error {
+	return b.subscribe(QUEUE_HEARTBEAT, func(msg any, _ Metadata) error {
+		n, ok := msg.(*tork.Node)
+		if !ok {
+			return errors.Errorf("expecting a *tork.Node but got %T", msg)
+		}
+		return handler(n)
+	})
+}
+
+func (b *PostgresBroker) PublishJob(ctx context.Context, j *tork.Job) error {
+	return b.publish(ctx, QUEUE_JOBS, j)
+}
+
+func (b *PostgresBroker) SubscribeForJobs(handler func(j *tork.Job) error) error {
+	return b.subscribe(QUEUE_JOBS, func(msg any, _ Metadata) error {
+		j, ok := msg.(*tork.Job)
+		if !ok {
+			return errors.Errorf("expecting a *tork.Job but got %T", msg)
+		}
+		return handler(j)
+	})
+}
+
+func (b *PostgresBroker) PublishEvent(ctx context.Context, topic string, event any) error {
+	body, md, err := seal(ctx, JSONCodec, event)
+	if err != nil {
+		return err
+	}
+	q := `WITH e AS (
+	        INSERT INTO mq_events (id,topic,type_,body,created_at)
+	        VALUES ($1,$2,$3,$4,$5)
+	        RETURNING id
+	      )
+	      SELECT pg_notify($6, id) FROM e`
+	if _, err := b.db.ExecContext(ctx, q,
+		uuid.NewUUID(),
+		topic,
+		md.Type,
+		string(body),
+		*md.CreatedAt,
+		pgChannelEvents,
+	); err != nil {
+		return errors.Wrapf(err, "unable to publish event")
+	}
+	return nil
+}
+
+func (b *PostgresBroker) SubscribeForEvents(ctx context.Context, pattern string, handler func(event any)) error {
+	log.Debug().Msgf("subscribing for events on %s", pattern)
+	b.events.Set(uuid.NewUUID(), &pgevents{
+		pattern: pattern,
+		handler: handler,
+	})
+	return nil
+}
+
+func (b *PostgresBroker) PublishTaskLogPart(ctx context.Context, p *tork.TaskLogPart) error {
+	return b.publish(ctx, QUEUE_LOGS, p)
+}
+
+func (b *PostgresBroker) SubscribeForTaskLogPart(handler func(p *tork.TaskLogPart)) error {
+	return b.subscribe(QUEUE_LOGS, func(msg any, _ Metadata) error {
+		p, ok := msg.(*tork.TaskLogPart)
+		if !ok {
+			return errors.Errorf("expecting a *tork.TaskLogPart but got %T", msg)
+		}
+		handler(p)
+		return nil
+	})
+}
+
+func (b *PostgresBroker) HealthCheck(ctx context.Context) error {
+	if b.isShuttingDown() {
+		return errors.New("broker is shutting down")
+	}
+	if err := b.db.PingContext(ctx); err != nil {
+		return errors.Wrapf(err, "error pinging postgres")
+	}
+	if b.listenerDown.Load() {
+		return errors.New("postgres broker listener is reconnecting")
+	}
+	if n := atomic.LoadInt32(&b.failing); n > 0 {
+		return errors.Errorf("%d subscription(s) fail to receive messages", n)
+	}
+	return nil
+}
+
+func (b *PostgresBroker) isShuttingDown() bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.shuttingDown
+}
+
+func (b *PostgresBroker) Shutdown(ctx context.Context) error {
+	// when running in standalone mode both the coordinator
+	// and the worker will attempt to shutdown the broker
+	b.mu.Lock()
+	if b.shuttingDown {
+		b.mu.Unlock()
+		return nil
+	}
+	b.shuttingDown = true
+	close(b.stop)
+	subs := b.subscriptions
+	b.subscriptions = []*pgsubscription{}
+	b.mu.Unlock()
+	// let the coordinator's subscribers finish
+	// handling the message they're processing
+	for _, sub := range subs {
+		if !IsCoordinatorQueue(sub.qname) {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+		case <-sub.done:
+		}
+	}
+	if err := b.listener.Close(); err != nil {
+		log.Error().Err(err).Msg("error closing postgres listener")
+	}
+	return b.db.Close()
+}
@@ -0,0 +1,143 @@
+package mq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/runabol/tork"
+	"github.com/runabol/tork/internal/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+const testPostgresDSN = "host=localhost user=tork password=tork dbname=tork port=5432 sslmode=disable"
+
+func TestPostgresPublishAndSubsribeForTask(t *testing.T) {
+	ctx := context.Background()
+	b, err := NewPostgresBroker(testPostgresDSN)
+	assert.NoError(t, err)
+	processed := make(chan any)
+	qname := fmt.Sprintf("%stest-%s", QUEUE_EXCLUSIVE_PREFIX, uuid.NewUUID())
+	err = b.SubscribeForTasks(qname, func(t *tork.Task) error {
+		processed <- 1
+		return nil
+	})
+	assert.NoError(t, err)
+	err = b.PublishTask(ctx, qname, &tork.Task{})
+	assert.NoError(t, err)
+	<-processed
+	assert.NoError(t, b.Shutdown(ctx))
+}
+
+func TestPostgresTaskPriority(t *testing.T) {
+	ctx := context.Background()
+	b, err := NewPostgresBroker(testPostgresDSN)
+	assert.NoError(t, err)
+	qname := fmt.Sprintf("%stest-%s", QUEUE_EXCLUSIVE_PREFIX, uuid.NewUUID())
+	for i := 0; i < 3; i++ {
+		err = b.PublishTask(ctx, qname, &tork.Task{Priority: i})
+		assert.NoError(t, err)
+	}
+	processed := make(chan int, 3)
+	err = b.SubscribeForTasks(qname, func(t *tork.Task) error {
+		processed <- t.Priority
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, <-processed)
+	assert.Equal(t, 1, <-processed)
+	assert.Equal(t, 0, <-processed)
+	assert.NoError(t, b.Shutdown(ctx))
+}
+
+func TestPostgresDeadLetterTask(t *testing.T) {
+	ctx := context.Background()
+	b, err := NewPostgresBroker(testPostgresDSN)
+	assert.NoError(t, err)
+	qname := fmt.Sprintf("%stest-%s", QUEUE_EXCLUSIVE_PREFIX, uuid.NewUUID())
+	err = b.SubscribeForTasks(qname, func(t *tork.Task) error {
+		return errors.New("something bad happened")
+	})
+	assert.NoError(t, err)
+	t1 := &tork.Task{ID: uuid.NewUUID()}
+	deadLettered := make(chan *tork.DeadLetter)
+	err = b.SubscribeForDeadLetters(func(dl *tork.DeadLetter) error {
+		if dl.Task.ID == t1.ID {
+			deadLettered <- dl
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	err = b.PublishTask(ctx, qname, t1)
+	assert.NoError(t, err)
+	dl := <-deadLettered
+	assert.Equal(t, qname, dl.Queue)
+	assert.Equal(t, "something bad happened", dl.Error)
+	assert.NoError(t, b.Shutdown(ctx))
+}
+
+func TestPostgresMessageLease(t *testing.T) {
+	ctx := context.Background()
+	b, err := NewPostgresBroker(testPostgresDSN)
+	assert.NoError(t, err)
+	defer func() {
+		assert.NoError(t, b.Shutdown(ctx))
+	}()
+	qname := fmt.Sprintf("%stest-%s", QUEUE_EXCLUSIVE_PREFIX, uuid.NewUUID())
+	err = b.PublishTask(ctx, qname, &tork.Task{ID: uuid.NewUUID()})
+	assert.NoError(t, err)
+	// the message is leased while it's being handled
+	ok, err := b.receive(qname, func(msg any, md Metadata) error {
+		var n int
+		err := b.db.Get(&n, `SELECT count(*) FROM mq_messages WHERE queue = $1 AND visible_at > now()`, qname)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, n)
+		// and hidden from the other subscribers
+		ok, err := b.receive(qname, func(msg any, md Metadata) error {
+			return nil
+		})
+		assert.NoError(t, err)
+		assert.False(t, ok)
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	// and deleted once it's handled
+	var n int
+	err = b.db.Get(&n, `SELECT count(*) FROM mq_messages WHERE queue = $1`, qname)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n)
+}
+
+func TestPostgresSubsribeForEvent(t *testing.T) {
+	ctx := context.Background()
+	b, err := NewPostgresBroker(testPostgresDSN)
+	assert.NoError(t, err)
+	j1 := &tork.Job{
+		ID:    uuid.NewUUID(),
+		State: tork.JobStateCompleted,
+	}
+	processed := make(chan any, 2)
+	err = b.SubscribeForEvents(ctx, TOPIC_JOB, func(event any) {
+		j2, ok := event.(*tork.Job)
+		assert.True(t, ok)
+		if j2.ID == j1.ID {
+			processed <- 1
+		}
+	})
+	assert.NoError(t, err)
+	err = b.PublishEvent(ctx, TOPIC_JOB_COMPLETED, j1)
+	assert.NoError(t, err)
+	<-processed
+	assert.NoError(t, b.Shutdown(ctx))
+}
+
+func TestPostgresHealthCheck(t *testing.T) {
+	ctx := context.Background()
+	b, err := NewPostgresBroker(testPostgresDSN)
+	assert.NoError(t, err)
+	assert.NoError(t, b.HealthCheck(ctx))
+	assert.NoError(t, b.Shutdown(ctx))
+	assert.Error(t, b.HealthCheck(ctx))
+}
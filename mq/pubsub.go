@@ -0,0 +1,740 @@
+package mq
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/runabol/tork"
+	"github.com/runabol/tork/internal/gcp"
+	"github.com/runabol/tork/internal/syncx"
+	"github.com/runabol/tork/internal/uuid"
+	"github.com/runabol/tork/internal/wildcard"
+)
+
+const (
+	PUBSUB_DEFAULT_ENDPOINT     = "https://pubsub.googleapis.com"
+	PUBSUB_DEFAULT_ACK_DEADLINE = time.Minute
+	PUBSUB_DEFAULT_PREFIX       = "tork-"
+)
+
+const (
+	// the topic all the events are published to. Each
+	// event subscriber matches their topic on its own.
+	pubsubEventsTopic = "events"
+	// exclusive subscriptions of subscribers that went
+	// away without deleting them expire after this long
+	pubsubSubscriptionTTL = "86400s"
+)
+
+// TokenSource returns an OAuth2 access token
+// used to authenticate with the Pub/Sub API.
+type TokenSource func(ctx context.Context) (string, error)
+
+// PubSubBroker is an implementation of the Broker interface which
+// uses Google Cloud Pub/Sub. Each queue has a topic and a subscription
+// of the same name, shared by all of the queue's subscribers. Pub/Sub
+// doesn't support priorities so tasks are delivered in no particular
+// order. A message's ack deadline is extended for as long as its
+// handler is running.
+type PubSubBroker struct {
+	project       string
+	endpoint      string
+	prefix        string
+	ackDeadline   time.Duration
+	tokenSource   TokenSource
+	conn          ConnectionConfig
+	codec         Codec
+	client        *http.Client
+	declared      *syncx.Map[string, string]
+	subscriptions []*pubsubSubscription
+	mu            sync.RWMutex
+	shuttingDown  bool
+	ctx           context.Context
+	cancel        context.CancelFunc
+	maxDeliveries int
+	// the number of subscriptions which
+	// fail to pull their messages
+	failing int32
+}
+
+type pubsubSubscription struct {
+	qname  string
+	name   string
+	events bool
+	done   chan struct{}
+	// cancelled by UnsubscribeForTasks
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+type pubsubMessage struct {
+	Data        string            `json:"data"`
+	Attributes  map[string]string `json:"attributes,omitempty"`
+	PublishTime time.Time         `json:"publishTime,omitempty"`
+}
+
+type pubsubReceivedMessage struct {
+	AckID   string        `json:"ackId"`
+	Message pubsubMessage `json:"message"`
+	// only set for subscriptions with a dead-letter policy
+	DeliveryAttempt int `json:"deliveryAttempt,omitempty"`
+}
+
+type PubSubOption = func(b *PubSubBroker)
+
+// WithPubSubEndpoint sets the URL of the Pub/Sub API.
+// Default: https://pubsub.googleapis.com
+func WithPubSubEndpoint(endpoint string) PubSubOption {
+	return func(b *PubSubBroker) {
+		b.endpoint = strings.TrimSuffix(endpoint, "/")
+	}
+}
+
+// WithPubSubEmulator points the broker to a Pub/Sub
+// emulator (e.g. localhost:8085), which doesn't
+// require authentication.
+func WithPubSubEmulator(host string) PubSubOption {
+	return func(b *PubSubBroker) {
+		b.endpoint = "http://" + host
+		b.tokenSource = nil
+	}
+}
+
+// WithAckDeadline sets the ack deadline of the subscriptions
+// created by the broker. It must be between 10 seconds and
+// 10 minutes. Default: 1 minute
+func WithAckDeadline(d time.Duration) PubSubOption {
+	return func(b *PubSubBroker) {
+		b.ackDeadline = d
+	}
+}
+
+// WithTopicPrefix sets the prefix of the topics and
+// subscriptions created by the broker. Default: tork-
+func WithTopicPrefix(prefix string) PubSubOption {
+	return func(b *PubSubBroker) {
+		b.prefix = prefix
+	}
+}
+
+// WithTokenSource sets the source of the access tokens used to
+// authenticate with Pub/Sub. Default: the token of the instance's
+// service account, fetched from the metadata server.
+func WithTokenSource(ts TokenSource) PubSubOption {
+	return func(b *PubSubBroker) {
+		b.tokenSource = ts
+	}
+}
+
+// WithPubSubConnection sets the endpoint URL, TLS settings and
+// access token used to connect to Pub/Sub. A token replaces the
+// default token source. Username/password authentication is not
+// supported by Pub/Sub.
+func WithPubSubConnection(cfg ConnectionConfig) PubSubOption {
+	return func(b *PubSubBroker) {
+		b.conn = cfg
+	}
+}
+
+// WithPubSubCodec sets the codec used to encode the published
+// messages. Consumers decode messages with the codec recorded
+// in their envelope. Default: JSON
+func WithPubSubCodec(c Codec) PubSubOption {
+	return func(b *PubSubBroker) {
+		b.codec = c
+	}
+}
+
+// WithPubSubMaxDeliveries sets the number of times a task message
+// is delivered before it's dead-lettered. Default: DEFAULT_MAX_DELIVERIES
+func WithPubSubMaxDeliveries(n int) PubSubOption {
+	return func(b *PubSubBroker) {
+		b.maxDeliveries = n
+	}
+}
+
+func NewPubSubBroker(project string, opts ...PubSubOption) (*PubSubBroker, error) {
+	if project == "" {
+		return nil, errors.New("pubsub project must not be empty")
+	}
+	client := &http.Client{Timeout: time.Second * 90}
+	b := &PubSubBroker{
+		project:       project,
+		endpoint:      PUBSUB_DEFAULT_ENDPOINT,
+		prefix:        PUBSUB_DEFAULT_PREFIX,
+		ackDeadline:   PUBSUB_DEFAULT_ACK_DEADLINE,
+		codec:         JSONCodec,
+		tokenSource:   gcp.MetadataTokenSource(client),
+		client:        client,
+		declared:      new(syncx.Map[string, string]),
+		subscriptions: make([]*pubsubSubscription, 0),
+	}
+	for _, o := range opts {
+		o(b)
+	}
+	if err := b.applyConnection(); err != nil {
+		return nil, err
+	}
+	if b.ackDeadline < time.Second*10 || b.ackDeadline > time.Minute*10 {
+		return nil, errors.Errorf("invalid ack deadline: %s", b.ackDeadline)
+	}
+	b.ctx, b.cancel = context.WithCancel(context.Background())
+	return b, nil
+}
+
+func (b *PubSubBroker) applyConnection() error {
+	if b.conn.Username != "" || b.conn.Password != "" {
+		return errors.New("pubsub does not support username/password authentication")
+	}
+	if b.conn.URL != "" {
+		b.endpoint = strings.TrimSuffix(b.conn.URL, "/")
+	}
+	if b.conn.Token != "" {
+		token := b.conn.Token
+		b.tokenSource = func(context.Context) (string, error) {
+			return token, nil
+		}
+	}
+	tlsConfig, err := b.conn.TLSConfig()
+	if err != nil {
+		return err
+	}
+	if tlsConfig != nil {
+		b.client.Transport = &http.Transport{TLSClientConfig: tlsConfig}
+	}
+	return nil
+}
+
+// call invokes a method of the Pub/Sub API. Responses with
+// an allowed status are not considered an error.
+func (b *PubSubBroker) call(ctx context.Context, method, path string, body, result any, allowed ...int) error {
+	var r io.Reader
+	if body != nil {
+		bs, err := json.Marshal(body)
+		if err != nil {
+			return errors.Wrapf(err, "error serializing request")
+		}
+		r = bytes.NewReader(bs)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, fmt.Sprintf("%s/v1/%s", b.endpoint, path), r)
+	if err != nil {
+		return errors.Wrapf(err, "error building request")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if b.tokenSource != nil {
+		token, err := b.tokenSource(ctx)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return errors.Wrapf(err, "error calling the pubsub API")
+	}
+	defer resp.Body.Close()
+	for _, s := range allowed {
+		if resp.StatusCode == s {
+			return nil
+		}
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return errors.Errorf("pubsub API responded with %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	if result != nil {
+		if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+			return errors.Wrapf(err, "error decoding pubsub API response")
+		}
+	}
+	return nil
+}
+
+// resourceName returns the name of the topic or subscription of
+// the queue. Characters not allowed in Pub/Sub resource names are
+// percent-encoded.
+func (b *PubSubBroker) resourceName(qname string) string {
+	var sb strings.Builder
+	name := b.prefix + qname
+	for i := 0; i < len(name); i++ {
+		switch c := name[i]; {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9',
+			c == '-', c == '_', c == '.', c == '~', c == '+':
+			sb.WriteByte(c)
+		default:
+			fmt.Fprintf(&sb, "%%%02X", c)
+		}
+	}
+	return sb.String()
+}
+
+func (b *PubSubBroker) topicPath(name string) string {
+	return fmt.Sprintf("projects/%s/topics/%s", b.project, name)
+}
+
+func (b *PubSubBroker) subscriptionPath(name string) string {
+	return fmt.Sprintf("projects/%s/subscriptions/%s", b.project, name)
+}
+
+func (b *PubSubBroker) declareTopic(ctx context.Context, name string) error {
+	if _, ok := b.declared.Get(b.topicPath(name)); ok {
+		return nil
+	}
+	log.Debug().Msgf("declaring topic: %s", name)
+	if err := b.call(ctx, http.MethodPut, b.topicPath(name), map[string]any{}, nil, http.StatusConflict); err != nil {
+		return errors.Wrapf(err, "error declaring topic %s", name)
+	}
+	b.declared.Set(b.topicPath(name), name)
+	return nil
+}
+
+func (b *PubSubBroker) declareSubscription(ctx context.Context, topic, name string, ttl string) error {
+	if _, ok := b.declared.Get(b.subscriptionPath(name)); ok {
+		return nil
+	}
+	log.Debug().Msgf("declaring subscription: %s", name)
+	body := map[string]any{
+		"topic":              b.topicPath(topic),
+		"ackDeadlineSeconds": int(b.ackDeadline.Seconds()),
+	}
+	if ttl != "" {
+		body["expirationPolicy"] = map[string]string{"ttl": ttl}
+	}
+	if err := b.call(ctx, http.MethodPut, b.subscriptionPath(name), body, nil, http.StatusConflict); err != nil {
+		return errors.Wrapf(err, "error declaring subscription %s", name)
+	}
+	b.declared.Set(b.subscriptionPath(name), name)
+	return nil
+}
+
+// declareQueue creates the topic and subscription of the queue,
+// so messages published before anyone subscribed are retained.
+func (b *PubSubBroker) declareQueue(ctx context.Context, qname string) error {
+	name := b.resourceName(qname)
+	if err := b.declareTopic(ctx, name); err != nil {
+		return err
+	}
+	var ttl string
+	if strings.HasPrefix(qname, QUEUE_EXCLUSIVE_PREFIX) {
+		ttl = pubsubSubscriptionTTL
+	}
+	return b.declareSubscription(ctx, name, name, ttl)
+}
+
+func (b *PubSubBroker) Queues(ctx context.Context) ([]QueueInfo, error) {
+	subscribers := make(map[string]int)
+	b.mu.RLock()
+	for _, sub := range b.subscriptions {
+		subscribers[sub.name]++
+	}
+	b.mu.RUnlock()
+	qis := make([]QueueInfo, 0)
+	pageToken := ""
+	for {
+		subs := struct {
+			Subscriptions []struct {
+				Name string `json:"name"`
+			} `json:"subscriptions"`
+			NextPageToken string `json:"nextPageToken"`
+		}{}
+		path := fmt.Sprintf("projects/%s/subscriptions?pageSize=1000&pageToken=%s", b.project, url.QueryEscape(pageToken))
+		if err := b.call(ctx, http.MethodGet, path, nil, &subs); err != nil {
+			return nil, errors.Wrapf(err, "error listing subscriptions")
+		}
+		for _, s := range subs.Subscriptions {
+			name := s.Name[strings.LastIndex(s.Name, "/")+1:]
+			if !strings.HasPrefix(name, b.prefix) {
+				continue
+			}
+			qname, err := url.PathUnescape(strings.TrimPrefix(name, b.prefix))
+			if err != nil {
+				qname = name
+			}
+			qis = append(qis, QueueInfo{
+				Name:        qname,
+				Subscribers: subscribers[name],
+			})
+		}
+		if subs.NextPageToken == "" {
+			break
+		}
+		pageToken = subs.NextPageToken
+	}
+	return qis, nil
+}
+
+func (b *PubSubBroker) PublishTask(ctx context.Context, qname string, t *tork.Task) error {
+	return b.publish(ctx, qname, t)
+}
+
+func (b *PubSubBroker) SubscribeForTasks(qname string, handler func(t *tork.Task) error) error {
+	return b.subscribe(qname, func(msg any, md Metadata) error {
+		t, ok := msg.(*tork.Task)
+		if !ok {
+			return errors.Errorf("expecting a *tork.Task but got %T", msg)
+		}
+		return deliverTask(qname, t, md.Attempt, b.maxDeliveries, handler,
+			func(t *tork.Task, attempt int) error {
+				md.Attempt = attempt
+				return b.publish(WithMetadata(context.Background(), md), qname, t)
+			},
+			func(dl *tork.DeadLetter) error {
+				return b.publish(context.Background(), QUEUE_DEAD_LETTER, dl)
+			})
+	})
+}
+
+func (b *PubSubBroker) SubscribeForDeadLetters(handler func(dl *tork.DeadLetter) error) error {
+	return b.subscribe(QUEUE_DEAD_LETTER, func(msg any, _ Metadata) error {
+		dl, ok := asDeadLetter(msg)
+		if !ok {
+			return errors.Errorf("expecting a *tork.DeadLetter but got %T", msg)
+		}
+		return handler(dl)
+	})
+}
+
+func (b *PubSubBroker) publish(ctx context.Context, qname string, msg any) error {
+	if err := b.declareQueue(ctx, qname); err != nil {
+		return err
+	}
+	return b.publishTo(ctx, b.resourceName(qname), msg, nil)
+}
+
+func (b *PubSubBroker) publishTo(ctx context.Context, topic string, msg any, attrs map[string]string) error {
+	if b.isShuttingDown() {
+		return errors.New("broker is shutting down")
+	}
+	body, md, err := seal(ctx, b.codec, msg)
+	if err != nil {
+		return err
+	}
+	if attrs == nil {
+		attrs = make(map[string]string)
+	}
+	attrs["type"] = md.Type
+	req := map[string]any{
+		"messages": []pubsubMessage{{
+			Data:       base64.StdEncoding.EncodeToString(body),
+			Attributes: attrs,
+		}},
+	}
+	if err := b.call(ctx, http.MethodPost, b.topicPath(topic)+":publish", req, nil); err != nil {
+		return errors.Wrapf(err, "unable to publish message")
+	}
+	return nil
+}
+
+func (b *PubSubBroker) subscribe(qname string, handler func(msg any, md Metadata) error) error {
+	if err := b.declareQueue(b.ctx, qname); err != nil {
+		return err
+	}
+	return b.startSubscription(&pubsubSubscription{
+		qname: qname,
+		name:  b.resourceName(qname),
+		done:  make(chan struct{}),
+	}, func(m pubsubReceivedMessage) {
+		// stale heartbeats are dropped
+		if qname == QUEUE_HEARTBEAT && !m.Message.PublishTime.IsZero() &&
+			time.Since(m.Message.PublishTime) > time.Millisecond*defaultHeartbeatTTL {
+			return
+		}
+		msg, md, err := b.decode(m.Message)
+		if err != nil {
+			log.Error().
+				Err(err).
+				Str("queue", qname).
+				Str("type", m.Message.Attributes["type"]).
+				Msg("failed to deserialized message")
+			return
+		}
+		if m.DeliveryAttempt > 1 {
+			md.Attempt = md.Attempt + m.DeliveryAttempt - 1
+		}
+		if err := handler(msg, md); err != nil {
+			log.Error().
+				Err(err).
+				Str("queue", qname).
+				Str("traceId", md.TraceID).
+				Int("attempt", md.Attempt).
+				Msg("failed to handle message")
+		}
+	})
+}
+
+func (b *PubSubBroker) decode(m pubsubMessage) (any, Metadata, error) {
+	body, err := base64.StdEncoding.DecodeString(m.Data)
+	if err != nil {
+		return nil, Metadata{}, errors.Wrapf(err, "error decoding message data")
+	}
+	return open(m.Attributes["type"], body)
+}
+
+func (b *PubSubBroker) startSubscription(sub *pubsubSubscription, handler func(m pubsubReceivedMessage)) error {
+	b.mu.Lock()
+	if b.shuttingDown {
+		b.mu.Unlock()
+		return errors.New("broker is shutting down")
+	}
+	sub.ctx, sub.cancel = context.WithCancel(b.ctx)
+	b.subscriptions = append(b.subscriptions, sub)
+	b.mu.Unlock()
+	log.Debug().Msgf("subscribing for messages on %s", sub.name)
+	go func() {
+		defer close(sub.done)
+		attempt := 0
+		defer func() {
+			if attempt > 0 {
+				atomic.AddInt32(&b.failing, -1)
+			}
+		}()
+		for sub.ctx.Err() == nil {
+			resp := struct {
+				ReceivedMessages []pubsubReceivedMessage `json:"receivedMessages"`
+			}{}
+			err := b.call(b.ctx, http.MethodPost, b.subscriptionPath(sub.name)+":pull", map[string]any{"maxMessages": 1}, &resp)
+			if err != nil {
+				if sub.ctx.Err() != nil {
+					return
+				}
+				attempt++
+				if attempt == 1 {
+					atomic.AddInt32(&b.failing, 1)
+				}
+				log.Error().
+					Err(err).
+					Msgf("error pulling messages from %s (attempt %d)", sub.name, attempt)
+				select {
+				case <-sub.ctx.Done():
+				case <-time.After(reconnectBackoff(attempt)):
+				}
+				continue
+			}
+			if attempt > 0 {
+				log.Info().Msgf("resumed pulling messages from %s", sub.name)
+				atomic.AddInt32(&b.failing, -1)
+				attempt = 0
+			}
+			for _, m := range resp.ReceivedMessages {
+				b.handle(sub, m, handler)
+			}
+		}
+	}()
+	return nil
+}
+
+// handle runs the handler of the message while extending its
+// ack deadline, and acks it once the handler returns. Messages
+// that could not be handled are not redelivered.
+func (b *PubSubBroker) UnsubscribeForTasks(qname string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	remaining := make([]*pubsubSubscription, 0, len(b.subscriptions))
+	for _, sub := range b.subscriptions {
+		if sub.qname == qname && !sub.events {
+			// a pull in progress runs to completion
+			sub.cancel()
+		} else {
+			remaining = append(remaining, sub)
+		}
+	}
+	b.subscriptions = remaining
+	return nil
+}
+
+func (b *PubSubBroker) handle(sub *pubsubSubscription, m pubsubReceivedMessage, handler func(m pubsubReceivedMessage)) {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(b.ackDeadline / 2)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				req := map[string]any{
+					"ackIds":             []string{m.AckID},
+					"ackDeadlineSeconds": int(b.ackDeadline.Seconds()),
+				}
+				if err := b.call(context.Background(), http.MethodPost, b.subscriptionPath(sub.name)+":modifyAckDeadline", req, nil); err != nil {
+					log.Error().
+						Err(err).
+						Msgf("error extending the ack deadline of a message on %s", sub.name)
+				}
+			}
+		}
+	}()
+	handler(m)
+	close(done)
+	req := map[string]any{"ackIds": []string{m.AckID}}
+	if err := b.call(context.Background(), http.MethodPost, b.subscriptionPath(sub.name)+":acknowledge", req, nil); err != nil {
+		log.Error().Err(err).Msg("failed to ack message")
+	}
+}
+
+func (b *PubSubBroker) PublishTaskProgress(ctx context.Context, t *tork.Task) error {
+	return b.publish(ctx, QUEUE_PROGRESS, t)
+}
+
+func (b *PubSubBroker) SubscribeForTaskProgress(handler func(t *tork.Task) error) error {
+	return b.subscribe(QUEUE_PROGRESS, func(msg any, _ Metadata) error {
+		t, ok := msg.(*tork.Task)
+		if !ok {
+			return errors.Errorf("expecting a *tork.Task but got %T", msg)
+		}
+		return handler(t)
+	})
+}
+
+func (b *PubSubBroker) PublishHeartbeat(ctx context.Context, n *tork.Node) error {
+	return b.publish(ctx, QUEUE_HEARTBEAT, n)
+}
+
+func (b *PubSubBroker) SubscribeForHeartbeats(handler func(n *tork.Node) error) error {
+	return b.subscribe(QUEUE_HEARTBEAT, func(msg any, _ Metadata) error {
+		n, ok := msg.(*tork.Node)
+		if !ok {
+			return errors.Errorf("expecting a *tork.Node but got %T", msg)
+		}
+		return handler(n)
+	})
+}
+
+func (b *PubSubBroker) PublishJob(ctx context.Context, j *tork.Job) error {
+	return b.publish(ctx, QUEUE_JOBS, j)
+}
+
+func (b *PubSubBroker) SubscribeForJobs(handler func(j *tork.Job) error) error {
+	return b.subscribe(QUEUE_JOBS, func(msg any, _ Metadata) error {
+		j, ok := msg.(*tork.Job)
+		if !ok {
+			return errors.Errorf("expecting a *tork.Job but got %T", msg)
+		}
+		return handler(j)
+	})
+}
+
+func (b *PubSubBroker) PublishEvent(ctx context.Context, topic string, event any) error {
+	name := b.resourceName(pubsubEventsTopic)
+	if err := b.declareTopic(ctx, name); err != nil {
+		return err
+	}
+	return b.publishTo(ctx, name, event, map[string]string{"topic": topic})
+}
+
+func (b *PubSubBroker) SubscribeForEvents(ctx context.Context, pattern string, handler func(event any)) error {
+	topic := b.resourceName(pubsubEventsTopic)
+	if err := b.declareTopic(ctx, topic); err != nil {
+		return err
+	}
+	// every event subscriber has a subscription of its own
+	name := b.resourceName(fmt.Sprintf("%s%s-%s", QUEUE_EXCLUSIVE_PREFIX, pubsubEventsTopic, uuid.NewUUID()))
+	if err := b.declareSubscription(ctx, topic, name, pubsubSubscriptionTTL); err != nil {
+		return err
+	}
+	return b.startSubscription(&pubsubSubscription{
+		qname:  pubsubEventsTopic,
+		name:   name,
+		events: true,
+		done:   make(chan struct{}),
+	}, func(m pubsubReceivedMessage) {
+		if !wildcard.Match(pattern, m.Message.Attributes["topic"]) {
+			return
+		}
+		ev, _, err := b.decode(m.Message)
+		if err != nil {
+			log.Error().
+				Err(err).
+				Str("type", m.Message.Attributes["type"]).
+				Msg("failed to deserialized event")
+			return
+		}
+		handler(ev)
+	})
+}
+
+func (b *PubSubBroker) PublishTaskLogPart(ctx context.Context, p *tork.TaskLogPart) error {
+	return b.publish(ctx, QUEUE_LOGS, p)
+}
+
+func (b *PubSubBroker) SubscribeForTaskLogPart(handler func(p *tork.TaskLogPart)) error {
+	return b.subscribe(QUEUE_LOGS, func(msg any, _ Metadata) error {
+		p, ok := msg.(*tork.TaskLogPart)
+		if !ok {
+			return errors.Errorf("expecting a *tork.TaskLogPart but got %T", msg)
+		}
+		handler(p)
+		return nil
+	})
+}
+
+func (b *PubSubBroker) HealthCheck(ctx context.Context) error {
+	if b.isShuttingDown() {
+		return errors.New("broker is shutting down")
+	}
+	path := fmt.Sprintf("projects/%s/topics?pageSize=1", b.project)
+	if err := b.call(ctx, http.MethodGet, path, nil, nil); err != nil {
+		return errors.Wrapf(err, "error listing topics")
+	}
+	if n := atomic.LoadInt32(&b.failing); n > 0 {
+		return errors.Errorf("%d subscription(s) fail to pull messages", n)
+	}
+	return nil
+}
+
+func (b *PubSubBroker) isShuttingDown() bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.shuttingDown
+}
+
+func (b *PubSubBroker) Shutdown(ctx context.Context) error {
+	// when running in standalone mode both the coordinator
+	// and the worker will attempt to shutdown the broker
+	b.mu.Lock()
+	if b.shuttingDown {
+		b.mu.Unlock()
+		return nil
+	}
+	b.shuttingDown = true
+	subs := b.subscriptions
+	b.subscriptions = []*pubsubSubscription{}
+	b.mu.Unlock()
+	// stop pulling messages
+	b.cancel()
+	// let the coordinator's subscribers finish
+	// handling the message they're processing
+	for _, sub := range subs {
+		if !IsCoordinatorQueue(sub.qname) {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+		case <-sub.done:
+		}
+	}
+	// the event subscriptions are private to this broker
+	for _, sub := range subs {
+		if !sub.events {
+			continue
+		}
+		if err := b.call(ctx, http.MethodDelete, b.subscriptionPath(sub.name), nil, nil, http.StatusNotFound); err != nil {
+			log.Error().
+				Err(err).
+				Msgf("error deleting subscription %s", sub.name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,215 @@
+package mq
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/runabol/tork"
+	"github.com/runabol/tork/internal/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakePubSub is a minimal in-memory implementation
+// of the Pub/Sub REST API used by the broker.
+type fakePubSub struct {
+	mu     sync.Mutex
+	topics map[string][]string
+	subs   map[string][]pubsubReceivedMessage
+	nextID int
+}
+
+func newFakePubSub() *httptest.Server {
+	f := &fakePubSub{
+		topics: make(map[string][]string),
+		subs:   make(map[string][]pubsubReceivedMessage),
+	}
+	return httptest.NewServer(http.HandlerFunc(f.serve))
+}
+
+func (f *fakePubSub) serve(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.EscapedPath(), "/v1/")
+	name, action, _ := strings.Cut(path, ":")
+	body := map[string]any{}
+	if r.Body != nil {
+		_ = json.NewDecoder(r.Body).Decode(&body)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch {
+	case r.Method == http.MethodGet:
+		subs := make([]map[string]string, 0)
+		for s := range f.subs {
+			subs = append(subs, map[string]string{"name": s})
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{"subscriptions": subs})
+	case r.Method == http.MethodPut && strings.Contains(name, "/topics/"):
+		if _, ok := f.topics[name]; ok {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		f.topics[name] = []string{}
+		_ = json.NewEncoder(w).Encode(map[string]any{})
+	case r.Method == http.MethodPut && strings.Contains(name, "/subscriptions/"):
+		if _, ok := f.subs[name]; ok {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		topic := body["topic"].(string)
+		f.topics[topic] = append(f.topics[topic], name)
+		f.subs[name] = []pubsubReceivedMessage{}
+		_ = json.NewEncoder(w).Encode(map[string]any{})
+	case r.Method == http.MethodDelete:
+		delete(f.subs, name)
+		_ = json.NewEncoder(w).Encode(map[string]any{})
+	case action == "publish":
+		for _, m := range body["messages"].([]any) {
+			mm := m.(map[string]any)
+			attrs := map[string]string{}
+			for k, v := range mm["attributes"].(map[string]any) {
+				attrs[k] = v.(string)
+			}
+			for _, s := range f.topics[name] {
+				f.nextID++
+				f.subs[s] = append(f.subs[s], pubsubReceivedMessage{
+					AckID: fmt.Sprintf("%d", f.nextID),
+					Message: pubsubMessage{
+						Data:        mm["data"].(string),
+						Attributes:  attrs,
+						PublishTime: time.Now(),
+					},
+				})
+			}
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{})
+	case action == "pull":
+		msgs := f.subs[name]
+		if len(msgs) == 0 {
+			f.mu.Unlock()
+			time.Sleep(time.Millisecond * 10)
+			f.mu.Lock()
+			_ = json.NewEncoder(w).Encode(map[string]any{})
+			return
+		}
+		f.subs[name] = msgs[1:]
+		_ = json.NewEncoder(w).Encode(map[string]any{"receivedMessages": msgs[:1]})
+	default:
+		_ = json.NewEncoder(w).Encode(map[string]any{})
+	}
+}
+
+func newTestPubSubBroker(t *testing.T) *PubSubBroker {
+	srv := newFakePubSub()
+	t.Cleanup(srv.Close)
+	b, err := NewPubSubBroker("test-project", WithPubSubEndpoint(srv.URL), WithTokenSource(nil))
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		assert.NoError(t, b.Shutdown(context.Background()))
+	})
+	return b
+}
+
+func TestPubSubPublishAndSubsribeForTask(t *testing.T) {
+	ctx := context.Background()
+	b := newTestPubSubBroker(t)
+	processed := make(chan any)
+	qname := fmt.Sprintf("%stest-%s", QUEUE_EXCLUSIVE_PREFIX, uuid.NewUUID())
+	// published before subscribing
+	err := b.PublishTask(ctx, qname, &tork.Task{})
+	assert.NoError(t, err)
+	err = b.SubscribeForTasks(qname, func(t *tork.Task) error {
+		processed <- 1
+		return nil
+	})
+	assert.NoError(t, err)
+	<-processed
+}
+
+func TestPubSubDeadLetterTask(t *testing.T) {
+	ctx := context.Background()
+	b := newTestPubSubBroker(t)
+	qname := fmt.Sprintf("%stest-%s", QUEUE_EXCLUSIVE_PREFIX, uuid.NewUUID())
+	err := b.SubscribeForTasks(qname, func(t *tork.Task) error {
+		return errors.New("something bad happened")
+	})
+	assert.NoError(t, err)
+	t1 := &tork.Task{ID: uuid.NewUUID()}
+	deadLettered := make(chan *tork.DeadLetter)
+	err = b.SubscribeForDeadLetters(func(dl *tork.DeadLetter) error {
+		if dl.Task.ID == t1.ID {
+			deadLettered <- dl
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	err = b.PublishTask(ctx, qname, t1)
+	assert.NoError(t, err)
+	dl := <-deadLettered
+	assert.Equal(t, qname, dl.Queue)
+	assert.Equal(t, "something bad happened", dl.Error)
+}
+
+func TestPubSubSubsribeForEvent(t *testing.T) {
+	ctx := context.Background()
+	b := newTestPubSubBroker(t)
+	j1 := &tork.Job{
+		ID:    uuid.NewUUID(),
+		State: tork.JobStateCompleted,
+	}
+	processed1 := make(chan any, 1)
+	processed2 := make(chan any, 1)
+	err := b.SubscribeForEvents(ctx, TOPIC_JOB, func(event any) {
+		j2, ok := event.(*tork.Job)
+		assert.True(t, ok)
+		assert.Equal(t, j1.ID, j2.ID)
+		processed1 <- 1
+	})
+	assert.NoError(t, err)
+	err = b.SubscribeForEvents(ctx, TOPIC_JOB_FAILED, func(event any) {
+		processed2 <- 1
+	})
+	assert.NoError(t, err)
+	err = b.PublishEvent(ctx, TOPIC_JOB_COMPLETED, j1)
+	assert.NoError(t, err)
+	<-processed1
+	select {
+	case <-processed2:
+		t.Fatal("unexpected event")
+	case <-time.After(time.Millisecond * 100):
+	}
+}
+
+func TestPubSubQueues(t *testing.T) {
+	ctx := context.Background()
+	b := newTestPubSubBroker(t)
+	err := b.SubscribeForTasks("tags-disk=ssd,region=eu", func(t *tork.Task) error {
+		return nil
+	})
+	assert.NoError(t, err)
+	qis, err := b.Queues(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, qis, 1)
+	assert.Equal(t, "tags-disk=ssd,region=eu", qis[0].Name)
+	assert.Equal(t, 1, qis[0].Subscribers)
+}
+
+func TestPubSubResourceName(t *testing.T) {
+	b, err := NewPubSubBroker("test-project")
+	assert.NoError(t, err)
+	assert.Equal(t, "tork-default", b.resourceName(QUEUE_DEFAULT))
+	assert.Equal(t, "tork-tags-disk%3Dssd%2Cregion%3Deu", b.resourceName("tags-disk=ssd,region=eu"))
+}
+
+func TestPubSubInvalidAckDeadline(t *testing.T) {
+	_, err := NewPubSubBroker("test-project", WithAckDeadline(time.Hour))
+	assert.Error(t, err)
+	_, err = NewPubSubBroker("")
+	assert.Error(t, err)
+}
@@ -1,6 +1,7 @@
 package mq
 
 import (
+	"strconv"
 	"strings"
 
 	"golang.org/x/exp/slices"
@@ -20,6 +21,10 @@ const (
 	QUEUE_ERROR = "error"
 	// The default queue for tasks
 	QUEUE_DEFAULT = "default"
+	// The queue of the tasks that require GPUs when no
+	// active worker can run them at the time they are
+	// scheduled. Only workers with GPUs subscribe to it
+	QUEUE_GPU = "gpu"
 	// The queue used by workers to periodically
 	// notify the coordinator about their aliveness
 	QUEUE_HEARTBEAT = "heartbeat"
@@ -32,9 +37,21 @@ const (
 	// The queue used by workers to send task
 	// progress to the Coordinator
 	QUEUE_PROGRESS = "progress"
+	// The queue that task messages which could not
+	// be handled are routed to, with the failure
+	// reason recorded on the task's error
+	QUEUE_DEAD_LETTER = "dead-letter"
 	// The prefix used for queues that
 	// are exclusive
 	QUEUE_EXCLUSIVE_PREFIX = "x-"
+	// The prefix of the queues of tasks that require
+	// a platform (e.g. platform-linux-arm64). Workers
+	// subscribe to the queue of their platform
+	QUEUE_PLATFORM_PREFIX = "platform-"
+	// The prefix of the queues of tagged workers
+	// (e.g. tags-disk=ssd,region=eu). Workers with the
+	// same tags share the queue of their tags
+	QUEUE_TAGS_PREFIX = "tags-"
 )
 
 type QueueInfo struct {
@@ -44,6 +61,41 @@ type QueueInfo struct {
 	Unacked     int    `json:"unacked"`
 }
 
+// PlatformQueue returns the queue of the tasks
+// that require the platform (e.g. linux/arm64).
+func PlatformQueue(platform string) string {
+	return QUEUE_PLATFORM_PREFIX + strings.ReplaceAll(platform, "/", "-")
+}
+
+// TagsQueue returns the queue shared by the
+// workers with the tags.
+func TagsQueue(tags map[string]string) string {
+	pairs := make([]string, 0, len(tags))
+	for k, v := range tags {
+		pairs = append(pairs, k+"="+v)
+	}
+	slices.Sort(pairs)
+	return QUEUE_TAGS_PREFIX + strings.Join(pairs, ",")
+}
+
+// NodeQueue returns the queue shared by the workers with the same
+// tags, number of GPUs and platform. Tasks that require GPUs, a
+// platform or tags are routed to the queue of a worker that can run
+// them, so that every subscriber of the queue can run them too.
+func NodeQueue(tags map[string]string, gpus int, platform string) string {
+	pairs := make(map[string]string, len(tags)+2)
+	for k, v := range tags {
+		pairs[k] = v
+	}
+	if gpus > 0 {
+		pairs["tork.gpus"] = strconv.Itoa(gpus)
+	}
+	if platform != "" {
+		pairs["tork.platform"] = strings.ReplaceAll(platform, "/", "-")
+	}
+	return TagsQueue(pairs)
+}
+
 func IsCoordinatorQueue(qname string) bool {
 	coordQueues := []string{
 		QUEUE_PENDING,
@@ -54,6 +106,7 @@ func IsCoordinatorQueue(qname string) bool {
 		QUEUE_JOBS,
 		QUEUE_LOGS,
 		QUEUE_PROGRESS,
+		QUEUE_DEAD_LETTER,
 	}
 	return slices.Contains(coordQueues, qname)
 }
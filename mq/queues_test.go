@@ -24,3 +24,15 @@ func TestIsCoordinatorQueue(t *testing.T) {
 	assert.Equal(t, true, mq.IsCoordinatorQueue(mq.QUEUE_STARTED))
 	assert.Equal(t, true, mq.IsCoordinatorQueue(mq.QUEUE_PENDING))
 }
+
+func TestPlatformQueue(t *testing.T) {
+	assert.Equal(t, "platform-linux-arm64", mq.PlatformQueue("linux/arm64"))
+	assert.Equal(t, "platform-linux-arm-v7", mq.PlatformQueue("linux/arm/v7"))
+	assert.True(t, mq.IsTaskQueue(mq.PlatformQueue("linux/amd64")))
+}
+
+func TestTagsQueue(t *testing.T) {
+	assert.Equal(t, "tags-disk=ssd,region=eu", mq.TagsQueue(map[string]string{"region": "eu", "disk": "ssd"}))
+	assert.Equal(t, "tags-region=eu", mq.TagsQueue(map[string]string{"region": "eu"}))
+	assert.True(t, mq.IsTaskQueue(mq.TagsQueue(map[string]string{"region": "eu"})))
+}
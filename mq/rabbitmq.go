@@ -2,12 +2,14 @@ package mq
 
 import (
 	"context"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/url"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/pkg/errors"
@@ -44,6 +46,13 @@ type RabbitMQBroker struct {
 	consumerTimeout int
 	managementURL   string
 	durable         bool
+	conn            ConnectionConfig
+	tlsConfig       *tls.Config
+	codec           Codec
+	maxDeliveries   int
+	// the number of subscriptions which lost
+	// their channel and are being re-established
+	reconnecting int32
 }
 
 type subscription struct {
@@ -51,6 +60,9 @@ type subscription struct {
 	ch    *amqp.Channel
 	name  string
 	done  chan int
+	// set once the subscription is cancelled
+	// through UnsubscribeForTasks
+	cancelled atomic.Bool
 }
 
 type rabbitq struct {
@@ -96,30 +108,96 @@ func WithDurableQueues(val bool) Option {
 	}
 }
 
-func NewRabbitMQBroker(url string, opts ...Option) (*RabbitMQBroker, error) {
-	connPool := make([]*amqp.Connection, 3)
-	for i := 0; i < len(connPool); i++ {
-		conn, err := amqp.Dial(url)
-		if err != nil {
-			return nil, errors.Wrapf(err, "error dialing to RabbitMQ")
-		}
-		connPool[i] = conn
+// WithConnection sets the URL, TLS and credentials used to
+// connect to RabbitMQ. The username and password (or token, for
+// OAuth 2.0 enabled brokers) take precedence over the ones in the
+// URL. TLS settings apply to amqps:// URLs.
+func WithConnection(cfg ConnectionConfig) Option {
+	return func(b *RabbitMQBroker) {
+		b.conn = cfg
+	}
+}
+
+// WithCodec sets the codec used to encode the published
+// messages. Consumers decode messages with the codec recorded
+// in their envelope. Default: JSON
+func WithCodec(c Codec) Option {
+	return func(b *RabbitMQBroker) {
+		b.codec = c
 	}
+}
+
+// WithMaxDeliveries sets the number of times a task message is
+// delivered before it's dead-lettered. Default: DEFAULT_MAX_DELIVERIES
+func WithMaxDeliveries(n int) Option {
+	return func(b *RabbitMQBroker) {
+		b.maxDeliveries = n
+	}
+}
+
+func NewRabbitMQBroker(url string, opts ...Option) (*RabbitMQBroker, error) {
 	b := &RabbitMQBroker{
 		queues:          new(syncx.Map[string, string]),
 		topics:          new(syncx.Map[string, string]),
 		url:             url,
-		connPool:        connPool,
 		subscriptions:   make([]*subscription, 0),
 		heartbeatTTL:    defaultHeartbeatTTL,
 		consumerTimeout: int(RABBITMQ_DEFAULT_CONSUMER_TIMEOUT.Milliseconds()),
+		codec:           JSONCodec,
 	}
 	for _, o := range opts {
 		o(b)
 	}
+	if err := b.applyConnection(); err != nil {
+		return nil, err
+	}
+	connPool := make([]*amqp.Connection, 3)
+	for i := 0; i < len(connPool); i++ {
+		conn, err := b.dial()
+		if err != nil {
+			return nil, errors.Wrapf(err, "error dialing to RabbitMQ")
+		}
+		connPool[i] = conn
+	}
+	b.connPool = connPool
 	return b, nil
 }
 
+func (b *RabbitMQBroker) applyConnection() error {
+	if b.conn.URL != "" {
+		b.url = b.conn.URL
+	}
+	if b.conn.Username != "" || b.conn.secret() != "" {
+		u, err := url.Parse(b.url)
+		if err != nil {
+			return errors.Wrapf(err, "unable to parse url: %s", b.url)
+		}
+		username := b.conn.Username
+		if username == "" {
+			username = u.User.Username()
+		}
+		password := b.conn.secret()
+		if password == "" {
+			password, _ = u.User.Password()
+		}
+		u.User = url.UserPassword(username, password)
+		b.url = u.String()
+	}
+	tlsConfig, err := b.conn.TLSConfig()
+	if err != nil {
+		return err
+	}
+	b.tlsConfig = tlsConfig
+	return nil
+}
+
+func (b *RabbitMQBroker) dial() (*amqp.Connection, error) {
+	if b.tlsConfig != nil {
+		return amqp.DialTLS(b.url, b.tlsConfig)
+	}
+	return amqp.Dial(b.url)
+}
+
 func (b *RabbitMQBroker) Queues(ctx context.Context) ([]QueueInfo, error) {
 	rqs, err := b.rabbitQueues(ctx)
 	if err != nil {
@@ -149,6 +227,9 @@ func (b *RabbitMQBroker) rabbitQueues(ctx context.Context) ([]rabbitq, error) {
 		endpoint = fmt.Sprintf("http://%s:15672/api/queues/", u.Hostname())
 	}
 	client := &http.Client{}
+	if b.tlsConfig != nil {
+		client.Transport = &http.Transport{TLSClientConfig: b.tlsConfig}
+	}
 	req, err := http.NewRequestWithContext(ctx, "GET", endpoint, nil)
 	if err != nil {
 		return nil, errors.Wrapf(err, "unable to build get queues request")
@@ -184,16 +265,49 @@ func (b *RabbitMQBroker) PublishTask(ctx context.Context, qname string, t *tork.
 }
 
 func (b *RabbitMQBroker) SubscribeForTasks(qname string, handler func(t *tork.Task) error) error {
-	return b.subscribe(exchangeDefault, keyDefault, qname, func(msg any) error {
+	return b.subscribe(exchangeDefault, keyDefault, qname, func(msg any, md Metadata) error {
 		t, ok := msg.(*tork.Task)
 		if !ok {
 			return errors.Errorf("expecting a *tork.Task but got %T", t)
 		}
-		return handler(t)
+		return deliverTask(qname, t, md.Attempt, b.maxDeliveries, handler,
+			func(t *tork.Task, attempt int) error {
+				md.Attempt = attempt
+				return b.publish(WithMetadata(context.Background(), md), exchangeDefault, qname, t)
+			},
+			b.deadLetter)
 	})
 }
 
-func (b *RabbitMQBroker) subscribe(exchange, key, qname string, handler func(msg any) error) error {
+func (b *RabbitMQBroker) SubscribeForDeadLetters(handler func(dl *tork.DeadLetter) error) error {
+	return b.subscribe(exchangeDefault, keyDefault, QUEUE_DEAD_LETTER, func(msg any, _ Metadata) error {
+		dl, ok := asDeadLetter(msg)
+		if !ok {
+			return errors.Errorf("expecting a *tork.DeadLetter but got %T", msg)
+		}
+		return handler(dl)
+	})
+}
+
+// deadLetter routes a task that could not
+// be handled to the dead-letter queue
+func (b *RabbitMQBroker) deadLetter(dl *tork.DeadLetter) error {
+	conn, err := b.getConnection()
+	if err != nil {
+		return errors.Wrapf(err, "error getting a connection")
+	}
+	ch, err := conn.Channel()
+	if err != nil {
+		return errors.Wrapf(err, "error creating channel")
+	}
+	defer ch.Close()
+	if err := b.declareQueue(exchangeDefault, keyDefault, QUEUE_DEAD_LETTER, ch); err != nil {
+		return errors.Wrapf(err, "error declaring the %s queue", QUEUE_DEAD_LETTER)
+	}
+	return b.publish(context.Background(), exchangeDefault, QUEUE_DEAD_LETTER, dl)
+}
+
+func (b *RabbitMQBroker) subscribe(exchange, key, qname string, handler func(msg any, md Metadata) error) error {
 	conn, err := b.getConnection()
 	if err != nil {
 		return errors.Wrapf(err, "error getting a connection")
@@ -233,7 +347,16 @@ func (b *RabbitMQBroker) subscribe(exchange, key, qname string, handler func(msg
 	b.mu.Unlock()
 	go func() {
 		for d := range msgs {
-			if msg, err := deserialize(d.Type, d.Body); err != nil {
+			if sub.cancelled.Load() {
+				// delivered before the cancellation took effect
+				if err := d.Nack(false, true); err != nil {
+					log.Error().
+						Err(err).
+						Msg("failed to nack message")
+				}
+				continue
+			}
+			if msg, md, err := open(d.Type, d.Body); err != nil {
 				log.Error().
 					Err(err).
 					Str("queue", qname).
@@ -241,11 +364,14 @@ func (b *RabbitMQBroker) subscribe(exchange, key, qname string, handler func(msg
 					Str("type", (string(d.Type))).
 					Msg("failed to deserialized message")
 			} else {
-				if err := handler(msg); err != nil {
+				md.Attempt = rabbitAttempt(md, d)
+				if err := handler(msg, md); err != nil {
 					log.Error().
 						Err(err).
 						Str("queue", qname).
 						Str("body", (string(d.Body))).
+						Str("traceId", md.TraceID).
+						Int("attempt", md.Attempt).
 						Msg("failed to handle message")
 					if err := d.Reject(false); err != nil {
 						log.Error().
@@ -261,15 +387,31 @@ func (b *RabbitMQBroker) subscribe(exchange, key, qname string, handler func(msg
 				}
 			}
 		}
-		maxAttempts := 20
-		for attempt := 1; !b.shuttingDown && attempt <= maxAttempts; attempt++ {
-			log.Info().Msgf("%s channel closed. reconnecting", qname)
+		if sub.cancelled.Load() {
+			if err := ch.Close(); err != nil {
+				log.Error().
+					Err(err).
+					Msgf("error closing channel for %s", qname)
+			}
+			return
+		}
+		if b.isShuttingDown() {
+			sub.done <- 1
+			return
+		}
+		atomic.AddInt32(&b.reconnecting, 1)
+		defer atomic.AddInt32(&b.reconnecting, -1)
+		for attempt := 1; !b.isShuttingDown(); attempt++ {
+			log.Warn().Msgf("%s channel closed. reconnecting", qname)
 			if err := b.subscribe(exchange, key, qname, handler); err != nil {
 				log.Error().
 					Err(err).
-					Msgf("error reconnecting to %s (attempt %d/%d)", qname, attempt, maxAttempts)
-				time.Sleep(time.Second * time.Duration(attempt))
+					Msgf("error reconnecting to %s (attempt %d)", qname, attempt)
+				time.Sleep(reconnectBackoff(attempt))
 			} else {
+				log.Info().Msgf("resubscribed to %s", qname)
+				// the new subscription replaces this one
+				b.removeSubscription(sub)
 				return
 			}
 		}
@@ -278,44 +420,99 @@ func (b *RabbitMQBroker) subscribe(exchange, key, qname string, handler func(msg
 	return nil
 }
 
-func serialize(msg any) ([]byte, error) {
+// rabbitAttempt returns the delivery attempt of a message,
+// using the delivery count tracked by quorum queues when
+// available and the redelivered flag otherwise.
+func rabbitAttempt(md Metadata, d amqp.Delivery) int {
+	switch count := d.Headers["x-delivery-count"].(type) {
+	case int64:
+		return md.Attempt + int(count)
+	case int32:
+		return md.Attempt + int(count)
+	}
+	if d.Redelivered {
+		return md.Attempt + 1
+	}
+	return md.Attempt
+}
+
+func (b *RabbitMQBroker) UnsubscribeForTasks(qname string) error {
+	b.mu.Lock()
+	cancelled := make([]*subscription, 0)
+	remaining := make([]*subscription, 0, len(b.subscriptions))
+	for _, sub := range b.subscriptions {
+		if sub.qname == qname {
+			cancelled = append(cancelled, sub)
+		} else {
+			remaining = append(remaining, sub)
+		}
+	}
+	b.subscriptions = remaining
+	b.mu.Unlock()
+	for _, sub := range cancelled {
+		sub.cancelled.Store(true)
+		if err := sub.ch.Cancel(sub.name, false); err != nil {
+			return errors.Wrapf(err, "error cancelling subscription to %s", qname)
+		}
+	}
+	return nil
+}
+
+func (b *RabbitMQBroker) removeSubscription(sub *subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for i, s := range b.subscriptions {
+		if s == sub {
+			b.subscriptions = append(b.subscriptions[:i], b.subscriptions[i+1:]...)
+			return
+		}
+	}
+}
+
+func serialize(c Codec, msg any) ([]byte, error) {
 	mtype := fmt.Sprintf("%T", msg)
-	if mtype != "*tork.Task" && mtype != "*tork.Job" && mtype != "*tork.Node" && mtype != "*tork.TaskLogPart" && mtype != "*tork.TaskProgress" {
+	if mtype != "*tork.Task" && mtype != "*tork.Job" && mtype != "*tork.Node" && mtype != "*tork.TaskLogPart" && mtype != "*tork.TaskProgress" && mtype != "*tork.DeadLetter" {
 		return nil, errors.Errorf("unnknown type: %T", msg)
 	}
-	body, err := json.Marshal(msg)
+	body, err := c.Marshal(msg)
 	if err != nil {
 		return nil, errors.Wrapf(err, "unable to serialize the message")
 	}
 	return body, nil
 }
 
-func deserialize(tname string, body []byte) (any, error) {
+func deserialize(c Codec, tname string, body []byte) (any, error) {
 	switch tname {
 	case "*tork.Task":
 		t := tork.Task{}
-		if err := json.Unmarshal(body, &t); err != nil {
+		if err := c.Unmarshal(body, &t); err != nil {
 			return nil, err
 		}
 		return &t, nil
 	case "*tork.Job":
 		j := tork.Job{}
-		if err := json.Unmarshal(body, &j); err != nil {
+		if err := c.Unmarshal(body, &j); err != nil {
 			return nil, err
 		}
 		return &j, nil
 	case "*tork.Node":
 		n := tork.Node{}
-		if err := json.Unmarshal(body, &n); err != nil {
+		if err := c.Unmarshal(body, &n); err != nil {
 			return nil, err
 		}
 		return &n, nil
 	case "*tork.TaskLogPart":
 		p := tork.TaskLogPart{}
-		if err := json.Unmarshal(body, &p); err != nil {
+		if err := c.Unmarshal(body, &p); err != nil {
 			return nil, err
 		}
 		return &p, nil
+	case "*tork.DeadLetter":
+		dl := tork.DeadLetter{}
+		if err := c.Unmarshal(body, &dl); err != nil {
+			return nil, err
+		}
+		return &dl, nil
 	}
 	return nil, errors.Errorf("unknown message type: %s", tname)
 }
@@ -374,20 +571,28 @@ func (b *RabbitMQBroker) publish(ctx context.Context, exchange, key string, msg
 		return errors.Wrapf(err, "error creating channel")
 	}
 	defer ch.Close()
-	body, err := serialize(msg)
+	body, md, err := seal(ctx, b.codec, msg)
 	if err != nil {
 		return err
 	}
+	contentType := "application/json"
+	if md.Codec != "" {
+		contentType = "application/octet-stream"
+	}
 	err = ch.PublishWithContext(ctx,
 		exchange, // exchange
 		key,      // routing key
 		false,    // mandatory
 		false,    // immediate
 		amqp.Publishing{
-			Type:        fmt.Sprintf("%T", msg),
-			ContentType: "application/json",
-			Body:        []byte(body),
-			Priority:    priority,
+			Type:          md.Type,
+			MessageId:     md.ID,
+			CorrelationId: md.TraceID,
+			Headers:       rabbitHeaders(md),
+			Timestamp:     *md.CreatedAt,
+			ContentType:   contentType,
+			Body:          body,
+			Priority:      priority,
 		})
 	if err != nil {
 		return errors.Wrapf(err, "unable to publish message")
@@ -395,8 +600,21 @@ func (b *RabbitMQBroker) publish(ctx context.Context, exchange, key string, msg
 	return nil
 }
 
+// rabbitHeaders exposes who submitted the message in its headers,
+// so that it can be routed (e.g. through a headers exchange)
+// without reading the envelope.
+func rabbitHeaders(md Metadata) amqp.Table {
+	if md.Tenant == "" && md.SubmittedBy == "" {
+		return nil
+	}
+	return amqp.Table{
+		"x-tork-tenant":       md.Tenant,
+		"x-tork-submitted-by": md.SubmittedBy,
+	}
+}
+
 func (b *RabbitMQBroker) SubscribeForHeartbeats(handler func(n *tork.Node) error) error {
-	return b.subscribe(exchangeDefault, keyDefault, QUEUE_HEARTBEAT, func(msg any) error {
+	return b.subscribe(exchangeDefault, keyDefault, QUEUE_HEARTBEAT, func(msg any, _ Metadata) error {
 		n, ok := msg.(*tork.Node)
 		if !ok {
 			return errors.Errorf("expecting a *tork.Node but got %T", msg)
@@ -409,7 +627,7 @@ func (b *RabbitMQBroker) PublishJob(ctx context.Context, j *tork.Job) error {
 	return b.publish(ctx, exchangeDefault, QUEUE_JOBS, j)
 }
 func (b *RabbitMQBroker) SubscribeForJobs(handler func(j *tork.Job) error) error {
-	return b.subscribe(exchangeDefault, keyDefault, QUEUE_JOBS, func(msg any) error {
+	return b.subscribe(exchangeDefault, keyDefault, QUEUE_JOBS, func(msg any, _ Metadata) error {
 		j, ok := msg.(*tork.Job)
 		if !ok {
 			return errors.Errorf("expecting a *tork.Job but got %T", msg)
@@ -433,7 +651,7 @@ func (b *RabbitMQBroker) getConnection() (*amqp.Connection, error) {
 		// re-declare them
 		b.queues = new(syncx.Map[string, string])
 		log.Warn().Msg("connection is closed. reconnecting to RabbitMQ")
-		conn, err = amqp.Dial(b.url)
+		conn, err = b.dial()
 		if err != nil {
 			return nil, errors.Wrapf(err, "error dialing to RabbitMQ")
 		}
@@ -515,7 +733,7 @@ func (b *RabbitMQBroker) Shutdown(ctx context.Context) error {
 func (b *RabbitMQBroker) SubscribeForEvents(ctx context.Context, pattern string, handler func(event any)) error {
 	key := strings.ReplaceAll(pattern, "*", "#")
 	qname := fmt.Sprintf("%s-%s", QUEUE_EXCLUSIVE_PREFIX, uuid.NewUUID())
-	return b.subscribe(exchangeTopic, key, qname, func(msg any) error {
+	return b.subscribe(exchangeTopic, key, qname, func(msg any, _ Metadata) error {
 		handler(msg)
 		return nil
 	})
@@ -535,6 +753,9 @@ func (b *RabbitMQBroker) HealthCheck(ctx context.Context) error {
 		return errors.Wrapf(err, "error creating channel")
 	}
 	defer ch.Close()
+	if n := atomic.LoadInt32(&b.reconnecting); n > 0 {
+		return errors.Errorf("%d subscription(s) are reconnecting", n)
+	}
 	return nil
 }
 
@@ -543,7 +764,7 @@ func (b *RabbitMQBroker) PublishTaskLogPart(ctx context.Context, p *tork.TaskLog
 }
 
 func (b *RabbitMQBroker) SubscribeForTaskLogPart(handler func(p *tork.TaskLogPart)) error {
-	return b.subscribe(exchangeDefault, keyDefault, QUEUE_LOGS, func(msg any) error {
+	return b.subscribe(exchangeDefault, keyDefault, QUEUE_LOGS, func(msg any, _ Metadata) error {
 		p, ok := msg.(*tork.TaskLogPart)
 		if !ok {
 			return errors.Errorf("expecting a *tork.TaskLogPart but got %T", msg)
@@ -558,7 +779,7 @@ func (b *RabbitMQBroker) PublishTaskProgress(ctx context.Context, tp *tork.Task)
 }
 
 func (b *RabbitMQBroker) SubscribeForTaskProgress(handler func(t *tork.Task) error) error {
-	return b.subscribe(exchangeDefault, keyDefault, QUEUE_PROGRESS, func(msg any) error {
+	return b.subscribe(exchangeDefault, keyDefault, QUEUE_PROGRESS, func(msg any, _ Metadata) error {
 		p, ok := msg.(*tork.Task)
 		if !ok {
 			return errors.Errorf("expecting a *tork.TaskProgress but got %T", msg)
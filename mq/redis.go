@@ -0,0 +1,687 @@
+package mq
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog/log"
+	"github.com/runabol/tork"
+	"github.com/runabol/tork/internal/syncx"
+	"github.com/runabol/tork/internal/uuid"
+	"github.com/runabol/tork/internal/wildcard"
+)
+
+const (
+	REDIS_DEFAULT_PREFIX      = "tork:"
+	REDIS_DEFAULT_CLAIM_AFTER = time.Minute
+)
+
+const (
+	// the consumer group every queue is read through
+	redisGroup = "tork"
+	// how long a subscriber blocks waiting for a message
+	// before it checks for messages to reclaim
+	redisFetchWait = time.Second
+	// exclusive queues whose subscribers
+	// went away are removed after this long
+	redisExclusiveTTL = time.Hour * 24
+)
+
+// RedisBroker is an implementation of the Broker interface which
+// uses Redis Streams. Each queue is a stream read through a consumer
+// group which its subscribers join, so every message is handled by
+// one of them. Messages are acked and removed from their stream once
+// their handler returned. Messages whose subscriber went away before
+// that stay pending and are reclaimed by another subscriber once they
+// were idle for longer than the claim timeout, which is reset while
+// a message is handled. Redis Streams don't support priorities so
+// tasks are delivered in the order they were published. Events are
+// published on Redis Pub/Sub channels.
+type RedisBroker struct {
+	url           string
+	prefix        string
+	claimAfter    time.Duration
+	conn          ConnectionConfig
+	codec         Codec
+	maxDeliveries int
+	client        *redis.Client
+	declared      *syncx.Map[string, bool]
+	subscriptions []*redisSubscription
+	mu            sync.RWMutex
+	shuttingDown  bool
+	ctx           context.Context
+	cancel        context.CancelFunc
+	// the number of subscriptions which
+	// fail to fetch their messages
+	failing int32
+}
+
+type redisSubscription struct {
+	qname    string
+	consumer string
+	pubsub   *redis.PubSub
+	done     chan struct{}
+	// cancelled by UnsubscribeForTasks
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+type RedisOption = func(b *RedisBroker)
+
+// WithRedisPrefix sets the prefix of the keys and
+// channels used by the broker. Default: tork:
+func WithRedisPrefix(prefix string) RedisOption {
+	return func(b *RedisBroker) {
+		b.prefix = prefix
+	}
+}
+
+// WithRedisClaimAfter sets how long a message may be pending
+// before it's reclaimed by another subscriber. It is reset for
+// as long as the message's handler is running. Default: 1 minute
+func WithRedisClaimAfter(d time.Duration) RedisOption {
+	return func(b *RedisBroker) {
+		b.claimAfter = d
+	}
+}
+
+// WithRedisConnection sets the URL, TLS settings and credentials
+// used to connect to Redis. A token is used as the password.
+func WithRedisConnection(cfg ConnectionConfig) RedisOption {
+	return func(b *RedisBroker) {
+		b.conn = cfg
+	}
+}
+
+// WithRedisCodec sets the codec used to encode the published
+// messages. Consumers decode messages with the codec recorded
+// in their envelope. Default: JSON
+func WithRedisCodec(c Codec) RedisOption {
+	return func(b *RedisBroker) {
+		b.codec = c
+	}
+}
+
+// WithRedisMaxDeliveries sets the number of times a task message
+// is delivered before it's dead-lettered. Default: DEFAULT_MAX_DELIVERIES
+func WithRedisMaxDeliveries(n int) RedisOption {
+	return func(b *RedisBroker) {
+		b.maxDeliveries = n
+	}
+}
+
+func NewRedisBroker(url string, opts ...RedisOption) (*RedisBroker, error) {
+	b := &RedisBroker{
+		url:           url,
+		prefix:        REDIS_DEFAULT_PREFIX,
+		claimAfter:    REDIS_DEFAULT_CLAIM_AFTER,
+		codec:         JSONCodec,
+		declared:      new(syncx.Map[string, bool]),
+		subscriptions: make([]*redisSubscription, 0),
+	}
+	for _, o := range opts {
+		o(b)
+	}
+	if b.conn.URL != "" {
+		b.url = b.conn.URL
+	}
+	if b.claimAfter < time.Second {
+		return nil, errors.Errorf("invalid claim timeout: %s", b.claimAfter)
+	}
+	ropts, err := redis.ParseURL(b.url)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid Redis URL")
+	}
+	if b.conn.Username != "" {
+		ropts.Username = b.conn.Username
+	}
+	if b.conn.Token != "" {
+		ropts.Password = b.conn.Token
+	} else if b.conn.Password != "" {
+		ropts.Password = b.conn.Password
+	}
+	tlsConfig, err := b.conn.TLSConfig()
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig != nil {
+		ropts.TLSConfig = tlsConfig
+	}
+	b.client = redis.NewClient(ropts)
+	if err := b.client.Ping(context.Background()).Err(); err != nil {
+		b.client.Close()
+		return nil, errors.Wrapf(err, "error connecting to Redis")
+	}
+	b.ctx, b.cancel = context.WithCancel(context.Background())
+	return b, nil
+}
+
+func (b *RedisBroker) streamKey(qname string) string {
+	return b.prefix + "queue:" + qname
+}
+
+func (b *RedisBroker) queuesKey() string {
+	return b.prefix + "queues"
+}
+
+func (b *RedisBroker) eventsChannel(topic string) string {
+	return b.prefix + "events:" + topic
+}
+
+// declareQueue creates the stream of the queue and its consumer
+// group, so messages published before anyone subscribed are kept.
+func (b *RedisBroker) declareQueue(ctx context.Context, qname string) error {
+	key := b.streamKey(qname)
+	exclusive := strings.HasPrefix(qname, QUEUE_EXCLUSIVE_PREFIX)
+	if _, ok := b.declared.Get(key); ok {
+		if exclusive {
+			return b.client.Expire(ctx, key, redisExclusiveTTL).Err()
+		}
+		return nil
+	}
+	log.Debug().Msgf("declaring queue: %s", qname)
+	err := b.client.XGroupCreateMkStream(ctx, key, redisGroup, "0").Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return errors.Wrapf(err, "error declaring queue %s", qname)
+	}
+	if err := b.client.SAdd(ctx, b.queuesKey(), qname).Err(); err != nil {
+		return errors.Wrapf(err, "error declaring queue %s", qname)
+	}
+	if exclusive {
+		if err := b.client.Expire(ctx, key, redisExclusiveTTL).Err(); err != nil {
+			return errors.Wrapf(err, "error declaring queue %s", qname)
+		}
+	}
+	b.declared.Set(key, true)
+	return nil
+}
+
+func (b *RedisBroker) Queues(ctx context.Context) ([]QueueInfo, error) {
+	subscribers := make(map[string]int)
+	b.mu.RLock()
+	for _, sub := range b.subscriptions {
+		subscribers[sub.qname]++
+	}
+	b.mu.RUnlock()
+	qnames, err := b.client.SMembers(ctx, b.queuesKey()).Result()
+	if err != nil {
+		return nil, errors.Wrapf(err, "error listing queues")
+	}
+	qis := make([]QueueInfo, 0)
+	for _, qname := range qnames {
+		key := b.streamKey(qname)
+		size, err := b.client.XLen(ctx, key).Result()
+		if err != nil {
+			return nil, errors.Wrapf(err, "error getting the size of %s", qname)
+		}
+		pending, err := b.client.XPending(ctx, key, redisGroup).Result()
+		if err != nil {
+			// the stream of an exclusive queue expired
+			if strings.HasPrefix(err.Error(), "NOGROUP") {
+				b.client.SRem(ctx, b.queuesKey(), qname)
+				b.declared.Delete(key)
+				continue
+			}
+			return nil, errors.Wrapf(err, "error getting the pending messages of %s", qname)
+		}
+		qis = append(qis, QueueInfo{
+			Name:        qname,
+			Size:        int(size - pending.Count),
+			Subscribers: subscribers[qname],
+			Unacked:     int(pending.Count),
+		})
+	}
+	return qis, nil
+}
+
+func (b *RedisBroker) PublishTask(ctx context.Context, qname string, t *tork.Task) error {
+	return b.publish(ctx, qname, t)
+}
+
+func (b *RedisBroker) SubscribeForTasks(qname string, handler func(t *tork.Task) error) error {
+	return b.subscribe(qname, func(msg any, md Metadata) error {
+		t, ok := msg.(*tork.Task)
+		if !ok {
+			return errors.Errorf("expecting a *tork.Task but got %T", msg)
+		}
+		return deliverTask(qname, t, md.Attempt, b.maxDeliveries, handler,
+			func(t *tork.Task, attempt int) error {
+				md.Attempt = attempt
+				return b.publish(WithMetadata(context.Background(), md), qname, t)
+			},
+			func(dl *tork.DeadLetter) error {
+				return b.publish(context.Background(), QUEUE_DEAD_LETTER, dl)
+			})
+	})
+}
+
+func (b *RedisBroker) SubscribeForDeadLetters(handler func(dl *tork.DeadLetter) error) error {
+	return b.subscribe(QUEUE_DEAD_LETTER, func(msg any, _ Metadata) error {
+		dl, ok := asDeadLetter(msg)
+		if !ok {
+			return errors.Errorf("expecting a *tork.DeadLetter but got %T", msg)
+		}
+		return handler(dl)
+	})
+}
+
+func (b *RedisBroker) publish(ctx context.Context, qname string, msg any) error {
+	if b.isShuttingDown() {
+		return errors.New("broker is shutting down")
+	}
+	if err := b.declareQueue(ctx, qname); err != nil {
+		return err
+	}
+	body, md, err := seal(ctx, b.codec, msg)
+	if err != nil {
+		return err
+	}
+	err = b.client.XAdd(ctx, &redis.XAddArgs{
+		Stream: b.streamKey(qname),
+		Values: []any{"type", md.Type, "body", body},
+	}).Err()
+	if err != nil {
+		return errors.Wrapf(err, "unable to publish message")
+	}
+	return nil
+}
+
+func (b *RedisBroker) subscribe(qname string, handler func(msg any, md Metadata) error) error {
+	if err := b.declareQueue(b.ctx, qname); err != nil {
+		return err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.shuttingDown {
+		return errors.New("broker is shutting down")
+	}
+	sub := &redisSubscription{
+		qname:    qname,
+		consumer: uuid.NewUUID(),
+		done:     make(chan struct{}),
+	}
+	sub.ctx, sub.cancel = context.WithCancel(b.ctx)
+	b.subscriptions = append(b.subscriptions, sub)
+	log.Debug().Msgf("subscribing for messages on %s", qname)
+	go func() {
+		defer close(sub.done)
+		attempt := 0
+		defer func() {
+			if attempt > 0 {
+				atomic.AddInt32(&b.failing, -1)
+			}
+		}()
+		for sub.ctx.Err() == nil {
+			err := b.fetch(sub, handler)
+			if err == nil {
+				if attempt > 0 {
+					log.Info().Msgf("resumed fetching messages from %s", qname)
+					atomic.AddInt32(&b.failing, -1)
+					attempt = 0
+				}
+				continue
+			}
+			if sub.ctx.Err() != nil {
+				return
+			}
+			attempt++
+			if attempt == 1 {
+				atomic.AddInt32(&b.failing, 1)
+			}
+			log.Error().
+				Err(err).
+				Msgf("error fetching messages from %s (attempt %d)", qname, attempt)
+			select {
+			case <-sub.ctx.Done():
+			case <-time.After(reconnectBackoff(attempt)):
+			}
+		}
+	}()
+	return nil
+}
+
+func (b *RedisBroker) UnsubscribeForTasks(qname string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	remaining := make([]*redisSubscription, 0, len(b.subscriptions))
+	for _, sub := range b.subscriptions {
+		if sub.qname == qname && sub.cancel != nil {
+			// a fetch in progress runs to completion
+			sub.cancel()
+		} else {
+			remaining = append(remaining, sub)
+		}
+	}
+	b.subscriptions = remaining
+	return nil
+}
+
+// fetch handles the oldest message left pending by a subscriber
+// which went away, if any, or else the next new message.
+func (b *RedisBroker) fetch(sub *redisSubscription, handler func(msg any, md Metadata) error) error {
+	key := b.streamKey(sub.qname)
+	pending, err := b.client.XPendingExt(b.ctx, &redis.XPendingExtArgs{
+		Stream: key,
+		Group:  redisGroup,
+		Idle:   b.claimAfter,
+		Start:  "-",
+		End:    "+",
+		Count:  1,
+	}).Result()
+	if err != nil && err != redis.Nil {
+		return b.recover(sub.qname, err)
+	}
+	if len(pending) > 0 {
+		p := pending[0]
+		msgs, err := b.client.XClaim(b.ctx, &redis.XClaimArgs{
+			Stream:   key,
+			Group:    redisGroup,
+			Consumer: sub.consumer,
+			MinIdle:  b.claimAfter,
+			Messages: []string{p.ID},
+		}).Result()
+		if err != nil {
+			return errors.Wrapf(err, "error reclaiming message %s", p.ID)
+		}
+		// another subscriber reclaimed it first
+		if len(msgs) == 0 {
+			return nil
+		}
+		log.Debug().Msgf("reclaimed message %s of %s from %s", p.ID, sub.qname, p.Consumer)
+		b.handle(sub, msgs[0], int(p.RetryCount), handler)
+		return nil
+	}
+	streams, err := b.client.XReadGroup(b.ctx, &redis.XReadGroupArgs{
+		Group:    redisGroup,
+		Consumer: sub.consumer,
+		Streams:  []string{key, ">"},
+		Count:    1,
+		Block:    redisFetchWait,
+	}).Result()
+	if err == redis.Nil {
+		return nil
+	}
+	if err != nil {
+		return b.recover(sub.qname, err)
+	}
+	for _, s := range streams {
+		for _, m := range s.Messages {
+			b.handle(sub, m, 0, handler)
+		}
+	}
+	return nil
+}
+
+// recover re-creates the stream of an exclusive queue which
+// expired while its subscriber was away.
+func (b *RedisBroker) recover(qname string, err error) error {
+	if !strings.HasPrefix(err.Error(), "NOGROUP") {
+		return err
+	}
+	b.declared.Delete(b.streamKey(qname))
+	return b.declareQueue(b.ctx, qname)
+}
+
+// handle runs the handler of the message while resetting its idle
+// time, and acks and deletes it once the handler returns. Messages
+// that could not be handled are not redelivered.
+func (b *RedisBroker) handle(sub *redisSubscription, m redis.XMessage, redelivered int, handler func(msg any, md Metadata) error) {
+	key := b.streamKey(sub.qname)
+	defer func() {
+		_, err := b.client.TxPipelined(context.Background(), func(p redis.Pipeliner) error {
+			p.XAck(context.Background(), key, redisGroup, m.ID)
+			p.XDel(context.Background(), key, m.ID)
+			return nil
+		})
+		if err != nil {
+			log.Error().Err(err).Msg("failed to ack message")
+		}
+	}()
+	// the ID of a message starts with the time it was added
+	if ms, _, ok := strings.Cut(m.ID, "-"); ok && sub.qname == QUEUE_HEARTBEAT {
+		if ts, err := strconv.ParseInt(ms, 10, 64); err == nil &&
+			time.Since(time.UnixMilli(ts)) > time.Millisecond*defaultHeartbeatTTL {
+			// stale heartbeats are dropped
+			return
+		}
+	}
+	mtype, _ := m.Values["type"].(string)
+	body, _ := m.Values["body"].(string)
+	msg, md, err := open(mtype, []byte(body))
+	if err != nil {
+		log.Error().
+			Err(err).
+			Str("queue", sub.qname).
+			Str("type", mtype).
+			Msg("failed to deserialized message")
+		return
+	}
+	md.Attempt = md.Attempt + redelivered
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(b.claimAfter / 2)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				err := b.client.XClaimJustID(context.Background(), &redis.XClaimArgs{
+					Stream:   key,
+					Group:    redisGroup,
+					Consumer: sub.consumer,
+					Messages: []string{m.ID},
+				}).Err()
+				if err != nil {
+					log.Error().
+						Err(err).
+						Msgf("error resetting the idle time of a message on %s", sub.qname)
+				}
+			}
+		}
+	}()
+	if err := handler(msg, md); err != nil {
+		log.Error().
+			Err(err).
+			Str("queue", sub.qname).
+			Str("traceId", md.TraceID).
+			Int("attempt", md.Attempt).
+			Msg("failed to handle message")
+	}
+}
+
+func (b *RedisBroker) PublishTaskProgress(ctx context.Context, t *tork.Task) error {
+	return b.publish(ctx, QUEUE_PROGRESS, t)
+}
+
+func (b *RedisBroker) SubscribeForTaskProgress(handler func(t *tork.Task) error) error {
+	return b.subscribe(QUEUE_PROGRESS, func(msg any, _ Metadata) error {
+		t, ok := msg.(*tork.Task)
+		if !ok {
+			return errors.Errorf("expecting a *tork.Task but got %T", msg)
+		}
+		return handler(t)
+	})
+}
+
+func (b *RedisBroker) PublishHeartbeat(ctx context.Context, n *tork.Node) error {
+	return b.publish(ctx, QUEUE_HEARTBEAT, n)
+}
+
+func (b *RedisBroker) SubscribeForHeartbeats(handler func(n *tork.Node) error) error {
+	return b.subscribe(QUEUE_HEARTBEAT, func(msg any, _ Metadata) error {
+		n, ok := msg.(*tork.Node)
+		if !ok {
+			return errors.Errorf("expecting a *tork.Node but got %T", msg)
+		}
+		return handler(n)
+	})
+}
+
+func (b *RedisBroker) PublishJob(ctx context.Context, j *tork.Job) error {
+	return b.publish(ctx, QUEUE_JOBS, j)
+}
+
+func (b *RedisBroker) SubscribeForJobs(handler func(j *tork.Job) error) error {
+	return b.subscribe(QUEUE_JOBS, func(msg any, _ Metadata) error {
+		j, ok := msg.(*tork.Job)
+		if !ok {
+			return errors.Errorf("expecting a *tork.Job but got %T", msg)
+		}
+		return handler(j)
+	})
+}
+
+func (b *RedisBroker) PublishEvent(ctx context.Context, topic string, event any) error {
+	if b.isShuttingDown() {
+		return errors.New("broker is shutting down")
+	}
+	body, _, err := seal(ctx, b.codec, event)
+	if err != nil {
+		return err
+	}
+	if err := b.client.Publish(ctx, b.eventsChannel(topic), body).Err(); err != nil {
+		return errors.Wrapf(err, "unable to publish event")
+	}
+	return nil
+}
+
+// SubscribeForEvents receives the events published while
+// subscribed, like an exclusive queue of the other brokers.
+func (b *RedisBroker) SubscribeForEvents(ctx context.Context, pattern string, handler func(event any)) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.shuttingDown {
+		return errors.New("broker is shutting down")
+	}
+	ps := b.client.PSubscribe(ctx, b.eventsChannel("*"))
+	// wait for the subscription to be confirmed, so
+	// that the events published from now on are received
+	if _, err := ps.Receive(ctx); err != nil {
+		ps.Close()
+		return errors.Wrapf(err, "error subscribing for events")
+	}
+	sub := &redisSubscription{
+		qname:  b.eventsChannel(pattern),
+		pubsub: ps,
+		done:   make(chan struct{}),
+	}
+	b.subscriptions = append(b.subscriptions, sub)
+	go func() {
+		defer close(sub.done)
+		for m := range ps.Channel() {
+			if !wildcard.Match(pattern, strings.TrimPrefix(m.Channel, b.eventsChannel(""))) {
+				continue
+			}
+			ev, _, err := open("", []byte(m.Payload))
+			if err != nil {
+				log.Error().
+					Err(err).
+					Msg("failed to deserialized event")
+				continue
+			}
+			handler(ev)
+		}
+	}()
+	return nil
+}
+
+func (b *RedisBroker) PublishTaskLogPart(ctx context.Context, p *tork.TaskLogPart) error {
+	return b.publish(ctx, QUEUE_LOGS, p)
+}
+
+func (b *RedisBroker) SubscribeForTaskLogPart(handler func(p *tork.TaskLogPart)) error {
+	return b.subscribe(QUEUE_LOGS, func(msg any, _ Metadata) error {
+		p, ok := msg.(*tork.TaskLogPart)
+		if !ok {
+			return errors.Errorf("expecting a *tork.TaskLogPart but got %T", msg)
+		}
+		handler(p)
+		return nil
+	})
+}
+
+func (b *RedisBroker) HealthCheck(ctx context.Context) error {
+	if b.isShuttingDown() {
+		return errors.New("broker is shutting down")
+	}
+	if err := b.client.Ping(ctx).Err(); err != nil {
+		return errors.Wrapf(err, "error pinging Redis")
+	}
+	if n := atomic.LoadInt32(&b.failing); n > 0 {
+		return errors.Errorf("%d subscription(s) fail to fetch messages", n)
+	}
+	return nil
+}
+
+func (b *RedisBroker) isShuttingDown() bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.shuttingDown
+}
+
+func (b *RedisBroker) Shutdown(ctx context.Context) error {
+	// when running in standalone mode both the coordinator
+	// and the worker will attempt to shutdown the broker
+	b.mu.Lock()
+	if b.shuttingDown {
+		b.mu.Unlock()
+		return nil
+	}
+	b.shuttingDown = true
+	subs := b.subscriptions
+	b.subscriptions = []*redisSubscription{}
+	b.mu.Unlock()
+	// stop fetching messages
+	b.cancel()
+	for _, sub := range subs {
+		if sub.pubsub != nil {
+			if err := sub.pubsub.Close(); err != nil {
+				log.Error().Err(err).Msg("error unsubscribing from events")
+			}
+		}
+	}
+	// let the coordinator's subscribers finish
+	// handling the message they're processing
+	for _, sub := range subs {
+		if !IsCoordinatorQueue(sub.qname) {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+		case <-sub.done:
+		}
+	}
+	// remove the consumers from their group, unless they
+	// still have pending messages which others must reclaim
+	for _, sub := range subs {
+		if sub.pubsub != nil {
+			continue
+		}
+		key := b.streamKey(sub.qname)
+		pending, err := b.client.XPendingExt(ctx, &redis.XPendingExtArgs{
+			Stream:   key,
+			Group:    redisGroup,
+			Start:    "-",
+			End:      "+",
+			Count:    1,
+			Consumer: sub.consumer,
+		}).Result()
+		if (err != nil && err != redis.Nil) || len(pending) > 0 {
+			continue
+		}
+		if err := b.client.XGroupDelConsumer(ctx, key, redisGroup, sub.consumer).Err(); err != nil {
+			log.Error().
+				Err(err).
+				Msgf("error removing consumer %s of %s", sub.consumer, sub.qname)
+		}
+	}
+	return b.client.Close()
+}
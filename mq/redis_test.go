@@ -0,0 +1,244 @@
+package mq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/runabol/tork"
+	"github.com/runabol/tork/internal/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestRedisBroker(t *testing.T, s *miniredis.Miniredis, opts ...RedisOption) *RedisBroker {
+	b, err := NewRedisBroker(fmt.Sprintf("redis://%s", s.Addr()), opts...)
+	assert.NoError(t, err)
+	return b
+}
+
+func TestRedisInvalidConfig(t *testing.T) {
+	s := miniredis.RunT(t)
+	_, err := NewRedisBroker("amqp://localhost")
+	assert.Error(t, err)
+	_, err = NewRedisBroker(fmt.Sprintf("redis://%s", s.Addr()), WithRedisClaimAfter(time.Millisecond))
+	assert.Error(t, err)
+}
+
+func TestRedisPublishAndSubsribeForTask(t *testing.T) {
+	ctx := context.Background()
+	s := miniredis.RunT(t)
+	b := newTestRedisBroker(t, s)
+	processed := make(chan *tork.Task, 1)
+	err := b.SubscribeForTasks("test-queue", func(t *tork.Task) error {
+		processed <- t
+		return nil
+	})
+	assert.NoError(t, err)
+	t1 := &tork.Task{ID: uuid.NewUUID()}
+	assert.NoError(t, b.PublishTask(ctx, "test-queue", t1))
+	t2 := <-processed
+	assert.Equal(t, t1.ID, t2.ID)
+	// the message is removed once handled
+	assert.Eventually(t, func() bool {
+		qis, err := b.Queues(ctx)
+		assert.NoError(t, err)
+		return len(qis) == 1 && qis[0].Size == 0 && qis[0].Unacked == 0
+	}, time.Second*2, time.Millisecond*20)
+	assert.NoError(t, b.Shutdown(ctx))
+}
+
+func TestRedisConsumerGroup(t *testing.T) {
+	ctx := context.Background()
+	s := miniredis.RunT(t)
+	processed := make(chan string, 10)
+	brokers := make([]*RedisBroker, 0)
+	for i := 0; i < 2; i++ {
+		b := newTestRedisBroker(t, s)
+		err := b.SubscribeForTasks("test-queue", func(t *tork.Task) error {
+			processed <- t.ID
+			return nil
+		})
+		assert.NoError(t, err)
+		brokers = append(brokers, b)
+	}
+	for i := 0; i < 10; i++ {
+		assert.NoError(t, brokers[0].PublishTask(ctx, "test-queue", &tork.Task{ID: uuid.NewUUID()}))
+	}
+	// every task is handled by one of the subscribers
+	seen := make(map[string]bool)
+	for i := 0; i < 10; i++ {
+		id := <-processed
+		assert.False(t, seen[id])
+		seen[id] = true
+	}
+	select {
+	case id := <-processed:
+		t.Fatalf("task %s was delivered twice", id)
+	case <-time.After(time.Millisecond * 500):
+	}
+	for _, b := range brokers {
+		assert.NoError(t, b.Shutdown(ctx))
+	}
+}
+
+func TestRedisReclaimPending(t *testing.T) {
+	ctx := context.Background()
+	s := miniredis.RunT(t)
+	b1 := newTestRedisBroker(t, s, WithRedisClaimAfter(time.Second))
+	handling := make(chan any)
+	release := make(chan any)
+	defer close(release)
+	err := b1.SubscribeForTasks("test-queue", func(t *tork.Task) error {
+		handling <- 1
+		<-release
+		return nil
+	})
+	assert.NoError(t, err)
+	t1 := &tork.Task{ID: uuid.NewUUID()}
+	assert.NoError(t, b1.PublishTask(ctx, "test-queue", t1))
+	<-handling
+	// the subscriber goes away before it's done with the task
+	assert.NoError(t, b1.Shutdown(ctx))
+	b2 := newTestRedisBroker(t, s, WithRedisClaimAfter(time.Second))
+	processed := make(chan *tork.Task, 1)
+	err = b2.SubscribeForTasks("test-queue", func(t *tork.Task) error {
+		processed <- t
+		return nil
+	})
+	assert.NoError(t, err)
+	t2 := <-processed
+	assert.Equal(t, t1.ID, t2.ID)
+	assert.NoError(t, b2.Shutdown(ctx))
+}
+
+func TestRedisNoReclaimWhileHandled(t *testing.T) {
+	ctx := context.Background()
+	s := miniredis.RunT(t)
+	processed := make(chan string, 2)
+	brokers := make([]*RedisBroker, 0)
+	for i := 0; i < 2; i++ {
+		b := newTestRedisBroker(t, s, WithRedisClaimAfter(time.Second))
+		err := b.SubscribeForTasks("test-queue", func(t *tork.Task) error {
+			processed <- t.ID
+			// outlives the claim timeout
+			time.Sleep(time.Millisecond * 2500)
+			return nil
+		})
+		assert.NoError(t, err)
+		brokers = append(brokers, b)
+	}
+	assert.NoError(t, brokers[0].PublishTask(ctx, "test-queue", &tork.Task{ID: uuid.NewUUID()}))
+	<-processed
+	select {
+	case id := <-processed:
+		t.Fatalf("task %s was reclaimed while handled", id)
+	case <-time.After(time.Second * 3):
+	}
+	for _, b := range brokers {
+		assert.NoError(t, b.Shutdown(ctx))
+	}
+}
+
+func TestRedisDeadLetterTask(t *testing.T) {
+	ctx := context.Background()
+	s := miniredis.RunT(t)
+	b := newTestRedisBroker(t, s)
+	err := b.SubscribeForTasks("test-queue", func(t *tork.Task) error {
+		return errors.New("something bad happened")
+	})
+	assert.NoError(t, err)
+	deadLettered := make(chan *tork.DeadLetter, 1)
+	err = b.SubscribeForDeadLetters(func(dl *tork.DeadLetter) error {
+		deadLettered <- dl
+		return nil
+	})
+	assert.NoError(t, err)
+	t1 := &tork.Task{ID: uuid.NewUUID()}
+	assert.NoError(t, b.PublishTask(ctx, "test-queue", t1))
+	dl := <-deadLettered
+	assert.Equal(t, t1.ID, dl.Task.ID)
+	assert.Equal(t, "something bad happened", dl.Error)
+	assert.Equal(t, "test-queue", dl.Queue)
+	assert.Equal(t, DEFAULT_MAX_DELIVERIES, dl.Attempts)
+	assert.NoError(t, b.Shutdown(ctx))
+}
+
+func TestRedisGetQueues(t *testing.T) {
+	ctx := context.Background()
+	s := miniredis.RunT(t)
+	b := newTestRedisBroker(t, s)
+	assert.NoError(t, b.PublishTask(ctx, "tags-disk=ssd", &tork.Task{ID: uuid.NewUUID()}))
+	err := b.SubscribeForJobs(func(j *tork.Job) error {
+		return nil
+	})
+	assert.NoError(t, err)
+	qis, err := b.Queues(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, qis, 2)
+	for _, qi := range qis {
+		switch qi.Name {
+		case "tags-disk=ssd":
+			assert.Equal(t, 1, qi.Size)
+			assert.Equal(t, 0, qi.Subscribers)
+		case QUEUE_JOBS:
+			assert.Equal(t, 1, qi.Subscribers)
+		default:
+			t.Fatalf("unexpected queue %s", qi.Name)
+		}
+	}
+	assert.NoError(t, b.Shutdown(ctx))
+}
+
+func TestRedisPublishAndSubsribeForEvent(t *testing.T) {
+	ctx := context.Background()
+	s := miniredis.RunT(t)
+	b := newTestRedisBroker(t, s)
+	processed := make(chan *tork.Job, 1)
+	err := b.SubscribeForEvents(ctx, TOPIC_JOB, func(event any) {
+		j, ok := event.(*tork.Job)
+		assert.True(t, ok)
+		processed <- j
+	})
+	assert.NoError(t, err)
+	j1 := &tork.Job{ID: uuid.NewUUID(), State: tork.JobStateCompleted}
+	assert.NoError(t, b.PublishEvent(ctx, "job.completed", j1))
+	// events of other topics are not received
+	assert.NoError(t, b.PublishEvent(ctx, "node.created", &tork.Job{ID: uuid.NewUUID()}))
+	j2 := <-processed
+	assert.Equal(t, j1.ID, j2.ID)
+	select {
+	case j := <-processed:
+		t.Fatalf("unexpected event for job %s", j.ID)
+	case <-time.After(time.Millisecond * 200):
+	}
+	assert.NoError(t, b.Shutdown(ctx))
+}
+
+func TestRedisHealthCheck(t *testing.T) {
+	ctx := context.Background()
+	s := miniredis.RunT(t)
+	b := newTestRedisBroker(t, s)
+	assert.NoError(t, b.HealthCheck(ctx))
+	assert.NoError(t, b.Shutdown(ctx))
+	assert.Error(t, b.HealthCheck(ctx))
+}
+
+func TestRedisShutdown(t *testing.T) {
+	ctx := context.Background()
+	s := miniredis.RunT(t)
+	b := newTestRedisBroker(t, s)
+	mu := make(chan int)
+	err := b.SubscribeForJobs(func(j *tork.Job) error {
+		mu <- 1
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, b.PublishJob(ctx, &tork.Job{ID: uuid.NewUUID()}))
+	<-mu
+	assert.NoError(t, b.Shutdown(ctx))
+	// publishing after shutdown fails
+	assert.Error(t, b.PublishJob(ctx, &tork.Job{}))
+}
@@ -0,0 +1,936 @@
+package mq
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/runabol/tork"
+	"github.com/runabol/tork/internal/aws"
+	"github.com/runabol/tork/internal/syncx"
+	"github.com/runabol/tork/internal/uuid"
+	"github.com/runabol/tork/internal/wildcard"
+)
+
+const (
+	SQS_DEFAULT_REGION             = "us-east-1"
+	SQS_DEFAULT_PREFIX             = "tork-"
+	SQS_DEFAULT_VISIBILITY_TIMEOUT = time.Minute
+	SQS_DEFAULT_MAX_RECEIVES       = 5
+)
+
+const (
+	// how long a receive waits for a message (in seconds)
+	sqsWaitTime = 20
+	// the longest a message may stay invisible
+	sqsMaxVisibility = time.Hour * 12
+	// the tag which records the tork queue of an SQS queue
+	sqsQueueTag = "tork-queue"
+	// the prefix of the queues of the event subscribers
+	sqsEventsQueue = "events-"
+	// how long the publishers cache the queues of
+	// the event subscribers before listing them again
+	sqsEventsQueuesTTL = time.Second * 10
+	// the longest an SQS queue name may be
+	sqsMaxQueueName = 80
+)
+
+// SQSBroker is an implementation of the Broker interface which
+// uses Amazon SQS. Each queue is a standard SQS queue, created on
+// demand unless it's mapped to an existing queue URL. Messages are
+// deleted once their handler returned, and become visible to other
+// subscribers again if their subscriber went away before that. The
+// visibility timeout of a task message is raised to the task's
+// timeout and extended while it's handled. Task queues route the
+// messages that were received too many times without being handled
+// (e.g. because their worker keeps crashing) to the dead-letter
+// queue through an SQS redrive policy. SQS doesn't support
+// priorities or strict ordering. Events are sent to a short-lived
+// queue of each event subscriber.
+type SQSBroker struct {
+	endpoint      string
+	region        string
+	prefix        string
+	creds         aws.Credentials
+	visibility    time.Duration
+	maxReceives   int
+	mapped        map[string]string
+	conn          ConnectionConfig
+	codec         Codec
+	maxDeliveries int
+	client        *http.Client
+	urls          *syncx.Map[string, string]
+	dlqARN        string
+	dlqMu         sync.Mutex
+	eventQueues   []string
+	eventsAt      time.Time
+	eventsMu      sync.Mutex
+	subscriptions []*sqsSubscription
+	mu            sync.RWMutex
+	shuttingDown  bool
+	ctx           context.Context
+	cancel        context.CancelFunc
+	// the number of subscriptions which
+	// fail to fetch their messages
+	failing int32
+}
+
+type sqsSubscription struct {
+	qname string
+	url   string
+	// the topic pattern of an event subscription,
+	// which owns its queue
+	pattern string
+	events  bool
+	done    chan struct{}
+	// cancelled by UnsubscribeForTasks
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+type sqsMessage struct {
+	MessageID         string            `json:"MessageId"`
+	ReceiptHandle     string            `json:"ReceiptHandle"`
+	Body              string            `json:"Body"`
+	Attributes        map[string]string `json:"Attributes"`
+	MessageAttributes map[string]struct {
+		DataType    string `json:"DataType"`
+		StringValue string `json:"StringValue"`
+	} `json:"MessageAttributes"`
+}
+
+type SQSOption = func(b *SQSBroker)
+
+// WithSQSRegion sets the region of the queues.
+// Default: $AWS_REGION or us-east-1
+func WithSQSRegion(region string) SQSOption {
+	return func(b *SQSBroker) {
+		b.region = region
+	}
+}
+
+// WithSQSEndpoint sets the URL of the SQS API, e.g. to use
+// an SQS compatible service such as ElasticMQ or LocalStack.
+// Default: https://sqs.{region}.amazonaws.com
+func WithSQSEndpoint(endpoint string) SQSOption {
+	return func(b *SQSBroker) {
+		b.endpoint = strings.TrimSuffix(endpoint, "/")
+	}
+}
+
+// WithSQSPrefix sets the prefix of the names of
+// the queues created by the broker. Default: tork-
+func WithSQSPrefix(prefix string) SQSOption {
+	return func(b *SQSBroker) {
+		b.prefix = prefix
+	}
+}
+
+// WithSQSQueueURLs maps queues to the URLs of existing SQS queues,
+// which are used as-is. The other queues are created on demand.
+func WithSQSQueueURLs(urls map[string]string) SQSOption {
+	return func(b *SQSBroker) {
+		b.mapped = urls
+	}
+}
+
+// WithSQSVisibilityTimeout sets how long a received message stays
+// invisible to the other subscribers. It is raised to the timeout
+// of a task and extended for as long as the message's handler is
+// running. Default: 1 minute
+func WithSQSVisibilityTimeout(d time.Duration) SQSOption {
+	return func(b *SQSBroker) {
+		b.visibility = d
+	}
+}
+
+// WithSQSMaxReceives sets the number of times a message of a task
+// queue may be received before SQS moves it to the dead-letter
+// queue. 0 disables the redrive policy. Default: 5
+func WithSQSMaxReceives(n int) SQSOption {
+	return func(b *SQSBroker) {
+		b.maxReceives = n
+	}
+}
+
+// WithSQSConnection sets the endpoint, the TLS settings and the
+// credentials used to connect to SQS: the username is used as the
+// access key, the password as the secret key and the token as
+// the session token.
+func WithSQSConnection(cfg ConnectionConfig) SQSOption {
+	return func(b *SQSBroker) {
+		b.conn = cfg
+	}
+}
+
+// WithSQSCodec sets the codec used to encode the published
+// messages. Consumers decode messages with the codec recorded
+// in their envelope. Default: JSON
+func WithSQSCodec(c Codec) SQSOption {
+	return func(b *SQSBroker) {
+		b.codec = c
+	}
+}
+
+// WithSQSMaxDeliveries sets the number of times a task message
+// is delivered before it's dead-lettered. Default: DEFAULT_MAX_DELIVERIES
+func WithSQSMaxDeliveries(n int) SQSOption {
+	return func(b *SQSBroker) {
+		b.maxDeliveries = n
+	}
+}
+
+func NewSQSBroker(opts ...SQSOption) (*SQSBroker, error) {
+	b := &SQSBroker{
+		region:        os.Getenv("AWS_REGION"),
+		prefix:        SQS_DEFAULT_PREFIX,
+		creds:         aws.EnvCredentials(),
+		visibility:    SQS_DEFAULT_VISIBILITY_TIMEOUT,
+		maxReceives:   SQS_DEFAULT_MAX_RECEIVES,
+		codec:         JSONCodec,
+		client:        &http.Client{Timeout: time.Second * (sqsWaitTime + 40)},
+		urls:          new(syncx.Map[string, string]),
+		subscriptions: make([]*sqsSubscription, 0),
+	}
+	for _, o := range opts {
+		o(b)
+	}
+	if b.region == "" {
+		b.region = SQS_DEFAULT_REGION
+	}
+	if b.conn.URL != "" {
+		b.endpoint = strings.TrimSuffix(b.conn.URL, "/")
+	}
+	if b.endpoint == "" {
+		b.endpoint = fmt.Sprintf("https://sqs.%s.amazonaws.com", b.region)
+	}
+	if b.conn.Username != "" || b.conn.Password != "" {
+		b.creds = aws.Credentials{
+			AccessKey:    b.conn.Username,
+			SecretKey:    b.conn.Password,
+			SessionToken: b.conn.Token,
+		}
+	}
+	if b.creds.AccessKey == "" || b.creds.SecretKey == "" {
+		return nil, errors.New("must provide the SQS access key and secret key")
+	}
+	if b.visibility < time.Second*2 || b.visibility > sqsMaxVisibility {
+		return nil, errors.Errorf("invalid visibility timeout: %s", b.visibility)
+	}
+	if b.maxReceives < 0 || b.maxReceives > 1000 {
+		return nil, errors.Errorf("invalid max receives: %d", b.maxReceives)
+	}
+	tlsConfig, err := b.conn.TLSConfig()
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig != nil {
+		b.client.Transport = &http.Transport{TLSClientConfig: tlsConfig}
+	}
+	for qname, u := range b.mapped {
+		b.urls.Set(qname, u)
+	}
+	b.ctx, b.cancel = context.WithCancel(context.Background())
+	return b, nil
+}
+
+// sqsAPIError is an error response of the SQS API.
+type sqsAPIError struct {
+	status  int
+	code    string
+	message string
+}
+
+func (e *sqsAPIError) Error() string {
+	return fmt.Sprintf("SQS responded with %d: %s: %s", e.status, e.code, e.message)
+}
+
+func isSQSQueueNotFound(err error) bool {
+	var apiErr *sqsAPIError
+	return errors.As(err, &apiErr) &&
+		(apiErr.code == "QueueDoesNotExist" || apiErr.code == "AWS.SimpleQueueService.NonExistentQueue")
+}
+
+// call invokes an action of the SQS API, using its JSON protocol.
+func (b *SQSBroker) call(ctx context.Context, action string, body, result any) error {
+	bs, err := json.Marshal(body)
+	if err != nil {
+		return errors.Wrapf(err, "error serializing request")
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, b.endpoint+"/", bytes.NewReader(bs))
+	if err != nil {
+		return errors.Wrapf(err, "error building request")
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.0")
+	req.Header.Set("X-Amz-Target", "AmazonSQS."+action)
+	aws.Sign(req, b.creds, b.region, "sqs", aws.PayloadHash(bs), time.Now().UTC())
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return errors.Wrapf(err, "error calling SQS")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		e := struct {
+			Type    string `json:"__type"`
+			Message string `json:"message"`
+		}{}
+		_ = json.NewDecoder(io.LimitReader(resp.Body, 4096)).Decode(&e)
+		// e.g. com.amazonaws.sqs#QueueDoesNotExist
+		code := e.Type
+		if i := strings.LastIndex(code, "#"); i >= 0 {
+			code = code[i+1:]
+		}
+		return &sqsAPIError{status: resp.StatusCode, code: code, message: e.Message}
+	}
+	if result != nil {
+		if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+			return errors.Wrapf(err, "error decoding SQS response")
+		}
+	}
+	return nil
+}
+
+// queueName returns the name of the SQS queue of the queue.
+// Characters not allowed in queue names are escaped as _XX,
+// and names which are too long are shortened with a hash.
+func (b *SQSBroker) queueName(qname string) string {
+	var sb strings.Builder
+	sb.WriteString(b.prefix)
+	for i := 0; i < len(qname); i++ {
+		switch c := qname[i]; {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-':
+			sb.WriteByte(c)
+		default:
+			fmt.Fprintf(&sb, "_%02X", c)
+		}
+	}
+	name := sb.String()
+	if len(name) > sqsMaxQueueName {
+		h := sha256.Sum256([]byte(qname))
+		suffix := "-" + hex.EncodeToString(h[:8])
+		name = name[:sqsMaxQueueName-len(suffix)] + suffix
+	}
+	return name
+}
+
+// queueURL returns the URL of the queue's SQS queue,
+// which is created if it doesn't exist.
+func (b *SQSBroker) queueURL(ctx context.Context, qname string) (string, error) {
+	if u, ok := b.urls.Get(qname); ok {
+		return u, nil
+	}
+	name := b.queueName(qname)
+	resp := struct {
+		QueueURL string `json:"QueueUrl"`
+	}{}
+	err := b.call(ctx, "GetQueueUrl", map[string]string{"QueueName": name}, &resp)
+	if isSQSQueueNotFound(err) {
+		resp.QueueURL, err = b.createQueue(ctx, qname, name, map[string]string{})
+	}
+	if err != nil {
+		return "", errors.Wrapf(err, "error getting the URL of queue %s", qname)
+	}
+	b.urls.Set(qname, resp.QueueURL)
+	return resp.QueueURL, nil
+}
+
+func (b *SQSBroker) createQueue(ctx context.Context, qname, name string, attrs map[string]string) (string, error) {
+	log.Debug().Msgf("declaring queue: %s", name)
+	attrs["VisibilityTimeout"] = strconv.Itoa(int(b.visibility.Seconds()))
+	if IsTaskQueue(qname) && b.maxReceives > 0 {
+		arn, err := b.deadLetterARN(ctx)
+		if err != nil {
+			return "", err
+		}
+		policy, err := json.Marshal(map[string]any{
+			"deadLetterTargetArn": arn,
+			"maxReceiveCount":     b.maxReceives,
+		})
+		if err != nil {
+			return "", err
+		}
+		attrs["RedrivePolicy"] = string(policy)
+	}
+	resp := struct {
+		QueueURL string `json:"QueueUrl"`
+	}{}
+	req := map[string]any{
+		"QueueName":  name,
+		"Attributes": attrs,
+		"tags":       map[string]string{sqsQueueTag: qname},
+	}
+	if err := b.call(ctx, "CreateQueue", req, &resp); err != nil {
+		return "", errors.Wrapf(err, "error declaring queue %s", name)
+	}
+	return resp.QueueURL, nil
+}
+
+// deadLetterARN returns the ARN of the dead-letter
+// queue, which the task queues redrive to.
+func (b *SQSBroker) deadLetterARN(ctx context.Context) (string, error) {
+	b.dlqMu.Lock()
+	defer b.dlqMu.Unlock()
+	if b.dlqARN != "" {
+		return b.dlqARN, nil
+	}
+	u, err := b.queueURL(ctx, QUEUE_DEAD_LETTER)
+	if err != nil {
+		return "", err
+	}
+	attrs, err := b.attributes(ctx, u, "QueueArn")
+	if err != nil {
+		return "", err
+	}
+	b.dlqARN = attrs["QueueArn"]
+	return b.dlqARN, nil
+}
+
+func (b *SQSBroker) attributes(ctx context.Context, u string, names ...string) (map[string]string, error) {
+	resp := struct {
+		Attributes map[string]string `json:"Attributes"`
+	}{}
+	req := map[string]any{
+		"QueueUrl":       u,
+		"AttributeNames": names,
+	}
+	if err := b.call(ctx, "GetQueueAttributes", req, &resp); err != nil {
+		return nil, errors.Wrapf(err, "error getting the attributes of %s", u)
+	}
+	return resp.Attributes, nil
+}
+
+// listQueues returns the URLs of the queues whose name starts with the prefix.
+func (b *SQSBroker) listQueues(ctx context.Context, prefix string) ([]string, error) {
+	urls := make([]string, 0)
+	token := ""
+	for {
+		req := map[string]any{
+			"QueueNamePrefix": prefix,
+			"MaxResults":      1000,
+		}
+		if token != "" {
+			req["NextToken"] = token
+		}
+		resp := struct {
+			QueueURLs []string `json:"QueueUrls"`
+			NextToken string   `json:"NextToken"`
+		}{}
+		if err := b.call(ctx, "ListQueues", req, &resp); err != nil {
+			return nil, errors.Wrapf(err, "error listing queues")
+		}
+		urls = append(urls, resp.QueueURLs...)
+		if resp.NextToken == "" {
+			return urls, nil
+		}
+		token = resp.NextToken
+	}
+}
+
+func (b *SQSBroker) Queues(ctx context.Context) ([]QueueInfo, error) {
+	subscribers := make(map[string]int)
+	b.mu.RLock()
+	for _, sub := range b.subscriptions {
+		subscribers[sub.qname]++
+	}
+	b.mu.RUnlock()
+	queues := make(map[string]string)
+	for qname, u := range b.mapped {
+		queues[u] = qname
+	}
+	urls, err := b.listQueues(ctx, b.prefix)
+	if err != nil {
+		return nil, err
+	}
+	for _, u := range urls {
+		if _, ok := queues[u]; ok || strings.Contains(u, "/"+b.prefix+sqsEventsQueue) {
+			continue
+		}
+		resp := struct {
+			Tags map[string]string `json:"Tags"`
+		}{}
+		if err := b.call(ctx, "ListQueueTags", map[string]string{"QueueUrl": u}, &resp); err != nil {
+			return nil, errors.Wrapf(err, "error getting the tags of %s", u)
+		}
+		// not a queue of the broker
+		if qname, ok := resp.Tags[sqsQueueTag]; ok {
+			queues[u] = qname
+		}
+	}
+	qis := make([]QueueInfo, 0)
+	for u, qname := range queues {
+		attrs, err := b.attributes(ctx, u, "ApproximateNumberOfMessages", "ApproximateNumberOfMessagesNotVisible")
+		if err != nil {
+			return nil, err
+		}
+		size, _ := strconv.Atoi(attrs["ApproximateNumberOfMessages"])
+		unacked, _ := strconv.Atoi(attrs["ApproximateNumberOfMessagesNotVisible"])
+		qis = append(qis, QueueInfo{
+			Name:        qname,
+			Size:        size,
+			Subscribers: subscribers[qname],
+			Unacked:     unacked,
+		})
+	}
+	return qis, nil
+}
+
+func (b *SQSBroker) PublishTask(ctx context.Context, qname string, t *tork.Task) error {
+	return b.publish(ctx, qname, t)
+}
+
+func (b *SQSBroker) SubscribeForTasks(qname string, handler func(t *tork.Task) error) error {
+	return b.subscribe(qname, func(msg any, md Metadata) error {
+		t, ok := msg.(*tork.Task)
+		if !ok {
+			return errors.Errorf("expecting a *tork.Task but got %T", msg)
+		}
+		return deliverTask(qname, t, md.Attempt, b.maxDeliveries, handler,
+			func(t *tork.Task, attempt int) error {
+				md.Attempt = attempt
+				return b.publish(WithMetadata(context.Background(), md), qname, t)
+			},
+			func(dl *tork.DeadLetter) error {
+				return b.publish(context.Background(), QUEUE_DEAD_LETTER, dl)
+			})
+	})
+}
+
+// SubscribeForDeadLetters receives the tasks dead-lettered by the
+// broker as well as the ones SQS redrove to the dead-letter queue.
+func (b *SQSBroker) SubscribeForDeadLetters(handler func(dl *tork.DeadLetter) error) error {
+	return b.subscribe(QUEUE_DEAD_LETTER, func(msg any, _ Metadata) error {
+		if t, ok := msg.(*tork.Task); ok && t.Error == "" {
+			t.Error = fmt.Sprintf("the task was received %d times without being handled", b.maxReceives)
+		}
+		dl, ok := asDeadLetter(msg)
+		if !ok {
+			return errors.Errorf("expecting a *tork.DeadLetter but got %T", msg)
+		}
+		return handler(dl)
+	})
+}
+
+func (b *SQSBroker) publish(ctx context.Context, qname string, msg any) error {
+	if b.isShuttingDown() {
+		return errors.New("broker is shutting down")
+	}
+	u, err := b.queueURL(ctx, qname)
+	if err != nil {
+		return err
+	}
+	return b.send(ctx, u, msg, "")
+}
+
+// send sends the message to the SQS queue. The body of SQS
+// messages is text, so the message is base64-encoded.
+func (b *SQSBroker) send(ctx context.Context, u string, msg any, topic string) error {
+	body, md, err := seal(ctx, b.codec, msg)
+	if err != nil {
+		return err
+	}
+	attrs := map[string]any{
+		"type": map[string]string{"DataType": "String", "StringValue": md.Type},
+	}
+	if topic != "" {
+		attrs["topic"] = map[string]string{"DataType": "String", "StringValue": topic}
+	}
+	req := map[string]any{
+		"QueueUrl":          u,
+		"MessageBody":       base64.StdEncoding.EncodeToString(body),
+		"MessageAttributes": attrs,
+	}
+	if err := b.call(ctx, "SendMessage", req, nil); err != nil {
+		return errors.Wrapf(err, "unable to publish message")
+	}
+	return nil
+}
+
+func (b *SQSBroker) subscribe(qname string, handler func(msg any, md Metadata) error) error {
+	u, err := b.queueURL(b.ctx, qname)
+	if err != nil {
+		return err
+	}
+	return b.startSubscription(&sqsSubscription{
+		qname: qname,
+		url:   u,
+		done:  make(chan struct{}),
+	}, handler)
+}
+
+func (b *SQSBroker) startSubscription(sub *sqsSubscription, handler func(msg any, md Metadata) error) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.shuttingDown {
+		return errors.New("broker is shutting down")
+	}
+	sub.ctx, sub.cancel = context.WithCancel(b.ctx)
+	b.subscriptions = append(b.subscriptions, sub)
+	log.Debug().Msgf("subscribing for messages on %s", sub.qname)
+	go func() {
+		defer close(sub.done)
+		attempt := 0
+		defer func() {
+			if attempt > 0 {
+				atomic.AddInt32(&b.failing, -1)
+			}
+		}()
+		for sub.ctx.Err() == nil {
+			msgs, err := b.receive(sub.url)
+			if err != nil {
+				if sub.ctx.Err() != nil {
+					return
+				}
+				attempt++
+				if attempt == 1 {
+					atomic.AddInt32(&b.failing, 1)
+				}
+				log.Error().
+					Err(err).
+					Msgf("error fetching messages from %s (attempt %d)", sub.qname, attempt)
+				select {
+				case <-sub.ctx.Done():
+				case <-time.After(reconnectBackoff(attempt)):
+				}
+				continue
+			}
+			if attempt > 0 {
+				log.Info().Msgf("resumed fetching messages from %s", sub.qname)
+				atomic.AddInt32(&b.failing, -1)
+				attempt = 0
+			}
+			for _, m := range msgs {
+				b.handle(sub, m, handler)
+			}
+		}
+	}()
+	return nil
+}
+
+func (b *SQSBroker) UnsubscribeForTasks(qname string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	remaining := make([]*sqsSubscription, 0, len(b.subscriptions))
+	for _, sub := range b.subscriptions {
+		if sub.qname == qname && !sub.events {
+			// a receive in progress runs to completion
+			sub.cancel()
+		} else {
+			remaining = append(remaining, sub)
+		}
+	}
+	b.subscriptions = remaining
+	return nil
+}
+
+func (b *SQSBroker) receive(u string) ([]sqsMessage, error) {
+	req := map[string]any{
+		"QueueUrl":                    u,
+		"MaxNumberOfMessages":         1,
+		"WaitTimeSeconds":             sqsWaitTime,
+		"MessageAttributeNames":       []string{"All"},
+		"MessageSystemAttributeNames": []string{"ApproximateReceiveCount", "SentTimestamp"},
+	}
+	resp := struct {
+		Messages []sqsMessage `json:"Messages"`
+	}{}
+	if err := b.call(b.ctx, "ReceiveMessage", req, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Messages, nil
+}
+
+// visibilityFor returns how long the message stays invisible
+// while it's handled: at least as long as the task may run.
+func (b *SQSBroker) visibilityFor(msg any) time.Duration {
+	t, ok := msg.(*tork.Task)
+	if !ok || t.Timeout == "" {
+		return b.visibility
+	}
+	timeout, err := time.ParseDuration(t.Timeout)
+	if err != nil || timeout <= 0 {
+		return b.visibility
+	}
+	return min(timeout+b.visibility, sqsMaxVisibility)
+}
+
+func (b *SQSBroker) changeVisibility(sub *sqsSubscription, m sqsMessage, d time.Duration) {
+	req := map[string]any{
+		"QueueUrl":          sub.url,
+		"ReceiptHandle":     m.ReceiptHandle,
+		"VisibilityTimeout": int(d.Seconds()),
+	}
+	if err := b.call(context.Background(), "ChangeMessageVisibility", req, nil); err != nil {
+		log.Error().
+			Err(err).
+			Msgf("error extending the visibility timeout of a message on %s", sub.qname)
+	}
+}
+
+// handle runs the handler of the message while extending its
+// visibility timeout, and deletes it once the handler returns.
+// Messages that could not be handled are not redelivered.
+func (b *SQSBroker) handle(sub *sqsSubscription, m sqsMessage, handler func(msg any, md Metadata) error) {
+	defer func() {
+		req := map[string]string{
+			"QueueUrl":      sub.url,
+			"ReceiptHandle": m.ReceiptHandle,
+		}
+		if err := b.call(context.Background(), "DeleteMessage", req, nil); err != nil {
+			log.Error().Err(err).Msg("failed to ack message")
+		}
+	}()
+	// stale heartbeats are dropped
+	if ts, err := strconv.ParseInt(m.Attributes["SentTimestamp"], 10, 64); err == nil &&
+		sub.qname == QUEUE_HEARTBEAT && time.Since(time.UnixMilli(ts)) > time.Millisecond*defaultHeartbeatTTL {
+		return
+	}
+	if sub.events && !wildcard.Match(sub.pattern, m.MessageAttributes["topic"].StringValue) {
+		return
+	}
+	mtype := m.MessageAttributes["type"].StringValue
+	body, err := base64.StdEncoding.DecodeString(m.Body)
+	if err != nil {
+		log.Error().
+			Err(err).
+			Str("queue", sub.qname).
+			Msg("invalid message body")
+		return
+	}
+	msg, md, err := open(mtype, body)
+	if err != nil {
+		log.Error().
+			Err(err).
+			Str("queue", sub.qname).
+			Str("type", mtype).
+			Msg("failed to deserialized message")
+		return
+	}
+	if n, err := strconv.Atoi(m.Attributes["ApproximateReceiveCount"]); err == nil && n > 1 {
+		md.Attempt = md.Attempt + n - 1
+	}
+	vis := b.visibilityFor(msg)
+	if vis != b.visibility {
+		b.changeVisibility(sub, m, vis)
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(vis / 2)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				b.changeVisibility(sub, m, vis)
+			}
+		}
+	}()
+	if err := handler(msg, md); err != nil {
+		log.Error().
+			Err(err).
+			Str("queue", sub.qname).
+			Str("traceId", md.TraceID).
+			Int("attempt", md.Attempt).
+			Msg("failed to handle message")
+	}
+}
+
+func (b *SQSBroker) PublishTaskProgress(ctx context.Context, t *tork.Task) error {
+	return b.publish(ctx, QUEUE_PROGRESS, t)
+}
+
+func (b *SQSBroker) SubscribeForTaskProgress(handler func(t *tork.Task) error) error {
+	return b.subscribe(QUEUE_PROGRESS, func(msg any, _ Metadata) error {
+		t, ok := msg.(*tork.Task)
+		if !ok {
+			return errors.Errorf("expecting a *tork.Task but got %T", msg)
+		}
+		return handler(t)
+	})
+}
+
+func (b *SQSBroker) PublishHeartbeat(ctx context.Context, n *tork.Node) error {
+	return b.publish(ctx, QUEUE_HEARTBEAT, n)
+}
+
+func (b *SQSBroker) SubscribeForHeartbeats(handler func(n *tork.Node) error) error {
+	return b.subscribe(QUEUE_HEARTBEAT, func(msg any, _ Metadata) error {
+		n, ok := msg.(*tork.Node)
+		if !ok {
+			return errors.Errorf("expecting a *tork.Node but got %T", msg)
+		}
+		return handler(n)
+	})
+}
+
+func (b *SQSBroker) PublishJob(ctx context.Context, j *tork.Job) error {
+	return b.publish(ctx, QUEUE_JOBS, j)
+}
+
+func (b *SQSBroker) SubscribeForJobs(handler func(j *tork.Job) error) error {
+	return b.subscribe(QUEUE_JOBS, func(msg any, _ Metadata) error {
+		j, ok := msg.(*tork.Job)
+		if !ok {
+			return errors.Errorf("expecting a *tork.Job but got %T", msg)
+		}
+		return handler(j)
+	})
+}
+
+// eventsQueues returns the queues of the event subscribers.
+func (b *SQSBroker) eventsQueues(ctx context.Context) ([]string, error) {
+	b.eventsMu.Lock()
+	defer b.eventsMu.Unlock()
+	if time.Since(b.eventsAt) < sqsEventsQueuesTTL {
+		return b.eventQueues, nil
+	}
+	urls, err := b.listQueues(ctx, b.prefix+sqsEventsQueue)
+	if err != nil {
+		return nil, err
+	}
+	b.eventQueues, b.eventsAt = urls, time.Now()
+	return urls, nil
+}
+
+// PublishEvent sends the event, along with its
+// topic, to the queue of every event subscriber.
+func (b *SQSBroker) PublishEvent(ctx context.Context, topic string, event any) error {
+	if b.isShuttingDown() {
+		return errors.New("broker is shutting down")
+	}
+	urls, err := b.eventsQueues(ctx)
+	if err != nil {
+		return err
+	}
+	for _, u := range urls {
+		err := b.send(ctx, u, event, topic)
+		// the subscriber went away
+		if isSQSQueueNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SubscribeForEvents receives the events published while subscribed,
+// through a queue of its own which is deleted on shutdown.
+func (b *SQSBroker) SubscribeForEvents(ctx context.Context, pattern string, handler func(event any)) error {
+	qname := sqsEventsQueue + uuid.NewUUID()
+	name := b.prefix + qname
+	u, err := b.createQueue(ctx, qname, name, map[string]string{
+		// events are of no use for long
+		"MessageRetentionPeriod": "60",
+	})
+	if err != nil {
+		return errors.Wrapf(err, "error subscribing for events")
+	}
+	// make the queue visible to this broker's
+	// publishers right away
+	b.eventsMu.Lock()
+	b.eventsAt = time.Time{}
+	b.eventsMu.Unlock()
+	return b.startSubscription(&sqsSubscription{
+		qname:   qname,
+		url:     u,
+		pattern: pattern,
+		events:  true,
+		done:    make(chan struct{}),
+	}, func(msg any, _ Metadata) error {
+		handler(msg)
+		return nil
+	})
+}
+
+func (b *SQSBroker) PublishTaskLogPart(ctx context.Context, p *tork.TaskLogPart) error {
+	return b.publish(ctx, QUEUE_LOGS, p)
+}
+
+func (b *SQSBroker) SubscribeForTaskLogPart(handler func(p *tork.TaskLogPart)) error {
+	return b.subscribe(QUEUE_LOGS, func(msg any, _ Metadata) error {
+		p, ok := msg.(*tork.TaskLogPart)
+		if !ok {
+			return errors.Errorf("expecting a *tork.TaskLogPart but got %T", msg)
+		}
+		handler(p)
+		return nil
+	})
+}
+
+func (b *SQSBroker) HealthCheck(ctx context.Context) error {
+	if b.isShuttingDown() {
+		return errors.New("broker is shutting down")
+	}
+	req := map[string]any{
+		"QueueNamePrefix": b.prefix,
+		"MaxResults":      1,
+	}
+	if err := b.call(ctx, "ListQueues", req, nil); err != nil {
+		return errors.Wrapf(err, "error listing queues")
+	}
+	if n := atomic.LoadInt32(&b.failing); n > 0 {
+		return errors.Errorf("%d subscription(s) fail to fetch messages", n)
+	}
+	return nil
+}
+
+func (b *SQSBroker) isShuttingDown() bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.shuttingDown
+}
+
+func (b *SQSBroker) Shutdown(ctx context.Context) error {
+	// when running in standalone mode both the coordinator
+	// and the worker will attempt to shutdown the broker
+	b.mu.Lock()
+	if b.shuttingDown {
+		b.mu.Unlock()
+		return nil
+	}
+	b.shuttingDown = true
+	subs := b.subscriptions
+	b.subscriptions = []*sqsSubscription{}
+	b.mu.Unlock()
+	// stop fetching messages
+	b.cancel()
+	// let the coordinator's subscribers finish
+	// handling the message they're processing
+	for _, sub := range subs {
+		if !IsCoordinatorQueue(sub.qname) {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+		case <-sub.done:
+		}
+	}
+	for _, sub := range subs {
+		if !sub.events {
+			continue
+		}
+		if err := b.call(ctx, "DeleteQueue", map[string]string{"QueueUrl": sub.url}, nil); err != nil {
+			log.Error().
+				Err(err).
+				Msgf("error deleting queue %s", sub.url)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,481 @@
+package mq
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/runabol/tork"
+	"github.com/runabol/tork/internal/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeSQS is a minimal stand-in for the SQS JSON API.
+type fakeSQS struct {
+	mu     sync.Mutex
+	queues map[string]*fakeSQSQueue
+	srv    *httptest.Server
+}
+
+type fakeSQSQueue struct {
+	name     string
+	attrs    map[string]string
+	tags     map[string]string
+	messages []*fakeSQSMessage
+}
+
+type fakeSQSMessage struct {
+	id        string
+	receipt   string
+	body      string
+	attrs     map[string]any
+	sentAt    time.Time
+	receives  int
+	invisible time.Time
+}
+
+func newFakeSQS(t *testing.T) *fakeSQS {
+	f := &fakeSQS{queues: make(map[string]*fakeSQSQueue)}
+	f.srv = httptest.NewServer(http.HandlerFunc(f.serve))
+	t.Cleanup(f.srv.Close)
+	return f
+}
+
+func (f *fakeSQS) url(name string) string {
+	return f.srv.URL + "/000000000000/" + name
+}
+
+func (f *fakeSQS) queue(u string) *fakeSQSQueue {
+	return f.queues[u[strings.LastIndex(u, "/")+1:]]
+}
+
+func (f *fakeSQS) serve(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=some-key/") ||
+		!strings.Contains(r.Header.Get("Authorization"), "/us-east-1/sqs/aws4_request") {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	req := map[string]any{}
+	_ = json.NewDecoder(r.Body).Decode(&req)
+	reply := func(v any) {
+		_ = json.NewEncoder(w).Encode(v)
+	}
+	notFound := func() {
+		w.WriteHeader(http.StatusBadRequest)
+		reply(map[string]string{"__type": "com.amazonaws.sqs#QueueDoesNotExist", "message": "no such queue"})
+	}
+	action := strings.TrimPrefix(r.Header.Get("X-Amz-Target"), "AmazonSQS.")
+	if action == "ReceiveMessage" {
+		f.receive(w, req)
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var q *fakeSQSQueue
+	if u, ok := req["QueueUrl"].(string); ok {
+		if q = f.queue(u); q == nil {
+			notFound()
+			return
+		}
+	}
+	switch action {
+	case "GetQueueUrl":
+		name := req["QueueName"].(string)
+		if _, ok := f.queues[name]; !ok {
+			notFound()
+			return
+		}
+		reply(map[string]string{"QueueUrl": f.url(name)})
+	case "CreateQueue":
+		name := req["QueueName"].(string)
+		q := &fakeSQSQueue{name: name, attrs: map[string]string{}, tags: map[string]string{}}
+		for k, v := range req["Attributes"].(map[string]any) {
+			q.attrs[k] = v.(string)
+		}
+		for k, v := range req["tags"].(map[string]any) {
+			q.tags[k] = v.(string)
+		}
+		f.queues[name] = q
+		reply(map[string]string{"QueueUrl": f.url(name)})
+	case "DeleteQueue":
+		delete(f.queues, q.name)
+	case "GetQueueAttributes":
+		visible, invisible := 0, 0
+		for _, m := range q.messages {
+			if time.Now().Before(m.invisible) {
+				invisible++
+			} else {
+				visible++
+			}
+		}
+		reply(map[string]any{"Attributes": map[string]string{
+			"QueueArn":                              "arn:aws:sqs:us-east-1:000000000000:" + q.name,
+			"ApproximateNumberOfMessages":           strconv.Itoa(visible),
+			"ApproximateNumberOfMessagesNotVisible": strconv.Itoa(invisible),
+		}})
+	case "ListQueues":
+		urls := make([]string, 0)
+		for name := range f.queues {
+			if strings.HasPrefix(name, req["QueueNamePrefix"].(string)) {
+				urls = append(urls, f.url(name))
+			}
+		}
+		reply(map[string]any{"QueueUrls": urls})
+	case "ListQueueTags":
+		reply(map[string]any{"Tags": q.tags})
+	case "SendMessage":
+		q.messages = append(q.messages, &fakeSQSMessage{
+			id:     uuid.NewUUID(),
+			body:   req["MessageBody"].(string),
+			attrs:  req["MessageAttributes"].(map[string]any),
+			sentAt: time.Now(),
+		})
+		reply(map[string]string{"MessageId": "1"})
+	case "DeleteMessage":
+		for i, m := range q.messages {
+			if m.receipt == req["ReceiptHandle"] {
+				q.messages = append(q.messages[:i], q.messages[i+1:]...)
+				break
+			}
+		}
+	case "ChangeMessageVisibility":
+		for _, m := range q.messages {
+			if m.receipt == req["ReceiptHandle"] {
+				m.invisible = time.Now().Add(time.Second * time.Duration(req["VisibilityTimeout"].(float64)))
+			}
+		}
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+// receive waits for a visible message, redriving the ones
+// which were received too many times to the dead-letter queue.
+func (f *fakeSQS) receive(w http.ResponseWriter, req map[string]any) {
+	deadline := time.Now().Add(time.Millisecond * 500)
+	for {
+		f.mu.Lock()
+		q := f.queue(req["QueueUrl"].(string))
+		if q == nil {
+			f.mu.Unlock()
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		for i, m := range q.messages {
+			if time.Now().Before(m.invisible) {
+				continue
+			}
+			if policy := q.attrs["RedrivePolicy"]; policy != "" {
+				p := struct {
+					DeadLetterTargetArn string
+					MaxReceiveCount     int
+				}{}
+				_ = json.Unmarshal([]byte(policy), &p)
+				if m.receives >= p.MaxReceiveCount {
+					q.messages = append(q.messages[:i], q.messages[i+1:]...)
+					dlq := f.queues[p.DeadLetterTargetArn[strings.LastIndex(p.DeadLetterTargetArn, ":")+1:]]
+					dlq.messages = append(dlq.messages, m)
+					break
+				}
+			}
+			m.receives++
+			m.receipt = uuid.NewUUID()
+			vis, _ := strconv.Atoi(q.attrs["VisibilityTimeout"])
+			m.invisible = time.Now().Add(time.Second * time.Duration(vis))
+			_ = json.NewEncoder(w).Encode(map[string]any{"Messages": []map[string]any{{
+				"MessageId":     m.id,
+				"ReceiptHandle": m.receipt,
+				"Body":          m.body,
+				"Attributes": map[string]string{
+					"ApproximateReceiveCount": strconv.Itoa(m.receives),
+					"SentTimestamp":           strconv.FormatInt(m.sentAt.UnixMilli(), 10),
+				},
+				"MessageAttributes": m.attrs,
+			}}})
+			f.mu.Unlock()
+			return
+		}
+		f.mu.Unlock()
+		if time.Now().After(deadline) {
+			_ = json.NewEncoder(w).Encode(map[string]any{})
+			return
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+}
+
+func newTestSQSBroker(t *testing.T, f *fakeSQS, opts ...SQSOption) *SQSBroker {
+	b, err := NewSQSBroker(append([]SQSOption{
+		WithSQSEndpoint(f.srv.URL),
+		WithSQSRegion("us-east-1"),
+		WithSQSConnection(ConnectionConfig{Username: "some-key", Password: "some-secret"}),
+	}, opts...)...)
+	assert.NoError(t, err)
+	return b
+}
+
+func TestSQSQueueName(t *testing.T) {
+	f := newFakeSQS(t)
+	b := newTestSQSBroker(t, f)
+	assert.Equal(t, "tork-default", b.queueName("default"))
+	assert.Equal(t, "tork-x-abc", b.queueName("x-abc"))
+	assert.Equal(t, "tork-tags-disk_3Dssd_2Cregion_3Deu", b.queueName("tags-disk=ssd,region=eu"))
+	assert.Equal(t, "tork-a_5Fb_2Ec", b.queueName("a_b.c"))
+	long := b.queueName(strings.Repeat("a", 100))
+	assert.Len(t, long, sqsMaxQueueName)
+	assert.NotEqual(t, long, b.queueName(strings.Repeat("a", 101)))
+}
+
+func TestSQSInvalidConfig(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "")
+	_, err := NewSQSBroker()
+	assert.Error(t, err)
+	_, err = NewSQSBroker(WithSQSConnection(ConnectionConfig{Username: "some-key", Password: "some-secret"}),
+		WithSQSVisibilityTimeout(time.Millisecond))
+	assert.Error(t, err)
+}
+
+func TestSQSPublishAndSubsribeForTask(t *testing.T) {
+	ctx := context.Background()
+	f := newFakeSQS(t)
+	b := newTestSQSBroker(t, f)
+	processed := make(chan *tork.Task, 1)
+	err := b.SubscribeForTasks("test-queue", func(t *tork.Task) error {
+		processed <- t
+		return nil
+	})
+	assert.NoError(t, err)
+	t1 := &tork.Task{ID: uuid.NewUUID()}
+	assert.NoError(t, b.PublishTask(ctx, "test-queue", t1))
+	t2 := <-processed
+	assert.Equal(t, t1.ID, t2.ID)
+	// the message is deleted once handled
+	assert.Eventually(t, func() bool {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		return len(f.queues["tork-test-queue"].messages) == 0
+	}, time.Second, time.Millisecond*10)
+	// task queues redrive to the dead-letter queue
+	f.mu.Lock()
+	assert.Equal(t, `{"deadLetterTargetArn":"arn:aws:sqs:us-east-1:000000000000:tork-dead-letter","maxReceiveCount":5}`,
+		f.queues["tork-test-queue"].attrs["RedrivePolicy"])
+	assert.Equal(t, "test-queue", f.queues["tork-test-queue"].tags[sqsQueueTag])
+	f.mu.Unlock()
+	assert.NoError(t, b.Shutdown(ctx))
+}
+
+func TestSQSUnsubscribeForTasks(t *testing.T) {
+	ctx := context.Background()
+	f := newFakeSQS(t)
+	b := newTestSQSBroker(t, f)
+	processed := make(chan *tork.Task, 10)
+	err := b.SubscribeForTasks("test-queue", func(t *tork.Task) error {
+		processed <- t
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, b.PublishTask(ctx, "test-queue", &tork.Task{ID: uuid.NewUUID()}))
+	<-processed
+	assert.NoError(t, b.UnsubscribeForTasks("test-queue"))
+	// let the receive in progress time out
+	time.Sleep(time.Millisecond * 600)
+	assert.NoError(t, b.PublishTask(ctx, "test-queue", &tork.Task{ID: uuid.NewUUID()}))
+	select {
+	case <-processed:
+		t.Fatal("received a task after unsubscribing")
+	case <-time.After(time.Millisecond * 700):
+	}
+	f.mu.Lock()
+	assert.Len(t, f.queues["tork-test-queue"].messages, 1)
+	f.mu.Unlock()
+	assert.NoError(t, b.Shutdown(ctx))
+}
+
+func TestSQSMappedQueue(t *testing.T) {
+	ctx := context.Background()
+	f := newFakeSQS(t)
+	f.queues["my-queue"] = &fakeSQSQueue{name: "my-queue", attrs: map[string]string{"VisibilityTimeout": "30"}}
+	b := newTestSQSBroker(t, f, WithSQSQueueURLs(map[string]string{"test-queue": f.url("my-queue")}))
+	assert.NoError(t, b.PublishTask(ctx, "test-queue", &tork.Task{ID: uuid.NewUUID()}))
+	f.mu.Lock()
+	assert.Len(t, f.queues["my-queue"].messages, 1)
+	_, ok := f.queues["tork-test-queue"]
+	assert.False(t, ok)
+	f.mu.Unlock()
+	qis, err := b.Queues(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, []QueueInfo{{Name: "test-queue", Size: 1}}, qis)
+}
+
+func TestSQSVisibilityTimeout(t *testing.T) {
+	ctx := context.Background()
+	f := newFakeSQS(t)
+	b := newTestSQSBroker(t, f)
+	assert.Equal(t, time.Minute, b.visibilityFor(&tork.Task{}))
+	assert.Equal(t, time.Minute, b.visibilityFor(&tork.Job{}))
+	assert.Equal(t, time.Minute*11, b.visibilityFor(&tork.Task{Timeout: "10m"}))
+	assert.Equal(t, sqsMaxVisibility, b.visibilityFor(&tork.Task{Timeout: "24h"}))
+
+	handling := make(chan any)
+	release := make(chan any)
+	err := b.SubscribeForTasks("test-queue", func(t *tork.Task) error {
+		handling <- 1
+		<-release
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, b.PublishTask(ctx, "test-queue", &tork.Task{ID: uuid.NewUUID(), Timeout: "1h"}))
+	<-handling
+	// the message is hidden for as long as the task may run
+	f.mu.Lock()
+	m := f.queues["tork-test-queue"].messages[0]
+	assert.True(t, time.Until(m.invisible) > time.Minute*59)
+	f.mu.Unlock()
+	close(release)
+	assert.NoError(t, b.Shutdown(ctx))
+}
+
+func TestSQSRedrive(t *testing.T) {
+	ctx := context.Background()
+	f := newFakeSQS(t)
+	b := newTestSQSBroker(t, f, WithSQSVisibilityTimeout(time.Second*2), WithSQSMaxReceives(1))
+	deadLettered := make(chan *tork.DeadLetter, 1)
+	err := b.SubscribeForDeadLetters(func(dl *tork.DeadLetter) error {
+		deadLettered <- dl
+		return nil
+	})
+	assert.NoError(t, err)
+	t1 := &tork.Task{ID: uuid.NewUUID(), Queue: "test-queue"}
+	assert.NoError(t, b.PublishTask(ctx, "test-queue", t1))
+	// the message was received, but never deleted
+	f.mu.Lock()
+	q := f.queues["tork-test-queue"]
+	q.messages[0].receives = 1
+	f.mu.Unlock()
+	err = b.SubscribeForTasks("test-queue", func(t *tork.Task) error {
+		return nil
+	})
+	assert.NoError(t, err)
+	dl := <-deadLettered
+	assert.Equal(t, t1.ID, dl.Task.ID)
+	assert.Equal(t, "test-queue", dl.Queue)
+	assert.Equal(t, "the task was received 1 times without being handled", dl.Error)
+	assert.NoError(t, b.Shutdown(ctx))
+}
+
+func TestSQSDeadLetterTask(t *testing.T) {
+	ctx := context.Background()
+	f := newFakeSQS(t)
+	b := newTestSQSBroker(t, f)
+	err := b.SubscribeForTasks("test-queue", func(t *tork.Task) error {
+		return errors.New("something bad happened")
+	})
+	assert.NoError(t, err)
+	deadLettered := make(chan *tork.DeadLetter, 1)
+	err = b.SubscribeForDeadLetters(func(dl *tork.DeadLetter) error {
+		deadLettered <- dl
+		return nil
+	})
+	assert.NoError(t, err)
+	t1 := &tork.Task{ID: uuid.NewUUID()}
+	assert.NoError(t, b.PublishTask(ctx, "test-queue", t1))
+	dl := <-deadLettered
+	assert.Equal(t, t1.ID, dl.Task.ID)
+	assert.Equal(t, "something bad happened", dl.Error)
+	assert.Equal(t, "test-queue", dl.Queue)
+	assert.Equal(t, DEFAULT_MAX_DELIVERIES, dl.Attempts)
+	assert.NoError(t, b.Shutdown(ctx))
+}
+
+func TestSQSGetQueues(t *testing.T) {
+	ctx := context.Background()
+	f := newFakeSQS(t)
+	b := newTestSQSBroker(t, f)
+	assert.NoError(t, b.PublishTask(ctx, "tags-disk=ssd", &tork.Task{ID: uuid.NewUUID()}))
+	err := b.SubscribeForJobs(func(j *tork.Job) error {
+		return nil
+	})
+	assert.NoError(t, err)
+	err = b.SubscribeForEvents(ctx, TOPIC_JOB, func(event any) {})
+	assert.NoError(t, err)
+	qis, err := b.Queues(ctx)
+	assert.NoError(t, err)
+	names := make([]string, 0)
+	for _, qi := range qis {
+		names = append(names, qi.Name)
+		switch qi.Name {
+		case "tags-disk=ssd":
+			assert.Equal(t, 1, qi.Size)
+		case QUEUE_JOBS:
+			assert.Equal(t, 1, qi.Subscribers)
+		}
+	}
+	assert.ElementsMatch(t, []string{"tags-disk=ssd", QUEUE_JOBS, QUEUE_DEAD_LETTER}, names)
+	assert.NoError(t, b.Shutdown(ctx))
+}
+
+func TestSQSPublishAndSubsribeForEvent(t *testing.T) {
+	ctx := context.Background()
+	f := newFakeSQS(t)
+	b := newTestSQSBroker(t, f)
+	processed := make(chan *tork.Job, 1)
+	err := b.SubscribeForEvents(ctx, TOPIC_JOB, func(event any) {
+		j, ok := event.(*tork.Job)
+		assert.True(t, ok)
+		processed <- j
+	})
+	assert.NoError(t, err)
+	j1 := &tork.Job{ID: uuid.NewUUID(), State: tork.JobStateCompleted}
+	assert.NoError(t, b.PublishEvent(ctx, "job.completed", j1))
+	// events of other topics are not received
+	assert.NoError(t, b.PublishEvent(ctx, "node.created", &tork.Job{ID: uuid.NewUUID()}))
+	j2 := <-processed
+	assert.Equal(t, j1.ID, j2.ID)
+	select {
+	case j := <-processed:
+		t.Fatalf("unexpected event for job %s", j.ID)
+	case <-time.After(time.Millisecond * 200):
+	}
+	assert.NoError(t, b.Shutdown(ctx))
+	// the queue of the subscriber is deleted
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for name := range f.queues {
+		assert.False(t, strings.HasPrefix(name, "tork-events-"), fmt.Sprintf("%s was not deleted", name))
+	}
+}
+
+func TestSQSHealthCheck(t *testing.T) {
+	ctx := context.Background()
+	f := newFakeSQS(t)
+	b := newTestSQSBroker(t, f)
+	assert.NoError(t, b.HealthCheck(ctx))
+	assert.NoError(t, b.Shutdown(ctx))
+	assert.Error(t, b.HealthCheck(ctx))
+}
+
+func TestSQSShutdown(t *testing.T) {
+	ctx := context.Background()
+	f := newFakeSQS(t)
+	b := newTestSQSBroker(t, f)
+	mu := make(chan int)
+	err := b.SubscribeForJobs(func(j *tork.Job) error {
+		mu <- 1
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, b.PublishJob(ctx, &tork.Job{ID: uuid.NewUUID()}))
+	<-mu
+	assert.NoError(t, b.Shutdown(ctx))
+	// publishing after shutdown fails
+	assert.Error(t, b.PublishJob(ctx, &tork.Job{}))
+}
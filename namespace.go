@@ -0,0 +1,91 @@
+package tork
+
+import "slices"
+
+// NAMESPACE_DEFAULT is the namespace of the jobs
+// which are submitted without naming one.
+const NAMESPACE_DEFAULT = "default"
+
+// Namespace isolates the jobs of a team sharing the deployment
+// with other teams: its jobs are only listed to its members, and
+// its quota and defaults apply to them alone.
+type Namespace struct {
+	Name string `json:"name,omitempty"`
+	// Users and Roles are the members of the namespace. A
+	// namespace without any members is open to everyone.
+	Users []string `json:"users,omitempty"`
+	Roles []string `json:"roles,omitempty"`
+	// Queues, when set, are the only queues
+	// the tasks of the namespace may run on.
+	Queues []string `json:"queues,omitempty"`
+	// Defaults fill in the defaults the jobs
+	// of the namespace don't specify.
+	Defaults *JobDefaults `json:"defaults,omitempty"`
+	// MaxActiveJobs is how many jobs of the namespace may be
+	// active when another one is submitted. The runs of the
+	// scheduled jobs aren't held back. 0 means there is no quota.
+	MaxActiveJobs int `json:"maxActiveJobs,omitempty"`
+}
+
+// IsOpen reports whether the namespace has no members,
+// in which case anyone may use it.
+func (n *Namespace) IsOpen() bool {
+	return len(n.Users) == 0 && len(n.Roles) == 0
+}
+
+// IsMember reports whether the user, who has the given
+// roles, is a member of the namespace.
+func (n *Namespace) IsMember(username string, roles []*Role) bool {
+	if n.IsOpen() || slices.Contains(n.Users, username) {
+		return true
+	}
+	for _, r := range roles {
+		if slices.Contains(n.Roles, r.Slug) {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsQueue reports whether the tasks of
+// the namespace may run on the queue.
+func (n *Namespace) AllowsQueue(queue string) bool {
+	return len(n.Queues) == 0 || slices.Contains(n.Queues, queue)
+}
+
+// ApplyDefaults fills in the defaults of the job which
+// it doesn't specify with those of the namespace.
+func (n *Namespace) ApplyDefaults(j *Job) {
+	if n.Defaults == nil {
+		return
+	}
+	if j.Defaults == nil {
+		j.Defaults = &JobDefaults{}
+	}
+	if j.Defaults.Queue == "" {
+		j.Defaults.Queue = n.Defaults.Queue
+	}
+	if j.Defaults.Timeout == "" {
+		j.Defaults.Timeout = n.Defaults.Timeout
+	}
+	if j.Defaults.Priority == 0 {
+		j.Defaults.Priority = n.Defaults.Priority
+	}
+	if j.Defaults.Retry == nil && n.Defaults.Retry != nil {
+		j.Defaults.Retry = n.Defaults.Retry.Clone()
+	}
+	if n.Defaults.Limits != nil {
+		if j.Defaults.Limits == nil {
+			j.Defaults.Limits = &TaskLimits{}
+		}
+		if j.Defaults.Limits.CPUs == "" {
+			j.Defaults.Limits.CPUs = n.Defaults.Limits.CPUs
+		}
+		if j.Defaults.Limits.CPUShares == 0 {
+			j.Defaults.Limits.CPUShares = n.Defaults.Limits.CPUShares
+		}
+		if j.Defaults.Limits.Memory == "" {
+			j.Defaults.Limits.Memory = n.Defaults.Limits.Memory
+		}
+	}
+}
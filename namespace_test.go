@@ -0,0 +1,69 @@
+package tork_test
+
+import (
+	"testing"
+
+	"github.com/runabol/tork"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNamespaceIsMember(t *testing.T) {
+	open := &tork.Namespace{Name: "open"}
+	assert.True(t, open.IsMember("someone", nil))
+
+	ns := &tork.Namespace{
+		Name:  "team-a",
+		Users: []string{"alice"},
+		Roles: []string{"team-a"},
+	}
+	assert.True(t, ns.IsMember("alice", nil))
+	assert.True(t, ns.IsMember("bob", []*tork.Role{{Slug: "team-a"}}))
+	assert.False(t, ns.IsMember("bob", []*tork.Role{{Slug: "team-b"}}))
+	assert.False(t, ns.IsMember("", nil))
+}
+
+func TestNamespaceAllowsQueue(t *testing.T) {
+	ns := &tork.Namespace{Name: "team-a"}
+	assert.True(t, ns.AllowsQueue("default"))
+
+	ns.Queues = []string{"team-a"}
+	assert.True(t, ns.AllowsQueue("team-a"))
+	assert.False(t, ns.AllowsQueue("default"))
+}
+
+func TestNamespaceApplyDefaults(t *testing.T) {
+	ns := &tork.Namespace{
+		Name: "team-a",
+		Defaults: &tork.JobDefaults{
+			Queue:    "team-a",
+			Timeout:  "10m",
+			Priority: 3,
+			Retry:    &tork.TaskRetry{Limit: 2},
+			Limits:   &tork.TaskLimits{CPUs: "1", Memory: "1g"},
+		},
+	}
+
+	j := &tork.Job{}
+	ns.ApplyDefaults(j)
+	assert.Equal(t, "team-a", j.Defaults.Queue)
+	assert.Equal(t, "10m", j.Defaults.Timeout)
+	assert.Equal(t, 3, j.Defaults.Priority)
+	assert.Equal(t, 2, j.Defaults.Retry.Limit)
+	assert.Equal(t, "1", j.Defaults.Limits.CPUs)
+	assert.Equal(t, "1g", j.Defaults.Limits.Memory)
+
+	// the job's own defaults win
+	j = &tork.Job{Defaults: &tork.JobDefaults{
+		Queue:  "other",
+		Limits: &tork.TaskLimits{CPUs: "2"},
+	}}
+	ns.ApplyDefaults(j)
+	assert.Equal(t, "other", j.Defaults.Queue)
+	assert.Equal(t, "2", j.Defaults.Limits.CPUs)
+	assert.Equal(t, "1g", j.Defaults.Limits.Memory)
+	assert.Equal(t, "10m", j.Defaults.Timeout)
+
+	// the retry is not shared with the namespace
+	j.Defaults.Retry.Limit = 5
+	assert.Equal(t, 2, ns.Defaults.Retry.Limit)
+}
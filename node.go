@@ -2,6 +2,8 @@ package tork
 
 import (
 	"time"
+
+	"golang.org/x/exp/maps"
 )
 
 var LAST_HEARTBEAT_TIMEOUT = time.Minute * 5
@@ -13,20 +15,28 @@ const (
 	NodeStatusUP      NodeStatus = "UP"
 	NodeStatusDown    NodeStatus = "DOWN"
 	NodeStatusOffline NodeStatus = "OFFLINE"
+	// the node is running but its connection
+	// to the broker is being re-established
+	NodeStatusDegraded NodeStatus = "DEGRADED"
 )
 
 type Node struct {
-	ID              string     `json:"id,omitempty"`
-	Name            string     `json:"name,omitempty"`
-	StartedAt       time.Time  `json:"startedAt,omitempty"`
-	CPUPercent      float64    `json:"cpuPercent,omitempty"`
-	LastHeartbeatAt time.Time  `json:"lastHeartbeatAt,omitempty"`
-	Queue           string     `json:"queue,omitempty"`
-	Status          NodeStatus `json:"status,omitempty"`
-	Hostname        string     `json:"hostname,omitempty"`
-	Port            int        `json:"port,omitempty"`
-	TaskCount       int        `json:"taskCount,omitempty"`
-	Version         string     `json:"version"`
+	ID              string            `json:"id,omitempty"`
+	Name            string            `json:"name,omitempty"`
+	StartedAt       time.Time         `json:"startedAt,omitempty"`
+	CPUPercent      float64           `json:"cpuPercent,omitempty"`
+	MemoryPercent   float64           `json:"memoryPercent,omitempty"`
+	DiskPercent     float64           `json:"diskPercent,omitempty"`
+	GPUs            int               `json:"gpus,omitempty"`
+	Platform        string            `json:"platform,omitempty"`
+	Tags            map[string]string `json:"tags,omitempty"`
+	LastHeartbeatAt time.Time         `json:"lastHeartbeatAt,omitempty"`
+	Queue           string            `json:"queue,omitempty"`
+	Status          NodeStatus        `json:"status,omitempty"`
+	Hostname        string            `json:"hostname,omitempty"`
+	Port            int               `json:"port,omitempty"`
+	TaskCount       int               `json:"taskCount,omitempty"`
+	Version         string            `json:"version"`
 }
 
 func (n *Node) Clone() *Node {
@@ -35,6 +45,11 @@ func (n *Node) Clone() *Node {
 		Name:            n.Name,
 		StartedAt:       n.StartedAt,
 		CPUPercent:      n.CPUPercent,
+		MemoryPercent:   n.MemoryPercent,
+		DiskPercent:     n.DiskPercent,
+		GPUs:            n.GPUs,
+		Platform:        n.Platform,
+		Tags:            maps.Clone(n.Tags),
 		LastHeartbeatAt: n.LastHeartbeatAt,
 		Queue:           n.Queue,
 		Status:          n.Status,
@@ -3,7 +3,10 @@ package tork
 import "time"
 
 const (
-	ROLE_PUBLIC string = "public"
+	ROLE_PUBLIC    string = "public"
+	ROLE_SUBMITTER string = "submitter"
+	ROLE_OPERATOR  string = "operator"
+	ROLE_ADMIN     string = "admin"
 )
 
 type Role struct {
@@ -0,0 +1,493 @@
+package containerd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/go-units"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/runabol/tork"
+	"github.com/runabol/tork/artifact"
+	"github.com/runabol/tork/internal/syncx"
+	"github.com/runabol/tork/internal/uuid"
+	"github.com/runabol/tork/mq"
+	"github.com/runabol/tork/runtime"
+)
+
+const (
+	DEFAULT_ADDRESS   = "/run/containerd/containerd.sock"
+	DEFAULT_NAMESPACE = "tork"
+	DEFAULT_CTR       = "ctr"
+	defaultWorkdir    = "/tork/workdir"
+)
+
+// the networks task containers can be attached to
+const (
+	NetworkHost = "host"
+	NetworkCNI  = "cni"
+	NetworkNone = "none"
+)
+
+// Command creates the command which runs
+// the ctr binary with the given arguments.
+type Command func(ctx context.Context, name string, args ...string) *exec.Cmd
+
+// ContainerdRuntime runs each task in a container of a
+// containerd daemon, which it drives through ctr, the
+// client that ships with containerd. Like with the docker
+// runtime, the task's files, output and progress are
+// exchanged through a directory mounted at /tork.
+type ContainerdRuntime struct {
+	ctr       string
+	address   string
+	namespace string
+	network   string
+	command   Command
+	images    *syncx.Map[string, bool]
+	broker    mq.Broker
+	artifacts artifact.Store
+	// whether a non-zero exit code doesn't fail the task
+	ignoreExitCode bool
+}
+
+type Option = func(rt *ContainerdRuntime)
+
+// WithCTR sets the path of the ctr binary. Default: ctr
+func WithCTR(ctr string) Option {
+	return func(rt *ContainerdRuntime) {
+		rt.ctr = ctr
+	}
+}
+
+// WithAddress sets the address of the containerd
+// socket. Default: /run/containerd/containerd.sock
+func WithAddress(address string) Option {
+	return func(rt *ContainerdRuntime) {
+		rt.address = address
+	}
+}
+
+// WithNamespace sets the containerd namespace of the
+// images and containers of tasks. Default: tork
+func WithNamespace(namespace string) Option {
+	return func(rt *ContainerdRuntime) {
+		rt.namespace = namespace
+	}
+}
+
+// WithNetwork sets the network of task containers: the host's
+// network, the default CNI network or none. Default: host
+func WithNetwork(network string) Option {
+	return func(rt *ContainerdRuntime) {
+		rt.network = network
+	}
+}
+
+// WithCommand overrides how ctr is executed.
+func WithCommand(c Command) Option {
+	return func(rt *ContainerdRuntime) {
+		rt.command = c
+	}
+}
+
+func WithBroker(broker mq.Broker) Option {
+	return func(rt *ContainerdRuntime) {
+		rt.broker = broker
+	}
+}
+
+// WithArtifactStore sets the store that the outputs of
+// tasks exceeding their output limit are spilled to.
+func WithArtifactStore(s artifact.Store) Option {
+	return func(rt *ContainerdRuntime) {
+		rt.artifacts = s
+	}
+}
+
+// WithIgnoreExitCode completes tasks which exit with a non-zero
+// code instead of failing them. The exit code is still recorded
+// on the task.
+func WithIgnoreExitCode(ignore bool) Option {
+	return func(rt *ContainerdRuntime) {
+		rt.ignoreExitCode = ignore
+	}
+}
+
+func NewContainerdRuntime(opts ...Option) (*ContainerdRuntime, error) {
+	rt := &ContainerdRuntime{
+		ctr:       DEFAULT_CTR,
+		address:   DEFAULT_ADDRESS,
+		namespace: DEFAULT_NAMESPACE,
+		network:   NetworkHost,
+		command:   exec.CommandContext,
+		images:    new(syncx.Map[string, bool]),
+	}
+	for _, o := range opts {
+		o(rt)
+	}
+	switch rt.network {
+	case NetworkHost, NetworkCNI, NetworkNone:
+	default:
+		return nil, errors.Errorf("unknown containerd network: %s", rt.network)
+	}
+	return rt, nil
+}
+
+func (rt *ContainerdRuntime) Run(ctx context.Context, t *tork.Task) error {
+	if t.ID == "" {
+		return errors.New("task id is required")
+	}
+	var logger io.Writer
+	if rt.broker != nil {
+		logger = mq.NewLogShipper(rt.broker, t.ID)
+	} else {
+		logger = os.Stdout
+	}
+	// excute pre-tasks
+	for _, pre := range t.Pre {
+		pre.ID = uuid.NewUUID()
+		if err := rt.doRun(ctx, pre, logger); err != nil {
+			return err
+		}
+	}
+	// run the actual task
+	if err := rt.doRun(ctx, t, logger); err != nil {
+		return err
+	}
+	// execute post tasks
+	for _, post := range t.Post {
+		post.ID = uuid.NewUUID()
+		if err := rt.doRun(ctx, post, logger); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validate(t *tork.Task) error {
+	if t.Image == "" {
+		return errors.New("image is required")
+	}
+	if len(t.Mounts) > 0 {
+		return errors.New("mounts are not supported on containerd runtime")
+	}
+	if len(t.Networks) > 0 {
+		return errors.New("networks are not supported on containerd runtime. use runtime.containerd.network instead")
+	}
+	if len(t.Ports) > 0 {
+		return errors.New("ports are not supported on containerd runtime")
+	}
+	if t.Service != nil {
+		return errors.New("services are not supported on containerd runtime")
+	}
+	if t.Security != nil {
+		return errors.New("security options are not supported on containerd runtime")
+	}
+	if t.ShmSize != "" {
+		return errors.New("shm size is not supported on containerd runtime")
+	}
+	if len(t.Ulimits) > 0 {
+		return errors.New("ulimits are not supported on containerd runtime")
+	}
+	if len(t.Devices) > 0 {
+		return errors.New("devices are not supported on containerd runtime")
+	}
+	if t.GPUs != "" {
+		return errors.New("gpus are not supported on containerd runtime")
+	}
+	if len(t.Downloads) > 0 {
+		return errors.New("downloads are not supported on containerd runtime")
+	}
+	if len(t.Artifacts) > 0 {
+		return errors.New("artifacts are not supported on containerd runtime")
+	}
+	return nil
+}
+
+func (rt *ContainerdRuntime) doRun(ctx context.Context, t *tork.Task, logger io.Writer) error {
+	if err := validate(t); err != nil {
+		return err
+	}
+	image := normalizeImage(t.Image)
+	if err := rt.pullImage(ctx, image, t); err != nil {
+		return errors.Wrapf(err, "error pulling image %s", t.Image)
+	}
+
+	dir, err := os.MkdirTemp("", "tork-containerd-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	// the task may run as any user
+	if err := os.Chmod(dir, 0777); err != nil {
+		return err
+	}
+	for _, f := range []string{"stdout", "progress"} {
+		if err := os.WriteFile(filepath.Join(dir, f), []byte{}, 0666); err != nil {
+			return errors.Wrapf(err, "error writing the %s file", f)
+		}
+		// the mode of created files is masked by the umask
+		if err := os.Chmod(filepath.Join(dir, f), 0666); err != nil {
+			return err
+		}
+	}
+	if err := os.WriteFile(filepath.Join(dir, "entrypoint"), []byte(t.Run), 0555); err != nil {
+		return errors.Wrapf(err, "error writing the entrypoint")
+	}
+	if len(t.Files) > 0 {
+		if t.Workdir == "" {
+			t.Workdir = defaultWorkdir
+		}
+		if !strings.HasPrefix(t.Workdir, "/tork/") {
+			return errors.Errorf("the workdir of a task with files must be under /tork: %s", t.Workdir)
+		}
+		wd := filepath.Join(dir, strings.TrimPrefix(t.Workdir, "/tork/"))
+		if err := os.MkdirAll(wd, 0777); err != nil {
+			return err
+		}
+		if err := os.Chmod(wd, 0777); err != nil {
+			return err
+		}
+		for filename, contents := range t.Files {
+			p := filepath.Join(wd, filename)
+			if !strings.HasPrefix(p, wd+string(filepath.Separator)) {
+				return errors.Errorf("invalid file name: %s", filename)
+			}
+			if err := os.WriteFile(p, []byte(contents), 0444); err != nil {
+				return errors.Wrapf(err, "error writing file: %s", filename)
+			}
+		}
+	}
+
+	args, err := rt.runArgs(dir, image, t)
+	if err != nil {
+		return err
+	}
+	// the container is killed through containerd when the task
+	// is cancelled, so ctr itself must outlive the context
+	cmd := rt.command(context.Background(), rt.ctr, args...)
+	cmd.Stdout = logger
+	cmd.Stderr = logger
+	if err := cmd.Start(); err != nil {
+		return errors.Wrapf(err, "error starting ctr")
+	}
+
+	go func() {
+		for {
+			progress, err := readProgress(dir)
+			if err != nil {
+				log.Error().Err(err).Msgf("error reading progress value")
+			} else if progress != t.Progress && rt.broker != nil {
+				t.Progress = progress
+				if err := rt.broker.PublishTaskProgress(ctx, t); err != nil {
+					log.Error().Err(err).Msgf("error publishing task progress")
+				}
+			}
+			select {
+			case <-time.After(time.Second * 5):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- cmd.Wait()
+	}()
+	select {
+	case err = <-errChan:
+	case <-ctx.Done():
+		if err := rt.kill(containerID(t.ID)); err != nil {
+			log.Error().Err(err).Msgf("error killing the container of task %s", t.ID)
+			_ = cmd.Process.Kill()
+		}
+		<-errChan
+		return ctx.Err()
+	}
+	if err != nil {
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) {
+			return errors.Wrapf(err, "error running container")
+		}
+		t.ExitCode = exitErr.ExitCode()
+		if !rt.ignoreExitCode || t.ExitCode <= 0 {
+			return errors.Errorf("exit code %d", exitErr.ExitCode())
+		}
+	}
+
+	output, err := os.Open(filepath.Join(dir, "stdout"))
+	if err != nil {
+		return errors.Wrapf(err, "error reading the task output")
+	}
+	defer output.Close()
+	if err := runtime.SpillResult(ctx, rt.artifacts, t, output); err != nil {
+		return errors.Wrapf(err, "error reading the task output")
+	}
+	return nil
+}
+
+// runArgs are the arguments of the ctr command
+// which runs the task in a new container.
+func (rt *ContainerdRuntime) runArgs(dir, image string, t *tork.Task) ([]string, error) {
+	args := append(rt.globalArgs(), "run", "--rm",
+		"--mount", fmt.Sprintf("type=bind,src=%s,dst=/tork,options=rbind:rw", dir),
+		"--label", "tork.task.id="+t.ID)
+	switch rt.network {
+	case NetworkHost:
+		args = append(args, "--net-host")
+	case NetworkCNI:
+		args = append(args, "--cni")
+	}
+	env := make([]string, 0, len(t.Env)+2)
+	for k, v := range t.Env {
+		env = append(env, k+"="+v)
+	}
+	sort.Strings(env)
+	env = append(env, "TORK_OUTPUT=/tork/stdout", "TORK_PROGRESS=/tork/progress")
+	for _, e := range env {
+		args = append(args, "--env", e)
+	}
+	if t.Workdir != "" {
+		args = append(args, "--cwd", t.Workdir)
+	}
+	if t.User != "" {
+		args = append(args, "--user", t.User)
+	}
+	if t.Platform != "" {
+		args = append(args, "--platform", t.Platform)
+	}
+	if t.Limits != nil && t.Limits.CPUs != "" {
+		cpus, err := strconv.ParseFloat(t.Limits.CPUs, 64)
+		if err != nil {
+			return nil, errors.Errorf("invalid CPUs value: %s", t.Limits.CPUs)
+		}
+		args = append(args, "--cpus", strconv.FormatFloat(cpus, 'f', -1, 64))
+	}
+	if t.Limits != nil && t.Limits.CPUShares != 0 {
+		args = append(args, "--cpu-shares", strconv.FormatInt(t.Limits.CPUShares, 10))
+	}
+	if t.Limits != nil && t.Limits.Memory != "" {
+		mem, err := units.RAMInBytes(t.Limits.Memory)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid memory value: %s", t.Limits.Memory)
+		}
+		args = append(args, "--memory-limit", strconv.FormatInt(mem, 10))
+	}
+	args = append(args, image, containerID(t.ID))
+	// unlike docker, ctr replaces both the entrypoint
+	// and the cmd of the image with the given args
+	switch {
+	case len(t.Entrypoint) > 0 || len(t.CMD) > 0:
+		args = append(args, t.Entrypoint...)
+		args = append(args, t.CMD...)
+	case t.Run != "":
+		args = append(args, "sh", "-c", "/tork/entrypoint")
+	}
+	return args, nil
+}
+
+func (rt *ContainerdRuntime) globalArgs() []string {
+	return []string{"--address", rt.address, "--namespace", rt.namespace}
+}
+
+// pullImage pulls the image the first time
+// it's used by a task of the worker.
+func (rt *ContainerdRuntime) pullImage(ctx context.Context, image string, t *tork.Task) error {
+	if _, ok := rt.images.Get(image); ok {
+		return nil
+	}
+	args := append(rt.globalArgs(), "images", "pull")
+	if t.Registry != nil {
+		args = append(args, "--user", t.Registry.Username+":"+t.Registry.Password)
+	}
+	if t.Platform != "" {
+		args = append(args, "--platform", t.Platform)
+	}
+	args = append(args, image)
+	var out bytes.Buffer
+	cmd := rt.command(ctx, rt.ctr, args...)
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	if err := cmd.Run(); err != nil {
+		return errors.Wrapf(err, "%s", strings.TrimSpace(out.String()))
+	}
+	// images pulled with credentials are not cached, so
+	// that other tasks must provide the credentials as well
+	if t.Registry == nil {
+		rt.images.Set(image, true)
+	}
+	return nil
+}
+
+func (rt *ContainerdRuntime) kill(id string) error {
+	args := append(rt.globalArgs(), "tasks", "kill", "--signal", "SIGKILL", id)
+	out, err := rt.command(context.Background(), rt.ctr, args...).CombinedOutput()
+	// the task may be gone already
+	if err != nil && !strings.Contains(string(out), "not found") {
+		return errors.Wrapf(err, "%s", strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// normalizeImage returns the fully qualified
+// reference of the image, which ctr requires.
+func normalizeImage(image string) string {
+	name, domain := image, "docker.io"
+	if i := strings.Index(image, "/"); i > 0 {
+		host := image[:i]
+		if strings.ContainsAny(host, ".:") || host == "localhost" {
+			domain, name = host, image[i+1:]
+		}
+	}
+	if domain == "docker.io" && !strings.Contains(name, "/") {
+		name = "library/" + name
+	}
+	last := name[strings.LastIndex(name, "/")+1:]
+	if !strings.Contains(last, ":") && !strings.Contains(name, "@") {
+		name = name + ":latest"
+	}
+	return domain + "/" + name
+}
+
+func containerID(taskID string) string {
+	return "tork-" + taskID
+}
+
+func readProgress(dir string) (float64, error) {
+	b, err := os.ReadFile(filepath.Join(dir, "progress"))
+	if err != nil {
+		return 0, err
+	}
+	s := strings.TrimSpace(string(b))
+	if s == "" {
+		return 0, nil
+	}
+	return strconv.ParseFloat(s, 32)
+}
+
+func (rt *ContainerdRuntime) Stop(ctx context.Context, t *tork.Task) error {
+	if err := rt.kill(containerID(t.ID)); err != nil {
+		return errors.Wrapf(err, "error stopping container for task: %s", t.ID)
+	}
+	return nil
+}
+
+func (rt *ContainerdRuntime) HealthCheck(ctx context.Context) error {
+	args := append(rt.globalArgs(), "version")
+	out, err := rt.command(ctx, rt.ctr, args...).CombinedOutput()
+	if err != nil {
+		return errors.Wrapf(err, "error reaching containerd: %s", strings.TrimSpace(string(out)))
+	}
+	return nil
+}
@@ -0,0 +1,205 @@
+package containerd
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/runabol/tork"
+	"github.com/runabol/tork/internal/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeCTR stands in for ctr. It records its arguments and runs
+// the entrypoint of a task on the host, in the task's directory.
+const fakeCTR = `#!/bin/sh
+echo "$@" >> "$FAKE_CTR_LOG"
+shift 4
+case "$1" in
+  images) [ -z "$FAKE_CTR_PULL_ERROR" ] || { echo "$FAKE_CTR_PULL_ERROR"; exit 1; } ;;
+  version|tasks) ;;
+  run)
+    for arg in "$@"; do
+      case "$arg" in
+        type=bind,src=*) dir=$(echo "$arg" | sed 's/^type=bind,src=\([^,]*\),.*/\1/') ;;
+      esac
+    done
+    wd="$dir"
+    [ -d "$dir/workdir" ] && wd="$dir/workdir"
+    cd "$wd" && TORK_OUTPUT="$dir/stdout" TORK_PROGRESS="$dir/progress" sh "$dir/entrypoint"
+    ;;
+esac
+`
+
+func newTestRuntime(t *testing.T, opts ...Option) (*ContainerdRuntime, string) {
+	dir := t.TempDir()
+	ctr := filepath.Join(dir, "ctr")
+	assert.NoError(t, os.WriteFile(ctr, []byte(fakeCTR), 0755))
+	calls := filepath.Join(dir, "calls")
+	t.Setenv("FAKE_CTR_LOG", calls)
+	rt, err := NewContainerdRuntime(append([]Option{WithCTR(ctr)}, opts...)...)
+	assert.NoError(t, err)
+	return rt, calls
+}
+
+func readCalls(t *testing.T, calls string) []string {
+	b, err := os.ReadFile(calls)
+	assert.NoError(t, err)
+	return strings.Split(strings.TrimSpace(string(b)), "\n")
+}
+
+func TestContainerdRuntimeRunResult(t *testing.T) {
+	rt, calls := newTestRuntime(t)
+	tk := &tork.Task{
+		ID:    uuid.NewUUID(),
+		Image: "alpine:3.18.3",
+		Run:   "echo -n hello world > $TORK_OUTPUT",
+	}
+	assert.NoError(t, rt.Run(context.Background(), tk))
+	assert.Equal(t, "hello world", tk.Result)
+
+	c := readCalls(t, calls)
+	assert.Len(t, c, 2)
+	assert.Equal(t, "--address /run/containerd/containerd.sock --namespace tork images pull docker.io/library/alpine:3.18.3", c[0])
+	assert.Contains(t, c[1], "run --rm")
+	assert.Contains(t, c[1], "--net-host")
+	assert.True(t, strings.HasSuffix(c[1], "docker.io/library/alpine:3.18.3 tork-"+tk.ID+" sh -c /tork/entrypoint"))
+}
+
+func TestContainerdRuntimeRunPullsOnce(t *testing.T) {
+	rt, calls := newTestRuntime(t)
+	for i := 0; i < 2; i++ {
+		assert.NoError(t, rt.Run(context.Background(), &tork.Task{
+			ID:    uuid.NewUUID(),
+			Image: "alpine:3.18.3",
+			Run:   "true",
+		}))
+	}
+	assert.Len(t, readCalls(t, calls), 3)
+}
+
+func TestContainerdRuntimeRunFiles(t *testing.T) {
+	rt, _ := newTestRuntime(t)
+	tk := &tork.Task{
+		ID:    uuid.NewUUID(),
+		Image: "alpine:3.18.3",
+		Run:   "cat hello.txt > $TORK_OUTPUT",
+		Files: map[string]string{"hello.txt": "hello"},
+	}
+	assert.NoError(t, rt.Run(context.Background(), tk))
+	assert.Equal(t, "hello", tk.Result)
+	assert.Equal(t, defaultWorkdir, tk.Workdir)
+}
+
+func TestContainerdRuntimeRunArgs(t *testing.T) {
+	rt, _ := newTestRuntime(t, WithNetwork(NetworkCNI))
+	tk := &tork.Task{
+		ID:         uuid.NewUUID(),
+		Image:      "ghcr.io/runabol/tork",
+		Entrypoint: []string{"/bin/tork"},
+		CMD:        []string{"run", "worker"},
+		Env:        map[string]string{"B": "2", "A": "1"},
+		User:       "1000:1000",
+		Limits: &tork.TaskLimits{
+			CPUs:      "0.5",
+			CPUShares: 512,
+			Memory:    "10m",
+		},
+	}
+	args, err := rt.runArgs("/tmp/dir", normalizeImage(tk.Image), tk)
+	assert.NoError(t, err)
+	s := strings.Join(args, " ")
+	assert.Contains(t, s, "--cni")
+	assert.Contains(t, s, "--env A=1 --env B=2 --env TORK_OUTPUT=/tork/stdout --env TORK_PROGRESS=/tork/progress")
+	assert.Contains(t, s, "--user 1000:1000")
+	assert.Contains(t, s, "--cpus 0.5 --cpu-shares 512 --memory-limit 10485760")
+	assert.True(t, strings.HasSuffix(s, "ghcr.io/runabol/tork:latest tork-"+tk.ID+" /bin/tork run worker"))
+}
+
+func TestContainerdRuntimeRunExitCode(t *testing.T) {
+	rt, _ := newTestRuntime(t)
+	tk := &tork.Task{
+		ID:    uuid.NewUUID(),
+		Image: "alpine:3.18.3",
+		Run:   "exit 2",
+	}
+	err := rt.Run(context.Background(), tk)
+	assert.EqualError(t, err, "exit code 2")
+	assert.Equal(t, 2, tk.ExitCode)
+}
+
+func TestContainerdRuntimeRunIgnoreExitCode(t *testing.T) {
+	rt, _ := newTestRuntime(t, WithIgnoreExitCode(true))
+	tk := &tork.Task{
+		ID:    uuid.NewUUID(),
+		Image: "alpine:3.18.3",
+		Run:   "echo -n partial > $TORK_OUTPUT; exit 2",
+	}
+	assert.NoError(t, rt.Run(context.Background(), tk))
+	assert.Equal(t, 2, tk.ExitCode)
+	assert.Equal(t, "partial", tk.Result)
+}
+
+func TestContainerdRuntimeRunPullError(t *testing.T) {
+	rt, _ := newTestRuntime(t)
+	t.Setenv("FAKE_CTR_PULL_ERROR", "not found")
+	err := rt.Run(context.Background(), &tork.Task{
+		ID:    uuid.NewUUID(),
+		Image: "no-such-image",
+		Run:   "true",
+	})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "not found")
+}
+
+func TestContainerdRuntimeRunCancelled(t *testing.T) {
+	rt, calls := newTestRuntime(t)
+	tk := &tork.Task{
+		ID:    uuid.NewUUID(),
+		Image: "alpine:3.18.3",
+		Run:   "sleep 1",
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer cancel()
+	assert.ErrorIs(t, rt.Run(ctx, tk), context.DeadlineExceeded)
+	// the container is killed through containerd
+	assert.Contains(t, readCalls(t, calls), "--address /run/containerd/containerd.sock --namespace tork tasks kill --signal SIGKILL tork-"+tk.ID)
+}
+
+func TestContainerdRuntimeRunNotSupported(t *testing.T) {
+	rt, _ := newTestRuntime(t)
+	tasks := []*tork.Task{
+		{ID: uuid.NewUUID(), Run: "true"},
+		{ID: uuid.NewUUID(), Image: "alpine", Mounts: []tork.Mount{{Type: "bind"}}},
+		{ID: uuid.NewUUID(), Image: "alpine", Networks: []string{"default"}},
+		{ID: uuid.NewUUID(), Image: "alpine", Artifacts: []*tork.Artifact{{Path: "out"}}},
+	}
+	for _, tk := range tasks {
+		assert.Error(t, rt.Run(context.Background(), tk))
+	}
+}
+
+func TestContainerdRuntimeBadNetwork(t *testing.T) {
+	_, err := NewContainerdRuntime(WithNetwork("bridge"))
+	assert.Error(t, err)
+}
+
+func TestContainerdRuntimeHealthCheck(t *testing.T) {
+	rt, _ := newTestRuntime(t)
+	assert.NoError(t, rt.HealthCheck(context.Background()))
+
+	rt, _ = newTestRuntime(t, WithCTR("/no/such/ctr"))
+	assert.Error(t, rt.HealthCheck(context.Background()))
+}
+
+func TestNormalizeImage(t *testing.T) {
+	assert.Equal(t, "docker.io/library/alpine:latest", normalizeImage("alpine"))
+	assert.Equal(t, "docker.io/library/alpine:3.18", normalizeImage("alpine:3.18"))
+	assert.Equal(t, "docker.io/runabol/tork:latest", normalizeImage("runabol/tork"))
+	assert.Equal(t, "ghcr.io/runabol/tork:v1", normalizeImage("ghcr.io/runabol/tork:v1"))
+	assert.Equal(t, "localhost:5000/tork:latest", normalizeImage("localhost:5000/tork"))
+	assert.Equal(t, "docker.io/library/alpine@sha256:abc", normalizeImage("alpine@sha256:abc"))
+}
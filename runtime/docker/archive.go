@@ -3,6 +3,7 @@ package docker
 import (
 	"archive/tar"
 	"bufio"
+	"io"
 	"os"
 
 	"github.com/pkg/errors"
@@ -69,3 +70,20 @@ func (a *archive) WriteFile(name string, mode int64, contents []byte) error {
 	}
 	return nil
 }
+
+// WriteReader writes the size bytes read from r
+// as a file in the archive.
+func (a *archive) WriteReader(name string, mode int64, size int64, r io.Reader) error {
+	hdr := &tar.Header{
+		Name: name,
+		Mode: mode,
+		Size: size,
+	}
+	if err := a.writer.WriteHeader(hdr); err != nil {
+		return err
+	}
+	if _, err := io.CopyN(a.writer, r, size); err != nil {
+		return err
+	}
+	return nil
+}
@@ -3,6 +3,7 @@ package docker
 import (
 	"archive/tar"
 	"io"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -29,3 +30,24 @@ func TestCreateArchive(t *testing.T) {
 
 	assert.NoError(t, ar.Remove())
 }
+
+func TestCreateArchiveFromReader(t *testing.T) {
+	ar, err := NewTempArchive()
+	assert.NoError(t, err)
+	assert.NotNil(t, ar)
+
+	err = ar.WriteReader("data/some_file.txt", 0444, 5, strings.NewReader("hello world"))
+	assert.NoError(t, err)
+
+	r := tar.NewReader(ar)
+
+	h, err := r.Next()
+	assert.NoError(t, err)
+	assert.Equal(t, "data/some_file.txt", h.Name)
+
+	b, err := io.ReadAll(r)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", string(b))
+
+	assert.NoError(t, ar.Remove())
+}
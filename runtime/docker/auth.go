@@ -193,12 +193,26 @@ func configPath(configFile string) (string, error) {
 	if configFile != "" {
 		return configFile, nil
 	}
+	if dir := os.Getenv("DOCKER_CONFIG"); dir != "" {
+		return filepath.Join(dir, "config.json"), nil
+	}
 	return userHomeConfigPath()
 }
 
-// LoadDefaultConfig loads the docker cli config from the path returned from `ConfigPath`
+// LoadDefaultConfig loads the docker cli config from the path returned from `ConfigPath`.
+//
+// When no config file is explicitly provided, the contents of the DOCKER_AUTH_CONFIG
+// env var -- if set -- take precedence over the config file on disk.
 func loadConfig(configFile string) (config, error) {
 	var cfg config
+	if configFile == "" {
+		if authConfig := os.Getenv("DOCKER_AUTH_CONFIG"); authConfig != "" {
+			if err := json.Unmarshal([]byte(authConfig), &cfg); err != nil {
+				return cfg, errors.Wrapf(err, "error decoding DOCKER_AUTH_CONFIG")
+			}
+			return cfg, nil
+		}
+	}
 	p, err := configPath(configFile)
 	if err != nil {
 		return cfg, err
@@ -2,7 +2,10 @@ package docker
 
 import (
 	"encoding/base64"
+	"fmt"
 	"testing"
+
+	"github.com/stretchr/testify/assert"
 )
 
 func TestDecodeBase64Auth(t *testing.T) {
@@ -30,6 +33,31 @@ func TestGetRegistryCredentials(t *testing.T) {
 	})
 }
 
+func TestConfigPath(t *testing.T) {
+	p, err := configPath("/some/config.json")
+	assert.NoError(t, err)
+	assert.Equal(t, "/some/config.json", p)
+
+	t.Setenv("DOCKER_CONFIG", "/etc/docker-config")
+	p, err = configPath("")
+	assert.NoError(t, err)
+	assert.Equal(t, "/etc/docker-config/config.json", p)
+}
+
+func TestLoadConfigFromEnv(t *testing.T) {
+	auth := base64.StdEncoding.EncodeToString([]byte("user:pass"))
+	t.Setenv("DOCKER_AUTH_CONFIG", fmt.Sprintf(`{"auths":{"registry.example.com":{"auth":"%s"}}}`, auth))
+
+	username, password, err := getRegistryCredentials("", "registry.example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, "user", username)
+	assert.Equal(t, "pass", password)
+
+	t.Setenv("DOCKER_AUTH_CONFIG", "not json")
+	_, _, err = getRegistryCredentials("", "registry.example.com")
+	assert.Error(t, err)
+}
+
 type base64TestCase struct {
 	name    string
 	config  authConfig
@@ -0,0 +1,92 @@
+package docker
+
+import (
+	"bytes"
+	"context"
+	"os/exec"
+	"strings"
+
+	distref "github.com/distribution/reference"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/runabol/tork"
+)
+
+// the cosign binary used to verify image signatures
+var cosignCommand = "cosign"
+
+// WithCosignKey verifies the signature of the images of
+// tasks with the given cosign public key before running them.
+// Requires the cosign binary.
+func WithCosignKey(key string) Option {
+	return func(rt *DockerRuntime) {
+		rt.cosignKey = key
+	}
+}
+
+// resolveDigest records the digest of the task's image on the
+// task and, when a cosign key is configured, verifies its signature.
+// It returns the reference pinned by digest (or the ID of an image
+// without digest) that the task's container must be created from,
+// so that a tag moved in the meantime can't swap the verified image,
+// and the ID of the image.
+func (d *DockerRuntime) resolveDigest(ctx context.Context, t *tork.Task) (string, string, error) {
+	inspect, _, err := d.client.ImageInspectWithRaw(ctx, t.Image)
+	if err != nil {
+		return "", "", errors.Wrapf(err, "error inspecting image %s", t.Image)
+	}
+	ref := repoDigest(t.Image, inspect.RepoDigests)
+	if ref == "" {
+		// e.g. an image that was built locally
+		if d.cosignKey != "" {
+			return "", "", errors.Errorf("can't verify the signature of image %s: it has no digest", t.Image)
+		}
+		t.ImageDigest = inspect.ID
+		return inspect.ID, inspect.ID, nil
+	}
+	t.ImageDigest = ref[strings.Index(ref, "@")+1:]
+	if d.cosignKey == "" {
+		return ref, inspect.ID, nil
+	}
+	if _, ok := d.verified.Get(ref); ok {
+		return ref, inspect.ID, nil
+	}
+	if err := verifySignature(ctx, d.cosignKey, ref); err != nil {
+		return "", "", err
+	}
+	d.verified.Set(ref, true)
+	return ref, inspect.ID, nil
+}
+
+// repoDigest picks the repo digest (e.g. ubuntu@sha256:...) of
+// the image's repository. The digests of other repositories (e.g.
+// of a mirror the image was also pulled from) are not considered,
+// since their signatures are not the ones of the image.
+func repoDigest(image string, digests []string) string {
+	if strings.Contains(image, "@") {
+		return image
+	}
+	named, err := distref.ParseNormalizedNamed(image)
+	if err != nil {
+		return ""
+	}
+	for _, d := range digests {
+		if rd, err := distref.ParseNormalizedNamed(d); err == nil && rd.Name() == named.Name() {
+			return d
+		}
+	}
+	return ""
+}
+
+// verifySignature verifies the signature of the
+// image ref (pinned by digest) with cosign.
+func verifySignature(ctx context.Context, key, ref string) error {
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, cosignCommand, "verify", "--key", key, ref)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return errors.Errorf("error verifying the signature of image %s: %s", ref, strings.TrimSpace(stderr.String()))
+	}
+	log.Debug().Msgf("verified the signature of image %s", ref)
+	return nil
+}
@@ -0,0 +1,56 @@
+package docker
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/runabol/tork"
+	"github.com/runabol/tork/internal/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_repoDigest(t *testing.T) {
+	mirror := "mirror.example.com/ubuntu@sha256:" + strings.Repeat("1", 64)
+	hub := "ubuntu@sha256:" + strings.Repeat("2", 64)
+	pinned := "ubuntu@sha256:" + strings.Repeat("3", 64)
+	digests := []string{mirror, hub}
+	assert.Equal(t, hub, repoDigest("ubuntu:mantic", digests))
+	assert.Equal(t, hub, repoDigest("docker.io/library/ubuntu", digests))
+	assert.Equal(t, mirror, repoDigest("mirror.example.com/ubuntu:mantic", digests))
+	assert.Equal(t, "", repoDigest("other:latest", digests))
+	assert.Equal(t, pinned, repoDigest(pinned, digests))
+	assert.Equal(t, "", repoDigest("local:latest", nil))
+}
+
+func Test_verifySignature(t *testing.T) {
+	defer func() { cosignCommand = "cosign" }()
+	ref := "ubuntu@sha256:" + strings.Repeat("2", 64)
+
+	cosignCommand = "true"
+	err := verifySignature(context.Background(), "cosign.pub", ref)
+	assert.NoError(t, err)
+
+	cosignCommand = "false"
+	err = verifySignature(context.Background(), "cosign.pub", ref)
+	assert.Error(t, err)
+}
+
+func TestRunTaskImageDigest(t *testing.T) {
+	rt, err := NewDockerRuntime()
+	assert.NoError(t, err)
+	tk := &tork.Task{
+		ID:    uuid.NewUUID(),
+		Image: "busybox:stable",
+		Run:   "echo hello",
+	}
+	err = rt.Run(context.Background(), tk)
+	assert.NoError(t, err)
+	assert.Contains(t, tk.ImageDigest, "sha256:")
+
+	// the container is created from the image pinned by digest
+	ref, imageID, err := rt.resolveDigest(context.Background(), tk)
+	assert.NoError(t, err)
+	assert.Equal(t, "busybox@"+tk.ImageDigest, ref)
+	assert.Contains(t, imageID, "sha256:")
+}
@@ -10,10 +10,15 @@ import (
 	"fmt"
 	"io"
 	"math/big"
+	"net"
+	"net/url"
 	"os"
+	"path"
 	"regexp"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	cliopts "github.com/docker/cli/opts"
@@ -25,15 +30,21 @@ import (
 	regtypes "github.com/docker/docker/api/types/registry"
 	"github.com/docker/docker/client"
 	"github.com/docker/docker/errdefs"
+	"github.com/docker/docker/pkg/jsonmessage"
 	"github.com/docker/go-connections/nat"
 	"github.com/docker/go-units"
 	"github.com/pkg/errors"
 	"github.com/rs/zerolog/log"
 	"github.com/runabol/tork"
+	"github.com/runabol/tork/artifact"
 	"github.com/runabol/tork/internal/syncx"
+	"github.com/runabol/tork/internal/tracing"
 	"github.com/runabol/tork/internal/uuid"
+	"github.com/runabol/tork/internal/wildcard"
 	"github.com/runabol/tork/mq"
 	"github.com/runabol/tork/runtime"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // defaultWorkdir is the directory where `Task.File`s are
@@ -45,15 +56,44 @@ const (
 
 var rootUserPattern = regexp.MustCompile(`^(|root|0|root(:root)?|root:0|0:root|0:0)$`)
 
+var nonAliasChars = regexp.MustCompile(`[^a-z0-9-]+`)
+
+var devicePermsPattern = regexp.MustCompile(`^[rwm]{1,3}$`)
+
 type DockerRuntime struct {
-	client  *client.Client
-	tasks   *syncx.Map[string, string]
-	images  *syncx.Map[string, bool]
-	pullq   chan *pullRequest
-	mounter runtime.Mounter
-	broker  mq.Broker
-	config  string
-	sandbox bool
+	client    *client.Client
+	tasks     *syncx.Map[string, string]
+	images    *syncx.Map[string, bool]
+	pullq     chan *pullRequest
+	mounter   runtime.Mounter
+	broker    mq.Broker
+	config    string
+	sandbox   bool
+	user      string
+	nonRoot   bool
+	security  SecurityConfig
+	ulimits   []string
+	clientCfg ClientConfig
+	artifacts artifact.Store
+	downloads artifact.FetchPolicy
+	// how often orphaned containers are reaped
+	reapInterval time.Duration
+	// how long failed containers are kept around
+	keepFailed  time.Duration
+	kept        *syncx.Map[string, bool]
+	imagePolicy runtime.ImagePolicy
+	cosignKey   string
+	verified    *syncx.Map[string, bool]
+	// in-flight image pulls
+	pulls   map[string]*pullCall
+	pullsMu sync.Mutex
+	poolCfg PoolConfig
+	pool    *pool
+	// whether a non-zero exit code doesn't fail the task
+	ignoreExitCode bool
+	// cancelled when the runtime is closed
+	ctx    context.Context
+	cancel context.CancelFunc
 }
 
 type dockerLogsReader struct {
@@ -63,11 +103,19 @@ type dockerLogsReader struct {
 type pullRequest struct {
 	ctx      context.Context
 	image    string
+	platform string
 	logger   io.Writer
 	registry registry
 	done     chan error
 }
 
+// pullCall is an image pull that concurrent
+// tasks using the same image wait for.
+type pullCall struct {
+	done chan struct{}
+	err  error
+}
+
 type registry struct {
 	username string
 	password string
@@ -75,6 +123,10 @@ type registry struct {
 
 type Option = func(rt *DockerRuntime)
 
+// the maximum duration of an image pull. Since the pull is shared
+// by the tasks using the image, it is not bound to any of them.
+var pullTimeout = time.Minute * 30
+
 func WithMounter(mounter runtime.Mounter) Option {
 	return func(rt *DockerRuntime) {
 		rt.mounter = mounter
@@ -99,32 +151,187 @@ func WithSandbox(val bool) Option {
 	}
 }
 
-func NewDockerRuntime(opts ...Option) (*DockerRuntime, error) {
-	dc, err := client.NewClientWithOpts(client.FromEnv)
-	if err != nil {
-		return nil, err
+// WithUser sets the user that containers run as
+// when the task doesn't specify one.
+func WithUser(user string) Option {
+	return func(rt *DockerRuntime) {
+		rt.user = user
 	}
-	rt := &DockerRuntime{
-		client: dc,
-		tasks:  new(syncx.Map[string, string]),
-		images: new(syncx.Map[string, bool]),
-		pullq:  make(chan *pullRequest, 1),
+}
+
+// WithNonRoot rejects tasks that would run as root.
+func WithNonRoot(val bool) Option {
+	return func(rt *DockerRuntime) {
+		rt.nonRoot = val
 	}
+}
+
+// SecurityConfig hardens the containers of all tasks.
+type SecurityConfig struct {
+	CapDrop        []string
+	SecurityOpt    []string
+	ReadOnlyRootfs bool
+	// AllowPrivileged allows tasks to
+	// run privileged containers.
+	AllowPrivileged bool
+	// AllowedDevices lists the host devices (or
+	// device directories) tasks may map into
+	// their containers.
+	AllowedDevices []string
+	// AllowedSecurityOpt lists the security options (e.g.
+	// "apparmor=tork-*") tasks may set on their containers.
+	// no-new-privileges, which only hardens the container,
+	// is always allowed.
+	AllowedSecurityOpt []string
+}
+
+// WithSecurity sets the security options
+// applied to the containers of all tasks.
+func WithSecurity(cfg SecurityConfig) Option {
+	return func(rt *DockerRuntime) {
+		rt.security = cfg
+	}
+}
+
+// WithUlimits sets the default ulimits of containers
+// (e.g. nofile=1024:65536). Tasks can override them.
+func WithUlimits(ulimits []string) Option {
+	return func(rt *DockerRuntime) {
+		rt.ulimits = ulimits
+	}
+}
+
+// WithKeepFailed keeps the containers of failed tasks around
+// for the given duration, so they can be inspected for debugging,
+// instead of removing them right away. The kept containers run a
+// snapshot of the failed container's filesystem (and its tork
+// volume), so that they can be exec'd into. Their retention is
+// recorded on the containers, so the reaper removes them once it
+// is over even if the worker was restarted.
+func WithKeepFailed(d time.Duration) Option {
+	return func(rt *DockerRuntime) {
+		rt.keepFailed = d
+	}
+}
+
+// WithImagePolicy restricts the images that tasks may use.
+func WithImagePolicy(p runtime.ImagePolicy) Option {
+	return func(rt *DockerRuntime) {
+		rt.imagePolicy = p
+	}
+}
+
+// ClientConfig configures the connection to the Docker daemon.
+// Unset values default to the DOCKER_* env vars.
+type ClientConfig struct {
+	// Host is the address of the Docker daemon
+	// (e.g. tcp://docker.example.com:2376).
+	Host string
+	// CACert, Cert and Key are the paths of the
+	// certificates used to connect over TLS.
+	CACert string
+	Cert   string
+	Key    string
+	// APIVersion pins the version of the Docker API.
+	// When empty, it's negotiated with the daemon.
+	APIVersion string
+}
+
+// WithIgnoreExitCode completes tasks which exit with a non-zero
+// code instead of failing them. The exit code is still recorded
+// on the task.
+func WithIgnoreExitCode(ignore bool) Option {
+	return func(rt *DockerRuntime) {
+		rt.ignoreExitCode = ignore
+	}
+}
+
+// WithClientConfig sets the connection to the Docker daemon.
+func WithClientConfig(cfg ClientConfig) Option {
+	return func(rt *DockerRuntime) {
+		rt.clientCfg = cfg
+	}
+}
+
+// Opts returns the options for creating
+// a Docker client with the config.
+func (c ClientConfig) Opts() []client.Opt {
+	opts := []client.Opt{client.FromEnv}
+	if c.Host != "" {
+		opts = append(opts, client.WithHost(c.Host))
+	}
+	if c.CACert != "" || c.Cert != "" || c.Key != "" {
+		opts = append(opts, client.WithTLSClientConfig(c.CACert, c.Cert, c.Key))
+	}
+	if c.APIVersion != "" {
+		opts = append(opts, client.WithVersion(c.APIVersion))
+	} else {
+		opts = append(opts, client.WithAPIVersionNegotiation())
+	}
+	return opts
+}
+
+// WithArtifactStore sets the store that the
+// artifacts produced by tasks are uploaded to.
+func WithArtifactStore(s artifact.Store) Option {
+	return func(rt *DockerRuntime) {
+		rt.artifacts = s
+	}
+}
+
+// WithFetchPolicy restricts the URLs that the inputs
+// of tasks are downloaded from and their size.
+func WithFetchPolicy(p artifact.FetchPolicy) Option {
+	return func(rt *DockerRuntime) {
+		rt.downloads = p
+	}
+}
+
+func NewDockerRuntime(opts ...Option) (*DockerRuntime, error) {
+	rt := &DockerRuntime{
+		tasks:    new(syncx.Map[string, string]),
+		images:   new(syncx.Map[string, bool]),
+		pullq:    make(chan *pullRequest, 1),
+		kept:     new(syncx.Map[string, bool]),
+		verified: new(syncx.Map[string, bool]),
+		pulls:    make(map[string]*pullCall),
+	}
+	rt.ctx, rt.cancel = context.WithCancel(context.Background())
 	for _, o := range opts {
 		o(rt)
 	}
+	dc, err := client.NewClientWithOpts(rt.clientCfg.Opts()...)
+	if err != nil {
+		return nil, err
+	}
+	rt.client = dc
 	// setup a default mounter
 	if rt.mounter == nil {
-		vmounter, err := NewVolumeMounter()
-		if err != nil {
-			return nil, err
+		rt.mounter = &VolumeMounter{
+			client:    dc,
+			ephemeral: new(syncx.Map[string, bool]),
 		}
-		rt.mounter = vmounter
 	}
 	go rt.puller()
+	if len(rt.poolCfg.Images) > 0 {
+		rt.pool = newPool(rt, rt.poolCfg)
+	}
+	if rt.reapInterval > 0 {
+		go rt.reaper()
+	}
 	return rt, nil
 }
 
+// Close stops the background work of the runtime (e.g.
+// the reaper) and removes the idle warm containers.
+func (d *DockerRuntime) Close() error {
+	d.cancel()
+	if d.pool != nil {
+		d.pool.close()
+	}
+	return nil
+}
+
 func (d *DockerRuntime) Run(ctx context.Context, t *tork.Task) error {
 	// prepare mounts
 	for i, mnt := range t.Mounts {
@@ -166,6 +373,8 @@ func (d *DockerRuntime) Run(ctx context.Context, t *tork.Task) error {
 		pre.Mounts = t.Mounts
 		pre.Networks = t.Networks
 		pre.Limits = t.Limits
+		pre.Security = t.Security
+		pre.Ulimits = t.Ulimits
 		if err := d.doRun(ctx, pre, logger); err != nil {
 			return err
 		}
@@ -180,6 +389,8 @@ func (d *DockerRuntime) Run(ctx context.Context, t *tork.Task) error {
 		post.Mounts = t.Mounts
 		post.Networks = t.Networks
 		post.Limits = t.Limits
+		post.Security = t.Security
+		post.Ulimits = t.Ulimits
 		if err := d.doRun(ctx, post, logger); err != nil {
 			return err
 		}
@@ -187,13 +398,30 @@ func (d *DockerRuntime) Run(ctx context.Context, t *tork.Task) error {
 	return nil
 }
 
-func (d *DockerRuntime) doRun(ctx context.Context, t *tork.Task, logger io.Writer) error {
+func (d *DockerRuntime) doRun(ctx context.Context, t *tork.Task, logger io.Writer) (err error) {
 	if t.ID == "" {
 		return errors.New("task id is required")
 	}
-	if err := d.imagePull(ctx, t, logger); err != nil {
+	if !t.Internal {
+		if err := d.imagePolicy.Check(t.Image); err != nil {
+			return err
+		}
+	}
+	pctx, span := tracing.Start(ctx, "tork.image.pull", trace.WithAttributes(attribute.String("image", t.Image)))
+	err = d.imagePull(pctx, t, logger)
+	tracing.End(span, err)
+	if err != nil {
 		return errors.Wrapf(err, "error pulling image: %s", t.Image)
 	}
+	// the image the container is created from
+	imageRef := t.Image
+	var imageID string
+	if !t.Internal {
+		imageRef, imageID, err = d.resolveDigest(ctx, t)
+		if err != nil {
+			return err
+		}
+	}
 
 	env := []string{}
 	for name, value := range t.Env {
@@ -202,6 +430,12 @@ func (d *DockerRuntime) doRun(ctx context.Context, t *tork.Task, logger io.Write
 	env = append(env, "TORK_OUTPUT=/tork/stdout")
 	env = append(env, "TORK_PROGRESS=/tork/progress")
 
+	if d.pool != nil {
+		if c := d.pool.acquire(t, imageID); c != nil {
+			return d.runWarm(ctx, t, c, env, logger)
+		}
+	}
+
 	var mounts []mount.Mount
 
 	for _, m := range t.Mounts {
@@ -242,7 +476,13 @@ func (d *DockerRuntime) doRun(ctx context.Context, t *tork.Task, logger io.Write
 	if err := d.mounter.Mount(ctx, torkdir); err != nil {
 		return err
 	}
+	// whether the container of the failed task is kept
+	var kept bool
 	defer func() {
+		if kept {
+			// unmounted when the container is removed
+			return
+		}
 		uctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
 		defer cancel()
 		if err := d.mounter.Unmount(uctx, torkdir); err != nil {
@@ -265,11 +505,34 @@ func (d *DockerRuntime) doRun(ctx context.Context, t *tork.Task, logger io.Write
 		return errors.Wrapf(err, "invalid memory value")
 	}
 
+	ulimits, err := parseUlimits(d.ulimits, t.Ulimits)
+	if err != nil {
+		return err
+	}
+
 	resources := container.Resources{
 		NanoCPUs: cpus,
 		Memory:   mem,
+		Ulimits:  ulimits,
+	}
+	if t.Limits != nil {
+		resources.CPUShares = t.Limits.CPUShares
+	}
+
+	var shmSize int64
+	if t.ShmSize != "" {
+		shmSize, err = units.RAMInBytes(t.ShmSize)
+		if err != nil {
+			return errors.Wrapf(err, "invalid shm size")
+		}
 	}
 
+	devices, err := d.parseDevices(t)
+	if err != nil {
+		return err
+	}
+	resources.Devices = devices
+
 	if t.GPUs != "" {
 		gpuOpts := cliopts.GpuOpts{}
 		if err := gpuOpts.Set(t.GPUs); err != nil {
@@ -293,6 +556,12 @@ func (d *DockerRuntime) doRun(ctx context.Context, t *tork.Task, logger io.Write
 		Mounts:          mounts,
 		Resources:       resources,
 		PortBindings:    portBindings,
+		ShmSize:         shmSize,
+	}
+	if !t.Internal {
+		if err := d.applySecurity(&hc, t); err != nil {
+			return err
+		}
 	}
 
 	cmd := t.CMD
@@ -304,23 +573,19 @@ func (d *DockerRuntime) doRun(ctx context.Context, t *tork.Task, logger io.Write
 		entrypoint = []string{"sh", "-c"}
 	}
 	containerConf := container.Config{
-		Image:        t.Image,
+		Image:        imageRef,
 		Env:          env,
 		Cmd:          cmd,
 		Entrypoint:   entrypoint,
 		ExposedPorts: exposedPorts,
+		Labels:       map[string]string{labelTaskID: t.ID},
 	}
-	if d.sandbox && !t.Internal {
-		imageInspect, _, err := d.client.ImageInspectWithRaw(ctx, t.Image)
+	if !t.Internal {
+		user, err := d.taskUser(ctx, t)
 		if err != nil {
 			return err
 		}
-		user := imageInspect.Config.User
-		if rootUserPattern.MatchString(user) {
-			// set a sandboxed (non-root) user
-			// only if the default user is root
-			containerConf.User = defaultSandboxUser
-		}
+		containerConf.User = user
 	}
 	// we want to override the default
 	// image WORKDIR only if the task
@@ -328,7 +593,7 @@ func (d *DockerRuntime) doRun(ctx context.Context, t *tork.Task, logger io.Write
 	// user specifies a WORKDIR
 	if t.Workdir != "" {
 		containerConf.WorkingDir = t.Workdir
-	} else if len(t.Files) > 0 {
+	} else if len(t.Files) > 0 || len(t.Downloads) > 0 {
 		t.Workdir = defaultWorkdir
 		containerConf.WorkingDir = t.Workdir
 	}
@@ -337,8 +602,22 @@ func (d *DockerRuntime) doRun(ctx context.Context, t *tork.Task, logger io.Write
 		EndpointsConfig: make(map[string]*network.EndpointSettings),
 	}
 
+	// let other containers on the network reach
+	// the task by its name (e.g. a database service)
+	var aliases []string
+	if alias := networkAlias(t.Name); alias != "" {
+		aliases = []string{alias}
+	}
 	for _, nw := range t.Networks {
-		nc.EndpointsConfig[nw] = &network.EndpointSettings{NetworkID: nw}
+		nc.EndpointsConfig[nw] = &network.EndpointSettings{
+			NetworkID: nw,
+			Aliases:   aliases,
+		}
+	}
+
+	platform, err := parsePlatform(t.Platform)
+	if err != nil {
+		return err
 	}
 
 	// we want to create the container using a background context
@@ -349,7 +628,7 @@ func (d *DockerRuntime) doRun(ctx context.Context, t *tork.Task, logger io.Write
 	createCtx, createCancel := context.WithTimeout(context.Background(), time.Second*30)
 	defer createCancel()
 	resp, err := d.client.ContainerCreate(
-		createCtx, &containerConf, &hc, &nc, nil, "")
+		createCtx, &containerConf, &hc, &nc, platform, "")
 	if err != nil {
 		log.Error().Msgf(
 			"Error creating container using image %s: %v\n",
@@ -365,6 +644,11 @@ func (d *DockerRuntime) doRun(ctx context.Context, t *tork.Task, logger io.Write
 
 	// remove the container
 	defer func() {
+		if err != nil && ctx.Err() == nil && d.keepFailed > 0 {
+			kept = true
+			d.keepContainer(t, resp.ID, torkdir)
+			return
+		}
 		stopContext, cancel := context.WithTimeout(context.Background(), time.Second*10)
 		defer cancel()
 		if err := d.Stop(stopContext, t); err != nil {
@@ -382,9 +666,17 @@ func (d *DockerRuntime) doRun(ctx context.Context, t *tork.Task, logger io.Write
 	if err := d.initWorkDir(ctx, resp.ID, t); err != nil {
 		return errors.Wrapf(err, "error initializing workdir")
 	}
+	if err := d.stageDownloads(ctx, resp.ID, t); err != nil {
+		return errors.Wrapf(err, "error staging downloads")
+	}
 
 	// start the container
 	log.Debug().Msgf("Starting container %s", resp.ID)
+	ctx, span = tracing.Start(ctx, "tork.container.run", trace.WithAttributes(
+		attribute.String("task.id", t.ID),
+		attribute.String("container.id", resp.ID),
+	))
+	defer func() { tracing.End(span, err) }()
 	err = d.client.ContainerStart(
 		ctx, resp.ID, container.StartOptions{})
 	if err != nil {
@@ -394,6 +686,21 @@ func (d *DockerRuntime) doRun(ctx context.Context, t *tork.Task, logger io.Write
 	// report task progress
 	go d.reportProgress(ctx, resp.ID, t)
 
+	// record the container's peak resource usage
+	stats := &statsCollector{}
+	go d.collectStats(ctx, resp.ID, stats)
+	defer func() {
+		t.Stats = stats.result()
+	}()
+
+	// let the job move on once the service is ready
+	if t.Service != nil {
+		if err := d.probeService(ctx, resp.ID, t); err != nil {
+			return err
+		}
+		runtime.Ready(ctx)
+	}
+
 	// read the container's stdout
 	out, err := d.client.ContainerLogs(
 		ctx,
@@ -427,7 +734,8 @@ func (d *DockerRuntime) doRun(ctx context.Context, t *tork.Task, logger io.Write
 			return err
 		}
 	case status := <-statusCh:
-		if status.StatusCode != 0 { // error
+		t.ExitCode = int(status.StatusCode)
+		if status.StatusCode != 0 && !d.ignoreExitCode { // error
 			out, err := d.client.ContainerLogs(
 				ctx,
 				resp.ID,
@@ -447,11 +755,12 @@ func (d *DockerRuntime) doRun(ctx context.Context, t *tork.Task, logger io.Write
 			}
 			return errors.Errorf("exit code %d: %s", status.StatusCode, string(buf))
 		} else {
-			stdout, err := d.readOutput(ctx, resp.ID)
-			if err != nil {
+			if err := d.readOutput(ctx, resp.ID, t); err != nil {
+				return err
+			}
+			if err := d.uploadArtifacts(ctx, resp.ID, t); err != nil {
 				return err
 			}
-			t.Result = stdout
 		}
 		log.Debug().
 			Int64("status-code", status.StatusCode).
@@ -461,6 +770,224 @@ func (d *DockerRuntime) doRun(ctx context.Context, t *tork.Task, logger io.Write
 	return nil
 }
 
+// applySecurity applies the runtime's and the
+// task's security options to the container.
+func (d *DockerRuntime) applySecurity(hc *container.HostConfig, t *tork.Task) error {
+	hc.CapDrop = append(hc.CapDrop, d.security.CapDrop...)
+	hc.SecurityOpt = append(hc.SecurityOpt, d.security.SecurityOpt...)
+	hc.ReadonlyRootfs = d.security.ReadOnlyRootfs
+	if t.Security == nil {
+		return nil
+	}
+	if t.Security.Privileged {
+		if !d.security.AllowPrivileged {
+			return errors.Errorf("task %s is not allowed to run privileged", t.ID)
+		}
+		hc.Privileged = true
+	}
+	for _, opt := range t.Security.SecurityOpt {
+		if !d.securityOptAllowed(opt) {
+			return errors.Errorf("task %s is not allowed to use the security option %s", t.ID, opt)
+		}
+	}
+	hc.CapDrop = append(hc.CapDrop, t.Security.CapDrop...)
+	hc.SecurityOpt = append(hc.SecurityOpt, t.Security.SecurityOpt...)
+	hc.ReadonlyRootfs = hc.ReadonlyRootfs || t.Security.ReadOnlyRootfs
+	return nil
+}
+
+func (d *DockerRuntime) securityOptAllowed(opt string) bool {
+	if opt == "no-new-privileges" || opt == "no-new-privileges:true" || opt == "no-new-privileges=true" {
+		return true
+	}
+	for _, allowed := range d.security.AllowedSecurityOpt {
+		if wildcard.Match(allowed, opt) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseDevices parses the task's device mappings, which use the
+// docker CLI format: host-path[:container-path[:permissions]].
+func (d *DockerRuntime) parseDevices(t *tork.Task) ([]container.DeviceMapping, error) {
+	var devices []container.DeviceMapping
+	for _, v := range t.Devices {
+		parts := strings.Split(v, ":")
+		if len(parts) > 3 || parts[0] == "" {
+			return nil, errors.Errorf("invalid device: %s", v)
+		}
+		dm := container.DeviceMapping{
+			PathOnHost:        path.Clean(parts[0]),
+			PathInContainer:   path.Clean(parts[0]),
+			CgroupPermissions: "rwm",
+		}
+		if len(parts) > 1 && parts[1] != "" {
+			dm.PathInContainer = path.Clean(parts[1])
+		}
+		if len(parts) > 2 {
+			if !devicePermsPattern.MatchString(parts[2]) {
+				return nil, errors.Errorf("invalid device permissions: %s", v)
+			}
+			dm.CgroupPermissions = parts[2]
+		}
+		if !d.deviceAllowed(dm.PathOnHost) {
+			return nil, errors.Errorf("task %s is not allowed to use device %s", t.ID, dm.PathOnHost)
+		}
+		devices = append(devices, dm)
+	}
+	return devices, nil
+}
+
+func (d *DockerRuntime) deviceAllowed(p string) bool {
+	for _, allowed := range d.security.AllowedDevices {
+		allowed = path.Clean(allowed)
+		if p == allowed || strings.HasPrefix(p, strings.TrimSuffix(allowed, "/")+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// taskUser resolves the user that the task's container runs as.
+// An empty user means the image's default user.
+func (d *DockerRuntime) taskUser(ctx context.Context, t *tork.Task) (string, error) {
+	user := t.User
+	if user == "" {
+		user = d.user
+	}
+	if !d.sandbox && !d.nonRoot {
+		return user, nil
+	}
+	if user == "" {
+		imageInspect, _, err := d.client.ImageInspectWithRaw(ctx, t.Image)
+		if err != nil {
+			return "", err
+		}
+		user = imageInspect.Config.User
+	}
+	if !rootUserPattern.MatchString(user) {
+		return user, nil
+	}
+	if d.sandbox {
+		// set a sandboxed (non-root) user
+		return defaultSandboxUser, nil
+	}
+	return "", errors.Errorf("task %s is not allowed to run as root", t.ID)
+}
+
+// probeService waits for the service task running
+// in the container to pass its readiness probe.
+func (d *DockerRuntime) probeService(ctx context.Context, containerID string, t *tork.Task) error {
+	p := t.Service.Probe
+	if p == nil {
+		return nil
+	}
+	if len(p.CMD) > 0 {
+		return runtime.WaitReady(ctx, p, func(ctx context.Context) error {
+			return d.exec(ctx, containerID, p.CMD)
+		})
+	}
+	var hostPort int
+	for _, tp := range t.Ports {
+		if tp.Port == p.Port {
+			hostPort = tp.HostPort
+		}
+	}
+	if hostPort == 0 {
+		return errors.Errorf("probe port %s is not published", p.Port)
+	}
+	addr, err := d.probeAddr(ctx, containerID, p.Port, hostPort)
+	if err != nil {
+		return err
+	}
+	return runtime.WaitReady(ctx, p, func(ctx context.Context) error {
+		return runtime.CheckAddr(ctx, p, addr)
+	})
+}
+
+// probeAddr returns the address the probe port of a service is
+// reachable at from the worker. Ports are published on the loopback
+// interface of the daemon's host, so when the daemon is remote the
+// container is reached on its IP address instead.
+func (d *DockerRuntime) probeAddr(ctx context.Context, containerID, port string, hostPort int) (string, error) {
+	if isLocalDaemon(d.client.DaemonHost()) {
+		return fmt.Sprintf("localhost:%d", hostPort), nil
+	}
+	info, err := d.client.ContainerInspect(ctx, containerID)
+	if err != nil {
+		return "", errors.Wrapf(err, "error inspecting container %s", containerID)
+	}
+	if info.NetworkSettings != nil {
+		for _, n := range info.NetworkSettings.Networks {
+			if n.IPAddress != "" {
+				return net.JoinHostPort(n.IPAddress, nat.Port(port).Port()), nil
+			}
+		}
+	}
+	return "", errors.Errorf("container %s has no IP address to probe", containerID)
+}
+
+// isLocalDaemon reports whether the daemon
+// runs on the same host as the worker.
+func isLocalDaemon(host string) bool {
+	u, err := url.Parse(host)
+	if err != nil {
+		return false
+	}
+	switch u.Scheme {
+	case "unix", "npipe":
+		return true
+	case "tcp", "http", "https":
+		if u.Hostname() == "localhost" {
+			return true
+		}
+		ip := net.ParseIP(u.Hostname())
+		return ip != nil && ip.IsLoopback()
+	}
+	return false
+}
+
+// exec runs the command inside of the
+// container and waits for it to succeed.
+func (d *DockerRuntime) exec(ctx context.Context, containerID string, cmd []string) error {
+	resp, err := d.client.ContainerExecCreate(ctx, containerID, types.ExecConfig{Cmd: cmd})
+	if err != nil {
+		return err
+	}
+	if err := d.client.ContainerExecStart(ctx, resp.ID, types.ExecStartCheck{}); err != nil {
+		return err
+	}
+	for {
+		inspect, err := d.client.ContainerExecInspect(ctx, resp.ID)
+		if err != nil {
+			return err
+		}
+		if !inspect.Running {
+			if inspect.ExitCode != 0 {
+				return errors.Errorf("%s exited with code %d", strings.Join(cmd, " "), inspect.ExitCode)
+			}
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Millisecond * 100):
+		}
+	}
+}
+
+// networkAlias converts a task name into
+// a valid DNS label (e.g. "My DB" -> "my-db").
+func networkAlias(name string) string {
+	alias := nonAliasChars.ReplaceAllString(strings.ToLower(name), "-")
+	alias = strings.Trim(alias, "-")
+	if len(alias) > 63 {
+		alias = strings.TrimRight(alias[:63], "-")
+	}
+	return alias
+}
+
 func (d *DockerRuntime) reportProgress(ctx context.Context, containerID string, t *tork.Task) {
 	for {
 		progress, err := d.readProgress(ctx, containerID)
@@ -485,10 +1012,10 @@ func (d *DockerRuntime) reportProgress(ctx context.Context, containerID string,
 	}
 }
 
-func (d *DockerRuntime) readOutput(ctx context.Context, containerID string) (string, error) {
+func (d *DockerRuntime) readOutput(ctx context.Context, containerID string, t *tork.Task) error {
 	r, _, err := d.client.CopyFromContainer(ctx, containerID, "/tork/stdout")
 	if err != nil {
-		return "", err
+		return err
 	}
 	defer func() {
 		err := r.Close()
@@ -497,21 +1024,60 @@ func (d *DockerRuntime) readOutput(ctx context.Context, containerID string) (str
 		}
 	}()
 	tr := tar.NewReader(r)
-	var buf bytes.Buffer
-	for {
-		_, err := tr.Next()
+	if _, err := tr.Next(); err != nil {
 		if err == io.EOF {
-			break // End of archive
+			return nil // empty archive
 		}
+		return err
+	}
+	return runtime.SpillResult(ctx, d.artifacts, t, tr)
+}
+
+// uploadArtifacts copies the task's artifacts out of the
+// container into the artifact store. Directories are
+// uploaded as tar archives.
+func (d *DockerRuntime) uploadArtifacts(ctx context.Context, containerID string, t *tork.Task) error {
+	if len(t.Artifacts) == 0 {
+		return nil
+	}
+	if d.artifacts == nil {
+		return errors.New("task has artifacts but no artifact store is configured")
+	}
+	info, err := d.client.ContainerInspect(ctx, containerID)
+	if err != nil {
+		return errors.Wrapf(err, "error inspecting container %s", containerID)
+	}
+	for _, a := range t.Artifacts {
+		p := a.Path
+		if !path.IsAbs(p) {
+			p = path.Join("/", info.Config.WorkingDir, p)
+		}
+		stat, err := d.client.ContainerStatPath(ctx, containerID, p)
 		if err != nil {
-			return "", err
+			return errors.Wrapf(err, "error reading artifact %s", a.Path)
 		}
-
-		if _, err := io.Copy(&buf, tr); err != nil {
-			return "", err
+		r, _, err := d.client.CopyFromContainer(ctx, containerID, p)
+		if err != nil {
+			return errors.Wrapf(err, "error reading artifact %s", a.Path)
 		}
+		var url string
+		if stat.Mode.IsDir() {
+			url, err = d.artifacts.Put(ctx, artifact.Key(t, stat.Name+".tar"), r)
+		} else {
+			tr := tar.NewReader(r)
+			if _, err = tr.Next(); err == nil {
+				url, err = d.artifacts.Put(ctx, artifact.Key(t, stat.Name), tr)
+			}
+		}
+		if cerr := r.Close(); cerr != nil {
+			log.Error().Err(cerr).Msgf("error closing artifact reader")
+		}
+		if err != nil {
+			return errors.Wrapf(err, "error uploading artifact %s", a.Path)
+		}
+		a.URL = url
 	}
-	return buf.String(), nil
+	return nil
 }
 
 func (d *DockerRuntime) readProgress(ctx context.Context, containerID string) (float64, error) {
@@ -608,6 +1174,62 @@ func (d *DockerRuntime) initWorkDir(ctx context.Context, containerID string, t *
 	return nil
 }
 
+// stageDownloads fetches the task's downloads and copies them
+// into the container. Relative paths are resolved against
+// the task's workdir.
+func (d *DockerRuntime) stageDownloads(ctx context.Context, containerID string, t *tork.Task) error {
+	if len(t.Downloads) == 0 {
+		return nil
+	}
+	ar, err := NewTempArchive()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := ar.Remove(); err != nil {
+			log.Error().Err(err).Msgf("error removing temp archive: %s", ar.Name())
+		}
+	}()
+	for _, dl := range t.Downloads {
+		p := dl.Path
+		if !path.IsAbs(p) {
+			p = path.Join(t.Workdir, p)
+		}
+		if err := d.download(ctx, ar, dl.URL, strings.TrimPrefix(path.Clean(p), "/")); err != nil {
+			return errors.Wrapf(err, "error downloading %s", dl.URL)
+		}
+	}
+	return d.client.CopyToContainer(ctx, containerID, "/", ar, types.CopyToContainerOptions{})
+}
+
+// download buffers the artifact in a temp
+// file, as its size must be known upfront
+func (d *DockerRuntime) download(ctx context.Context, ar *archive, url, name string) error {
+	src, err := d.downloads.Fetch(ctx, d.artifacts, url)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	f, err := os.CreateTemp("", "download-*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		f.Close()
+		if err := os.Remove(f.Name()); err != nil {
+			log.Error().Err(err).Msgf("error removing temp file: %s", f.Name())
+		}
+	}()
+	size, err := io.Copy(f, src)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	return ar.WriteReader(name, 0444, size, f)
+}
+
 func (d *DockerRuntime) Stop(ctx context.Context, t *tork.Task) error {
 	containerID, ok := d.tasks.Get(t.ID)
 	if !ok {
@@ -622,11 +1244,138 @@ func (d *DockerRuntime) Stop(ctx context.Context, t *tork.Task) error {
 	})
 }
 
+// keepContainer keeps the container of the failed task around
+// for debugging and removes it (and its tork volume) once the
+// retention period is over. Since the container has exited,
+// it is replaced by a container of a snapshot of its filesystem
+// which keeps running, so that it can be exec'd into.
+func (d *DockerRuntime) keepContainer(t *tork.Task, containerID string, torkdir *tork.Mount) {
+	d.tasks.Delete(t.ID)
+	until := time.Now().Add(d.keepFailed)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	keptID, imageID, err := d.debugContainer(ctx, t, containerID, until)
+	if err != nil {
+		log.Error().Err(err).Msgf("error creating debug container of failed task %s. keeping its exited container", t.ID)
+		keptID = containerID
+	}
+	d.kept.Set(keptID, true)
+	log.Info().Msgf("keeping container %s of failed task %s until %s", keptID, t.ID, until.Format(time.RFC3339))
+	time.AfterFunc(d.keepFailed, func() {
+		defer d.kept.Delete(keptID)
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+		defer cancel()
+		if err := d.client.ContainerRemove(ctx, keptID, container.RemoveOptions{
+			RemoveVolumes: true,
+			Force:         true,
+		}); err != nil {
+			log.Error().Err(err).Msgf("error removing kept container %s", keptID)
+			return
+		}
+		if imageID != "" {
+			if _, err := d.client.ImageRemove(ctx, imageID, image.RemoveOptions{PruneChildren: true}); err != nil {
+				log.Error().Err(err).Msgf("error removing image %s of kept container %s", imageID, keptID)
+			}
+		}
+		if err := d.mounter.Unmount(ctx, torkdir); err != nil {
+			log.Error().Err(err).Msgf("error unmounting workdir")
+		}
+	})
+}
+
+// debugContainer commits the exited container of the failed task to
+// an image and replaces it by a container of that image which sleeps
+// until it is removed. The container is labeled with the end of its
+// retention period, so that the reaper removes it even if the worker
+// is restarted in the meantime.
+func (d *DockerRuntime) debugContainer(ctx context.Context, t *tork.Task, containerID string, until time.Time) (string, string, error) {
+	info, err := d.client.ContainerInspect(ctx, containerID)
+	if err != nil {
+		return "", "", errors.Wrapf(err, "error inspecting container %s", containerID)
+	}
+	img, err := d.client.ContainerCommit(ctx, containerID, container.CommitOptions{
+		Comment: fmt.Sprintf("filesystem of failed task %s", t.ID),
+	})
+	if err != nil {
+		return "", "", errors.Wrapf(err, "error committing container %s", containerID)
+	}
+	cfg := *info.Config
+	cfg.Image = img.ID
+	cfg.Entrypoint = []string{"sh", "-c"}
+	cfg.Cmd = []string{"while :; do sleep 3600; done"}
+	cfg.Healthcheck = nil
+	cfg.ExposedPorts = nil
+	cfg.Labels = map[string]string{
+		labelTaskID:    t.ID,
+		labelKeepUntil: strconv.FormatInt(until.Unix(), 10),
+	}
+	hc := *info.HostConfig
+	hc.PortBindings = nil
+	hc.AutoRemove = false
+	resp, err := d.client.ContainerCreate(ctx, &cfg, &hc, nil, nil, "")
+	if err != nil {
+		d.removeImage(img.ID)
+		return "", "", errors.Wrapf(err, "error creating debug container")
+	}
+	if err := d.client.ContainerStart(ctx, resp.ID, container.StartOptions{}); err != nil {
+		d.removeContainer(resp.ID)
+		d.removeImage(img.ID)
+		return "", "", errors.Wrapf(err, "error starting debug container")
+	}
+	// the tork volume is named, so it is kept for the debug container
+	if err := d.client.ContainerRemove(ctx, containerID, container.RemoveOptions{
+		RemoveVolumes: true,
+		Force:         true,
+	}); err != nil {
+		log.Error().Err(err).Msgf("error removing container %s of failed task %s", containerID, t.ID)
+	}
+	return resp.ID, img.ID, nil
+}
+
+func (d *DockerRuntime) removeContainer(containerID string) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+	if err := d.client.ContainerRemove(ctx, containerID, container.RemoveOptions{
+		RemoveVolumes: true,
+		Force:         true,
+	}); err != nil {
+		log.Error().Err(err).Msgf("error removing container %s", containerID)
+	}
+}
+
+func (d *DockerRuntime) removeImage(imageID string) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+	if _, err := d.client.ImageRemove(ctx, imageID, image.RemoveOptions{PruneChildren: true}); err != nil {
+		log.Error().Err(err).Msgf("error removing image %s", imageID)
+	}
+}
+
 func (d *DockerRuntime) HealthCheck(ctx context.Context) error {
 	_, err := d.client.ContainerList(ctx, container.ListOptions{})
 	return err
 }
 
+// parseUlimits parses the default ulimits and the task's
+// ulimits, which take precedence over the defaults.
+func parseUlimits(defaults, ulimits []string) ([]*units.Ulimit, error) {
+	var result []*units.Ulimit
+	byName := make(map[string]int)
+	for _, v := range append(slices.Clone(defaults), ulimits...) {
+		u, err := units.ParseUlimit(v)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid ulimit")
+		}
+		if i, ok := byName[u.Name]; ok {
+			result[i] = u
+			continue
+		}
+		byName[u.Name] = len(result)
+		result = append(result, u)
+	}
+	return result, nil
+}
+
 // take from https://github.com/docker/cli/blob/9bd5ec504afd13e82d5e50b60715e7190c1b2aa0/opts/opts.go#L393-L403
 func parseCPUs(limits *tork.TaskLimits) (int64, error) {
 	if limits == nil || limits.CPUs == "" {
@@ -671,15 +1420,87 @@ func (r dockerLogsReader) Read(p []byte) (int, error) {
 }
 
 func (d *DockerRuntime) imagePull(ctx context.Context, t *tork.Task, logger io.Writer) error {
-	_, ok := d.images.Get(t.Image)
+	key := imageKey(t.Image, t.Platform)
+	_, ok := d.images.Get(key)
 	if ok {
 		return nil
 	}
+	// tasks that need an image that is already
+	// being pulled wait for that pull instead
+	d.pullsMu.Lock()
+	if call, ok := d.pulls[key]; ok {
+		d.pullsMu.Unlock()
+		select {
+		case <-call.done:
+			return call.err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	call := &pullCall{done: make(chan struct{})}
+	d.pulls[key] = call
+	d.pullsMu.Unlock()
+	// the pull is detached from the task that started it, so
+	// that cancelling the task doesn't fail the other tasks
+	// waiting for the pull
+	w := &detachableWriter{w: logger}
+	pt := &tork.Task{Image: t.Image, Platform: t.Platform, Registry: t.Registry}
+	go func() {
+		pctx, cancel := context.WithTimeout(d.ctx, pullTimeout)
+		defer cancel()
+		call.err = d.doImagePull(pctx, pt, w)
+		d.pullsMu.Lock()
+		delete(d.pulls, key)
+		d.pullsMu.Unlock()
+		close(call.done)
+	}()
+	select {
+	case <-call.done:
+		return call.err
+	case <-ctx.Done():
+		w.detach()
+		return ctx.Err()
+	}
+}
+
+// detachableWriter writes the progress of a pull to the logger of
+// the task that started it, until that task stops waiting for it.
+type detachableWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (w *detachableWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.w == nil {
+		return len(p), nil
+	}
+	return w.w.Write(p)
+}
+
+func (w *detachableWriter) detach() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.w = nil
+}
+
+// PullImage pulls the image ahead of
+// the tasks that use it.
+func (d *DockerRuntime) PullImage(ctx context.Context, image string) error {
+	if err := d.imagePolicy.Check(image); err != nil {
+		return err
+	}
+	return d.imagePull(ctx, &tork.Task{Image: image}, io.Discard)
+}
+
+func (d *DockerRuntime) doImagePull(ctx context.Context, t *tork.Task, logger io.Writer) error {
 	pr := &pullRequest{
-		ctx:    ctx,
-		image:  t.Image,
-		logger: logger,
-		done:   make(chan error),
+		ctx:      ctx,
+		image:    t.Image,
+		platform: t.Platform,
+		logger:   logger,
+		done:     make(chan error),
 	}
 	if t.Registry != nil {
 		pr.registry = registry{
@@ -690,7 +1511,7 @@ func (d *DockerRuntime) imagePull(ctx context.Context, t *tork.Task, logger io.W
 	d.pullq <- pr
 	err := <-pr.done
 	if err == nil {
-		d.images.Set(t.Image, true)
+		d.images.Set(imageKey(t.Image, t.Platform), true)
 	}
 	return err
 }
@@ -709,6 +1530,13 @@ func (d *DockerRuntime) doPullRequest(pr *pullRequest) error {
 	if err != nil {
 		return err
 	}
+	// the local image may be the variant of another platform
+	if imageExists && pr.platform != "" {
+		imageExists, err = d.imageHasPlatform(pr.ctx, pr.image, pr.platform)
+		if err != nil {
+			return err
+		}
+	}
 	if !imageExists {
 		var authConfig regtypes.AuthConfig
 		if pr.registry.username != "" {
@@ -739,13 +1567,13 @@ func (d *DockerRuntime) doPullRequest(pr *pullRequest) error {
 		}
 		authStr := base64.URLEncoding.EncodeToString(encodedJSON)
 		reader, err := d.client.ImagePull(
-			pr.ctx, pr.image, image.PullOptions{RegistryAuth: authStr})
+			pr.ctx, pr.image, image.PullOptions{RegistryAuth: authStr, Platform: pr.platform})
 		if err != nil {
 			return err
 		}
 		defer reader.Close()
 
-		if _, err := io.Copy(pr.logger, reader); err != nil {
+		if err := reportPullProgress(pr.image, reader, pr.logger); err != nil {
 			return err
 		}
 	}
@@ -753,6 +1581,48 @@ func (d *DockerRuntime) doPullRequest(pr *pullRequest) error {
 	return nil
 }
 
+// reportPullProgress parses the progress stream of an image pull
+// into log events. Status changes (e.g. "Pull complete") are also
+// written to the task's logger, while the frequent progress updates
+// of each layer are only logged at the trace level.
+func reportPullProgress(image string, r io.Reader, logger io.Writer) error {
+	dec := json.NewDecoder(r)
+	for {
+		var msg jsonmessage.JSONMessage
+		if err := dec.Decode(&msg); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return errors.Wrapf(err, "error reading the pull progress of image %s", image)
+		}
+		if msg.Error != nil {
+			return msg.Error
+		}
+		if msg.Progress != nil && msg.Progress.Current > 0 {
+			log.Trace().
+				Str("image", image).
+				Str("layer", msg.ID).
+				Str("status", msg.Status).
+				Int64("current", msg.Progress.Current).
+				Int64("total", msg.Progress.Total).
+				Msg("pulling image")
+			continue
+		}
+		log.Debug().
+			Str("image", image).
+			Str("layer", msg.ID).
+			Str("status", msg.Status).
+			Msg("pulling image")
+		line := msg.Status
+		if msg.ID != "" {
+			line = fmt.Sprintf("%s: %s", msg.ID, msg.Status)
+		}
+		if _, err := fmt.Fprintln(logger, line); err != nil {
+			return err
+		}
+	}
+}
+
 func (d *DockerRuntime) imageExistsLocally(ctx context.Context, name string) (bool, error) {
 	images, err := d.client.ImageList(
 		ctx,
@@ -767,6 +1637,12 @@ func (d *DockerRuntime) imageExistsLocally(ctx context.Context, name string) (bo
 				return true, nil
 			}
 		}
+		// images pinned by digest
+		for _, digest := range img.RepoDigests {
+			if digest == name {
+				return true, nil
+			}
+		}
 	}
 	return false, nil
 }
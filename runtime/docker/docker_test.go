@@ -5,6 +5,7 @@ import (
 	"context"
 	"io"
 	"net/http"
+	"net/http/httptest"
 	"os"
 	"path"
 	"strings"
@@ -21,6 +22,9 @@ import (
 
 	mobyarchive "github.com/moby/moby/pkg/archive"
 
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/go-units"
+	"github.com/runabol/tork/artifact"
 	"github.com/runabol/tork/internal/uuid"
 	"github.com/runabol/tork/mq"
 	"github.com/runabol/tork/runtime"
@@ -28,6 +32,22 @@ import (
 	"github.com/stretchr/testify/assert"
 )
 
+func TestNewDockerRuntimeClientConfig(t *testing.T) {
+	rt, err := NewDockerRuntime(WithClientConfig(ClientConfig{
+		Host:       "tcp://docker.example.com:2376",
+		APIVersion: "1.43",
+	}))
+	assert.NoError(t, err)
+	assert.Equal(t, "tcp://docker.example.com:2376", rt.client.DaemonHost())
+	assert.Equal(t, "1.43", rt.client.ClientVersion())
+
+	_, err = NewDockerRuntime(WithClientConfig(ClientConfig{
+		Host:   "tcp://docker.example.com:2376",
+		CACert: "/no/such/ca.pem",
+	}))
+	assert.Error(t, err)
+}
+
 func TestParseCPUs(t *testing.T) {
 	parsed, err := parseCPUs(&tork.TaskLimits{CPUs: ".25"})
 	assert.NoError(t, err)
@@ -346,7 +366,7 @@ func TestRunTaskWithVolumeAndCustomWorkdir(t *testing.T) {
 
 func TestRunTaskWithBind(t *testing.T) {
 	mm := runtime.NewMultiMounter()
-	vm, err := NewVolumeMounter()
+	vm, err := NewVolumeMounter(VolumeConfig{})
 	assert.NoError(t, err)
 	mm.RegisterMounter("bind", NewBindMounter(BindConfig{Allowed: true}))
 	mm.RegisterMounter("volume", vm)
@@ -473,9 +493,60 @@ func TestRunTaskInitWorkdirLs(t *testing.T) {
 	assert.Equal(t, "hello.txt\nlarge.txt\n", t1.Result)
 }
 
+func TestRunTaskWithArtifacts(t *testing.T) {
+	store, err := artifact.NewFileStore(t.TempDir())
+	assert.NoError(t, err)
+	rt, err := NewDockerRuntime(WithArtifactStore(store))
+	assert.NoError(t, err)
+
+	t1 := &tork.Task{
+		ID:      uuid.NewUUID(),
+		JobID:   uuid.NewUUID(),
+		Image:   "ubuntu:mantic",
+		Run:     "echo -n hello > out.txt && mkdir /reports && echo -n world > /reports/a.txt",
+		Workdir: "/xyz",
+		Artifacts: []*tork.Artifact{
+			{Path: "out.txt"},
+			{Path: "/reports"},
+		},
+	}
+	err = rt.Run(context.Background(), t1)
+	assert.NoError(t, err)
+	assert.True(t, strings.HasSuffix(t1.Artifacts[0].URL, "/out.txt"))
+	assert.True(t, strings.HasSuffix(t1.Artifacts[1].URL, "/reports.tar"))
+
+	b, err := os.ReadFile(strings.TrimPrefix(t1.Artifacts[0].URL, "file://"))
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", string(b))
+}
+
+func TestRunTaskWithDownloads(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("hello world"))
+	}))
+	defer srv.Close()
+	rt, err := NewDockerRuntime(WithFetchPolicy(artifact.FetchPolicy{
+		Hosts: []string{strings.TrimPrefix(srv.URL, "http://")},
+	}))
+	assert.NoError(t, err)
+
+	t1 := &tork.Task{
+		ID:    uuid.NewUUID(),
+		Image: "ubuntu:mantic",
+		Run:   "cat data/hello.txt /abs/hello.txt > $TORK_OUTPUT",
+		Downloads: []*tork.Artifact{
+			{URL: srv.URL + "/hello.txt", Path: "data/hello.txt"},
+			{URL: srv.URL + "/hello.txt", Path: "/abs/hello.txt"},
+		},
+	}
+	err = rt.Run(context.Background(), t1)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello worldhello world", t1.Result)
+}
+
 func TestRunTaskWithCustomMounter(t *testing.T) {
 	mounter := runtime.NewMultiMounter()
-	vmounter, err := NewVolumeMounter()
+	vmounter, err := NewVolumeMounter(VolumeConfig{})
 	assert.NoError(t, err)
 	mounter.RegisterMounter(tork.MountTypeVolume, vmounter)
 	rt, err := NewDockerRuntime(WithMounter(mounter))
@@ -529,6 +600,31 @@ func Test_imagePull(t *testing.T) {
 	wg.Wait()
 }
 
+func Test_reportPullProgress(t *testing.T) {
+	stream := `{"status":"Pulling from library/busybox","id":"stable"}
+{"status":"Pulling fs layer","progressDetail":{},"id":"ec562eabd705"}
+{"status":"Downloading","progressDetail":{"current":1024,"total":2152262},"progress":"[>   ]","id":"ec562eabd705"}
+{"status":"Download complete","progressDetail":{},"id":"ec562eabd705"}
+{"status":"Pull complete","progressDetail":{},"id":"ec562eabd705"}
+{"status":"Status: Downloaded newer image for busybox:stable"}
+`
+	var buf bytes.Buffer
+	err := reportPullProgress("busybox:stable", strings.NewReader(stream), &buf)
+	assert.NoError(t, err)
+	assert.Equal(t, `stable: Pulling from library/busybox
+ec562eabd705: Pulling fs layer
+ec562eabd705: Download complete
+ec562eabd705: Pull complete
+Status: Downloaded newer image for busybox:stable
+`, buf.String())
+
+	stream = `{"status":"Pulling from library/busybox","id":"stable"}
+{"errorDetail":{"message":"manifest unknown"},"error":"manifest unknown"}
+`
+	err = reportPullProgress("busybox:stable", strings.NewReader(stream), &buf)
+	assert.EqualError(t, err, "manifest unknown")
+}
+
 func Test_imagePullPrivateRegistry(t *testing.T) {
 	ctx := context.Background()
 
@@ -767,3 +863,303 @@ func TestRunServiceTask(t *testing.T) {
 	err = rt.Stop(context.Background(), tk)
 	assert.NoError(t, err)
 }
+
+func Test_networkAlias(t *testing.T) {
+	assert.Equal(t, "postgres", networkAlias("postgres"))
+	assert.Equal(t, "my-db", networkAlias("My DB"))
+	assert.Equal(t, "start-redis-v7", networkAlias(" start redis (v7) "))
+	assert.Equal(t, "", networkAlias("!!!"))
+	assert.Len(t, networkAlias(strings.Repeat("a", 100)), 63)
+}
+
+func TestRunServiceTaskWithProbe(t *testing.T) {
+	rt, err := NewDockerRuntime()
+	assert.NoError(t, err)
+
+	ready := make(chan any)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ctx = runtime.WithReadyFunc(ctx, func() {
+		close(ready)
+	})
+
+	tk := &tork.Task{
+		ID:    uuid.NewUUID(),
+		Image: "ubuntu:mantic",
+		Run:   "sleep 1 && touch /tmp/ready && sleep 60",
+		Service: &tork.ServiceTask{
+			Probe: &tork.Probe{
+				CMD:     []string{"test", "-f", "/tmp/ready"},
+				Timeout: "30s",
+			},
+		},
+	}
+
+	done := make(chan error)
+	go func() {
+		done <- rt.Run(ctx, tk)
+	}()
+
+	select {
+	case <-ready:
+	case err := <-done:
+		t.Fatalf("service exited before it was ready: %v", err)
+	}
+
+	// stopping the service removes the container
+	cancel()
+	assert.Error(t, <-done)
+}
+
+func TestRunServiceTaskNotReady(t *testing.T) {
+	rt, err := NewDockerRuntime()
+	assert.NoError(t, err)
+
+	tk := &tork.Task{
+		ID:    uuid.NewUUID(),
+		Image: "ubuntu:mantic",
+		Run:   "sleep 60",
+		Service: &tork.ServiceTask{
+			Probe: &tork.Probe{
+				CMD:     []string{"false"},
+				Timeout: "2s",
+			},
+		},
+	}
+
+	err = rt.Run(context.Background(), tk)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "service not ready")
+}
+
+func Test_taskUser(t *testing.T) {
+	ctx := context.Background()
+
+	rt, err := NewDockerRuntime(WithUser("1001"))
+	assert.NoError(t, err)
+	user, err := rt.taskUser(ctx, &tork.Task{})
+	assert.NoError(t, err)
+	assert.Equal(t, "1001", user)
+	user, err = rt.taskUser(ctx, &tork.Task{User: "root"})
+	assert.NoError(t, err)
+	assert.Equal(t, "root", user)
+
+	rt, err = NewDockerRuntime(WithNonRoot(true))
+	assert.NoError(t, err)
+	user, err = rt.taskUser(ctx, &tork.Task{User: "nobody"})
+	assert.NoError(t, err)
+	assert.Equal(t, "nobody", user)
+	_, err = rt.taskUser(ctx, &tork.Task{User: "0:0"})
+	assert.Error(t, err)
+
+	// the sandbox replaces root rather than rejecting it
+	rt, err = NewDockerRuntime(WithNonRoot(true), WithSandbox(true))
+	assert.NoError(t, err)
+	user, err = rt.taskUser(ctx, &tork.Task{User: "root"})
+	assert.NoError(t, err)
+	assert.Equal(t, defaultSandboxUser, user)
+}
+
+func TestRunTaskNonRoot(t *testing.T) {
+	rt, err := NewDockerRuntime(WithNonRoot(true))
+	assert.NoError(t, err)
+	// ubuntu runs as root by default
+	err = rt.Run(context.Background(), &tork.Task{
+		ID:    uuid.NewUUID(),
+		Image: "ubuntu:mantic",
+		Run:   "id -u",
+	})
+	assert.Error(t, err)
+	tk := &tork.Task{
+		ID:    uuid.NewUUID(),
+		Image: "ubuntu:mantic",
+		Run:   "id -u > $TORK_OUTPUT",
+		User:  "1001",
+	}
+	err = rt.Run(context.Background(), tk)
+	assert.NoError(t, err)
+	assert.Equal(t, "1001\n", tk.Result)
+}
+
+func Test_applySecurity(t *testing.T) {
+	rt, err := NewDockerRuntime(WithSecurity(SecurityConfig{
+		CapDrop:     []string{"NET_RAW"},
+		SecurityOpt: []string{"no-new-privileges"},
+	}))
+	assert.NoError(t, err)
+
+	hc := &container.HostConfig{}
+	err = rt.applySecurity(hc, &tork.Task{})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"NET_RAW"}, []string(hc.CapDrop))
+	assert.Equal(t, []string{"no-new-privileges"}, hc.SecurityOpt)
+	assert.False(t, hc.ReadonlyRootfs)
+
+	hc = &container.HostConfig{}
+	err = rt.applySecurity(hc, &tork.Task{Security: &tork.TaskSecurity{
+		CapDrop:        []string{"ALL"},
+		ReadOnlyRootfs: true,
+	}})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"NET_RAW", "ALL"}, []string(hc.CapDrop))
+	assert.True(t, hc.ReadonlyRootfs)
+
+	// privileged tasks must be allowed explicitly
+	err = rt.applySecurity(&container.HostConfig{}, &tork.Task{Security: &tork.TaskSecurity{Privileged: true}})
+	assert.Error(t, err)
+
+	// so are the security options of tasks, other than no-new-privileges
+	hc = &container.HostConfig{}
+	err = rt.applySecurity(hc, &tork.Task{Security: &tork.TaskSecurity{SecurityOpt: []string{"no-new-privileges:true"}}})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"no-new-privileges", "no-new-privileges:true"}, hc.SecurityOpt)
+	err = rt.applySecurity(&container.HostConfig{}, &tork.Task{Security: &tork.TaskSecurity{SecurityOpt: []string{"seccomp=unconfined"}}})
+	assert.Error(t, err)
+
+	rt, err = NewDockerRuntime(WithSecurity(SecurityConfig{AllowedSecurityOpt: []string{"apparmor=tork-*"}}))
+	assert.NoError(t, err)
+	err = rt.applySecurity(&container.HostConfig{}, &tork.Task{Security: &tork.TaskSecurity{SecurityOpt: []string{"apparmor=tork-db"}}})
+	assert.NoError(t, err)
+	err = rt.applySecurity(&container.HostConfig{}, &tork.Task{Security: &tork.TaskSecurity{SecurityOpt: []string{"apparmor=unconfined"}}})
+	assert.Error(t, err)
+
+	rt, err = NewDockerRuntime(WithSecurity(SecurityConfig{AllowPrivileged: true}))
+	assert.NoError(t, err)
+	hc = &container.HostConfig{}
+	err = rt.applySecurity(hc, &tork.Task{Security: &tork.TaskSecurity{Privileged: true}})
+	assert.NoError(t, err)
+	assert.True(t, hc.Privileged)
+}
+
+func Test_parseUlimits(t *testing.T) {
+	ulimits, err := parseUlimits(nil, nil)
+	assert.NoError(t, err)
+	assert.Empty(t, ulimits)
+
+	ulimits, err = parseUlimits([]string{"nofile=1024:65536", "nproc=4096"}, []string{"nofile=2048"})
+	assert.NoError(t, err)
+	assert.Equal(t, []*units.Ulimit{
+		{Name: "nofile", Soft: 2048, Hard: 2048},
+		{Name: "nproc", Soft: 4096, Hard: 4096},
+	}, ulimits)
+
+	_, err = parseUlimits(nil, []string{"nofile"})
+	assert.Error(t, err)
+}
+
+func Test_parseDevices(t *testing.T) {
+	rt, err := NewDockerRuntime(WithSecurity(SecurityConfig{
+		AllowedDevices: []string{"/dev/dri", "/dev/ttyUSB0"},
+	}))
+	assert.NoError(t, err)
+
+	devices, err := rt.parseDevices(&tork.Task{Devices: []string{
+		"/dev/dri/renderD128",
+		"/dev/ttyUSB0:/dev/serial:rw",
+	}})
+	assert.NoError(t, err)
+	assert.Equal(t, []container.DeviceMapping{
+		{PathOnHost: "/dev/dri/renderD128", PathInContainer: "/dev/dri/renderD128", CgroupPermissions: "rwm"},
+		{PathOnHost: "/dev/ttyUSB0", PathInContainer: "/dev/serial", CgroupPermissions: "rw"},
+	}, devices)
+
+	_, err = rt.parseDevices(&tork.Task{Devices: []string{"/dev/sda"}})
+	assert.Error(t, err)
+
+	_, err = rt.parseDevices(&tork.Task{Devices: []string{"/dev/dri/../sda"}})
+	assert.Error(t, err)
+
+	_, err = rt.parseDevices(&tork.Task{Devices: []string{"/dev/ttyUSB0::x"}})
+	assert.Error(t, err)
+}
+
+func TestRunTaskKeepFailed(t *testing.T) {
+	rt, err := NewDockerRuntime(WithKeepFailed(time.Second * 2))
+	assert.NoError(t, err)
+	err = rt.Run(context.Background(), &tork.Task{
+		ID:    uuid.NewUUID(),
+		Image: "busybox:stable",
+		Run:   "exit 1",
+	})
+	assert.Error(t, err)
+
+	var containerID string
+	rt.kept.Iterate(func(id string, _ bool) {
+		containerID = id
+	})
+	assert.NotEmpty(t, containerID)
+	info, err := rt.client.ContainerInspect(context.Background(), containerID)
+	assert.NoError(t, err)
+	assert.True(t, info.State.Running)
+	assert.NotEmpty(t, info.Config.Labels[labelKeepUntil])
+
+	exec, err := rt.client.ContainerExecCreate(context.Background(), containerID, types.ExecConfig{
+		Cmd: []string{"true"},
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, rt.client.ContainerExecStart(context.Background(), exec.ID, types.ExecStartCheck{}))
+
+	assert.Eventually(t, func() bool {
+		_, err := rt.client.ContainerInspect(context.Background(), containerID)
+		return err != nil
+	}, time.Second*10, time.Millisecond*100)
+}
+
+func TestRunTaskImagePolicy(t *testing.T) {
+	rt, err := NewDockerRuntime(WithImagePolicy(runtime.ImagePolicy{Deny: []string{"evil/*"}}))
+	assert.NoError(t, err)
+	err = rt.Run(context.Background(), &tork.Task{
+		ID:    uuid.NewUUID(),
+		Image: "evil/miner:latest",
+		Run:   "echo hello",
+	})
+	assert.EqualError(t, err, "image evil/miner:latest is not allowed")
+}
+
+func TestRunTaskReadOnlyRootfs(t *testing.T) {
+	rt, err := NewDockerRuntime()
+	assert.NoError(t, err)
+	tk := &tork.Task{
+		ID:    uuid.NewUUID(),
+		Image: "ubuntu:mantic",
+		Run:   "touch /tmp/file || echo -n readonly > $TORK_OUTPUT",
+		Security: &tork.TaskSecurity{
+			ReadOnlyRootfs: true,
+			CapDrop:        []string{"ALL"},
+		},
+	}
+	err = rt.Run(context.Background(), tk)
+	assert.NoError(t, err)
+	assert.Equal(t, "readonly", tk.Result)
+}
+
+func TestRunTaskWithShmSize(t *testing.T) {
+	rt, err := NewDockerRuntime()
+	assert.NoError(t, err)
+	tk := &tork.Task{
+		ID:      uuid.NewUUID(),
+		Image:   "ubuntu:mantic",
+		Run:     "df -k /dev/shm | tail -1 | awk '{print $2}' > $TORK_OUTPUT",
+		ShmSize: "128m",
+	}
+	err = rt.Run(context.Background(), tk)
+	assert.NoError(t, err)
+	assert.Equal(t, "131072\n", tk.Result)
+
+	err = rt.Run(context.Background(), &tork.Task{
+		ID:      uuid.NewUUID(),
+		Image:   "ubuntu:mantic",
+		Run:     "true",
+		ShmSize: "lots",
+	})
+	assert.Error(t, err)
+}
+
+func Test_isLocalDaemon(t *testing.T) {
+	assert.True(t, isLocalDaemon("unix:///var/run/docker.sock"))
+	assert.True(t, isLocalDaemon("npipe:////./pipe/docker_engine"))
+	assert.True(t, isLocalDaemon("tcp://localhost:2375"))
+	assert.True(t, isLocalDaemon("tcp://127.0.0.1:2375"))
+	assert.False(t, isLocalDaemon("tcp://10.0.0.5:2376"))
+	assert.False(t, isLocalDaemon("ssh://user@docker.example.com"))
+}
@@ -0,0 +1,57 @@
+package docker
+
+import (
+	"context"
+	"strings"
+
+	"github.com/docker/docker/errdefs"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+)
+
+// parsePlatform parses a platform in the os/arch[/variant]
+// format (e.g. linux/arm64). It returns nil for an empty platform.
+func parsePlatform(platform string) (*ocispec.Platform, error) {
+	if platform == "" {
+		return nil, nil
+	}
+	parts := strings.Split(platform, "/")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+		return nil, errors.Errorf("invalid platform: %s", platform)
+	}
+	p := &ocispec.Platform{
+		OS:           parts[0],
+		Architecture: parts[1],
+	}
+	if len(parts) == 3 {
+		p.Variant = parts[2]
+	}
+	return p, nil
+}
+
+// imageKey identifies the pulls of the image for the platform,
+// since a multi-arch image is pulled once per platform.
+func imageKey(image, platform string) string {
+	if platform == "" {
+		return image
+	}
+	return image + " " + platform
+}
+
+// imageHasPlatform reports whether the local image was built for the platform.
+func (d *DockerRuntime) imageHasPlatform(ctx context.Context, image, platform string) (bool, error) {
+	p, err := parsePlatform(platform)
+	if err != nil {
+		return false, err
+	}
+	inspect, _, err := d.client.ImageInspectWithRaw(ctx, image)
+	if err != nil {
+		if errdefs.IsNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return inspect.Os == p.OS &&
+		inspect.Architecture == p.Architecture &&
+		(p.Variant == "" || inspect.Variant == p.Variant), nil
+}
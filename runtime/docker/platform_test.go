@@ -0,0 +1,33 @@
+package docker
+
+import (
+	"testing"
+
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_parsePlatform(t *testing.T) {
+	p, err := parsePlatform("")
+	assert.NoError(t, err)
+	assert.Nil(t, p)
+
+	p, err = parsePlatform("linux/arm64")
+	assert.NoError(t, err)
+	assert.Equal(t, &ocispec.Platform{OS: "linux", Architecture: "arm64"}, p)
+
+	p, err = parsePlatform("linux/arm/v7")
+	assert.NoError(t, err)
+	assert.Equal(t, &ocispec.Platform{OS: "linux", Architecture: "arm", Variant: "v7"}, p)
+
+	_, err = parsePlatform("arm64")
+	assert.Error(t, err)
+
+	_, err = parsePlatform("linux/")
+	assert.Error(t, err)
+}
+
+func Test_imageKey(t *testing.T) {
+	assert.Equal(t, "alpine:3.18", imageKey("alpine:3.18", ""))
+	assert.NotEqual(t, imageKey("alpine:3.18", "linux/arm64"), imageKey("alpine:3.18", "linux/amd64"))
+}
@@ -0,0 +1,23 @@
+package docker
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// PodmanHost returns the address of the Docker-compatible
+// API socket exposed by the Podman service
+// (i.e. `podman system service`).
+//
+// Rootless Podman listens on a per-user socket under
+// $XDG_RUNTIME_DIR while rootful Podman uses a system-wide
+// socket.
+func PodmanHost() string {
+	if os.Geteuid() != 0 {
+		if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+			return fmt.Sprintf("unix://%s", filepath.Join(dir, "podman", "podman.sock"))
+		}
+	}
+	return "unix:///run/podman/podman.sock"
+}
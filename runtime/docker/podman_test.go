@@ -0,0 +1,17 @@
+package docker
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPodmanHost(t *testing.T) {
+	t.Setenv("XDG_RUNTIME_DIR", "/run/user/1000")
+	if os.Geteuid() == 0 {
+		assert.Equal(t, "unix:///run/podman/podman.sock", PodmanHost())
+	} else {
+		assert.Equal(t, "unix:///run/user/1000/podman/podman.sock", PodmanHost())
+	}
+}
@@ -0,0 +1,419 @@
+package docker
+
+import (
+	"context"
+	"io"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/runabol/tork"
+	"github.com/runabol/tork/internal/uuid"
+)
+
+// labelPool is the label of warm containers.
+const labelPool = "tork.pool"
+
+// PoolConfig configures the pools of warm containers that
+// short tasks are executed in, instead of paying the cost of
+// creating and starting a container for each task.
+type PoolConfig struct {
+	// Images are the images that warm containers are kept for.
+	Images []string
+	// Size is the number of warm containers kept per image.
+	Size int
+	// MaxUses is the number of tasks a warm container runs before
+	// it's replaced. Defaults to 1, i.e. containers aren't reused.
+	// Since the files that a task leaves behind are visible to the
+	// next tasks that run in the same container, a container is only
+	// reused by the tasks of the job whose task it ran first.
+	MaxUses int
+}
+
+// WithPool keeps warm containers for the images of the
+// config. Tasks that need container settings (e.g. mounts,
+// networks or limits) always run in a new container.
+func WithPool(cfg PoolConfig) Option {
+	return func(rt *DockerRuntime) {
+		rt.poolCfg = cfg
+	}
+}
+
+type pool struct {
+	d    *DockerRuntime
+	cfg  PoolConfig
+	mu   sync.Mutex
+	idle map[string][]*warmContainer
+	// the containers that ran a task and can be
+	// reused by the tasks of its job, by job ID
+	used    map[string][]*warmContainer
+	filling map[string]int
+	// the idle and busy containers of the pool
+	all map[string]*warmContainer
+}
+
+type warmContainer struct {
+	id      string
+	image   string
+	imageID string
+	// the job of the first task the container ran
+	jobID   string
+	torkdir *tork.Mount
+	uses    int
+}
+
+func newPool(d *DockerRuntime, cfg PoolConfig) *pool {
+	if cfg.Size < 1 {
+		cfg.Size = 1
+	}
+	if cfg.MaxUses < 1 {
+		cfg.MaxUses = 1
+	}
+	p := &pool{
+		d:       d,
+		cfg:     cfg,
+		idle:    make(map[string][]*warmContainer),
+		used:    make(map[string][]*warmContainer),
+		filling: make(map[string]int),
+		all:     make(map[string]*warmContainer),
+	}
+	for _, image := range cfg.Images {
+		p.fill(image)
+	}
+	return p
+}
+
+// eligible reports whether the task can run in a warm container.
+func (p *pool) eligible(t *tork.Task) bool {
+	return slices.Contains(p.cfg.Images, t.Image) &&
+		!t.Internal &&
+		(t.Run != "" || len(t.Entrypoint) > 0) &&
+		len(t.Mounts) == 0 &&
+		len(t.Networks) == 0 &&
+		len(t.Ports) == 0 &&
+		t.Service == nil &&
+		t.GPUs == "" &&
+		t.Security == nil &&
+		len(t.Devices) == 0 &&
+		len(t.Ulimits) == 0 &&
+		t.ShmSize == "" &&
+		t.Platform == "" &&
+		(t.Limits == nil || (t.Limits.CPUs == "" && t.Limits.CPUShares == 0 && t.Limits.Memory == ""))
+}
+
+// how long a used container is kept for
+// the next task of its job before it's removed
+var usedIdleTimeout = time.Minute
+
+// acquire takes a warm container for the task, if one is available.
+// A container which already ran a task is only reused by the tasks
+// of the same job. Warm containers of another image than the task's
+// (e.g. created before the image's tag was moved) are discarded.
+func (p *pool) acquire(t *tork.Task, imageID string) *warmContainer {
+	if !p.eligible(t) {
+		return nil
+	}
+	p.mu.Lock()
+	var c *warmContainer
+	var stale []*warmContainer
+	if t.JobID != "" {
+		used := p.used[t.JobID]
+		for i := len(used) - 1; i >= 0 && c == nil; i-- {
+			if used[i].image != t.Image {
+				continue
+			}
+			u := used[i]
+			used = slices.Delete(used, i, i+1)
+			if u.imageID != imageID {
+				stale = append(stale, u)
+				continue
+			}
+			c = u
+		}
+		p.setUsed(t.JobID, used)
+	}
+	for idle := p.idle[t.Image]; len(idle) > 0 && c == nil; idle = p.idle[t.Image] {
+		c = idle[len(idle)-1]
+		p.idle[t.Image] = idle[:len(idle)-1]
+		if c.imageID != imageID {
+			stale = append(stale, c)
+			c = nil
+		}
+	}
+	if c != nil && c.jobID == "" {
+		c.jobID = t.JobID
+	}
+	p.mu.Unlock()
+	for _, s := range stale {
+		go p.destroy(s)
+	}
+	p.fill(t.Image)
+	return c
+}
+
+// release keeps the container for the next task of its job,
+// unless the task failed or the container reached its maximum
+// uses, in which case the container is removed.
+func (p *pool) release(c *warmContainer, ok bool) {
+	p.mu.Lock()
+	c.uses++
+	if ok && c.jobID != "" && c.uses < p.cfg.MaxUses && len(p.used[c.jobID]) < p.cfg.Size {
+		p.used[c.jobID] = append(p.used[c.jobID], c)
+		uses := c.uses
+		p.mu.Unlock()
+		time.AfterFunc(usedIdleTimeout, func() {
+			p.evict(c, uses)
+		})
+		return
+	}
+	p.mu.Unlock()
+	go p.destroy(c)
+}
+
+// evict removes the used container if no
+// task reused it since it was released.
+func (p *pool) evict(c *warmContainer, uses int) {
+	p.mu.Lock()
+	used := p.used[c.jobID]
+	i := slices.Index(used, c)
+	if i < 0 || c.uses != uses {
+		p.mu.Unlock()
+		return
+	}
+	p.setUsed(c.jobID, slices.Delete(used, i, i+1))
+	p.mu.Unlock()
+	p.destroy(c)
+}
+
+func (p *pool) setUsed(jobID string, used []*warmContainer) {
+	if len(used) == 0 {
+		delete(p.used, jobID)
+	} else {
+		p.used[jobID] = used
+	}
+}
+
+// fill creates containers in the background until
+// the pool of the image is full.
+func (p *pool) fill(image string) {
+	p.mu.Lock()
+	n := p.cfg.Size - len(p.idle[image]) - p.filling[image]
+	if n <= 0 {
+		p.mu.Unlock()
+		return
+	}
+	p.filling[image] = p.filling[image] + n
+	p.mu.Unlock()
+	for i := 0; i < n; i++ {
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Minute*5)
+			defer cancel()
+			c, err := p.create(ctx, image)
+			p.mu.Lock()
+			defer p.mu.Unlock()
+			p.filling[image] = p.filling[image] - 1
+			if err != nil {
+				log.Error().Err(err).Msgf("error creating a warm container for image %s", image)
+				return
+			}
+			p.idle[image] = append(p.idle[image], c)
+		}()
+	}
+}
+
+// owns reports whether the container belongs to the pool.
+func (p *pool) owns(containerID string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, ok := p.all[containerID]
+	return ok
+}
+
+// close destroys the idle containers of the pool.
+func (p *pool) close() {
+	p.mu.Lock()
+	var idle []*warmContainer
+	for image, cs := range p.idle {
+		idle = append(idle, cs...)
+		delete(p.idle, image)
+	}
+	for jobID, cs := range p.used {
+		idle = append(idle, cs...)
+		delete(p.used, jobID)
+	}
+	p.mu.Unlock()
+	for _, c := range idle {
+		p.destroy(c)
+	}
+}
+
+func (p *pool) create(ctx context.Context, image string) (*warmContainer, error) {
+	d := p.d
+	if err := d.imagePull(ctx, &tork.Task{Image: image}, io.Discard); err != nil {
+		return nil, errors.Wrapf(err, "error pulling image: %s", image)
+	}
+	torkdir := &tork.Mount{
+		ID:     uuid.NewUUID(),
+		Type:   tork.MountTypeVolume,
+		Target: "/tork",
+	}
+	if err := d.mounter.Mount(ctx, torkdir); err != nil {
+		return nil, err
+	}
+	ulimits, err := parseUlimits(d.ulimits, nil)
+	if err != nil {
+		return nil, err
+	}
+	hc := container.HostConfig{
+		Mounts: []mount.Mount{{
+			Type:   mount.TypeVolume,
+			Source: torkdir.Source,
+			Target: torkdir.Target,
+		}},
+		Resources: container.Resources{Ulimits: ulimits},
+	}
+	if err := d.applySecurity(&hc, &tork.Task{}); err != nil {
+		return nil, err
+	}
+	resp, err := d.client.ContainerCreate(ctx, &container.Config{
+		Image:      image,
+		Entrypoint: []string{"sh", "-c"},
+		Cmd:        []string{"while :; do sleep 3600; done"},
+		Labels:     map[string]string{labelPool: image},
+	}, &hc, nil, nil, "")
+	if err != nil {
+		p.unmount(torkdir)
+		return nil, err
+	}
+	c := &warmContainer{id: resp.ID, image: image, torkdir: torkdir}
+	p.mu.Lock()
+	p.all[c.id] = c
+	p.mu.Unlock()
+	if err := d.client.ContainerStart(ctx, resp.ID, container.StartOptions{}); err != nil {
+		p.destroy(c)
+		return nil, err
+	}
+	info, err := d.client.ContainerInspect(ctx, resp.ID)
+	if err != nil {
+		p.destroy(c)
+		return nil, err
+	}
+	c.imageID = info.Image
+	log.Debug().Msgf("created warm container %s for image %s", resp.ID, image)
+	return c, nil
+}
+
+func (p *pool) destroy(c *warmContainer) {
+	p.mu.Lock()
+	delete(p.all, c.id)
+	p.mu.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+	if err := p.d.client.ContainerRemove(ctx, c.id, container.RemoveOptions{
+		RemoveVolumes: true,
+		Force:         true,
+	}); err != nil {
+		// e.g. it was already removed when its task was stopped
+		log.Debug().Err(err).Msgf("error removing warm container %s", c.id)
+	}
+	p.unmount(c.torkdir)
+}
+
+func (p *pool) unmount(torkdir *tork.Mount) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+	if err := p.d.mounter.Unmount(ctx, torkdir); err != nil {
+		log.Error().Err(err).Msgf("error unmounting workdir")
+	}
+}
+
+// runWarm runs the task by executing it in the warm container c.
+func (d *DockerRuntime) runWarm(ctx context.Context, t *tork.Task, c *warmContainer, env []string, logger io.Writer) (err error) {
+	d.tasks.Set(t.ID, c.id)
+	defer func() {
+		d.tasks.Delete(t.ID)
+		d.pool.release(c, err == nil)
+	}()
+
+	if t.Workdir == "" && (len(t.Files) > 0 || len(t.Downloads) > 0) {
+		t.Workdir = defaultWorkdir
+	}
+	if err := d.initTorkdir(ctx, c.id, t); err != nil {
+		return errors.Wrapf(err, "error initializing torkdir")
+	}
+	if err := d.initWorkDir(ctx, c.id, t); err != nil {
+		return errors.Wrapf(err, "error initializing workdir")
+	}
+	if err := d.stageDownloads(ctx, c.id, t); err != nil {
+		return errors.Wrapf(err, "error staging downloads")
+	}
+	user, err := d.taskUser(ctx, t)
+	if err != nil {
+		return err
+	}
+
+	cmd := t.CMD
+	if len(cmd) == 0 {
+		cmd = []string{"/tork/entrypoint"}
+	}
+	entrypoint := t.Entrypoint
+	if len(entrypoint) == 0 {
+		entrypoint = []string{"sh", "-c"}
+	}
+	exec, err := d.client.ContainerExecCreate(ctx, c.id, types.ExecConfig{
+		User:         user,
+		Env:          env,
+		WorkingDir:   t.Workdir,
+		Cmd:          append(slices.Clone(entrypoint), cmd...),
+		AttachStdout: true,
+		AttachStderr: true,
+	})
+	if err != nil {
+		return errors.Wrapf(err, "error creating exec in container %s", c.id)
+	}
+
+	// report task progress and record the peak resource usage
+	rctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go d.reportProgress(rctx, c.id, t)
+	stats := &statsCollector{}
+	go d.collectStats(rctx, c.id, stats)
+	defer func() {
+		t.Stats = stats.result()
+	}()
+
+	resp, err := d.client.ContainerExecAttach(ctx, exec.ID, types.ExecStartCheck{})
+	if err != nil {
+		return errors.Wrapf(err, "error starting exec in container %s", c.id)
+	}
+	defer resp.Close()
+	// unblock reading the output when the task is cancelled
+	go func() {
+		<-rctx.Done()
+		resp.Close()
+	}()
+	if _, err := io.Copy(logger, dockerLogsReader{reader: resp.Reader}); err != nil && ctx.Err() == nil {
+		return errors.Wrapf(err, "error reading the std out")
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	inspect, err := d.client.ContainerExecInspect(ctx, exec.ID)
+	if err != nil {
+		return err
+	}
+	t.ExitCode = inspect.ExitCode
+	if inspect.ExitCode != 0 && !d.ignoreExitCode {
+		return errors.Errorf("exit code %d", inspect.ExitCode)
+	}
+	if err := d.readOutput(ctx, c.id, t); err != nil {
+		return err
+	}
+	return d.uploadArtifacts(ctx, c.id, t)
+}
@@ -0,0 +1,84 @@
+package docker
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/runabol/tork"
+	"github.com/runabol/tork/internal/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_poolEligible(t *testing.T) {
+	p := &pool{cfg: PoolConfig{Images: []string{"busybox:stable"}}}
+	assert.True(t, p.eligible(&tork.Task{Image: "busybox:stable", Run: "echo hello"}))
+	assert.True(t, p.eligible(&tork.Task{Image: "busybox:stable", Entrypoint: []string{"echo"}}))
+	assert.False(t, p.eligible(&tork.Task{Image: "alpine:3.18", Run: "echo hello"}))
+	assert.False(t, p.eligible(&tork.Task{Image: "busybox:stable", CMD: []string{"echo"}}))
+	assert.False(t, p.eligible(&tork.Task{Image: "busybox:stable", Run: "echo hello", Internal: true}))
+	assert.False(t, p.eligible(&tork.Task{Image: "busybox:stable", Run: "echo hello", Networks: []string{"net"}}))
+	assert.False(t, p.eligible(&tork.Task{Image: "busybox:stable", Run: "echo hello", Limits: &tork.TaskLimits{Memory: "10m"}}))
+	assert.True(t, p.eligible(&tork.Task{Image: "busybox:stable", Run: "echo hello", Limits: &tork.TaskLimits{}}))
+	assert.False(t, p.eligible(&tork.Task{Image: "busybox:stable", Run: "echo hello", Mounts: []tork.Mount{{
+		Type:   tork.MountTypeVolume,
+		Target: "/somedir",
+	}}}))
+}
+
+func TestRunTaskPooled(t *testing.T) {
+	rt, err := NewDockerRuntime(WithPool(PoolConfig{
+		Images:  []string{"busybox:stable"},
+		Size:    1,
+		MaxUses: 2,
+	}))
+	assert.NoError(t, err)
+	// wait for the warm container
+	ok := assert.Eventually(t, func() bool {
+		rt.pool.mu.Lock()
+		defer rt.pool.mu.Unlock()
+		return len(rt.pool.idle["busybox:stable"]) == 1
+	}, time.Minute, time.Millisecond*100)
+	if !ok {
+		return
+	}
+	rt.pool.mu.Lock()
+	warm := rt.pool.idle["busybox:stable"][0]
+	rt.pool.mu.Unlock()
+
+	jobID := uuid.NewUUID()
+	tk := &tork.Task{
+		ID:    uuid.NewUUID(),
+		JobID: jobID,
+		Image: "busybox:stable",
+		Run:   "touch /tmp/leftover; echo -n hello $NAME > $TORK_OUTPUT",
+		Env:   map[string]string{"NAME": "world"},
+	}
+	err = rt.Run(context.Background(), tk)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello world", tk.Result)
+
+	// the container is kept for the tasks of the same job
+	rt.pool.mu.Lock()
+	assert.Equal(t, []*warmContainer{warm}, rt.pool.used[jobID])
+	rt.pool.mu.Unlock()
+
+	// the tasks of other jobs don't see the leftovers
+	err = rt.Run(context.Background(), &tork.Task{
+		ID:    uuid.NewUUID(),
+		JobID: uuid.NewUUID(),
+		Image: "busybox:stable",
+		Run:   "test ! -e /tmp/leftover",
+	})
+	assert.NoError(t, err)
+
+	tk = &tork.Task{
+		ID:    uuid.NewUUID(),
+		JobID: jobID,
+		Image: "busybox:stable",
+		Run:   "test -e /tmp/leftover && exit 3",
+	}
+	err = rt.Run(context.Background(), tk)
+	assert.Error(t, err)
+	assert.Equal(t, 3, tk.ExitCode)
+}
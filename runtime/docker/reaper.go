@@ -0,0 +1,109 @@
+package docker
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/rs/zerolog/log"
+)
+
+// labelTaskID is the label that identifies
+// the containers created by tork.
+const labelTaskID = "tork.task.id"
+
+// labelKeepUntil is the label of the containers of failed tasks
+// kept for debugging, holding the end of their retention period
+// as a unix timestamp.
+const labelKeepUntil = "tork.keep.until"
+
+// containers that were just created might not be
+// tracked yet, so the reaper leaves them alone.
+var reapGracePeriod = time.Minute
+
+// WithReaper periodically removes the containers left behind by
+// tork (e.g. after a worker crashed). Since the reaper removes any
+// tork container it doesn't track, it should only be enabled when
+// a single worker uses the Docker daemon. The reaper stops when
+// the runtime is closed.
+func WithReaper(interval time.Duration) Option {
+	return func(rt *DockerRuntime) {
+		rt.reapInterval = interval
+	}
+}
+
+// reaper reaps the orphaned containers
+// until the runtime is closed.
+func (d *DockerRuntime) reaper() {
+	for {
+		ctx, cancel := context.WithTimeout(d.ctx, time.Minute)
+		if err := d.reap(ctx); err != nil && d.ctx.Err() == nil {
+			log.Error().Err(err).Msg("error reaping orphaned containers")
+		}
+		cancel()
+		select {
+		case <-d.ctx.Done():
+			return
+		case <-time.After(d.reapInterval):
+		}
+	}
+}
+
+// reap removes the tork containers whose tasks are not
+// tracked by the runtime, and the warm containers which
+// are not part of its pool.
+func (d *DockerRuntime) reap(ctx context.Context) error {
+	for _, label := range []string{labelTaskID, labelPool} {
+		containers, err := d.client.ContainerList(ctx, container.ListOptions{
+			All:     true,
+			Filters: filters.NewArgs(filters.Arg("label", label)),
+		})
+		if err != nil {
+			return err
+		}
+		for _, c := range containers {
+			if d.tracked(c.ID, c.Labels) {
+				continue
+			}
+			if time.Since(time.Unix(c.Created, 0)) < reapGracePeriod {
+				continue
+			}
+			log.Info().Msgf("removing orphaned container %s", c.ID)
+			if err := d.client.ContainerRemove(ctx, c.ID, container.RemoveOptions{
+				RemoveVolumes: true,
+				Force:         true,
+			}); err != nil {
+				log.Error().Err(err).Msgf("error removing orphaned container %s", c.ID)
+				continue
+			}
+			if _, ok := c.Labels[labelKeepUntil]; ok {
+				// the snapshot of the failed container
+				d.removeImage(c.ImageID)
+			}
+		}
+	}
+	return nil
+}
+
+// tracked reports whether the container is in use by the runtime.
+func (d *DockerRuntime) tracked(containerID string, labels map[string]string) bool {
+	if _, ok := d.kept.Get(containerID); ok {
+		return true
+	}
+	if until, ok := labels[labelKeepUntil]; ok {
+		if ts, err := strconv.ParseInt(until, 10, 64); err == nil && time.Now().Before(time.Unix(ts, 0)) {
+			return true
+		}
+	}
+	if taskID, ok := labels[labelTaskID]; ok {
+		if _, ok := d.tasks.Get(taskID); ok {
+			return true
+		}
+	}
+	if _, ok := labels[labelPool]; ok {
+		return d.pool != nil && d.pool.owns(containerID)
+	}
+	return false
+}
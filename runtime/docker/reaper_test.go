@@ -0,0 +1,70 @@
+package docker
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/runabol/tork/internal/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReap(t *testing.T) {
+	reapGracePeriod = 0
+	rt, err := NewDockerRuntime()
+	assert.NoError(t, err)
+	ctx := context.Background()
+
+	create := func(labels map[string]string) string {
+		resp, err := rt.client.ContainerCreate(ctx, &container.Config{
+			Image:  "busybox:stable",
+			Cmd:    []string{"sleep", "60"},
+			Labels: labels,
+		}, nil, nil, nil, "")
+		assert.NoError(t, err)
+		return resp.ID
+	}
+
+	orphan := create(map[string]string{labelTaskID: uuid.NewUUID()})
+	// warm containers left behind by a previous worker
+	orphanWarm := create(map[string]string{labelPool: "busybox:stable"})
+	trackedTaskID := uuid.NewUUID()
+	tracked := create(map[string]string{labelTaskID: trackedTaskID})
+	rt.tasks.Set(trackedTaskID, tracked)
+	defer func() {
+		assert.NoError(t, rt.client.ContainerRemove(ctx, tracked, container.RemoveOptions{Force: true}))
+	}()
+
+	err = rt.reap(ctx)
+	assert.NoError(t, err)
+
+	_, err = rt.client.ContainerInspect(ctx, orphan)
+	assert.Error(t, err)
+	_, err = rt.client.ContainerInspect(ctx, orphanWarm)
+	assert.Error(t, err)
+	_, err = rt.client.ContainerInspect(ctx, tracked)
+	assert.NoError(t, err)
+}
+
+func TestReapPool(t *testing.T) {
+	reapGracePeriod = 0
+	rt, err := NewDockerRuntime(WithPool(PoolConfig{Images: []string{"busybox:stable"}}))
+	assert.NoError(t, err)
+	defer func() {
+		assert.NoError(t, rt.Close())
+	}()
+	ctx := context.Background()
+	assert.Eventually(t, func() bool {
+		rt.pool.mu.Lock()
+		defer rt.pool.mu.Unlock()
+		return len(rt.pool.idle["busybox:stable"]) == 1
+	}, time.Minute, time.Millisecond*100)
+	warm := rt.pool.idle["busybox:stable"][0]
+
+	err = rt.reap(ctx)
+	assert.NoError(t, err)
+
+	_, err = rt.client.ContainerInspect(ctx, warm.id)
+	assert.NoError(t, err)
+}
@@ -0,0 +1,93 @@
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"sync"
+
+	"github.com/docker/docker/api/types"
+	"github.com/rs/zerolog/log"
+	"github.com/runabol/tork"
+)
+
+// statsCollector keeps track of the peak
+// resource usage of a container.
+type statsCollector struct {
+	mu    sync.Mutex
+	stats *tork.TaskStats
+}
+
+func (c *statsCollector) add(s *types.StatsJSON) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.stats == nil {
+		c.stats = &tork.TaskStats{}
+	}
+	if cpu := cpuPercent(s); cpu > c.stats.PeakCPUPercent {
+		c.stats.PeakCPUPercent = cpu
+	}
+	if mem := memoryUsage(s); mem > c.stats.PeakMemory {
+		c.stats.PeakMemory = mem
+	}
+}
+
+func (c *statsCollector) result() *tork.TaskStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.stats == nil {
+		return nil
+	}
+	return c.stats.Clone()
+}
+
+// collectStats streams the container's stats
+// until it stops or ctx is cancelled.
+func (d *DockerRuntime) collectStats(ctx context.Context, containerID string, c *statsCollector) {
+	resp, err := d.client.ContainerStats(ctx, containerID, true)
+	if err != nil {
+		log.Error().Err(err).Msgf("error getting stats for container %s", containerID)
+		return
+	}
+	defer resp.Body.Close()
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var s types.StatsJSON
+		if err := dec.Decode(&s); err != nil {
+			if err != io.EOF && ctx.Err() == nil {
+				log.Debug().Err(err).Msgf("error reading stats for container %s", containerID)
+			}
+			return
+		}
+		c.add(&s)
+	}
+}
+
+// cpuPercent calculates the CPU usage the same
+// way the docker CLI does.
+func cpuPercent(s *types.StatsJSON) float64 {
+	cpuDelta := float64(s.CPUStats.CPUUsage.TotalUsage) - float64(s.PreCPUStats.CPUUsage.TotalUsage)
+	systemDelta := float64(s.CPUStats.SystemUsage) - float64(s.PreCPUStats.SystemUsage)
+	if cpuDelta <= 0 || systemDelta <= 0 {
+		return 0
+	}
+	cpus := float64(s.CPUStats.OnlineCPUs)
+	if cpus == 0 {
+		cpus = float64(len(s.CPUStats.CPUUsage.PercpuUsage))
+	}
+	return cpuDelta / systemDelta * cpus * 100
+}
+
+// memoryUsage excludes the page cache from the
+// container's memory usage like the docker CLI does.
+func memoryUsage(s *types.StatsJSON) int64 {
+	usage := s.MemoryStats.Usage
+	cache, ok := s.MemoryStats.Stats["total_inactive_file"] // cgroup v1
+	if !ok {
+		cache = s.MemoryStats.Stats["inactive_file"] // cgroup v2
+	}
+	if cache < usage {
+		usage -= cache
+	}
+	return int64(usage)
+}
@@ -0,0 +1,47 @@
+package docker
+
+import (
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/runabol/tork"
+	"github.com/stretchr/testify/assert"
+)
+
+func newStats(cpu, precpu, system, presystem, mem uint64) *types.StatsJSON {
+	s := &types.StatsJSON{}
+	s.CPUStats.OnlineCPUs = 2
+	s.CPUStats.CPUUsage.TotalUsage = cpu
+	s.CPUStats.SystemUsage = system
+	s.PreCPUStats.CPUUsage.TotalUsage = precpu
+	s.PreCPUStats.SystemUsage = presystem
+	s.MemoryStats.Usage = mem
+	s.MemoryStats.Stats = map[string]uint64{"inactive_file": 100}
+	return s
+}
+
+func Test_statsCollector(t *testing.T) {
+	c := &statsCollector{}
+	assert.Nil(t, c.result())
+
+	c.add(newStats(150, 100, 1100, 1000, 1100))
+	c.add(newStats(200, 150, 1200, 1100, 600))
+	c.add(newStats(200, 200, 1300, 1200, 900))
+
+	assert.Equal(t, &tork.TaskStats{
+		PeakCPUPercent: 100,
+		PeakMemory:     1000,
+	}, c.result())
+}
+
+func Test_memoryUsage(t *testing.T) {
+	s := &types.StatsJSON{}
+	s.MemoryStats.Usage = 1000
+	assert.Equal(t, int64(1000), memoryUsage(s))
+
+	s.MemoryStats.Stats = map[string]uint64{"total_inactive_file": 200, "inactive_file": 100}
+	assert.Equal(t, int64(800), memoryUsage(s))
+
+	s.MemoryStats.Stats = map[string]uint64{"inactive_file": 2000}
+	assert.Equal(t, int64(1000), memoryUsage(s))
+}
@@ -9,34 +9,94 @@ import (
 	"github.com/pkg/errors"
 	"github.com/rs/zerolog/log"
 	"github.com/runabol/tork"
+	"github.com/runabol/tork/internal/syncx"
 	"github.com/runabol/tork/internal/uuid"
+	"github.com/runabol/tork/internal/wildcard"
 )
 
 type VolumeMounter struct {
 	client *client.Client
+	cfg    VolumeConfig
+	// ephemeral tracks the volumes that were created
+	// by the mounter and should be removed on unmount
+	ephemeral *syncx.Map[string, bool]
 }
 
-func NewVolumeMounter() (*VolumeMounter, error) {
-	dc, err := client.NewClientWithOpts(client.FromEnv)
+// VolumeConfig restricts the named volumes tasks may mount.
+type VolumeConfig struct {
+	// Named is a list of patterns, which may contain * wildcards,
+	// of the named volumes tasks may mount. If empty, tasks may
+	// only use ephemeral volumes.
+	Named []string
+}
+
+func NewVolumeMounter(cfg VolumeConfig, opts ...client.Opt) (*VolumeMounter, error) {
+	dc, err := client.NewClientWithOpts(append([]client.Opt{client.FromEnv}, opts...)...)
 	if err != nil {
 		return nil, err
 	}
-	return &VolumeMounter{client: dc}, nil
+	return &VolumeMounter{
+		client:    dc,
+		cfg:       cfg,
+		ephemeral: new(syncx.Map[string, bool]),
+	}, nil
 }
 
 func (m *VolumeMounter) Mount(ctx context.Context, mn *tork.Mount) error {
+	// a named volume is shared across tasks and
+	// outlives any single task execution
+	if mn.Source != "" {
+		return m.mountNamed(ctx, mn)
+	}
 	name := uuid.NewUUID()
 	mn.Source = name
 	v, err := m.client.VolumeCreate(ctx, volume.CreateOptions{Name: name})
 	if err != nil {
 		return err
 	}
+	m.ephemeral.Set(name, true)
 	log.Debug().
 		Str("mount-point", v.Mountpoint).Msgf("created volume %s", v.Name)
 	return nil
 }
 
+func (m *VolumeMounter) mountNamed(ctx context.Context, mn *tork.Mount) error {
+	if !m.isNamedAllowed(mn.Source) {
+		return errors.Errorf("named volume is not allowed: %s", mn.Source)
+	}
+	ls, err := m.client.VolumeList(ctx, volume.ListOptions{Filters: filters.NewArgs(filters.Arg("name", mn.Source))})
+	if err != nil {
+		return err
+	}
+	for _, v := range ls.Volumes {
+		if v.Name == mn.Source {
+			return nil
+		}
+	}
+	v, err := m.client.VolumeCreate(ctx, volume.CreateOptions{Name: mn.Source})
+	if err != nil {
+		return errors.Wrapf(err, "error creating named volume: %s", mn.Source)
+	}
+	log.Debug().
+		Str("mount-point", v.Mountpoint).Msgf("created named volume %s", v.Name)
+	return nil
+}
+
+func (m *VolumeMounter) isNamedAllowed(name string) bool {
+	for _, pattern := range m.cfg.Named {
+		if wildcard.Match(pattern, name) {
+			return true
+		}
+	}
+	return false
+}
+
 func (m *VolumeMounter) Unmount(ctx context.Context, mn *tork.Mount) error {
+	if _, ok := m.ephemeral.Get(mn.Source); !ok {
+		// named volumes are left in place
+		return nil
+	}
+	m.ephemeral.Delete(mn.Source)
 	ls, err := m.client.VolumeList(ctx, volume.ListOptions{Filters: filters.NewArgs(filters.Arg("name", mn.Source))})
 	if err != nil {
 		return err
@@ -6,11 +6,12 @@ import (
 
 	"github.com/docker/docker/api/types/volume"
 	"github.com/runabol/tork"
+	"github.com/runabol/tork/internal/uuid"
 	"github.com/stretchr/testify/assert"
 )
 
 func TestCreateVolume(t *testing.T) {
-	vm, err := NewVolumeMounter()
+	vm, err := NewVolumeMounter(VolumeConfig{})
 	assert.NoError(t, err)
 
 	ctx := context.Background()
@@ -41,7 +42,7 @@ func TestCreateVolume(t *testing.T) {
 }
 
 func Test_createMountVolume(t *testing.T) {
-	m, err := NewVolumeMounter()
+	m, err := NewVolumeMounter(VolumeConfig{})
 	assert.NoError(t, err)
 
 	mnt := &tork.Mount{
@@ -57,3 +58,60 @@ func Test_createMountVolume(t *testing.T) {
 	assert.Equal(t, "/somevol", mnt.Target)
 	assert.NotEmpty(t, mnt.Source)
 }
+
+func Test_createMountNamedVolume(t *testing.T) {
+	m, err := NewVolumeMounter(VolumeConfig{Named: []string{"tork-named-*"}})
+	assert.NoError(t, err)
+
+	ctx := context.Background()
+	name := "tork-named-" + uuid.NewShortUUID()
+	mnt := &tork.Mount{
+		Type:   tork.MountTypeVolume,
+		Source: name,
+		Target: "/somevol",
+	}
+
+	err = m.Mount(ctx, mnt)
+	assert.NoError(t, err)
+	assert.Equal(t, name, mnt.Source)
+
+	// mounting an existing named volume is a no-op
+	err = m.Mount(ctx, mnt)
+	assert.NoError(t, err)
+
+	// named volumes outlive the task
+	err = m.Unmount(ctx, mnt)
+	assert.NoError(t, err)
+
+	ls, err := m.client.VolumeList(ctx, volume.ListOptions{})
+	assert.NoError(t, err)
+	found := false
+	for _, v := range ls.Volumes {
+		if v.Name == name {
+			found = true
+			break
+		}
+	}
+	assert.True(t, found)
+
+	assert.NoError(t, m.client.VolumeRemove(ctx, name, true))
+}
+
+func Test_createMountNamedVolumeNotAllowed(t *testing.T) {
+	m, err := NewVolumeMounter(VolumeConfig{Named: []string{"tork-named-*"}})
+	assert.NoError(t, err)
+
+	mnt := &tork.Mount{
+		Type:   tork.MountTypeVolume,
+		Source: "other-" + uuid.NewShortUUID(),
+		Target: "/somevol",
+	}
+	err = m.Mount(context.Background(), mnt)
+	assert.ErrorContains(t, err, "named volume is not allowed")
+
+	m, err = NewVolumeMounter(VolumeConfig{})
+	assert.NoError(t, err)
+	mnt.Source = "tork-named-" + uuid.NewShortUUID()
+	err = m.Mount(context.Background(), mnt)
+	assert.ErrorContains(t, err, "named volume is not allowed")
+}
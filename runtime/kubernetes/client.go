@@ -0,0 +1,160 @@
+package kubernetes
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// the files mounted into pods running
+// with a service account
+const (
+	serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+	tokenFile         = serviceAccountDir + "/token"
+	caCertFile        = serviceAccountDir + "/ca.crt"
+	namespaceFile     = serviceAccountDir + "/namespace"
+)
+
+// client is a minimal client of the
+// Kubernetes REST API.
+type client struct {
+	host   string
+	token  string
+	client *http.Client
+}
+
+// apiError is an error response of the API server.
+type apiError struct {
+	status  int
+	message string
+}
+
+func (e *apiError) Error() string {
+	if e.message == "" {
+		return http.StatusText(e.status)
+	}
+	return fmt.Sprintf("%s (%d)", e.message, e.status)
+}
+
+func isNotFound(err error) bool {
+	var apiErr *apiError
+	return errors.As(err, &apiErr) && apiErr.status == http.StatusNotFound
+}
+
+// inClusterHost is the address of the API server
+// when the worker runs in a pod of the cluster.
+func inClusterHost() string {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return ""
+	}
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	return "https://" + host + ":" + port
+}
+
+// inClusterNamespace is the namespace of the
+// worker's pod, if it runs in the cluster.
+func inClusterNamespace() string {
+	b, err := os.ReadFile(namespaceFile)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(b))
+}
+
+func newClient(host, token, caCert string) (*client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if caCert != "" {
+		pem, err := os.ReadFile(caCert)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error reading the CA certificate %s", caCert)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.Errorf("invalid CA certificate: %s", caCert)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+	return &client{
+		host:   strings.TrimSuffix(host, "/"),
+		token:  token,
+		client: &http.Client{Transport: transport},
+	}, nil
+}
+
+// bearerToken returns the configured token or the token of the
+// worker's service account, which is re-read on every request
+// since the kubelet rotates it.
+func (c *client) bearerToken() string {
+	if c.token != "" {
+		return c.token
+	}
+	b, err := os.ReadFile(tokenFile)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(b))
+}
+
+// stream sends the request and returns the body of a
+// successful response, which the caller must close.
+func (c *client) stream(ctx context.Context, method, path string, body any) (io.ReadCloser, error) {
+	var r io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		r = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.host+path, r)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Accept", "application/json")
+	if token := c.bearerToken(); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		defer resp.Body.Close()
+		status := struct {
+			Message string `json:"message"`
+		}{}
+		_ = json.NewDecoder(io.LimitReader(resp.Body, 64*1024)).Decode(&status)
+		return nil, &apiError{status: resp.StatusCode, message: status.Message}
+	}
+	return resp.Body, nil
+}
+
+// do sends the request and decodes the
+// response into out, unless it's nil.
+func (c *client) do(ctx context.Context, method, path string, body, out any) error {
+	rc, err := c.stream(ctx, method, path, body)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	if out == nil {
+		_, err = io.Copy(io.Discard, rc)
+		return err
+	}
+	return json.NewDecoder(rc).Decode(out)
+}
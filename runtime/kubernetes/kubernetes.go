@@ -0,0 +1,575 @@
+package kubernetes
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/go-units"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/runabol/tork"
+	"github.com/runabol/tork/artifact"
+	"github.com/runabol/tork/internal/uuid"
+	"github.com/runabol/tork/mq"
+	"github.com/runabol/tork/runtime"
+)
+
+const (
+	DEFAULT_NAMESPACE = "default"
+	defaultWorkdir    = "/tork/workdir"
+	labelTaskID       = "tork.task.id"
+	taskContainer     = "task"
+	// the file Kubernetes reads the termination message of
+	// a container from. It holds the output of the task.
+	terminationLog = "/dev/termination-log"
+)
+
+// how often the status of a task's pod is polled
+var pollInterval = time.Second
+
+// the reasons a container waits for which
+// it won't recover from without intervention
+var fatalWaitReasons = map[string]bool{
+	"ImagePullBackOff":           true,
+	"ErrImageNeverPull":          true,
+	"InvalidImageName":           true,
+	"CreateContainerConfigError": true,
+	"CreateContainerError":       true,
+}
+
+// KubernetesRuntime runs each task in a pod of a Kubernetes
+// cluster, through the API server's REST API. The output of
+// a task is read from the termination message of its container,
+// which Kubernetes limits to 4KB. Progress is not reported.
+type KubernetesRuntime struct {
+	host           string
+	token          string
+	caCert         string
+	namespace      string
+	serviceAccount string
+	client         *client
+	broker         mq.Broker
+	artifacts      artifact.Store
+	// whether a non-zero exit code doesn't fail the task
+	ignoreExitCode bool
+}
+
+type Option = func(rt *KubernetesRuntime)
+
+// WithHost sets the URL of the API server.
+// Default: the in-cluster address.
+func WithHost(host string) Option {
+	return func(rt *KubernetesRuntime) {
+		rt.host = host
+	}
+}
+
+// WithToken sets the bearer token used to authenticate with
+// the API server. Default: the token of the service account
+// of the worker's pod.
+func WithToken(token string) Option {
+	return func(rt *KubernetesRuntime) {
+		rt.token = token
+	}
+}
+
+// WithCACert sets the path of the CA certificate the API server's
+// certificate is verified with. Default: the in-cluster CA.
+func WithCACert(caCert string) Option {
+	return func(rt *KubernetesRuntime) {
+		rt.caCert = caCert
+	}
+}
+
+// WithNamespace sets the namespace the pods are created in.
+// Default: the namespace of the worker's pod or "default".
+func WithNamespace(namespace string) Option {
+	return func(rt *KubernetesRuntime) {
+		rt.namespace = namespace
+	}
+}
+
+// WithServiceAccount sets the service account the pods run as.
+func WithServiceAccount(sa string) Option {
+	return func(rt *KubernetesRuntime) {
+		rt.serviceAccount = sa
+	}
+}
+
+func WithBroker(broker mq.Broker) Option {
+	return func(rt *KubernetesRuntime) {
+		rt.broker = broker
+	}
+}
+
+// WithArtifactStore sets the store that the outputs of
+// tasks exceeding their output limit are spilled to.
+func WithArtifactStore(s artifact.Store) Option {
+	return func(rt *KubernetesRuntime) {
+		rt.artifacts = s
+	}
+}
+
+// WithIgnoreExitCode completes tasks which exit with a non-zero
+// code instead of failing them. The exit code is still recorded
+// on the task.
+func WithIgnoreExitCode(ignore bool) Option {
+	return func(rt *KubernetesRuntime) {
+		rt.ignoreExitCode = ignore
+	}
+}
+
+func NewKubernetesRuntime(opts ...Option) (*KubernetesRuntime, error) {
+	rt := &KubernetesRuntime{}
+	for _, o := range opts {
+		o(rt)
+	}
+	if rt.host == "" {
+		rt.host = inClusterHost()
+		if rt.host == "" {
+			return nil, errors.New("must provide the address of the kubernetes API server when not running in a cluster")
+		}
+		if rt.caCert == "" {
+			rt.caCert = caCertFile
+		}
+	}
+	if rt.namespace == "" {
+		rt.namespace = inClusterNamespace()
+	}
+	if rt.namespace == "" {
+		rt.namespace = DEFAULT_NAMESPACE
+	}
+	c, err := newClient(rt.host, rt.token, rt.caCert)
+	if err != nil {
+		return nil, err
+	}
+	rt.client = c
+	return rt, nil
+}
+
+func (rt *KubernetesRuntime) Run(ctx context.Context, t *tork.Task) error {
+	if t.ID == "" {
+		return errors.New("task id is required")
+	}
+	var logger io.Writer
+	if rt.broker != nil {
+		logger = mq.NewLogShipper(rt.broker, t.ID)
+	} else {
+		logger = os.Stdout
+	}
+	// excute pre-tasks
+	for _, pre := range t.Pre {
+		pre.ID = uuid.NewUUID()
+		if err := rt.doRun(ctx, pre, logger); err != nil {
+			return err
+		}
+	}
+	// run the actual task
+	if err := rt.doRun(ctx, t, logger); err != nil {
+		return err
+	}
+	// execute post tasks
+	for _, post := range t.Post {
+		post.ID = uuid.NewUUID()
+		if err := rt.doRun(ctx, post, logger); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validate(t *tork.Task) error {
+	if t.Image == "" {
+		return errors.New("image is required")
+	}
+	if len(t.Mounts) > 0 {
+		return errors.New("mounts are not supported on kubernetes runtime")
+	}
+	if len(t.Networks) > 0 {
+		return errors.New("networks are not supported on kubernetes runtime")
+	}
+	if len(t.Ports) > 0 {
+		return errors.New("ports are not supported on kubernetes runtime")
+	}
+	if t.Service != nil {
+		return errors.New("services are not supported on kubernetes runtime")
+	}
+	if t.Security != nil {
+		return errors.New("security options are not supported on kubernetes runtime")
+	}
+	if len(t.Ulimits) > 0 {
+		return errors.New("ulimits are not supported on kubernetes runtime")
+	}
+	if len(t.Devices) > 0 {
+		return errors.New("devices are not supported on kubernetes runtime")
+	}
+	if t.GPUs != "" {
+		return errors.New("gpus are not supported on kubernetes runtime")
+	}
+	if len(t.Downloads) > 0 {
+		return errors.New("downloads are not supported on kubernetes runtime")
+	}
+	if len(t.Artifacts) > 0 {
+		return errors.New("artifacts are not supported on kubernetes runtime")
+	}
+	return nil
+}
+
+func (rt *KubernetesRuntime) doRun(ctx context.Context, t *tork.Task, logger io.Writer) error {
+	if err := validate(t); err != nil {
+		return err
+	}
+	name := podName(t.ID)
+	p, err := rt.podSpec(name, t)
+	if err != nil {
+		return err
+	}
+	// the objects are removed using a background context, so that
+	// they are cleaned up even when the task is cancelled
+	if len(t.Files) > 0 {
+		cm := configMap{
+			APIVersion: "v1",
+			Kind:       "ConfigMap",
+			Metadata:   objectMeta{Name: name, Labels: map[string]string{labelTaskID: t.ID}},
+			Data:       make(map[string]string),
+		}
+		for _, item := range fileItems(t.Files) {
+			cm.Data[item.Key] = t.Files[item.Path]
+		}
+		if err := rt.create(ctx, "configmaps", cm); err != nil {
+			return errors.Wrapf(err, "error creating the files of task %s", t.ID)
+		}
+		defer rt.remove(context.Background(), "configmaps", name)
+	}
+	if t.Registry != nil {
+		s, err := registrySecret(name, t)
+		if err != nil {
+			return err
+		}
+		if err := rt.create(ctx, "secrets", s); err != nil {
+			return errors.Wrapf(err, "error creating the registry credentials of task %s", t.ID)
+		}
+		defer rt.remove(context.Background(), "secrets", name)
+	}
+	if err := rt.create(ctx, "pods", p); err != nil {
+		return errors.Wrapf(err, "error creating pod for task %s", t.ID)
+	}
+	defer rt.remove(context.Background(), "pods", name)
+	log.Debug().Msgf("created pod %s for task %s", name, t.ID)
+
+	// wait for the pod to terminate, shipping the
+	// logs of the task once its container started
+	var logsDone chan struct{}
+	var status *containerStateTerminated
+	for status == nil {
+		current := pod{}
+		if err := rt.client.do(ctx, http.MethodGet, rt.path("pods", name), nil, &current); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return errors.Wrapf(err, "error getting pod %s", name)
+		}
+		for _, cs := range append(current.Status.InitContainerStatuses, current.Status.ContainerStatuses...) {
+			if w := cs.State.Waiting; w != nil && fatalWaitReasons[w.Reason] {
+				return errors.Errorf("error starting task: %s: %s", w.Reason, w.Message)
+			}
+		}
+		if current.Status.Phase != "Pending" && logsDone == nil {
+			logsDone = make(chan struct{})
+			go func() {
+				defer close(logsDone)
+				if err := rt.shipLogs(ctx, name, logger); err != nil && ctx.Err() == nil {
+					log.Error().Err(err).Msgf("error reading the logs of pod %s", name)
+				}
+			}()
+		}
+		switch current.Status.Phase {
+		case "Succeeded", "Failed":
+			if cs := current.status(taskContainer); cs != nil && cs.State.Terminated != nil {
+				status = cs.State.Terminated
+			} else {
+				return errors.Errorf("pod %s failed: %s %s", name, current.Status.Reason, current.Status.Message)
+			}
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(pollInterval):
+		}
+	}
+	select {
+	case <-logsDone:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	t.ExitCode = status.ExitCode
+	if status.ExitCode != 0 && !rt.ignoreExitCode {
+		tail, err := rt.tailLogs(ctx, name)
+		if err != nil {
+			log.Error().Err(err).Msg("error tailing the log")
+			return errors.Errorf("exit code %d", status.ExitCode)
+		}
+		return errors.Errorf("exit code %d: %s", status.ExitCode, tail)
+	}
+	if err := runtime.SpillResult(ctx, rt.artifacts, t, strings.NewReader(status.Message)); err != nil {
+		return errors.Wrapf(err, "error reading the task output")
+	}
+	return nil
+}
+
+// podSpec maps the task onto a pod which runs it once.
+func (rt *KubernetesRuntime) podSpec(name string, t *tork.Task) (pod, error) {
+	env := make([]envVar, 0, len(t.Env)+2)
+	for k, v := range t.Env {
+		env = append(env, envVar{Name: k, Value: v})
+	}
+	sort.Slice(env, func(i, j int) bool { return env[i].Name < env[j].Name })
+	env = append(env,
+		envVar{Name: "TORK_OUTPUT", Value: terminationLog},
+		envVar{Name: "TORK_PROGRESS", Value: "/dev/null"})
+
+	c := container{
+		Name:       taskContainer,
+		Image:      t.Image,
+		Command:    t.Entrypoint,
+		Args:       t.CMD,
+		WorkingDir: t.Workdir,
+		Env:        env,
+	}
+	if len(c.Command) == 0 && t.Run != "" {
+		c.Command = []string{"sh", "-c"}
+	}
+	if len(c.Args) == 0 && t.Run != "" {
+		c.Args = []string{t.Run}
+	}
+	if t.Limits != nil {
+		limits := make(map[string]string)
+		if t.Limits.CPUs != "" {
+			if _, err := strconv.ParseFloat(t.Limits.CPUs, 64); err != nil {
+				return pod{}, errors.Errorf("invalid CPUs value: %s", t.Limits.CPUs)
+			}
+			limits["cpu"] = t.Limits.CPUs
+		}
+		if t.Limits.Memory != "" {
+			mem, err := units.RAMInBytes(t.Limits.Memory)
+			if err != nil {
+				return pod{}, errors.Wrapf(err, "invalid memory value: %s", t.Limits.Memory)
+			}
+			limits["memory"] = strconv.FormatInt(mem, 10)
+		}
+		if len(limits) > 0 {
+			c.Resources.Limits = limits
+		}
+	}
+
+	p := pod{
+		APIVersion: "v1",
+		Kind:       "Pod",
+		Metadata:   objectMeta{Name: name, Labels: map[string]string{labelTaskID: t.ID}},
+		Spec: podSpec{
+			// retries are up to the coordinator
+			RestartPolicy:      "Never",
+			ServiceAccountName: rt.serviceAccount,
+		},
+	}
+	if t.Platform != "" {
+		parts := strings.Split(t.Platform, "/")
+		if len(parts) < 2 || parts[0] == "" || parts[1] == "" {
+			return pod{}, errors.Errorf("invalid platform: %s", t.Platform)
+		}
+		p.Spec.NodeSelector = map[string]string{
+			"kubernetes.io/os":   parts[0],
+			"kubernetes.io/arch": parts[1],
+		}
+	}
+	if t.User != "" {
+		sc, err := securityContext(t.User)
+		if err != nil {
+			return pod{}, err
+		}
+		p.Spec.SecurityContext = sc
+	}
+	if t.ShmSize != "" {
+		size, err := units.RAMInBytes(t.ShmSize)
+		if err != nil {
+			return pod{}, errors.Wrapf(err, "invalid shm size: %s", t.ShmSize)
+		}
+		p.Spec.Volumes = append(p.Spec.Volumes, volume{
+			Name:     "shm",
+			EmptyDir: &emptyDirVolume{Medium: "Memory", SizeLimit: strconv.FormatInt(size, 10)},
+		})
+		c.VolumeMounts = append(c.VolumeMounts, volumeMount{Name: "shm", MountPath: "/dev/shm"})
+	}
+	// config map volumes are read-only, so the task's files
+	// are copied into a writable workdir by an init container
+	if len(t.Files) > 0 {
+		if t.Workdir == "" {
+			t.Workdir = defaultWorkdir
+			c.WorkingDir = t.Workdir
+		}
+		p.Spec.Volumes = append(p.Spec.Volumes,
+			volume{Name: "files", ConfigMap: &configMapVolume{Name: name, Items: fileItems(t.Files)}},
+			volume{Name: "workdir", EmptyDir: &emptyDirVolume{}})
+		c.VolumeMounts = append(c.VolumeMounts, volumeMount{Name: "workdir", MountPath: t.Workdir})
+		p.Spec.InitContainers = []container{{
+			Name:    "files",
+			Image:   t.Image,
+			Command: []string{"sh", "-c", "cp -RL /tork/files/. /tork/workdir/"},
+			VolumeMounts: []volumeMount{
+				{Name: "files", MountPath: "/tork/files", ReadOnly: true},
+				{Name: "workdir", MountPath: "/tork/workdir"},
+			},
+		}}
+	}
+	if t.Registry != nil {
+		p.Spec.ImagePullSecrets = []localObjectRef{{Name: name}}
+	}
+	p.Spec.Containers = []container{c}
+	return p, nil
+}
+
+// fileItems maps the files of a task onto config map keys,
+// which may not contain slashes, and back to their paths.
+func fileItems(files map[string]string) []keyToPath {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	items := make([]keyToPath, len(names))
+	for i, name := range names {
+		items[i] = keyToPath{Key: fmt.Sprintf("file-%d", i), Path: path.Clean(name)}
+	}
+	return items
+}
+
+// securityContext maps the task's uid[:gid] onto the
+// security context of its pod. Kubernetes doesn't resolve
+// user names, so they must be numeric.
+func securityContext(user string) (*podSecurityContext, error) {
+	uid, gid, hasGID := strings.Cut(user, ":")
+	u, err := strconv.ParseInt(uid, 10, 64)
+	if err != nil {
+		return nil, errors.Errorf("user must be a numeric uid[:gid] on kubernetes runtime: %s", user)
+	}
+	sc := &podSecurityContext{RunAsUser: &u}
+	if hasGID {
+		g, err := strconv.ParseInt(gid, 10, 64)
+		if err != nil {
+			return nil, errors.Errorf("user must be a numeric uid[:gid] on kubernetes runtime: %s", user)
+		}
+		sc.RunAsGroup = &g
+	}
+	return sc, nil
+}
+
+// registrySecret holds the task's registry
+// credentials in the docker config format.
+func registrySecret(name string, t *tork.Task) (secret, error) {
+	auth := base64.StdEncoding.EncodeToString([]byte(t.Registry.Username + ":" + t.Registry.Password))
+	cfg, err := json.Marshal(map[string]any{
+		"auths": map[string]any{
+			registryHost(t.Image): map[string]string{
+				"username": t.Registry.Username,
+				"password": t.Registry.Password,
+				"auth":     auth,
+			},
+		},
+	})
+	if err != nil {
+		return secret{}, err
+	}
+	return secret{
+		APIVersion: "v1",
+		Kind:       "Secret",
+		Metadata:   objectMeta{Name: name, Labels: map[string]string{labelTaskID: t.ID}},
+		Type:       "kubernetes.io/dockerconfigjson",
+		StringData: map[string]string{".dockerconfigjson": string(cfg)},
+	}, nil
+}
+
+// registryHost is the registry an image is pulled from.
+func registryHost(image string) string {
+	if i := strings.Index(image, "/"); i > 0 {
+		host := image[:i]
+		if strings.ContainsAny(host, ".:") || host == "localhost" {
+			return host
+		}
+	}
+	return "https://index.docker.io/v1/"
+}
+
+// podName derives a valid (DNS-1123) pod name from the task ID.
+func podName(taskID string) string {
+	return "tork-" + strings.ToLower(taskID)
+}
+
+func (rt *KubernetesRuntime) path(resource, name string) string {
+	p := fmt.Sprintf("/api/v1/namespaces/%s/%s", url.PathEscape(rt.namespace), resource)
+	if name != "" {
+		p = p + "/" + url.PathEscape(name)
+	}
+	return p
+}
+
+func (rt *KubernetesRuntime) create(ctx context.Context, resource string, obj any) error {
+	return rt.client.do(ctx, http.MethodPost, rt.path(resource, ""), obj, nil)
+}
+
+func (rt *KubernetesRuntime) remove(ctx context.Context, resource, name string) {
+	if err := rt.client.do(ctx, http.MethodDelete, rt.path(resource, name), nil, nil); err != nil && !isNotFound(err) {
+		log.Error().Err(err).Msgf("error removing %s %s", resource, name)
+	}
+}
+
+// shipLogs follows the logs of the task's container
+// until it terminates.
+func (rt *KubernetesRuntime) shipLogs(ctx context.Context, name string, logger io.Writer) error {
+	rc, err := rt.client.stream(ctx, http.MethodGet,
+		rt.path("pods", name)+"/log?follow=true&container="+taskContainer, nil)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	_, err = io.Copy(logger, rc)
+	return err
+}
+
+func (rt *KubernetesRuntime) tailLogs(ctx context.Context, name string) (string, error) {
+	rc, err := rt.client.stream(ctx, http.MethodGet,
+		rt.path("pods", name)+"/log?tailLines=10&container="+taskContainer, nil)
+	if err != nil {
+		return "", err
+	}
+	defer rc.Close()
+	b, err := io.ReadAll(rc)
+	return string(b), err
+}
+
+func (rt *KubernetesRuntime) Stop(ctx context.Context, t *tork.Task) error {
+	err := rt.client.do(ctx, http.MethodDelete, rt.path("pods", podName(t.ID)), nil, nil)
+	if err != nil && !isNotFound(err) {
+		return errors.Wrapf(err, "error stopping pod for task: %s", t.ID)
+	}
+	return nil
+}
+
+func (rt *KubernetesRuntime) HealthCheck(ctx context.Context) error {
+	if err := rt.client.do(ctx, http.MethodGet, "/version", nil, nil); err != nil {
+		return errors.Wrapf(err, "error reaching the kubernetes API server")
+	}
+	return nil
+}
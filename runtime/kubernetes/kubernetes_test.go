@@ -0,0 +1,299 @@
+package kubernetes
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/runabol/tork"
+	"github.com/runabol/tork/internal/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeAPI is an API server which runs the
+// pods it's given to completion at once.
+type fakeAPI struct {
+	mu         sync.Mutex
+	pods       map[string]pod
+	configMaps map[string]configMap
+	secrets    map[string]secret
+	deleted    []string
+	// the state the task container of a pod terminates
+	// in, or is stuck waiting in, and its logs
+	terminated *containerStateTerminated
+	waiting    *containerStateWaiting
+	logs       string
+}
+
+func newFakeAPI() *fakeAPI {
+	return &fakeAPI{
+		pods:       make(map[string]pod),
+		configMaps: make(map[string]configMap),
+		secrets:    make(map[string]secret),
+		terminated: &containerStateTerminated{},
+	}
+}
+
+func (f *fakeAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if r.Header.Get("Authorization") != "Bearer secret" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if r.URL.Path == "/version" {
+		_, _ = w.Write([]byte(`{"major":"1","minor":"29"}`))
+		return
+	}
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/v1/namespaces/tork/"), "/")
+	switch {
+	case r.Method == http.MethodPost && parts[0] == "pods":
+		p := pod{}
+		_ = json.NewDecoder(r.Body).Decode(&p)
+		f.pods[p.Metadata.Name] = p
+	case r.Method == http.MethodPost && parts[0] == "configmaps":
+		cm := configMap{}
+		_ = json.NewDecoder(r.Body).Decode(&cm)
+		f.configMaps[cm.Metadata.Name] = cm
+	case r.Method == http.MethodPost && parts[0] == "secrets":
+		s := secret{}
+		_ = json.NewDecoder(r.Body).Decode(&s)
+		f.secrets[s.Metadata.Name] = s
+	case r.Method == http.MethodDelete:
+		f.deleted = append(f.deleted, parts[0]+"/"+parts[1])
+		if _, ok := f.pods[parts[1]]; !ok && parts[0] == "pods" {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"message":"not found"}`))
+		}
+		delete(f.pods, parts[1])
+	case r.Method == http.MethodGet && len(parts) == 3 && parts[2] == "log":
+		_, _ = w.Write([]byte(f.logs))
+	case r.Method == http.MethodGet && len(parts) == 2:
+		p, ok := f.pods[parts[1]]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if f.waiting != nil {
+			p.Status.Phase = "Pending"
+			p.Status.ContainerStatuses = []containerStatus{{Name: taskContainer, State: containerState{Waiting: f.waiting}}}
+		} else {
+			p.Status.Phase = "Succeeded"
+			if f.terminated.ExitCode != 0 {
+				p.Status.Phase = "Failed"
+			}
+			p.Status.ContainerStatuses = []containerStatus{{Name: taskContainer, State: containerState{Terminated: f.terminated}}}
+		}
+		_ = json.NewEncoder(w).Encode(p)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func newTestRuntime(t *testing.T, f *fakeAPI, opts ...Option) *KubernetesRuntime {
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	rt, err := NewKubernetesRuntime(append([]Option{
+		WithHost(srv.URL),
+		WithToken("secret"),
+		WithNamespace("tork"),
+	}, opts...)...)
+	assert.NoError(t, err)
+	return rt
+}
+
+func TestKubernetesRuntimeNoHost(t *testing.T) {
+	t.Setenv("KUBERNETES_SERVICE_HOST", "")
+	_, err := NewKubernetesRuntime()
+	assert.Error(t, err)
+}
+
+func TestKubernetesRuntimeRunResult(t *testing.T) {
+	f := newFakeAPI()
+	f.terminated = &containerStateTerminated{Message: "hello world"}
+	rt := newTestRuntime(t, f)
+
+	tk := &tork.Task{
+		ID:    uuid.NewUUID(),
+		Image: "alpine:3.18.3",
+		Run:   "echo -n hello world > $TORK_OUTPUT",
+		Env:   map[string]string{"NAME": "world"},
+		Limits: &tork.TaskLimits{
+			CPUs:   "0.5",
+			Memory: "10m",
+		},
+		Platform: "linux/arm64",
+	}
+	assert.NoError(t, rt.Run(context.Background(), tk))
+	assert.Equal(t, "hello world", tk.Result)
+	assert.Equal(t, 0, tk.ExitCode)
+
+	// the pod is removed once the task completed
+	assert.Empty(t, f.pods)
+	assert.Equal(t, []string{"pods/" + podName(tk.ID)}, f.deleted)
+}
+
+func TestKubernetesRuntimePodSpec(t *testing.T) {
+	rt := newTestRuntime(t, newFakeAPI(), WithServiceAccount("tork-tasks"))
+	tk := &tork.Task{
+		ID:    uuid.NewUUID(),
+		Image: "alpine:3.18.3",
+		Run:   "cat hello.txt",
+		Env:   map[string]string{"B": "2", "A": "1"},
+		Files: map[string]string{"hello.txt": "hello"},
+		Limits: &tork.TaskLimits{
+			CPUs:   "0.5",
+			Memory: "10m",
+		},
+		User:     "1000:1000",
+		Platform: "linux/arm64",
+	}
+	p, err := rt.podSpec(podName(tk.ID), tk)
+	assert.NoError(t, err)
+	assert.Equal(t, "Never", p.Spec.RestartPolicy)
+	assert.Equal(t, "tork-tasks", p.Spec.ServiceAccountName)
+	assert.Equal(t, tk.ID, p.Metadata.Labels[labelTaskID])
+	assert.Equal(t, map[string]string{"kubernetes.io/os": "linux", "kubernetes.io/arch": "arm64"}, p.Spec.NodeSelector)
+	assert.Equal(t, int64(1000), *p.Spec.SecurityContext.RunAsUser)
+	assert.Equal(t, int64(1000), *p.Spec.SecurityContext.RunAsGroup)
+
+	c := p.Spec.Containers[0]
+	assert.Equal(t, []string{"sh", "-c"}, c.Command)
+	assert.Equal(t, []string{"cat hello.txt"}, c.Args)
+	assert.Equal(t, defaultWorkdir, c.WorkingDir)
+	assert.Equal(t, []envVar{
+		{Name: "A", Value: "1"},
+		{Name: "B", Value: "2"},
+		{Name: "TORK_OUTPUT", Value: terminationLog},
+		{Name: "TORK_PROGRESS", Value: "/dev/null"},
+	}, c.Env)
+	assert.Equal(t, map[string]string{"cpu": "0.5", "memory": "10485760"}, c.Resources.Limits)
+
+	// the files are copied into the workdir
+	assert.Len(t, p.Spec.InitContainers, 1)
+	assert.Equal(t, []keyToPath{{Key: "file-0", Path: "hello.txt"}}, p.Spec.Volumes[0].ConfigMap.Items)
+}
+
+func TestKubernetesRuntimeRunFiles(t *testing.T) {
+	f := newFakeAPI()
+	rt := newTestRuntime(t, f)
+	tk := &tork.Task{
+		ID:    uuid.NewUUID(),
+		Image: "alpine:3.18.3",
+		Run:   "cat hello.txt > $TORK_OUTPUT",
+		Files: map[string]string{"hello.txt": "hello"},
+		Registry: &tork.Registry{
+			Username: "user",
+			Password: "pass",
+		},
+	}
+	assert.NoError(t, rt.Run(context.Background(), tk))
+	name := podName(tk.ID)
+	assert.Equal(t, map[string]string{"file-0": "hello"}, f.configMaps[name].Data)
+	assert.Equal(t, "kubernetes.io/dockerconfigjson", f.secrets[name].Type)
+	assert.ElementsMatch(t, []string{"pods/" + name, "configmaps/" + name, "secrets/" + name}, f.deleted)
+}
+
+func TestKubernetesRuntimeRunExitCode(t *testing.T) {
+	f := newFakeAPI()
+	f.terminated = &containerStateTerminated{ExitCode: 2}
+	f.logs = "bad things happened"
+	rt := newTestRuntime(t, f)
+	tk := &tork.Task{
+		ID:    uuid.NewUUID(),
+		Image: "alpine:3.18.3",
+		Run:   "exit 2",
+	}
+	err := rt.Run(context.Background(), tk)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "exit code 2: bad things happened")
+	assert.Equal(t, 2, tk.ExitCode)
+}
+
+func TestKubernetesRuntimeRunIgnoreExitCode(t *testing.T) {
+	f := newFakeAPI()
+	f.terminated = &containerStateTerminated{ExitCode: 2, Message: "partial"}
+	rt := newTestRuntime(t, f, WithIgnoreExitCode(true))
+	tk := &tork.Task{
+		ID:    uuid.NewUUID(),
+		Image: "alpine:3.18.3",
+		Run:   "exit 2",
+	}
+	assert.NoError(t, rt.Run(context.Background(), tk))
+	assert.Equal(t, 2, tk.ExitCode)
+	assert.Equal(t, "partial", tk.Result)
+}
+
+func TestKubernetesRuntimeRunBadImage(t *testing.T) {
+	f := newFakeAPI()
+	f.waiting = &containerStateWaiting{Reason: "ImagePullBackOff", Message: "no such image"}
+	rt := newTestRuntime(t, f)
+	tk := &tork.Task{
+		ID:    uuid.NewUUID(),
+		Image: "no-such-image",
+		Run:   "true",
+	}
+	err := rt.Run(context.Background(), tk)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "ImagePullBackOff")
+	assert.Empty(t, f.pods)
+}
+
+func TestKubernetesRuntimeRunCancelled(t *testing.T) {
+	f := newFakeAPI()
+	f.waiting = &containerStateWaiting{Reason: "ContainerCreating"}
+	rt := newTestRuntime(t, f)
+	tk := &tork.Task{
+		ID:    uuid.NewUUID(),
+		Image: "alpine:3.18.3",
+		Run:   "sleep 10",
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer cancel()
+	err := rt.Run(ctx, tk)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	assert.Empty(t, f.pods)
+}
+
+func TestKubernetesRuntimeRunNotSupported(t *testing.T) {
+	rt := newTestRuntime(t, newFakeAPI())
+	tasks := []*tork.Task{
+		{ID: uuid.NewUUID(), Run: "true"},
+		{ID: uuid.NewUUID(), Image: "alpine", Mounts: []tork.Mount{{Type: "bind"}}},
+		{ID: uuid.NewUUID(), Image: "alpine", Networks: []string{"default"}},
+		{ID: uuid.NewUUID(), Image: "alpine", Artifacts: []*tork.Artifact{{Path: "out"}}},
+		{ID: uuid.NewUUID(), Image: "alpine", User: "nobody"},
+	}
+	for _, tk := range tasks {
+		assert.Error(t, rt.Run(context.Background(), tk))
+	}
+}
+
+func TestKubernetesRuntimeStop(t *testing.T) {
+	f := newFakeAPI()
+	rt := newTestRuntime(t, f)
+	// stopping a task whose pod is gone is a no-op
+	assert.NoError(t, rt.Stop(context.Background(), &tork.Task{ID: uuid.NewUUID()}))
+}
+
+func TestKubernetesRuntimeHealthCheck(t *testing.T) {
+	rt := newTestRuntime(t, newFakeAPI())
+	assert.NoError(t, rt.HealthCheck(context.Background()))
+
+	rt = newTestRuntime(t, newFakeAPI(), WithToken("wrong"))
+	assert.Error(t, rt.HealthCheck(context.Background()))
+}
+
+func TestRegistryHost(t *testing.T) {
+	assert.Equal(t, "https://index.docker.io/v1/", registryHost("alpine:3.18"))
+	assert.Equal(t, "https://index.docker.io/v1/", registryHost("library/alpine"))
+	assert.Equal(t, "ghcr.io", registryHost("ghcr.io/runabol/tork"))
+	assert.Equal(t, "localhost:5000", registryHost("localhost:5000/tork"))
+}
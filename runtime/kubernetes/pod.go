@@ -0,0 +1,144 @@
+package kubernetes
+
+// The subset of the Kubernetes API objects
+// the runtime creates and inspects.
+
+type objectMeta struct {
+	Name      string            `json:"name"`
+	Namespace string            `json:"namespace,omitempty"`
+	Labels    map[string]string `json:"labels,omitempty"`
+}
+
+type pod struct {
+	APIVersion string     `json:"apiVersion"`
+	Kind       string     `json:"kind"`
+	Metadata   objectMeta `json:"metadata"`
+	Spec       podSpec    `json:"spec"`
+	Status     podStatus  `json:"status,omitempty"`
+}
+
+type podSpec struct {
+	RestartPolicy      string              `json:"restartPolicy"`
+	ServiceAccountName string              `json:"serviceAccountName,omitempty"`
+	NodeSelector       map[string]string   `json:"nodeSelector,omitempty"`
+	InitContainers     []container         `json:"initContainers,omitempty"`
+	Containers         []container         `json:"containers"`
+	Volumes            []volume            `json:"volumes,omitempty"`
+	ImagePullSecrets   []localObjectRef    `json:"imagePullSecrets,omitempty"`
+	SecurityContext    *podSecurityContext `json:"securityContext,omitempty"`
+}
+
+type podSecurityContext struct {
+	RunAsUser  *int64 `json:"runAsUser,omitempty"`
+	RunAsGroup *int64 `json:"runAsGroup,omitempty"`
+}
+
+type localObjectRef struct {
+	Name string `json:"name"`
+}
+
+type container struct {
+	Name         string               `json:"name"`
+	Image        string               `json:"image"`
+	Command      []string             `json:"command,omitempty"`
+	Args         []string             `json:"args,omitempty"`
+	WorkingDir   string               `json:"workingDir,omitempty"`
+	Env          []envVar             `json:"env,omitempty"`
+	Resources    resourceRequirements `json:"resources,omitempty"`
+	VolumeMounts []volumeMount        `json:"volumeMounts,omitempty"`
+}
+
+type envVar struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type resourceRequirements struct {
+	Limits map[string]string `json:"limits,omitempty"`
+}
+
+type volumeMount struct {
+	Name      string `json:"name"`
+	MountPath string `json:"mountPath"`
+	ReadOnly  bool   `json:"readOnly,omitempty"`
+}
+
+type volume struct {
+	Name      string           `json:"name"`
+	EmptyDir  *emptyDirVolume  `json:"emptyDir,omitempty"`
+	ConfigMap *configMapVolume `json:"configMap,omitempty"`
+}
+
+type emptyDirVolume struct {
+	Medium    string `json:"medium,omitempty"`
+	SizeLimit string `json:"sizeLimit,omitempty"`
+}
+
+type configMapVolume struct {
+	Name  string      `json:"name"`
+	Items []keyToPath `json:"items,omitempty"`
+}
+
+type keyToPath struct {
+	Key  string `json:"key"`
+	Path string `json:"path"`
+}
+
+type podStatus struct {
+	Phase                 string            `json:"phase,omitempty"`
+	Reason                string            `json:"reason,omitempty"`
+	Message               string            `json:"message,omitempty"`
+	InitContainerStatuses []containerStatus `json:"initContainerStatuses,omitempty"`
+	ContainerStatuses     []containerStatus `json:"containerStatuses,omitempty"`
+}
+
+type containerStatus struct {
+	Name  string         `json:"name"`
+	State containerState `json:"state"`
+}
+
+type containerState struct {
+	Waiting    *containerStateWaiting    `json:"waiting,omitempty"`
+	Terminated *containerStateTerminated `json:"terminated,omitempty"`
+}
+
+type containerStateWaiting struct {
+	Reason  string `json:"reason,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+type containerStateTerminated struct {
+	ExitCode int    `json:"exitCode"`
+	Reason   string `json:"reason,omitempty"`
+	Message  string `json:"message,omitempty"`
+}
+
+type configMap struct {
+	APIVersion string            `json:"apiVersion"`
+	Kind       string            `json:"kind"`
+	Metadata   objectMeta        `json:"metadata"`
+	Data       map[string]string `json:"data"`
+}
+
+type secret struct {
+	APIVersion string            `json:"apiVersion"`
+	Kind       string            `json:"kind"`
+	Metadata   objectMeta        `json:"metadata"`
+	Type       string            `json:"type"`
+	StringData map[string]string `json:"stringData"`
+}
+
+// status returns the status of the named container.
+func (p *pod) status(name string) *containerStatus {
+	for i := range p.Status.InitContainerStatuses {
+		if p.Status.InitContainerStatuses[i].Name == name {
+			return &p.Status.InitContainerStatuses[i]
+		}
+	}
+	for i := range p.Status.ContainerStatuses {
+		if p.Status.ContainerStatuses[i].Name == name {
+			return &p.Status.ContainerStatuses[i]
+		}
+	}
+	return nil
+}
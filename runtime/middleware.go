@@ -0,0 +1,50 @@
+package runtime
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	"github.com/runabol/tork"
+)
+
+// Middleware wraps a Runtime to add cross-cutting behavior
+// (e.g. timing, artifact capture or image allow-listing)
+// regardless of the underlying Runtime implementation.
+type Middleware func(next Runtime) Runtime
+
+// RunFunc is the signature of Runtime.Run.
+type RunFunc func(ctx context.Context, t *tork.Task) error
+
+// Wrap applies the middleware to the given Runtime.
+// The first middleware is the outermost one.
+func Wrap(rt Runtime, mws ...Middleware) Runtime {
+	for i := len(mws) - 1; i >= 0; i-- {
+		rt = mws[i](rt)
+	}
+	return rt
+}
+
+// RunMiddleware returns a Middleware that only intercepts
+// calls to Run. Stop and HealthCheck are passed through.
+func RunMiddleware(fn func(next RunFunc) RunFunc) Middleware {
+	return func(next Runtime) Runtime {
+		return &runWrapper{Runtime: next, run: fn(next.Run)}
+	}
+}
+
+type runWrapper struct {
+	Runtime
+	run RunFunc
+}
+
+func (w *runWrapper) Run(ctx context.Context, t *tork.Task) error {
+	return w.run(ctx, t)
+}
+
+func (w *runWrapper) PullImage(ctx context.Context, image string) error {
+	p, ok := w.Runtime.(ImagePuller)
+	if !ok {
+		return errors.Errorf("%T can't pull images", w.Runtime)
+	}
+	return p.PullImage(ctx, image)
+}
@@ -0,0 +1,87 @@
+package runtime
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/runabol/tork"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeRuntime struct {
+	calls *[]string
+}
+
+func (r *fakeRuntime) Run(ctx context.Context, t *tork.Task) error {
+	*r.calls = append(*r.calls, "run")
+	return nil
+}
+
+func (r *fakeRuntime) Stop(ctx context.Context, t *tork.Task) error {
+	*r.calls = append(*r.calls, "stop")
+	return nil
+}
+
+func (r *fakeRuntime) HealthCheck(ctx context.Context) error {
+	return nil
+}
+
+func TestWrap(t *testing.T) {
+	calls := make([]string, 0)
+	mw := func(name string) Middleware {
+		return RunMiddleware(func(next RunFunc) RunFunc {
+			return func(ctx context.Context, t *tork.Task) error {
+				calls = append(calls, name)
+				return next(ctx, t)
+			}
+		})
+	}
+	rt := Wrap(&fakeRuntime{calls: &calls}, mw("mw1"), mw("mw2"))
+	assert.NoError(t, rt.Run(context.Background(), &tork.Task{}))
+	assert.NoError(t, rt.Stop(context.Background(), &tork.Task{}))
+	assert.NoError(t, rt.HealthCheck(context.Background()))
+	assert.Equal(t, []string{"mw1", "mw2", "run", "stop"}, calls)
+}
+
+func TestRunMiddlewareError(t *testing.T) {
+	calls := make([]string, 0)
+	deny := RunMiddleware(func(next RunFunc) RunFunc {
+		return func(ctx context.Context, t *tork.Task) error {
+			if t.Image != "ubuntu:mantic" {
+				return errors.New("image not allowed")
+			}
+			return next(ctx, t)
+		}
+	})
+	rt := Wrap(&fakeRuntime{calls: &calls}, deny)
+	assert.EqualError(t, rt.Run(context.Background(), &tork.Task{Image: "evil:latest"}), "image not allowed")
+	assert.NoError(t, rt.Run(context.Background(), &tork.Task{Image: "ubuntu:mantic"}))
+	assert.Equal(t, []string{"run"}, calls)
+}
+
+type fakePuller struct {
+	fakeRuntime
+}
+
+func (r *fakePuller) PullImage(ctx context.Context, image string) error {
+	*r.calls = append(*r.calls, "pull "+image)
+	return nil
+}
+
+func TestRunMiddlewarePullImage(t *testing.T) {
+	calls := make([]string, 0)
+	noop := RunMiddleware(func(next RunFunc) RunFunc {
+		return next
+	})
+	rt := Wrap(&fakePuller{fakeRuntime{calls: &calls}}, noop)
+	p, ok := rt.(ImagePuller)
+	assert.True(t, ok)
+	assert.NoError(t, p.PullImage(context.Background(), "ubuntu:mantic"))
+	assert.Equal(t, []string{"pull ubuntu:mantic"}, calls)
+
+	rt = Wrap(&fakeRuntime{calls: &calls}, noop)
+	p, ok = rt.(ImagePuller)
+	assert.True(t, ok)
+	assert.Error(t, p.PullImage(context.Background(), "ubuntu:mantic"))
+}
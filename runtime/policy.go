@@ -0,0 +1,45 @@
+package runtime
+
+import (
+	"github.com/distribution/reference"
+	"github.com/pkg/errors"
+	"github.com/runabol/tork/internal/wildcard"
+)
+
+// ImagePolicy restricts the images that tasks may use.
+// Patterns may contain * wildcards and are matched against
+// the image as written (e.g. ubuntu:*) and against its fully
+// qualified name (e.g. docker.io/library/*).
+type ImagePolicy struct {
+	// Allow, when not empty, lists the only images tasks may use.
+	Allow []string
+	// Deny lists the images tasks may not use.
+	// It takes precedence over Allow.
+	Deny []string
+}
+
+// Check returns an error if the policy doesn't allow the image.
+func (p ImagePolicy) Check(image string) error {
+	names := []string{image}
+	if named, err := reference.ParseNormalizedNamed(image); err == nil {
+		names = append(names, reference.TagNameOnly(named).String())
+	}
+	if matchAny(p.Deny, names) {
+		return errors.Errorf("image %s is not allowed", image)
+	}
+	if len(p.Allow) > 0 && !matchAny(p.Allow, names) {
+		return errors.Errorf("image %s is not allowed", image)
+	}
+	return nil
+}
+
+func matchAny(patterns []string, names []string) bool {
+	for _, pattern := range patterns {
+		for _, name := range names {
+			if wildcard.Match(pattern, name) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,30 @@
+package runtime
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestImagePolicyCheck(t *testing.T) {
+	p := ImagePolicy{}
+	assert.NoError(t, p.Check("ubuntu:mantic"))
+
+	p = ImagePolicy{Allow: []string{"docker.io/library/*", "registry.example.com/team/*"}}
+	assert.NoError(t, p.Check("ubuntu:mantic"))
+	assert.NoError(t, p.Check("ubuntu"))
+	assert.NoError(t, p.Check("registry.example.com/team/app:1.0"))
+	assert.Error(t, p.Check("evil/miner:latest"))
+	assert.Error(t, p.Check("registry.example.com/other/app:1.0"))
+
+	p = ImagePolicy{
+		Allow: []string{"docker.io/library/*"},
+		Deny:  []string{"docker.io/library/ubuntu:*"},
+	}
+	assert.Error(t, p.Check("ubuntu:mantic"))
+	assert.NoError(t, p.Check("alpine:3.18"))
+
+	p = ImagePolicy{Deny: []string{"*:latest"}}
+	assert.Error(t, p.Check("alpine"))
+	assert.NoError(t, p.Check("alpine:3.18"))
+}
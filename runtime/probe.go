@@ -0,0 +1,93 @@
+package runtime
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/runabol/tork"
+)
+
+// DefaultProbeTimeout is how long a service task is given
+// to pass its probe when the probe doesn't specify a timeout.
+const DefaultProbeTimeout = time.Minute
+
+// how often a service is probed until it's ready
+var probeInterval = time.Second
+
+type readyKey struct{}
+
+// WithReadyFunc returns a copy of ctx carrying fn, which is
+// called when a runtime signals that the service task it runs
+// with ctx is ready.
+func WithReadyFunc(ctx context.Context, fn func()) context.Context {
+	return context.WithValue(ctx, readyKey{}, fn)
+}
+
+// Ready signals that the service task run with ctx
+// passed its probe.
+func Ready(ctx context.Context) {
+	if fn, ok := ctx.Value(readyKey{}).(func()); ok {
+		fn()
+	}
+}
+
+// WaitReady calls check until it succeeds or
+// the probe times out.
+func WaitReady(ctx context.Context, p *tork.Probe, check func(ctx context.Context) error) error {
+	timeout := DefaultProbeTimeout
+	if p.Timeout != "" {
+		d, err := time.ParseDuration(p.Timeout)
+		if err != nil {
+			return errors.Wrapf(err, "invalid probe timeout: %s", p.Timeout)
+		}
+		timeout = d
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	for {
+		err := check(ctx)
+		if err == nil {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return errors.Wrapf(err, "service not ready after %s", timeout)
+		case <-time.After(probeInterval):
+		}
+	}
+}
+
+// CheckAddr probes the service listening on addr with an HTTP GET
+// to the probe's path or, if it has none, by connecting to it.
+func CheckAddr(ctx context.Context, p *tork.Probe, addr string) error {
+	if p.Path != "" {
+		path := p.Path
+		if !strings.HasPrefix(path, "/") {
+			path = "/" + path
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("http://%s%s", addr, path), nil)
+		if err != nil {
+			return err
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 400 {
+			return errors.Errorf("probe returned %d", resp.StatusCode)
+		}
+		return nil
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
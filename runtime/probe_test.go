@@ -0,0 +1,85 @@
+package runtime
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/runabol/tork"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReady(t *testing.T) {
+	// no-op without a ready func
+	Ready(context.Background())
+
+	called := false
+	ctx := WithReadyFunc(context.Background(), func() {
+		called = true
+	})
+	Ready(ctx)
+	assert.True(t, called)
+}
+
+func TestWaitReady(t *testing.T) {
+	probeInterval = time.Millisecond * 10
+	attempts := 0
+	err := WaitReady(context.Background(), &tork.Probe{}, func(ctx context.Context) error {
+		attempts++
+		if attempts < 3 {
+			return errors.New("not yet")
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, attempts)
+}
+
+func TestWaitReadyTimeout(t *testing.T) {
+	probeInterval = time.Millisecond * 10
+	err := WaitReady(context.Background(), &tork.Probe{Timeout: "50ms"}, func(ctx context.Context) error {
+		return errors.New("connection refused")
+	})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "service not ready after 50ms")
+	assert.Contains(t, err.Error(), "connection refused")
+}
+
+func TestWaitReadyBadTimeout(t *testing.T) {
+	err := WaitReady(context.Background(), &tork.Probe{Timeout: "xyz"}, func(ctx context.Context) error {
+		return nil
+	})
+	assert.Error(t, err)
+}
+
+func TestCheckAddrTCP(t *testing.T) {
+	ln, err := net.Listen("tcp", "localhost:0")
+	assert.NoError(t, err)
+	addr := ln.Addr().String()
+	err = CheckAddr(context.Background(), &tork.Probe{}, addr)
+	assert.NoError(t, err)
+	assert.NoError(t, ln.Close())
+	err = CheckAddr(context.Background(), &tork.Probe{}, addr)
+	assert.Error(t, err)
+}
+
+func TestCheckAddrHTTP(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/health" {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+	addr := strings.TrimPrefix(srv.URL, "http://")
+	err := CheckAddr(context.Background(), &tork.Probe{Path: "health"}, addr)
+	assert.NoError(t, err)
+	err = CheckAddr(context.Background(), &tork.Probe{Path: "/other"}, addr)
+	assert.Error(t, err)
+}
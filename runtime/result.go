@@ -0,0 +1,70 @@
+package runtime
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"unicode/utf8"
+
+	"github.com/docker/go-units"
+	"github.com/pkg/errors"
+	"github.com/runabol/tork"
+	"github.com/runabol/tork/artifact"
+)
+
+// ReadResult reads the output of the task from r into
+// t.Result. When the task has an output limit, the result
+// is truncated to at most that size, on a rune boundary,
+// and t.ResultTruncated is set.
+func ReadResult(t *tork.Task, r io.Reader) error {
+	return SpillResult(context.Background(), nil, t, r)
+}
+
+// SpillResult is like ReadResult, but when the output exceeds
+// the task's output limit and s is not nil, the full output is
+// uploaded to s and its URL is stored in t.ResultURL. t.Result
+// still holds the truncated output.
+func SpillResult(ctx context.Context, s artifact.Store, t *tork.Task, r io.Reader) error {
+	var limit int64
+	if t.Limits != nil && t.Limits.Output != "" {
+		l, err := units.RAMInBytes(t.Limits.Output)
+		if err != nil {
+			return errors.Wrapf(err, "invalid output limit: %s", t.Limits.Output)
+		}
+		limit = l
+	}
+	if limit <= 0 {
+		b, err := io.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		t.Result = string(b)
+		return nil
+	}
+	// read one extra byte to detect truncation
+	b, err := io.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return err
+	}
+	if int64(len(b)) <= limit {
+		t.Result = string(b)
+		return nil
+	}
+	// cut at the start of the rune that crosses the
+	// limit so the result stays valid UTF-8
+	cut := limit
+	for cut > 0 && !utf8.RuneStart(b[cut]) {
+		cut--
+	}
+	t.Result = string(b[:cut])
+	t.ResultTruncated = true
+	if s == nil {
+		return nil
+	}
+	url, err := s.Put(ctx, artifact.ResultKey(t), io.MultiReader(bytes.NewReader(b), r))
+	if err != nil {
+		return errors.Wrapf(err, "error uploading the task result")
+	}
+	t.ResultURL = url
+	return nil
+}
@@ -0,0 +1,90 @@
+package runtime
+
+import (
+	"context"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/runabol/tork"
+	"github.com/runabol/tork/artifact"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadResultNoLimit(t *testing.T) {
+	tk := &tork.Task{}
+	err := ReadResult(tk, strings.NewReader("hello world"))
+	assert.NoError(t, err)
+	assert.Equal(t, "hello world", tk.Result)
+	assert.False(t, tk.ResultTruncated)
+}
+
+func TestReadResultTruncated(t *testing.T) {
+	tk := &tork.Task{Limits: &tork.TaskLimits{Output: "5b"}}
+	err := ReadResult(tk, strings.NewReader("hello world"))
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", tk.Result)
+	assert.True(t, tk.ResultTruncated)
+}
+
+func TestReadResultTruncatedRuneBoundary(t *testing.T) {
+	// "€" takes up bytes 2-4 of "h€llo"
+	tk := &tork.Task{Limits: &tork.TaskLimits{Output: "4b"}}
+	err := ReadResult(tk, strings.NewReader("h€llo"))
+	assert.NoError(t, err)
+	assert.Equal(t, "h€", tk.Result)
+	assert.True(t, tk.ResultTruncated)
+
+	tk = &tork.Task{Limits: &tork.TaskLimits{Output: "3b"}}
+	err = ReadResult(tk, strings.NewReader("h€llo"))
+	assert.NoError(t, err)
+	assert.Equal(t, "h", tk.Result)
+	assert.True(t, tk.ResultTruncated)
+}
+
+func TestReadResultWithinLimit(t *testing.T) {
+	tk := &tork.Task{Limits: &tork.TaskLimits{Output: "11b"}}
+	err := ReadResult(tk, strings.NewReader("hello world"))
+	assert.NoError(t, err)
+	assert.Equal(t, "hello world", tk.Result)
+	assert.False(t, tk.ResultTruncated)
+}
+
+func TestReadResultBadLimit(t *testing.T) {
+	tk := &tork.Task{Limits: &tork.TaskLimits{Output: "xyz"}}
+	err := ReadResult(tk, strings.NewReader("hello world"))
+	assert.Error(t, err)
+}
+
+func TestSpillResult(t *testing.T) {
+	store, err := artifact.NewFileStore(t.TempDir())
+	assert.NoError(t, err)
+	tk := &tork.Task{ID: "t1", JobID: "j1", Limits: &tork.TaskLimits{Output: "5b"}}
+	err = SpillResult(context.Background(), store, tk, strings.NewReader("hello world"))
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", tk.Result)
+	assert.True(t, tk.ResultTruncated)
+	assert.True(t, strings.HasSuffix(tk.ResultURL, "/results/j1/t1"))
+	r, err := artifact.Fetch(context.Background(), store, tk.ResultURL)
+	assert.NoError(t, err)
+	defer r.Close()
+	b, err := io.ReadAll(r)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello world", string(b))
+}
+
+func TestSpillResultWithinLimit(t *testing.T) {
+	dir := t.TempDir()
+	store, err := artifact.NewFileStore(dir)
+	assert.NoError(t, err)
+	tk := &tork.Task{ID: "t1", JobID: "j1", Limits: &tork.TaskLimits{Output: "11b"}}
+	err = SpillResult(context.Background(), store, tk, strings.NewReader("hello world"))
+	assert.NoError(t, err)
+	assert.Equal(t, "hello world", tk.Result)
+	assert.False(t, tk.ResultTruncated)
+	assert.Empty(t, tk.ResultURL)
+	entries, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+}
@@ -7,8 +7,11 @@ import (
 )
 
 const (
-	Docker = "docker"
-	Shell  = "shell"
+	Docker     = "docker"
+	Podman     = "podman"
+	Shell      = "shell"
+	Kubernetes = "kubernetes"
+	Containerd = "containerd"
 )
 
 // Runtime is the actual runtime environment that executes a task.
@@ -17,3 +20,9 @@ type Runtime interface {
 	Stop(ctx context.Context, t *tork.Task) error
 	HealthCheck(ctx context.Context) error
 }
+
+// ImagePuller is implemented by runtimes that can pull
+// images ahead of the tasks that use them.
+type ImagePuller interface {
+	PullImage(ctx context.Context, image string) error
+}
@@ -0,0 +1,32 @@
+//go:build freebsd || darwin || linux
+
+package shell
+
+import (
+	"github.com/rs/zerolog/log"
+	"golang.org/x/sys/unix"
+)
+
+var rlimitResources = map[string]int{
+	"as":     unix.RLIMIT_AS,
+	"core":   unix.RLIMIT_CORE,
+	"cpu":    unix.RLIMIT_CPU,
+	"data":   unix.RLIMIT_DATA,
+	"fsize":  unix.RLIMIT_FSIZE,
+	"nofile": unix.RLIMIT_NOFILE,
+	"nproc":  unix.RLIMIT_NPROC,
+	"stack":  unix.RLIMIT_STACK,
+}
+
+func SetRlimits(rlimits string) {
+	limits, err := parseRlimits(rlimits)
+	if err != nil {
+		log.Fatal().Err(err).Msgf("invalid rlimits: %s", rlimits)
+	}
+	for name, value := range limits {
+		lim := &unix.Rlimit{Cur: value, Max: value}
+		if err := unix.Setrlimit(rlimitResources[name], lim); err != nil {
+			log.Fatal().Err(err).Msgf("error setting rlimit: %s=%d", name, value)
+		}
+	}
+}
@@ -0,0 +1,15 @@
+//go:build !freebsd && !darwin && !linux
+
+package shell
+
+import (
+	"github.com/rs/zerolog/log"
+)
+
+var rlimitResources = map[string]int{}
+
+func SetRlimits(rlimits string) {
+	if rlimits != "" {
+		log.Fatal().Msgf("setting rlimits is only supported on unix/linux systems")
+	}
+}
@@ -1,24 +1,32 @@
 package shell
 
 import (
+	"archive/tar"
 	"context"
 	"flag"
 	"io"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"fmt"
 	"os"
 	"os/exec"
+	"path/filepath"
+	goruntime "runtime"
 
 	"github.com/pkg/errors"
 	"github.com/rs/zerolog/log"
 	"github.com/runabol/tork"
+	"github.com/runabol/tork/artifact"
 	"github.com/runabol/tork/internal/reexec"
 	"github.com/runabol/tork/internal/syncx"
 	"github.com/runabol/tork/internal/uuid"
 	"github.com/runabol/tork/mq"
+	"github.com/runabol/tork/runtime"
+	"golang.org/x/exp/maps"
 )
 
 type Rexec func(args ...string) *exec.Cmd
@@ -27,6 +35,9 @@ const (
 	DEFAULT_UID  = "-"
 	DEFAULT_GID  = "-"
 	envVarPrefix = "REEXEC_"
+	// how much of the stderr of a failed
+	// command is included in its error
+	stderrTailSize = 4096
 )
 
 func init() {
@@ -34,20 +45,38 @@ func init() {
 }
 
 type ShellRuntime struct {
-	cmds   *syncx.Map[string, *exec.Cmd]
-	shell  []string
-	uid    string
-	gid    string
-	reexec Rexec
-	broker mq.Broker
+	cmds      *syncx.Map[string, *exec.Cmd]
+	shell     []string
+	uid       string
+	gid       string
+	rlimits   map[string]int
+	reexec    Rexec
+	broker    mq.Broker
+	artifacts artifact.Store
+	downloads artifact.FetchPolicy
+	// whether a non-zero exit code doesn't fail the task
+	ignoreExitCode bool
 }
 
 type Config struct {
-	CMD    []string
-	UID    string
-	GID    string
-	Rexec  Rexec
-	Broker mq.Broker
+	CMD []string
+	UID string
+	GID string
+	// Rlimits sets resource limits (e.g. nofile, nproc)
+	// on the task process
+	Rlimits map[string]int
+	Rexec   Rexec
+	Broker  mq.Broker
+	// Artifacts is the store that the artifacts
+	// produced by tasks are uploaded to
+	Artifacts artifact.Store
+	// Downloads restricts the URLs that the inputs
+	// of tasks are downloaded from and their size
+	Downloads artifact.FetchPolicy
+	// IgnoreExitCode completes tasks which exit with a non-zero
+	// code instead of failing them. The exit code is still
+	// recorded on the task.
+	IgnoreExitCode bool
 }
 
 func NewShellRuntime(cfg Config) *ShellRuntime {
@@ -64,12 +93,17 @@ func NewShellRuntime(cfg Config) *ShellRuntime {
 		cfg.GID = DEFAULT_GID
 	}
 	return &ShellRuntime{
-		cmds:   new(syncx.Map[string, *exec.Cmd]),
-		shell:  cfg.CMD,
-		uid:    cfg.UID,
-		gid:    cfg.GID,
-		reexec: cfg.Rexec,
-		broker: cfg.Broker,
+		cmds:      new(syncx.Map[string, *exec.Cmd]),
+		shell:     cfg.CMD,
+		uid:       cfg.UID,
+		gid:       cfg.GID,
+		rlimits:   cfg.Rlimits,
+		reexec:    cfg.Rexec,
+		broker:    cfg.Broker,
+		artifacts: cfg.Artifacts,
+		downloads: cfg.Downloads,
+
+		ignoreExitCode: cfg.IgnoreExitCode,
 	}
 }
 
@@ -86,7 +120,7 @@ func (r *ShellRuntime) Run(ctx context.Context, t *tork.Task) error {
 	if t.Image != "" {
 		return errors.New("image is not supported on shell runtime")
 	}
-	if t.Limits != nil && (t.Limits.CPUs != "" || t.Limits.Memory != "") {
+	if t.Limits != nil && (t.Limits.CPUs != "" || t.Limits.CPUShares != 0 || t.Limits.Memory != "") {
 		return errors.New("limits are not supported on shell runtime")
 	}
 	if len(t.Networks) > 0 {
@@ -98,6 +132,31 @@ func (r *ShellRuntime) Run(ctx context.Context, t *tork.Task) error {
 	if len(t.CMD) > 0 {
 		return errors.New("cmd is not supported on shell runtime")
 	}
+	if t.Service != nil {
+		return errors.New("services are not supported on shell runtime")
+	}
+	if t.User != "" {
+		return errors.New("user is not supported on shell runtime")
+	}
+	if t.Security != nil {
+		return errors.New("security options are not supported on shell runtime")
+	}
+	if t.ShmSize != "" {
+		return errors.New("shm size is not supported on shell runtime")
+	}
+	if len(t.Ulimits) > 0 {
+		return errors.New("ulimits are not supported on shell runtime. use runtime.shell.rlimits instead")
+	}
+	if len(t.Devices) > 0 {
+		return errors.New("devices are not supported on shell runtime")
+	}
+	if t.Platform != "" && t.Platform != goruntime.GOOS+"/"+goruntime.GOARCH {
+		return errors.Errorf("platform %s is not supported on this host", t.Platform)
+	}
+	rlimits, err := formatRlimits(r.rlimits)
+	if err != nil {
+		return err
+	}
 	var logger io.Writer
 	if r.broker != nil {
 		logger = mq.NewLogShipper(r.broker, t.ID)
@@ -107,25 +166,25 @@ func (r *ShellRuntime) Run(ctx context.Context, t *tork.Task) error {
 	// excute pre-tasks
 	for _, pre := range t.Pre {
 		pre.ID = uuid.NewUUID()
-		if err := r.doRun(ctx, pre, logger); err != nil {
+		if err := r.doRun(ctx, pre, rlimits, logger); err != nil {
 			return err
 		}
 	}
 	// run the actual task
-	if err := r.doRun(ctx, t, logger); err != nil {
+	if err := r.doRun(ctx, t, rlimits, logger); err != nil {
 		return err
 	}
 	// execute post tasks
 	for _, post := range t.Post {
 		post.ID = uuid.NewUUID()
-		if err := r.doRun(ctx, post, logger); err != nil {
+		if err := r.doRun(ctx, post, rlimits, logger); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
-func (r *ShellRuntime) doRun(ctx context.Context, t *tork.Task, logger io.Writer) error {
+func (r *ShellRuntime) doRun(ctx context.Context, t *tork.Task, rlimits string, logger io.Writer) error {
 	defer r.cmds.Delete(t.ID)
 
 	workdir, err := os.MkdirTemp("", "tork")
@@ -151,6 +210,10 @@ func (r *ShellRuntime) doRun(ctx context.Context, t *tork.Task, logger io.Writer
 		}
 	}
 
+	if err := r.stageDownloads(ctx, workdir, t); err != nil {
+		return err
+	}
+
 	env := []string{}
 	for name, value := range t.Env {
 		env = append(env, fmt.Sprintf("%s%s=%s", envVarPrefix, name, value))
@@ -164,6 +227,9 @@ func (r *ShellRuntime) doRun(ctx context.Context, t *tork.Task, logger io.Writer
 		return errors.Wrapf(err, "error writing the entrypoint")
 	}
 	args := append(r.shell, fmt.Sprintf("%s/entrypoint", workdir))
+	if rlimits != "" {
+		args = append([]string{"-rlimits", rlimits}, args...)
+	}
 	args = append([]string{"shell", "-uid", r.uid, "-gid", r.gid}, args...)
 	cmd := r.reexec(args...)
 	cmd.Env = env
@@ -173,7 +239,10 @@ func (r *ShellRuntime) doRun(ctx context.Context, t *tork.Task, logger io.Writer
 		return err
 	}
 	defer stdout.Close()
-	cmd.Stderr = cmd.Stdout
+	// stderr goes to the log as well, and its tail
+	// is kept for the error of a failed command
+	stderr := &tailWriter{max: stderrTailSize}
+	cmd.Stderr = io.MultiWriter(logger, stderr)
 
 	if err := cmd.Start(); err != nil {
 		return err
@@ -222,7 +291,17 @@ func (r *ShellRuntime) doRun(ctx context.Context, t *tork.Task, logger io.Writer
 	}()
 	select {
 	case err := <-errChan:
-		return errors.Wrapf(err, "error executing command")
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) {
+			return errors.Wrapf(err, "error executing command")
+		}
+		t.ExitCode = exitErr.ExitCode()
+		if !r.ignoreExitCode || t.ExitCode <= 0 {
+			if tail := stderr.String(); tail != "" {
+				return errors.Wrapf(err, "error executing command: %s", tail)
+			}
+			return errors.Wrapf(err, "error executing command")
+		}
 	case <-ctx.Done():
 		if err := cmd.Process.Kill(); err != nil {
 			return errors.Wrapf(err, "error cancelling command")
@@ -231,16 +310,135 @@ func (r *ShellRuntime) doRun(ctx context.Context, t *tork.Task, logger io.Writer
 	case <-doneChan:
 	}
 
-	output, err := os.ReadFile(fmt.Sprintf("%s/stdout", workdir))
+	output, err := os.Open(fmt.Sprintf("%s/stdout", workdir))
 	if err != nil {
 		return errors.Wrapf(err, "error reading the task output")
 	}
+	defer output.Close()
+
+	if err := runtime.SpillResult(ctx, r.artifacts, t, output); err != nil {
+		return errors.Wrapf(err, "error reading the task output")
+	}
+
+	return r.uploadArtifacts(ctx, workdir, t)
+}
+
+// stageDownloads fetches the task's downloads into its workdir.
+// Unlike in a container, downloads may not be written outside
+// of the workdir.
+func (r *ShellRuntime) stageDownloads(ctx context.Context, workdir string, t *tork.Task) error {
+	for _, d := range t.Downloads {
+		p := filepath.Join(workdir, d.Path)
+		if filepath.IsAbs(d.Path) || !strings.HasPrefix(p, workdir+string(filepath.Separator)) {
+			return errors.Errorf("download path must be relative to the workdir: %s", d.Path)
+		}
+		if err := r.download(ctx, d.URL, p); err != nil {
+			return errors.Wrapf(err, "error downloading %s", d.URL)
+		}
+	}
+	return nil
+}
 
-	t.Result = string(output)
+func (r *ShellRuntime) download(ctx context.Context, url, p string) error {
+	src, err := r.downloads.Fetch(ctx, r.artifacts, url)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+	f, err := os.Create(p)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(f, src)
+	return err
+}
 
+// uploadArtifacts uploads the task's artifacts to the artifact
+// store. Relative paths are resolved against the task's workdir
+// and directories are uploaded as tar archives.
+func (r *ShellRuntime) uploadArtifacts(ctx context.Context, workdir string, t *tork.Task) error {
+	if len(t.Artifacts) == 0 {
+		return nil
+	}
+	if r.artifacts == nil {
+		return errors.New("task has artifacts but no artifact store is configured")
+	}
+	for _, a := range t.Artifacts {
+		p := a.Path
+		if !filepath.IsAbs(p) {
+			p = filepath.Join(workdir, p)
+		}
+		url, err := r.uploadArtifact(ctx, p, t)
+		if err != nil {
+			return errors.Wrapf(err, "error uploading artifact %s", a.Path)
+		}
+		a.URL = url
+	}
 	return nil
 }
 
+func (r *ShellRuntime) uploadArtifact(ctx context.Context, p string, t *tork.Task) (string, error) {
+	stat, err := os.Stat(p)
+	if err != nil {
+		return "", err
+	}
+	if !stat.IsDir() {
+		f, err := os.Open(p)
+		if err != nil {
+			return "", err
+		}
+		defer f.Close()
+		return r.artifacts.Put(ctx, artifact.Key(t, stat.Name()), f)
+	}
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(tarDir(pw, p))
+	}()
+	defer pr.Close()
+	return r.artifacts.Put(ctx, artifact.Key(t, stat.Name()+".tar"), pr)
+}
+
+func tarDir(w io.Writer, dir string) error {
+	tw := tar.NewWriter(w)
+	if err := filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() && !info.IsDir() {
+			return nil
+		}
+		name, err := filepath.Rel(dir, p)
+		if err != nil || name == "." {
+			return err
+		}
+		hdr, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		hdr.Name = filepath.ToSlash(name)
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	}); err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
 func (r *ShellRuntime) readProgress(workdir string) (float64, error) {
 	b, err := os.ReadFile(fmt.Sprintf("%s/progress", workdir))
 	if err != nil {
@@ -256,10 +454,15 @@ func (r *ShellRuntime) readProgress(workdir string) (float64, error) {
 func reexecRun() {
 	var uid string
 	var gid string
+	var rlimits string
 	flag.StringVar(&uid, "uid", "", "the uid to use when running the process")
 	flag.StringVar(&gid, "gid", "", "the gid to use when running the process")
+	flag.StringVar(&rlimits, "rlimits", "", "the resource limits to set on the process")
 	flag.Parse()
 
+	// rlimits must be set before dropping privileges
+	// in case they exceed the current hard limits
+	SetRlimits(rlimits)
 	SetUID(uid)
 	SetGID(gid)
 
@@ -288,10 +491,56 @@ func reexecRun() {
 	cmd.Dir = workdir
 
 	if err := cmd.Run(); err != nil {
+		// exit with the code of the process so that
+		// the runtime can record it on the task
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && exitErr.ExitCode() > 0 {
+			os.Exit(exitErr.ExitCode())
+		}
 		log.Fatal().Err(err).Msgf("error reexecing: %s", strings.Join(flag.Args(), " "))
 	}
 }
 
+// formatRlimits encodes the rlimits as a
+// comma-separated list of name=value pairs
+func formatRlimits(rlimits map[string]int) (string, error) {
+	names := maps.Keys(rlimits)
+	sort.Strings(names)
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		if _, ok := rlimitResources[name]; !ok {
+			return "", errors.Errorf("unknown rlimit: %s", name)
+		}
+		if rlimits[name] < 0 {
+			return "", errors.Errorf("invalid rlimit value: %s=%d", name, rlimits[name])
+		}
+		pairs[i] = fmt.Sprintf("%s=%d", name, rlimits[name])
+	}
+	return strings.Join(pairs, ","), nil
+}
+
+func parseRlimits(s string) (map[string]uint64, error) {
+	result := make(map[string]uint64)
+	if s == "" {
+		return result, nil
+	}
+	for _, pair := range strings.Split(s, ",") {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 {
+			return nil, errors.Errorf("invalid rlimit: %s", pair)
+		}
+		if _, ok := rlimitResources[kv[0]]; !ok {
+			return nil, errors.Errorf("unknown rlimit: %s", kv[0])
+		}
+		v, err := strconv.ParseUint(kv[1], 10, 64)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid rlimit value: %s", pair)
+		}
+		result[kv[0]] = v
+	}
+	return result, nil
+}
+
 func (r *ShellRuntime) Stop(ctx context.Context, t *tork.Task) error {
 	proc, ok := r.cmds.Get(t.ID)
 	if !ok {
@@ -306,3 +555,26 @@ func (r *ShellRuntime) Stop(ctx context.Context, t *tork.Task) error {
 func (r *ShellRuntime) HealthCheck(ctx context.Context) error {
 	return nil
 }
+
+// tailWriter keeps the last max bytes written to it.
+type tailWriter struct {
+	mu  sync.Mutex
+	buf []byte
+	max int
+}
+
+func (w *tailWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.buf = append(w.buf, p...)
+	if len(w.buf) > w.max {
+		w.buf = w.buf[len(w.buf)-w.max:]
+	}
+	return len(p), nil
+}
+
+func (w *tailWriter) String() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return strings.TrimSpace(string(w.buf))
+}
@@ -1,17 +1,34 @@
 package shell
 
 import (
+	"archive/tar"
 	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
 	"os/exec"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/runabol/tork"
+	"github.com/runabol/tork/artifact"
+	"github.com/runabol/tork/internal/reexec"
 	"github.com/runabol/tork/internal/uuid"
 	"github.com/runabol/tork/mq"
 	"github.com/stretchr/testify/assert"
 )
 
+func TestMain(m *testing.M) {
+	// run the re-exec'd task process
+	if reexec.Init() {
+		return
+	}
+	os.Exit(m.Run())
+}
+
 func TestShellRuntimeRunResult(t *testing.T) {
 	rt := NewShellRuntime(Config{
 		UID: DEFAULT_UID,
@@ -69,6 +86,48 @@ func TestShellRuntimeRunNotSupported(t *testing.T) {
 	err := rt.Run(context.Background(), tk)
 
 	assert.Error(t, err)
+
+	err = rt.Run(context.Background(), &tork.Task{
+		ID:   uuid.NewUUID(),
+		Run:  "echo hello world",
+		User: "nobody",
+	})
+	assert.Error(t, err)
+
+	err = rt.Run(context.Background(), &tork.Task{
+		ID:       uuid.NewUUID(),
+		Run:      "echo hello world",
+		Security: &tork.TaskSecurity{ReadOnlyRootfs: true},
+	})
+	assert.Error(t, err)
+
+	err = rt.Run(context.Background(), &tork.Task{
+		ID:      uuid.NewUUID(),
+		Run:     "echo hello world",
+		ShmSize: "1g",
+	})
+	assert.Error(t, err)
+
+	err = rt.Run(context.Background(), &tork.Task{
+		ID:      uuid.NewUUID(),
+		Run:     "echo hello world",
+		Ulimits: []string{"nofile=1024"},
+	})
+	assert.Error(t, err)
+
+	err = rt.Run(context.Background(), &tork.Task{
+		ID:      uuid.NewUUID(),
+		Run:     "echo hello world",
+		Devices: []string{"/dev/null"},
+	})
+	assert.Error(t, err)
+
+	err = rt.Run(context.Background(), &tork.Task{
+		ID:       uuid.NewUUID(),
+		Run:      "echo hello world",
+		Platform: "plan9/mips",
+	})
+	assert.Error(t, err)
 }
 
 func TestShellRuntimeRunError(t *testing.T) {
@@ -91,6 +150,95 @@ func TestShellRuntimeRunError(t *testing.T) {
 	assert.Error(t, err)
 }
 
+func TestShellRuntimeRunExitCode(t *testing.T) {
+	rt := NewShellRuntime(Config{
+		UID: DEFAULT_UID,
+		GID: DEFAULT_GID,
+		Rexec: func(args ...string) *exec.Cmd {
+			cmd := exec.Command(args[5], args[6:]...)
+			return cmd
+		},
+	})
+
+	tk := &tork.Task{
+		ID:  uuid.NewUUID(),
+		Run: "exit 3",
+	}
+
+	err := rt.Run(context.Background(), tk)
+
+	assert.Error(t, err)
+	assert.Equal(t, 3, tk.ExitCode)
+}
+
+func TestShellRuntimeRunStderr(t *testing.T) {
+	rt := NewShellRuntime(Config{
+		UID: DEFAULT_UID,
+		GID: DEFAULT_GID,
+		Rexec: func(args ...string) *exec.Cmd {
+			cmd := exec.Command(args[5], args[6:]...)
+			return cmd
+		},
+	})
+
+	tk := &tork.Task{
+		ID:  uuid.NewUUID(),
+		Run: "echo some output; echo something went wrong >&2; exit 3",
+	}
+
+	err := rt.Run(context.Background(), tk)
+
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "something went wrong")
+	assert.NotContains(t, err.Error(), "some output")
+	assert.Equal(t, 3, tk.ExitCode)
+}
+
+func TestTailWriter(t *testing.T) {
+	w := &tailWriter{max: 5}
+	_, err := w.Write([]byte("hello "))
+	assert.NoError(t, err)
+	_, err = w.Write([]byte("world"))
+	assert.NoError(t, err)
+	assert.Equal(t, "world", w.String())
+}
+
+func TestShellRuntimeRunReexecExitCode(t *testing.T) {
+	rt := NewShellRuntime(Config{
+		UID: DEFAULT_UID,
+		GID: DEFAULT_GID,
+	})
+
+	tk := &tork.Task{
+		ID:  uuid.NewUUID(),
+		Run: "exit 3",
+	}
+
+	err := rt.Run(context.Background(), tk)
+
+	assert.Error(t, err)
+	assert.Equal(t, 3, tk.ExitCode)
+}
+
+func TestShellRuntimeRunIgnoreExitCode(t *testing.T) {
+	rt := NewShellRuntime(Config{
+		UID:            DEFAULT_UID,
+		GID:            DEFAULT_GID,
+		IgnoreExitCode: true,
+	})
+
+	tk := &tork.Task{
+		ID:  uuid.NewUUID(),
+		Run: "echo -n partial > $TORK_OUTPUT; exit 2",
+	}
+
+	err := rt.Run(context.Background(), tk)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 2, tk.ExitCode)
+	assert.Equal(t, "partial", tk.Result)
+}
+
 func TestShellRuntimeRunTimeout(t *testing.T) {
 	rt := NewShellRuntime(Config{
 		UID: DEFAULT_UID,
@@ -167,3 +315,202 @@ func TestRunTaskCMDLogger(t *testing.T) {
 	assert.NoError(t, err)
 	<-processed
 }
+
+func TestShellRuntimeRunRlimits(t *testing.T) {
+	var rargs []string
+	rt := NewShellRuntime(Config{
+		UID: DEFAULT_UID,
+		GID: DEFAULT_GID,
+		Rlimits: map[string]int{
+			"nproc":  64,
+			"nofile": 1024,
+		},
+		Rexec: func(args ...string) *exec.Cmd {
+			rargs = args
+			cmd := exec.Command(args[7], args[8:]...)
+			return cmd
+		},
+	})
+
+	tk := &tork.Task{
+		ID:  uuid.NewUUID(),
+		Run: "echo -n hello world > $REEXEC_TORK_OUTPUT",
+	}
+
+	err := rt.Run(context.Background(), tk)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "hello world", tk.Result)
+	assert.Equal(t, []string{"-rlimits", "nofile=1024,nproc=64"}, rargs[5:7])
+}
+
+func TestShellRuntimeRunBadRlimits(t *testing.T) {
+	rt := NewShellRuntime(Config{
+		UID: DEFAULT_UID,
+		GID: DEFAULT_GID,
+		Rlimits: map[string]int{
+			"nosuchlimit": 1,
+		},
+	})
+
+	tk := &tork.Task{
+		ID:  uuid.NewUUID(),
+		Run: "echo hello world",
+	}
+
+	err := rt.Run(context.Background(), tk)
+	assert.Error(t, err)
+}
+
+func TestParseRlimits(t *testing.T) {
+	limits, err := parseRlimits("nofile=1024,nproc=64")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]uint64{"nofile": 1024, "nproc": 64}, limits)
+
+	_, err = parseRlimits("nofile")
+	assert.Error(t, err)
+
+	_, err = parseRlimits("nofile=abc")
+	assert.Error(t, err)
+}
+
+func TestShellRuntimeRunResultTruncated(t *testing.T) {
+	rt := NewShellRuntime(Config{
+		UID: DEFAULT_UID,
+		GID: DEFAULT_GID,
+		Rexec: func(args ...string) *exec.Cmd {
+			cmd := exec.Command(args[5], args[6:]...)
+			return cmd
+		},
+	})
+
+	tk := &tork.Task{
+		ID:     uuid.NewUUID(),
+		Run:    "echo -n hello world > $REEXEC_TORK_OUTPUT",
+		Limits: &tork.TaskLimits{Output: "5b"},
+	}
+
+	err := rt.Run(context.Background(), tk)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", tk.Result)
+	assert.True(t, tk.ResultTruncated)
+}
+
+func TestShellRuntimeRunArtifacts(t *testing.T) {
+	store, err := artifact.NewFileStore(t.TempDir())
+	assert.NoError(t, err)
+	rt := NewShellRuntime(Config{
+		UID: DEFAULT_UID,
+		GID: DEFAULT_GID,
+		Rexec: func(args ...string) *exec.Cmd {
+			cmd := exec.Command(args[5], args[6:]...)
+			return cmd
+		},
+		Artifacts: store,
+	})
+
+	tk := &tork.Task{
+		ID:    uuid.NewUUID(),
+		JobID: uuid.NewUUID(),
+		Run:   "echo -n hello > out.txt && mkdir reports && echo -n world > reports/a.txt",
+		Artifacts: []*tork.Artifact{
+			{Path: "out.txt"},
+			{Path: "reports"},
+		},
+	}
+
+	err = rt.Run(context.Background(), tk)
+	assert.NoError(t, err)
+
+	u, err := url.Parse(tk.Artifacts[0].URL)
+	assert.NoError(t, err)
+	b, err := os.ReadFile(u.Path)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", string(b))
+
+	u, err = url.Parse(tk.Artifacts[1].URL)
+	assert.NoError(t, err)
+	assert.True(t, strings.HasSuffix(u.Path, "/reports.tar"))
+	f, err := os.Open(u.Path)
+	assert.NoError(t, err)
+	defer f.Close()
+	tr := tar.NewReader(f)
+	hdr, err := tr.Next()
+	assert.NoError(t, err)
+	assert.Equal(t, "a.txt", hdr.Name)
+	b, err = io.ReadAll(tr)
+	assert.NoError(t, err)
+	assert.Equal(t, "world", string(b))
+}
+
+func TestShellRuntimeRunArtifactsNoStore(t *testing.T) {
+	rt := NewShellRuntime(Config{
+		UID: DEFAULT_UID,
+		GID: DEFAULT_GID,
+		Rexec: func(args ...string) *exec.Cmd {
+			cmd := exec.Command(args[5], args[6:]...)
+			return cmd
+		},
+	})
+
+	tk := &tork.Task{
+		ID:        uuid.NewUUID(),
+		Run:       "echo -n hello > out.txt",
+		Artifacts: []*tork.Artifact{{Path: "out.txt"}},
+	}
+
+	err := rt.Run(context.Background(), tk)
+	assert.Error(t, err)
+}
+
+func TestShellRuntimeRunDownloads(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("hello world"))
+	}))
+	defer srv.Close()
+	rt := NewShellRuntime(Config{
+		UID: DEFAULT_UID,
+		GID: DEFAULT_GID,
+		Rexec: func(args ...string) *exec.Cmd {
+			cmd := exec.Command(args[5], args[6:]...)
+			return cmd
+		},
+		Downloads: artifact.FetchPolicy{
+			Hosts: []string{strings.TrimPrefix(srv.URL, "http://")},
+		},
+	})
+
+	tk := &tork.Task{
+		ID:  uuid.NewUUID(),
+		Run: "cat data/hello.txt > $REEXEC_TORK_OUTPUT",
+		Downloads: []*tork.Artifact{
+			{URL: srv.URL + "/hello.txt", Path: "data/hello.txt"},
+		},
+	}
+
+	err := rt.Run(context.Background(), tk)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello world", tk.Result)
+
+	tk = &tork.Task{
+		ID:  uuid.NewUUID(),
+		Run: "true",
+		Downloads: []*tork.Artifact{
+			{URL: srv.URL + "/hello.txt", Path: "../hello.txt"},
+		},
+	}
+	err = rt.Run(context.Background(), tk)
+	assert.Error(t, err)
+
+	// local files can't be downloaded
+	tk = &tork.Task{
+		ID:  uuid.NewUUID(),
+		Run: "true",
+		Downloads: []*tork.Artifact{
+			{URL: "file:///etc/passwd", Path: "passwd"},
+		},
+	}
+	err = rt.Run(context.Background(), tk)
+	assert.Error(t, err)
+}
@@ -0,0 +1,65 @@
+package tork
+
+import "time"
+
+type ScheduledJobState string
+
+const (
+	ScheduledJobStateActive ScheduledJobState = "ACTIVE"
+	ScheduledJobStatePaused ScheduledJobState = "PAUSED"
+)
+
+// ScheduledJobOverlap determines what happens when a scheduled
+// job is due while the job it previously triggered is still active.
+type ScheduledJobOverlap string
+
+const (
+	// ScheduledJobOverlapSkip skips the run.
+	ScheduledJobOverlapSkip ScheduledJobOverlap = "skip"
+	// ScheduledJobOverlapQueue delays the run until
+	// the previous job is done.
+	ScheduledJobOverlapQueue ScheduledJobOverlap = "queue"
+	// ScheduledJobOverlapReplace cancels the previous
+	// job and starts a new one.
+	ScheduledJobOverlapReplace ScheduledJobOverlap = "replace"
+)
+
+// ScheduledJob triggers a new instance of its
+// Template job every time its Cron expression is due.
+type ScheduledJob struct {
+	ID          string              `json:"id,omitempty"`
+	Name        string              `json:"name,omitempty"`
+	Description string              `json:"description,omitempty"`
+	Cron        string              `json:"cron,omitempty"`
+	Overlap     ScheduledJobOverlap `json:"overlap,omitempty"`
+	State       ScheduledJobState   `json:"state,omitempty"`
+	Template    *Job                `json:"template,omitempty"`
+	CreatedAt   time.Time           `json:"createdAt,omitempty"`
+	CreatedBy   *User               `json:"createdBy,omitempty"`
+	LastRunAt   *time.Time          `json:"lastRunAt,omitempty"`
+	LastJobID   string              `json:"lastJobId,omitempty"`
+}
+
+func (sj *ScheduledJob) Clone() *ScheduledJob {
+	var template *Job
+	if sj.Template != nil {
+		template = sj.Template.Clone()
+	}
+	var createdBy *User
+	if sj.CreatedBy != nil {
+		createdBy = sj.CreatedBy.Clone()
+	}
+	return &ScheduledJob{
+		ID:          sj.ID,
+		Name:        sj.Name,
+		Description: sj.Description,
+		Cron:        sj.Cron,
+		Overlap:     sj.Overlap,
+		State:       sj.State,
+		Template:    template,
+		CreatedAt:   sj.CreatedAt,
+		CreatedBy:   createdBy,
+		LastRunAt:   sj.LastRunAt,
+		LastJobID:   sj.LastJobID,
+	}
+}
@@ -0,0 +1,28 @@
+package tork
+
+import "time"
+
+// SECRET_REF_PREFIX marks a task env value as a reference
+// to a managed secret, e.g. secret://db-password
+const SECRET_REF_PREFIX = "secret://"
+
+// Secret is a named value managed by Tork. Tasks reference it
+// in their env as secret://<name> and receive its value when
+// they are sent to a worker.
+type Secret struct {
+	Name      string     `json:"name,omitempty"`
+	Value     string     `json:"value,omitempty"`
+	CreatedBy string     `json:"createdBy,omitempty"` // the ID of the user who created the secret
+	CreatedAt *time.Time `json:"createdAt,omitempty"`
+	UpdatedAt *time.Time `json:"updatedAt,omitempty"`
+}
+
+func (s *Secret) Clone() *Secret {
+	return &Secret{
+		Name:      s.Name,
+		Value:     s.Value,
+		CreatedBy: s.CreatedBy,
+		CreatedAt: s.CreatedAt,
+		UpdatedAt: s.UpdatedAt,
+	}
+}
@@ -0,0 +1,56 @@
+package secrets
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// MultiProvider dispatches secret references of
+// the form <scheme>://<ref> to the provider that
+// was registered for the scheme.
+type MultiProvider struct {
+	providers map[string]Provider
+	mu        sync.RWMutex
+}
+
+func NewMultiProvider() *MultiProvider {
+	return &MultiProvider{
+		providers: make(map[string]Provider),
+	}
+}
+
+// RegisterProvider registers the provider for the
+// scheme. A scheme can only be registered once.
+func (m *MultiProvider) RegisterProvider(scheme string, p Provider) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.providers[scheme]; ok {
+		return errors.Errorf("a secrets provider is already registered for scheme %s", scheme)
+	}
+	m.providers[scheme] = p
+	return nil
+}
+
+// Resolve returns the secret value the given value refers
+// to. Values that don't start with a registered scheme are
+// returned as they are.
+func (m *MultiProvider) Resolve(ctx context.Context, value string) (string, error) {
+	scheme, ref, ok := strings.Cut(value, "://")
+	if !ok {
+		return value, nil
+	}
+	m.mu.RLock()
+	p, ok := m.providers[scheme]
+	m.mu.RUnlock()
+	if !ok {
+		return value, nil
+	}
+	v, err := p.GetSecret(ctx, ref)
+	if err != nil {
+		return "", errors.Wrapf(err, "error resolving secret %s", value)
+	}
+	return v, nil
+}
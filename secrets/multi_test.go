@@ -0,0 +1,47 @@
+package secrets_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/runabol/tork/secrets"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeProvider map[string]string
+
+func (p fakeProvider) GetSecret(ctx context.Context, ref string) (string, error) {
+	v, ok := p[ref]
+	if !ok {
+		return "", errors.Errorf("secret not found: %s", ref)
+	}
+	return v, nil
+}
+
+func TestMultiProviderResolve(t *testing.T) {
+	m := secrets.NewMultiProvider()
+	assert.NoError(t, m.RegisterProvider("fake", fakeProvider{"db#password": "s3cr3t"}))
+
+	v, err := m.Resolve(context.Background(), "fake://db#password")
+	assert.NoError(t, err)
+	assert.Equal(t, "s3cr3t", v)
+
+	_, err = m.Resolve(context.Background(), "fake://db#username")
+	assert.Error(t, err)
+
+	// unregistered schemes and plain values are left alone
+	v, err = m.Resolve(context.Background(), "https://example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, "https://example.com", v)
+
+	v, err = m.Resolve(context.Background(), "plain")
+	assert.NoError(t, err)
+	assert.Equal(t, "plain", v)
+}
+
+func TestMultiProviderRegisterTwice(t *testing.T) {
+	m := secrets.NewMultiProvider()
+	assert.NoError(t, m.RegisterProvider("fake", fakeProvider{}))
+	assert.Error(t, m.RegisterProvider("fake", fakeProvider{}))
+}
@@ -0,0 +1,11 @@
+package secrets
+
+import "context"
+
+// Provider looks up secrets held in an external secrets store.
+type Provider interface {
+	// GetSecret returns the value of the secret that the
+	// ref points to. The ref is the part of the reference
+	// that follows the <scheme>:// prefix.
+	GetSecret(ctx context.Context, ref string) (string, error)
+}
@@ -0,0 +1,71 @@
+package secrets
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/runabol/tork"
+	"github.com/runabol/tork/mq"
+)
+
+const redactedStr = "[REDACTED]"
+
+// Redactor keeps track of the secret values resolved for the
+// tasks running on a worker, so that they can be masked in the
+// logs and results published for those tasks. The coordinator
+// never sees the resolved values, so it can't redact them.
+type Redactor struct {
+	values map[string][]string
+	mu     sync.RWMutex
+}
+
+func NewRedactor() *Redactor {
+	return &Redactor{
+		values: make(map[string][]string),
+	}
+}
+
+// Add registers secret values resolved for the task.
+func (r *Redactor) Add(taskID string, values ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, v := range values {
+		if v != "" {
+			r.values[taskID] = append(r.values[taskID], v)
+		}
+	}
+}
+
+// Remove forgets the secret values of the task.
+func (r *Redactor) Remove(taskID string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.values, taskID)
+}
+
+// Redact masks the secret values of the task found in s.
+func (r *Redactor) Redact(taskID, s string) string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, v := range r.values[taskID] {
+		s = strings.ReplaceAll(s, v, redactedStr)
+	}
+	return s
+}
+
+// Broker wraps the broker so that the secret values are
+// masked in the task log parts published through it.
+func (r *Redactor) Broker(b mq.Broker) mq.Broker {
+	return &redactingBroker{Broker: b, r: r}
+}
+
+type redactingBroker struct {
+	mq.Broker
+	r *Redactor
+}
+
+func (b *redactingBroker) PublishTaskLogPart(ctx context.Context, p *tork.TaskLogPart) error {
+	p.Contents = b.r.Redact(p.TaskID, p.Contents)
+	return b.Broker.PublishTaskLogPart(ctx, p)
+}
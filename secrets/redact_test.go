@@ -0,0 +1,17 @@
+package secrets_test
+
+import (
+	"testing"
+
+	"github.com/runabol/tork/secrets"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRedactor(t *testing.T) {
+	r := secrets.NewRedactor()
+	r.Add("1234", "s3cr3t", "")
+	assert.Equal(t, "pw=[REDACTED]", r.Redact("1234", "pw=s3cr3t"))
+	assert.Equal(t, "pw=s3cr3t", r.Redact("5678", "pw=s3cr3t"))
+	r.Remove("1234")
+	assert.Equal(t, "pw=s3cr3t", r.Redact("1234", "pw=s3cr3t"))
+}
@@ -0,0 +1,111 @@
+package vault
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// Scheme is the reference scheme the
+	// Vault provider is registered under
+	Scheme = "vault"
+
+	defaultMount       = "secret"
+	defaultHTTPTimeout = time.Second * 10
+)
+
+type Config struct {
+	// Address is the base URL of the Vault server,
+	// e.g. https://vault.example.com:8200
+	Address string
+	// Token is used to authenticate against Vault.
+	Token string
+	// Mount is the path the KV v2 secrets engine
+	// is mounted at. Defaults to secret
+	Mount string
+	// Namespace is the Vault Enterprise namespace, if any.
+	Namespace string
+}
+
+// Provider reads secrets from the Vault KV v2 secrets
+// engine. Secrets are referenced as <path>#<key>, e.g.
+// vault://myapp/db#password reads the password key of the
+// secret stored at myapp/db.
+type Provider struct {
+	cfg    Config
+	client *http.Client
+}
+
+func NewProvider(cfg Config) (*Provider, error) {
+	if cfg.Address == "" {
+		return nil, errors.New("must provide vault address")
+	}
+	if cfg.Token == "" {
+		return nil, errors.New("must provide vault token")
+	}
+	if cfg.Mount == "" {
+		cfg.Mount = defaultMount
+	}
+	cfg.Address = strings.TrimSuffix(cfg.Address, "/")
+	cfg.Mount = strings.Trim(cfg.Mount, "/")
+	return &Provider{
+		cfg:    cfg,
+		client: &http.Client{Timeout: defaultHTTPTimeout},
+	}, nil
+}
+
+func (p *Provider) GetSecret(ctx context.Context, ref string) (string, error) {
+	path, key, ok := strings.Cut(ref, "#")
+	path = strings.Trim(path, "/")
+	if !ok || path == "" || key == "" {
+		return "", errors.Errorf("invalid vault secret reference %s. expecting <path>#<key>", ref)
+	}
+	u := fmt.Sprintf("%s/v1/%s/data/%s", p.cfg.Address, p.cfg.Mount, (&url.URL{Path: path}).EscapedPath())
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", p.cfg.Token)
+	if p.cfg.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", p.cfg.Namespace)
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", errors.Wrapf(err, "error reading vault secret %s", path)
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return "", errors.Errorf("vault secret %s not found", path)
+	case resp.StatusCode != http.StatusOK:
+		return "", errors.Errorf("error reading vault secret %s: %d", path, resp.StatusCode)
+	}
+	body := struct {
+		Data struct {
+			Data map[string]any `json:"data"`
+		} `json:"data"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", errors.Wrapf(err, "error decoding vault secret %s", path)
+	}
+	v, ok := body.Data.Data[key]
+	if !ok {
+		return "", errors.Errorf("key %s not found in vault secret %s", key, path)
+	}
+	if s, ok := v.(string); ok {
+		return s, nil
+	}
+	// non-string values are passed on in their JSON form
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
@@ -0,0 +1,95 @@
+package vault_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/runabol/tork/secrets/vault"
+	"github.com/stretchr/testify/assert"
+)
+
+func newVault(t *testing.T) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "root" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		if r.URL.Path != "/v1/kv/data/myapp/db" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"data": map[string]any{
+				"data": map[string]any{
+					"password": "s3cr3t",
+					"port":     5432,
+				},
+				"metadata": map[string]any{
+					"version": 1,
+				},
+			},
+		})
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestGetSecret(t *testing.T) {
+	srv := newVault(t)
+	p, err := vault.NewProvider(vault.Config{
+		Address: srv.URL,
+		Token:   "root",
+		Mount:   "kv",
+	})
+	assert.NoError(t, err)
+
+	v, err := p.GetSecret(context.Background(), "myapp/db#password")
+	assert.NoError(t, err)
+	assert.Equal(t, "s3cr3t", v)
+
+	v, err = p.GetSecret(context.Background(), "myapp/db#port")
+	assert.NoError(t, err)
+	assert.Equal(t, "5432", v)
+}
+
+func TestGetSecretErrors(t *testing.T) {
+	srv := newVault(t)
+	p, err := vault.NewProvider(vault.Config{
+		Address: srv.URL,
+		Token:   "root",
+		Mount:   "kv",
+	})
+	assert.NoError(t, err)
+
+	// unknown key
+	_, err = p.GetSecret(context.Background(), "myapp/db#username")
+	assert.Error(t, err)
+
+	// unknown path
+	_, err = p.GetSecret(context.Background(), "myapp/other#password")
+	assert.Error(t, err)
+
+	// missing key
+	_, err = p.GetSecret(context.Background(), "myapp/db")
+	assert.Error(t, err)
+
+	// bad token
+	p, err = vault.NewProvider(vault.Config{
+		Address: srv.URL,
+		Token:   "bad",
+		Mount:   "kv",
+	})
+	assert.NoError(t, err)
+	_, err = p.GetSecret(context.Background(), "myapp/db#password")
+	assert.Error(t, err)
+}
+
+func TestNewProviderInvalid(t *testing.T) {
+	_, err := vault.NewProvider(vault.Config{Token: "root"})
+	assert.Error(t, err)
+	_, err = vault.NewProvider(vault.Config{Address: "http://localhost:8200"})
+	assert.Error(t, err)
+}
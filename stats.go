@@ -17,4 +17,7 @@ type TaskMetrics struct {
 type NodeMetrics struct {
 	Running    int     `json:"online"`
 	CPUPercent float64 `json:"cpuPercent"`
+	// average memory and disk usage of the workers
+	MemoryPercent float64 `json:"memoryPercent"`
+	DiskPercent   float64 `json:"diskPercent"`
 }
@@ -12,6 +12,7 @@ import (
 type TaskState string
 
 const (
+	TaskStateCreated   TaskState = "CREATED"
 	TaskStatePending   TaskState = "PENDING"
 	TaskStateScheduled TaskState = "SCHEDULED"
 	TaskStateRunning   TaskState = "RUNNING"
@@ -36,10 +37,13 @@ type Task struct {
 	StartedAt   *time.Time        `json:"startedAt,omitempty"`
 	CompletedAt *time.Time        `json:"completedAt,omitempty"`
 	FailedAt    *time.Time        `json:"failedAt,omitempty"`
+	RetryAt     *time.Time        `json:"retryAt,omitempty"`
 	CMD         []string          `json:"cmd,omitempty"`
 	Entrypoint  []string          `json:"entrypoint,omitempty"`
+	User        string            `json:"user,omitempty"`
 	Run         string            `json:"run,omitempty"`
 	Image       string            `json:"image,omitempty"`
+	ImageDigest string            `json:"imageDigest,omitempty"`
 	Registry    *Registry         `json:"registry,omitempty"`
 	Env         map[string]string `json:"env,omitempty"`
 	Files       map[string]string `json:"files,omitempty"`
@@ -52,38 +56,66 @@ type Task struct {
 	NodeID      string            `json:"nodeId,omitempty"`
 	Retry       *TaskRetry        `json:"retry,omitempty"`
 	Limits      *TaskLimits       `json:"limits,omitempty"`
+	Security    *TaskSecurity     `json:"security,omitempty"`
 	Timeout     string            `json:"timeout,omitempty"`
 	Result      string            `json:"result,omitempty"`
-	Var         string            `json:"var,omitempty"`
-	If          string            `json:"if,omitempty"`
-	Parallel    *ParallelTask     `json:"parallel,omitempty"`
-	Each        *EachTask         `json:"each,omitempty"`
-	SubJob      *SubJobTask       `json:"subjob,omitempty"`
-	GPUs        string            `json:"gpus,omitempty"`
-	Tags        []string          `json:"tags,omitempty"`
-	Workdir     string            `json:"workdir,omitempty"`
-	Priority    int               `json:"priority,omitempty"`
-	Progress    float64           `json:"progress,omitempty"`
-	Ports       []*Port           `json:"ports,omitempty"`
-	Internal    bool              `json:"-"`
+	// ResultTruncated is set when the task's output
+	// exceeded the maximum result size
+	ResultTruncated bool `json:"resultTruncated,omitempty"`
+	// ResultURL points to the full output of the task
+	// when it was too large to be stored inline
+	ResultURL string        `json:"resultURL,omitempty"`
+	ExitCode  int           `json:"exitCode,omitempty"`
+	Stats     *TaskStats    `json:"stats,omitempty"`
+	Var       string        `json:"var,omitempty"`
+	If        string        `json:"if,omitempty"`
+	Parallel  *ParallelTask `json:"parallel,omitempty"`
+	Each      *EachTask     `json:"each,omitempty"`
+	SubJob    *SubJobTask   `json:"subjob,omitempty"`
+	GPUs      string        `json:"gpus,omitempty"`
+	ShmSize   string        `json:"shmSize,omitempty"`
+	Ulimits   []string      `json:"ulimits,omitempty"`
+	Devices   []string      `json:"devices,omitempty"`
+	Platform  string        `json:"platform,omitempty"`
+	// Selector restricts the task to the workers
+	// whose tags match all of its key/value pairs
+	Selector  map[string]string `json:"selector,omitempty"`
+	Tags      []string          `json:"tags,omitempty"`
+	Workdir   string            `json:"workdir,omitempty"`
+	Priority  int               `json:"priority,omitempty"`
+	Progress  float64           `json:"progress,omitempty"`
+	Ports     []*Port           `json:"ports,omitempty"`
+	Artifacts []*Artifact       `json:"artifacts,omitempty"`
+	// Downloads are fetched from their URL to their path
+	// before the task starts. URLs are either artifacts of the
+	// worker's artifact store or http(s) URLs of allowed hosts.
+	Downloads []*Artifact `json:"downloads,omitempty"`
+	// Service keeps the task running until the end of
+	// its job once it passes its readiness probe
+	Service *ServiceTask `json:"service,omitempty"`
+	// Trace carries the W3C trace context of the
+	// scheduling span from the coordinator to the worker
+	Trace    map[string]string `json:"trace,omitempty"`
+	Internal bool              `json:"-"`
 }
 
 type TaskSummary struct {
-	ID          string     `json:"id,omitempty"`
-	JobID       string     `json:"jobId,omitempty"`
-	Position    int        `json:"position,omitempty"`
-	Progress    float64    `json:"progress,omitempty"`
-	Name        string     `json:"name,omitempty"`
-	Description string     `json:"description,omitempty"`
-	State       TaskState  `json:"state,omitempty"`
-	CreatedAt   *time.Time `json:"createdAt,omitempty"`
-	ScheduledAt *time.Time `json:"scheduledAt,omitempty"`
-	StartedAt   *time.Time `json:"startedAt,omitempty"`
-	CompletedAt *time.Time `json:"completedAt,omitempty"`
-	Error       string     `json:"error,omitempty"`
-	Result      string     `json:"result,omitempty"`
-	Var         string     `json:"var,omitempty"`
-	Tags        []string   `json:"tags,omitempty"`
+	ID              string     `json:"id,omitempty"`
+	JobID           string     `json:"jobId,omitempty"`
+	Position        int        `json:"position,omitempty"`
+	Progress        float64    `json:"progress,omitempty"`
+	Name            string     `json:"name,omitempty"`
+	Description     string     `json:"description,omitempty"`
+	State           TaskState  `json:"state,omitempty"`
+	CreatedAt       *time.Time `json:"createdAt,omitempty"`
+	ScheduledAt     *time.Time `json:"scheduledAt,omitempty"`
+	StartedAt       *time.Time `json:"startedAt,omitempty"`
+	CompletedAt     *time.Time `json:"completedAt,omitempty"`
+	Error           string     `json:"error,omitempty"`
+	Result          string     `json:"result,omitempty"`
+	ResultTruncated bool       `json:"resultTruncated,omitempty"`
+	Var             string     `json:"var,omitempty"`
+	Tags            []string   `json:"tags,omitempty"`
 }
 
 type TaskLogPart struct {
@@ -115,16 +147,39 @@ type EachTask struct {
 	Task        *Task  `json:"task,omitempty"`
 	Size        int    `json:"size,omitempty"`
 	Completions int    `json:"completions,omitempty"`
+	Concurrency int    `json:"concurrency,omitempty"`
 }
 
 type TaskRetry struct {
-	Limit    int `json:"limit,omitempty"`
-	Attempts int `json:"attempts,omitempty"`
+	Limit         int     `json:"limit,omitempty"`
+	Attempts      int     `json:"attempts,omitempty"`
+	InitialDelay  string  `json:"initialDelay,omitempty"`
+	ScalingFactor float64 `json:"scalingFactor,omitempty"`
+	MaxDelay      string  `json:"maxDelay,omitempty"`
 }
 
 type TaskLimits struct {
-	CPUs   string `json:"cpus,omitempty"`
-	Memory string `json:"memory,omitempty"`
+	CPUs      string `json:"cpus,omitempty"`
+	CPUShares int64  `json:"cpuShares,omitempty"`
+	Memory    string `json:"memory,omitempty"`
+	Output    string `json:"output,omitempty"`
+}
+
+// TaskSecurity hardens (or, with Privileged,
+// loosens) the task's container. Privileged and
+// SecurityOpt are subject to the worker's policy.
+type TaskSecurity struct {
+	CapDrop        []string `json:"capDrop,omitempty"`
+	SecurityOpt    []string `json:"securityOpt,omitempty"`
+	ReadOnlyRootfs bool     `json:"readOnlyRootfs,omitempty"`
+	Privileged     bool     `json:"privileged,omitempty"`
+}
+
+// TaskStats holds the peak resource usage
+// of the task's container.
+type TaskStats struct {
+	PeakCPUPercent float64 `json:"peakCPUPercent,omitempty"`
+	PeakMemory     int64   `json:"peakMemory,omitempty"`
 }
 
 type Registry struct {
@@ -137,6 +192,29 @@ type Port struct {
 	HostPort int    `json:"-"`
 }
 
+// ServiceTask is a long-running task (e.g. a database) that
+// the subsequent tasks of its job can connect to. It's torn
+// down once the job is done.
+type ServiceTask struct {
+	Probe *Probe `json:"probe,omitempty"`
+}
+
+// Probe checks whether a service is ready: by running CMD inside
+// of it, by an HTTP GET to Path on Port, or by connecting to Port.
+type Probe struct {
+	Port    string   `json:"port,omitempty"`
+	Path    string   `json:"path,omitempty"`
+	CMD     []string `json:"cmd,omitempty"`
+	Timeout string   `json:"timeout,omitempty"`
+}
+
+// Artifact is a file (or directory) produced by a task which
+// is uploaded to the artifact store once the task completes.
+type Artifact struct {
+	Path string `json:"path,omitempty"`
+	URL  string `json:"url,omitempty"`
+}
+
 func (s TaskState) IsActive() bool {
 	return s == TaskStatePending ||
 		s == TaskStateScheduled ||
@@ -168,48 +246,77 @@ func (t *Task) Clone() *Task {
 	if t.Registry != nil {
 		registry = t.Registry.Clone()
 	}
+	var service *ServiceTask
+	if t.Service != nil {
+		service = t.Service.Clone()
+	}
+	var security *TaskSecurity
+	if t.Security != nil {
+		security = t.Security.Clone()
+	}
+	var stats *TaskStats
+	if t.Stats != nil {
+		stats = t.Stats.Clone()
+	}
 	return &Task{
-		ID:          t.ID,
-		JobID:       t.JobID,
-		ParentID:    t.ParentID,
-		Position:    t.Position,
-		Name:        t.Name,
-		State:       t.State,
-		CreatedAt:   t.CreatedAt,
-		ScheduledAt: t.ScheduledAt,
-		StartedAt:   t.StartedAt,
-		CompletedAt: t.CompletedAt,
-		FailedAt:    t.FailedAt,
-		CMD:         t.CMD,
-		Entrypoint:  t.Entrypoint,
-		Run:         t.Run,
-		Image:       t.Image,
-		Registry:    registry,
-		Env:         maps.Clone(t.Env),
-		Files:       maps.Clone(t.Files),
-		Queue:       t.Queue,
-		Error:       t.Error,
-		Pre:         CloneTasks(t.Pre),
-		Post:        CloneTasks(t.Post),
-		Mounts:      slices.Clone(t.Mounts),
-		Networks:    t.Networks,
-		NodeID:      t.NodeID,
-		Retry:       retry,
-		Limits:      limits,
-		Timeout:     t.Timeout,
-		Result:      t.Result,
-		Var:         t.Var,
-		If:          t.If,
-		Parallel:    parallel,
-		Each:        each,
-		Description: t.Description,
-		SubJob:      subjob,
-		GPUs:        t.GPUs,
-		Tags:        t.Tags,
-		Workdir:     t.Workdir,
-		Priority:    t.Priority,
-		Progress:    t.Progress,
-		Ports:       ClonePorts(t.Ports),
+		ID:              t.ID,
+		JobID:           t.JobID,
+		ParentID:        t.ParentID,
+		Position:        t.Position,
+		Name:            t.Name,
+		State:           t.State,
+		CreatedAt:       t.CreatedAt,
+		ScheduledAt:     t.ScheduledAt,
+		StartedAt:       t.StartedAt,
+		CompletedAt:     t.CompletedAt,
+		FailedAt:        t.FailedAt,
+		RetryAt:         t.RetryAt,
+		CMD:             t.CMD,
+		Entrypoint:      t.Entrypoint,
+		User:            t.User,
+		Run:             t.Run,
+		Image:           t.Image,
+		ImageDigest:     t.ImageDigest,
+		Registry:        registry,
+		Env:             maps.Clone(t.Env),
+		Files:           maps.Clone(t.Files),
+		Queue:           t.Queue,
+		Error:           t.Error,
+		Pre:             CloneTasks(t.Pre),
+		Post:            CloneTasks(t.Post),
+		Mounts:          slices.Clone(t.Mounts),
+		Networks:        t.Networks,
+		NodeID:          t.NodeID,
+		Retry:           retry,
+		Limits:          limits,
+		Security:        security,
+		Timeout:         t.Timeout,
+		Result:          t.Result,
+		ResultTruncated: t.ResultTruncated,
+		ResultURL:       t.ResultURL,
+		ExitCode:        t.ExitCode,
+		Stats:           stats,
+		Var:             t.Var,
+		If:              t.If,
+		Parallel:        parallel,
+		Each:            each,
+		Description:     t.Description,
+		SubJob:          subjob,
+		GPUs:            t.GPUs,
+		ShmSize:         t.ShmSize,
+		Ulimits:         slices.Clone(t.Ulimits),
+		Devices:         slices.Clone(t.Devices),
+		Platform:        t.Platform,
+		Selector:        maps.Clone(t.Selector),
+		Tags:            t.Tags,
+		Workdir:         t.Workdir,
+		Priority:        t.Priority,
+		Progress:        t.Progress,
+		Ports:           ClonePorts(t.Ports),
+		Artifacts:       CloneArtifacts(t.Artifacts),
+		Downloads:       CloneArtifacts(t.Downloads),
+		Service:         service,
+		Trace:           maps.Clone(t.Trace),
 	}
 }
 
@@ -223,15 +330,36 @@ func CloneTasks(tasks []*Task) []*Task {
 
 func (r *TaskRetry) Clone() *TaskRetry {
 	return &TaskRetry{
-		Limit:    r.Limit,
-		Attempts: r.Attempts,
+		Limit:         r.Limit,
+		Attempts:      r.Attempts,
+		InitialDelay:  r.InitialDelay,
+		ScalingFactor: r.ScalingFactor,
+		MaxDelay:      r.MaxDelay,
 	}
 }
 
 func (l *TaskLimits) Clone() *TaskLimits {
 	return &TaskLimits{
-		CPUs:   l.CPUs,
-		Memory: l.Memory,
+		CPUs:      l.CPUs,
+		CPUShares: l.CPUShares,
+		Memory:    l.Memory,
+		Output:    l.Output,
+	}
+}
+
+func (s *TaskSecurity) Clone() *TaskSecurity {
+	return &TaskSecurity{
+		CapDrop:        slices.Clone(s.CapDrop),
+		SecurityOpt:    slices.Clone(s.SecurityOpt),
+		ReadOnlyRootfs: s.ReadOnlyRootfs,
+		Privileged:     s.Privileged,
+	}
+}
+
+func (s *TaskStats) Clone() *TaskStats {
+	return &TaskStats{
+		PeakCPUPercent: s.PeakCPUPercent,
+		PeakMemory:     s.PeakMemory,
 	}
 }
 
@@ -242,6 +370,7 @@ func (e *EachTask) Clone() *EachTask {
 		Task:        e.Task.Clone(),
 		Size:        e.Size,
 		Completions: e.Completions,
+		Concurrency: e.Concurrency,
 	}
 }
 
@@ -265,6 +394,19 @@ func (p *ParallelTask) Clone() *ParallelTask {
 	}
 }
 
+func (s *ServiceTask) Clone() *ServiceTask {
+	var probe *Probe
+	if s.Probe != nil {
+		probe = &Probe{
+			Port:    s.Probe.Port,
+			Path:    s.Probe.Path,
+			CMD:     slices.Clone(s.Probe.CMD),
+			Timeout: s.Probe.Timeout,
+		}
+	}
+	return &ServiceTask{Probe: probe}
+}
+
 func (r *Registry) Clone() *Registry {
 	return &Registry{
 		Username: r.Username,
@@ -274,21 +416,22 @@ func (r *Registry) Clone() *Registry {
 
 func NewTaskSummary(t *Task) *TaskSummary {
 	return &TaskSummary{
-		ID:          t.ID,
-		JobID:       t.JobID,
-		Position:    t.Position,
-		Progress:    t.Progress,
-		Name:        t.Name,
-		Description: t.Description,
-		State:       t.State,
-		CreatedAt:   t.CreatedAt,
-		ScheduledAt: t.ScheduledAt,
-		StartedAt:   t.StartedAt,
-		CompletedAt: t.CompletedAt,
-		Error:       t.Error,
-		Result:      t.Result,
-		Var:         t.Var,
-		Tags:        t.Tags,
+		ID:              t.ID,
+		JobID:           t.JobID,
+		Position:        t.Position,
+		Progress:        t.Progress,
+		Name:            t.Name,
+		Description:     t.Description,
+		State:           t.State,
+		CreatedAt:       t.CreatedAt,
+		ScheduledAt:     t.ScheduledAt,
+		StartedAt:       t.StartedAt,
+		CompletedAt:     t.CompletedAt,
+		Error:           t.Error,
+		Result:          t.Result,
+		ResultTruncated: t.ResultTruncated,
+		Var:             t.Var,
+		Tags:            t.Tags,
 	}
 }
 
@@ -307,6 +450,24 @@ func ClonePorts(ports []*Port) []*Port {
 	return copy
 }
 
+func (a *Artifact) Clone() *Artifact {
+	return &Artifact{
+		Path: a.Path,
+		URL:  a.URL,
+	}
+}
+
+func CloneArtifacts(artifacts []*Artifact) []*Artifact {
+	if artifacts == nil {
+		return nil
+	}
+	copy := make([]*Artifact, len(artifacts))
+	for i, a := range artifacts {
+		copy[i] = a.Clone()
+	}
+	return copy
+}
+
 func (t *Task) Port(name string) (*Port, bool) {
 	for _, p := range t.Ports {
 		if p.Port == name {